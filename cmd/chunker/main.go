@@ -5,6 +5,8 @@ import (
 	"flag"
 	"fmt"
 	"github.com/faanross/simulacra_txt/internal/chunker"
+	"github.com/faanross/simulacra_txt/internal/config"
+	"github.com/faanross/simulacra_txt/internal/progress"
 	"image"
 	"image/color"
 	"image/png"
@@ -22,17 +24,71 @@ func main() {
 	// Command line flags
 	inputFile := flag.String("input", "", "Input file to chunk (image or data)")
 	outputDir := flag.String("output", "chunks", "Output directory for chunk files")
-	encoding := flag.String("encoding", "base32", "Encoding type (hex or base32)")
+	archive := flag.String("archive", "", "Single-file chunk set archive (written instead of -output; read by -reassemble)")
+	encoding := flag.String("encoding", "base32", "Encoding type ("+strings.Join(chunker.EncodingNames(), ", ")+")")
 	simulate := flag.Bool("simulate", false, "Simulate DNS records")
 	reassemble := flag.Bool("reassemble", false, "Reassemble chunks from directory")
 	verbose := flag.Bool("verbose", false, "Show detailed output")
-
+	fec := flag.Float64("fec", 0, "FEC redundancy factor (e.g. 0.25 = 25% parity chunks)")
+	fecScheme := flag.String("fec-scheme", chunker.FEC_REED_SOLOMON, "FEC scheme ("+strings.Join(chunker.FECSchemeNames(), ", ")+"; fountain allows -fec up to 4)")
+	compress := flag.String("compress", "", "Compress before chunking ("+strings.Join(chunker.CompressionNames()[1:], ", ")+")")
+	integrity := flag.String("integrity", chunker.DEFAULT_INTEGRITY, "Chunk checksum ("+strings.Join(chunker.IntegrityNames(), ", ")+")")
+	password := flag.String("password", "", "Encrypt/decrypt messages with an AES-256-GCM envelope (PBKDF2 key)")
+	keyHex := flag.String("key", "", "Encrypt/decrypt with a raw AES-256 key (64 hex chars)")
+	txtStrings := flag.Int("strings", 1, "TXT strings per chunk record (1-16, >1 needs EDNS0)")
+	profile := flag.String("profile", "", "Sizing profile ("+strings.Join(chunker.ProfileNames(), ", ")+"; overrides -strings)")
+	authKey := flag.String("auth-key", "", "Shared secret for per-chunk HMAC-SHA256 tags")
+	requireAuth := flag.Bool("require-auth", false, "Reject chunks without a valid authentication tag")
+	shuffle := flag.Bool("shuffle", false, "Emit chunks in a keyed pseudo-random order")
+	workers := flag.Int("workers", 1, "Goroutines encoding chunks (-1 = one per CPU)")
+	estimate := flag.Int("estimate", 0, "Only estimate the transfer for this many bytes (no input needed)")
+	qps := flag.Float64("qps", 10, "Queries per second assumed by -estimate")
+	progressMode := flag.String("progress", string(progress.MODE_BAR), "Progress reporting ("+strings.Join(progress.ModeNames(), ", ")+"; json writes events to stderr)")
+
+	flag.String(config.FLAG_NAME, "", config.USAGE)
 	flag.Parse()
+	if _, err := config.Apply(flag.CommandLine, "chunker"); err != nil {
+		fmt.Printf("❌ Invalid configuration: %v\n", err)
+		return
+	}
 
 	fmt.Println("🧩 DNS CHUNKING SYSTEM DEMONSTRATION")
 
+	reporter, err := progress.New(*progressMode)
+	if err != nil {
+		fmt.Printf("❌ %v\n", err)
+		return
+	}
+
+	var key []byte
+	if *keyHex != "" {
+		var err error
+		key, err = hex.DecodeString(*keyHex)
+		if err != nil {
+			fmt.Printf("❌ Invalid key: %v\n", err)
+			return
+		}
+	}
+
 	if *reassemble {
-		demonstrateReassembly(*outputDir, *verbose)
+		demonstrateReassembly(*outputDir, *archive, *verbose, *password, key, []byte(*authKey), *requireAuth, reporter)
+		return
+	}
+
+	if *estimate > 0 {
+		demonstrateEstimate(*estimate, *qps, chunker.ChunkerConfig{
+			Encoding:             *encoding,
+			AddRedundancy:        *fec > 0,
+			Redundancy:           *fec,
+			FECScheme:            *fecScheme,
+			Integrity:            *integrity,
+			CompressionAlgorithm: *compress,
+			Password:             *password,
+			Key:                  key,
+			Profile:              *profile,
+			StringsPerRecord:     *txtStrings,
+			AuthKey:              []byte(*authKey),
+		})
 		return
 	}
 
@@ -53,24 +109,54 @@ func main() {
 	fmt.Printf("📊 File size: %d bytes\n", len(data))
 
 	// Demonstrate chunking
-	demonstrateChunking(data, *encoding, *outputDir, *simulate, *verbose)
+	demonstrateChunking(data, *inputFile, *encoding, *outputDir, *archive, *simulate, *verbose, *fec, *fecScheme, *integrity, *compress, *password, key, *profile, *txtStrings, []byte(*authKey), *shuffle, *workers, reporter)
+}
+
+// demonstrateEstimate plans a transfer without chunking anything
+func demonstrateEstimate(size int, qps float64, config chunker.ChunkerConfig) {
+	fmt.Println("\n📐 TRANSFER ESTIMATE (incompressible data)")
+
+	estimate, err := chunker.EstimateChunks(size, config)
+	if err != nil {
+		fmt.Printf("❌ Estimate failed: %v\n", err)
+		return
+	}
+
+	fmt.Println(estimate)
+	fmt.Printf("   Transfer time at %.1f QPS: %v\n", qps, estimate.TransferTime(qps).Round(time.Second))
 }
 
-func demonstrateChunking(data []byte, encoding, outputDir string, simulate, verbose bool) {
+func demonstrateChunking(data []byte, filename, encoding, outputDir, archive string, simulate, verbose bool, redundancy float64, fecScheme, integrity, compression, password string, key []byte, profile string, txtStrings int, authKey []byte, shuffle bool, workers int, reporter progress.Reporter) {
 
 	fmt.Println("STEP 1: CHUNKING ANALYSIS")
 
 	// Create chunker with configuration
 	config := chunker.ChunkerConfig{
-		Encoding:      encoding,
-		DNSNamePrefix: "covert.example.com",
+		Encoding:             encoding,
+		DNSNamePrefix:        "covert.example.com",
+		AddRedundancy:        redundancy > 0,
+		Redundancy:           redundancy,
+		FECScheme:            fecScheme,
+		Integrity:            integrity,
+		Compression:          compression != "" && compression != "none",
+		CompressionAlgorithm: compression,
+		Password:             password,
+		Key:                  key,
+		Profile:              profile,
+		StringsPerRecord:     txtStrings,
+		AuthKey:              authKey,
+		Shuffle:              shuffle,
+		Workers:              workers,
+		Logger:               progress.ChunkerLogger(reporter, progress.STAGE_CHUNK, filename),
 	}
 
 	chk := chunker.NewChunker(config)
 
 	// Perform chunking
 	startTime := time.Now()
-	msg, err := chk.ChunkMessage(data)
+	task := progress.Begin(reporter, progress.STAGE_CHUNK, filename, 0)
+	msg, err := chk.ChunkFile(data, filename, "")
+	task.Finish(err)
 	if err != nil {
 		fmt.Printf("❌ Chunking failed: %v\n", err)
 		return
@@ -80,6 +166,13 @@ func demonstrateChunking(data []byte, encoding, outputDir string, simulate, verb
 	// Display statistics
 	fmt.Printf("\n📈 Chunking Statistics:\n")
 	fmt.Printf("   Encoding method: %s\n", strings.ToUpper(encoding))
+	fmt.Printf("   Integrity: %s\n", msg.Chunks[0].Metadata.Integrity)
+	if msg.Chunks[0].Metadata.Encrypted {
+		fmt.Printf("   Encryption: %s\n", msg.Metadata["encryption"])
+	}
+	if msg.Info != nil {
+		fmt.Printf("   Content: %s\n", msg.Info)
+	}
 	fmt.Printf("   Chunks created: %d\n", len(msg.Chunks))
 	fmt.Printf("   Processing time: %v\n", chunkTime)
 	fmt.Printf("   Message ID: %s\n", hex.EncodeToString(msg.ID[:8]))
@@ -138,8 +231,24 @@ func demonstrateChunking(data []byte, encoding, outputDir string, simulate, verb
 		}
 	}
 
-	// Save chunks to files
-	if outputDir != "" {
+	// Save chunks to a single archive, or to one file per chunk
+	if archive != "" {
+		fmt.Println("STEP 3: SAVING CHUNK SET")
+
+		manifest := &chunker.DNSManifest{
+			MessageID:   hex.EncodeToString(msg.ID[:8]),
+			TotalChunks: len(msg.Chunks),
+			Checksum:    chunker.MessageDigest(msg.Data),
+			Timestamp:   msg.CreatedAt,
+		}
+		err = chunker.NewChunkSet(msg, manifest.Value()).Save(archive)
+		if err != nil {
+			fmt.Printf("❌ Error saving archive: %v\n", err)
+			return
+		}
+
+		fmt.Printf("✅ Saved %d chunks to archive: %s\n", len(msg.Chunks), archive)
+	} else if outputDir != "" {
 		fmt.Println("STEP 3: SAVING CHUNKS")
 
 		err = saveChunks(msg, outputDir)
@@ -194,7 +303,7 @@ func demonstrateChunking(data []byte, encoding, outputDir string, simulate, verb
 	// Educational summary
 	fmt.Println("\n📚 KEY LESSONS LEARNED:")
 	fmt.Printf("1. Your %d-byte file required %d DNS TXT records\n", len(data), len(msg.Chunks))
-	fmt.Printf("2. Each chunk carries %d bytes of metadata overhead\n", chunker.METADATA_OVERHEAD)
+	fmt.Printf("2. Each chunk carries %d bytes of metadata overhead\n", chunker.METADATA_OVERHEAD_V2)
 	fmt.Printf("3. %s encoding resulted in %.1fx expansion\n",
 		strings.ToUpper(encoding), float64(totalEncoded)/float64(len(data)))
 	fmt.Println("4. Chunks are self-contained and can arrive out of order")
@@ -242,7 +351,7 @@ func saveChunks(msg *chunker.Message, outputDir string) error {
 		fmt.Fprintf(file, "; Message ID: %s\n", hex.EncodeToString(chunk.Metadata.MessageID[:8]))
 		fmt.Fprintf(file, "; Checksum: %08x\n", chunk.Metadata.Checksum)
 		fmt.Fprintf(file, "\n")
-		fmt.Fprintf(file, "%s. 300 IN TXT \"%s\"\n", chunk.RecordName, chunk.Encoded)
+		fmt.Fprintf(file, "%s. 300 IN TXT %s\n", chunk.RecordName, chunker.FormatTXT(chunk.Encoded))
 
 		file.Close()
 
@@ -288,24 +397,78 @@ func simulateDNSRecords(msg *chunker.Message) {
 	fmt.Printf("   nslookup -type=TXT %s your-dns-server\n", msg.Chunks[0].RecordName)
 }
 
-func demonstrateReassembly(dir string, verbose bool) {
+func demonstrateReassembly(dir, archive string, verbose bool, password string, key, authKey []byte, requireAuth bool, reporter progress.Reporter) {
 	fmt.Println("\n🔄 REASSEMBLY MODE")
 	fmt.Println(strings.Repeat("-", 60))
 
+	// Create chunker for decoding
+	chk := chunker.NewChunker(chunker.ChunkerConfig{
+		Encoding:    chunker.ENCODE_BASE32,
+		Password:    password,
+		Key:         key,
+		AuthKey:     authKey,
+		RequireAuth: requireAuth,
+		Logger:      progress.ChunkerLogger(reporter, progress.STAGE_REASSEMBLE, ""),
+	})
+
+	var chunks []chunker.Chunk
+	if archive != "" {
+		set, err := chunker.LoadChunkSet(archive, chk)
+		if err != nil {
+			fmt.Printf("❌ Error loading archive: %v\n", err)
+			return
+		}
+		chunks = set.Message.Chunks
+		fmt.Printf("\n📦 Loaded %d chunks from archive %s\n", len(chunks), archive)
+	} else {
+		chunks = loadChunkDir(chk, dir, verbose)
+		fmt.Printf("\n📦 Loaded %d chunks from %s/\n", len(chunks), dir)
+	}
+
+	if len(chunks) == 0 {
+		fmt.Println("❌ No valid chunks found")
+		return
+	}
+
+	// Attempt reassembly
+	fmt.Println("\n🔧 Attempting reassembly...")
+
+	reassembled, info, err := chk.ReassembleFile(chunks)
+	if err != nil {
+		fmt.Printf("❌ Reassembly failed: %v\n", err)
+		return
+	}
+
+	fmt.Printf("✅ Successfully reassembled %d bytes!\n", len(reassembled))
+
+	// Save reassembled file, under its original name when the sender gave one
+	outputFile := "reassembled_image.png"
+	if info != nil {
+		fmt.Printf("   Content: %s (SHA-256 verified)\n", info)
+		if name := info.SafeFilename(); name != "" {
+			outputFile = "reassembled_" + name
+		}
+	}
+	err = os.WriteFile(outputFile, reassembled, 0644)
+	if err != nil {
+		fmt.Printf("❌ Error saving file: %v\n", err)
+		return
+	}
+
+	fmt.Printf("💾 Saved reassembled file: %s\n", outputFile)
+	fmt.Println("\n🎉 Reassembly complete! You can now decode this image to extract the message.")
+}
+
+// loadChunkDir decodes the per-chunk zone files written by saveChunks
+func loadChunkDir(chk *chunker.Chunker, dir string, verbose bool) []chunker.Chunk {
 	// Read chunks from directory
 	entries, err := os.ReadDir(dir)
 	if err != nil {
 		fmt.Printf("❌ Error reading directory: %v\n", err)
-		return
+		return nil
 	}
 
-	// Create chunker for decoding
-	chk := chunker.NewChunker(chunker.ChunkerConfig{
-		Encoding: chunker.ENCODE_BASE32,
-	})
-
 	var chunks []chunker.Chunk
-
 	for _, entry := range entries {
 		if strings.HasPrefix(entry.Name(), "chunk_") && strings.HasSuffix(entry.Name(), ".txt") {
 			filepath := fmt.Sprintf("%s/%s", dir, entry.Name())
@@ -317,13 +480,9 @@ func demonstrateReassembly(dir string, verbose bool) {
 			// Parse DNS record format
 			lines := strings.Split(string(data), "\n")
 			for _, line := range lines {
-				if strings.Contains(line, "IN TXT") {
-					// Extract the quoted content
-					start := strings.Index(line, "\"")
-					end := strings.LastIndex(line, "\"")
-					if start >= 0 && end > start {
-						encoded := line[start+1 : end]
-
+				if _, rdata, found := strings.Cut(line, "IN TXT"); found {
+					// Extract the quoted content (one or more strings)
+					if encoded, err := chunker.ParseTXT(rdata); err == nil {
 						// Decode chunk
 						chunk, err := chk.DecodeChunk(encoded)
 						if err != nil {
@@ -343,34 +502,7 @@ func demonstrateReassembly(dir string, verbose bool) {
 		}
 	}
 
-	fmt.Printf("\n📦 Loaded %d chunks from %s/\n", len(chunks), dir)
-
-	if len(chunks) == 0 {
-		fmt.Println("❌ No valid chunks found")
-		return
-	}
-
-	// Attempt reassembly
-	fmt.Println("\n🔧 Attempting reassembly...")
-
-	reassembled, err := chk.ReassembleMessage(chunks)
-	if err != nil {
-		fmt.Printf("❌ Reassembly failed: %v\n", err)
-		return
-	}
-
-	fmt.Printf("✅ Successfully reassembled %d bytes!\n", len(reassembled))
-
-	// Save reassembled file
-	outputFile := "reassembled_image.png"
-	err = os.WriteFile(outputFile, reassembled, 0644)
-	if err != nil {
-		fmt.Printf("❌ Error saving file: %v\n", err)
-		return
-	}
-
-	fmt.Printf("💾 Saved reassembled image: %s\n", outputFile)
-	fmt.Println("\n🎉 Reassembly complete! You can now decode this image to extract the message.")
+	return chunks
 }
 
 func createDemoStegoImage() string {
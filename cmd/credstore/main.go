@@ -0,0 +1,114 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/faanross/simulacra_txt/internal/config"
+	"github.com/faanross/simulacra_txt/internal/credstore"
+	"golang.org/x/term"
+	"io"
+	"log"
+	"os"
+	"strings"
+)
+
+// ================================================================================
+// CREDENTIAL STORE MANAGER - Adds, reads and removes client credentials
+// ================================================================================
+
+// MAX_VALUE_SIZE caps a credential value read from stdin
+const MAX_VALUE_SIZE = 64 * 1024
+
+func main() {
+	file := flag.String("file", "", "Credential file (default: state dir)")
+	set := flag.String("set", "", "Store a credential under this name; the value is prompted for, or read from stdin when piped (then unlock with "+credstore.PASSWORD_ENV+")")
+	get := flag.String("get", "", "Print a credential value")
+	del := flag.String("delete", "", "Remove a credential")
+	list := flag.Bool("list", false, "List stored credential names")
+	flag.String(config.FLAG_NAME, "", config.USAGE)
+	flag.Parse()
+	if _, err := config.Apply(flag.CommandLine, "credstore"); err != nil {
+		log.Fatalf("❌ Invalid configuration: %v", err)
+	}
+
+	if *set == "" && *get == "" && *del == "" && !*list {
+		fmt.Println("Usage: credstore [-set name | -get name | -delete name | -list]")
+		flag.PrintDefaults()
+		return
+	}
+
+	// Values on the command line end up in shell history and ps output
+	if strings.Contains(*set, "=") {
+		log.Fatal("❌ -set takes only the name: the value is prompted for, or read from stdin")
+	}
+
+	store, err := credstore.Unlock(*file)
+	if err != nil {
+		log.Fatalf("❌ %v", err)
+	}
+
+	switch {
+	case *set != "":
+		value, err := readValue(*set)
+		if err != nil {
+			log.Fatalf("❌ %v", err)
+		}
+		store.Set(*set, value)
+		if err := store.Save(); err != nil {
+			log.Fatalf("❌ Save failed: %v", err)
+		}
+		fmt.Printf("✅ Stored credential: %s\n", *set)
+
+	case *get != "":
+		value, ok := store.Get(*get)
+		if !ok {
+			log.Fatalf("❌ Credential not found: %s", *get)
+		}
+		fmt.Println(value)
+
+	case *del != "":
+		if !store.Delete(*del) {
+			log.Fatalf("❌ Credential not found: %s", *del)
+		}
+		if err := store.Save(); err != nil {
+			log.Fatalf("❌ Save failed: %v", err)
+		}
+		fmt.Printf("🗑️  Removed credential: %s\n", *del)
+
+	case *list:
+		names := store.Names()
+		fmt.Printf("🔐 %d stored credentials:\n", len(names))
+		for _, name := range names {
+			fmt.Printf("   %s\n", name)
+		}
+	}
+}
+
+// readValue takes a credential value from the terminal without echoing it,
+// or from stdin when it isn't a terminal (one trailing newline dropped)
+func readValue(name string) (string, error) {
+	var value []byte
+	if term.IsTerminal(int(os.Stdin.Fd())) {
+		fmt.Printf("🔑 Value for %s: ", name)
+		var err error
+		value, err = term.ReadPassword(int(os.Stdin.Fd()))
+		fmt.Println()
+		if err != nil {
+			return "", fmt.Errorf("value read failed: %w", err)
+		}
+	} else {
+		raw, err := io.ReadAll(io.LimitReader(os.Stdin, MAX_VALUE_SIZE+1))
+		if err != nil {
+			return "", fmt.Errorf("value read failed: %w", err)
+		}
+		if len(raw) > MAX_VALUE_SIZE {
+			return "", fmt.Errorf("value on stdin exceeds %d bytes", MAX_VALUE_SIZE)
+		}
+		value = []byte(strings.TrimSuffix(strings.TrimSuffix(string(raw), "\n"), "\r"))
+	}
+
+	if len(value) == 0 {
+		return "", fmt.Errorf("empty value for %s", name)
+	}
+	return string(value), nil
+}
@@ -3,7 +3,9 @@ package main
 import (
 	"flag"
 	"fmt"
+	"github.com/faanross/simulacra_txt/internal/config"
 	"github.com/faanross/simulacra_txt/internal/decoder"
+	"github.com/faanross/simulacra_txt/internal/kdf"
 	"github.com/faanross/simulacra_txt/internal/scrypto"
 	"image"
 	_ "image/png"
@@ -20,14 +22,26 @@ func main() {
 	analyze := flag.Bool("analyze", false, "Perform security analysis only")
 	tryList := flag.String("trylist", "", "Comma-separated passwords to try")
 	verbose := flag.Bool("verbose", false, "Show full extracted message")
+	noPreview := flag.Bool("no-preview", false, "Report success without displaying decrypted content")
+	maxIters := flag.Uint("kdf-max-iters", kdf.DEFAULT_MAX_ITERATIONS, "Refuse payloads whose KDF asks for more iterations/passes")
+	maxMemory := flag.Uint("kdf-max-memory", kdf.DEFAULT_MAX_MEMORY/1024, "Refuse payloads whose KDF asks for more memory (MiB)")
 
+	flag.String(config.FLAG_NAME, "", config.USAGE)
 	flag.Parse()
+	if _, err := config.Apply(flag.CommandLine, "decoder"); err != nil {
+		log.Fatalf("❌ Invalid configuration: %v", err)
+	}
 
 	// Validate input
 	if *inputFile == "" {
 		log.Fatal("❌ Please provide input image with -input flag")
 	}
 
+	limits := kdf.Limits{
+		MaxIterations: uint32(*maxIters),
+		MaxMemory:     uint32(*maxMemory) * 1024,
+	}
+
 	fmt.Println("\n🔓 Secure Steganography Decoder")
 	fmt.Println("=" + strings.Repeat("=", 40))
 
@@ -61,7 +75,13 @@ func main() {
 	// Try multiple passwords mode
 	if *tryList != "" {
 		passwords := strings.Split(*tryList, ",")
-		scrypto.TryMultiplePasswords(img, passwords)
+		_, result := scrypto.TryMultiplePasswords(img, passwords, !*noPreview, limits)
+		if result != nil && *outputFile != "" {
+			if err := os.WriteFile(*outputFile, result.Message, 0600); err != nil {
+				log.Fatalf("❌ Error saving output: %v", err)
+			}
+			fmt.Printf("\n💾 Message saved to: %s\n", *outputFile)
+		}
 		return
 	}
 
@@ -78,6 +98,7 @@ func main() {
 
 	// Create decoder
 	stegDecoder := decoder.NewSecureStegoDecoder(img, pass)
+	stegDecoder.SetKDFLimits(limits)
 
 	// Extract bit stream
 	stegDecoder.ExtractBitStream()
@@ -105,12 +126,32 @@ func main() {
 	fmt.Printf("   Authentication: %v\n", result.Authenticated)
 
 	// Display message
+	if *noPreview {
+		fmt.Printf("\n🙈 Content not displayed (-no-preview)\n")
+	} else {
+		displayMessage(result.Message, *verbose)
+	}
+
+	// Save to file if requested
+	if *outputFile != "" {
+		err = os.WriteFile(*outputFile, result.Message, 0644)
+		if err != nil {
+			log.Fatalf("❌ Error saving output: %v", err)
+		}
+		fmt.Printf("\n💾 Message saved to: %s\n", *outputFile)
+	}
+
+	fmt.Println("\n✅ Secure decoding complete!")
+}
+
+// displayMessage prints the decrypted message, abbreviated unless verbose
+func displayMessage(data []byte, verbose bool) {
 	fmt.Println("\n" + strings.Repeat("=", 60))
 	fmt.Println("📝 DECRYPTED MESSAGE:")
 	fmt.Println(strings.Repeat("=", 60))
 
-	message := string(result.Message)
-	if *verbose || len(message) <= 500 {
+	message := string(data)
+	if verbose || len(message) <= 500 {
 		fmt.Println(message)
 	} else {
 		// Show preview for long messages
@@ -122,15 +163,4 @@ func main() {
 	}
 
 	fmt.Println(strings.Repeat("=", 60))
-
-	// Save to file if requested
-	if *outputFile != "" {
-		err = os.WriteFile(*outputFile, result.Message, 0644)
-		if err != nil {
-			log.Fatalf("❌ Error saving output: %v", err)
-		}
-		fmt.Printf("\n💾 Message saved to: %s\n", *outputFile)
-	}
-
-	fmt.Println("\n✅ Secure decoding complete!")
 }
@@ -1,17 +1,56 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"flag"
 	"fmt"
 	"github.com/faanross/simulacra_txt/internal/chunker"
+	"github.com/faanross/simulacra_txt/internal/config"
+	"github.com/faanross/simulacra_txt/internal/publisher"
 	"os"
+	"os/signal"
+	"strings"
 )
 
 func main() {
 	input := flag.String("input", "", "Input image file")
 	domain := flag.String("domain", "covert.example.com", "DNS domain")
 	output := flag.String("output", "zone.txt", "Output zone file")
+	txtStrings := flag.Int("strings", 1, "TXT strings per chunk record (1-16, >1 needs EDNS0)")
+	profile := flag.String("profile", "", "Sizing profile ("+strings.Join(chunker.ProfileNames(), ", ")+"; overrides -strings)")
+	shuffle := flag.Bool("shuffle", false, "Emit chunk records in a keyed pseudo-random order")
+	record := flag.String("record", "txt", "Record type carrying chunks ("+strings.Join(chunker.RecordTypeNames(), ", ")+")")
+	manifestFormat := flag.String("manifest-format", chunker.MANIFEST_FORMAT_TEXT, "Manifest format ("+strings.Join(chunker.ManifestFormatNames(), ", ")+")")
+	names := flag.String("names", "", "Chunk naming template, e.g. {word}.{sub:cdn|img|static} (default: t<minutes>-c-{seq}-{id}.data)")
+	nameKey := flag.String("names-key", "", "Secret keying {word} and {sub:...} names; receivers need it to find the chunks")
+	wildcard := flag.Bool("wildcard", false, "Add a decoy TXT wildcard under each subdomain chunks are named in")
+	ttlSpec := flag.String("ttl", "", "Record TTLs, e.g. chunk=1h,manifest=5m,jitter=20% (default: 300s each, no jitter)")
+	publish := flag.String("publish", "", "Also push the records to a DNS provider ("+strings.Join(publisher.BackendNames(), ", ")+")")
+	publishZone := flag.String("publish-zone", "", "Provider zone: Route53 hosted zone ID, Cloudflare zone ID or PowerDNS zone name (PowerDNS default: -domain)")
+	publishEndpoint := flag.String("publish-endpoint", "", "Provider API URL (required for PowerDNS, e.g. http://127.0.0.1:8081)")
+	publishToken := flag.String("publish-token", "", "Cloudflare API token or PowerDNS API key (default: CLOUDFLARE_API_TOKEN / PDNS_API_KEY; Route53 reads the AWS_* variables)")
+	flag.String(config.FLAG_NAME, "", config.USAGE)
 	flag.Parse()
+	if _, err := config.Apply(flag.CommandLine, "dns-encoder"); err != nil {
+		fmt.Printf("❌ Invalid configuration: %v\n", err)
+		return
+	}
+
+	recordType, err := chunker.ParseRecordType(*record)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	ttl, err := chunker.ParseTTLPolicy(*ttlSpec)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	if recordType == chunker.RECORD_CNAME && *profile == "" {
+		// CNAME targets are names, chunks must fit in one
+		*profile = chunker.PROFILE_QNAME_MULTI
+	}
 
 	if *input == "" {
 		fmt.Println("Usage: dns-encoder-demo -input <image.png>")
@@ -28,7 +67,10 @@ func main() {
 
 	// Chunk it
 	chk := chunker.NewChunker(chunker.ChunkerConfig{
-		Encoding: chunker.ENCODE_BASE32,
+		Encoding:         chunker.ENCODE_BASE32,
+		Profile:          *profile,
+		StringsPerRecord: *txtStrings,
+		Shuffle:          *shuffle,
 	})
 	msg, err := chk.ChunkMessage(data)
 	if err != nil {
@@ -38,14 +80,28 @@ func main() {
 	fmt.Printf("🧩 Chunks: %d\n", len(msg.Chunks))
 
 	// Encode for DNS
-	encoder := chunker.NewDNSEncoder(*domain)
+	encoder, err := chunker.NewDNSEncoderWithConfig(*domain, chunker.DNSEncoderConfig{
+		RecordType:     recordType,
+		TTL:            ttl,
+		ManifestFormat: *manifestFormat,
+		NameTemplate:   *names,
+		NameKey:        []byte(*nameKey),
+		Wildcard:       *wildcard,
+	})
+	if err != nil {
+		panic(err)
+	}
 	manifest, records, err := encoder.EncodeToDNS(msg)
 	if err != nil {
 		panic(err)
 	}
 
+	// Advertise how the chunks were built so receivers can auto-configure
+	records = append(records, encoder.CapabilitiesRecord(chk.Capabilities()))
+
 	fmt.Printf("🌐 DNS Records: %d\n", len(records))
 	fmt.Printf("📋 Message ID: %s\n", manifest.MessageID)
+	fmt.Printf("⏳ TTLs: %s\n", ttl)
 
 	// Show example records
 	fmt.Println("\nExample DNS records:")
@@ -55,17 +111,64 @@ func main() {
 		if len(value) > 50 {
 			value = value[:50] + "..."
 		}
-		fmt.Printf("  %s TXT \"%s\"\n", r.Name, value)
+		fmt.Printf("  %s %s \"%s\"\n", r.Name, r.Type, value)
 	}
 
 	// Generate zone file
 	zoneFile := encoder.GenerateZoneFile(records)
+
+	// Check the zone file decodes back to the input, whatever carried the chunks
+	zoneRecords, err := chunker.ParseZone(strings.NewReader(zoneFile), *domain)
+	if err != nil {
+		panic(err)
+	}
+	parsed, parsedManifest, err := encoder.ParseFromDNS(zoneRecords)
+	if err != nil {
+		panic(err)
+	}
+	restored, err := chunker.NewChunker(chunker.ChunkerConfig{}).ReassembleMessage(parsed)
+	if err == nil && parsedManifest != nil {
+		err = parsedManifest.VerifyData(restored)
+	}
+	if err != nil || !bytes.Equal(restored, data) {
+		fmt.Printf("❌ %s zone file doesn't parse back to the input (%v)\n", recordType, err)
+		return
+	}
+	fmt.Printf("\n✅ %s zone file parses back to the input\n", recordType)
 	err = os.WriteFile(*output, []byte(zoneFile), 0644)
 	if err != nil {
 		panic(err)
 	}
 
 	fmt.Printf("\n✅ Zone file saved to: %s\n", *output)
+
+	if *publish != "" {
+		zone := *publishZone
+		if zone == "" && *publish == publisher.BACKEND_POWERDNS {
+			zone = *domain
+		}
+		pub, err := publisher.New(*publish, publisher.Config{
+			Zone:     zone,
+			Token:    *publishToken,
+			Endpoint: *publishEndpoint,
+		})
+		if err != nil {
+			fmt.Printf("❌ %v\n", err)
+			return
+		}
+
+		// Ctrl+C abandons the API requests still to go
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+		defer stop()
+
+		fmt.Printf("\n☁️  Publishing %d records to %s...\n", len(records), pub.Name())
+		if err := pub.Publish(ctx, records); err != nil {
+			fmt.Printf("❌ Publishing failed: %v\n", err)
+			return
+		}
+		fmt.Printf("✅ Published to %s (manifest last)\n", pub.Name())
+		return
+	}
 	fmt.Println("\nNext steps:")
 	fmt.Println("1. Upload zone file to DNS server")
 	fmt.Println("2. Query DNS server from receiver")
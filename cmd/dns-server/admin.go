@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/faanross/simulacra_txt/internal/chunker"
+	dnsserver "github.com/faanross/simulacra_txt/internal/dns-server"
+	"github.com/miekg/dns"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ================================================================================
+// ADMIN API
+// Message lifecycle over HTTP: list, inspect, delete, expire, requeue, export, verify
+// ================================================================================
+
+// handleAdminList lists message summaries, filtered by ?state=new,delivered
+// and ?tags=a,b
+func (s *DNSServerV2) handleAdminList(w http.ResponseWriter, r *http.Request) {
+	states, err := dnsserver.ParseStates(r.URL.Query().Get("state"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	tags, err := dnsserver.ParseTags(r.URL.Query().Get("tags"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	summaries, err := s.queue.ListSummaries(states, tags)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"zone":     s.domain,
+		"count":    len(summaries),
+		"messages": summaries,
+	})
+}
+
+// handleAdminMessage reports one message: its summary, chunk coverage and
+// every consumer record
+func (s *DNSServerV2) handleAdminMessage(w http.ResponseWriter, r *http.Request) {
+	message, ok := s.adminMessage(w, r)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"summary":   dnsserver.Summarize(message),
+		"coverage":  dnsserver.Coverage(message),
+		"consumers": message.Consumers,
+		"manifest":  message.Manifest != "",
+	})
+}
+
+// handleAdminDelete removes a message now
+func (s *DNSServerV2) handleAdminDelete(w http.ResponseWriter, r *http.Request) {
+	if _, ok := s.adminMessage(w, r); !ok {
+		return
+	}
+	s.adminAction(w, r, "deleted", s.queue.Delete)
+}
+
+// handleAdminExpire stops a message from being served, leaving it to the GC
+func (s *DNSServerV2) handleAdminExpire(w http.ResponseWriter, r *http.Request) {
+	if _, ok := s.adminMessage(w, r); !ok {
+		return
+	}
+	s.adminAction(w, r, "expired", s.queue.Expire)
+}
+
+// handleAdminRequeue moves a message back to new
+func (s *DNSServerV2) handleAdminRequeue(w http.ResponseWriter, r *http.Request) {
+	if _, ok := s.adminMessage(w, r); !ok {
+		return
+	}
+	s.adminAction(w, r, "requeued", s.queue.Requeue)
+}
+
+// handleAdminExport returns a message's records as a zone file, which
+// -zone (or any authoritative server) can load
+func (s *DNSServerV2) handleAdminExport(w http.ResponseWriter, r *http.Request) {
+	message, ok := s.adminMessage(w, r)
+	if !ok {
+		return
+	}
+
+	var zone strings.Builder
+	fmt.Fprintf(&zone, "; Message %s (%s), exported %s\n", message.ID, message.State, time.Now().UTC().Format(time.RFC3339))
+	fmt.Fprintf(&zone, "$ORIGIN %s\n", dns.Fqdn(s.domain))
+	fmt.Fprintf(&zone, "%s\n", &dns.TXT{
+		Hdr: dns.RR_Header{
+			Name:   dns.Fqdn(chunker.CapabilitiesName(s.domain)),
+			Rrtype: dns.TypeTXT,
+			Class:  dns.ClassINET,
+			Ttl:    3600,
+		},
+		Txt: []string{s.caps.String()},
+	})
+	for _, rr := range s.messageRecords(message) {
+		fmt.Fprintf(&zone, "%s\n", rr)
+	}
+
+	w.Header().Set("Content-Type", "text/dns")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", message.ID+".zone"))
+	fmt.Fprint(w, zone.String())
+}
+
+// adminMessage loads the message named in the path, answering 404 if
+// there is none
+func (s *DNSServerV2) adminMessage(w http.ResponseWriter, r *http.Request) (*dnsserver.Message, bool) {
+	message, err := s.storage.GetMessage(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return nil, false
+	}
+	return message, true
+}
+
+// adminAction applies a lifecycle change to the message named in the path
+func (s *DNSServerV2) adminAction(w http.ResponseWriter, r *http.Request, status string, action func(string) error) {
+	msgID := r.PathValue("id")
+	if err := action(msgID); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	s.zoneChanged()
+
+	httpLog.Info("🛠️  Message "+status+" by admin", "zone", s.domain, "msg_id", msgID)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"status":     status,
+		"message_id": msgID,
+	})
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	dnsserver "github.com/faanross/simulacra_txt/internal/dns-server"
+	"github.com/miekg/dns"
+	"net"
+	"time"
+)
+
+// ================================================================================
+// ZONE AUTHORITY
+// SOA and NS answers, negative answers with the SOA, REFUSED outside the zone
+// ================================================================================
+
+// inZone reports whether the server answers for a name: the domain, or a
+// zone with decoys
+func (s *DNSServerV2) inZone(name string) bool {
+	return s.authority.Contains(name) || s.decoys.Load().Match(name) != nil
+}
+
+// answerAuthority answers SOA and NS queries at the apex and address
+// queries for the zone's own name servers, reporting whether it did
+func (s *DNSServerV2) answerAuthority(question dns.Question, msg *dns.Msg) bool {
+	switch question.Qtype {
+	case dns.TypeSOA:
+		if !s.authority.IsApex(question.Name) {
+			return false
+		}
+		msg.Answer = append(msg.Answer, s.soaRecord())
+	case dns.TypeNS:
+		if !s.authority.IsApex(question.Name) {
+			return false
+		}
+		msg.Answer = append(msg.Answer, s.nsRecords()...)
+		for _, server := range s.authority.NameServers {
+			msg.Extra = append(msg.Extra, s.glueRecords(server.Name, dns.TypeA)...)
+			msg.Extra = append(msg.Extra, s.glueRecords(server.Name, dns.TypeAAAA)...)
+		}
+	case dns.TypeA, dns.TypeAAAA:
+		if s.authority.Glue(question.Name) == nil {
+			return false
+		}
+		// A name server without addresses of this family has NODATA
+		msg.Answer = append(msg.Answer, s.glueRecords(question.Name, question.Qtype)...)
+	default:
+		return false
+	}
+	return true
+}
+
+// soaRecord returns the zone's SOA record
+func (s *DNSServerV2) soaRecord() *dns.SOA {
+	return authoritySOA(s.authority, s.authority.Serial+s.zoneVersion.Load())
+}
+
+// nsRecords returns the zone's NS records
+func (s *DNSServerV2) nsRecords() []dns.RR {
+	return authorityNS(s.authority)
+}
+
+// glueRecords returns a name server's A (or AAAA) records
+func (s *DNSServerV2) glueRecords(name string, qtype uint16) []dns.RR {
+	return authorityGlue(s.authority, name, qtype)
+}
+
+// authoritySOA returns a zone's SOA record with the given serial
+func authoritySOA(a *dnsserver.Authority, serial uint32) *dns.SOA {
+	return &dns.SOA{
+		Hdr: dns.RR_Header{
+			Name:   dns.Fqdn(a.Zone),
+			Rrtype: dns.TypeSOA,
+			Class:  dns.ClassINET,
+			Ttl:    uint32(a.TTL / time.Second),
+		},
+		Ns:      dns.Fqdn(a.Primary()),
+		Mbox:    dns.Fqdn(a.Mbox),
+		Serial:  serial,
+		Refresh: uint32(a.Refresh / time.Second),
+		Retry:   uint32(a.Retry / time.Second),
+		Expire:  uint32(a.Expire / time.Second),
+		Minttl:  uint32(a.Minimum / time.Second),
+	}
+}
+
+// authorityNS returns a zone's NS records
+func authorityNS(a *dnsserver.Authority) []dns.RR {
+	var records []dns.RR
+	for _, server := range a.NameServers {
+		records = append(records, &dns.NS{
+			Hdr: dns.RR_Header{
+				Name:   dns.Fqdn(a.Zone),
+				Rrtype: dns.TypeNS,
+				Class:  dns.ClassINET,
+				Ttl:    uint32(a.TTL / time.Second),
+			},
+			Ns: dns.Fqdn(server.Name),
+		})
+	}
+	return records
+}
+
+// authorityGlue returns the A (or AAAA) records of a name server in a zone
+func authorityGlue(a *dnsserver.Authority, name string, qtype uint16) []dns.RR {
+	var records []dns.RR
+	for _, addr := range a.Glue(name) {
+		header := dns.RR_Header{
+			Name:   dns.Fqdn(name),
+			Rrtype: qtype,
+			Class:  dns.ClassINET,
+			Ttl:    uint32(a.TTL / time.Second),
+		}
+		switch {
+		case qtype == dns.TypeA && addr.Is4():
+			records = append(records, &dns.A{Hdr: header, A: net.IP(addr.AsSlice())})
+		case qtype == dns.TypeAAAA && addr.Is6():
+			records = append(records, &dns.AAAA{Hdr: header, AAAA: net.IP(addr.AsSlice())})
+		}
+	}
+	return records
+}
+
+// addNegativeSOA puts the SOA in the authority section of NXDOMAIN and
+// NODATA answers for the zone, which is what lets resolvers cache them
+// (RFC 2308)
+func (s *DNSServerV2) addNegativeSOA(question dns.Question, msg *dns.Msg) {
+	if !s.authority.Contains(question.Name) {
+		return
+	}
+	negative := msg.Rcode == dns.RcodeNameError || (msg.Rcode == dns.RcodeSuccess && len(msg.Answer) == 0)
+	if !negative {
+		return
+	}
+
+	soa := s.soaRecord()
+	soa.Hdr.Ttl = s.authority.NegativeTTL()
+	msg.Ns = append(msg.Ns, soa)
+}
+
+// SetAuthority sets the zone's SOA and NS data from their specs (see
+// dnsserver.ParseAuthority)
+func (s *DNSServerV2) SetAuthority(nsSpec, soaSpec string) error {
+	authority, err := dnsserver.ParseAuthority(s.domain, nsSpec, soaSpec)
+	if err != nil {
+		return err
+	}
+	s.authority = authority
+	return nil
+}
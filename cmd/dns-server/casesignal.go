@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/faanross/simulacra_txt/internal/chunker"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ================================================================================
+// CASE SIGNALS
+// Reads signals clients write into the letter case of their queries
+// ================================================================================
+
+// LESSON: Normalize Late
+// Every lookup works on the lowercased name, so mixed-case queries find
+// the same records and the answer echoes the case they were asked in. The
+// case itself is read first, from the name exactly as it arrived - after
+// lowercasing there is nothing left to read.
+
+// MAX_CASE_SIGNALS is how many received signals /signals keeps
+const MAX_CASE_SIGNALS = 32
+
+// caseSignal is one signal received through query case
+type caseSignal struct {
+	Signal   string    `json:"signal"`
+	Received time.Time `json:"received"`
+}
+
+// caseSignals collects signals from query names
+type caseSignals struct {
+	reader   *chunker.CaseReader
+	received []caseSignal
+	mu       sync.Mutex
+}
+
+// readCaseSignal feeds a query name, as received, to the case reader
+func (s *DNSServerV2) readCaseSignal(name string) {
+	if s.signals == nil {
+		return
+	}
+	signal, ok := s.signals.reader.Read(name)
+	if !ok {
+		return
+	}
+
+	dnsLog.Info("📶 Case signal", "signal", signal)
+	s.signals.mu.Lock()
+	defer s.signals.mu.Unlock()
+	s.signals.received = append(s.signals.received, caseSignal{Signal: string(signal), Received: time.Now()})
+	if len(s.signals.received) > MAX_CASE_SIGNALS {
+		s.signals.received = s.signals.received[1:]
+	}
+}
+
+// handleSignals lists the case signals received, oldest first
+func (s *DNSServerV2) handleSignals(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.signals == nil {
+		http.Error(w, "Case signals are off (start with -case-key)", http.StatusNotFound)
+		return
+	}
+
+	s.signals.mu.Lock()
+	received := append([]caseSignal{}, s.signals.received...)
+	s.signals.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(received)
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/hex"
+	dnsserver "github.com/faanross/simulacra_txt/internal/dns-server"
+	"github.com/miekg/dns"
+	"net"
+	"net/netip"
+	"strings"
+)
+
+// ================================================================================
+// CLIENT IDENTIFICATION
+// Finds the client ID of a discovery query in its name, EDNS0 or source
+// ================================================================================
+
+// LESSON: First Hint Wins
+// The sources are tried in the order -client-id gives (see
+// internal/dns-server/clients.go for what each one survives). Generated
+// IDs carry their source as a prefix - "subnet-198.51.100.0/24",
+// "cookie-8f3a...", "ip-203.0.113.7" - so they can never collide with an
+// ID a receiver chose for itself.
+
+// identifyClient returns the client a discovery or chunk query comes from
+func (s *DNSServerV2) identifyClient(r *dns.Msg, qname string, source net.Addr) dnsserver.ClientInfo {
+	client := dnsserver.ClientInfo{Addr: remoteIP(source)}
+
+	for _, via := range s.clientSources {
+		if id, ok := s.clientHint(via, r, qname, client.Addr); ok {
+			client.ID, client.Via = id, via
+			return client
+		}
+	}
+
+	// Nothing identified the client: everyone unidentified shares one queue
+	client.ID = "client-default"
+	return client
+}
+
+// clientHint extracts an ID from one source
+func (s *DNSServerV2) clientHint(via string, r *dns.Msg, qname, addr string) (string, bool) {
+	if via == dnsserver.CLIENT_VIA_NAME {
+		return dnsserver.ConsumeClient(qname, s.domain)
+	}
+	if via == dnsserver.CLIENT_VIA_IP {
+		return "ip-" + addr, addr != ""
+	}
+
+	opt := r.IsEdns0()
+	if opt == nil {
+		return "", false
+	}
+	for _, option := range opt.Option {
+		switch o := option.(type) {
+		case *dns.EDNS0_LOCAL:
+			if via == dnsserver.CLIENT_VIA_EDNS && o.Code == dnsserver.CLIENT_ID_OPTION && dnsserver.ValidClientID(string(o.Data)) {
+				return string(o.Data), true
+			}
+		case *dns.EDNS0_SUBNET:
+			if via == dnsserver.CLIENT_VIA_SUBNET {
+				if ip, ok := netip.AddrFromSlice(o.Address); ok {
+					if prefix, err := ip.Unmap().Prefix(int(o.SourceNetmask)); err == nil {
+						return "subnet-" + prefix.String(), true
+					}
+				}
+			}
+		case *dns.EDNS0_COOKIE:
+			// The first 8 bytes (16 hex digits) are the client's own cookie
+			if via == dnsserver.CLIENT_VIA_COOKIE && len(o.Cookie) >= 16 {
+				if _, err := hex.DecodeString(o.Cookie[:16]); err == nil {
+					return "cookie-" + strings.ToLower(o.Cookie[:16]), true
+				}
+			}
+		}
+	}
+	return "", false
+}
+
+// remoteIP returns the IP of a UDP or TCP peer ("" if unknown)
+func remoteIP(addr net.Addr) string {
+	switch peer := addr.(type) {
+	case *net.UDPAddr:
+		return peer.IP.String()
+	case *net.TCPAddr:
+		return peer.IP.String()
+	}
+	return ""
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"crypto/rand"
+	"github.com/faanross/simulacra_txt/internal/chunker"
+	dnsserver "github.com/faanross/simulacra_txt/internal/dns-server"
+	"github.com/miekg/dns"
+	"net"
+	"strings"
+)
+
+// ================================================================================
+// DECOY RESPONSES
+// Answers names outside the protocol like an ordinary zone would
+// ================================================================================
+
+// answerUnknown answers a query for a name the server has no record of
+// with the decoys of its zone, reporting whether it did; callers give
+// their usual negative answer otherwise
+func (s *DNSServerV2) answerUnknown(question dns.Question, msg *dns.Msg) bool {
+	zone := s.decoys.Load().Match(question.Name)
+	if zone == nil {
+		return false
+	}
+
+	header := dns.RR_Header{
+		Name:   question.Name,
+		Rrtype: question.Qtype,
+		Class:  dns.ClassINET,
+		Ttl:    zone.TTL,
+	}
+	switch question.Qtype {
+	case dns.TypeTXT:
+		if !zone.TXT {
+			return false
+		}
+		value := zone.TXTValue(question.Name, s.decoyKey)
+		msg.Answer = append(msg.Answer, &dns.TXT{Hdr: header, Txt: chunker.SplitTXT(value)})
+	case dns.TypeA, dns.TypeAAAA:
+		addrs := zone.Addrs(question.Qtype == dns.TypeAAAA)
+		if len(addrs) == 0 {
+			return false
+		}
+		for _, addr := range addrs {
+			ip := net.IP(addr.AsSlice())
+			if question.Qtype == dns.TypeA {
+				msg.Answer = append(msg.Answer, &dns.A{Hdr: header, A: ip})
+			} else {
+				msg.Answer = append(msg.Answer, &dns.AAAA{Hdr: header, AAAA: ip})
+			}
+		}
+	default:
+		return false
+	}
+
+	msg.Rcode = dns.RcodeSuccess
+	dnsLog.Debug("Served decoy", "name", question.Name, "type", dns.TypeToString[question.Qtype], "zone", zone.Zone)
+	return true
+}
+
+// chunkShaped reports whether a name looks like one the protocol uses for
+// message data, which decoy views answer with a decoy chunk instead
+func chunkShaped(qname string) bool {
+	label, _, _ := strings.Cut(strings.ToLower(qname), ".")
+	return strings.HasPrefix(label, "c-") || strings.HasPrefix(label, "m-") || strings.Contains(qname, "consume.")
+}
+
+// LoadDecoys installs the decoy file at path and keeps it up to date
+func (s *DNSServerV2) LoadDecoys(path string) error {
+	decoys, err := dnsserver.LoadDecoys(path, s.domain)
+	if err != nil {
+		return err
+	}
+	s.initDecoyKey()
+	s.decoys.Store(decoys)
+
+	go watchFile(path, func() {
+		decoys, err := dnsserver.LoadDecoys(path, s.domain)
+		if err != nil {
+			dnsLog.Warn("⚠️  Decoys not reloaded, keeping the previous ones", "err", err)
+			return
+		}
+		s.decoys.Store(decoys)
+		dnsLog.Info("🎭 Decoys reloaded", "decoys", decoys.String())
+	})
+	return nil
+}
+
+// initDecoyKey draws the key decoy values are derived under, once
+func (s *DNSServerV2) initDecoyKey() {
+	if s.decoyKey == nil {
+		s.decoyKey = make([]byte, 32)
+		rand.Read(s.decoyKey)
+	}
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/faanross/simulacra_txt/internal/chunker"
+	dnsserver "github.com/faanross/simulacra_txt/internal/dns-server"
+	"github.com/faanross/simulacra_txt/internal/transport"
+	"github.com/miekg/dns"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ================================================================================
+// DELEGATION HELPER
+// Prints the parent zone's records and checks them through public resolvers
+// ================================================================================
+
+// DELEGATION_QUERY_TIMEOUT bounds each query of a delegation check
+const DELEGATION_QUERY_TIMEOUT = 5 * time.Second
+
+// delegationAuthorities returns the authority of -domain and of every
+// -zones zone, as the server would build them
+func delegationAuthorities(domain, nsSpec, soaSpec, zonesFile string) ([]*dnsserver.Authority, error) {
+	primary, err := dnsserver.ParseAuthority(domain, nsSpec, soaSpec)
+	if err != nil {
+		return nil, fmt.Errorf("invalid -ns or -soa: %w", err)
+	}
+	authorities := []*dnsserver.Authority{primary}
+	if zonesFile == "" {
+		return authorities, nil
+	}
+
+	configs, err := dnsserver.LoadZones(zonesFile, domain)
+	if err != nil {
+		return nil, fmt.Errorf("invalid -zones: %w", err)
+	}
+	for _, config := range configs {
+		authority, err := dnsserver.ParseAuthority(config.Domain, config.NS, config.SOA)
+		if err != nil {
+			return nil, fmt.Errorf("zone %s: %w", config.Domain, err)
+		}
+		authorities = append(authorities, authority)
+	}
+	return authorities, nil
+}
+
+// writeDelegation writes what the parent zone must publish for a zone, the
+// zone's own skeleton and anything that would keep the delegation from working
+func writeDelegation(w io.Writer, a *dnsserver.Authority) {
+	fmt.Fprintf(w, "; ==== Delegation of %s ====\n", a.Zone)
+	fmt.Fprintf(w, "; Add to the parent zone (%s) at its registrar or DNS host:\n", a.Parent())
+	for _, rr := range authorityNS(a) {
+		fmt.Fprintf(w, "%s\n", rr)
+	}
+	for _, server := range a.NameServers {
+		if !a.NeedsGlue(server) {
+			continue
+		}
+		for _, rr := range append(authorityGlue(a, server.Name, dns.TypeA), authorityGlue(a, server.Name, dns.TypeAAAA)...) {
+			fmt.Fprintf(w, "%s\n", rr)
+		}
+	}
+
+	fmt.Fprintf(w, ";\n; Served by this server at the apex (-ns, -soa):\n")
+	fmt.Fprintf(w, "$ORIGIN %s\n", dns.Fqdn(a.Zone))
+	fmt.Fprintf(w, "%s\n", authoritySOA(a, a.Serial))
+	for _, rr := range authorityNS(a) {
+		fmt.Fprintf(w, "%s\n", rr)
+	}
+	for _, server := range a.NameServers {
+		for _, rr := range append(authorityGlue(a, server.Name, dns.TypeA), authorityGlue(a, server.Name, dns.TypeAAAA)...) {
+			fmt.Fprintf(w, "%s\n", rr)
+		}
+	}
+
+	if problems := a.DelegationProblems(); len(problems) > 0 {
+		fmt.Fprintf(w, ";\n; ⚠️  Before registering:\n")
+		for _, problem := range problems {
+			fmt.Fprintf(w, ";   %s\n", problem)
+		}
+	}
+	fmt.Fprintln(w)
+}
+
+// checkDelegation queries each resolver for the zone's NS, glue, SOA and
+// capabilities record, printing every result; it reports whether all
+// passed. Cancelling ctx abandons the checks and fails them.
+func checkDelegation(ctx context.Context, a *dnsserver.Authority, resolvers []string) bool {
+	fmt.Printf("🔎 Checking delegation of %s through %d resolver(s)\n", a.Zone, len(resolvers))
+
+	passed := true
+	for _, resolver := range resolvers {
+		fmt.Printf("   %s\n", resolver)
+		checks := delegationChecks(ctx, a, resolver)
+		if ctx.Err() != nil {
+			fmt.Printf("🛑 Check of %s interrupted\n\n", a.Zone)
+			return false
+		}
+		for _, check := range checks {
+			mark := "✅"
+			if !check.ok {
+				mark = "❌"
+				passed = false
+			}
+			fmt.Printf("     %s %-4s %s\n", mark, check.kind, check.detail)
+		}
+	}
+
+	if passed {
+		fmt.Printf("✅ %s is delegated here and answers through every resolver\n\n", a.Zone)
+	} else {
+		fmt.Printf("❌ Delegation of %s is incomplete (changes at the parent can take its TTL to show)\n\n", a.Zone)
+	}
+	return passed
+}
+
+// delegationCheck is one result of a delegation check
+type delegationCheck struct {
+	kind   string // Record type checked
+	ok     bool
+	detail string
+}
+
+// delegationChecks runs every check of a zone against one resolver
+func delegationChecks(ctx context.Context, a *dnsserver.Authority, resolver string) []delegationCheck {
+	var checks []delegationCheck
+
+	// NS: the parent delegates to our name servers
+	want := make([]string, 0, len(a.NameServers))
+	for _, server := range a.NameServers {
+		want = append(want, dns.Fqdn(server.Name))
+	}
+	got, err := resolveNames(ctx, resolver, a.Zone, dns.TypeNS)
+	checks = append(checks, compareSets("NS", want, got, err))
+
+	// A/AAAA: the glue of name servers inside the zone
+	for _, server := range a.NameServers {
+		if !a.NeedsGlue(server) || len(server.Addrs) == 0 {
+			continue
+		}
+		for _, qtype := range []uint16{dns.TypeA, dns.TypeAAAA} {
+			var want []string
+			for _, rr := range authorityGlue(a, server.Name, qtype) {
+				want = append(want, rrValue(rr))
+			}
+			if len(want) == 0 {
+				continue
+			}
+			got, err := resolveNames(ctx, resolver, server.Name, qtype)
+			check := compareSets(dns.TypeToString[qtype], want, got, err)
+			check.detail = dns.Fqdn(server.Name) + " " + check.detail
+			checks = append(checks, check)
+		}
+	}
+
+	// SOA: answered with our primary name server
+	got, err = resolveNames(ctx, resolver, a.Zone, dns.TypeSOA)
+	checks = append(checks, compareSets("SOA", []string{dns.Fqdn(a.Primary())}, got, err))
+
+	// TXT: the running server itself answers through the resolver
+	check := delegationCheck{kind: "TXT"}
+	got, err = resolveNames(ctx, resolver, chunker.CapabilitiesName(a.Zone), dns.TypeTXT)
+	switch {
+	case err != nil:
+		check.detail = fmt.Sprintf("capabilities: %v (is the server running on port 53 at the glue address?)", err)
+	case len(got) == 0:
+		check.detail = "capabilities: no record (is the server running?)"
+	default:
+		check.ok = true
+		check.detail = "capabilities: " + got[0]
+	}
+	return append(checks, check)
+}
+
+// resolveNames asks a resolver for a name's records of one type, returning
+// their values
+func resolveNames(ctx context.Context, resolver, name string, qtype uint16) ([]string, error) {
+	m := new(dns.Msg)
+	m.SetQuestion(dns.Fqdn(name), qtype)
+	m.RecursionDesired = true
+
+	resp, err := transport.UDP.Exchange(ctx, m, resolver, DELEGATION_QUERY_TIMEOUT)
+	if err != nil {
+		return nil, err
+	}
+	if resp.Rcode != dns.RcodeSuccess {
+		return nil, fmt.Errorf("%s", dns.RcodeToString[resp.Rcode])
+	}
+
+	var values []string
+	for _, rr := range resp.Answer {
+		if rr.Header().Rrtype == qtype {
+			values = append(values, rrValue(rr))
+		}
+	}
+	return values, nil
+}
+
+// rrValue returns the part of a record a delegation check compares
+func rrValue(rr dns.RR) string {
+	switch v := rr.(type) {
+	case *dns.NS:
+		return strings.ToLower(v.Ns)
+	case *dns.SOA:
+		return strings.ToLower(v.Ns)
+	case *dns.A:
+		return v.A.String()
+	case *dns.AAAA:
+		return v.AAAA.String()
+	case *dns.TXT:
+		return chunker.JoinTXT(v.Txt)
+	}
+	return rr.String()
+}
+
+// compareSets checks that a resolver returned exactly the expected values
+func compareSets(kind string, want, got []string, err error) delegationCheck {
+	if err != nil {
+		return delegationCheck{kind: kind, detail: err.Error()}
+	}
+	if len(got) == 0 {
+		return delegationCheck{kind: kind, detail: "no records (not delegated yet?)"}
+	}
+
+	wanted := make(map[string]bool, len(want))
+	for _, value := range want {
+		wanted[strings.ToLower(value)] = true
+	}
+	var missing, extra []string
+	seen := make(map[string]bool, len(got))
+	for _, value := range got {
+		seen[value] = true
+		if !wanted[value] {
+			extra = append(extra, value)
+		}
+	}
+	for value := range wanted {
+		if !seen[value] {
+			missing = append(missing, value)
+		}
+	}
+	sort.Strings(missing)
+	sort.Strings(got)
+
+	if len(missing) == 0 && len(extra) == 0 {
+		return delegationCheck{kind: kind, ok: true, detail: strings.Join(got, ", ")}
+	}
+	detail := "got " + strings.Join(got, ", ")
+	if len(missing) > 0 {
+		detail += "; missing " + strings.Join(missing, ", ")
+	}
+	if len(extra) > 0 {
+		detail += "; not ours " + strings.Join(extra, ", ")
+	}
+	return delegationCheck{kind: kind, detail: detail}
+}
+
+// handleAdminDelegation returns the zone's delegation records (as printed by
+// -delegate) for pasting into the parent zone
+func (s *DNSServerV2) handleAdminDelegation(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/dns")
+	writeDelegation(w, s.authority)
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"github.com/miekg/dns"
+	"time"
+)
+
+// ================================================================================
+// DNS-OVER-TLS LISTENER
+// Serves the same zone over TLS on port 853 (RFC 7858)
+// ================================================================================
+
+// LESSON: When Port 53 Is Blocked
+// Networks that force all DNS through their own resolver simply drop
+// outbound port 53. DoT is ordinary DNS-over-TCP inside a TLS session on
+// port 853: the same length-prefixed messages, the same handler, but the
+// network only sees a TLS handshake to our address. Answers are never
+// truncated here - like TCP, the stream has room for any answer size.
+//
+// TLS needs a certificate for the name receivers connect to. A self-signed
+// one works if receivers are given it with -tls-ca.
+
+// newDoTServer creates a DNS-over-TLS server from a certificate and key
+// (PEM files)
+func newDoTServer(addr, certFile, keyFile string, timeout time.Duration) (*dns.Server, error) {
+	if certFile == "" || keyFile == "" {
+		return nil, errors.New("DNS-over-TLS needs -dot-cert and -dot-key")
+	}
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load DoT certificate: %w", err)
+	}
+
+	return &dns.Server{
+		Addr: addr,
+		Net:  "tcp-tls",
+		TLSConfig: &tls.Config{
+			Certificates: []tls.Certificate{cert},
+			MinVersion:   tls.VersionTLS12,
+		},
+		ReadTimeout:  timeout,
+		WriteTimeout: timeout,
+	}, nil
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	dnsserver "github.com/faanross/simulacra_txt/internal/dns-server"
+	"github.com/miekg/dns"
+	"net"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// ================================================================================
+// QUERY LIMITS
+// Bounds how many queries are answered at once
+// ================================================================================
+
+// LESSON: Shedding Load Early
+// The DNS library starts a goroutine per datagram before our handler runs,
+// so the cheapest thing a flooded server can do is decline straight away.
+// Queries beyond -max-queries get an immediate SERVFAIL without touching
+// storage: the resolver retries (or tries another server) instead of
+// caching a negative answer, and the goroutine exits in microseconds.
+// Queries that are admitted still meet the storage pool's deadline, so a
+// slow backend can hold at most -max-queries goroutines.
+
+// REJECT_LOG_EVERY is how many shed queries are counted per log line
+const REJECT_LOG_EVERY = 100
+
+// CANARY_BUSY_LOAD is the query slot usage (percent) reported as busy in canaries
+const CANARY_BUSY_LOAD = 80
+
+// queryLimiter admits a bounded number of concurrent queries
+type queryLimiter struct {
+	slots    chan struct{}
+	rejected atomic.Int64
+}
+
+// newQueryLimiter creates a limiter admitting max queries at once (0 = unlimited)
+func newQueryLimiter(max int) *queryLimiter {
+	if max <= 0 {
+		return nil
+	}
+	return &queryLimiter{slots: make(chan struct{}, max)}
+}
+
+// acquire admits a query without waiting, reporting whether there was room
+func (l *queryLimiter) acquire() bool {
+	if l == nil {
+		return true
+	}
+	select {
+	case l.slots <- struct{}{}:
+		return true
+	default:
+		if n := l.rejected.Add(1); n%REJECT_LOG_EVERY == 1 {
+			dnsLog.Warn("⚠️  Query limit reached", "limit", cap(l.slots), "shed", n)
+		}
+		return false
+	}
+}
+
+// load returns the percentage of query slots in use (0 when unlimited)
+func (l *queryLimiter) load() int {
+	if l == nil {
+		return 0
+	}
+	return len(l.slots) * 100 / cap(l.slots)
+}
+
+// release frees the slot of an admitted query
+func (l *queryLimiter) release() {
+	if l != nil {
+		<-l.slots
+	}
+}
+
+// LESSON: Silence for Floods
+// Queries over a source's rate limit get no answer at all. Source addresses
+// of UDP queries can be forged, and answering a flood - even with REFUSED -
+// would aim our replies at whoever's address the flood carries. A real
+// resolver that hits the limit times out and retries, by which time its
+// bucket has refilled a little.
+
+// rateLimited reports whether a query's source is over its rate limit
+func (s *DNSServerV2) rateLimited(source net.Addr) bool {
+	if s.rates.Allow(dnsserver.RateKey(remoteIP(source))) {
+		return false
+	}
+	if n := s.rateDropped.Add(1); n%REJECT_LOG_EVERY == 1 {
+		dnsLog.Warn("⚠️  Rate limit hit", "source", remoteIP(source), "dropped", n)
+	}
+	return true
+}
+
+// overQuota reports whether a client has used up its daily quota, refusing
+// the query if so
+func (s *DNSServerV2) overQuota(msg *dns.Msg, client dnsserver.ClientInfo) bool {
+	if s.quota.Allow(client.ID) {
+		return false
+	}
+	msg.Rcode = dns.RcodeRefused
+	return true
+}
+
+// chargeQuota counts the data of answered chunks against a client's quota
+func (s *DNSServerV2) chargeQuota(message *dnsserver.Message, client dnsserver.ClientInfo, keys []string) {
+	var bytes int64
+	for _, key := range keys {
+		if strings.HasPrefix(key, "m-") {
+			bytes += int64(len(message.Manifest))
+		} else {
+			bytes += int64(len(message.Chunks[key]))
+		}
+	}
+	if s.quota.Charge(client.ID, bytes) {
+		dnsLog.Warn("📛 Daily quota reached; chunk queries refused until midnight UTC", "client", client.ID, "via", client.Via)
+	}
+}
+
+// shedQuery answers a query the limiter turned away
+func shedQuery(w dns.ResponseWriter, r *dns.Msg) {
+	msg := new(dns.Msg)
+	msg.SetRcode(r, dns.RcodeServerFailure)
+	w.WriteMsg(msg)
+}
+
+// describeLimits summarizes the configured limits for the startup banner
+func describeLimits(maxQueries, workers int, timeout time.Duration) string {
+	queries := "unlimited queries"
+	if maxQueries > 0 {
+		queries = fmt.Sprintf("%d concurrent queries", maxQueries)
+	}
+	lookups := "unbounded storage lookups"
+	if workers > 0 {
+		lookups = fmt.Sprintf("%d storage workers", workers)
+	}
+	return fmt.Sprintf("%s, %s, %s query deadline", queries, lookups, timeout)
+}
@@ -1,38 +1,153 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"github.com/faanross/simulacra_txt/internal/chunker"
+	"github.com/faanross/simulacra_txt/internal/config"
 	dnsserver "github.com/faanross/simulacra_txt/internal/dns-server"
+	"github.com/faanross/simulacra_txt/internal/logging"
 	"github.com/miekg/dns"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"sort"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 )
 
+// Loggers of the server's components (see internal/logging)
+var (
+	dnsLog    = logging.For("dns")
+	httpLog   = logging.For("http")
+	serverLog = logging.For("server")
+)
+
 // DNSServerV2 integrates our storage backend
 type DNSServerV2 struct {
 	domain  string
 	addr    string
 	storage dnsserver.Storage
 	queue   *dnsserver.QueueManager
+	lookups dnsserver.Storage // storage as seen by DNS queries (see SetLimits)
+	limiter *queryLimiter
+	caps    chunker.Capabilities // Advertised at _simulacra.<domain>
+	ttl     chunker.TTLPolicy    // TTLs of chunk and manifest answers
+	started time.Time            // Reported in canary answers
+
+	// SOA and NS data of the domain
+	authority   *dnsserver.Authority
+	zoneVersion atomic.Uint32   // Changes since start, added to the SOA serial
+	transfers   *transferPolicy // Who may AXFR the zone (nil = nobody)
+
+	// Per-source query rates and daily data per client (nil = unlimited)
+	rates       *dnsserver.RateLimiter
+	quota       *dnsserver.QuotaTracker
+	rateDropped atomic.Int64 // Queries dropped by the rate limiter
+
+	metrics *dnsserver.ServerMetrics // Served at /metrics
+
+	// Checks uploaded chunks before they are published (nil = unchecked)
+	validator *chunkValidator
+
+	// Template-named chunks: name relative to domain -> message ID
+	names   map[string]string
+	namesMu sync.RWMutex
+
+	// Latest receipt token per message, relayed to the sender
+	receipts   map[string]string
+	receiptsMu sync.Mutex
+
+	// When each receiver was last heard from (see presence.go)
+	presence *dnsserver.PresenceTracker
+
+	// Messages arriving in query names, one part per query (nil qnames =
+	// not accepted)
+	qnames  *chunker.QNameEncoder
+	uploads *dnsserver.UploadAssembler
+
+	// Signals in the letter case of query names (nil = not read)
+	signals *caseSignals
+
+	// Split-horizon views of the zone (nil = everyone gets answers)
+	acl      *atomic.Pointer[dnsserver.ACL] // Shared by all zones
+	decoyKey []byte                         // Keys decoy answers, so each name's decoy stays the same
+
+	// Plausible answers for names outside the protocol (nil = NXDOMAIN)
+	decoys *atomic.Pointer[dnsserver.Decoys] // Shared by all zones
+
+	// Every zone served, this one included (see zones.go)
+	zones *zoneSet
+
+	// Where discovery queries' client IDs come from, in order (see clients.go)
+	clientSources []string
+
+	// Keys the HTTP API accepts (nil = open to anyone who can connect)
+	keys atomic.Pointer[dnsserver.KeyRing]
+
+	httpServer *http.Server // The HTTP API, stopped gracefully at shutdown
+	adminAPI   bool         // /admin/* is served (keys on every zone, or a loopback API)
+
+	// Copies the zone from a primary server (nil = this is a primary, see replicate.go)
+	replicator *dnsserver.Replicator
 }
 
 // HTTP API for uploads
-func (s *DNSServerV2) StartHTTPAPI(port string) {
-	http.HandleFunc("/upload", s.handleHTTPUpload)
-	http.HandleFunc("/status", s.handleStatus)
+func (s *DNSServerV2) StartHTTPAPI(config dnsserver.HTTPConfig) error {
+	http.HandleFunc("/upload", s.zoned(dnsserver.SCOPE_UPLOAD, (*DNSServerV2).handleHTTPUpload))
+	http.HandleFunc("/status", s.zoned(dnsserver.SCOPE_ADMIN, (*DNSServerV2).handleStatus))
+	http.HandleFunc("/flush", s.zoned(dnsserver.SCOPE_ADMIN, (*DNSServerV2).handleFlush))
+	http.HandleFunc("/progress", s.zoned(dnsserver.SCOPE_ADMIN, (*DNSServerV2).handleProgress))
+	http.HandleFunc("/quota", s.zoned(dnsserver.SCOPE_ADMIN, (*DNSServerV2).handleQuota))
 
 	// NEW: Discovery endpoint for Host C
-	http.HandleFunc("/messages", s.handleGetMessages)
-	http.HandleFunc("/consume", s.handleConsumeMessage)
+	http.HandleFunc("/messages", s.zoned(dnsserver.SCOPE_CONSUME, (*DNSServerV2).handleGetMessages))
+	http.HandleFunc("/consume", s.zoned(dnsserver.SCOPE_CONSUME, (*DNSServerV2).handleConsumeMessage))
+	http.HandleFunc("/signals", s.zoned(dnsserver.SCOPE_ADMIN, (*DNSServerV2).handleSignals))
+	// Message lifecycle (see admin.go), kept from anyone who could reach it
+	// without a key
+	s.adminAPI = s.zones.keyed() || loopbackOnly(config.Addr)
+	if s.adminAPI {
+		s.registerAdminAPI()
+	} else {
+		httpLog.Warn("⚠️  /admin/* not served: the HTTP API has no keys and listens beyond loopback (see -api-keys, -http-addr)", "addr", config.String())
+	}
+
+	http.HandleFunc("/metrics", s.authorize(dnsserver.SCOPE_METRICS, s.metrics.Registry.ServeHTTP))
+
+	server, err := dnsserver.ListenHTTP(config, http.DefaultServeMux, func(err error) {
+		httpLog.Error("HTTP API stopped", "err", err)
+	})
+	if err != nil {
+		return err
+	}
+	s.httpServer = server
+	httpLog.Info("📡 HTTP API listening", "addr", config.String())
+	return nil
+}
 
-	log.Printf("📡 HTTP API starting on port %s", port)
-	go http.ListenAndServe(":"+port, nil)
+// registerAdminAPI serves the message lifecycle endpoints
+func (s *DNSServerV2) registerAdminAPI() {
+	http.HandleFunc("GET /admin/messages", s.zoned(dnsserver.SCOPE_ADMIN, (*DNSServerV2).handleAdminList))
+	http.HandleFunc("GET /admin/messages/{id}", s.zoned(dnsserver.SCOPE_ADMIN, (*DNSServerV2).handleAdminMessage))
+	http.HandleFunc("DELETE /admin/messages/{id}", s.zoned(dnsserver.SCOPE_ADMIN, (*DNSServerV2).handleAdminDelete))
+	http.HandleFunc("POST /admin/messages/{id}/expire", s.zoned(dnsserver.SCOPE_ADMIN, (*DNSServerV2).handleAdminExpire))
+	http.HandleFunc("POST /admin/messages/{id}/requeue", s.zoned(dnsserver.SCOPE_ADMIN, (*DNSServerV2).handleAdminRequeue))
+	http.HandleFunc("GET /admin/messages/{id}/zone", s.zoned(dnsserver.SCOPE_ADMIN, (*DNSServerV2).handleAdminExport))
+	http.HandleFunc("GET /admin/messages/{id}/verify", s.zoned(dnsserver.SCOPE_ADMIN, (*DNSServerV2).handleAdminVerify))
+	http.HandleFunc("GET /admin/replication", s.zoned(dnsserver.SCOPE_ADMIN, (*DNSServerV2).handleReplicationList))
+	http.HandleFunc("GET /admin/replication/{id}", s.zoned(dnsserver.SCOPE_ADMIN, (*DNSServerV2).handleReplicationMessage))
+	http.HandleFunc("GET /admin/replica", s.zoned(dnsserver.SCOPE_ADMIN, (*DNSServerV2).handleReplicaStatus))
+	http.HandleFunc("GET /admin/clients", s.zoned(dnsserver.SCOPE_ADMIN, (*DNSServerV2).handleAdminClients))
+	http.HandleFunc("GET /admin/delegation", s.zoned(dnsserver.SCOPE_ADMIN, (*DNSServerV2).handleAdminDelegation))
 }
 
 // NEW: handleGetMessages - Host C calls this to discover new messages
@@ -43,13 +158,43 @@ func (s *DNSServerV2) handleGetMessages(w http.ResponseWriter, r *http.Request)
 	}
 
 	// Get client ID from query param (default if not provided)
-	clientID := r.URL.Query().Get("client")
-	if clientID == "" {
-		clientID = "default-client"
+	client := dnsserver.ClientInfo{
+		ID:   r.URL.Query().Get("client"),
+		Addr: r.RemoteAddr,
+		Via:  dnsserver.CLIENT_VIA_HTTP,
 	}
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		client.Addr = host
+	}
+	if client.ID == "" {
+		client.ID = "default-client"
+	}
+	s.presence.Seen(client, time.Now())
 
-	// Get list of NEW messages (not yet delivered to this client)
-	messages, err := s.storage.GetNewMessages(clientID)
+	// Optional tag filter: ?tags=campaign-q3,image
+	tags, err := dnsserver.ParseTags(r.URL.Query().Get("tags"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Optional consumer group: ?group=archive
+	var group string
+	if name := r.URL.Query().Get("group"); name != "" {
+		if group, err = dnsserver.ParseGroup(name); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	// Get NEW messages (not yet delivered to this client, or its group) in
+	// queue order, marking them delivered
+	var messages []*dnsserver.Message
+	if group != "" {
+		messages, err = s.queue.ConsumeGroup(group, client, tags...)
+	} else {
+		messages, err = s.queue.ConsumeMessages(client, tags...)
+	}
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -61,16 +206,13 @@ func (s *DNSServerV2) handleGetMessages(w http.ResponseWriter, r *http.Request)
 		messageIDs = append(messageIDs, msg.ID)
 	}
 
-	// Mark these as delivered to this client
-	for _, msg := range messages {
-		s.storage.MarkAsDelivered(msg.ID, clientID)
-	}
-
-	log.Printf("📬 Client %s discovered %d new messages", clientID, len(messageIDs))
+	httpLog.Info("📬 Client discovered new messages", "client", client.ID, "group", group, "messages", len(messageIDs))
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
-		"client":   clientID,
+		"client":   client.ID,
+		"group":    group,
+		"tags":     tags,
 		"messages": messageIDs,
 		"count":    len(messageIDs),
 	})
@@ -86,21 +228,39 @@ func (s *DNSServerV2) handleConsumeMessage(w http.ResponseWriter, r *http.Reques
 	var req struct {
 		MessageID string `json:"message_id"`
 		ClientID  string `json:"client_id"`
+		Group     string `json:"group"` // Consumer group ("" = none)
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		http.Error(w, err.Error(), requestStatus(err))
 		return
 	}
 
-	// Mark as consumed
-	err := s.storage.MarkAsConsumed(req.MessageID, req.ClientID)
+	// Mark as consumed (burning it, if it was uploaded with burn)
+	var err error
+	if req.Group != "" {
+		var group string
+		if group, err = dnsserver.ParseGroup(req.Group); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		err = s.queue.AcknowledgeGroup(req.MessageID, group, req.ClientID)
+	} else {
+		err = s.queue.AcknowledgeMessage(req.MessageID, req.ClientID)
+	}
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	log.Printf("✅ Message %s consumed by %s", req.MessageID, req.ClientID)
+	if dnsserver.ValidClientID(req.ClientID) {
+		client := dnsserver.ClientInfo{ID: req.ClientID, Addr: r.RemoteAddr, Via: dnsserver.CLIENT_VIA_HTTP}
+		if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+			client.Addr = host
+		}
+		s.presence.Seen(client, time.Now())
+	}
+	httpLog.Info("✅ Message consumed", "msg_id", req.MessageID, "client", req.ClientID, "group", req.Group)
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]string{
@@ -108,6 +268,15 @@ func (s *DNSServerV2) handleConsumeMessage(w http.ResponseWriter, r *http.Reques
 	})
 }
 
+// requestStatus is the status for a request body that failed to decode:
+// too large, or malformed
+func requestStatus(err error) int {
+	if dnsserver.BodyTooLarge(err) {
+		return http.StatusRequestEntityTooLarge
+	}
+	return http.StatusBadRequest
+}
+
 // handleHTTPUpload receives chunks via HTTP
 func (s *DNSServerV2) handleHTTPUpload(w http.ResponseWriter, r *http.Request) {
 	if r.Method != "POST" {
@@ -116,35 +285,90 @@ func (s *DNSServerV2) handleHTTPUpload(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var req struct {
-		MessageID string            `json:"message_id"`
-		Chunks    map[string]string `json:"chunks"`
-		Manifest  string            `json:"manifest"`
+		MessageID  string            `json:"message_id"`
+		Chunks     map[string]string `json:"chunks"`
+		Manifest   string            `json:"manifest"`
+		Tags       []string          `json:"tags"`
+		Recipient  string            `json:"recipient"`  // Client ID that alone discovers it ("" = any)
+		Burn       bool              `json:"burn"`       // Delete once fetched (burn after reading)
+		Priority   string            `json:"priority"`   // Discovery order: low, normal, high, urgent
+		Visibility string            `json:"visibility"` // Redelivered unless acknowledged within, e.g. "10m"
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), requestStatus(err))
+		return
+	}
+
+	// Sharded chunks are fetched once per share, so "every chunk fetched"
+	// would burn them after the first share
+	if req.Burn && isSharded(&dnsserver.Message{ID: req.MessageID, Manifest: req.Manifest}) {
+		http.Error(w, "burn after reading can't be combined with sharded chunks", http.StatusBadRequest)
+		return
+	}
+
+	priority, err := dnsserver.ParsePriority(req.Priority)
+	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	// Process chunks to use simpler keys for lookup
-	processedChunks := make(map[string]string)
-	for chunkName, chunkData := range req.Chunks {
-		// Extract just the label part (e.g., "c-0-msgid" from "c-0-msgid.data.domain.com")
-		parts := strings.Split(chunkName, ".")
-		if len(parts) > 0 {
-			processedChunks[parts[0]] = chunkData
-		}
+	visibility, err := dnsserver.ParseVisibility(req.Visibility)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	recipient, err := dnsserver.NormalizeRecipient(req.Recipient)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
 
 	// Store the message
-	err := s.queue.PublishMessage(req.MessageID, processedChunks, req.Manifest)
+	err = s.publishUpload(req.MessageID, req.Chunks, req.Manifest, dnsserver.PublishOptions{
+		Tags:       req.Tags,
+		Recipient:  recipient,
+		Burn:       req.Burn,
+		Priority:   priority,
+		Visibility: visibility,
+	})
 
+	var duplicate *dnsserver.DuplicateError
+	if errors.As(err, &duplicate) {
+		// Identical content is already served; nothing was stored
+		httpLog.Info("♻️  Upload duplicates a stored message, not stored again", "msg_id", req.MessageID, "existing", duplicate.Existing)
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{
+			"status":     "duplicate",
+			"message_id": duplicate.Existing,
+			"chunks":     fmt.Sprintf("%d", len(req.Chunks)),
+		})
+		return
+	}
+	if errors.Is(err, dnsserver.ErrConflict) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if errors.Is(err, errInvalidChunk) || errors.Is(err, errUploadRejected) {
+		httpLog.Warn("🚫 Upload rejected", "msg_id", req.MessageID, "err", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if errors.Is(err, dnsserver.ErrOverRetention) {
+		httpLog.Warn("🚫 Upload rejected", "msg_id", req.MessageID, "err", err)
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	log.Printf("✅ Uploaded message %s via HTTP (%d chunks)", req.MessageID, len(req.Chunks))
+	s.metrics.Uploaded(dnsserver.UPLOAD_VIA_HTTP, (&dnsserver.Message{Chunks: req.Chunks, Manifest: req.Manifest}).Size())
+
+	httpLog.Info("✅ Uploaded message via HTTP", "msg_id", req.MessageID, "chunks", len(req.Chunks), "tags", req.Tags, "to", recipient, "burn", req.Burn, "priority", dnsserver.PriorityName(priority))
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]string{
@@ -154,6 +378,60 @@ func (s *DNSServerV2) handleHTTPUpload(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// publishUpload stores an uploaded message, however it arrived
+func (s *DNSServerV2) publishUpload(msgID string, chunks map[string]string, manifest string, options dnsserver.PublishOptions) error {
+	if s.validator != nil {
+		if err := s.validator.Check(msgID, chunks); err != nil {
+			return err
+		}
+	}
+	recipient, err := manifestRecipient(msgID, manifest, options.Recipient)
+	if err != nil {
+		return err
+	}
+	options.Recipient = recipient
+
+	// Process chunks to use simpler keys for lookup
+	// (e.g., "c-0-msgid" from "c-0-msgid.data.domain.com")
+	processedChunks := make(map[string]string)
+	var indexed []string
+	for chunkName, chunkData := range chunks {
+		key, isIndexed := s.chunkKey(chunkName)
+		processedChunks[key] = chunkData
+		if isIndexed {
+			indexed = append(indexed, key)
+		}
+	}
+
+	if err := s.queue.Publish(msgID, processedChunks, manifest, options); err != nil {
+		return err
+	}
+
+	for _, key := range indexed {
+		s.indexName(key, msgID)
+	}
+	s.zoneChanged()
+	return nil
+}
+
+// manifestRecipient reconciles the recipient an upload names with the one
+// its manifest names: either may address the message, but not to two
+// different clients
+func manifestRecipient(msgID, manifest, recipient string) (string, error) {
+	parsed, err := chunker.ParseManifest(manifest, msgID, "")
+	if err != nil || parsed.Recipient == "" {
+		return recipient, nil
+	}
+	addressed, err := dnsserver.NormalizeRecipient(parsed.Recipient)
+	if err != nil {
+		return "", fmt.Errorf("%w: manifest %v", errUploadRejected, err)
+	}
+	if recipient != "" && recipient != addressed {
+		return "", fmt.Errorf("%w: upload is addressed to %s, its manifest to %s", errUploadRejected, recipient, addressed)
+	}
+	return addressed, nil
+}
+
 // handleStatus returns server status
 func (s *DNSServerV2) handleStatus(w http.ResponseWriter, r *http.Request) {
 	stats := s.storage.GetStats()
@@ -161,133 +439,590 @@ func (s *DNSServerV2) handleStatus(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(stats)
 }
 
-func NewDNSServerV2(domain, addr string, persistent bool) *DNSServerV2 {
-	var storage dnsserver.Storage
-	var err error
+// handleProgress reports which chunks of a message were served, and to
+// whom (?msg=ID), or the coverage of every message without the chunk lists
+func (s *DNSServerV2) handleProgress(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
 
+	w.Header().Set("Content-Type", "application/json")
+	if msgID := r.URL.Query().Get("msg"); msgID != "" {
+		coverage, err := s.queue.Progress(msgID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(coverage)
+		return
+	}
+
+	messages, err := s.storage.ListMessages()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	report := make([]dnsserver.ChunkCoverage, 0, len(messages))
+	for _, m := range messages {
+		coverage := dnsserver.Coverage(m)
+		coverage.Missing = nil
+		report = append(report, coverage)
+	}
+	sort.Slice(report, func(a, b int) bool { return report[a].MessageID < report[b].MessageID })
+	json.NewEncoder(w).Encode(report)
+}
+
+// handleQuota reports today's usage per client against the daily quota
+func (s *DNSServerV2) handleQuota(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.quota == nil {
+		http.Error(w, "no daily quota configured (-quota-daily)", http.StatusNotImplemented)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.quota.Report())
+}
+
+// handleFlush writes pending changes of persistent storage to its data
+// file now instead of at the next snapshot
+func (s *DNSServerV2) handleFlush(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	fs, ok := s.storage.(*dnsserver.FileStorage)
+	if !ok {
+		http.Error(w, "storage is not file-backed (-persistent)", http.StatusNotImplemented)
+		return
+	}
+	if err := fs.Flush(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	serverLog.Info("💾 Flushed state to disk")
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status": "flushed",
+	})
+}
+
+func NewDNSServerV2(domain, addr string, persistent bool, dbFile string) *DNSServerV2 {
+	storage, err := openStorage(persistent, dbFile, "")
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	server := &DNSServerV2{
+		domain:   strings.ToLower(strings.TrimSuffix(domain, ".")),
+		addr:     addr,
+		storage:  storage,
+		queue:    dnsserver.NewQueueManager(storage),
+		lookups:  storage,
+		caps:     chunker.DefaultCapabilities(),
+		ttl:      chunker.DefaultTTLPolicy(),
+		started:  time.Now(),
+		names:    make(map[string]string),
+		receipts: make(map[string]string),
+		presence: dnsserver.NewPresenceTracker(),
+		uploads:  dnsserver.NewUploadAssembler(dnsserver.DEFAULT_UPLOAD_TIMEOUT, dnsserver.DEFAULT_MAX_PENDING_UPLOADS),
+		acl:      sharedPointer[dnsserver.ACL](),
+		decoys:   sharedPointer[dnsserver.Decoys](),
+	}
+	server.zones = &zoneSet{zones: []*DNSServerV2{server}}
+	server.metrics = dnsserver.NewServerMetrics(server.zones)
+	server.clientSources, _ = dnsserver.ParseClientSources(dnsserver.DEFAULT_CLIENT_SOURCES)
+	server.authority, _ = dnsserver.ParseAuthority(server.domain, "", "")
+	server.rebuildNameIndex()
+
+	return server
+}
+
+// openStorage opens the storage of a namespace ("" = the -domain zone's):
+// a database, a JSON file, or memory
+func openStorage(persistent bool, dbFile, namespace string) (dnsserver.Storage, error) {
+	if dbFile != "" {
+		path := dnsserver.NamespacedPath(dbFile, namespace)
+		serverLog.Info("🗄️  Using database storage", "path", path)
+		storage, err := dnsserver.NewBoltStorage(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open database storage: %w", err)
+		}
+		return storage, nil
+	}
 	if persistent {
-		log.Println("📁 Using persistent storage (dns_data.json)")
-		storage, err = dnsserver.NewFileStorage("dns_data.json")
+		path := dnsserver.NamespacedPath("dns_data.json", namespace)
+		serverLog.Info("📁 Using persistent storage", "path", path)
+		storage, err := dnsserver.NewFileStorage(path)
 		if err != nil {
-			log.Fatalf("Failed to create file storage: %v", err)
+			return nil, fmt.Errorf("failed to create file storage: %w", err)
 		}
-	} else {
-		log.Println("💾 Using in-memory storage")
-		storage = dnsserver.NewMemoryStorage()
+		return storage, nil
+	}
+	serverLog.Info("💾 Using in-memory storage")
+	return dnsserver.NewMemoryStorage(), nil
+}
+
+// SetMemoryLimit caps the chunk data held in RAM at limit bytes, spilling
+// the least recently used messages to files in dir
+func (s *DNSServerV2) SetMemoryLimit(limit int64, dir string) error {
+	spill, err := dnsserver.NewDirSpill(dir)
+	if err != nil {
+		return err
+	}
+
+	bounded, ok := s.storage.(interface {
+		SetMemoryLimit(limit int64, spill dnsserver.SpillStore) error
+	})
+	if !ok {
+		return fmt.Errorf("storage does not support a memory limit")
 	}
+	return bounded.SetMemoryLimit(limit, spill)
+}
+
+// SetRetention caps what the zone's storage holds, evicting to make room
+// as messages are stored
+func (s *DNSServerV2) SetRetention(policy dnsserver.RetentionPolicy) error {
+	bounded, ok := s.storage.(interface {
+		SetRetention(policy dnsserver.RetentionPolicy, onEvict func(ids []string)) error
+	})
+	if !ok {
+		return fmt.Errorf("storage does not support retention limits")
+	}
+	return bounded.SetRetention(policy, func(ids []string) {
+		s.zoneChanged()
+		serverLog.Info("📏 Evicted messages over the retention limit", "zone", s.domain, "messages", ids)
+	})
+}
 
-	return &DNSServerV2{
-		domain:  domain,
-		addr:    addr,
-		storage: storage,
-		queue:   dnsserver.NewQueueManager(storage),
+// SetLimits bounds concurrent queries (0 = unlimited) and runs their storage
+// lookups on a pool of workers, each lookup given timeout (0 = no pool)
+func (s *DNSServerV2) SetLimits(maxQueries, workers int, timeout time.Duration) {
+	s.limiter = newQueryLimiter(maxQueries)
+	s.lookups = s.storage
+	if workers > 0 {
+		s.lookups = dnsserver.NewLimitedStorage(s.storage, workers, timeout)
 	}
 }
 
 func (s *DNSServerV2) handleDNSRequest(w dns.ResponseWriter, r *dns.Msg) {
+	start := time.Now()
+	if s.rateLimited(w.RemoteAddr()) {
+		s.metrics.Dropped("rate")
+		return
+	}
+	if !s.limiter.acquire() {
+		s.metrics.Dropped("load")
+		shedQuery(w, r)
+		return
+	}
+	defer s.limiter.release()
+
+	if len(r.Question) == 1 && (r.Question[0].Qtype == dns.TypeAXFR || r.Question[0].Qtype == dns.TypeIXFR) {
+		s.handleTransfer(w, r)
+		return
+	}
+
 	msg := new(dns.Msg)
 	msg.SetReply(r)
 	msg.Authoritative = true
 
+	view := s.viewAction(w.RemoteAddr())
+	fetches := new(fetchLog)
 	for _, question := range r.Question {
-		if question.Qtype == dns.TypeTXT {
-			s.handleTXT(question, msg, r)
+		// Authoritative only: no recursion, no answers for other zones
+		if !s.inZone(question.Name) {
+			msg.Rcode = dns.RcodeRefused
+			msg.Authoritative = false
+			continue
+		}
+		s.readCaseSignal(question.Name)
+		if s.answerAuthority(question, msg) {
+			continue
+		}
+
+		switch question.Qtype {
+		case dns.TypeTXT:
+			// QNAME uploads, acknowledged in TXT, and everything we serve
+			if !s.handleQNameUpload(question, msg, view) {
+				s.handleTXT(question, msg, r, view, w.RemoteAddr(), fetches)
+			}
+		case dns.TypeA:
+			// QNAME uploads, and decoys for everything else
+			if !s.handleQNameUpload(question, msg, view) {
+				s.answerUnknown(question, msg)
+			}
+		case dns.TypeAAAA, dns.TypeNULL:
+			// Only chunks of sharded messages have these types
+			if view != dnsserver.ACL_ANSWER {
+				s.answerHidden(question, msg, view)
+				continue
+			}
+			qname := strings.ToLower(strings.TrimSuffix(question.Name, "."))
+			s.handleChunkQuery(qname, msg, question, s.identifyClient(r, qname, w.RemoteAddr()), fetches)
 		}
 	}
+	if len(r.Question) > 0 {
+		s.addNegativeSOA(r.Question[0], msg)
+	}
+
+	// LESSON: Response Size Limits
+	// Classic DNS over UDP is capped at 512 bytes. Clients that advertise a
+	// larger EDNS0 buffer can receive range answers in one datagram; anything
+	// that still doesn't fit is trimmed to whole records and flagged TC.
+	size := dns.MinMsgSize
+	if opt := r.IsEdns0(); opt != nil {
+		size = int(opt.UDPSize())
+		msg.SetEdns0(opt.UDPSize(), false)
+	}
+	if _, isUDP := w.RemoteAddr().(*net.UDPAddr); isUDP {
+		msg.Truncate(size)
+	}
+	s.recordFetches(fetches, msg)
 
 	w.WriteMsg(msg)
+	s.metrics.Query(queryType(r), dns.RcodeToString[msg.Rcode], time.Since(start))
+}
+
+// queryType names a query's question type for metrics; types outside the
+// known set share "other", so junk queries can't create series at will
+func queryType(r *dns.Msg) string {
+	if len(r.Question) == 0 {
+		return "none"
+	}
+	if name, known := dns.TypeToString[r.Question[0].Qtype]; known {
+		return name
+	}
+	return "other"
 }
 
-func (s *DNSServerV2) handleTXT(q dns.Question, msg *dns.Msg, r *dns.Msg) {
+func (s *DNSServerV2) handleTXT(q dns.Question, msg *dns.Msg, r *dns.Msg, view string, source net.Addr, fetches *fetchLog) {
 	qname := strings.ToLower(strings.TrimSuffix(q.Name, "."))
 
-	// Extract client ID from query (for tracking)
-	// In production, would extract from source IP or EDNS0
-	clientID := "client-default"
+	// Health check clients run before transfers
+	if nonce, ok := chunker.ParseCanaryName(qname, s.domain); ok {
+		s.handleCanary(q, msg, nonce)
+		return
+	}
+
+	// Protocol version record
+	if qname == chunker.CapabilitiesName(s.domain) {
+		s.handleCapabilities(q, msg)
+		return
+	}
+
+	// Receipts travel from receiver to sender through the server
+	if s.handleReceipt(qname, msg, q) {
+		return
+	}
+
+	// Receivers' heartbeats (see presence.go)
+	if s.handleBeacon(qname, msg, q, dnsserver.ClientInfo{Addr: remoteIP(source)}) {
+		return
+	}
+
+	// Message data only reaches sources the ACL lets see it
+	if view != dnsserver.ACL_ANSWER {
+		s.answerHidden(q, msg, view)
+		return
+	}
 
 	// Check if this is a consumption query (special prefix)
-	if strings.Contains(qname, "consume.") {
-		s.handleConsume(qname, msg, clientID)
+	if dnsserver.IsConsumeName(qname) {
+		client := s.identifyClient(r, qname, source)
+		s.presence.Seen(client, time.Now())
+		s.handleConsume(qname, msg, client)
+		return
+	}
+
+	// Acknowledgement of a fetched message (see visibility.go)
+	if msgID, clientID, group, ok := dnsserver.ParseAckName(qname, s.domain); ok {
+		s.presence.Seen(dnsserver.ClientInfo{ID: clientID, Addr: remoteIP(source), Via: dnsserver.CLIENT_VIA_NAME}, time.Now())
+		s.handleAck(q, msg, msgID, clientID, group)
 		return
 	}
 
 	// Regular chunk query
-	s.handleChunkQuery(qname, msg, q)
+	s.handleChunkQuery(qname, msg, q, s.identifyClient(r, qname, source), fetches)
+}
+
+// handleCapabilities answers the zone's version/capabilities record
+func (s *DNSServerV2) handleCapabilities(question dns.Question, msg *dns.Msg) {
+	rr := &dns.TXT{
+		Hdr: dns.RR_Header{
+			Name:   question.Name,
+			Rrtype: dns.TypeTXT,
+			Class:  dns.ClassINET,
+			Ttl:    3600, // Changes only on server restart
+		},
+		Txt: []string{s.caps.String()},
+	}
+	msg.Answer = append(msg.Answer, rr)
+	dnsLog.Debug("Served capabilities", "caps", s.caps.String())
+}
+
+// handleCanary answers a canary query with the server's health, echoing its nonce
+func (s *DNSServerV2) handleCanary(question dns.Question, msg *dns.Msg, nonce string) {
+	canary := chunker.Canary{
+		Version:    s.caps.Version,
+		MinVersion: s.caps.MinVersion,
+		Status:     chunker.CANARY_OK,
+		Nonce:      nonce,
+		Uptime:     time.Since(s.started),
+		Load:       s.limiter.load(),
+		Messages:   s.storage.GetStats().TotalMessages,
+	}
+	if canary.Load >= CANARY_BUSY_LOAD {
+		canary.Status = chunker.CANARY_BUSY
+	}
+
+	rr := &dns.TXT{
+		Hdr: dns.RR_Header{
+			Name:   question.Name,
+			Rrtype: dns.TypeTXT,
+			Class:  dns.ClassINET,
+			Ttl:    0, // Health is only true right now
+		},
+		Txt: []string{canary.String()},
+	}
+	msg.Answer = append(msg.Answer, rr)
 }
 
-func (s *DNSServerV2) handleChunkQuery(qname string, msg *dns.Msg, question dns.Question) {
+func (s *DNSServerV2) handleChunkQuery(qname string, msg *dns.Msg, question dns.Question, client dnsserver.ClientInfo, fetches *fetchLog) {
+	if s.overQuota(msg, client) {
+		return
+	}
+
+	// Names published from a manifest template
+	if s.answerNamed(qname, msg, question, client, fetches) {
+		return
+	}
+
 	// Try to find the chunk
 	parts := strings.Split(qname, ".")
 	if len(parts) < 2 {
-		msg.Rcode = dns.RcodeNameError
+		if !s.answerUnknown(question, msg) {
+			msg.Rcode = dns.RcodeNameError
+		}
 		return
 	}
 
-	// Extract potential message ID
+	// Extract the message ID; malformed data labels never reach storage
 	label := parts[0]
-	var msgID string
-
-	if strings.HasPrefix(label, "c-") || strings.HasPrefix(label, "m-") {
-		// Extract message ID from chunk name
-		idx := strings.LastIndex(label, "-")
-		if idx > 0 {
-			msgID = label[idx+1:]
-		}
+	data, err := chunker.ParseDataLabel(label)
+	if errors.Is(err, chunker.ErrMalformedLabel) {
+		dnsLog.Debug("Refused malformed name", "name", qname, "err", err)
+		s.metrics.Dropped("malformed")
+		msg.Rcode = dns.RcodeRefused
+		return
 	}
-
-	if msgID == "" {
-		msg.Rcode = dns.RcodeNameError
+	if err != nil {
+		if !s.answerUnknown(question, msg) {
+			msg.Rcode = dns.RcodeNameError
+		}
 		return
 	}
+	msgID := data.MessageID
 
 	// Get message from storage
-	message, err := s.storage.GetMessage(msgID)
+	message, err := s.lookups.GetMessage(msgID)
+	if dnsserver.IsOverloaded(err) {
+		dnsLog.Warn("Lookup failed", "msg_id", msgID, "err", err)
+		msg.Rcode = dns.RcodeServerFailure
+		return
+	}
+	if err == nil && message.State == dnsserver.StateExpired {
+		err = fmt.Errorf("message %s expired", msgID)
+	}
 	if err != nil {
-		log.Printf("Message %s not found", msgID)
+		dnsLog.Debug("Message not found", "msg_id", msgID)
 		msg.Rcode = dns.RcodeNameError
+		s.metrics.ChunkLookup(false)
+		return
+	}
+
+	// Range queries return several chunk records at once
+	// (sharded chunks are only served one at a time)
+	if data.IsRange() {
+		if question.Qtype == dns.TypeTXT && !isSharded(message) {
+			s.answerRange(data.ChunkLabel, message, msg, question, client, fetches)
+		} else {
+			msg.Rcode = dns.RcodeNameError
+		}
 		return
 	}
 
 	// Return appropriate data
 	var value string
-	if strings.HasPrefix(label, "m-") {
+	if data.Manifest {
 		value = message.Manifest
 	} else {
 		// Direct lookup using the label as key
 		if chunkData, exists := message.Chunks[label]; exists {
 			value = chunkData
 		} else {
-			log.Printf("Chunk not found: %s (available: %v)", label, getChunkKeys(message.Chunks))
+			dnsLog.Debug("Chunk not found", "label", label, "available", getChunkKeys(message.Chunks))
 
 		}
 	}
 
 	if value != "" {
+		records := chunkRecords(message, label, value, question, s.recordTTL(label))
+		msg.Answer = append(msg.Answer, records...)
+		msg.Rcode = dns.RcodeSuccess // Explicitly set success
+		dnsLog.Debug("Served", "name", qname, "type", dns.TypeToString[question.Qtype], "bytes", len(value))
+		fetches.add(message, client, label, records...)
+	} else {
+		msg.Rcode = dns.RcodeNameError
+		dnsLog.Debug("No data found", "name", qname)
+		s.metrics.ChunkLookup(false)
+	}
+}
+
+// fetchLog collects the chunks a query's answer carries. They are only
+// recorded once the answer was cut to the size it is sent at: a chunk
+// truncated out of a UDP answer wasn't served, and counting it would burn
+// a burn-after-reading message before the client's retry gets it.
+type fetchLog struct {
+	fetches []pendingFetch
+}
+
+// pendingFetch is one chunk in an answer, with the records carrying it
+type pendingFetch struct {
+	message *dnsserver.Message
+	client  dnsserver.ClientInfo
+	key     string
+	records []dns.RR
+}
+
+// add notes that records carry the chunk stored under key
+func (fl *fetchLog) add(message *dnsserver.Message, client dnsserver.ClientInfo, key string, records ...dns.RR) {
+	fl.fetches = append(fl.fetches, pendingFetch{message: message, client: client, key: key, records: records})
+}
+
+// recordFetches records the chunks whose records all made it into the
+// answer as sent, one fetch per message
+func (s *DNSServerV2) recordFetches(fl *fetchLog, msg *dns.Msg) {
+	if len(fl.fetches) == 0 {
+		return
+	}
+	sent := make(map[dns.RR]bool, len(msg.Answer))
+	for _, rr := range msg.Answer {
+		sent[rr] = true
+	}
+
+	var keys []string
+	for i, fetch := range fl.fetches {
+		whole := true
+		for _, rr := range fetch.records {
+			whole = whole && sent[rr]
+		}
+		if whole {
+			keys = append(keys, fetch.key)
+		}
+
+		next := i + 1
+		if next == len(fl.fetches) || fl.fetches[next].message != fetch.message || fl.fetches[next].client != fetch.client {
+			if len(keys) > 0 {
+				s.recordFetch(fetch.message, fetch.client, keys...)
+			}
+			keys = nil
+		}
+	}
+}
+
+// recordFetch reports chunks served to a client to the queue, which tracks
+// delivery progress and burns burn-after-reading messages once all of them
+// were fetched, and charges them to the client's quota
+func (s *DNSServerV2) recordFetch(message *dnsserver.Message, client dnsserver.ClientInfo, keys ...string) {
+	s.metrics.ChunkLookup(true)
+	s.chargeQuota(message, client, keys)
+
+	burned, err := s.queue.RecordFetch(message, client, keys...)
+	if err != nil {
+		dnsLog.Error("Failed to record fetch", "msg_id", message.ID, "client", client.ID, "err", err)
+		return
+	}
+	if burned {
+		s.zoneChanged()
+		dnsLog.Info("🔥 Burned message: every chunk fetched", "msg_id", message.ID)
+	}
+}
+
+// recordTTL returns the TTL for answering the record stored under key
+func (s *DNSServerV2) recordTTL(key string) uint32 {
+	if strings.HasPrefix(key, "m-") {
+		return uint32(s.ttl.ManifestTTL())
+	}
+	return uint32(s.ttl.ChunkTTL())
+}
+
+// answerRange adds one TXT record per available chunk in a range query
+func (s *DNSServerV2) answerRange(cl chunker.ChunkLabel, message *dnsserver.Message, msg *dns.Msg, question dns.Question, client dnsserver.ClientInfo, fetches *fetchLog) {
+	last := cl.Last
+	if last-cl.First+1 > chunker.MAX_BATCH_SIZE {
+		last = cl.First + chunker.MAX_BATCH_SIZE - 1
+	}
+
+	ttl := uint32(s.ttl.ChunkTTL()) // One RRset, one TTL
+	for i := cl.First; i <= last; i++ {
+		key := chunker.RangeLabel(i, i, cl.MessageID)
+		chunkData, exists := message.Chunks[key]
+		if !exists {
+			continue
+		}
+
 		rr := &dns.TXT{
 			Hdr: dns.RR_Header{
-				Name:   question.Name, // Use the ORIGINAL question name
+				Name:   question.Name,
 				Rrtype: dns.TypeTXT,
 				Class:  dns.ClassINET,
-				Ttl:    300,
+				Ttl:    ttl,
 			},
-			Txt: []string{value},
+			Txt: chunker.SplitTXT(chunker.FormatRangeValue(i, chunkData)),
 		}
 		msg.Answer = append(msg.Answer, rr)
-		msg.Rcode = dns.RcodeSuccess // Explicitly set success
-		log.Printf("Served: %s -> %d bytes", qname, len(value))
-	} else {
+		fetches.add(message, client, key, rr)
+	}
+
+	if len(msg.Answer) == 0 {
 		msg.Rcode = dns.RcodeNameError
-		log.Printf("No data found for: %s", qname)
+		dnsLog.Debug("No chunks in range", "msg_id", cl.MessageID, "first", cl.First, "last", last)
+		s.metrics.ChunkLookup(false)
+		return
 	}
+
+	dnsLog.Debug("Served range", "msg_id", cl.MessageID, "first", cl.First, "last", last, "records", len(msg.Answer))
 }
 
-func (s *DNSServerV2) handleConsume(qname string, msg *dns.Msg, clientID string) {
+func (s *DNSServerV2) handleConsume(qname string, msg *dns.Msg, client dnsserver.ClientInfo) {
 	// Special query to get new messages
 	// Format: consume.client123.covert.com
+	// Filtered: campaign-q3.image.consume.client123.covert.com
+	// Consumer group: consume-archive.client123.covert.com
+
+	tags, err := dnsserver.ConsumeTags(qname)
+	if err != nil {
+		dnsLog.Warn("Bad discovery query", "name", qname, "err", err)
+		msg.Rcode = dns.RcodeNameError
+		return
+	}
 
-	messages, err := s.queue.ConsumeMessages(clientID)
+	var messages []*dnsserver.Message
+	group := dnsserver.ConsumeGroup(qname)
+	if group != "" {
+		messages, err = s.queue.ConsumeGroup(group, client, tags...)
+	} else {
+		messages, err = s.queue.ConsumeMessages(client, tags...)
+	}
 	if err != nil {
-		log.Printf("Consume failed for %s: %v", clientID, err)
+		dnsLog.Error("Consume failed", "client", client.ID, "group", group, "err", err)
 		return
 	}
 
@@ -309,40 +1044,93 @@ func (s *DNSServerV2) handleConsume(qname string, msg *dns.Msg, clientID string)
 			Txt: []string{value},
 		}
 		msg.Answer = append(msg.Answer, rr)
-		log.Printf("Client %s consumed %d messages", clientID, len(messages))
+		dnsLog.Info("Client consumed messages", "client", client.ID, "group", group, "messages", len(messages))
+	}
+}
+
+// handleAck marks a message consumed for a receiver that acknowledges over
+// DNS, as /consume does over HTTP
+func (s *DNSServerV2) handleAck(question dns.Question, msg *dns.Msg, msgID, clientID, group string) {
+	var err error
+	if group != "" {
+		err = s.queue.AcknowledgeGroup(msgID, group, clientID)
+	} else {
+		err = s.queue.AcknowledgeMessage(msgID, clientID)
+	}
+	if err != nil {
+		dnsLog.Debug("Acknowledgement of unknown message", "msg_id", msgID, "client", clientID, "group", group, "err", err)
+		msg.Rcode = dns.RcodeNameError
+		return
+	}
+
+	rr := &dns.TXT{
+		Hdr: dns.RR_Header{
+			Name:   question.Name,
+			Rrtype: dns.TypeTXT,
+			Class:  dns.ClassINET,
+			Ttl:    0, // Every acknowledgement has to reach the server
+		},
+		Txt: []string{"consumed"},
 	}
+	msg.Answer = append(msg.Answer, rr)
+	dnsLog.Info("✅ Message consumed", "msg_id", msgID, "client", clientID, "group", group)
 }
 
 func (s *DNSServerV2) LoadChunkedMessage(msgID string, zoneContent string) error {
 	// Parse zone file and create message
 	chunks := make(map[string]string)
 	manifest := ""
+	var indexed []string
 
-	lines := strings.Split(zoneContent, "\n")
-	for _, line := range lines {
-		if strings.Contains(line, " IN TXT ") {
-			parts := strings.Fields(line)
-			if len(parts) >= 5 {
-				name := strings.TrimSuffix(parts[0], ".")
-
-				// Extract value
-				startQuote := strings.Index(line, `"`)
-				endQuote := strings.LastIndex(line, `"`)
-				if startQuote >= 0 && endQuote > startQuote {
-					value := line[startQuote+1 : endQuote]
-
-					if strings.Contains(name, "m-") {
-						manifest = value
-					} else if strings.Contains(name, "c-") {
-						chunks[name] = value
-					}
-				}
+	records, err := chunker.ParseZone(strings.NewReader(zoneContent), s.domain)
+	if err != nil {
+		return err
+	}
+
+	// Chunks are served as TXT; other carriers need a regular authoritative server
+	for _, record := range records {
+		if record.Type != chunker.RECORD_TXT {
+			continue
+		}
+
+		name, value := record.Name, record.Value
+		if strings.HasPrefix(name, chunker.CAPABILITIES_LABEL+".") {
+			// Adopt the settings the zone was generated with
+			if caps, err := chunker.ParseCapabilities(value); err == nil {
+				s.caps = caps
+			}
+		} else if strings.HasPrefix(name, "m-") {
+			manifest = value
+		} else {
+			key, isIndexed := s.chunkKey(name)
+			chunks[key] = value
+			if isIndexed {
+				indexed = append(indexed, key)
 			}
 		}
 	}
 
 	if len(chunks) > 0 {
-		return s.queue.PublishMessage(msgID, chunks, manifest)
+		// The zone's message ID is made up here, so only consistency is checked
+		if s.validator != nil {
+			if err := s.validator.Check("", chunks); err != nil {
+				return err
+			}
+		}
+
+		err := s.queue.PublishMessage(msgID, chunks, manifest)
+		var duplicate *dnsserver.DuplicateError
+		if errors.As(err, &duplicate) {
+			serverLog.Info("♻️  Zone content already stored", "existing", duplicate.Existing)
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		for _, key := range indexed {
+			s.indexName(key, msgID)
+		}
+		return nil
 	}
 
 	return fmt.Errorf("no chunks found in zone file")
@@ -350,42 +1138,363 @@ func (s *DNSServerV2) LoadChunkedMessage(msgID string, zoneContent string) error
 
 func (s *DNSServerV2) PrintStats() {
 	stats := s.storage.GetStats()
-	fmt.Printf("\n📊 Storage Statistics:\n")
+	if len(s.zones.zones) > 1 {
+		fmt.Printf("\n📊 Storage Statistics (%s):\n", s.domain)
+	} else {
+		fmt.Printf("\n📊 Storage Statistics:\n")
+	}
 	fmt.Printf("   Total messages: %d\n", stats.TotalMessages)
 	fmt.Printf("   New (undelivered): %d\n", stats.NewMessages)
 	fmt.Printf("   Delivered: %d\n", stats.Delivered)
 	fmt.Printf("   Consumed: %d\n", stats.Consumed)
 	fmt.Printf("   Total chunks: %d\n", stats.TotalChunks)
+	fmt.Printf("   Message data in memory: %d bytes\n", stats.MemoryUsage)
+	if stats.Evictions > 0 {
+		fmt.Printf("   Evicted by retention: %d messages (%d bytes)\n", stats.Evictions, stats.EvictedBytes)
+	}
+	if ms, ok := s.storage.(*dnsserver.MemoryStorage); ok {
+		if spilled := ms.Spilled(); spilled > 0 {
+			fmt.Printf("   Spilled to disk: %d messages\n", spilled)
+		}
+	}
+	if pending := s.uploads.Pending(); pending > 0 {
+		fmt.Printf("   Unfinished QNAME uploads: %d\n", pending)
+	}
 
-	messages, _ := s.storage.ListMessages()
-	if len(messages) > 0 {
+	summaries, _ := s.queue.ListSummaries(nil, nil)
+	if len(summaries) > 0 {
 		fmt.Println("\n📬 Stored Messages:")
-		for _, m := range messages {
-			status := "unknown"
-			switch m.State {
-			case dnsserver.StateNew:
-				status = "NEW"
-			case dnsserver.StateDelivered:
-				status = "DELIVERED"
-			case dnsserver.StateConsumed:
-				status = "CONSUMED"
+		for _, m := range summaries {
+			fmt.Printf("   %s: %d chunks, status=%s, served=%d/%d (%.1f%%)", m.ID, m.TotalChunks, strings.ToUpper(m.State), m.Served, m.TotalChunks, 100*float64(m.Served)/float64(max(m.TotalChunks, 1)))
+			if len(m.Tags) > 0 {
+				fmt.Printf(", tags=%s", strings.Join(m.Tags, ","))
+			}
+			if m.Recipient != "" {
+				fmt.Printf(", to=%s", m.Recipient)
 			}
-			fmt.Printf("   %s: %d chunks, status=%s\n", m.ID, m.TotalChunks, status)
+			if m.Priority != dnsserver.PriorityName(dnsserver.PRIORITY_NORMAL) {
+				fmt.Printf(", priority=%s", m.Priority)
+			}
+			fmt.Println()
 		}
+		fmt.Println("   (GET /admin/messages for details)")
 	}
+	s.printPresence()
 }
 
 func main() {
 	domain := flag.String("domain", "covert.example.com", "Domain to serve")
 	addr := flag.String("addr", ":5353", "Listen address")
 	persistent := flag.Bool("persistent", false, "Use persistent storage")
+	snapshotInterval := flag.Duration("snapshot-interval", dnsserver.DEFAULT_SNAPSHOT_INTERVAL, "How often -persistent folds its journal into dns_data.json (0 = only on /flush and shutdown)")
+	journalSync := flag.Bool("journal-sync", true, "Sync each -persistent journal entry to disk before acknowledging it (off = faster, last changes lost in a crash)")
+	dbFile := flag.String("db", "", "Keep messages in this embedded database file (bbolt) instead of memory or dns_data.json")
+	migrateJSON := flag.String("migrate-json", "", "Import a -persistent data file (e.g. dns_data.json) into -db, then exit")
+	verifySpec := flag.String("verify", "", "Reassemble these stored messages (comma-separated IDs, or \"all\") from -persistent or -db storage and report whether each is complete and intact, then exit")
+	delegate := flag.Bool("delegate", false, "Print the NS and glue records the parent zone needs to delegate -domain (and -zones) here, with the zone's skeleton, then exit")
+	checkDelegationSpec := flag.String("check-delegation", "", "Check the delegation of -domain (and -zones) through these resolvers, comma-separated, or \"public\" for Google, Cloudflare and Quad9, then exit")
 	zoneFile := flag.String("zone", "", "Zone file to load")
-	cleanInterval := flag.Duration("clean", 1*time.Hour, "Cleanup interval for old messages")
+	zonesFile := flag.String("zones", "", "More zones to serve (JSON), each with its own storage namespace, API keys, TTLs and SOA/NS; API requests pick one with ?zone=<domain>")
+	cleanInterval := flag.Duration("clean", 1*time.Hour, "Garbage collection interval (overrides the policy's interval)")
+	gcSpec := flag.String("gc", "", "GC policy, e.g. max-age=7d,max-bytes=500MB,max-messages=1000,consumed=1h,new=72h")
+	encoding := flag.String("encoding", chunker.ENCODE_BASE32, "Chunk encoding advertised to receivers ("+strings.Join(chunker.EncodingNames(), ", ")+")")
+	integrity := flag.String("integrity", chunker.DEFAULT_INTEGRITY, "Chunk integrity algorithm advertised to receivers")
+	validate := flag.Bool("validate", true, "Verify each uploaded chunk's checksum with the algorithm its header names")
+	acceptIntegrity := flag.String("accept-integrity", "", "Integrity algorithms accepted from uploads ("+strings.Join(chunker.IntegrityNames(), ", ")+"; empty = all)")
+	txtStrings := flag.Int("strings", 1, "TXT strings per chunk record advertised to receivers (1-16)")
+	profile := flag.String("profile", "", "Sizing profile advertised to receivers ("+strings.Join(chunker.ProfileNames(), ", ")+"; overrides -strings)")
+	maxQueries := flag.Int("max-queries", 1000, "Maximum DNS queries answered concurrently (0 = unlimited)")
+	queryTimeout := flag.Duration("query-timeout", 2*time.Second, "Deadline for a query's storage lookups and for reading/writing it")
+	listenTCP := flag.Bool("tcp", true, "Also listen on TCP, for clients retrying truncated answers")
+	dotAddr := flag.String("dot", "", "Also serve DNS-over-TLS on this address, e.g. :853 (needs -dot-cert and -dot-key)")
+	dotCert := flag.String("dot-cert", "", "TLS certificate (PEM) for -dot")
+	dotKey := flag.String("dot-key", "", "TLS private key (PEM) for -dot")
+	ttlSpec := flag.String("ttl", "", "Answer TTLs, e.g. chunk=1h,manifest=5m,jitter=20% (default: 300s each, no jitter)")
+	storageWorkers := flag.Int("storage-workers", 32, "Concurrent storage lookups for DNS queries (0 = unbounded)")
+	retentionSpec := flag.String("retention", "", "Limits enforced on every upload, evicting the least valuable messages to make room, e.g. max-bytes=500MB,max-messages=10000,evict=lru (evict: oldest, lru; memory or -persistent storage; empty = none)")
+	memoryLimit := flag.String("memory-limit", "", "Message data kept in RAM before cold messages spill to disk, e.g. 64MB (in-memory storage only; empty = unbounded)")
+	httpAddr := flag.String("http-addr", dnsserver.DEFAULT_HTTP_ADDR, "HTTP API listen address, e.g. 127.0.0.1:8080 to keep it off the network")
+	httpCert := flag.String("http-cert", "", "TLS certificate (PEM) for the HTTP API (needs -http-key)")
+	httpKey := flag.String("http-key", "", "TLS private key (PEM) for the HTTP API")
+	autocertHosts := flag.String("http-autocert", "", "Get HTTP API certificates from Let's Encrypt for these names (comma-separated; -http-addr must be reachable on :443)")
+	autocertCache := flag.String("http-autocert-cache", dnsserver.DEFAULT_AUTOCERT_CACHE, "Directory keeping -http-autocert certificates")
+	apiKeys := flag.String("api-keys", "", "HTTP API key file (JSON): keys with upload/consume/admin scopes, bearer or HMAC-signed; reloaded on SIGHUP or change")
+	aclFile := flag.String("acl", "", "Split-horizon ACL (JSON): chunk queries from unlisted sources get NXDOMAIN or decoys; reloaded on SIGHUP or change")
+	nsSpec := flag.String("ns", "", "Name servers of the zone, name[=address] comma-separated, relative names inside it (default ns1.<domain>), e.g. ns1=192.0.2.53,ns2=192.0.2.54")
+	soaSpec := flag.String("soa", "", "SOA fields, e.g. serial=2024060100,refresh=2h,retry=1h,expire=14d,minimum=5m,ttl=1h,mbox=hostmaster (default: today's serial, 5m negative TTL)")
+	axfrAllow := flag.String("axfr-allow", "", "Sources allowed to transfer the zone (AXFR/IXFR over TCP), comma-separated addresses or CIDR prefixes (empty = transfers refused)")
+	axfrTSIG := flag.String("axfr-tsig", "", "Also require zone transfers signed with this TSIG key, as [algorithm:]name:secret (nsupdate -y format)")
+	decoyFile := flag.String("decoys", "", "Decoy zones (JSON): unknown names get SPF/DKIM-looking TXT and A/AAAA answers instead of NXDOMAIN; reloaded on SIGHUP or change")
+	clientSpec := flag.String("client-id", dnsserver.DEFAULT_CLIENT_SOURCES, "Where discovery queries' client IDs come from, first match wins (name, edns, subnet, cookie, ip)")
+	caseKey := flag.String("case-key", "", "Shared secret for signals clients write into query name case (0x20); empty = not read")
+	qnameUploads := flag.Bool("qname-uploads", false, "Accept messages uploaded inside DNS query names (stego-send -upload qname, its default), from sources the ACL answers; without it senders need -upload http")
+	qnameKey := flag.String("qname-key", "", "Shared secret QNAME upload queries must be signed with (stego-send -qname-key); required with -api-keys, zones may set their own qname_key")
+	rateQPS := flag.Float64("rate-qps", 0, "DNS queries per second allowed from each source IP (IPv6: each /64); excess is dropped (0 = unlimited)")
+	rateBurst := flag.Int("rate-burst", 0, "Queries a source may send at once before -rate-qps applies (0 = twice -rate-qps)")
+	consumeBatch := flag.Int("consume-batch", 0, "Most messages one discovery query hands a client, highest priority then oldest first; the rest wait for the next query (0 = all)")
+	replicateFrom := flag.String("replicate-from", "", "Run as a secondary: mirror the messages of the primary server whose HTTP API is at this URL (e.g. http://ns1.example.com:8080), every zone, so receivers can fail over to this server")
+	replicateKey := flag.String("replicate-key", "", "Admin API key of the primary as id:secret, for -replicate-from")
+	replicateCA := flag.String("replicate-ca", "", "CA certificate (PEM) to trust for an HTTPS -replicate-from")
+	replicateInterval := flag.Duration("replicate-interval", dnsserver.DEFAULT_REPLICATION_INTERVAL, "How often -replicate-from polls the primary")
+	visibilityTimeout := flag.Duration("visibility-timeout", 0, "Return messages discovered or fetched but not acknowledged within this to the new queue, for any receiver to discover again; uploads may set their own (0 = never)")
+	quotaDaily := flag.String("quota-daily", "", "Message data served to each client per UTC day, e.g. 50MB; further chunk queries are refused (empty = unlimited)")
+	logLevel := flag.String("log-level", logging.DEFAULT_LEVEL, "Log level (debug, info, warn, error), optionally per component, e.g. info,dns=debug (components: dns, http, server, storage, quota, api)")
+	logFormat := flag.String("log-format", logging.FORMAT_TEXT, "Log format (text, json)")
+	logFile := flag.String("log-file", "", "Write logs to this file instead of stderr, rotated by size")
+	logMaxSize := flag.String("log-max-size", "100MB", "Rotate -log-file at this size (0 = never)")
+	logBackups := flag.Int("log-backups", logging.DEFAULT_MAX_BACKUPS, "Rotated -log-file copies kept")
+	spillDir := flag.String("spill-dir", "dns_spill", "Directory for messages spilled by -memory-limit (cleared on start)")
+	flag.String(config.FLAG_NAME, "", config.USAGE)
 	flag.Parse()
+	configPath, err := config.Apply(flag.CommandLine, "dns-server")
+	if err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+
+	logConfig := logging.Config{
+		Level:      *logLevel,
+		Format:     *logFormat,
+		File:       *logFile,
+		MaxBackups: *logBackups,
+	}
+	if *logFile != "" {
+		var err error
+		if logConfig.MaxSize, err = dnsserver.ParseByteSize(*logMaxSize); err != nil {
+			log.Fatalf("Invalid -log-max-size: %v", err)
+		}
+	}
+	logs, err := logging.Setup(logConfig)
+	if err != nil {
+		log.Fatalf("Invalid logging options: %v", err)
+	}
+	defer logs.Close()
+
+	if *dbFile != "" && *persistent {
+		log.Fatalf("-db and -persistent are different storage backends; pick one")
+	}
+	if *migrateJSON != "" {
+		if *dbFile == "" {
+			log.Fatalf("-migrate-json needs -db")
+		}
+		migrate(*migrateJSON, *dbFile)
+		return
+	}
+	if *verifySpec != "" {
+		if !*persistent && *dbFile == "" {
+			log.Fatalf("-verify reads -persistent or -db storage (stop the server first); a running server answers GET /admin/messages/{id}/verify")
+		}
+		if !verifyStored(*verifySpec, *domain, *persistent, *dbFile) {
+			os.Exit(1)
+		}
+		return
+	}
+
+	// SIGINT and SIGTERM cancel every outbound query and poll, then shut
+	// the server down (see below)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if *delegate || *checkDelegationSpec != "" {
+		authorities, err := delegationAuthorities(*domain, *nsSpec, *soaSpec, *zonesFile)
+		if err != nil {
+			log.Fatalf("❌ %v", err)
+		}
+		if *delegate {
+			for _, authority := range authorities {
+				writeDelegation(os.Stdout, authority)
+			}
+		}
+		if *checkDelegationSpec != "" {
+			resolvers, err := dnsserver.ParseResolvers(*checkDelegationSpec)
+			if err != nil {
+				log.Fatalf("❌ Invalid -check-delegation: %v", err)
+			}
+			passed := true
+			for _, authority := range authorities {
+				passed = checkDelegation(ctx, authority, resolvers) && passed
+			}
+			if !passed {
+				os.Exit(1)
+			}
+		}
+		return
+	}
 
 	// Create server with storage backend
-	server := NewDNSServerV2(*domain, *addr, *persistent)
-	server.StartHTTPAPI("8080")
+	server := NewDNSServerV2(*domain, *addr, *persistent, *dbFile)
+	if fs, ok := server.storage.(*dnsserver.FileStorage); ok {
+		fs.SetJournalSync(*journalSync)
+		fs.StartSnapshots(*snapshotInterval)
+	}
+	var memoryCap int64
+	if *memoryLimit != "" {
+		var err error
+		if memoryCap, err = dnsserver.ParseByteSize(*memoryLimit); err != nil {
+			log.Fatalf("Invalid memory limit: %v", err)
+		}
+	}
+	if memoryCap > 0 {
+		if *persistent || *dbFile != "" {
+			log.Fatalf("-memory-limit needs in-memory storage (drop -persistent or -db)")
+		}
+		if err := server.SetMemoryLimit(memoryCap, *spillDir); err != nil {
+			log.Fatalf("Failed to set memory limit: %v", err)
+		}
+	}
+	retention, err := dnsserver.ParseRetention(*retentionSpec)
+	if err != nil {
+		log.Fatalf("Invalid -retention: %v", err)
+	}
+	if retention.Enabled() {
+		if *dbFile != "" {
+			log.Fatalf("-retention needs memory or -persistent storage; -db is bounded by -gc max-bytes= and max-messages=")
+		}
+		if err := server.SetRetention(retention); err != nil {
+			log.Fatalf("Failed to set retention: %v", err)
+		}
+	}
+	ttl, err := chunker.ParseTTLPolicy(*ttlSpec)
+	if err != nil {
+		log.Fatalf("Invalid -ttl: %v", err)
+	}
+	server.ttl = ttl
+	if *consumeBatch < 0 {
+		log.Fatalf("-consume-batch can't be negative")
+	}
+	server.queue.SetMaxBatch(*consumeBatch)
+	if *visibilityTimeout < 0 {
+		log.Fatalf("-visibility-timeout can't be negative")
+	}
+	server.queue.SetVisibilityTimeout(*visibilityTimeout)
+	if *aclFile != "" {
+		if err := server.LoadACL(*aclFile); err != nil {
+			log.Fatalf("Invalid -acl: %v", err)
+		}
+	}
+	if err := server.SetAuthority(*nsSpec, *soaSpec); err != nil {
+		log.Fatalf("Invalid -ns or -soa: %v", err)
+	}
+	if *axfrAllow != "" {
+		if err := server.SetTransfers(*axfrAllow, *axfrTSIG); err != nil {
+			log.Fatalf("Invalid -axfr-allow or -axfr-tsig: %v", err)
+		}
+	} else if *axfrTSIG != "" {
+		log.Fatalf("-axfr-tsig needs -axfr-allow")
+	}
+	if *decoyFile != "" {
+		if err := server.LoadDecoys(*decoyFile); err != nil {
+			log.Fatalf("Invalid -decoys: %v", err)
+		}
+	}
+	if *apiKeys != "" {
+		if err := server.LoadKeys(*apiKeys); err != nil {
+			log.Fatalf("Invalid -api-keys: %v", err)
+		}
+	}
+	if server.clientSources, err = dnsserver.ParseClientSources(*clientSpec); err != nil {
+		log.Fatalf("Invalid -client-id: %v", err)
+	}
+	if *caseKey != "" {
+		server.signals = &caseSignals{reader: chunker.NewCaseReader([]byte(*caseKey))}
+	}
+	if *qnameUploads {
+		if err := server.EnableQNameUploads(*qnameKey); err != nil {
+			log.Fatalf("❌ %v", err)
+		}
+	} else if *qnameKey != "" {
+		log.Fatalf("-qname-key needs -qname-uploads")
+	}
+	server.caps.Encoding = *encoding
+	server.caps.Integrity = *integrity
+	if *validate {
+		validator, err := newChunkValidator(*acceptIntegrity)
+		if err != nil {
+			log.Fatalf("Invalid -accept-integrity: %v", err)
+		}
+		if !validator.Accepts(*integrity) {
+			log.Fatalf("-integrity %s is not in -accept-integrity", *integrity)
+		}
+		server.validator = validator
+		server.caps.Algorithms = validator.names
+	} else if *acceptIntegrity != "" {
+		log.Fatalf("-accept-integrity needs -validate")
+	}
+	sizing := chunker.TXTProfile(*txtStrings)
+	if *profile != "" {
+		var err error
+		if sizing, err = chunker.LookupProfile(*profile); err != nil {
+			log.Fatalf("Invalid capabilities: %v", err)
+		}
+	}
+	server.caps.ChunkSize = sizing.ChunkSize
+	server.caps.Profile = sizing.Name
+	if err := server.caps.Check(); err != nil {
+		log.Fatalf("Invalid capabilities: %v", err)
+	}
+	server.SetLimits(*maxQueries, *storageWorkers, *queryTimeout)
+	if *rateQPS < 0 || *rateBurst < 0 {
+		log.Fatalf("-rate-qps and -rate-burst can't be negative")
+	}
+	if *rateBurst > 0 && *rateQPS == 0 {
+		log.Fatalf("-rate-burst needs -rate-qps")
+	}
+	server.rates = dnsserver.NewRateLimiter(*rateQPS, *rateBurst)
+	var dailyBytes int64
+	if *quotaDaily != "" {
+		if dailyBytes, err = dnsserver.ParseByteSize(*quotaDaily); err != nil {
+			log.Fatalf("Invalid -quota-daily: %v", err)
+		}
+		server.quota = dnsserver.NewQuotaTracker(server.storage, dailyBytes)
+		server.quota.Start(dnsserver.QUOTA_FLUSH_INTERVAL)
+	}
+	if *zonesFile != "" {
+		configs, err := dnsserver.LoadZones(*zonesFile, server.domain)
+		if err != nil {
+			log.Fatalf("Invalid -zones: %v", err)
+		}
+		options := zoneOptions{
+			persistent:       *persistent,
+			journalSync:      *journalSync,
+			snapshotInterval: *snapshotInterval,
+			dbFile:           *dbFile,
+			memoryLimit:      memoryCap,
+			spillDir:         *spillDir,
+			apiKeys:          *apiKeys,
+			qnameUploads:     *qnameUploads,
+			qnameKey:         *qnameKey,
+			dailyQuota:       dailyBytes,
+			consumeBatch:     *consumeBatch,
+			visibility:       *visibilityTimeout,
+			retention:        retention,
+		}
+		for _, config := range configs {
+			if _, err := server.AddZone(config, options); err != nil {
+				log.Fatalf("Invalid -zones: %v", err)
+			}
+		}
+	}
+	var dotServer *dns.Server
+	if *dotAddr != "" {
+		var err error
+		if dotServer, err = newDoTServer(*dotAddr, *dotCert, *dotKey, *queryTimeout); err != nil {
+			log.Fatalf("%v", err)
+		}
+		dotServer.TsigSecret = server.tsigSecrets()
+	} else if *dotCert != "" || *dotKey != "" {
+		log.Fatalf("-dot-cert and -dot-key need -dot")
+	}
+	httpConfig := dnsserver.HTTPConfig{
+		Addr:          *httpAddr,
+		CertFile:      *httpCert,
+		KeyFile:       *httpKey,
+		AutocertCache: *autocertCache,
+	}
+	if *autocertHosts != "" {
+		httpConfig.AutocertHosts = strings.Split(*autocertHosts, ",")
+	}
+	if err := server.StartHTTPAPI(httpConfig); err != nil {
+		log.Fatalf("HTTP API: %v", err)
+	}
 
 	// Load zone file if provided
 	if *zoneFile != "" {
@@ -397,66 +1506,207 @@ func main() {
 		// Extract message ID from zone file
 		msgID := fmt.Sprintf("msg%d", time.Now().Unix())
 		if err := server.LoadChunkedMessage(msgID, string(content)); err != nil {
-			log.Printf("Failed to load zone file: %v", err)
+			serverLog.Error("Failed to load zone file", "err", err)
 		} else {
-			log.Printf("✅ Loaded message %s from zone file", msgID)
+			serverLog.Info("✅ Loaded message from zone file", "msg_id", msgID)
 		}
 	}
 
-	// Start cleanup goroutine
-	go func() {
-		ticker := time.NewTicker(*cleanInterval)
-		for range ticker.C {
-			removed := server.storage.CleanExpired(*cleanInterval)
-			if removed > 0 {
-				log.Printf("🧹 Cleaned %d expired messages", removed)
+	// Start garbage collector
+	policy, err := dnsserver.ParseGCPolicy(*gcSpec)
+	if err != nil {
+		log.Fatalf("Invalid GC policy: %v", err)
+	}
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "clean" {
+			policy.Interval = *cleanInterval
+		}
+	})
+
+	for _, zone := range server.zones.zones {
+		gc := dnsserver.NewGarbageCollector(zone.storage, policy)
+		gc.Start(func(report dnsserver.GCReport) {
+			if report.Removed > 0 {
+				zone.zoneChanged()
+				serverLog.Info("🧹 Cleaned messages", "zone", zone.domain, "removed", report.Removed, "freed_bytes", report.FreedBytes, "by_reason", report.ByReason)
 			}
+		})
+		zone.queue.StartRedelivery(dnsserver.VISIBILITY_SWEEP_INTERVAL, func(ids []string) {
+			serverLog.Info("↩️  Unacknowledged messages back in the queue", "zone", zone.domain, "messages", ids)
+		})
+	}
+	if *replicateFrom != "" {
+		replication, err := replicationConfig(*replicateFrom, *replicateKey, *replicateCA, *replicateInterval)
+		if err != nil {
+			log.Fatalf("❌ Invalid %v", err)
 		}
-	}()
+		for _, zone := range server.zones.zones {
+			if err := zone.StartReplication(ctx, replication); err != nil {
+				log.Fatalf("❌ Invalid -replicate-from: %v", err)
+			}
+		}
+	} else if *replicateKey != "" || *replicateCA != "" {
+		log.Fatalf("-replicate-key and -replicate-ca need -replicate-from")
+	}
 
 	// Print initial stats
-	server.PrintStats()
+	server.zones.PrintStats()
 
 	// Handle shutdown
 	go func() {
-		sigChan := make(chan os.Signal, 1)
-		signal.Notify(sigChan, os.Interrupt)
-		<-sigChan
+		<-ctx.Done()
 		fmt.Println("\n🛑 Shutting down...")
-		server.PrintStats()
-
-		// Save if using persistent storage
-		if fs, ok := server.storage.(*dnsserver.FileStorage); ok {
-			if err := fs.Save(); err != nil {
-				log.Printf("Failed to save state: %v", err)
-			} else {
-				log.Println("💾 State saved to disk")
+
+		// Uploads in flight finish before storage closes
+		if err := dnsserver.ShutdownHTTP(server.httpServer); err != nil {
+			httpLog.Warn("HTTP API did not stop cleanly", "err", err)
+		}
+		server.zones.PrintStats()
+
+		for _, zone := range server.zones.zones {
+			zone.queue.StopRedelivery()
+			if zone.replicator != nil {
+				zone.replicator.Stop()
+			}
+
+			// Usage still counted in memory goes to storage before it closes
+			if err := zone.quota.Stop(); err != nil {
+				serverLog.Error("Failed to save quota usage", "zone", zone.domain, "err", err)
+			}
+
+			// Save if using persistent storage
+			if fs, ok := zone.storage.(*dnsserver.FileStorage); ok {
+				if err := fs.Close(); err != nil {
+					serverLog.Error("Failed to save state", "zone", zone.domain, "err", err)
+				} else {
+					serverLog.Info("💾 State saved to disk", "zone", zone.domain)
+				}
+			}
+			if bs, ok := zone.storage.(*dnsserver.BoltStorage); ok {
+				if err := bs.Close(); err != nil {
+					serverLog.Error("Failed to close database", "zone", zone.domain, "err", err)
+				}
 			}
 		}
 
+		logs.Close()
 		os.Exit(0)
 	}()
 
 	// Setup DNS handler
-	dns.HandleFunc(server.domain, server.handleDNSRequest)
-	dns.HandleFunc(".", server.handleDNSRequest)
+	dns.Handle(".", server.zones)
 
 	// Start server
-	fmt.Printf("\n🌐 DNS Server V2 starting on %s\n", *addr)
+	transports := "UDP"
+	if *listenTCP {
+		transports = "UDP+TCP"
+	}
+	fmt.Printf("\n🌐 DNS Server V2 starting on %s (%s)\n", *addr, transports)
+	if dotServer != nil {
+		fmt.Printf("🔒 DNS-over-TLS on %s\n", *dotAddr)
+	}
 	fmt.Printf("📍 Domain: %s\n", *domain)
+	for _, zone := range server.zones.zones[1:] {
+		fmt.Printf("🗂️  Zone: %s (%s)\n", zone.domain, zone.authority)
+	}
 	fmt.Printf("💾 Storage: ")
-	if *persistent {
-		fmt.Println("Persistent (dns_data.json)")
+	if *dbFile != "" {
+		fmt.Printf("Database (%s)\n", *dbFile)
+	} else if *persistent {
+		fmt.Printf("Persistent (dns_data.json, journal %s, snapshots every %s", "dns_data.json"+dnsserver.JOURNAL_SUFFIX, *snapshotInterval)
+		if !*journalSync {
+			fmt.Print(", unsynced")
+		}
+		fmt.Println(")")
+	} else if memoryCap > 0 {
+		fmt.Printf("In-memory, %s in RAM, colder messages spilled to %s/\n", *memoryLimit, *spillDir)
 	} else {
 		fmt.Println("In-memory")
 	}
-	fmt.Printf("🧹 Cleanup: Every %v\n", *cleanInterval)
+	fmt.Printf("🧹 GC policy: %s\n", policy)
+	if *replicateFrom != "" {
+		fmt.Printf("🔁 Replica of %s, polled every %s (GET /admin/replica)\n", *replicateFrom, *replicateInterval)
+	}
+	if retention.Enabled() {
+		fmt.Printf("📏 Retention: %s\n", retention)
+	}
+	fmt.Printf("⏳ TTLs: %s\n", server.ttl)
+	if server.signals != nil {
+		fmt.Printf("📶 Reading case signals (GET /signals)\n")
+	}
+	if acl := server.acl.Load(); acl != nil {
+		fmt.Printf("🛂 ACL: %s (%s)\n", acl, *aclFile)
+	}
+	fmt.Printf("🏛️  Authority: %s\n", server.authority)
+	if server.transfers != nil {
+		fmt.Printf("📤 Zone transfers: %s\n", server.transfers)
+	}
+	if decoys := server.decoys.Load(); decoys != nil {
+		fmt.Printf("🎭 Decoys: %s (%s)\n", decoys, *decoyFile)
+	}
+	if configPath != "" {
+		fmt.Printf("⚙️  Config: %s\n", configPath)
+	}
+	fmt.Printf("📝 Logs: %s\n", logConfig)
+	fmt.Printf("📡 HTTP API: %s\n", httpConfig)
+	if keys := server.keys.Load(); keys != nil {
+		fmt.Printf("🔑 HTTP API keys: %s (%s)\n", keys, *apiKeys)
+	} else {
+		fmt.Println("🔓 HTTP API: open to anyone who can connect (see -api-keys)")
+	}
+	if !server.adminAPI {
+		fmt.Println("   /admin/* not served: it needs -api-keys or a loopback -http-addr")
+	}
+	fmt.Printf("🪪 Client IDs from: %s\n", strings.Join(server.clientSources, ", "))
+	fmt.Printf("🚦 Limits: %s\n", describeLimits(*maxQueries, *storageWorkers, *queryTimeout))
+	fmt.Printf("🚰 Rate limit: %s\n", server.rates)
+	fmt.Printf("📛 Daily quota: %s\n", server.quota)
+	if server.validator != nil {
+		fmt.Printf("🔎 Upload validation: %s\n", strings.Join(server.validator.names, ", "))
+	} else {
+		fmt.Println("🔎 Upload validation: off")
+	}
+	fmt.Printf("🏷️  Capabilities: %s\n", chunker.CapabilitiesName(*domain))
+	switch {
+	case server.qnames == nil:
+		fmt.Println("📨 QNAME uploads: off (see -qname-uploads)")
+	case server.qnames.Signed():
+		fmt.Printf("📨 QNAME uploads: <data>.<index>.%s<id>-<mac>.%s TXT (or A), signed with -qname-key\n", chunker.QNAME_UPLOAD_PREFIX, *domain)
+	default:
+		fmt.Printf("📨 QNAME uploads: <data>.<index>.%s<id>.%s TXT (or A), open to anyone the ACL answers (see -qname-key)\n", chunker.QNAME_UPLOAD_PREFIX, *domain)
+	}
 	fmt.Println("\n✅ Server ready!")
 
+	// Start TCP server: answers that don't fit the client's UDP buffer are
+	// truncated, and the client asks again here
+	if *listenTCP {
+		tcpServer := &dns.Server{
+			Addr:         *addr,
+			Net:          "tcp",
+			ReadTimeout:  *queryTimeout,
+			WriteTimeout: *queryTimeout,
+			TsigSecret:   server.tsigSecrets(),
+		}
+		go func() {
+			log.Fatalf("TCP listener failed: %v", tcpServer.ListenAndServe())
+		}()
+	}
+
+	// Start DoT server: the same handler behind TLS, for networks that
+	// block plaintext port 53
+	if dotServer != nil {
+		go func() {
+			log.Fatalf("DoT listener failed: %v", dotServer.ListenAndServe())
+		}()
+	}
+
 	// Start UDP server
 	dnsServer := &dns.Server{
-		Addr: *addr,
-		Net:  "udp",
+		Addr:         *addr,
+		Net:          "udp",
+		ReadTimeout:  *queryTimeout,
+		WriteTimeout: *queryTimeout,
+		TsigSecret:   server.tsigSecrets(),
 	}
 	log.Fatal(dnsServer.ListenAndServe())
 }
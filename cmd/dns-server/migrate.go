@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	dnsserver "github.com/faanross/simulacra_txt/internal/dns-server"
+	"log"
+)
+
+// ================================================================================
+// STORAGE MIGRATION
+// Moves a -persistent JSON data file into a -db database
+// ================================================================================
+
+// LESSON: Migrate Offline
+// The import runs with no DNS or HTTP listener up, in one database
+// transaction: either every message of the JSON file lands in the database
+// or none does. Messages already in the database are skipped, so running
+// it twice is harmless. The JSON file keeps its messages (changes still in
+// its journal are folded into it first) - delete it once the server runs
+// happily on -db.
+
+// migrate imports dataFile into the database at dbFile and reports the result
+func migrate(dataFile, dbFile string) {
+	storage, err := dnsserver.NewBoltStorage(dbFile)
+	if err != nil {
+		log.Fatalf("Failed to open database storage: %v", err)
+	}
+	defer storage.Close()
+
+	report, err := storage.ImportJSON(dataFile)
+	if err != nil {
+		log.Fatalf("Migration failed: %v", err)
+	}
+
+	fmt.Printf("📦 Migrated %s -> %s\n", dataFile, dbFile)
+	fmt.Printf("   Messages imported: %d (%d chunks)\n", report.Imported, report.Chunks)
+	if report.Skipped > 0 {
+		fmt.Printf("   Already present:   %d (skipped)\n", report.Skipped)
+	}
+	fmt.Printf("   Client histories:  %d\n", report.Clients)
+
+	stats := storage.GetStats()
+	fmt.Printf("🗄️  Database now holds %d messages, %d chunks\n", stats.TotalMessages, stats.TotalChunks)
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"github.com/faanross/simulacra_txt/internal/chunker"
+	dnsserver "github.com/faanross/simulacra_txt/internal/dns-server"
+	"github.com/miekg/dns"
+	"strings"
+)
+
+// ================================================================================
+// MANIFEST-DRIVEN CHUNK NAMES
+// Serves chunks published under names other than c-<seq>-<msgid>
+// ================================================================================
+
+// LESSON: Two Ways to Find a Chunk
+// Conventional names carry the message ID in the first label, so the server
+// can go straight to the message. Template names (cache-busting prefixes,
+// rotated subdomains) may put it anywhere, so those are kept in an index of
+// name (relative to the served domain) -> message ID, filled at publish time
+// and rebuilt from storage at startup.
+
+// chunkKey returns the storage key for a published chunk name.
+// Conventional names keep their first label; others are indexed by their
+// name relative to the domain.
+func (s *DNSServerV2) chunkKey(name string) (key string, indexed bool) {
+	relative := s.relativeName(name)
+	label, _, _ := strings.Cut(relative, ".")
+
+	if _, err := chunker.ParseChunkLabel(label); err == nil {
+		return label, false
+	}
+	return relative, true
+}
+
+// relativeName strips the served domain from a query or record name
+func (s *DNSServerV2) relativeName(name string) string {
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	return strings.TrimSuffix(name, "."+s.domain)
+}
+
+// indexName records which message a template-named chunk belongs to
+func (s *DNSServerV2) indexName(key, msgID string) {
+	s.namesMu.Lock()
+	defer s.namesMu.Unlock()
+	s.names[key] = msgID
+}
+
+// rebuildNameIndex indexes template-named chunks of stored messages
+func (s *DNSServerV2) rebuildNameIndex() {
+	messages, err := s.storage.ListMessages()
+	if err != nil {
+		return
+	}
+
+	for _, msg := range messages {
+		// Storage may list messages without their chunks
+		if msg.Chunks == nil {
+			s.indexMessageNames(msg.ID)
+			continue
+		}
+		s.indexChunkNames(msg)
+	}
+}
+
+// indexMessageNames indexes the template-named chunks of a stored message
+func (s *DNSServerV2) indexMessageNames(msgID string) {
+	if msg, err := s.storage.GetMessage(msgID); err == nil {
+		s.indexChunkNames(msg)
+	}
+}
+
+// indexChunkNames indexes the template-named chunks of a message
+func (s *DNSServerV2) indexChunkNames(msg *dnsserver.Message) {
+	for key := range msg.Chunks {
+		label, _, _ := strings.Cut(key, ".")
+		if _, err := chunker.ParseChunkLabel(label); err != nil {
+			s.indexName(key, msg.ID)
+		}
+	}
+}
+
+// answerNamed serves a template-named chunk, reporting whether the name was indexed
+func (s *DNSServerV2) answerNamed(qname string, msg *dns.Msg, question dns.Question, client dnsserver.ClientInfo, fetches *fetchLog) bool {
+	key := s.relativeName(qname)
+
+	s.namesMu.RLock()
+	msgID, ok := s.names[key]
+	s.namesMu.RUnlock()
+	if !ok {
+		return false
+	}
+
+	message, err := s.lookups.GetMessage(msgID)
+	if dnsserver.IsOverloaded(err) {
+		dnsLog.Warn("Lookup failed", "msg_id", msgID, "err", err)
+		msg.Rcode = dns.RcodeServerFailure
+		return true
+	}
+	if err != nil {
+		// Collected since it was indexed
+		s.namesMu.Lock()
+		delete(s.names, key)
+		s.namesMu.Unlock()
+		return false
+	}
+
+	value, exists := message.Chunks[key]
+	if !exists {
+		return false
+	}
+
+	records := chunkRecords(message, key, value, question, s.recordTTL(key))
+	msg.Answer = append(msg.Answer, records...)
+	dnsLog.Debug("Served", "name", qname, "type", dns.TypeToString[question.Qtype], "bytes", len(value), "msg_id", msgID)
+	fetches.add(message, client, key, records...)
+	return true
+}
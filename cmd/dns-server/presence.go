@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	dnsserver "github.com/faanross/simulacra_txt/internal/dns-server"
+	"github.com/miekg/dns"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ================================================================================
+// CLIENT PRESENCE
+// Receiver beacons, and who was heard from when (GET /admin/clients)
+// ================================================================================
+
+// LESSON: Presence Outside the ACL
+// Beacons are answered before the split-horizon check, like receipts: a
+// receiver whose resolver only gets decoys still shows it is alive. The
+// answer carries nothing - "ok" with TTL 0 - so a beacon tells the far
+// end no more than that the server is there. Discovery and
+// acknowledgement queries count as signs of life too (see
+// internal/dns-server/presence.go).
+
+// handleBeacon records a receiver's heartbeat, reporting whether qname was one
+func (s *DNSServerV2) handleBeacon(qname string, msg *dns.Msg, question dns.Question, client dnsserver.ClientInfo) bool {
+	clientID, interval, ok := dnsserver.ParseBeaconName(qname, s.domain)
+	if !ok {
+		return false
+	}
+	client.ID, client.Via = clientID, dnsserver.CLIENT_VIA_NAME
+	s.presence.Beacon(client, interval, time.Now())
+
+	rr := &dns.TXT{
+		Hdr: dns.RR_Header{
+			Name:   question.Name,
+			Rrtype: dns.TypeTXT,
+			Class:  dns.ClassINET,
+			Ttl:    0, // Every beacon has to reach the server
+		},
+		Txt: []string{"ok"},
+	}
+	msg.Answer = append(msg.Answer, rr)
+	dnsLog.Debug("💓 Beacon", "client", clientID, "interval", interval, "addr", client.Addr)
+	return true
+}
+
+// handleAdminClients lists every client heard from, most recent first
+func (s *DNSServerV2) handleAdminClients(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.presence.Report(time.Now()))
+}
+
+// printPresence prints when each client was last heard from
+func (s *DNSServerV2) printPresence() {
+	report := s.presence.Report(time.Now())
+	if len(report) == 0 {
+		return
+	}
+	fmt.Println("\n👥 Clients:")
+	for _, p := range report {
+		fmt.Printf("   %s: %s, last seen %s ago", p.ClientID, strings.ToUpper(p.Status), time.Duration(p.SinceSeconds)*time.Second)
+		if p.Beacons > 0 {
+			fmt.Printf(", %d beacons every %s", p.Beacons, time.Duration(p.IntervalSeconds)*time.Second)
+		}
+		if p.Addr != "" {
+			fmt.Printf(", from %s", p.Addr)
+		}
+		fmt.Println()
+	}
+	fmt.Println("   (GET /admin/clients for details)")
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/faanross/simulacra_txt/internal/chunker"
+	dnsserver "github.com/faanross/simulacra_txt/internal/dns-server"
+	"github.com/miekg/dns"
+)
+
+// ================================================================================
+// QNAME UPLOADS
+// Accepts messages whose chunks arrive inside TXT or A query names
+// ================================================================================
+
+// LESSON: Acknowledge, Don't Cache
+// Every part is acknowledged in the type it was asked with - a TXT record
+// carrying the upload's progress, or an A record - and TTL 0: a resolver that
+// cached the acknowledgement would answer a retransmission itself, and the
+// part it carried would never reach us. Parts that can't be stored right
+// now get SERVFAIL, which the sender retries; malformed ones get REFUSED,
+// which it doesn't. Parts arriving after their message was published (a
+// lost acknowledgement, retransmitted) are acknowledged as complete without
+// starting a new upload.
+
+// LESSON: Uploads Are Opt-In
+// An upload query needs nothing but a resolver, so without a key anyone
+// who can make the server's zone resolve can store messages on it. The
+// server only accepts them with -qname-uploads, only from sources the ACL
+// answers (the others get what any unknown name gets), and, with
+// -qname-key or a zone's qname_key, only signed with that key. A server
+// whose HTTP API needs -api-keys refuses to start with unsigned uploads:
+// they would be an unauthenticated way around the keys.
+
+// errUploadRejected marks an assembled upload the server will never store
+var errUploadRejected = errors.New("upload rejected")
+
+// EnableQNameUploads accepts uploads in query names, signed with key
+// unless it is empty. A zone whose HTTP API needs keys needs one.
+func (s *DNSServerV2) EnableQNameUploads(key string) error {
+	if key == "" {
+		if s.keys.Load() != nil {
+			return fmt.Errorf("zone %s: QNAME uploads need -qname-key (or the zone's qname_key) when the HTTP API needs -api-keys", s.domain)
+		}
+		serverLog.Warn("⚠️  QNAME uploads are unsigned: anyone the ACL answers can store messages (see -qname-key)", "zone", s.domain)
+	}
+	s.qnames = chunker.NewQNameEncoder(s.domain, []byte(key))
+	return nil
+}
+
+// handleQNameUpload stores one upload part, reporting whether the query was
+// an upload; sources the ACL doesn't answer can't upload
+func (s *DNSServerV2) handleQNameUpload(question dns.Question, msg *dns.Msg, view string) bool {
+	if s.qnames == nil || view != dnsserver.ACL_ANSWER {
+		return false
+	}
+	upload, err := s.qnames.ParseQuery(question.Name)
+	if errors.Is(err, chunker.ErrNotQNameUpload) {
+		return false
+	}
+	if err != nil {
+		dnsLog.Warn("⚠️  Rejected QNAME upload query", "err", err)
+		msg.Rcode = dns.RcodeRefused
+		return true
+	}
+
+	if _, err := s.lookups.GetMessage(upload.MessageID); err == nil {
+		ackUpload(question, msg, chunker.QNameAck{Complete: true})
+		return true
+	} else if dnsserver.IsOverloaded(err) {
+		msg.Rcode = dns.RcodeServerFailure
+		return true
+	}
+
+	var done *dnsserver.CompletedUpload
+	switch {
+	case upload.IsOptions():
+		err = s.setUploadOptions(upload)
+	case upload.IsManifest():
+		manifest, parseErr := chunker.ParseManifest(upload.Data, upload.MessageID, s.domain)
+		if parseErr == nil && manifest.KeyedNames {
+			parseErr = chunker.ErrNameKeyRequired // Chunks are named here, without the secret
+		}
+		if parseErr != nil {
+			dnsLog.Warn("⚠️  Rejected QNAME manifest", "msg_id", upload.MessageID, "err", parseErr)
+			msg.Rcode = dns.RcodeRefused
+			return true
+		}
+		done, err = s.uploads.SetManifest(upload.MessageID, upload.Data, manifest.TotalChunks)
+	default:
+		done, err = s.uploads.AddChunk(upload.MessageID, upload.Index, upload.Data)
+	}
+
+	if errors.Is(err, dnsserver.ErrTooManyUploads) {
+		dnsLog.Warn("⚠️  QNAME upload deferred", "msg_id", upload.MessageID, "err", err)
+		msg.Rcode = dns.RcodeServerFailure
+		return true
+	}
+	if err != nil {
+		dnsLog.Warn("⚠️  Rejected QNAME upload part", "msg_id", upload.MessageID, "err", err)
+		msg.Rcode = dns.RcodeRefused
+		return true
+	}
+
+	if done == nil {
+		chunks, total := s.uploads.Progress(upload.MessageID)
+		ackUpload(question, msg, chunker.QNameAck{Chunks: chunks, Total: total})
+		return true
+	}
+
+	if err := s.publishAssembled(done); err != nil {
+		s.metrics.Reassembled(false)
+		dnsLog.Error("❌ Assembled QNAME upload not stored", "msg_id", done.MessageID, "err", err)
+		msg.Rcode = dns.RcodeServerFailure
+		if errors.Is(err, errInvalidChunk) || errors.Is(err, errUploadRejected) {
+			msg.Rcode = dns.RcodeRefused // Retrying won't fix a corrupt chunk
+		}
+		return true
+	}
+
+	s.metrics.Reassembled(true)
+	ackUpload(question, msg, chunker.QNameAck{Complete: true})
+	return true
+}
+
+// setUploadOptions stores the tags, recipient, burn flag, priority and
+// visibility timeout of an unfinished upload
+func (s *DNSServerV2) setUploadOptions(upload *chunker.QNameUpload) error {
+	options, err := chunker.ParseQNameOptions(upload.Data)
+	if err != nil {
+		return err
+	}
+	tags, err := dnsserver.NormalizeTags(options.Tags)
+	if err != nil {
+		return err
+	}
+	priority, err := dnsserver.ParsePriority(options.Priority)
+	if err != nil {
+		return err
+	}
+	visibility, err := dnsserver.ParseVisibility(options.Visibility)
+	if err != nil {
+		return err
+	}
+	recipient, err := dnsserver.NormalizeRecipient(options.Recipient)
+	if err != nil {
+		return err
+	}
+	return s.uploads.SetOptions(upload.MessageID, dnsserver.PublishOptions{Tags: tags, Recipient: recipient, Burn: options.Burn, Priority: priority, Visibility: visibility})
+}
+
+// publishAssembled stores a completed QNAME upload under the names its
+// manifest gives, as an HTTP upload would have
+func (s *DNSServerV2) publishAssembled(done *dnsserver.CompletedUpload) error {
+	names, err := chunker.ParseManifest(done.Manifest, done.MessageID, s.domain)
+	if err != nil {
+		return fmt.Errorf("invalid manifest: %w", err)
+	}
+
+	chunks := make(map[string]string, len(done.Chunks)+1)
+	for i, data := range done.Chunks {
+		chunks[names.ChunkName(i)] = data
+	}
+	chunks[fmt.Sprintf("m-%s.data.%s", done.MessageID, s.domain)] = done.Manifest
+
+	// Sharded chunks are fetched once per share, so "every chunk fetched"
+	// would burn them after the first share
+	if done.Options.Burn && isSharded(&dnsserver.Message{ID: done.MessageID, Manifest: done.Manifest}) {
+		return fmt.Errorf("%w: burn after reading can't be combined with sharded chunks", errUploadRejected)
+	}
+
+	err = s.publishUpload(done.MessageID, chunks, done.Manifest, done.Options)
+	if err == nil {
+		s.metrics.Uploaded(dnsserver.UPLOAD_VIA_QNAME, (&dnsserver.Message{Chunks: chunks, Manifest: done.Manifest}).Size())
+	}
+
+	var duplicate *dnsserver.DuplicateError
+	if errors.As(err, &duplicate) {
+		dnsLog.Info("♻️  QNAME upload duplicates a stored message, not stored again", "msg_id", done.MessageID, "existing", duplicate.Existing)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	dnsLog.Info("✅ Uploaded message via QNAME", "msg_id", done.MessageID, "chunks", len(done.Chunks), "tags", done.Options.Tags, "to", done.Options.Recipient, "burn", done.Options.Burn, "priority", dnsserver.PriorityName(done.Options.Priority))
+	return nil
+}
+
+// ackUpload answers an upload query with an acknowledgement: TXT
+// queries get its text, A queries an address
+func ackUpload(question dns.Question, msg *dns.Msg, ack chunker.QNameAck) {
+	hdr := dns.RR_Header{
+		Name:   question.Name,
+		Rrtype: question.Qtype,
+		Class:  dns.ClassINET,
+		Ttl:    0, // Retransmissions must reach us
+	}
+
+	if question.Qtype == dns.TypeTXT {
+		msg.Answer = append(msg.Answer, &dns.TXT{Hdr: hdr, Txt: []string{ack.String()}})
+		return
+	}
+
+	address := chunker.QNAME_ACK
+	if ack.Complete {
+		address = chunker.QNAME_COMPLETE
+	}
+	msg.Answer = append(msg.Answer, &dns.A{Hdr: hdr, A: address})
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"github.com/faanross/simulacra_txt/internal/chunker"
+	dnsserver "github.com/faanross/simulacra_txt/internal/dns-server"
+	"github.com/miekg/dns"
+)
+
+// ================================================================================
+// RECEIPT RELAY
+// Holds receivers' signed receipts until the sender polls for them
+// ================================================================================
+
+// LESSON: A Relay, Not a Verifier
+// Receipts are signed with a secret only the sender and receiver share, so
+// the server can't tell a genuine receipt from a forged one - and doesn't
+// need to: the sender verifies. The server only refuses receipts for
+// messages it doesn't serve, keeps one (the latest) per message, and
+// answers with TTL 0 so resolvers never cache a stale receipt.
+
+// handleReceipt delivers or serves a receipt, reporting whether qname was a receipt name
+func (s *DNSServerV2) handleReceipt(qname string, msg *dns.Msg, question dns.Question) bool {
+	msgID, token, ok := chunker.ParseReceiptName(s.relativeName(qname))
+	if !ok {
+		return false
+	}
+
+	if _, err := s.lookups.GetMessage(msgID); dnsserver.IsOverloaded(err) {
+		msg.Rcode = dns.RcodeServerFailure
+		return true
+	} else if err != nil {
+		msg.Rcode = dns.RcodeNameError
+		return true
+	}
+
+	answer := func(value string) {
+		msg.Answer = append(msg.Answer, &dns.TXT{
+			Hdr: dns.RR_Header{
+				Name:   question.Name,
+				Rrtype: dns.TypeTXT,
+				Class:  dns.ClassINET,
+				Ttl:    0,
+			},
+			Txt: []string{value},
+		})
+	}
+
+	s.receiptsMu.Lock()
+	defer s.receiptsMu.Unlock()
+
+	// Sender polling
+	if token == "" {
+		stored, exists := s.receipts[msgID]
+		if !exists {
+			msg.Rcode = dns.RcodeNameError
+			return true
+		}
+		answer(stored)
+		dnsLog.Info("🧾 Served receipt", "msg_id", msgID)
+		return true
+	}
+
+	// Receiver delivering
+	if !chunker.ValidReceiptToken(token) {
+		msg.Rcode = dns.RcodeNameError
+		return true
+	}
+	s.receipts[msgID] = token
+	answer("ok")
+	dnsLog.Info("🧾 Receipt stored", "msg_id", msgID)
+	return true
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	dnsserver "github.com/faanross/simulacra_txt/internal/dns-server"
+	"github.com/faanross/simulacra_txt/internal/transport"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ================================================================================
+// REPLICATION
+// The primary's side of the feed, and a secondary's replicator per zone
+// ================================================================================
+
+// handleReplicationList lists every stored message without its chunks, for
+// secondaries to compare against what they hold (see replication.go)
+func (s *DNSServerV2) handleReplicationList(w http.ResponseWriter, r *http.Request) {
+	messages, err := s.storage.ListMessages()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	listing := dnsserver.ReplicationListing{Zone: s.domain, Messages: make([]*dnsserver.Message, 0, len(messages))}
+	for _, msg := range messages {
+		metadata := *msg
+		metadata.Chunks = nil
+		listing.Messages = append(listing.Messages, &metadata)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(listing)
+}
+
+// handleReplicationMessage sends one message whole, chunks included, to a
+// secondary copying it
+func (s *DNSServerV2) handleReplicationMessage(w http.ResponseWriter, r *http.Request) {
+	message, ok := s.adminMessage(w, r)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(message)
+}
+
+// handleReplicaStatus reports how this zone stands with its primary
+func (s *DNSServerV2) handleReplicaStatus(w http.ResponseWriter, r *http.Request) {
+	if s.replicator == nil {
+		http.Error(w, "not a replica (start with -replicate-from)", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.replicator.Status())
+}
+
+// replicationConfig builds the settings of -replicate-from: key is an admin
+// API key of the primary as id:secret ("" = none), caFile a CA to trust
+// for an HTTPS primary
+func replicationConfig(primary, key, caFile string, interval time.Duration) (dnsserver.ReplicationConfig, error) {
+	config := dnsserver.ReplicationConfig{Primary: primary, Interval: interval}
+	if key != "" {
+		id, secret, ok := strings.Cut(key, ":")
+		if !ok || id == "" || secret == "" {
+			return config, fmt.Errorf("-replicate-key: use id:secret")
+		}
+		config.KeyID, config.Secret = id, secret
+	}
+
+	tlsConfig, err := transport.LoadTLSConfig(caFile)
+	if err != nil {
+		return config, fmt.Errorf("-replicate-ca: %w", err)
+	}
+	config.Client = &http.Client{Timeout: 30 * time.Second, Transport: &http.Transport{TLSClientConfig: tlsConfig}}
+	return config, nil
+}
+
+// StartReplication mirrors the zone from the primary named in config,
+// serving every copied message as soon as it arrives, until ctx is done
+func (s *DNSServerV2) StartReplication(ctx context.Context, config dnsserver.ReplicationConfig) error {
+	config.Zone = s.domain
+	replicator, err := dnsserver.NewReplicator(s.storage, config)
+	if err != nil {
+		return err
+	}
+	s.replicator = replicator
+
+	replicator.Start(ctx, func(report dnsserver.ReplicationReport, err error) {
+		if err != nil {
+			// Once per outage, not once per poll
+			if replicator.Status().Failures == 1 {
+				serverLog.Warn("⚠️  Replication from the primary failing", "zone", s.domain, "primary", config.Primary, "err", err)
+			}
+			return
+		}
+		if !report.Changed() {
+			return
+		}
+		for _, msgID := range report.Added {
+			s.indexMessageNames(msgID)
+		}
+		s.zoneChanged()
+		serverLog.Info("🔁 Replicated from the primary", "zone", s.domain, "added", report.Added, "updated", len(report.Updated), "removed", report.Removed)
+	})
+	return nil
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"github.com/faanross/simulacra_txt/internal/chunker"
+	dnsserver "github.com/faanross/simulacra_txt/internal/dns-server"
+	"github.com/miekg/dns"
+	"strings"
+)
+
+// ================================================================================
+// SHARDED CHUNK ANSWERS
+// Serves chunks of sharded messages as TXT, AAAA and NULL records
+// ================================================================================
+
+// LESSON: Sharding at Answer Time
+// Senders upload sharded messages like any other; only the manifest says
+// "shards=1". The split is deterministic, so the server computes it from
+// the stored value for each query instead of storing three copies. A TXT
+// query for a sharded chunk returns just its TXT share - the full value is
+// never published.
+
+// isSharded reports whether a stored message asked for sharded chunks
+func isSharded(message *dnsserver.Message) bool {
+	manifest, err := chunker.ParseManifest(message.Manifest, message.ID, "")
+	return err == nil && manifest.Sharded
+}
+
+// chunkRecords builds the answer records for a value stored under key: the
+// value as TXT, or for chunks of sharded messages the share matching the
+// query type. Types with nothing to serve yield no records (NODATA).
+func chunkRecords(message *dnsserver.Message, key, value string, question dns.Question, ttl uint32) []dns.RR {
+	header := func(rrtype uint16) dns.RR_Header {
+		return dns.RR_Header{
+			Name:   question.Name, // Use the ORIGINAL question name
+			Rrtype: rrtype,
+			Class:  dns.ClassINET,
+			Ttl:    ttl,
+		}
+	}
+
+	// Manifests are stored alongside the chunks but never sharded
+	manifest := strings.HasPrefix(key, "m-")
+
+	if manifest || !isSharded(message) {
+		if question.Qtype != dns.TypeTXT {
+			return nil
+		}
+		return []dns.RR{&dns.TXT{Hdr: header(dns.TypeTXT), Txt: chunker.SplitTXT(value)}}
+	}
+
+	shards, err := chunker.ShardChunk(value)
+	if err != nil {
+		dnsLog.Warn("Cannot shard chunk", "msg_id", message.ID, "err", err)
+		return nil
+	}
+
+	switch question.Qtype {
+	case dns.TypeTXT:
+		return []dns.RR{&dns.TXT{Hdr: header(dns.TypeTXT), Txt: []string{shards.TXT}}}
+	case dns.TypeAAAA:
+		records := make([]dns.RR, 0, len(shards.AAAA))
+		for _, ip := range shards.AAAA {
+			records = append(records, &dns.AAAA{Hdr: header(dns.TypeAAAA), AAAA: ip})
+		}
+		return records
+	case dns.TypeNULL:
+		return []dns.RR{&dns.NULL{Hdr: header(dns.TypeNULL), Data: string(shards.NULL)}}
+	}
+	return nil
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"fmt"
+	"github.com/faanross/simulacra_txt/internal/chunker"
+	dnsserver "github.com/faanross/simulacra_txt/internal/dns-server"
+	"github.com/faanross/simulacra_txt/internal/publisher"
+	"github.com/miekg/dns"
+	"net"
+	"sort"
+	"strings"
+)
+
+// ================================================================================
+// ZONE TRANSFERS
+// AXFR (and IXFR, answered in full) of every stored chunk record
+// ================================================================================
+
+// LESSON: Handing the Zone to a Secondary
+// A secondary authoritative server copies a zone by asking its primary for
+// AXFR over TCP: the SOA, every record, then the SOA again to mark the
+// end. It polls the SOA every refresh interval and transfers again only
+// when the serial went up - so the serial counts changes here: the -soa
+// serial (or today's date) plus one for every publish, burn and clean-up
+// since the server started.
+//
+// Transfers hand out everything at once, which is exactly what the zone
+// otherwise hides behind unguessable names, so they are off until
+// -axfr-allow lists the secondaries' addresses; -axfr-tsig additionally
+// requires requests signed with a shared key, and signs the answers.
+//
+// IXFR is answered with the whole zone, which RFC 1995 allows when the
+// server keeps no history. Over UDP it gets just the SOA, telling the
+// client to come back over TCP. Decoys and range answers are made up per
+// query and are not part of the transfer.
+
+// TRANSFER_ENVELOPE_SIZE is roughly how many bytes of records go in one
+// transfer message (a message can hold 64KB)
+const TRANSFER_ENVELOPE_SIZE = 32 * 1024
+
+// transferPolicy says who may transfer the zone (nil = nobody)
+type transferPolicy struct {
+	allow      dnsserver.SourceList
+	tsigKey    string            // Required key name, fully qualified ("" = unsigned is fine)
+	tsigSecret map[string]string // Key name -> base64 secret, for the listeners
+}
+
+// zoneChanged bumps the SOA serial after records were added or removed
+func (s *DNSServerV2) zoneChanged() {
+	s.zoneVersion.Add(1)
+}
+
+// handleTransfer answers an AXFR or IXFR query
+func (s *DNSServerV2) handleTransfer(w dns.ResponseWriter, r *dns.Msg) {
+	question := r.Question[0]
+	refuse := func(reason string) {
+		dnsLog.Warn("⛔ Zone transfer refused", "source", w.RemoteAddr().String(), "reason", reason)
+		msg := new(dns.Msg)
+		msg.SetRcode(r, dns.RcodeRefused)
+		w.WriteMsg(msg)
+	}
+
+	switch {
+	case !s.authority.IsApex(question.Name):
+		refuse("not the zone apex")
+		return
+	case s.transfers == nil || !s.transfers.allow.Contains(w.RemoteAddr()):
+		refuse("source not allowed")
+		return
+	case s.transfers.tsigKey != "" && (r.IsTsig() == nil || r.IsTsig().Hdr.Name != s.transfers.tsigKey):
+		refuse("not signed with the transfer key")
+		return
+	case r.IsTsig() != nil && w.TsigStatus() != nil:
+		refuse("bad TSIG: " + w.TsigStatus().Error())
+		return
+	}
+
+	if _, isUDP := w.RemoteAddr().(*net.UDPAddr); isUDP {
+		if question.Qtype == dns.TypeAXFR {
+			refuse("AXFR over UDP")
+			return
+		}
+		msg := new(dns.Msg)
+		msg.SetReply(r)
+		msg.Authoritative = true
+		msg.Answer = append(msg.Answer, s.soaRecord())
+		w.WriteMsg(msg)
+		return
+	}
+
+	records, err := s.zoneRecords()
+	if err != nil {
+		dnsLog.Error("Zone transfer failed", "err", err)
+		msg := new(dns.Msg)
+		msg.SetRcode(r, dns.RcodeServerFailure)
+		w.WriteMsg(msg)
+		return
+	}
+
+	ch := make(chan *dns.Envelope)
+	transfer := new(dns.Transfer)
+	done := make(chan error, 1)
+	go func() { done <- transfer.Out(w, r, ch) }()
+
+	// Out stops reading envelopes when a write fails, so every send also
+	// waits for it to return: a broken connection must not park us here
+	outReturned := false
+	send := func(batch []dns.RR) bool {
+		select {
+		case ch <- &dns.Envelope{RR: batch}:
+			return true
+		case err = <-done:
+			outReturned = true
+			return false
+		}
+	}
+
+	var batch []dns.RR
+	size := 0
+	for _, rr := range records {
+		if size+dns.Len(rr) > TRANSFER_ENVELOPE_SIZE && len(batch) > 0 {
+			if !send(batch) {
+				break
+			}
+			batch, size = nil, 0
+		}
+		batch = append(batch, rr)
+		size += dns.Len(rr)
+	}
+	if !outReturned && send(batch) {
+		close(ch)
+		err = <-done
+	}
+	w.Close() // A transfer ends the connection
+	if err != nil {
+		dnsLog.Warn("Zone transfer interrupted", "source", w.RemoteAddr().String(), "err", err)
+		return
+	}
+	dnsLog.Info("📤 Zone transferred", "source", w.RemoteAddr().String(), "type", dns.TypeToString[question.Qtype], "records", len(records), "serial", s.soaRecord().Serial)
+}
+
+// zoneRecords returns the zone as a transfer sends it: SOA, NS, glue, the
+// capabilities record, every stored chunk and manifest, then the SOA again
+func (s *DNSServerV2) zoneRecords() ([]dns.RR, error) {
+	soa := s.soaRecord()
+	records := []dns.RR{soa}
+	records = append(records, s.nsRecords()...)
+	for _, server := range s.authority.NameServers {
+		records = append(records, s.glueRecords(server.Name, dns.TypeA)...)
+		records = append(records, s.glueRecords(server.Name, dns.TypeAAAA)...)
+	}
+	records = append(records, &dns.TXT{
+		Hdr: dns.RR_Header{
+			Name:   dns.Fqdn(chunker.CapabilitiesName(s.domain)),
+			Rrtype: dns.TypeTXT,
+			Class:  dns.ClassINET,
+			Ttl:    3600,
+		},
+		Txt: []string{s.caps.String()},
+	})
+
+	messages, err := s.storage.ListMessages()
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(messages, func(a, b int) bool { return messages[a].ID < messages[b].ID })
+
+	for _, message := range messages {
+		if message.State == dnsserver.StateExpired {
+			continue // No longer served
+		}
+		// Storage may list messages without their chunks
+		if message.Chunks == nil {
+			if message, err = s.storage.GetMessage(message.ID); err != nil {
+				continue // Collected since it was listed
+			}
+		}
+		records = append(records, s.messageRecords(message)...)
+	}
+
+	return append(records, soa), nil
+}
+
+// messageRecords returns the records a message is served as
+func (s *DNSServerV2) messageRecords(message *dnsserver.Message) []dns.RR {
+	keys := make([]string, 0, len(message.Chunks)+1)
+	for key := range message.Chunks {
+		keys = append(keys, key)
+	}
+	manifestKey := "m-" + message.ID
+	if _, stored := message.Chunks[manifestKey]; !stored && message.Manifest != "" {
+		keys = append(keys, manifestKey)
+	}
+	sort.Strings(keys)
+
+	types := []uint16{dns.TypeTXT}
+	if isSharded(message) {
+		types = append(types, dns.TypeAAAA, dns.TypeNULL)
+	}
+
+	var records []dns.RR
+	for _, key := range keys {
+		value := message.Chunks[key]
+		if strings.HasPrefix(key, "m-") {
+			value = message.Manifest
+		}
+		name := dns.Fqdn(key + "." + s.domain)
+		for _, qtype := range types {
+			question := dns.Question{Name: name, Qtype: qtype, Qclass: dns.ClassINET}
+			records = append(records, chunkRecords(message, key, value, question, s.recordTTL(key))...)
+		}
+	}
+	return records
+}
+
+// SetTransfers allows the sources in allowSpec to transfer the zone, with
+// requests signed by tsigSpec's key if one is given
+func (s *DNSServerV2) SetTransfers(allowSpec, tsigSpec string) error {
+	allow, err := dnsserver.ParseSourceList(allowSpec)
+	if err != nil {
+		return err
+	}
+	if len(allow) == 0 {
+		return fmt.Errorf("no sources allowed")
+	}
+	policy := &transferPolicy{allow: allow}
+	if tsigSpec != "" {
+		key, err := publisher.ParseTSIGKey(tsigSpec)
+		if err != nil {
+			return err
+		}
+		policy.tsigKey = key.Name
+		policy.tsigSecret = map[string]string{key.Name: key.Secret}
+	}
+	s.transfers = policy
+	return nil
+}
+
+// tsigSecrets returns the TSIG keys DNS listeners verify (nil = none)
+func (s *DNSServerV2) tsigSecrets() map[string]string {
+	if s.transfers == nil {
+		return nil
+	}
+	return s.transfers.tsigSecret
+}
+
+// String describes the policy for display
+func (p *transferPolicy) String() string {
+	if p.tsigKey == "" {
+		return p.allow.String()
+	}
+	return fmt.Sprintf("%s, signed with %s", p.allow, strings.TrimSuffix(p.tsigKey, "."))
+}
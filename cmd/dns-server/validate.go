@@ -0,0 +1,99 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/faanross/simulacra_txt/internal/chunker"
+	"strings"
+)
+
+// ================================================================================
+// UPLOAD VALIDATION
+// Checks uploaded chunks against the integrity algorithm their headers name
+// ================================================================================
+
+// LESSON: Reject Corruption at the Door
+// A chunk corrupted on its way up would otherwise be served until every
+// receiver had fetched it and failed. Each chunk's header says which
+// checksum it carries (crc32c, xxhash64, crc64, sha256, ...), so the server
+// verifies it the same way a receiver would - no negotiation needed. The
+// accepted set lets an operator refuse algorithms too weak for their use.
+// HMAC tags can't be checked without the sender's key, so only their
+// checksum is.
+
+// errInvalidChunk marks uploads refused because a chunk failed validation
+var errInvalidChunk = errors.New("invalid chunk")
+
+// chunkValidator verifies the chunks of uploads before they are published
+type chunkValidator struct {
+	chunker *chunker.Chunker
+	accept  map[chunker.IntegrityAlgorithm]bool
+	names   []string
+}
+
+// newChunkValidator accepts chunks using any of the named integrity
+// algorithms, or every registered one when spec is empty
+func newChunkValidator(spec string) (*chunkValidator, error) {
+	names := chunker.IntegrityNames()
+	if strings.TrimSpace(spec) != "" {
+		names = strings.Split(spec, ",")
+	}
+
+	v := &chunkValidator{
+		chunker: chunker.NewChunker(chunker.ChunkerConfig{Logger: chunker.DiscardLogger}),
+		accept:  make(map[chunker.IntegrityAlgorithm]bool),
+	}
+	for _, name := range names {
+		algorithm, err := chunker.ParseIntegrity(strings.TrimSpace(name))
+		if err != nil {
+			return nil, err
+		}
+		if !v.accept[algorithm] {
+			v.accept[algorithm] = true
+			v.names = append(v.names, algorithm.String())
+		}
+	}
+	return v, nil
+}
+
+// Accepts reports whether chunks using the named algorithm are accepted
+func (v *chunkValidator) Accepts(name string) bool {
+	algorithm, err := chunker.ParseIntegrity(name)
+	return err == nil && v.accept[algorithm]
+}
+
+// Check verifies every chunk of an upload, skipping its manifest. All
+// chunks must belong to one message, and to msgID unless it is empty.
+func (v *chunkValidator) Check(msgID string, chunks map[string]string) error {
+	var first *chunker.Chunk
+
+	for name, value := range chunks {
+		label, _, _ := strings.Cut(strings.ToLower(name), ".")
+		if strings.HasPrefix(label, "m-") {
+			continue
+		}
+
+		chunk, err := v.chunker.DecodeChunk(value)
+		if err != nil {
+			return fmt.Errorf("%w %s: %v", errInvalidChunk, name, err)
+		}
+		if !v.accept[chunk.Metadata.Integrity] {
+			return fmt.Errorf("%w %s: integrity %s not accepted (accepted: %s)",
+				errInvalidChunk, name, chunk.Metadata.Integrity, strings.Join(v.names, ", "))
+		}
+		if err := v.chunker.VerifyIntegrity(chunk); err != nil {
+			return fmt.Errorf("%w %s: %v", errInvalidChunk, name, err)
+		}
+
+		if first == nil {
+			first = chunk
+			if id := fmt.Sprintf("%x", chunk.Metadata.MessageID[:8]); msgID != "" && id != msgID {
+				return fmt.Errorf("%w %s: belongs to message %s, not %s", errInvalidChunk, name, id, msgID)
+			}
+		} else if chunk.Metadata.MessageID != first.Metadata.MessageID {
+			return fmt.Errorf("%w %s: chunks of more than one message", errInvalidChunk, name)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/faanross/simulacra_txt/internal/chunker"
+	dnsserver "github.com/faanross/simulacra_txt/internal/dns-server"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// ================================================================================
+// SERVER-SIDE VERIFICATION
+// Reassembles a stored message the way a receiver would, without a query
+// ================================================================================
+
+// LESSON: Check Before They Fetch
+// A receiver learns that a message is broken only after fetching all of it:
+// thousands of queries for a missing chunk, a corrupted one, or a manifest
+// whose digest no longer matches. The server holds every chunk already, so
+// it can run the receiver's checks locally in milliseconds:
+//
+//   1. The manifest parses, and every chunk record it announces is stored
+//   2. Every stored chunk decodes and its checksum matches
+//   3. The chunks reassemble (parity filling any gaps)
+//   4. The signed chunk set, if the manifest carries one, matches
+//   5. The reassembled data matches the manifest's message digest
+//
+// Encrypted messages stop after step 4: the digest covers the plaintext and
+// the server has no key. Chunks with HMAC tags are checked by checksum only,
+// for the same reason. Keyed chunk names can't be derived without the naming
+// secret, so their completeness is judged by chunk sequence numbers instead.
+
+// Outcomes of the chunk set and digest checks
+const (
+	CHECK_VERIFIED = "verified"
+	CHECK_MISMATCH = "mismatch"
+	CHECK_NONE     = "none"        // The manifest carries nothing to check against
+	CHECK_SKIPPED  = "not checked" // The check couldn't run (see Problems)
+)
+
+// verifyReport is the outcome of reassembling a stored message
+type verifyReport struct {
+	MessageID   string   `json:"message_id"`
+	Complete    bool     `json:"complete"`  // Every announced chunk is stored and valid
+	Intact      bool     `json:"intact"`    // Complete, and every check that could run passed
+	Announced   int      `json:"announced"` // Chunk records the manifest announces
+	Stored      int      `json:"stored"`    // Chunk records stored
+	Valid       int      `json:"valid"`     // Stored chunks that decoded with a matching checksum
+	Parity      int      `json:"parity,omitempty"`
+	Missing     []string `json:"missing,omitempty"` // Announced chunks that aren't stored
+	Invalid     []string `json:"invalid,omitempty"` // Stored chunks that failed, with why
+	ChunkSet    string   `json:"chunk_set"`         // CHECK_*
+	Digest      string   `json:"digest"`            // CHECK_*
+	Bytes       int      `json:"bytes,omitempty"`   // Reassembled size
+	Filename    string   `json:"filename,omitempty"`
+	ContentType string   `json:"content_type,omitempty"`
+	Problems    []string `json:"problems,omitempty"`
+}
+
+// problem records something found wrong, or left unchecked
+func (r *verifyReport) problem(format string, args ...interface{}) {
+	r.Problems = append(r.Problems, fmt.Sprintf(format, args...))
+}
+
+// verifyMessage reassembles a stored message of domain with the chunker
+// and checks it as a receiver would
+func verifyMessage(message *dnsserver.Message, domain string) verifyReport {
+	report := verifyReport{MessageID: message.ID, ChunkSet: CHECK_NONE, Digest: CHECK_SKIPPED}
+
+	keys := make([]string, 0, len(message.Chunks))
+	for key := range message.Chunks {
+		if !strings.HasPrefix(key, "m-") {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	report.Stored = len(keys)
+
+	// 1. The manifest, and the records it announces
+	var manifest *chunker.DNSManifest
+	if message.Manifest == "" {
+		report.problem("no manifest: receivers can't find out what to fetch")
+	} else if parsed, err := chunker.ParseManifest(message.Manifest, message.ID, domain); err != nil {
+		report.problem("manifest: %v", err)
+	} else {
+		manifest = parsed
+		report.Announced = manifest.TotalChunks
+	}
+	ordered := announcedValues(&report, message, manifest)
+
+	// 2. Every stored chunk decodes and passes its checksum
+	chk := chunker.NewChunker(chunker.ChunkerConfig{Logger: chunker.DiscardLogger})
+	session := chk.NewReassemblySession()
+	encrypted := false
+	for _, key := range keys {
+		chunk, err := chk.DecodeChunk(message.Chunks[key])
+		if err == nil {
+			_, err = session.Add(*chunk)
+		}
+		if err != nil {
+			report.Invalid = append(report.Invalid, fmt.Sprintf("%s: %v", key, err))
+			continue
+		}
+		if id := fmt.Sprintf("%x", chunk.Metadata.MessageID[:8]); id != message.ID {
+			report.Invalid = append(report.Invalid, fmt.Sprintf("%s: belongs to message %s", key, id))
+			continue
+		}
+		report.Valid++
+		encrypted = encrypted || chunk.Metadata.Encrypted
+	}
+	status := session.Status()
+	report.Parity = status.Parity
+	if status.Received == 0 {
+		report.problem("no valid chunks stored")
+	} else if len(status.Missing) > 0 {
+		report.problem("chunk sequences %v missing", status.Missing)
+	}
+	report.Complete = manifest != nil && len(report.Missing) == 0 && len(report.Invalid) == 0 && status.Complete
+	if len(report.Invalid) > 0 {
+		report.problem("%d stored chunks invalid", len(report.Invalid))
+	}
+
+	// 4. The chunk set the sender signed
+	if manifest != nil && manifest.ChunkSet != "" {
+		switch {
+		case ordered == nil:
+			report.ChunkSet = CHECK_SKIPPED
+			report.problem("chunk set not checked: keyed chunk names need the naming secret")
+		case manifest.VerifyChunks(ordered) != nil:
+			report.ChunkSet = CHECK_MISMATCH
+			report.problem("stored chunks don't match the chunk set the manifest was signed with")
+		default:
+			report.ChunkSet = CHECK_VERIFIED
+		}
+	}
+
+	// 3 and 5. Reassemble, then compare with the message digest
+	switch {
+	case !report.Complete:
+	case encrypted:
+		report.problem("digest not checked: the message is encrypted and the server has no key")
+	default:
+		data, info, err := session.FinalizeFile()
+		if err != nil {
+			report.problem("reassembly: %v", err)
+			break
+		}
+		report.Bytes = len(data)
+		if info != nil {
+			report.Filename, report.ContentType = info.Filename, info.ContentType
+		}
+		switch err := manifest.VerifyData(data); {
+		case err == nil:
+			report.Digest = CHECK_VERIFIED
+		case errors.Is(err, chunker.ErrNoDigest):
+			report.Digest = CHECK_NONE
+		default:
+			report.Digest = CHECK_MISMATCH
+			report.problem("%v", err)
+		}
+	}
+
+	report.Intact = report.Complete && report.ChunkSet != CHECK_MISMATCH &&
+		(report.Digest == CHECK_VERIFIED || report.Digest == CHECK_NONE || encrypted)
+	return report
+}
+
+// announcedValues lists the stored value of every chunk the manifest
+// announces, in manifest order, recording the ones that aren't stored. It
+// returns nil when chunk names can't be derived.
+func announcedValues(report *verifyReport, message *dnsserver.Message, manifest *chunker.DNSManifest) []string {
+	if manifest == nil || manifest.KeyedNames {
+		return nil
+	}
+	values := make([]string, manifest.TotalChunks)
+	for i := range values {
+		key, _, _ := strings.Cut(strings.ToLower(manifest.ChunkName(i)), ".")
+		value, stored := message.Chunks[key]
+		if !stored {
+			report.Missing = append(report.Missing, key)
+		}
+		values[i] = value
+	}
+	if len(report.Missing) > 0 {
+		report.problem("%d of %d announced chunks not stored", len(report.Missing), manifest.TotalChunks)
+	}
+	return values
+}
+
+// printVerifyReport writes a report for a terminal
+func printVerifyReport(report verifyReport) {
+	fmt.Printf("🔎 Message %s\n", report.MessageID)
+	fmt.Printf("   Chunks: %d announced, %d stored, %d valid", report.Announced, report.Stored, report.Valid)
+	if report.Parity > 0 {
+		fmt.Printf(" (%d parity)", report.Parity)
+	}
+	fmt.Println()
+	if report.Bytes > 0 {
+		fmt.Printf("   Reassembled: %d bytes", report.Bytes)
+		if report.Filename != "" {
+			fmt.Printf(" (%s, %s)", report.Filename, report.ContentType)
+		}
+		fmt.Println()
+	}
+	fmt.Printf("   Chunk set: %s\n", report.ChunkSet)
+	fmt.Printf("   Digest: %s\n", report.Digest)
+	for _, problem := range report.Problems {
+		fmt.Printf("   ⚠️  %s\n", problem)
+	}
+
+	switch {
+	case report.Intact:
+		fmt.Printf("✅ %s is complete and intact\n\n", report.MessageID)
+	case report.Complete:
+		fmt.Printf("❌ %s is complete but not intact\n\n", report.MessageID)
+	default:
+		fmt.Printf("❌ %s is incomplete\n\n", report.MessageID)
+	}
+}
+
+// verifyStored checks the messages named in spec (comma-separated IDs, or
+// "all") in the -persistent or -db storage of a stopped server, and reports
+// whether every one is intact
+func verifyStored(spec, domain string, persistent bool, dbFile string) bool {
+	storage, err := openStorage(persistent, dbFile, "")
+	if err != nil {
+		log.Fatalf("❌ %v", err)
+	}
+	if closer, ok := storage.(io.Closer); ok {
+		defer closer.Close()
+	}
+
+	var ids []string
+	if spec == "all" {
+		messages, err := storage.ListMessages()
+		if err != nil {
+			log.Fatalf("❌ Failed to list messages: %v", err)
+		}
+		for _, message := range messages {
+			ids = append(ids, message.ID)
+		}
+		sort.Strings(ids)
+	} else {
+		ids = strings.Split(spec, ",")
+	}
+
+	intact := true
+	for _, id := range ids {
+		message, err := storage.GetMessage(strings.TrimSpace(id))
+		if err != nil {
+			fmt.Printf("❌ %v\n\n", err)
+			intact = false
+			continue
+		}
+		report := verifyMessage(message, domain)
+		printVerifyReport(report)
+		intact = intact && report.Intact
+	}
+	return intact
+}
+
+// handleAdminVerify reassembles a stored message and reports whether it is
+// complete and intact
+func (s *DNSServerV2) handleAdminVerify(w http.ResponseWriter, r *http.Request) {
+	message, ok := s.adminMessage(w, r)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(verifyMessage(message, s.domain))
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/base32"
+	dnsserver "github.com/faanross/simulacra_txt/internal/dns-server"
+	"github.com/miekg/dns"
+	"net"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// ================================================================================
+// SPLIT-HORIZON RESPONSES
+// Answers chunk queries only for the sources an ACL file allows
+// ================================================================================
+
+// LESSON: Reloading Without Restarting
+// Receivers move, resolvers change. The ACL file is read again on SIGHUP
+// and whenever its modification time changes (checked every
+// ACL_POLL_INTERVAL), and the new views replace the old in one atomic
+// swap: queries in flight finish under the views they started with. An
+// ACL that fails to parse is logged and ignored - the server keeps
+// answering under the last good one rather than opening up or going dark.
+//
+// Decoys are derived from the query name under a key drawn at startup, so
+// asking twice gets the same "chunk" twice, like a real record would.
+
+// ACL_POLL_INTERVAL is how often the ACL and API key files are checked for changes
+const ACL_POLL_INTERVAL = 5 * time.Second
+
+// decoyEncoding renders decoy TXT values like base32 chunks
+var decoyEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// viewAction returns what a query from source may see
+func (s *DNSServerV2) viewAction(source net.Addr) string {
+	acl := s.acl.Load()
+	if acl == nil {
+		return dnsserver.ACL_ANSWER
+	}
+	action, _ := acl.Match(source)
+	return action
+}
+
+// answerHidden answers a query the ACL doesn't allow: the zone's decoys
+// for names outside the protocol, otherwise NXDOMAIN, or for TXT queries
+// in decoy views a record that looks like a chunk
+func (s *DNSServerV2) answerHidden(question dns.Question, msg *dns.Msg, action string) {
+	if !chunkShaped(question.Name) && s.answerUnknown(question, msg) {
+		return
+	}
+	if action != dnsserver.ACL_DECOY || question.Qtype != dns.TypeTXT {
+		msg.Rcode = dns.RcodeNameError
+		return
+	}
+
+	msg.Answer = append(msg.Answer, &dns.TXT{
+		Hdr: dns.RR_Header{
+			Name:   question.Name,
+			Rrtype: dns.TypeTXT,
+			Class:  dns.ClassINET,
+			Ttl:    uint32(s.ttl.ChunkTTL()),
+		},
+		Txt: []string{s.decoyValue(question.Name)},
+	})
+}
+
+// decoyValue derives a chunk-sized base32 value from a name
+func (s *DNSServerV2) decoyValue(name string) string {
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	size := s.caps.ChunkSize
+	if size > 255 {
+		size = 255 // One TXT string
+	}
+
+	value := dnsserver.DecoyBytes(s.decoyKey, name, decoyEncoding.DecodedLen(size))
+	return strings.ToLower(decoyEncoding.EncodeToString(value))
+}
+
+// LoadACL installs the ACL at path and keeps it up to date
+func (s *DNSServerV2) LoadACL(path string) error {
+	acl, err := dnsserver.LoadACL(path)
+	if err != nil {
+		return err
+	}
+	s.initDecoyKey()
+	s.acl.Store(acl)
+
+	go s.watchACL(path)
+	return nil
+}
+
+// watchACL reloads the ACL on SIGHUP and when the file changes
+func (s *DNSServerV2) watchACL(path string) {
+	watchFile(path, func() {
+		acl, err := dnsserver.LoadACL(path)
+		if err != nil {
+			dnsLog.Warn("⚠️  ACL not reloaded, keeping the previous one", "err", err)
+			return
+		}
+		s.acl.Store(acl)
+		dnsLog.Info("🛂 ACL reloaded", "acl", acl.String())
+	})
+}
+
+// watchFile calls reload on SIGHUP and whenever the file at path changes
+func watchFile(path string, reload func()) {
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	ticker := time.NewTicker(ACL_POLL_INTERVAL)
+	defer ticker.Stop()
+
+	modified := modTime(path)
+	for {
+		select {
+		case <-hangup:
+		case <-ticker.C:
+			if current := modTime(path); current.Equal(modified) {
+				continue
+			}
+		}
+		modified = modTime(path)
+		reload()
+	}
+}
+
+// modTime returns a file's modification time (zero if unreadable)
+func modTime(path string) time.Time {
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"fmt"
+	"github.com/faanross/simulacra_txt/internal/chunker"
+	dnsserver "github.com/faanross/simulacra_txt/internal/dns-server"
+	"github.com/miekg/dns"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// ================================================================================
+// ZONES
+// Several domains on one server, routed by query name or ?zone=
+// ================================================================================
+
+// zoneSet is every zone a server answers for, the -domain zone first. It
+// is filled before the server starts and only read afterwards.
+type zoneSet struct {
+	zones []*DNSServerV2
+}
+
+// ForName returns the zone a query name belongs to: the one with the
+// longest matching domain, or the -domain zone (which refuses it, or
+// answers with decoys)
+func (zs *zoneSet) ForName(name string) *DNSServerV2 {
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	best, bestLen := zs.zones[0], 0
+	for _, zone := range zs.zones {
+		if (name == zone.domain || strings.HasSuffix(name, "."+zone.domain)) && len(zone.domain) > bestLen {
+			best, bestLen = zone, len(zone.domain)
+		}
+	}
+	return best
+}
+
+// ForRequest returns the zone an API request names with ?zone= (the
+// -domain zone if it names none, or the server has no others)
+func (zs *zoneSet) ForRequest(r *http.Request) (*DNSServerV2, error) {
+	domain := strings.ToLower(strings.TrimSuffix(r.URL.Query().Get("zone"), "."))
+	if domain == "" || len(zs.zones) == 1 {
+		return zs.zones[0], nil
+	}
+	for _, zone := range zs.zones {
+		if zone.domain == domain {
+			return zone, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown zone %q", domain)
+}
+
+// keyed reports whether every zone's HTTP API needs keys
+func (zs *zoneSet) keyed() bool {
+	for _, zone := range zs.zones {
+		if zone.keys.Load() == nil {
+			return false
+		}
+	}
+	return true
+}
+
+// ServeDNS hands a query to the zone of its first question
+func (zs *zoneSet) ServeDNS(w dns.ResponseWriter, r *dns.Msg) {
+	zone := zs.zones[0]
+	if len(r.Question) > 0 {
+		zone = zs.ForName(r.Question[0].Name)
+	}
+	zone.handleDNSRequest(w, r)
+}
+
+// GetStats adds up the storage figures of every zone, for metrics
+func (zs *zoneSet) GetStats() dnsserver.StorageStats {
+	var total dnsserver.StorageStats
+	for _, zone := range zs.zones {
+		stats := zone.storage.GetStats()
+		total.TotalMessages += stats.TotalMessages
+		total.NewMessages += stats.NewMessages
+		total.Delivered += stats.Delivered
+		total.Consumed += stats.Consumed
+		total.TotalChunks += stats.TotalChunks
+		total.MemoryUsage += stats.MemoryUsage
+		total.StoredBytes += stats.StoredBytes
+		total.Evictions += stats.Evictions
+		total.EvictedBytes += stats.EvictedBytes
+	}
+	return total
+}
+
+// PrintStats prints every zone's storage statistics
+func (zs *zoneSet) PrintStats() {
+	for _, zone := range zs.zones {
+		zone.PrintStats()
+	}
+}
+
+// zoned routes an API request to the zone it names, authorized with that
+// zone's keys
+func (s *DNSServerV2) zoned(scope string, handler func(*DNSServerV2, http.ResponseWriter, *http.Request)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		zone, err := s.zones.ForRequest(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		dnsserver.LimitBody(w, r)
+		zone.authorize(scope, func(w http.ResponseWriter, r *http.Request) {
+			handler(zone, w, r)
+		})(w, r)
+	}
+}
+
+// zoneOptions are the flags every zone follows, each with its own copy
+type zoneOptions struct {
+	persistent       bool // JSON data file (dns_data.json)
+	journalSync      bool // fsync the JSON journal after each write
+	snapshotInterval time.Duration
+	dbFile           string // Database file ("" = none)
+	memoryLimit      int64  // RAM for message data before spilling (0 = unbounded)
+	spillDir         string
+	apiKeys          string        // -api-keys file, for zones without their own
+	qnameUploads     bool          // Accept QNAME uploads
+	qnameKey         string        // -qname-key, for zones without their own
+	dailyQuota       int64         // Data per client per day (0 = unlimited)
+	consumeBatch     int           // Messages per discovery query (0 = all)
+	visibility       time.Duration // Default visibility timeout (0 = none)
+	retention        dnsserver.RetentionPolicy
+}
+
+// AddZone starts serving another zone: its own storage namespace, queue,
+// names, API keys, TTLs and authority, sharing everything else with s
+func (s *DNSServerV2) AddZone(config dnsserver.ZoneConfig, options zoneOptions) (*DNSServerV2, error) {
+	storage, err := openStorage(options.persistent, options.dbFile, config.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	zone := &DNSServerV2{
+		domain:        config.Domain,
+		addr:          s.addr,
+		storage:       storage,
+		queue:         dnsserver.NewQueueManager(storage),
+		lookups:       storage,
+		limiter:       s.limiter,
+		caps:          s.caps,
+		ttl:           s.ttl,
+		started:       s.started,
+		rates:         s.rates,
+		metrics:       s.metrics,
+		validator:     s.validator,
+		names:         make(map[string]string),
+		receipts:      make(map[string]string),
+		presence:      dnsserver.NewPresenceTracker(),
+		uploads:       dnsserver.NewUploadAssembler(dnsserver.DEFAULT_UPLOAD_TIMEOUT, dnsserver.DEFAULT_MAX_PENDING_UPLOADS),
+		signals:       s.signals,
+		acl:           s.acl,
+		decoyKey:      s.decoyKey,
+		decoys:        s.decoys,
+		clientSources: s.clientSources,
+		transfers:     s.transfers,
+		zones:         s.zones,
+	}
+	if limited, ok := s.lookups.(*dnsserver.LimitedStorage); ok {
+		zone.lookups = limited.Share(storage)
+	}
+	if options.memoryLimit > 0 {
+		if err := zone.SetMemoryLimit(options.memoryLimit, filepath.Join(options.spillDir, config.Storage)); err != nil {
+			return nil, fmt.Errorf("zone %s: %w", config.Domain, err)
+		}
+	}
+	if options.retention.Enabled() {
+		if err := zone.SetRetention(options.retention); err != nil {
+			return nil, fmt.Errorf("zone %s: %w", config.Domain, err)
+		}
+	}
+	if fs, ok := storage.(*dnsserver.FileStorage); ok {
+		fs.SetJournalSync(options.journalSync)
+		fs.StartSnapshots(options.snapshotInterval)
+	}
+
+	if config.TTL != "" {
+		if zone.ttl, err = chunker.ParseTTLPolicy(config.TTL); err != nil {
+			return nil, fmt.Errorf("zone %s: invalid TTL: %w", config.Domain, err)
+		}
+	}
+	if err := zone.SetAuthority(config.NS, config.SOA); err != nil {
+		return nil, fmt.Errorf("zone %s: %w", config.Domain, err)
+	}
+	if config.APIKeys == "" {
+		config.APIKeys = options.apiKeys
+	}
+	if config.APIKeys != "" {
+		if err := zone.LoadKeys(config.APIKeys); err != nil {
+			return nil, fmt.Errorf("zone %s: %w", config.Domain, err)
+		}
+	}
+	if config.QNameKey == "" {
+		config.QNameKey = options.qnameKey
+	}
+	if options.qnameUploads {
+		if err := zone.EnableQNameUploads(config.QNameKey); err != nil {
+			return nil, err
+		}
+	} else if config.QNameKey != "" {
+		return nil, fmt.Errorf("zone %s: qname_key needs -qname-uploads", config.Domain)
+	}
+	zone.queue.SetMaxBatch(options.consumeBatch)
+	zone.queue.SetVisibilityTimeout(options.visibility)
+	if options.dailyQuota > 0 {
+		zone.quota = dnsserver.NewQuotaTracker(storage, options.dailyQuota)
+		zone.quota.Start(dnsserver.QUOTA_FLUSH_INTERVAL)
+	}
+
+	zone.rebuildNameIndex()
+	s.zones.zones = append(s.zones.zones, zone)
+	return zone, nil
+}
+
+// sharedPointer returns a new atomic pointer, for state zones share and
+// reload together
+func sharedPointer[T any]() *atomic.Pointer[T] {
+	return new(atomic.Pointer[T])
+}
@@ -4,7 +4,9 @@ import (
 	"bytes"
 	"flag"
 	"fmt"
+	"github.com/faanross/simulacra_txt/internal/config"
 	"github.com/faanross/simulacra_txt/internal/encoder"
+	"github.com/faanross/simulacra_txt/internal/kdf"
 	"github.com/faanross/simulacra_txt/internal/scrypto"
 	"github.com/faanross/simulacra_txt/internal/spec"
 	"image/png"
@@ -21,8 +23,18 @@ func main() {
 	compress := flag.Bool("compress", true, "Enable compression")
 	password := flag.String("password", "", "Password (prompt if not provided)")
 	analyze := flag.Bool("analyze", false, "Show security analysis")
-
+	maxUtil := flag.Float64("max-util", 0, "Enlarge the image so the payload uses at most this fraction of its LSBs (e.g. 0.5)")
+	kdfName := flag.String("kdf", "pbkdf2", "Key derivation function (pbkdf2, argon2id)")
+	kdfIters := flag.Uint("kdf-iters", 0, "PBKDF2 iterations or Argon2id passes (0 = default)")
+	kdfMemory := flag.Uint("kdf-memory", 0, "Argon2id memory in MiB (0 = default 64)")
+	kdfThreads := flag.Uint("kdf-threads", 0, "Argon2id parallelism (0 = default 4)")
+	cover := flag.String("cover", string(encoder.COVER_NOISE), "Cover image style ("+strings.Join(encoder.CoverStyleNames(), ", ")+")")
+
+	flag.String(config.FLAG_NAME, "", config.USAGE)
 	flag.Parse()
+	if _, err := config.Apply(flag.CommandLine, "encoder"); err != nil {
+		log.Fatalf("❌ Invalid configuration: %v", err)
+	}
 
 	// Validate input
 	if *inputFile == "" {
@@ -40,6 +52,23 @@ func main() {
 
 	fmt.Printf("\n📄 Input file: %s (%d bytes)\n", *inputFile, len(message))
 
+	// Resolve KDF settings before asking for a password
+	algorithm, err := kdf.ParseAlgorithm(*kdfName)
+	if err != nil {
+		log.Fatalf("❌ %v", err)
+	}
+	if *kdfThreads > 255 {
+		log.Fatal("❌ -kdf-threads must be at most 255")
+	}
+	kdfParams, err := kdf.ForAlgorithm(algorithm, uint32(*kdfIters), uint32(*kdfMemory)*1024, uint8(*kdfThreads))
+	if err != nil {
+		log.Fatalf("❌ Invalid KDF settings: %v", err)
+	}
+	coverStyle, err := encoder.ParseCoverStyle(*cover)
+	if err != nil {
+		log.Fatalf("❌ %v", err)
+	}
+
 	// Get password
 	var pass []byte
 	if *password != "" {
@@ -66,6 +95,11 @@ func main() {
 
 	// Create secure encoder
 	stegoEncoder := encoder.NewSecureStegoEncoder(message, pass, *width, *compress)
+	stegoEncoder.SetMaxUtilization(*maxUtil)
+	if err := stegoEncoder.SetKDF(kdfParams); err != nil {
+		log.Fatalf("❌ Invalid KDF settings: %v", err)
+	}
+	stegoEncoder.SetCoverStyle(coverStyle)
 
 	// Generate secure stego image
 	img, err := stegoEncoder.CreateStegoImage()
@@ -92,6 +126,10 @@ func main() {
 
 	fmt.Printf("\n✅ Secure steganography complete!\n")
 	fmt.Printf("   Output: %s\n", *outputFile)
-	fmt.Printf("   Security: AES-256-GCM + PBKDF2-%d\n", spec.PBKDF2_ITERS)
+	fmt.Printf("   Security: AES-256-GCM + %s\n", kdfParams)
+	if report := stegoEncoder.Capacity(); report.ShouldWarn() {
+		fmt.Printf("   ⚠️  Carrier is %.0f%% full (risk: %s) - use -max-util %.1f to lower detectability\n",
+			report.Utilization*100, report.RiskLevel(), encoder.RISK_WARN_UTILIZATION)
+	}
 	fmt.Printf("\n🔓 To decode: Use the secure decoder with the same password\n")
 }
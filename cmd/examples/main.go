@@ -0,0 +1,335 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"github.com/faanross/simulacra_txt/internal/chunker"
+	"github.com/faanross/simulacra_txt/internal/config"
+	"github.com/faanross/simulacra_txt/internal/decoder"
+	dnsserver "github.com/faanross/simulacra_txt/internal/dns-server"
+	"github.com/faanross/simulacra_txt/internal/encoder"
+	"github.com/miekg/dns"
+	"image"
+	"image/png"
+	"log"
+	"math/rand"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// ================================================================================
+// LIBRARY EXAMPLES
+// Runs the whole pipeline in one process with generated data, printing the
+// calls each step makes - executable documentation for library users
+// ================================================================================
+
+// LESSON: The Pipeline in Five Calls
+// Every command in this repo is a thin wrapper around the same steps:
+// 1. encoder.NewSecureStegoEncoder -> CreateStegoImage (hide + encrypt)
+// 2. chunker.NewChunker -> ChunkFile (fragment into TXT-sized chunks)
+// 3. dnsserver.QueueManager -> PublishMessage (store for serving)
+// 4. DNS queries for the manifest and each chunk -> ReassemblySession
+// 5. decoder.NewSecureStegoDecoder -> DecryptPayload (extract + decrypt)
+// Here the server is a miekg/dns listener on a loopback port backed by
+// MemoryStorage, so nothing touches the disk or the network.
+
+const (
+	EXAMPLE_DOMAIN   = "example.test"
+	EXAMPLE_PASSWORD = "correct horse battery staple"
+)
+
+// words feed the generated message
+var words = strings.Fields("dns txt record chunk carrier pixel cipher nonce salt manifest resolver label zone query answer parity")
+
+// step prints a numbered example heading
+func step(n int, title string) {
+	fmt.Printf("\n📘 Example %d: %s\n", n, title)
+}
+
+// call prints a library call the example makes
+func call(format string, args ...interface{}) {
+	fmt.Printf("   → %s\n", fmt.Sprintf(format, args...))
+}
+
+// quietly runs fn with stdout discarded unless verbose is set.
+// The encoder and decoder report progress on stdout.
+func quietly(verbose bool, fn func() error) error {
+	if verbose {
+		return fn()
+	}
+
+	devNull, err := os.Open(os.DevNull)
+	if err != nil {
+		return fn()
+	}
+	defer devNull.Close()
+
+	stdout := os.Stdout
+	os.Stdout = devNull
+	defer func() { os.Stdout = stdout }()
+
+	return fn()
+}
+
+// generateMessage returns size bytes of readable text
+func generateMessage(size int) []byte {
+	rng := rand.New(rand.NewSource(time.Now().UnixNano()))
+
+	var text strings.Builder
+	for text.Len() < size {
+		text.WriteString(words[rng.Intn(len(words))])
+		text.WriteByte(' ')
+	}
+	return []byte(text.String()[:size])
+}
+
+// serve starts an in-process DNS server answering from storage
+func serve(storage *dnsserver.MemoryStorage, msgID string) (*dns.Server, string, error) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		return nil, "", err
+	}
+
+	suffix := ".data." + EXAMPLE_DOMAIN + "."
+	handler := dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		reply := new(dns.Msg)
+		reply.SetReply(r)
+		reply.Authoritative = true
+
+		for _, q := range r.Question {
+			label := strings.TrimSuffix(strings.ToLower(q.Name), suffix)
+
+			var value string
+			if strings.HasPrefix(label, "m-") {
+				if msg, err := storage.GetMessage(msgID); err == nil {
+					value = msg.Manifest
+				}
+			} else {
+				value, _ = storage.GetChunk(msgID, label)
+			}
+
+			if value == "" {
+				reply.Rcode = dns.RcodeNameError
+				continue
+			}
+			reply.Answer = append(reply.Answer, &dns.TXT{
+				Hdr: dns.RR_Header{Name: q.Name, Rrtype: dns.TypeTXT, Class: dns.ClassINET, Ttl: 300},
+				Txt: chunker.SplitTXT(value),
+			})
+		}
+
+		w.WriteMsg(reply)
+	})
+
+	started := make(chan struct{})
+	server := &dns.Server{PacketConn: conn, Handler: handler, NotifyStartedFunc: func() { close(started) }}
+	go server.ActivateAndServe()
+	<-started
+
+	return server, conn.LocalAddr().String(), nil
+}
+
+// query fetches one TXT record, joining multi-string answers
+func query(client *dns.Client, server, name string) (string, error) {
+	m := new(dns.Msg)
+	m.SetQuestion(dns.Fqdn(name), dns.TypeTXT)
+	m.SetEdns0(chunker.EDNS0_BUFFER_SIZE, false)
+
+	resp, _, err := client.Exchange(m, server)
+	if err != nil {
+		return "", err
+	}
+	for _, ans := range resp.Answer {
+		if txt, ok := ans.(*dns.TXT); ok {
+			return chunker.JoinTXT(txt.Txt), nil
+		}
+	}
+	return "", fmt.Errorf("no TXT answer for %s", name)
+}
+
+func main() {
+	size := flag.Int("size", 2048, "Bytes of generated message text")
+	fec := flag.Float64("fec", 0.25, "Reed-Solomon redundancy for the chunking example")
+	drop := flag.Int("drop", 1, "Chunks to lose in transit (recovered from parity)")
+	verbose := flag.Bool("verbose", false, "Show the libraries' own progress output")
+	flag.String(config.FLAG_NAME, "", config.USAGE)
+	flag.Parse()
+	if _, err := config.Apply(flag.CommandLine, "examples"); err != nil {
+		log.Fatalf("❌ Invalid configuration: %v", err)
+	}
+
+	fmt.Println("📚 SIMULACRA LIBRARY EXAMPLES")
+	fmt.Println("=" + strings.Repeat("=", 40))
+
+	message := generateMessage(*size)
+	password := []byte(EXAMPLE_PASSWORD)
+	fmt.Printf("\n📝 Generated message: %d bytes (%q...)\n", len(message), message[:min(40, len(message))])
+
+	// Example 1: hide the message in a carrier image
+	step(1, "Encode a stego image")
+	call("encoder.NewSecureStegoEncoder(message, password, 64, true)")
+	call("(*SecureStegoEncoder).SetCoverStyle(encoder.COVER_GRADIENT)")
+	call("(*SecureStegoEncoder).CreateStegoImage()")
+	call("png.Encode(&buffer, img)")
+
+	var carrier bytes.Buffer
+	err := quietly(*verbose, func() error {
+		stegoEncoder := encoder.NewSecureStegoEncoder(message, password, 64, true)
+		if err := stegoEncoder.SetCoverStyle(encoder.COVER_GRADIENT); err != nil {
+			return err
+		}
+		img, err := stegoEncoder.CreateStegoImage()
+		if err != nil {
+			return err
+		}
+		return png.Encode(&carrier, img)
+	})
+	if err != nil {
+		log.Fatalf("❌ Encoding failed: %v", err)
+	}
+	fmt.Printf("   ✅ Carrier: %d PNG bytes\n", carrier.Len())
+
+	// Example 2: fragment the carrier into DNS-sized chunks
+	step(2, "Chunk the carrier for TXT records")
+	call("chunker.NewChunker(chunker.ChunkerConfig{AddRedundancy: true, Redundancy: %.2f, Shuffle: true})", *fec)
+	call("(*Chunker).ChunkFile(carrier, \"example.png\", \"\")")
+
+	chk := chunker.NewChunker(chunker.ChunkerConfig{
+		AddRedundancy: *fec > 0,
+		Redundancy:    *fec,
+		Shuffle:       true,
+		Logger:        chunker.DiscardLogger,
+	})
+	msg, err := chk.ChunkFile(carrier.Bytes(), "example.png", "")
+	if err != nil {
+		log.Fatalf("❌ Chunking failed: %v", err)
+	}
+	msgID := fmt.Sprintf("%x", msg.ID[:8])
+	fmt.Printf("   ✅ %d chunks (%s), message %s\n", len(msg.Chunks), chk.Profile().Name, msgID)
+	fmt.Printf("   ✅ Extended header: %s\n", msg.Info)
+
+	// Example 3: publish to storage and serve it over DNS
+	step(3, "Publish and serve in memory")
+	call("chunker.DNSManifest{...}.Value()")
+	call("dnsserver.NewQueueManager(dnsserver.NewMemoryStorage())")
+	call("(*QueueManager).PublishMessage(id, chunks, manifest)")
+
+	manifest := &chunker.DNSManifest{
+		MessageID:   msgID,
+		TotalChunks: len(msg.Chunks),
+		Timestamp:   msg.CreatedAt,
+		Checksum:    chunker.MessageDigest(msg.Data),
+		Domain:      EXAMPLE_DOMAIN,
+	}
+	// Chunks are stored under the first label of their name, like dns-server does
+	key := func(i int) string {
+		label, _, _ := strings.Cut(manifest.ChunkName(i), ".")
+		return label
+	}
+	chunks := make(map[string]string, len(msg.Chunks))
+	for i, chunk := range msg.Chunks {
+		chunks[key(i)] = chunk.Encoded
+	}
+
+	// Lose some chunks on the way, as DNS sometimes does
+	lost := 0
+	for i := 0; i < *drop && i < len(msg.Chunks); i++ {
+		if name := key(i * 3 % len(msg.Chunks)); chunks[name] != "" {
+			delete(chunks, name)
+			lost++
+		}
+	}
+
+	storage := dnsserver.NewMemoryStorage()
+	queue := dnsserver.NewQueueManager(storage)
+	if err := queue.PublishMessage(msgID, chunks, manifest.Value()); err != nil {
+		log.Fatalf("❌ Publish failed: %v", err)
+	}
+
+	server, addr, err := serve(storage, msgID)
+	if err != nil {
+		log.Fatalf("❌ Cannot start DNS listener: %v", err)
+	}
+	defer server.Shutdown()
+	fmt.Printf("   ✅ Serving %d chunks on %s (%d withheld to simulate loss)\n", len(chunks), addr, lost)
+
+	// Example 4: fetch and reassemble like a receiver
+	step(4, "Retrieve over DNS")
+	call("dns query m-%s.data.%s TXT", msgID, EXAMPLE_DOMAIN)
+	call("chunker.ParseManifest(value, id, domain)")
+	call("(*Chunker).NewReassemblySession() + AddEncoded(chunk) per answer")
+	call("(*ReassemblySession).FinalizeFile()")
+	call("(*DNSManifest).VerifyData(data)")
+
+	client := &dns.Client{Timeout: 2 * time.Second}
+	value, err := query(client, addr, fmt.Sprintf("m-%s.data.%s", msgID, EXAMPLE_DOMAIN))
+	if err != nil {
+		log.Fatalf("❌ Manifest query failed: %v", err)
+	}
+	names, err := chunker.ParseManifest(value, msgID, EXAMPLE_DOMAIN)
+	if err != nil {
+		log.Fatalf("❌ Invalid manifest: %v", err)
+	}
+
+	session := chunker.NewChunker(chunker.ChunkerConfig{Logger: chunker.DiscardLogger}).NewReassemblySession()
+	missing := 0
+	for i := 0; i < names.TotalChunks; i++ {
+		encoded, err := query(client, addr, names.ChunkName(i))
+		if err != nil {
+			missing++
+			continue
+		}
+		if _, err := session.AddEncoded(encoded); err != nil {
+			log.Fatalf("❌ Chunk %d rejected: %v", i, err)
+		}
+	}
+
+	retrieved, info, err := session.FinalizeFile()
+	if err != nil {
+		log.Fatalf("❌ Reassembly failed: %v", err)
+	}
+	if err := names.VerifyData(retrieved); err != nil {
+		log.Fatalf("❌ %v", err)
+	}
+	fmt.Printf("   ✅ %d/%d chunks answered, %d bytes reassembled\n", names.TotalChunks-missing, names.TotalChunks, len(retrieved))
+	fmt.Printf("   ✅ %s, SHA-256 verified against the header and the manifest\n", info)
+
+	// Example 5: extract the hidden message
+	step(5, "Decode the stego image")
+	call("png.Decode(bytes.NewReader(retrieved))")
+	call("decoder.NewSecureStegoDecoder(img, password)")
+	call("(*SecureStegoDecoder).ExtractBitStream() + ExtractSecurePayload()")
+	call("(*SecureStegoDecoder).DecryptPayload()")
+
+	var recovered []byte
+	err = quietly(*verbose, func() error {
+		img, _, err := image.Decode(bytes.NewReader(retrieved))
+		if err != nil {
+			return err
+		}
+		stegoDecoder := decoder.NewSecureStegoDecoder(img, password)
+		stegoDecoder.ExtractBitStream()
+		if err := stegoDecoder.ExtractSecurePayload(); err != nil {
+			return err
+		}
+		result, err := stegoDecoder.DecryptPayload()
+		if err != nil {
+			return err
+		}
+		recovered = result.Message
+		return nil
+	})
+	if err != nil {
+		log.Fatalf("❌ Decoding failed: %v", err)
+	}
+
+	if !bytes.Equal(recovered, message) {
+		log.Fatal("❌ Recovered message differs from the original")
+	}
+	fmt.Printf("   ✅ Recovered %d bytes, identical to the original\n", len(recovered))
+
+	fmt.Println("\n🎉 All examples passed")
+}
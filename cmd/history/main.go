@@ -0,0 +1,161 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/faanross/simulacra_txt/internal/clientstate"
+	"github.com/faanross/simulacra_txt/internal/config"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+// ================================================================================
+// TRANSFER HISTORY - Prints and exports client-side transfer statistics
+// ================================================================================
+
+func main() {
+	format := flag.String("format", "table", "Output format (table, json or csv)")
+	output := flag.String("output", "", "Export to file instead of stdout")
+	direction := flag.String("direction", "", "Filter by direction (upload or download)")
+	last := flag.Int("last", 0, "Only show the N most recent transfers")
+	flag.String(config.FLAG_NAME, "", config.USAGE)
+	flag.Parse()
+	if _, err := config.Apply(flag.CommandLine, "history"); err != nil {
+		log.Fatalf("❌ Invalid configuration: %v", err)
+	}
+
+	records, err := clientstate.LoadHistory()
+	if err != nil {
+		log.Fatalf("❌ Failed to load history: %v", err)
+	}
+
+	// Apply filters
+	var filtered []clientstate.TransferRecord
+	for _, record := range records {
+		if *direction != "" && record.Direction != *direction {
+			continue
+		}
+		filtered = append(filtered, record)
+	}
+	if *last > 0 && len(filtered) > *last {
+		filtered = filtered[len(filtered)-*last:]
+	}
+
+	var out io.Writer = os.Stdout
+	if *output != "" {
+		file, err := os.Create(*output)
+		if err != nil {
+			log.Fatalf("❌ Cannot create output file: %v", err)
+		}
+		defer file.Close()
+		out = file
+	}
+
+	switch *format {
+	case "json":
+		err = writeJSON(out, filtered)
+	case "csv":
+		err = writeCSV(out, filtered)
+	case "table":
+		writeTable(out, filtered)
+	default:
+		log.Fatalf("❌ Unknown format: %s", *format)
+	}
+
+	if err != nil {
+		log.Fatalf("❌ Export failed: %v", err)
+	}
+
+	if *output != "" {
+		fmt.Printf("💾 Exported %d transfers to %s\n", len(filtered), *output)
+	}
+}
+
+// writeTable prints a human-readable summary of transfers
+func writeTable(out io.Writer, records []clientstate.TransferRecord) {
+	fmt.Fprintln(out, "\n📜 TRANSFER HISTORY")
+	fmt.Fprintln(out, strings.Repeat("-", 100))
+	fmt.Fprintf(out, "%-19s  %-8s  %-16s  %7s  %10s  %6s  %6s  %7s  %10s\n",
+		"Started", "Dir", "Message", "Status", "Duration", "Chunks", "Failed", "Retries", "KB/s")
+	fmt.Fprintln(out, strings.Repeat("-", 100))
+
+	var totalBytes int
+	var totalDuration time.Duration
+	var totalRetries, failures int
+
+	for _, r := range records {
+		status := "ok"
+		if !r.Success {
+			status = "FAILED"
+			failures++
+		}
+
+		fmt.Fprintf(out, "%-19s  %-8s  %-16s  %7s  %10s  %6d  %6d  %7d  %10.2f\n",
+			r.StartedAt.Format("2006-01-02 15:04:05"),
+			r.Direction,
+			r.MessageID,
+			status,
+			r.Duration.Round(time.Millisecond),
+			r.TotalChunks,
+			r.FailedChunks,
+			r.Retries,
+			r.Throughput()/1024)
+
+		totalBytes += r.Bytes
+		totalDuration += r.Duration
+		totalRetries += r.Retries
+	}
+
+	fmt.Fprintln(out, strings.Repeat("-", 100))
+	fmt.Fprintf(out, "Transfers: %d | Failed: %d | Retries: %d", len(records), failures, totalRetries)
+	if totalDuration > 0 {
+		fmt.Fprintf(out, " | Avg throughput: %.2f KB/s", float64(totalBytes)/1024/totalDuration.Seconds())
+	}
+	fmt.Fprintln(out)
+}
+
+// writeJSON exports transfers as a JSON array
+func writeJSON(out io.Writer, records []clientstate.TransferRecord) error {
+	enc := json.NewEncoder(out)
+	enc.SetIndent("", "  ")
+	return enc.Encode(records)
+}
+
+// writeCSV exports transfers as CSV with a header row
+func writeCSV(out io.Writer, records []clientstate.TransferRecord) error {
+	w := csv.NewWriter(out)
+
+	header := []string{"started_at", "direction", "message_id", "server", "success",
+		"duration_ms", "bytes", "total_chunks", "failed_chunks", "retries", "throughput_bps", "error"}
+	if err := w.Write(header); err != nil {
+		return err
+	}
+
+	for _, r := range records {
+		row := []string{
+			r.StartedAt.Format(time.RFC3339),
+			r.Direction,
+			r.MessageID,
+			r.Server,
+			fmt.Sprintf("%v", r.Success),
+			fmt.Sprintf("%d", r.Duration.Milliseconds()),
+			fmt.Sprintf("%d", r.Bytes),
+			fmt.Sprintf("%d", r.TotalChunks),
+			fmt.Sprintf("%d", r.FailedChunks),
+			fmt.Sprintf("%d", r.Retries),
+			fmt.Sprintf("%.2f", r.Throughput()),
+			r.Error,
+		}
+		if err := w.Write(row); err != nil {
+			return err
+		}
+	}
+
+	w.Flush()
+	return w.Error()
+}
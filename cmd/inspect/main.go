@@ -0,0 +1,136 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/faanross/simulacra_txt/internal/config"
+	"github.com/faanross/simulacra_txt/internal/decoder"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	"image/png"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ================================================================================
+// BIT-PLANE INSPECTOR
+// Renders an image's bit planes and reports entropy per region, to debug
+// embedding and to vet third-party carriers before trusting them
+// ================================================================================
+
+func main() {
+	inputFile := flag.String("input", "", "Image to inspect (PNG, JPEG or GIF)")
+	outputFile := flag.String("output", "", "Rendered bit plane PNG (default: <input>_bit<N>.png)")
+	bit := flag.Uint("bit", 0, "Bit plane to inspect (0 = LSB ... 7 = MSB)")
+	channelName := flag.String("channel", string(decoder.PLANE_RGB), "Channels to inspect (rgb, r, g, b)")
+	grid := flag.Int("grid", 4, "Regions per side for the entropy report")
+	flag.String(config.FLAG_NAME, "", config.USAGE)
+	flag.Parse()
+	if _, err := config.Apply(flag.CommandLine, "inspect"); err != nil {
+		log.Fatalf("❌ Invalid configuration: %v", err)
+	}
+
+	if *inputFile == "" {
+		log.Fatal("❌ Please provide an image with -input")
+	}
+	if *bit > 7 {
+		log.Fatal("❌ -bit must be between 0 and 7")
+	}
+	channel, err := decoder.ParsePlaneChannel(*channelName)
+	if err != nil {
+		log.Fatalf("❌ %v", err)
+	}
+
+	file, err := os.Open(*inputFile)
+	if err != nil {
+		log.Fatalf("❌ Error opening file: %v", err)
+	}
+	img, format, err := image.Decode(file)
+	file.Close()
+	if err != nil {
+		log.Fatalf("❌ Error decoding image: %v", err)
+	}
+
+	bounds := img.Bounds()
+	fmt.Println("\n🔬 BIT-PLANE INSPECTOR")
+	fmt.Printf("   File: %s (%s, %dx%d)\n", *inputFile, format, bounds.Dx(), bounds.Dy())
+	fmt.Printf("   Plane: bit %d, channels %s\n", *bit, channel)
+	if format == "jpeg" {
+		fmt.Println("   ⚠️  JPEG is lossy: its low bit planes can't carry LSB payloads")
+	}
+
+	// Render the plane
+	if *outputFile == "" {
+		base := strings.TrimSuffix(*inputFile, filepath.Ext(*inputFile))
+		*outputFile = fmt.Sprintf("%s_bit%d.png", base, *bit)
+	}
+	out, err := os.Create(*outputFile)
+	if err != nil {
+		log.Fatalf("❌ Cannot create output file: %v", err)
+	}
+	if err := png.Encode(out, decoder.RenderBitPlane(img, *bit, channel)); err != nil {
+		out.Close()
+		log.Fatalf("❌ PNG encoding failed: %v", err)
+	}
+	out.Close()
+	fmt.Printf("   🖼️  Plane rendered to: %s\n", *outputFile)
+
+	// Entropy per region
+	regions := decoder.PlaneEntropy(img, *bit, channel, *grid, *grid)
+	printRegions(regions)
+}
+
+// printRegions prints byte entropy as a grid, then the flagged regions
+func printRegions(regions []decoder.RegionStats) {
+	fmt.Printf("\n📊 Byte entropy per region (max 8.0, * = stands out):\n")
+
+	cols := 0
+	for _, r := range regions {
+		if r.Bounds.Min.Y == regions[0].Bounds.Min.Y {
+			cols++
+		}
+	}
+
+	for i, r := range regions {
+		if i%cols == 0 {
+			fmt.Print("   ")
+		}
+		marker := " "
+		if r.Suspicious {
+			marker = "*"
+		}
+		fmt.Printf("%6.3f%s", r.ByteEntropy, marker)
+		if i%cols == cols-1 {
+			fmt.Println()
+		}
+	}
+
+	flagged := 0
+	lowest, highest := regions[0], regions[0]
+	for _, r := range regions {
+		if r.Suspicious {
+			flagged++
+		}
+		if r.ByteEntropy < lowest.ByteEntropy {
+			lowest = r
+		}
+		if r.ByteEntropy > highest.ByteEntropy {
+			highest = r
+		}
+	}
+
+	fmt.Printf("\n   Lowest:  %v entropy %.3f, %.1f%% ones\n", lowest.Bounds, lowest.ByteEntropy, lowest.Ones*100)
+	fmt.Printf("   Highest: %v entropy %.3f, %.1f%% ones\n", highest.Bounds, highest.ByteEntropy, highest.Ones*100)
+
+	if flagged == 0 {
+		fmt.Println("\n   ✅ Uniform plane - no region stands out")
+		return
+	}
+
+	fmt.Printf("\n   ⚠️  %d/%d regions differ from the median by more than %.0f%%\n",
+		flagged, len(regions), decoder.REGION_ENTROPY_DEVIATION*100)
+	fmt.Println("      A sharp boundary often marks where an embedded payload ends")
+}
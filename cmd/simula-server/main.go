@@ -1,101 +1,197 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
+	"github.com/faanross/simulacra_txt/internal/chunker"
+	"github.com/faanross/simulacra_txt/internal/config"
 	dnsserver "github.com/faanross/simulacra_txt/internal/dns-server"
+	"github.com/faanross/simulacra_txt/internal/logging"
 	"github.com/miekg/dns"
+	"io"
 	"log"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
 )
 
-var totalDuration int = 26
+// DEFAULT_DURATION is how long a simulation runs without -duration or a
+// scenario duration
+const DEFAULT_DURATION = 26 * time.Hour
+
+// DNS_SHUTDOWN_TIMEOUT bounds the wait for queries in flight at shutdown
+const DNS_SHUTDOWN_TIMEOUT = 5 * time.Second
+
+// Loggers of the simulation's components (see internal/logging)
+var (
+	simLog  = logging.For("simulation")
+	httpLog = logging.For("http")
+	dnsLog  = logging.For("dns")
+)
 
 // SimulationServer wraps DNS server for 24-hour simulation
 type SimulationServer struct {
 	domain    string
 	dnsAddr   string
-	httpPort  string
+	http      dnsserver.HTTPConfig // Where the HTTP API listens
+	keys      *dnsserver.KeyRing   // HTTP API keys (nil = open to anyone who can connect)
 	storage   dnsserver.Storage
 	queue     *dnsserver.QueueManager
 	startTime time.Time
-	logFile   *os.File
+	duration  time.Duration // Run length (0 = until interrupted)
+
+	scenario   *Scenario // Expected outcome, judged at shutdown (nil = none)
+	metrics    *simMetrics
+	exported   *dnsserver.ServerMetrics // Served at /metrics
+	httpServer *http.Server             // Stopped gracefully at shutdown
+	dnsServer  *dns.Server              // Stopped gracefully at shutdown
+	logs       io.Closer                // Log file, closed last at shutdown
 }
 
 // NewSimulationServer creates the simulation server
 func NewSimulationServer() *SimulationServer {
-	// Create log file for trace analysis
-	logFile, err := os.Create(fmt.Sprintf("simulation_server_%s.log",
-		time.Now().Format("20060102_150405")))
-	if err != nil {
-		log.Fatal("Failed to create log file:", err)
-	}
-
 	// Use persistent storage so state survives if we need to restart
 	storage, err := dnsserver.NewFileStorage("simulation_state.json")
 	if err != nil {
 		log.Fatal("Failed to create storage:", err)
 	}
+	storage.StartSnapshots(dnsserver.DEFAULT_SNAPSHOT_INTERVAL)
 
 	return &SimulationServer{
 		domain:    "covert.example.com",
 		dnsAddr:   ":5555",
-		httpPort:  "8080",
+		http:      dnsserver.HTTPConfig{Addr: dnsserver.DEFAULT_HTTP_ADDR},
 		storage:   storage,
 		queue:     dnsserver.NewQueueManager(storage),
 		startTime: time.Now(),
-		logFile:   logFile,
+		duration:  DEFAULT_DURATION,
+		metrics:   newSimMetrics(),
+		exported:  dnsserver.NewServerMetrics(storage),
 	}
 }
 
-// Start begins the simulation server
-func (s *SimulationServer) Start() {
-	s.log("SIMULATION", fmt.Sprintf("Server starting for %d-hour simulation", totalDuration))
-	s.log("CONFIG", fmt.Sprintf("DNS: %s, HTTP: %s, Domain: %s",
-		s.dnsAddr, s.httpPort, s.domain))
+// Run serves the simulation until its duration is up or ctx is cancelled
+// (SIGINT/SIGTERM), then shuts down; it reports whether the run passed
+func (s *SimulationServer) Run(ctx context.Context) bool {
+	simLog.Info("Server starting", "duration", s.describeDuration())
+	simLog.Info("Config", "dns", s.dnsAddr, "http", s.http.String(), "domain", s.domain)
+	if s.scenario != nil {
+		expect, _ := json.Marshal(s.scenario.Expect)
+		simLog.Info("Scenario", "name", s.scenario.Name, "expect", string(expect))
+	}
 
 	// Start HTTP API
-	s.startHTTPAPI()
+	if err := s.startHTTPAPI(); err != nil {
+		httpLog.Error("HTTP API failed to start", "err", err)
+		s.closeStorage()
+		s.logs.Close()
+		return false
+	}
 
 	// Start DNS server in background
-	go s.startDNSServer()
+	started := make(chan struct{})
+	var once sync.Once
+	s.dnsServer = &dns.Server{
+		Addr:              s.dnsAddr,
+		Net:               "udp",
+		NotifyStartedFunc: func() { once.Do(func() { close(started) }) },
+	}
+	go func() {
+		s.startDNSServer()
+		once.Do(func() { close(started) })
+	}()
 
 	// Print status every 5 minutes
-	go s.statusReporter()
+	go s.statusReporter(ctx)
 
-	// Run for X hours
-	duration := time.Duration(totalDuration) * time.Hour
-	s.log("SIMULATION", fmt.Sprintf("Will run for %v", duration))
+	// Run for the duration, or until interrupted
+	if s.duration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.duration)
+		defer cancel()
+	}
+	<-ctx.Done()
 
-	timer := time.NewTimer(duration)
-	<-timer.C
+	reason := "Simulation complete"
+	if !errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		reason = "Interrupted"
+	}
+	select {
+	case <-started:
+	case <-time.After(DNS_SHUTDOWN_TIMEOUT):
+	}
+	return s.shutdown(reason)
+}
 
-	s.shutdown()
+// describeDuration says how long the simulation runs
+func (s *SimulationServer) describeDuration() string {
+	if s.duration == 0 {
+		return "until stopped"
+	}
+	return s.duration.String()
 }
 
 // startHTTPAPI starts the HTTP endpoints
-func (s *SimulationServer) startHTTPAPI() {
+func (s *SimulationServer) startHTTPAPI() error {
 	// Upload endpoint (Host A uses this)
-	http.HandleFunc("/upload", s.handleUpload)
+	http.HandleFunc("/upload", s.authorize(dnsserver.SCOPE_UPLOAD, s.handleUpload))
 
 	// Discovery endpoint (Host C uses this)
-	http.HandleFunc("/messages", s.handleGetMessages)
+	http.HandleFunc("/messages", s.authorize(dnsserver.SCOPE_CONSUME, s.handleGetMessages))
 
 	// Consume endpoint (Host C uses this)
-	http.HandleFunc("/consume", s.handleConsume)
+	http.HandleFunc("/consume", s.authorize(dnsserver.SCOPE_CONSUME, s.handleConsume))
 
 	// Status endpoint (for monitoring)
-	http.HandleFunc("/status", s.handleStatus)
+	http.HandleFunc("/status", s.authorize(dnsserver.SCOPE_ADMIN, s.handleStatus))
 
-	go func() {
-		s.log("HTTP", fmt.Sprintf("API starting on port %s", s.httpPort))
-		if err := http.ListenAndServe(":"+s.httpPort, nil); err != nil {
-			s.log("ERROR", fmt.Sprintf("HTTP server failed: %v", err))
+	// Prometheus metrics (for monitoring)
+	http.HandleFunc("/metrics", s.authorize(dnsserver.SCOPE_METRICS, s.exported.Registry.ServeHTTP))
+
+	server, err := dnsserver.ListenHTTP(s.http, http.DefaultServeMux, func(err error) {
+		httpLog.Error("HTTP server failed", "err", err)
+	})
+	if err != nil {
+		return err
+	}
+	s.httpServer = server
+	httpLog.Info("API listening", "addr", s.http.String())
+	return nil
+}
+
+// authorize caps the request body at dnsserver.MAX_REQUEST_BODY and, with
+// -api-keys, lets requests through to next only with a key carrying scope,
+// as dns-server does
+func (s *SimulationServer) authorize(scope string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		dnsserver.LimitBody(w, r)
+		if s.keys != nil {
+			if _, err := s.keys.Authenticate(r, scope); err != nil {
+				httpLog.Warn("Refused API request", "method", r.Method, "path", r.URL.Path, "remote", r.RemoteAddr, "err", err)
+				dnsserver.WriteAuthError(w, err)
+				return
+			}
 		}
-	}()
+		next(w, r)
+	}
+}
+
+// requestStatus is the status answering a request body that failed to
+// decode: 413 past MAX_REQUEST_BODY, else 400
+func requestStatus(err error) int {
+	if dnsserver.BodyTooLarge(err) {
+		return http.StatusRequestEntityTooLarge
+	}
+	return http.StatusBadRequest
 }
 
 // handleUpload processes message uploads from Host A
@@ -109,11 +205,13 @@ func (s *SimulationServer) handleUpload(w http.ResponseWriter, r *http.Request)
 		MessageID string            `json:"message_id"`
 		Chunks    map[string]string `json:"chunks"`
 		Manifest  string            `json:"manifest"`
+		Tags      []string          `json:"tags"`
+		Recipient string            `json:"recipient"`
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
-		s.log("ERROR", fmt.Sprintf("Upload decode failed: %v", err))
+		http.Error(w, err.Error(), requestStatus(err))
+		httpLog.Warn("Upload decode failed", "err", err)
 		return
 	}
 
@@ -127,14 +225,16 @@ func (s *SimulationServer) handleUpload(w http.ResponseWriter, r *http.Request)
 	}
 
 	// Store the message
-	err := s.queue.PublishMessage(req.MessageID, processedChunks, req.Manifest)
+	err := s.queue.Publish(req.MessageID, processedChunks, req.Manifest, dnsserver.PublishOptions{Tags: req.Tags, Recipient: req.Recipient})
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
-		s.log("ERROR", fmt.Sprintf("Failed to store message %s: %v", req.MessageID, err))
+		httpLog.Error("Failed to store message", "msg_id", req.MessageID, "err", err)
 		return
 	}
 
-	s.log("UPLOAD", fmt.Sprintf("Message %s uploaded (%d chunks)", req.MessageID, len(req.Chunks)))
+	s.metrics.Uploaded(req.MessageID, time.Now())
+	s.exported.Uploaded(dnsserver.UPLOAD_VIA_HTTP, (&dnsserver.Message{Chunks: processedChunks, Manifest: req.Manifest}).Size())
+	httpLog.Info("Message uploaded", "msg_id", req.MessageID, "chunks", len(req.Chunks))
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]string{
@@ -155,22 +255,28 @@ func (s *SimulationServer) handleGetMessages(w http.ResponseWriter, r *http.Requ
 		clientID = "default-client"
 	}
 
+	tags, err := dnsserver.ParseTags(r.URL.Query().Get("tags"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	messages, err := s.storage.GetNewMessages(clientID)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
-		s.log("ERROR", fmt.Sprintf("Failed to get messages for %s: %v", clientID, err))
+		httpLog.Error("Failed to get messages", "client", clientID, "err", err)
 		return
 	}
+	messages = dnsserver.FilterByTags(dnsserver.FilterByRecipient(messages, clientID), tags)
 
 	var messageIDs []string
 	for _, msg := range messages {
 		messageIDs = append(messageIDs, msg.ID)
-		s.storage.MarkAsDelivered(msg.ID, clientID)
+		s.storage.MarkAsDelivered(msg.ID, dnsserver.ClientInfo{ID: clientID, Addr: r.RemoteAddr, Via: dnsserver.CLIENT_VIA_HTTP})
 	}
 
 	if len(messageIDs) > 0 {
-		s.log("DISCOVERY", fmt.Sprintf("Client %s discovered %d messages: %v",
-			clientID, len(messageIDs), messageIDs))
+		httpLog.Info("Messages discovered", "client", clientID, "count", len(messageIDs), "msg_ids", messageIDs)
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -193,18 +299,19 @@ func (s *SimulationServer) handleConsume(w http.ResponseWriter, r *http.Request)
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		http.Error(w, err.Error(), requestStatus(err))
 		return
 	}
 
 	err := s.storage.MarkAsConsumed(req.MessageID, req.ClientID)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
-		s.log("ERROR", fmt.Sprintf("Failed to mark %s as consumed: %v", req.MessageID, err))
+		httpLog.Error("Failed to mark message consumed", "msg_id", req.MessageID, "err", err)
 		return
 	}
 
-	s.log("CONSUME", fmt.Sprintf("Message %s consumed by %s", req.MessageID, req.ClientID))
+	s.metrics.Consumed(req.MessageID, time.Now())
+	httpLog.Info("Message consumed", "msg_id", req.MessageID, "client", req.ClientID)
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]string{"status": "consumed"})
@@ -225,40 +332,69 @@ func (s *SimulationServer) handleStatus(w http.ResponseWriter, r *http.Request)
 	json.NewEncoder(w).Encode(response)
 }
 
-// startDNSServer handles DNS queries for chunk retrieval
+// startDNSServer handles DNS queries for chunk retrieval until shutdown
 func (s *SimulationServer) startDNSServer() {
 	dns.HandleFunc(s.domain, s.handleDNSRequest)
 	dns.HandleFunc(".", s.handleDNSRequest)
 
-	server := &dns.Server{
-		Addr: s.dnsAddr,
-		Net:  "udp",
-	}
-
-	s.log("DNS", fmt.Sprintf("Server starting on %s", s.dnsAddr))
-	if err := server.ListenAndServe(); err != nil {
-		s.log("ERROR", fmt.Sprintf("DNS server failed: %v", err))
+	dnsLog.Info("Server starting", "addr", s.dnsAddr)
+	if err := s.dnsServer.ListenAndServe(); err != nil {
+		dnsLog.Error("DNS server failed", "err", err)
 	}
 }
 
 // handleDNSRequest processes DNS TXT queries
 func (s *SimulationServer) handleDNSRequest(w dns.ResponseWriter, r *dns.Msg) {
+	start := time.Now()
 	msg := new(dns.Msg)
 	msg.SetReply(r)
 	msg.Authoritative = true
 
 	for _, question := range r.Question {
+		s.metrics.Query(strings.ToLower(question.Name), time.Now())
 		if question.Qtype == dns.TypeTXT {
-			s.handleTXTQuery(question, msg)
+			s.handleTXTQuery(question, msg, w.RemoteAddr())
 		}
 	}
 
 	w.WriteMsg(msg)
+
+	qtype := "none"
+	if len(r.Question) > 0 {
+		if qtype = dns.TypeToString[r.Question[0].Qtype]; qtype == "" {
+			qtype = "other"
+		}
+	}
+	s.exported.Query(qtype, dns.RcodeToString[msg.Rcode], time.Since(start))
 }
 
 // handleTXTQuery returns chunk data via DNS
-func (s *SimulationServer) handleTXTQuery(q dns.Question, msg *dns.Msg) {
+func (s *SimulationServer) handleTXTQuery(q dns.Question, msg *dns.Msg, source net.Addr) {
 	qname := strings.ToLower(strings.TrimSuffix(q.Name, "."))
+
+	// Health check clients run before transfers
+	if nonce, ok := chunker.ParseCanaryName(qname, s.domain); ok {
+		canary := chunker.Canary{
+			Version:    chunker.PROTOCOL_VERSION,
+			MinVersion: chunker.MIN_PROTOCOL_VERSION,
+			Status:     chunker.CANARY_OK,
+			Nonce:      nonce,
+			Uptime:     time.Since(s.startTime),
+			Messages:   s.storage.GetStats().TotalMessages,
+		}
+		msg.Answer = append(msg.Answer, &dns.TXT{
+			Hdr: dns.RR_Header{
+				Name:   q.Name,
+				Rrtype: dns.TypeTXT,
+				Class:  dns.ClassINET,
+				Ttl:    0,
+			},
+			Txt: []string{canary.String()},
+		})
+		dnsLog.Debug("Canary")
+		return
+	}
+
 	parts := strings.Split(qname, ".")
 
 	if len(parts) < 2 {
@@ -267,37 +403,38 @@ func (s *SimulationServer) handleTXTQuery(q dns.Question, msg *dns.Msg) {
 	}
 
 	label := parts[0]
-	var msgID string
 
-	// Extract message ID from query
-	if strings.HasPrefix(label, "c-") || strings.HasPrefix(label, "m-") {
-		idx := strings.LastIndex(label, "-")
-		if idx > 0 {
-			msgID = label[idx+1:]
-		}
+	// Extract message ID from query; malformed data labels are refused
+	data, err := chunker.ParseDataLabel(label)
+	if errors.Is(err, chunker.ErrMalformedLabel) {
+		dnsLog.Debug("Refused malformed name", "name", qname)
+		msg.Rcode = dns.RcodeRefused
+		return
 	}
-
-	if msgID == "" {
+	if err != nil {
 		msg.Rcode = dns.RcodeNameError
 		return
 	}
+	msgID := data.MessageID
 
 	// Get message from storage
 	message, err := s.storage.GetMessage(msgID)
 	if err != nil {
 		msg.Rcode = dns.RcodeNameError
+		s.exported.ChunkLookup(false)
 		return
 	}
 
 	// Return appropriate data
 	var value string
-	if strings.HasPrefix(label, "m-") {
+	isManifest := data.Manifest
+	if isManifest {
 		value = message.Manifest
-		s.log("DNS_QUERY", fmt.Sprintf("Manifest for %s", msgID))
+		dnsLog.Debug("Manifest", "msg_id", msgID)
 	} else {
 		if chunkData, exists := message.Chunks[label]; exists {
 			value = chunkData
-			s.log("DNS_QUERY", fmt.Sprintf("Chunk %s", label))
+			dnsLog.Debug("Chunk", "label", label)
 		}
 	}
 
@@ -313,75 +450,244 @@ func (s *SimulationServer) handleTXTQuery(q dns.Question, msg *dns.Msg) {
 		}
 		msg.Answer = append(msg.Answer, rr)
 		msg.Rcode = dns.RcodeSuccess
+		s.metrics.Answered(msgID, label, len(value), !isManifest)
+		s.exported.ChunkLookup(true)
+		if !isManifest {
+			// Chunk receivers are told apart by address only
+			host, _, _ := net.SplitHostPort(source.String())
+			client := dnsserver.ClientInfo{ID: "ip-" + host, Addr: host, Via: dnsserver.CLIENT_VIA_IP}
+			if _, err := s.storage.MarkChunksServed(msgID, client, label); err != nil {
+				dnsLog.Warn("Failed to record fetch", "label", label, "err", err)
+			}
+		}
 	} else {
 		msg.Rcode = dns.RcodeNameError
+		s.exported.ChunkLookup(false)
 	}
 }
 
-// statusReporter prints statistics periodically
-func (s *SimulationServer) statusReporter() {
+// statusReporter prints statistics periodically until ctx is cancelled
+func (s *SimulationServer) statusReporter(ctx context.Context) {
 	ticker := time.NewTicker(5 * time.Minute)
 	defer ticker.Stop()
 
-	for range ticker.C {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
 		stats := s.storage.GetStats()
 		uptime := time.Since(s.startTime)
 
-		s.log("STATUS", fmt.Sprintf(
-			"Uptime: %v | Messages: %d (New: %d, Delivered: %d, Consumed: %d) | Chunks: %d",
-			uptime.Round(time.Second),
-			stats.TotalMessages,
-			stats.NewMessages,
-			stats.Delivered,
-			stats.Consumed,
-			stats.TotalChunks,
-		))
+		simLog.Info("Status",
+			"uptime", uptime.Round(time.Second),
+			"messages", stats.TotalMessages,
+			"new", stats.NewMessages,
+			"delivered", stats.Delivered,
+			"consumed", stats.Consumed,
+			"chunks", stats.TotalChunks,
+		)
 	}
 }
 
-// log writes timestamped log entries
-func (s *SimulationServer) log(category, message string) {
-	timestamp := time.Now().Format("2006-01-02 15:04:05.000")
-	logEntry := fmt.Sprintf("[%s] [%s] %s\n", timestamp, category, message)
+// shutdown stops the listeners, letting requests in flight finish, saves
+// the final state and closes the log file; it reports whether the run
+// passed
+func (s *SimulationServer) shutdown(reason string) bool {
+	simLog.Info(reason+", shutting down", "uptime", time.Since(s.startTime).Round(time.Second))
 
-	// Write to file
-	s.logFile.WriteString(logEntry)
+	// Final statistics
+	stats := s.storage.GetStats()
+	simLog.Info("Final",
+		"messages", stats.TotalMessages,
+		"consumed", stats.Consumed,
+		"chunks", stats.TotalChunks,
+		"retransmission_pct", s.metrics.RetransmissionPercent(),
+		"detection_score", s.metrics.DetectionScore(),
+	)
+	passed := s.judge()
+
+	// Let uploads and queries in flight finish before the final save
+	if err := dnsserver.ShutdownHTTP(s.httpServer); err != nil {
+		httpLog.Warn("HTTP API did not stop cleanly", "err", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), DNS_SHUTDOWN_TIMEOUT)
+	if err := s.dnsServer.ShutdownContext(ctx); err != nil {
+		dnsLog.Warn("DNS server did not stop cleanly", "err", err)
+	}
+	cancel()
 
-	// Also print to console
-	fmt.Print(logEntry)
+	s.closeStorage()
+	s.logs.Close()
+	return passed
 }
 
-// shutdown gracefully stops the server
-func (s *SimulationServer) shutdown() {
-	s.log("SIMULATION", "24-hour simulation complete, shutting down")
-
-	// Final statistics
-	stats := s.storage.GetStats()
-	s.log("FINAL", fmt.Sprintf(
-		"Total Messages: %d | Consumed: %d | Chunks: %d",
-		stats.TotalMessages,
-		stats.Consumed,
-		stats.TotalChunks,
-	))
-
-	// Save final state
+// closeStorage saves the final state
+func (s *SimulationServer) closeStorage() {
 	if fs, ok := s.storage.(*dnsserver.FileStorage); ok {
-		if err := fs.Save(); err != nil {
-			s.log("ERROR", fmt.Sprintf("Failed to save final state: %v", err))
+		if err := fs.Close(); err != nil {
+			simLog.Error("Failed to save final state", "err", err)
 		} else {
-			s.log("SHUTDOWN", "State saved to simulation_state.json")
+			simLog.Info("State saved", "file", "simulation_state.json")
+		}
+	}
+}
+
+// judge evaluates the scenario, logging each assertion and saving the
+// verdict; it reports whether the run passed (true without a scenario)
+func (s *SimulationServer) judge() bool {
+	if s.scenario == nil {
+		return true
+	}
+
+	verdict := Verdict{
+		Scenario:   s.scenario.Name,
+		StartedAt:  s.startTime,
+		EndedAt:    time.Now(),
+		Assertions: s.scenario.Evaluate(s.metrics),
+	}
+	verdict.Passed = passed(verdict.Assertions)
+
+	for _, result := range verdict.Assertions {
+		status := "PASS"
+		if !result.Passed {
+			status = "FAIL"
 		}
+		simLog.Info("Assert "+status, "name", result.Name, "expected", result.Expected, "observed", result.Observed)
+	}
+
+	outcome := "PASS"
+	if !verdict.Passed {
+		outcome = "FAIL"
+	}
+	simLog.Info("Verdict "+outcome, "scenario", s.scenario.Name, "assertions", len(verdict.Assertions))
+
+	path := fmt.Sprintf("simulation_verdict_%s.json", s.startTime.Format("20060102_150405"))
+	var data bytes.Buffer
+	encoder := json.NewEncoder(&data)
+	encoder.SetEscapeHTML(false) // Keep "<=" readable
+	encoder.SetIndent("", "  ")
+	err := encoder.Encode(verdict)
+	if err == nil {
+		err = os.WriteFile(path, data.Bytes(), 0644)
+	}
+	if err != nil {
+		simLog.Error("Failed to save verdict", "err", err)
+	} else {
+		simLog.Info("Verdict saved", "file", path)
 	}
 
-	s.logFile.Close()
-	os.Exit(0)
+	return verdict.Passed
 }
 
 func main() {
+	scenarioFile := flag.String("scenario", "", "Scenario file (JSON) with the expected outcome, judged at shutdown")
+	httpAddr := flag.String("http-addr", dnsserver.DEFAULT_HTTP_ADDR, "HTTP API listen address, e.g. 127.0.0.1:8080")
+	httpCert := flag.String("http-cert", "", "TLS certificate (PEM) for the HTTP API (needs -http-key)")
+	httpKey := flag.String("http-key", "", "TLS private key (PEM) for the HTTP API")
+	autocertHosts := flag.String("http-autocert", "", "Get HTTP API certificates from Let's Encrypt for these names (comma-separated; -http-addr must be reachable on :443)")
+	autocertCache := flag.String("http-autocert-cache", dnsserver.DEFAULT_AUTOCERT_CACHE, "Directory keeping -http-autocert certificates")
+	apiKeys := flag.String("api-keys", "", "HTTP API key file (JSON, as for dns-server -api-keys): keys with upload/consume/admin/metrics scopes, bearer or HMAC-signed")
+	logLevel := flag.String("log-level", logging.DEFAULT_LEVEL, "Log level (debug, info, warn, error), optionally per component, e.g. info,dns=debug (components: simulation, http, dns, storage)")
+	logFormat := flag.String("log-format", logging.FORMAT_TEXT, "Log format (text, json)")
+	logFile := flag.String("log-file", fmt.Sprintf("simulation_server_%s.log", time.Now().Format("20060102_150405")), "Log file for trace analysis, also echoed to the console and rotated by size (\"\" = console only)")
+	logMaxSize := flag.String("log-max-size", "100MB", "Rotate -log-file at this size (0 = never)")
+	logBackups := flag.Int("log-backups", logging.DEFAULT_MAX_BACKUPS, "Rotated -log-file copies kept")
+	duration := flag.Duration("duration", DEFAULT_DURATION, "How long the simulation runs before shutting down and judging the scenario (0 = until SIGINT/SIGTERM; default: the scenario's duration, else 26h)")
+	flag.String(config.FLAG_NAME, "", config.USAGE)
+	flag.Parse()
+	configPath, err := config.Apply(flag.CommandLine, "simula-server")
+	if err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+
+	if *duration < 0 {
+		log.Fatalf("-duration can't be negative")
+	}
+
+	logConfig := logging.Config{
+		Level:      *logLevel,
+		Format:     *logFormat,
+		File:       *logFile,
+		Console:    true,
+		MaxBackups: *logBackups,
+	}
+	if *logFile != "" {
+		var err error
+		if logConfig.MaxSize, err = dnsserver.ParseByteSize(*logMaxSize); err != nil {
+			log.Fatalf("Invalid -log-max-size: %v", err)
+		}
+	}
+	logs, err := logging.Setup(logConfig)
+	if err != nil {
+		log.Fatalf("Invalid logging options: %v", err)
+	}
+
+	httpConfig := dnsserver.HTTPConfig{
+		Addr:          *httpAddr,
+		CertFile:      *httpCert,
+		KeyFile:       *httpKey,
+		AutocertCache: *autocertCache,
+	}
+	if *autocertHosts != "" {
+		httpConfig.AutocertHosts = strings.Split(*autocertHosts, ",")
+	}
+	if err := httpConfig.Validate(); err != nil {
+		log.Fatalf("HTTP API: %v", err)
+	}
+
+	var scenario *Scenario
+	if *scenarioFile != "" {
+		if scenario, err = LoadScenario(*scenarioFile); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	var keys *dnsserver.KeyRing
+	if *apiKeys != "" {
+		if keys, err = dnsserver.LoadKeyRing(*apiKeys); err != nil {
+			log.Fatalf("Invalid -api-keys: %v", err)
+		}
+	}
+
+	server := NewSimulationServer()
+	server.keys = keys
+	server.scenario = scenario
+	server.http = httpConfig
+	server.logs = logs
+	server.duration = *duration
+	durationSet := false
+	flag.Visit(func(f *flag.Flag) { durationSet = durationSet || f.Name == "duration" })
+	if !durationSet && scenario != nil && scenario.Duration.Duration > 0 {
+		server.duration = scenario.Duration.Duration
+	}
+
 	fmt.Println("=" + strings.Repeat("=", 60))
-	fmt.Printf("SIMULACRA TXT - %d HOUR SIMULATION SERVER\n", totalDuration)
+	fmt.Println("SIMULACRA TXT - SIMULATION SERVER")
 	fmt.Println("=" + strings.Repeat("=", 60))
+	fmt.Printf("⏱️  Duration: %s\n", server.describeDuration())
+	if configPath != "" {
+		fmt.Printf("⚙️  Config: %s\n", configPath)
+	}
+	fmt.Printf("📝 Logs: %s\n", logConfig)
+	if keys != nil {
+		fmt.Printf("🔑 HTTP API keys: %s (%s)\n", keys, *apiKeys)
+	} else {
+		fmt.Println("🔓 HTTP API: open to anyone who can connect (see -api-keys)")
+	}
 
-	server := NewSimulationServer()
-	server.Start()
+	// SIGINT/SIGTERM end the run early, shutting down the same way
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		stop() // A second signal kills a shutdown that hangs
+	}()
+
+	if !server.Run(ctx) {
+		stop()
+		os.Exit(1)
+	}
 }
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// ================================================================================
+// SIMULATION METRICS
+// Records what a run did, for the scenario's assertions to judge
+// ================================================================================
+
+// LESSON: A Crude Detector
+// The detection score is a stand-in for what DNS tunneling detectors look
+// at, scored 0 (quiet) to 100 (obvious) from three signals:
+//
+//   rate  - peak queries in any one minute (DETECT_PEAK_QPM = full marks)
+//   names - peak distinct names queried in one minute (DETECT_PEAK_NAMES)
+//   size  - average answer size against a full TXT string (255 bytes)
+//
+// It won't catch what a real IDS catches, but it moves the right way when
+// pacing, batching or chunk sizes change, which is what an experiment needs.
+
+// Detection score weights (sum to 100) and the levels that max them out
+const (
+	DETECT_WEIGHT_RATE  = 40
+	DETECT_WEIGHT_NAMES = 30
+	DETECT_WEIGHT_SIZE  = 30
+
+	DETECT_PEAK_QPM   = 600
+	DETECT_PEAK_NAMES = 300
+	DETECT_FULL_TXT   = 255
+)
+
+// minuteTraffic is the DNS traffic seen in one minute
+type minuteTraffic struct {
+	queries int
+	names   map[string]bool
+}
+
+// simMetrics collects the observations assertions are evaluated against
+type simMetrics struct {
+	mu sync.Mutex
+
+	uploaded map[string]time.Time // Message ID -> upload time
+	consumed map[string]time.Time // Message ID -> first consumption
+
+	served         map[string]int // "<id>/<label>" -> times answered
+	chunkQueries   int
+	retransmission int
+
+	minutes     map[int64]*minuteTraffic
+	answers     int
+	answerBytes int
+}
+
+// newSimMetrics creates an empty collector
+func newSimMetrics() *simMetrics {
+	return &simMetrics{
+		uploaded: make(map[string]time.Time),
+		consumed: make(map[string]time.Time),
+		served:   make(map[string]int),
+		minutes:  make(map[int64]*minuteTraffic),
+	}
+}
+
+// Uploaded records a message upload
+func (m *simMetrics) Uploaded(msgID string, at time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.uploaded[msgID]; !ok {
+		m.uploaded[msgID] = at
+	}
+}
+
+// Consumed records the first time a message is consumed
+func (m *simMetrics) Consumed(msgID string, at time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.consumed[msgID]; !ok {
+		m.consumed[msgID] = at
+	}
+}
+
+// Query records a DNS query for name
+func (m *simMetrics) Query(name string, at time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	minute := at.Unix() / 60
+	traffic, ok := m.minutes[minute]
+	if !ok {
+		traffic = &minuteTraffic{names: make(map[string]bool)}
+		m.minutes[minute] = traffic
+	}
+	traffic.queries++
+	traffic.names[name] = true
+}
+
+// Answered records an answer of size bytes, and for chunks whether the
+// same chunk was served before (a retransmission)
+func (m *simMetrics) Answered(msgID, label string, size int, chunk bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.answers++
+	m.answerBytes += size
+	if !chunk {
+		return
+	}
+
+	key := msgID + "/" + label
+	m.chunkQueries++
+	if m.served[key] > 0 {
+		m.retransmission++
+	}
+	m.served[key]++
+}
+
+// RetransmissionPercent is the share of chunk answers that repeated one
+// already served
+func (m *simMetrics) RetransmissionPercent() float64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.chunkQueries == 0 {
+		return 0
+	}
+	return 100 * float64(m.retransmission) / float64(m.chunkQueries)
+}
+
+// DetectionScore rates how conspicuous the run's traffic was (0-100)
+func (m *simMetrics) DetectionScore() float64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	peakQueries, peakNames := 0, 0
+	for _, traffic := range m.minutes {
+		peakQueries = max(peakQueries, traffic.queries)
+		peakNames = max(peakNames, len(traffic.names))
+	}
+	averageSize := 0.0
+	if m.answers > 0 {
+		averageSize = float64(m.answerBytes) / float64(m.answers)
+	}
+
+	return DETECT_WEIGHT_RATE*min(1, float64(peakQueries)/DETECT_PEAK_QPM) +
+		DETECT_WEIGHT_NAMES*min(1, float64(peakNames)/DETECT_PEAK_NAMES) +
+		DETECT_WEIGHT_SIZE*min(1, averageSize/DETECT_FULL_TXT)
+}
+
+// ConsumeLatencies returns how long each uploaded message took to be
+// consumed; messages never consumed are listed in pending
+func (m *simMetrics) ConsumeLatencies() (latencies map[string]time.Duration, pending []string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	latencies = make(map[string]time.Duration)
+	for id, uploaded := range m.uploaded {
+		if consumed, ok := m.consumed[id]; ok {
+			latencies[id] = consumed.Sub(uploaded)
+		} else {
+			pending = append(pending, id)
+		}
+	}
+	return latencies, pending
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ================================================================================
+// SCENARIO ASSERTIONS
+// Declares what a run should achieve and judges it at shutdown
+// ================================================================================
+
+// LESSON: Experiments Need a Verdict
+// A 24-hour run that ends with "Total Messages: 40 | Consumed: 38" still
+// leaves someone reading logs to decide whether it worked. A scenario file
+// states the expected outcome up front:
+//
+//   {
+//     "name": "paced-receiver",
+//     "duration": "2h",
+//     "expect": {
+//       "all_consumed_within": "15m",
+//       "max_retransmission_percent": 5,
+//       "max_detection_score": 40
+//     }
+//   }
+//
+// Every expectation left out is skipped. At shutdown each one is logged as
+// PASS or FAIL with what was observed, the verdict is written next to the
+// log, and the process exits non-zero on FAIL so scripts can chain runs.
+
+// Scenario is a simulation run and its expected outcome
+type Scenario struct {
+	Name     string           `json:"name"`
+	Duration scenarioDuration `json:"duration,omitempty"` // Run length, unless -duration is given (default: DEFAULT_DURATION)
+	Expect   Expectations     `json:"expect"`
+}
+
+// Expectations are the pass/fail criteria of a scenario
+type Expectations struct {
+	AllConsumedWithin        *scenarioDuration `json:"all_consumed_within,omitempty"`        // Upload to first consume, every message
+	MaxRetransmissionPercent *float64          `json:"max_retransmission_percent,omitempty"` // Chunk answers repeating one already served
+	MaxDetectionScore        *float64          `json:"max_detection_score,omitempty"`        // See metrics.go (0-100)
+}
+
+// scenarioDuration is a duration written as a string ("90m", "2h")
+type scenarioDuration struct {
+	time.Duration
+}
+
+// UnmarshalJSON implements json.Unmarshaler
+func (d *scenarioDuration) UnmarshalJSON(data []byte) error {
+	var text string
+	if err := json.Unmarshal(data, &text); err != nil {
+		return errors.New(`durations are strings like "90m" or "2h"`)
+	}
+	parsed, err := time.ParseDuration(text)
+	if err != nil {
+		return err
+	}
+	if parsed <= 0 {
+		return fmt.Errorf("duration must be positive: %s", text)
+	}
+	d.Duration = parsed
+	return nil
+}
+
+// MarshalJSON implements json.Marshaler
+func (d scenarioDuration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+// LoadScenario reads a scenario file
+func LoadScenario(path string) (*Scenario, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read scenario: %w", err)
+	}
+	defer file.Close()
+
+	var scenario Scenario
+	decoder := json.NewDecoder(file)
+	decoder.DisallowUnknownFields() // A misspelled expectation must not pass silently
+	if err := decoder.Decode(&scenario); err != nil {
+		return nil, fmt.Errorf("invalid scenario %s: %w", path, err)
+	}
+	if scenario.Name == "" {
+		scenario.Name = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	}
+	return &scenario, nil
+}
+
+// AssertionResult is one evaluated expectation
+type AssertionResult struct {
+	Name     string `json:"name"`
+	Expected string `json:"expected"`
+	Observed string `json:"observed"`
+	Passed   bool   `json:"passed"`
+}
+
+// Verdict is the outcome of a scenario run
+type Verdict struct {
+	Scenario   string            `json:"scenario"`
+	StartedAt  time.Time         `json:"started_at"`
+	EndedAt    time.Time         `json:"ended_at"`
+	Passed     bool              `json:"passed"`
+	Assertions []AssertionResult `json:"assertions"`
+}
+
+// Evaluate checks the scenario's expectations against a run's metrics
+func (s *Scenario) Evaluate(metrics *simMetrics) []AssertionResult {
+	var results []AssertionResult
+
+	if limit := s.Expect.AllConsumedWithin; limit != nil {
+		latencies, pending := metrics.ConsumeLatencies()
+		slowest, slowestID := time.Duration(0), ""
+		for id, latency := range latencies {
+			if latency > slowest {
+				slowest, slowestID = latency, id
+			}
+		}
+
+		observed := fmt.Sprintf("%d messages, slowest %s", len(latencies)+len(pending), slowest.Round(time.Second))
+		if slowestID != "" {
+			observed += " (" + slowestID + ")"
+		}
+		if len(pending) > 0 {
+			sort.Strings(pending)
+			observed += fmt.Sprintf(", %d never consumed: %s", len(pending), strings.Join(pending, ", "))
+		}
+		results = append(results, AssertionResult{
+			Name:     "all_consumed_within",
+			Expected: "<= " + limit.String(),
+			Observed: observed,
+			Passed:   len(pending) == 0 && slowest <= limit.Duration,
+		})
+	}
+
+	if limit := s.Expect.MaxRetransmissionPercent; limit != nil {
+		percent := metrics.RetransmissionPercent()
+		results = append(results, AssertionResult{
+			Name:     "max_retransmission_percent",
+			Expected: fmt.Sprintf("<= %.1f%%", *limit),
+			Observed: fmt.Sprintf("%.1f%%", percent),
+			Passed:   percent <= *limit,
+		})
+	}
+
+	if limit := s.Expect.MaxDetectionScore; limit != nil {
+		score := metrics.DetectionScore()
+		results = append(results, AssertionResult{
+			Name:     "max_detection_score",
+			Expected: fmt.Sprintf("<= %.1f", *limit),
+			Observed: fmt.Sprintf("%.1f", score),
+			Passed:   score <= *limit,
+		})
+	}
+
+	return results
+}
+
+// passed reports whether every assertion passed
+func passed(results []AssertionResult) bool {
+	for _, result := range results {
+		if !result.Passed {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/faanross/simulacra_txt/internal/credstore"
+	"github.com/faanross/simulacra_txt/internal/decoder"
+	"github.com/faanross/simulacra_txt/internal/progress"
+	"github.com/faanross/simulacra_txt/internal/scrypto"
+	"github.com/faanross/simulacra_txt/pkg/simulacra"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ================================================================================
+// RECEIVER DAEMON
+// What -poll does with each message: save, decode, tell someone
+// ================================================================================
+
+// LESSON: Acknowledge What You Kept
+// The poll loop acknowledges a message only when the handler returns nil,
+// and an acknowledged message is never offered again. So the daemon
+// returns an error while nothing is kept yet (retrieval or saving failed:
+// the next poll offers the message again) and nil once the data is on
+// disk - a wrong decode password won't fix itself by refetching, so that
+// is acknowledged too, with a failed receipt for the sender.
+
+// daemon handles the messages -poll retrieves
+type daemon struct {
+	outputDir string
+	decode    bool
+	password  []byte
+	reporter  progress.Reporter
+	notifier  simulacra.Notifier // nil = no hooks
+}
+
+// newDaemon sets up what happens to each -poll or -batch-manifest message,
+// asking for the decode password unless it was given or stored
+func newDaemon(outputDir string, decode bool, password string, creds *credstore.Store, reporter progress.Reporter, notifyURL, notifyExec string) *daemon {
+	d := &daemon{outputDir: outputDir, decode: decode, reporter: reporter}
+	if decode {
+		var err error
+		if password != "" {
+			d.password = []byte(password)
+		} else if stored, ok := lookupCredential(creds, credstore.CRED_DECODE_PASSWORD); ok {
+			d.password = []byte(stored)
+		} else if d.password, err = scrypto.GetSecurePassword("Enter password: "); err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("   Decoding: every message, plaintext as decoded_<id>.txt\n")
+	}
+	var notifiers simulacra.Notifiers
+	if notifyURL != "" {
+		notifiers = append(notifiers, &simulacra.WebhookNotifier{URL: notifyURL})
+		fmt.Printf("   Webhook: %s\n", notifyURL)
+	}
+	if notifyExec != "" {
+		notifiers = append(notifiers, &simulacra.ExecNotifier{Command: strings.Fields(notifyExec), Stdout: os.Stdout, Stderr: os.Stderr})
+		fmt.Printf("   Hook command: %s\n", notifyExec)
+	}
+	if len(notifiers) > 0 {
+		d.notifier = notifiers
+	}
+	return d
+}
+
+// handle saves, optionally decodes and announces one polled message
+func (d *daemon) handle(ctx context.Context, msgID string, data []byte, info *simulacra.ContentInfo, err error) (simulacra.ReceiptStatus, error) {
+	n := simulacra.Notification{Event: simulacra.NOTIFY_MESSAGE, MessageID: msgID, Time: time.Now(), Bytes: len(data)}
+	if info != nil {
+		n.ContentType, n.Filename = info.ContentType, info.Filename
+	}
+
+	if err != nil {
+		if ctx.Err() != nil {
+			return simulacra.RECEIPT_FAILED, err
+		}
+		log.Printf("Failed to retrieve %s: %v", msgID, err)
+		d.notify(ctx, n, err)
+		return simulacra.RECEIPT_FAILED, err
+	}
+
+	// Save retrieved message
+	filename := savePath(d.outputDir, msgID, info)
+	if err := os.WriteFile(filename, data, 0644); err != nil {
+		log.Printf("Failed to save: %v", err)
+		d.notify(ctx, n, err)
+		return simulacra.RECEIPT_FAILED, err
+	}
+	fmt.Printf("💾 Saved to: %s\n", filename)
+	n.SavedTo = filename
+
+	// Only PNGs carry a hidden message
+	var integrity IntegrityReport
+	img, err := checkPNG(data, info, &integrity)
+	if !d.decode || integrity.PNG == PNG_SKIPPED {
+		d.notify(ctx, n, nil)
+		return simulacra.RECEIPT_DELIVERED, nil
+	}
+	if err == nil {
+		var result *decoder.ExtractedMessage
+		if result, err = decodeImage(img, filepath.Base(filename), d.password, d.reporter); err == nil {
+			n.Plaintext = filepath.Join(d.outputDir, fmt.Sprintf("decoded_%s.txt", msgID))
+			err = os.WriteFile(n.Plaintext, result.Message, 0644)
+		}
+	}
+	if err != nil {
+		log.Printf("Decode of %s failed: %v", msgID, err)
+		n.Plaintext = ""
+		d.notify(ctx, n, err)
+		return simulacra.RECEIPT_FAILED, nil
+	}
+
+	fmt.Printf("✅ Decoded message saved to: %s\n", n.Plaintext)
+	n.Decoded = true
+	d.notify(ctx, n, nil)
+	return simulacra.RECEIPT_DECODED, nil
+}
+
+// notify runs the hooks for a message, reporting err as its failure
+func (d *daemon) notify(ctx context.Context, n simulacra.Notification, err error) {
+	if d.notifier == nil {
+		return
+	}
+	if err != nil {
+		n.Event, n.Error = simulacra.NOTIFY_FAILED, err.Error()
+	}
+	if err := d.notifier.Notify(ctx, n); err != nil && ctx.Err() == nil {
+		log.Printf("⚠️  Notification for %s failed: %v", n.MessageID, err)
+	}
+}
@@ -1,409 +1,517 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
+	"errors"
 	"flag"
 	"fmt"
 	"github.com/faanross/simulacra_txt/internal/chunker"
+	"github.com/faanross/simulacra_txt/internal/config"
+	"github.com/faanross/simulacra_txt/internal/credstore"
 	"github.com/faanross/simulacra_txt/internal/decoder"
+	dnsserver "github.com/faanross/simulacra_txt/internal/dns-server"
+	"github.com/faanross/simulacra_txt/internal/fingerprint"
+	"github.com/faanross/simulacra_txt/internal/progress"
 	"github.com/faanross/simulacra_txt/internal/scrypto"
+	"github.com/faanross/simulacra_txt/internal/transport"
+	"github.com/faanross/simulacra_txt/pkg/simulacra"
 	"github.com/miekg/dns"
 	"image"
 	"log"
 	"os"
+	"os/signal"
+	"path/filepath"
 	"strings"
+	"syscall"
 	"time"
 )
 
 // ================================================================================
 // DNS RECEIVER CLIENT - Retrieves and decodes covert messages
+// Retrieval lives in pkg/simulacra; this command saves and decodes
 // ================================================================================
 
-// Receiver handles message retrieval from DNS
-type Receiver struct {
-	server       string
-	domain       string
-	pollInterval time.Duration
-	maxRetries   int
-}
-
-// NewReceiver creates a receiver instance
-func NewReceiver(server, domain string) *Receiver {
-	return &Receiver{
-		server:       server,
-		domain:       domain,
-		pollInterval: 5 * time.Second,
-		maxRetries:   3,
+// savePath picks where a retrieved message is written: its original name
+// when the sender included one (prefixed with the ID if that file exists),
+// else received_<id>.png
+func savePath(dir, msgID string, info *chunker.ContentInfo) string {
+	name := fmt.Sprintf("received_%s.png", msgID)
+	if info != nil {
+		if original := info.SafeFilename(); original != "" {
+			name = original
+			if _, err := os.Stat(filepath.Join(dir, name)); err == nil {
+				name = fmt.Sprintf("%s_%s", msgID, original)
+			}
+		}
 	}
+	return filepath.Join(dir, name)
 }
 
-// RetrieveMessage fetches a complete message from DNS
-func (r *Receiver) RetrieveMessage(msgID string) ([]byte, error) {
-	fmt.Printf("\n📥 RETRIEVING MESSAGE: %s\n", msgID)
-	fmt.Printf("   Server: %s\n", r.server)
-	fmt.Printf("   Domain: %s\n", r.domain)
-
-	// LESSON: Retrieval Strategy
-	// 1. Fetch manifest first (tells us what to expect)
-	// 2. Query for each chunk
-	// 3. Handle missing/failed chunks
-	// 4. Reassemble in correct order
-	// 5. Decode from steganographic format
-
-	// Step 1: Get manifest
-	fmt.Printf("\n1️⃣ Fetching manifest...\n")
-	manifest, totalChunks, err := r.fetchManifest(msgID)
-	if err != nil {
-		return nil, fmt.Errorf("manifest fetch failed: %w", err)
-	}
-
-	fmt.Printf("   ✅ Manifest retrieved\n")
-	fmt.Printf("   Total chunks: %d\n", totalChunks)
+// decodeImage extracts and decrypts the message hidden in a retrieved image
+func decodeImage(img image.Image, name string, password []byte, reporter progress.Reporter) (result *decoder.ExtractedMessage, err error) {
+	task := progress.Begin(reporter, progress.STAGE_DECODE, name, 0)
+	defer func() { task.Finish(err) }()
 
-	// Step 2: Fetch all chunks
-	fmt.Printf("\n2️⃣ Fetching chunks...\n")
-	chunks := make([]string, totalChunks)
-	successful := 0
-	failed := 0
-
-	progressBar := NewProgressBar(totalChunks)
-
-	for i := 0; i < totalChunks; i++ {
-		chunkName := fmt.Sprintf("c-%d-%s.data.%s", i, msgID, r.domain)
-
-		chunkData, err := r.fetchChunk(chunkName)
-		if err != nil {
-			// Retry logic
-			retried := false
-			for retry := 0; retry < r.maxRetries; retry++ {
-				time.Sleep(time.Duration(retry+1) * time.Second)
-				chunkData, err = r.fetchChunk(chunkName)
-				if err == nil {
-					retried = true
-					break
-				}
-			}
-
-			if !retried {
-				fmt.Printf("\n   ❌ Failed chunk %d: %v\n", i, err)
-				failed++
-				continue
-			}
-		}
-
-		chunks[i] = chunkData
-		successful++
-		progressBar.Update(successful)
+	// Create decoder
+	stegDecoder := decoder.NewSecureStegoDecoder(img, password)
 
-		// Small delay to avoid hammering server
-		time.Sleep(50 * time.Millisecond)
+	// Extract and decrypt
+	stegDecoder.ExtractBitStream()
+	if err := stegDecoder.ExtractSecurePayload(); err != nil {
+		return nil, err
 	}
+	return stegDecoder.DecryptPayload()
+}
 
-	progressBar.Finish()
-
-	// Check completeness
-	if failed > 0 {
-		return nil, fmt.Errorf("incomplete retrieval: %d/%d chunks missing", failed, totalChunks)
+// saveReport writes -report, if one was asked for
+func saveReport(path string, report *TransferReport) {
+	if path == "" {
+		return
 	}
-
-	fmt.Printf("   ✅ All chunks retrieved\n")
-
-	// Step 3: Reassemble
-	fmt.Printf("\n3️⃣ Reassembling message...\n")
-
-	reassembled, err := r.reassembleChunks(chunks, msgID, manifest)
-	if err != nil {
-		return nil, fmt.Errorf("reassembly failed: %w", err)
+	if err := writeReport(path, report); err != nil {
+		log.Printf("⚠️  %v", err)
+		return
 	}
-
-	fmt.Printf("   ✅ Reassembled %d bytes\n", len(reassembled))
-
-	return reassembled, nil
+	fmt.Printf("📝 Report written to %s\n", path)
 }
 
-// fetchManifest retrieves the manifest record
-func (r *Receiver) fetchManifest(msgID string) (string, int, error) {
-	manifestName := fmt.Sprintf("m-%s.data.%s", msgID, r.domain)
-
-	c := new(dns.Client)
-	c.Timeout = 5 * time.Second
-
-	m := new(dns.Msg)
-	m.SetQuestion(dns.Fqdn(manifestName), dns.TypeTXT)
-
-	resp, _, err := c.Exchange(m, r.server)
-	if err != nil {
-		return "", 0, err
-	}
-
-	// Extract manifest data
-	for _, ans := range resp.Answer {
-		if txt, ok := ans.(*dns.TXT); ok && len(txt.Txt) > 0 {
-			// Parse manifest: "total:checksum:timestamp"
-			parts := strings.Split(txt.Txt[0], ":")
-			if len(parts) >= 1 {
-				var total int
-				fmt.Sscanf(parts[0], "%d", &total)
-				return txt.Txt[0], total, nil
-			}
-		}
+// lookupCredential reads a credential if the store was unlocked
+func lookupCredential(creds *credstore.Store, name string) (string, bool) {
+	if creds == nil {
+		return "", false
 	}
-
-	return "", 0, fmt.Errorf("manifest not found")
+	return creds.Get(name)
 }
 
-// fetchChunk retrieves a single chunk
-func (r *Receiver) fetchChunk(chunkName string) (string, error) {
-	c := new(dns.Client)
-	c.Timeout = 5 * time.Second
-
-	m := new(dns.Msg)
-	m.SetQuestion(dns.Fqdn(chunkName), dns.TypeTXT)
-
-	resp, _, err := c.Exchange(m, r.server)
-	if err != nil {
-		return "", err
+// printResolverStats shows how each resolver served chunk queries, when
+// there were several
+func printResolverStats(receiver *simulacra.Receiver) {
+	stats := receiver.ResolverStats()
+	if len(stats) < 2 {
+		return
 	}
 
-	// Extract chunk data
-	for _, ans := range resp.Answer {
-		if txt, ok := ans.(*dns.TXT); ok && len(txt.Txt) > 0 {
-			return txt.Txt[0], nil
+	fmt.Printf("   Resolvers:\n")
+	for _, s := range stats {
+		latency := "no answers"
+		if s.Latency > 0 {
+			latency = fmt.Sprintf("%v avg", s.Latency.Round(time.Microsecond))
+		}
+		if s.Benched {
+			latency += " (benched)"
 		}
+		fmt.Printf("     %-22s %4d queries, %d failed, %s\n", s.Address, s.Queries, s.Failures, latency)
 	}
-
-	return "", fmt.Errorf("chunk not found")
 }
 
-// reassembleChunks reconstructs the original data
-func (r *Receiver) reassembleChunks(encodedChunks []string, msgID, manifest string) ([]byte, error) {
-	// Convert DNS chunks back to chunker.Chunk format
-	chk := chunker.NewChunker(chunker.ChunkerConfig{
-		Encoding: chunker.ENCODE_BASE32,
-	})
-
-	chunks := make([]chunker.Chunk, 0, len(encodedChunks))
+// printCoverStats prints how many cover queries and beacons went out, if any
+func printCoverStats(receiver *simulacra.Receiver) {
+	if n := receiver.Cover().Generated(); n > 0 {
+		fmt.Printf("   Cover queries: %d\n", n)
+	}
+	if receiver.BeaconInterval() > 0 {
+		fmt.Printf("   Beacons answered: %d\n", receiver.Beacons())
+	}
+}
 
-	for _, encoded := range encodedChunks {
-		if encoded == "" {
-			continue // Skip missing chunks
-		}
+func main() {
+	// Command line flags
+	server := flag.String("server", "localhost:5353", "DNS server, or several resolvers as a,b,c to spread chunk queries over (the first also gets manifest, poll and receipt queries)")
+	domain := flag.String("domain", "covert.example.com", "Domain")
+	msgID := flag.String("msg", "", "Message ID to retrieve")
+	batchManifest := flag.String("batch-manifest", "", "Retrieve every message a stego-send -input-dir batch manifest lists, saving (and with -decode decoding) each like -poll")
+	poll := flag.Bool("poll", false, "Run as a daemon: poll for new messages, save (and with -decode decode) each one and run the -notify hooks")
+	pollInterval := flag.Duration("poll-interval", simulacra.DEFAULT_POLL_INTERVAL, "Pause between polls")
+	pollMax := flag.Duration("poll-max", 0, "Idle polling backs off up to this pause (0 = twice -poll-interval)")
+	pollJitter := flag.Float64("poll-jitter", 0, "Vary each poll pause randomly by up to this fraction either way (0-1, e.g. 0.3)")
+	notifyURL := flag.String("notify-url", "", "With -poll, POST a JSON notification to this webhook for every message")
+	notifyExec := flag.String("notify-exec", "", "With -poll, run this command for every message (JSON on stdin, SIMULACRA_* environment variables; no shell)")
+	clientID := flag.String("client", "receiver1", "Client ID for polling")
+	beacon := flag.String("beacon", "", "Send the server a heartbeat this often, e.g. 5m, so its presence view (GET /admin/clients) shows -client alive during long runs (default: none)")
+	beaconJitter := flag.Float64("beacon-jitter", 0.3, "Vary each -beacon pause randomly by up to this fraction either way (0-1)")
+	clientEDNS := flag.Bool("client-edns", false, "Send -client in an EDNS0 option on every query, so the server can track chunk fetches per client (dns-server -client-id edns)")
+	decode := flag.Bool("decode", false, "Decode after retrieval")
+	password := flag.String("password", "", "Password for decoding")
+	output := flag.String("output", "", "Output directory")
+	plaintext := flag.String("plaintext", "", "Write the decoded message to this file (implies -decode; default: decoded_<id>.txt in -output)")
+	reportPath := flag.String("report", "", "Write a JSON summary of the retrieval and its integrity checks to this file (with -msg)")
+	batch := flag.Int("batch", 1, "Chunks per range query (e.g. 10; needs EDNS0-capable path)")
+	parallel := flag.Int("parallel", 1, fmt.Sprintf("Chunk windows fetched at once (1-%d)", simulacra.MAX_PARALLEL))
+	queryRate := flag.Int("rate", 0, fmt.Sprintf("Chunk queries per second to the server or resolver (default: %d with -parallel, else a %v pause between windows)", simulacra.DEFAULT_QUERY_RATE, simulacra.FETCH_PAUSE))
+	coverRatio := flag.Float64("cover-ratio", 0, "Cover queries per chunk query, spread over the resolvers like chunk queries, e.g. 0.5 (0 = none)")
+	coverSites := flag.String("cover-sites", "", "Site list for cover queries: one \"domain host...\" line per site, most popular first (default: built-in list)")
+	coverMix := flag.String("cover-mix", "", "Cover query types and weights, e.g. A=50,AAAA=30,TXT=12,MX=8 (the default)")
+	coverTempo := flag.Float64("cover-tempo", 1, "Scale the pauses of -cover-background, e.g. 0.5 for twice as busy")
+	coverBackground := flag.Bool("cover-background", false, "Also send cover queries at their own browsing-like timing for the whole run, polling included")
+	timeout := flag.Duration("timeout", 0, "Give up on a message after this long, retries included (e.g. 5m; 0 = no limit)")
+	queryTimeout := flag.Duration("query-timeout", simulacra.QUERY_TIMEOUT, "Wait this long for each DNS answer")
+	priorities := flag.String("priority", "", "Poll priorities as msgID=N,... (higher fetched sooner)")
+	authKey := flag.String("auth-key", "", "Shared secret for per-chunk HMAC-SHA256 tags")
+	nameKey := flag.String("names-key", "", "Sender's secret for keyed chunk names ({word}, {sub:...})")
+	requireAuth := flag.Bool("require-auth", false, "Reject chunks without a valid authentication tag")
+	receipt := flag.Bool("receipt", false, "Send the sender a signed receipt after retrieval (and decoding)")
+	receiptKey := flag.String("receipt-key", "", "Shared secret for signing receipts (default: the auth key)")
+	digest := flag.String("digest", simulacra.DIGEST_VERIFY, "Whole-message SHA-256 check: "+simulacra.DIGEST_REQUIRE+" (refuse mismatches and undigested manifests), "+simulacra.DIGEST_VERIFY+" (refuse mismatches) or "+simulacra.DIGEST_WARN)
+	verifyKey := flag.String("verify-key", "", "Sender's Ed25519 public key (hex, or a file holding it); unsigned or forged manifests are refused")
+	randomCase := flag.Bool("case", false, "Randomize the letter case of query names, as 0x20 resolvers do")
+	caseSignal := flag.String("case-signal", "", "Short signal (up to 254 bytes) written into query name case, read by dns-server -case-key")
+	caseKey := flag.String("case-key", "", "Shared secret for -case-signal")
+	queryProfile := flag.String("query-profile", string(fingerprint.PROFILE_NONE), "Query fingerprint randomization ("+strings.Join(fingerprint.ProfileNames(), ", ")+")")
+	useCreds := flag.Bool("creds", false, "Unlock the encrypted credential store at startup")
+	credsFile := flag.String("creds-file", "", "Credential store file (default: state dir)")
+	tags := flag.String("tags", "", "Poll only for messages carrying all these tags (comma-separated)")
+	group := flag.String("group", "", "Poll in this consumer group: every group gets each message once, shared out among the receivers polling in it")
+	archiveDir := flag.String("archive-dir", "", "Also keep each message's fetched chunks as <id>.chunkset in this directory")
+	useCache := flag.Bool("cache", false, "Keep validated chunks on disk and reuse them in later attempts")
+	cacheDir := flag.String("cache-dir", "", "Chunk cache directory (default: state dir)")
+	resume := flag.Bool("resume", false, "Journal each retrieval's chunks as they arrive and resume a failed or interrupted one from its journal")
+	journalDir := flag.String("journal-dir", "", "Fetch journal directory (default: state dir)")
+	edns0 := flag.Uint("edns0", chunker.EDNS0_BUFFER_SIZE, "EDNS0 UDP buffer size advertised on every query (0 = only when an answer needs it)")
+	tcpFallback := flag.Bool("tcp-fallback", true, "Repeat truncated (TC) answers over TCP")
+	transportName := flag.String("transport", transport.NET_UDP, "How queries travel ("+strings.Join(transport.Names(), ", ")+"); with dot and doh, -server names DoT servers (host[:853]) or DoH URLs, or cloudflare, google, quad9")
+	doh := flag.String("doh", "", "Send queries over HTTPS to this DoH resolver (URL, or cloudflare, google, quad9) instead of -server (same as -transport doh -server ...)")
+	dot := flag.String("dot", "", "Send queries over TLS to this DoT server (host[:853], or cloudflare, google, quad9) instead of -server (same as -transport dot -server ...)")
+	tlsCA := flag.String("tls-ca", "", "CA certificate (PEM) to trust for DoT and DoH, e.g. a self-signed server's")
+	cacheAssisted := flag.Bool("cache-assisted", false, "Treat -server as a recursive resolver: ask its cache first (RD clear) and recurse only on a miss")
+	cacheOnly := flag.Bool("cache-only", false, "Read only what the resolver at -server has cached, never recursing (implies -cache-assisted, skips -canary)")
+	probe := flag.Bool("canary", true, "Query the server's canary first and stop early if it is down, the domain is wrong or a resolver interferes")
+	progressMode := flag.String("progress", string(progress.MODE_BAR), "Progress reporting ("+strings.Join(progress.ModeNames(), ", ")+"; json writes events to stderr)")
+	flag.String(config.FLAG_NAME, "", config.USAGE)
+	flag.Parse()
+	if _, err := config.Apply(flag.CommandLine, "stego-receive"); err != nil {
+		log.Fatalf("❌ Invalid configuration: %v", err)
+	}
 
-		chunk, err := chk.DecodeChunk(encoded)
+	var creds *credstore.Store
+	if *useCreds {
+		var err error
+		creds, err = credstore.Unlock(*credsFile)
 		if err != nil {
-			return nil, fmt.Errorf("chunk decode failed: %w", err)
+			log.Fatalf("Credential store: %v", err)
 		}
-
-		chunks = append(chunks, *chunk)
 	}
 
-	// Reassemble
-	data, err := chk.ReassembleMessage(chunks)
+	fmt.Println("\n📡 DNS COVERT CHANNEL RECEIVER")
+
+	reporter, err := progress.New(*progressMode)
 	if err != nil {
-		return nil, err
+		log.Fatalf("❌ %v", err)
 	}
 
-	return data, nil
-}
-
-// PollForNewMessages continuously checks for new messages
-func (r *Receiver) PollForNewMessages(clientID string) {
-	fmt.Printf("\n👁️ POLLING MODE\n")
-	fmt.Printf("   Client ID: %s\n", clientID)
-	fmt.Printf("   Poll interval: %v\n", r.pollInterval)
-	fmt.Println("\nWaiting for messages... (Press Ctrl+C to stop)")
-
-	// LESSON: Polling Patterns
-	// - Fixed interval: Simple but predictable
-	// - Exponential backoff: Reduces load when idle
-	// - Jittered: Avoids synchronized polling
-
-	consecutiveEmpty := 0
-
-	for {
-		// Query for new messages
-		newMsgIDs, err := r.checkForNewMessages(clientID)
+	servers := strings.Split(*server, ",")
+	receive := simulacra.ReceiverConfig{
+		Server:       servers[0],
+		Resolvers:    servers[1:],
+		PollInterval: *pollInterval,
+		PollMaxPause: *pollMax,
+		PollJitter:   *pollJitter,
+		BeaconJitter: *beaconJitter,
+		Domain:       *domain,
+		BatchSize:    *batch,
+		Parallel:     *parallel,
+		QueryRate:    *queryRate,
+		CoverRatio:   *coverRatio,
+		Priorities:   make(map[string]int),
+		RequireAuth:  *requireAuth,
+		DigestMode:   *digest,
+		Reporter:     reporter,
+		History:      true,
+		QueryTimeout: *queryTimeout,
+		Timeout:      *timeout,
+	}
+	if *parallel < 1 || *parallel > simulacra.MAX_PARALLEL {
+		log.Fatalf("❌ -parallel must be 1-%d", simulacra.MAX_PARALLEL)
+	}
+	if *queryRate < 0 {
+		log.Fatal("❌ -rate can't be negative")
+	}
+	if *coverRatio < 0 {
+		log.Fatal("❌ -cover-ratio can't be negative")
+	}
+	if receive.Cover, err = simulacra.NewCoverEngine(*coverSites, *coverMix, *coverTempo); err != nil {
+		log.Fatalf("❌ Invalid cover traffic: %v", err)
+	}
+	if *pollJitter < 0 || *pollJitter > 1 {
+		log.Fatal("❌ -poll-jitter must be between 0 and 1")
+	}
+	if receive.Beacon, err = dnsserver.ParseBeaconInterval(*beacon); err != nil {
+		log.Fatalf("❌ Invalid -beacon: %v", err)
+	}
+	if *beaconJitter < 0 || *beaconJitter > 1 {
+		log.Fatal("❌ -beacon-jitter must be between 0 and 1")
+	}
+	if receive.Beacon > 0 && !dnsserver.ValidClientID(*clientID) {
+		log.Fatalf("❌ -client %q can't name a beacon (printable, no ',' or '.', at most %d characters)", *clientID, dnsserver.MAX_TAG_LENGTH)
+	}
+	if (*poll || *batchManifest != "") && (*plaintext != "" || *reportPath != "") {
+		log.Fatal("❌ -plaintext and -report name one file, so they need -msg; -poll and -batch-manifest write decoded_<id>.txt per message")
+	}
+	if *batchManifest != "" && (*poll || *msgID != "") {
+		log.Fatal("❌ -batch-manifest can't be combined with -poll or -msg")
+	}
+	if !*poll && *batchManifest == "" && (*notifyURL != "" || *notifyExec != "") {
+		log.Fatal("❌ -notify-url and -notify-exec need -poll or -batch-manifest")
+	}
+	switch *digest {
+	case simulacra.DIGEST_REQUIRE, simulacra.DIGEST_VERIFY, simulacra.DIGEST_WARN:
+	default:
+		log.Fatalf("❌ Unknown -digest %q (use %s, %s or %s)", *digest, simulacra.DIGEST_REQUIRE, simulacra.DIGEST_VERIFY, simulacra.DIGEST_WARN)
+	}
+	if receive.Queries, err = simulacra.NewQueries(*queryProfile); err != nil {
+		log.Fatalf("❌ %v", err)
+	}
+	queries := receive.Queries
+	if *edns0 > 0xFFFF {
+		log.Fatalf("❌ -edns0 must be at most 65535")
+	}
+	queries.SetEDNS0(uint16(*edns0))
+	queries.SetTCPFallback(*tcpFallback)
+	if *randomCase || *caseSignal != "" {
+		writer, err := chunker.NewCaseWriter([]byte(*caseKey), []byte(*caseSignal))
 		if err != nil {
-			log.Printf("Poll error: %v", err)
-			time.Sleep(r.pollInterval)
-			continue
+			log.Fatalf("❌ Invalid -case-signal: %v", err)
 		}
+		queries.SetCase(writer)
+	}
+	if *doh != "" && *dot != "" {
+		log.Fatal("❌ Choose one of -doh and -dot")
+	}
 
-		if len(newMsgIDs) > 0 {
-			fmt.Printf("\n🔔 New messages: %v\n", newMsgIDs)
-			consecutiveEmpty = 0
-
-			// Retrieve each message
-			for _, msgID := range newMsgIDs {
-				data, err := r.RetrieveMessage(msgID)
-				if err != nil {
-					log.Printf("Failed to retrieve %s: %v", msgID, err)
-					continue
-				}
-
-				// Save retrieved message
-				filename := fmt.Sprintf("received_%s.png", msgID)
-				err = os.WriteFile(filename, data, 0644)
-				if err != nil {
-					log.Printf("Failed to save: %v", err)
-					continue
-				}
-
-				fmt.Printf("💾 Saved to: %s\n", filename)
+	// -doh and -dot are shorthands for -transport with a single -server
+	netName := strings.ToLower(*transportName)
+	for _, shorthand := range []struct{ flag, net, value string }{{"doh", transport.NET_DOH, *doh}, {"dot", transport.NET_DOT, *dot}} {
+		if shorthand.value == "" {
+			continue
+		}
+		if netName != transport.NET_UDP && netName != shorthand.net {
+			log.Fatalf("❌ -%s conflicts with -transport %s", shorthand.flag, netName)
+		}
+		if len(receive.Resolvers) > 0 {
+			log.Fatalf("❌ -%s takes the place of -server; for several resolvers use -transport %s -server a,b,...", shorthand.flag, shorthand.net)
+		}
+		netName, servers = shorthand.net, []string{shorthand.value}
+	}
 
-				// Acknowledge receipt
-				r.acknowledgeMessage(msgID, clientID)
+	switch netName {
+	case transport.NET_UDP:
+		// Plain queries, UDP or TCP as the query profile and truncation decide
+		if *tlsCA != "" {
+			log.Fatal("❌ -tls-ca needs -transport dot or doh")
+		}
+	default:
+		var tlsConfig *tls.Config
+		if netName == transport.NET_DOT || netName == transport.NET_DOH {
+			if tlsConfig, err = transport.LoadTLSConfig(*tlsCA); err != nil {
+				log.Fatalf("❌ %v", err)
 			}
-		} else {
-			consecutiveEmpty++
-
-			// Exponential backoff when idle
-			if consecutiveEmpty > 5 {
-				time.Sleep(r.pollInterval * 2)
-			} else {
-				time.Sleep(r.pollInterval)
+		} else if *tlsCA != "" {
+			log.Fatal("❌ -tls-ca needs -transport dot or doh")
+		}
+		selected, err := transport.New(netName, tlsConfig)
+		if err != nil {
+			log.Fatalf("❌ Invalid -transport: %v", err)
+		}
+		for i, spec := range servers {
+			if servers[i], err = transport.ResolveServer(netName, spec); err != nil {
+				log.Fatalf("❌ %v", err)
 			}
 		}
+		queries.SetTransport(selected)
+
+		switch netName {
+		case transport.NET_DOH:
+			fmt.Printf("🔒 DNS-over-HTTPS via %s\n", strings.Join(servers, ", "))
+		case transport.NET_DOT:
+			fmt.Printf("🔒 DNS-over-TLS via %s\n", strings.Join(servers, ", "))
+		default:
+			fmt.Printf("🔌 DNS over TCP to %s\n", strings.Join(servers, ", "))
+		}
 	}
-}
-
-// checkForNewMessages queries for unread messages
-func (r *Receiver) checkForNewMessages(clientID string) ([]string, error) {
-	queryName := fmt.Sprintf("consume.%s.%s", clientID, r.domain)
-
-	c := new(dns.Client)
-	m := new(dns.Msg)
-	m.SetQuestion(dns.Fqdn(queryName), dns.TypeTXT)
-
-	resp, _, err := c.Exchange(m, r.server)
-	if err != nil {
-		return nil, err
+	receive.Server, receive.Resolvers = servers[0], servers[1:]
+	if *cacheAssisted || *cacheOnly {
+		queries.SetCacheFirst(true, *cacheOnly)
+		if *cacheOnly {
+			// The canary's nonce name is never cached
+			*probe = false
+			fmt.Printf("🗄️  Cache-only retrieval via %s: no query reaches the zone's server\n", receive.Server)
+		} else {
+			fmt.Printf("🗄️  Cache-assisted retrieval via %s\n", receive.Server)
+		}
 	}
-
-	// Parse response
-	for _, ans := range resp.Answer {
-		if txt, ok := ans.(*dns.TXT); ok && len(txt.Txt) > 0 {
-			// Response format: "msgID1,msgID2,msgID3"
-			if txt.Txt[0] != "" {
-				return strings.Split(txt.Txt[0], ","), nil
-			}
+	if len(receive.Resolvers) > 0 {
+		fmt.Printf("🔀 Chunk queries spread over %d resolvers\n", len(servers))
+	}
+	if queries.Profile() != fingerprint.PROFILE_NONE {
+		fmt.Printf("🎭 Query profile: %s\n", queries.Describe())
+	}
+	if *coverRatio > 0 || *coverBackground {
+		fmt.Printf("🎭 Cover traffic: %s\n", receive.Cover.Describe())
+	}
+	if *authKey != "" {
+		receive.AuthKey = []byte(*authKey)
+	} else if stored, ok := lookupCredential(creds, credstore.CRED_CHUNK_AUTH_KEY); ok {
+		receive.AuthKey = []byte(stored)
+	}
+	if *nameKey != "" {
+		receive.NameKey = []byte(*nameKey)
+	} else if stored, ok := lookupCredential(creds, credstore.CRED_NAME_KEY); ok {
+		receive.NameKey = []byte(stored)
+	}
+	if receive.RequireAuth && len(receive.AuthKey) == 0 {
+		log.Fatal("❌ -require-auth needs -auth-key (or a stored chunk-auth-key)")
+	}
+	if *verifyKey == "" {
+		*verifyKey, _ = lookupCredential(creds, credstore.CRED_VERIFY_KEY)
+	}
+	if *verifyKey != "" {
+		if receive.VerifyKey, err = simulacra.LoadVerifyKey(*verifyKey); err != nil {
+			log.Fatalf("❌ Invalid -verify-key: %v", err)
 		}
+		fmt.Println("🔏 Manifests must be signed by the sender's key")
 	}
-
-	return []string{}, nil
-}
-
-// acknowledgeMessage marks a message as consumed
-func (r *Receiver) acknowledgeMessage(msgID, clientID string) {
-	ackName := fmt.Sprintf("ack.%s.%s.%s", msgID, clientID, r.domain)
-
-	c := new(dns.Client)
-	m := new(dns.Msg)
-	m.SetQuestion(dns.Fqdn(ackName), dns.TypeTXT)
-
-	c.Exchange(m, r.server) // Fire and forget
-}
-
-// DecodeAndSave decodes the steganographic image
-func DecodeAndSave(imagePath string, password []byte, outputPath string) error {
-	// Open image
-	file, err := os.Open(imagePath)
-	if err != nil {
-		return err
+	if *receipt {
+		receive.ReceiptKey = []byte(*receiptKey)
+		if len(receive.ReceiptKey) == 0 {
+			receive.ReceiptKey = receive.AuthKey
+		}
+		if len(receive.ReceiptKey) == 0 {
+			log.Fatal("❌ -receipt needs -receipt-key or -auth-key to sign with")
+		}
 	}
-	defer file.Close()
-
-	// Decode image
-	img, _, err := image.Decode(file)
-	if err != nil {
-		return err
+	if receive.Tags, err = dnsserver.ParseTags(*tags); err != nil {
+		log.Fatalf("❌ Invalid tags: %v", err)
 	}
-
-	// Create decoder
-	stegDecoder := decoder.NewSecureStegoDecoder(img, password)
-
-	// Extract and decrypt
-	stegDecoder.ExtractBitStream()
-	err = stegDecoder.ExtractSecurePayload()
-	if err != nil {
-		return err
+	if *group != "" {
+		if receive.Group, err = dnsserver.ParseGroup(*group); err != nil {
+			log.Fatalf("❌ Invalid -group: %v", err)
+		}
 	}
-
-	result, err := stegDecoder.DecryptPayload()
-	if err != nil {
-		return err
+	if *clientEDNS && !dnsserver.ValidClientID(*clientID) {
+		log.Fatalf("❌ -client %q can't travel in EDNS0 (printable, no ',' or '.', at most %d characters)", *clientID, dnsserver.MAX_TAG_LENGTH)
+	}
+	if *clientEDNS {
+		queries.SetOptions(&dns.EDNS0_LOCAL{Code: dnsserver.CLIENT_ID_OPTION, Data: []byte(*clientID)})
+	}
+	if *useCache {
+		if receive.Cache, err = simulacra.OpenChunkCache(*cacheDir); err != nil {
+			log.Fatalf("❌ %v", err)
+		}
+		fmt.Printf("♻️  Chunk cache: %s\n", receive.Cache.Dir())
+	}
+	if *resume {
+		if receive.Journals, err = simulacra.OpenFetchJournals(*journalDir); err != nil {
+			log.Fatalf("❌ %v", err)
+		}
+		fmt.Printf("⏯️  Fetch journals: %s\n", receive.Journals.Dir())
+	}
+	if *archiveDir != "" {
+		if err := os.MkdirAll(*archiveDir, 0755); err != nil {
+			log.Fatalf("❌ Archive directory: %v", err)
+		}
+		receive.ArchiveDir = *archiveDir
+	}
+	if *priorities != "" {
+		for _, entry := range strings.Split(*priorities, ",") {
+			parts := strings.SplitN(entry, "=", 2)
+			if len(parts) != 2 {
+				log.Fatalf("Invalid priority entry: %s", entry)
+			}
+			id := strings.TrimSpace(parts[0])
+			var p int
+			if _, err := fmt.Sscanf(parts[1], "%d", &p); err != nil || p <= 0 {
+				log.Fatalf("Invalid priority for %s: %s", id, parts[1])
+			}
+			receive.Priorities[id] = p
+		}
 	}
 
-	// Save message
-	err = os.WriteFile(outputPath, result.Message, 0644)
+	receiver, err := simulacra.NewReceiver(receive)
 	if err != nil {
-		return err
+		log.Fatalf("❌ %v", err)
 	}
 
-	fmt.Printf("✅ Decoded message saved to: %s\n", outputPath)
-	return nil
-}
+	// Ctrl+C abandons the query in flight and stops retrieval or polling
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-// ProgressBar for visual feedback
-type ProgressBar struct {
-	total   int
-	current int
-}
-
-func NewProgressBar(total int) *ProgressBar {
-	return &ProgressBar{total: total}
-}
-
-func (pb *ProgressBar) Update(current int) {
-	pb.current = current
-	percent := float64(pb.current) / float64(pb.total) * 100
-	barWidth := 30
-	filled := int(float64(barWidth) * percent / 100)
-	bar := strings.Repeat("█", filled) + strings.Repeat("░", barWidth-filled)
-	fmt.Printf("\r   [%s] %d/%d (%.1f%%)", bar, pb.current, pb.total, percent)
-}
-
-func (pb *ProgressBar) Finish() {
-	fmt.Println()
-}
-
-func main() {
-	// Command line flags
-	server := flag.String("server", "localhost:5353", "DNS server")
-	domain := flag.String("domain", "covert.example.com", "Domain")
-	msgID := flag.String("msg", "", "Message ID to retrieve")
-	poll := flag.Bool("poll", false, "Poll for new messages")
-	clientID := flag.String("client", "receiver1", "Client ID for polling")
-	decode := flag.Bool("decode", false, "Decode after retrieval")
-	password := flag.String("password", "", "Password for decoding")
-	output := flag.String("output", "", "Output directory")
-	flag.Parse()
-
-	fmt.Println("\n📡 DNS COVERT CHANNEL RECEIVER")
-
-	receiver := NewReceiver(*server, *domain)
+	if *probe {
+		if _, err := receiver.CheckServer(ctx); err != nil {
+			if ctx.Err() != nil {
+				log.Fatal("🛑 Interrupted")
+			}
+			log.Fatalf("❌ %v (-canary=false skips this check)", err)
+		}
+	}
+	if err := receiver.Configure(ctx); err != nil {
+		if ctx.Err() != nil {
+			log.Fatal("🛑 Interrupted")
+		}
+		log.Fatalf("❌ %v", err)
+	}
+	if *coverBackground {
+		go receiver.RunCover(ctx)
+	}
+	if receive.Beacon > 0 {
+		fmt.Printf("💓 Beacon: every %v (±%.0f%% jitter) as %s\n", receive.Beacon, *beaconJitter*100, *clientID)
+		go receiver.RunBeacon(ctx, *clientID)
+	}
 
 	if *poll {
 		// Polling mode
-		receiver.PollForNewMessages(*clientID)
+		fmt.Printf("\n👁️ POLLING MODE\n")
+		fmt.Printf("   Client ID: %s\n", *clientID)
+		fmt.Printf("   Poll interval: %v (idle: up to %v", *pollInterval, receiver.PollMaxPause())
+		if *pollJitter > 0 {
+			fmt.Printf(", ±%.0f%% jitter", *pollJitter*100)
+		}
+		fmt.Printf(")\n")
+		if len(receive.Tags) > 0 {
+			fmt.Printf("   Tags: %s\n", strings.Join(receive.Tags, ", "))
+		}
+		if receive.Group != "" {
+			fmt.Printf("   Consumer group: %s (its own copy of every message, shared by its members)\n", receive.Group)
+		}
+
+		d := newDaemon(*output, *decode, *password, creds, reporter, *notifyURL, *notifyExec)
+		fmt.Println("\nWaiting for messages... (Press Ctrl+C to stop)")
+
+		receiver.PollWithReceipts(ctx, *clientID, func(msgID string, data []byte, info *simulacra.ContentInfo, err error) (simulacra.ReceiptStatus, error) {
+			return d.handle(ctx, msgID, data, info, err)
+		})
+		fmt.Println("\n🛑 Stopped polling")
+		printResolverStats(receiver)
+		printCoverStats(receiver)
+	} else if *batchManifest != "" {
+		d := newDaemon(*output, *decode, *password, creds, reporter, *notifyURL, *notifyExec)
+		retrieveBatch(ctx, receiver, d, *batchManifest)
+		printResolverStats(receiver)
+		printCoverStats(receiver)
 	} else if *msgID != "" {
 		// Retrieve specific message
 		startTime := time.Now()
 
-		data, err := receiver.RetrieveMessage(*msgID)
+		ret, err := receiver.Retrieve(ctx, *msgID)
+		report := newReport(ret, receiver, err)
 		if err != nil {
+			saveReport(*reportPath, report)
+			if errors.Is(err, context.Canceled) {
+				log.Fatal("🛑 Retrieval interrupted")
+			}
 			log.Fatalf("Retrieval failed: %v", err)
 		}
+		data, info := ret.Data, ret.Info
 
-		// Save image
-		imagePath := fmt.Sprintf("received_%s.png", *msgID)
-		if *output != "" {
-			imagePath = fmt.Sprintf("%s/received_%s.png", *output, *msgID)
-		}
+		// Save under the sender's filename when known
+		imagePath := savePath(*output, *msgID, info)
 
 		err = os.WriteFile(imagePath, data, 0644)
 		if err != nil {
+			report.fail(fmt.Errorf("failed to save: %w", err))
+			saveReport(*reportPath, report)
 			log.Fatalf("Failed to save: %v", err)
 		}
+		report.SavedTo = imagePath
 
 		elapsed := time.Since(startTime)
 
@@ -413,31 +521,84 @@ func main() {
 		fmt.Printf("   Time: %v\n", elapsed)
 		fmt.Printf("   Rate: %.2f KB/s\n", float64(len(data))/1024/elapsed.Seconds())
 		fmt.Printf("   Saved to: %s\n", imagePath)
+		if *cacheAssisted || *cacheOnly {
+			hits, misses := queries.CacheStats()
+			fmt.Printf("   Resolver cache: %d of %d queries answered from cache\n", hits, hits+misses)
+		}
+		printResolverStats(receiver)
+		printCoverStats(receiver)
+		if ret.Manifest != nil && ret.Manifest.Recipient != "" {
+			fmt.Printf("   Addressed to: %s\n", ret.Manifest.Recipient)
+		}
+		if info != nil {
+			fmt.Printf("   Content type: %s\n", info.ContentType)
+			if len(info.TLVs) > 0 {
+				fmt.Printf("   TLVs: %s\n", info.TLVs)
+			}
+		}
+
+		// Check the integrity of each layer
+		fmt.Printf("\n🔍 INTEGRITY:\n")
+		if ret.Signed {
+			fmt.Printf("   Manifest signature: verified\n")
+		}
+		fmt.Printf("   Manifest digest: %s\n", ret.Digest)
+		img, pngErr := checkPNG(data, info, &report.Integrity)
+		switch report.Integrity.PNG {
+		case PNG_VALID:
+			fmt.Printf("   PNG: valid, %dx%d\n", report.Integrity.Width, report.Integrity.Height)
+		case PNG_INVALID:
+			fmt.Printf("   ⚠️  PNG: %v\n", pngErr)
+		case PNG_SKIPPED:
+			fmt.Printf("   PNG: skipped (%s)\n", info.ContentType)
+		}
 
 		// Optionally decode
-		if *decode {
+		if *decode || *plaintext != "" {
 			fmt.Printf("\n4️⃣ Decoding steganographic image...\n")
 
-			var pass []byte
-			if *password != "" {
-				pass = []byte(*password)
-			} else {
-				pass, err = scrypto.GetSecurePassword("Enter password: ")
-				if err != nil {
-					log.Fatal(err)
+			outputPath := *plaintext
+			if outputPath == "" {
+				outputPath = filepath.Join(*output, fmt.Sprintf("decoded_%s.txt", *msgID))
+			}
+
+			var result *decoder.ExtractedMessage
+			err := pngErr
+			if img != nil {
+				var pass []byte
+				if *password != "" {
+					pass = []byte(*password)
+				} else if stored, ok := lookupCredential(creds, credstore.CRED_DECODE_PASSWORD); ok {
+					pass = []byte(stored)
+				} else {
+					pass, err = scrypto.GetSecurePassword("Enter password: ")
+					if err != nil {
+						log.Fatal(err)
+					}
+				}
+
+				if result, err = decodeImage(img, filepath.Base(imagePath), pass, reporter); err == nil {
+					err = os.WriteFile(outputPath, result.Message, 0644)
 				}
 			}
+			report.recordDecode(result, outputPath, err)
 
-			outputPath := fmt.Sprintf("decoded_%s.txt", *msgID)
-			err = DecodeAndSave(imagePath, pass, outputPath)
 			if err != nil {
-				log.Printf("Decode failed: %v", err)
+				receiver.SendReceipt(ctx, *msgID, data, simulacra.RECEIPT_FAILED)
+				report.fail(fmt.Errorf("decode failed: %w", err))
+				saveReport(*reportPath, report)
+				log.Fatalf("❌ Decode failed: %v", err)
 			}
+			fmt.Printf("✅ Decoded message saved to: %s\n", outputPath)
+			receiver.SendReceipt(ctx, *msgID, data, simulacra.RECEIPT_DECODED)
+		} else {
+			receiver.SendReceipt(ctx, *msgID, data, simulacra.RECEIPT_DELIVERED)
 		}
+		saveReport(*reportPath, report)
 
 		fmt.Println("\n✅ RETRIEVAL COMPLETE!")
 	} else {
-		fmt.Println("Please specify -msg ID or -poll")
+		fmt.Println("Please specify -msg ID, -batch-manifest FILE or -poll")
 		flag.Usage()
 	}
 }
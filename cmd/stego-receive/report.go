@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"github.com/faanross/simulacra_txt/internal/decoder"
+	"github.com/faanross/simulacra_txt/pkg/simulacra"
+	"image"
+	"image/png"
+	"os"
+	"time"
+)
+
+// ================================================================================
+// INTEGRITY REPORT
+// Checks a retrieved image end to end and summarizes the transfer as JSON
+// ================================================================================
+
+// LESSON: Every Layer Gets Its Own Check
+// A retrieval passes through three layers, and each can fail on its own:
+//
+//   1. Transport - the chunks reassemble and match the manifest's digest
+//      (and the sender's signature, with -verify-key); pkg/simulacra checks
+//   2. Carrier   - the bytes are a PNG that decodes, CRCs and all
+//   3. Payload   - the hidden message extracts, decrypts and authenticates
+//
+// A message can be intact at layer 1 and still fail at 2 (the sender sent
+// the wrong file) or 3 (wrong password). -report writes one JSON document
+// saying how far a retrieval got, so a script doesn't have to parse emoji.
+
+// PNG check outcomes
+const (
+	PNG_VALID   = "valid"
+	PNG_INVALID = "invalid"
+	PNG_SKIPPED = "skipped" // The extended header says it isn't a PNG
+)
+
+// TransferReport is the JSON summary written by -report
+type TransferReport struct {
+	MessageID    string                    `json:"message_id"`
+	Success      bool                      `json:"success"`
+	Error        string                    `json:"error,omitempty"`
+	Server       string                    `json:"server"`
+	Resolvers    []simulacra.ResolverStats `json:"resolvers,omitempty"`
+	StartedAt    time.Time                 `json:"started_at"`
+	DurationMS   int64                     `json:"duration_ms"`
+	TotalChunks  int                       `json:"total_chunks"`
+	FailedChunks int                       `json:"failed_chunks"`
+	Retries      int                       `json:"retries"`
+	ChunkErrors  []simulacra.ChunkError    `json:"chunk_errors,omitempty"`
+	Bytes        int                       `json:"bytes"`
+	SavedTo      string                    `json:"saved_to,omitempty"`
+	Integrity    IntegrityReport           `json:"integrity"`
+	Decode       *DecodeReport             `json:"decode,omitempty"`
+}
+
+// IntegrityReport covers the transport and carrier checks
+type IntegrityReport struct {
+	ManifestSigned bool   `json:"manifest_signed"`        // Signature checked against -verify-key
+	Digest         string `json:"digest,omitempty"`       // simulacra.DIGEST_STATUS_*
+	SHA256         string `json:"sha256,omitempty"`       // Of the saved data
+	ContentType    string `json:"content_type,omitempty"` // From the extended header
+	Filename       string `json:"filename,omitempty"`     // From the extended header
+	PNG            string `json:"png,omitempty"`          // PNG_*
+	PNGError       string `json:"png_error,omitempty"`
+	Width          int    `json:"width,omitempty"`
+	Height         int    `json:"height,omitempty"`
+}
+
+// DecodeReport covers the payload check
+type DecodeReport struct {
+	Success       bool   `json:"success"`
+	Error         string `json:"error,omitempty"`
+	Output        string `json:"output,omitempty"`
+	Bytes         int    `json:"bytes"`
+	SHA256        string `json:"sha256,omitempty"`
+	Authenticated bool   `json:"authenticated"`
+	Compressed    bool   `json:"compressed"`
+}
+
+// newReport summarizes a retrieval, successful or not
+func newReport(ret *simulacra.Retrieval, receiver *simulacra.Receiver, err error) *TransferReport {
+	report := &TransferReport{
+		MessageID:    ret.MessageID,
+		Success:      err == nil,
+		Server:       ret.Stats.Server,
+		StartedAt:    ret.Stats.StartedAt,
+		DurationMS:   ret.Stats.Duration.Milliseconds(),
+		TotalChunks:  ret.Stats.TotalChunks,
+		FailedChunks: ret.Stats.FailedChunks,
+		Retries:      ret.Stats.Retries,
+		ChunkErrors:  ret.ChunkErrors,
+		Bytes:        len(ret.Data),
+		Integrity: IntegrityReport{
+			ManifestSigned: ret.Signed,
+			Digest:         ret.Digest,
+		},
+	}
+	if err != nil {
+		report.Error = err.Error()
+	}
+	if stats := receiver.ResolverStats(); len(stats) > 1 {
+		report.Resolvers = stats
+	}
+	if ret.Data != nil {
+		report.Integrity.SHA256 = fmt.Sprintf("%x", sha256.Sum256(ret.Data))
+	}
+	if ret.Info != nil {
+		report.Integrity.ContentType = ret.Info.ContentType
+		report.Integrity.Filename = ret.Info.Filename
+	}
+	return report
+}
+
+// fail records err in the report as the outcome
+func (report *TransferReport) fail(err error) {
+	report.Success = false
+	report.Error = err.Error()
+}
+
+// checkPNG decodes data as a PNG, which also verifies every chunk CRC,
+// and records the outcome. Data the extended header calls something else
+// is skipped.
+func checkPNG(data []byte, info *simulacra.ContentInfo, report *IntegrityReport) (image.Image, error) {
+	if info != nil && info.ContentType != "" && info.ContentType != "image/png" {
+		report.PNG = PNG_SKIPPED
+		return nil, fmt.Errorf("content is %s, not a PNG", info.ContentType)
+	}
+
+	img, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		report.PNG = PNG_INVALID
+		report.PNGError = err.Error()
+		return nil, fmt.Errorf("not a valid PNG: %w", err)
+	}
+
+	bounds := img.Bounds()
+	report.PNG = PNG_VALID
+	report.Width, report.Height = bounds.Dx(), bounds.Dy()
+	return img, nil
+}
+
+// recordDecode adds a decode outcome to the report
+func (report *TransferReport) recordDecode(result *decoder.ExtractedMessage, output string, err error) {
+	decode := &DecodeReport{Success: err == nil}
+	if err != nil {
+		decode.Error = err.Error()
+	} else {
+		decode.Output = output
+		decode.Bytes = len(result.Message)
+		decode.SHA256 = fmt.Sprintf("%x", sha256.Sum256(result.Message))
+		decode.Authenticated = result.Authenticated
+		decode.Compressed = result.WasCompressed
+	}
+	report.Decode = decode
+}
+
+// writeReport saves the report as indented JSON
+func writeReport(path string, report *TransferReport) error {
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal report: %w", err)
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0600); err != nil {
+		return fmt.Errorf("failed to write report: %w", err)
+	}
+	return nil
+}
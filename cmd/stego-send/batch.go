@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/faanross/simulacra_txt/internal/progress"
+	"github.com/faanross/simulacra_txt/pkg/simulacra"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ================================================================================
+// BATCH SEND
+// -input-dir: every file of a directory as its own message
+// ================================================================================
+
+// batchSend uploads the files of a directory one message each
+type batchSend struct {
+	client          *simulacra.UploadClient
+	options         simulacra.ChunkOptions
+	hider           *stegoHider // nil = files are sent as they are
+	signer          ed25519.PrivateKey
+	reporter        progress.Reporter
+	server          string
+	domain          string
+	coverBackground bool
+}
+
+// run chunks every file, uploads them in name order after Enter, then
+// writes the batch manifest and prints a summary
+func (b *batchSend) run(ctx context.Context, dir, manifestPath string) {
+	files, err := simulacra.BatchFiles(dir)
+	if err != nil {
+		log.Fatalf("❌ %v", err)
+	}
+	if len(files) == 0 {
+		log.Fatalf("❌ No files to send in %s", dir)
+	}
+	fmt.Printf("📁 Batch: %d files in %s\n", len(files), dir)
+
+	manifest := &simulacra.BatchManifest{
+		Version: simulacra.BATCH_MANIFEST_VERSION,
+		Created: time.Now().UTC(),
+		Server:  b.server,
+		Domain:  b.domain,
+	}
+
+	// Chunk everything first, so unreadable files show before anything is sent
+	sets := make([]*simulacra.ChunkSet, len(files))
+	var estimate time.Duration
+	for i, path := range files {
+		entry, set, err := b.chunk(path)
+		if err != nil {
+			entry.Error = err.Error()
+			fmt.Printf("   ⚠️  %s: %v\n", entry.File, err)
+		} else {
+			estimate += b.estimate(len(set.Message.Chunks))
+		}
+		manifest.Messages = append(manifest.Messages, entry)
+		sets[i] = set
+	}
+
+	fmt.Printf("\n⚙️ Configuration:\n")
+	fmt.Printf("   Server: %s\n", b.server)
+	fmt.Printf("   Domain: %s\n", b.domain)
+	fmt.Printf("   Upload method: %s\n", b.client.Method())
+	if traffic := b.client.Traffic(); b.client.Method() == simulacra.UPLOAD_QNAME && traffic.Name != simulacra.TRAFFIC_NONE {
+		fmt.Printf("   Traffic profile: %s (%s)\n", traffic.Name, traffic.Description)
+	}
+	if b.hider != nil {
+		fmt.Printf("   Stego: every file hidden in a %s image\n", b.hider.style)
+	}
+	if b.options.Recipient != "" {
+		fmt.Printf("   Recipient: %s (only that client ID discovers the messages)\n", b.options.Recipient)
+	}
+	fmt.Printf("   Batch manifest: %s\n", manifestPath)
+	fmt.Printf("\n⏱️ Estimated upload time: %v\n", estimate.Round(time.Second/10))
+
+	fmt.Printf("\nPress Enter to start upload...")
+	waitForEnter(ctx)
+
+	if b.coverBackground {
+		fmt.Printf("\n🎭 Background cover traffic: %s\n", b.client.Cover().Describe())
+		coverCtx, stopCover := context.WithCancel(ctx)
+		defer stopCover()
+		go b.client.RunCover(coverCtx)
+	}
+
+	// One message after another; a failed file doesn't stop the rest
+	for i, set := range sets {
+		if set == nil {
+			continue
+		}
+		entry := &manifest.Messages[i]
+		if ctx.Err() != nil {
+			entry.Error = "not sent: interrupted"
+			continue
+		}
+
+		fmt.Printf("\n📤 [%d/%d] %s → %s\n", i+1, len(sets), entry.File, entry.MessageID)
+		startTime := time.Now()
+		result, err := b.client.UploadChunkSet(ctx, set)
+		recordUpload(entry.MessageID, b.server, set.Message.Chunks, startTime, err)
+		switch {
+		case err == nil:
+			entry.Uploaded = true
+		case errors.Is(err, context.Canceled):
+			entry.Error = "not sent: interrupted"
+		case result != nil:
+			// Published, but receivers may not be able to fetch it
+			entry.Uploaded = true
+			entry.Error = err.Error()
+			fmt.Printf("   ⚠️  Uploaded, but %v\n", err)
+		default:
+			entry.Error = err.Error()
+			fmt.Printf("   ❌ Upload failed: %v\n", err)
+		}
+	}
+
+	writeBatchTable(manifest.Messages)
+	if err := manifest.Save(manifestPath); err != nil {
+		log.Fatalf("❌ %v", err)
+	}
+	uploaded := len(manifest.Uploaded())
+	fmt.Printf("\n📝 Batch manifest written to %s\n", manifestPath)
+	if n := b.client.Cover().Generated(); n > 0 {
+		fmt.Printf("🎭 Cover queries: %d\n", n)
+	}
+	fmt.Printf("\nExample receiver command:\n")
+	fmt.Printf("  go run cmd/stego-receive/main.go -server %s -batch-manifest %s\n", b.server, manifestPath)
+
+	if ctx.Err() != nil {
+		log.Fatalf("🛑 Batch interrupted: %d of %d files uploaded", uploaded, len(files))
+	}
+	if uploaded < len(files) {
+		log.Fatalf("❌ %d of %d files uploaded", uploaded, len(files))
+	}
+	fmt.Printf("\n🎉 Batch complete: %d files uploaded\n", uploaded)
+}
+
+// chunk reads a file, hides it if asked, then chunks and signs it
+func (b *batchSend) chunk(path string) (simulacra.BatchEntry, *simulacra.ChunkSet, error) {
+	entry := simulacra.BatchEntry{File: filepath.Base(path), Stego: b.hider != nil}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return entry, nil, err
+	}
+	digest := sha256.Sum256(data)
+	entry.Bytes, entry.SHA256 = int64(len(data)), hex.EncodeToString(digest[:])
+
+	set, err := chunkInput(data, entry.File, b.options, b.reporter, b.hider)
+	if err != nil {
+		return entry, nil, err
+	}
+	if b.signer != nil {
+		if err := simulacra.SignChunkSet(set, b.signer); err != nil {
+			return entry, nil, fmt.Errorf("signing manifest: %w", err)
+		}
+	}
+	entry.MessageID, entry.Chunks = set.MessageID(), len(set.Message.Chunks)
+	return entry, set, nil
+}
+
+// estimate returns how long uploading a message of so many chunks should take
+func (b *batchSend) estimate(chunks int) time.Duration {
+	if b.client.Method() == simulacra.UPLOAD_QNAME {
+		return b.client.EstimateUpload(chunks)
+	}
+	return time.Duration(chunks+1) * b.client.RateLimit()
+}
+
+// writeBatchTable prints one line per file of the batch
+func writeBatchTable(entries []simulacra.BatchEntry) {
+	fmt.Println("\n📋 BATCH SUMMARY")
+	fmt.Println(strings.Repeat("-", 90))
+	fmt.Printf("%-30s  %-16s  %10s  %6s  %s\n", "File", "Message", "Bytes", "Chunks", "Status")
+	fmt.Println(strings.Repeat("-", 90))
+
+	for _, e := range entries {
+		status := "ok"
+		switch {
+		case e.Uploaded && e.Error != "":
+			status = "uploaded, " + e.Error
+		case !e.Uploaded:
+			status = "FAILED: " + e.Error
+		}
+		msgID := e.MessageID
+		if msgID == "" {
+			msgID = "-"
+		}
+		fmt.Printf("%-30s  %-16s  %10d  %6d  %s\n", e.File, msgID, e.Bytes, e.Chunks, status)
+	}
+}
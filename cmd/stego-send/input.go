@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/faanross/simulacra_txt/internal/encoder"
+	"github.com/faanross/simulacra_txt/internal/progress"
+	"github.com/faanross/simulacra_txt/internal/scrypto"
+	"github.com/faanross/simulacra_txt/internal/spec"
+	"github.com/faanross/simulacra_txt/pkg/simulacra"
+	"image/png"
+)
+
+// ================================================================================
+// INPUT FILES
+// Reading, optionally hiding and chunking what gets sent
+// ================================================================================
+
+// stegoHider hides files in stego images before they are chunked, as the
+// encoder command does
+type stegoHider struct {
+	password []byte
+	style    encoder.CoverStyle
+}
+
+// newStegoHider checks the cover style and takes -password, or asks for
+// one twice
+func newStegoHider(password, cover string) (*stegoHider, error) {
+	style, err := encoder.ParseCoverStyle(cover)
+	if err != nil {
+		return nil, err
+	}
+	h := &stegoHider{style: style}
+
+	if password != "" {
+		h.password = []byte(password)
+	} else {
+		if h.password, err = scrypto.GetSecurePassword("\n🔑 Enter -stego password (min 8 chars): "); err != nil {
+			return nil, err
+		}
+		confirm, err := scrypto.GetSecurePassword("🔑 Confirm password: ")
+		if err != nil {
+			return nil, err
+		}
+		if !bytes.Equal(h.password, confirm) {
+			return nil, fmt.Errorf("passwords do not match")
+		}
+	}
+	if len(h.password) < 8 {
+		return nil, fmt.Errorf("password must be at least 8 characters")
+	}
+	return h, nil
+}
+
+// hide encrypts data into a stego PNG
+func (h *stegoHider) hide(data []byte) ([]byte, error) {
+	stegoEncoder := encoder.NewSecureStegoEncoder(data, h.password, spec.DEFAULT_WIDTH, true)
+	stegoEncoder.SetCoverStyle(h.style)
+
+	img, err := stegoEncoder.CreateStegoImage()
+	if err != nil {
+		return nil, fmt.Errorf("stego encoding failed: %w", err)
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, fmt.Errorf("PNG encoding failed: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// chunkInput chunks a file's data for upload, first hiding it in a stego
+// image (sent as <name>.png) when hider is set
+func chunkInput(data []byte, name string, options simulacra.ChunkOptions, reporter progress.Reporter, hider *stegoHider) (*simulacra.ChunkSet, error) {
+	task := progress.Begin(reporter, progress.STAGE_CHUNK, name, 0)
+	options.Config.Logger = progress.ChunkerLogger(reporter, progress.STAGE_CHUNK, name)
+
+	if hider != nil {
+		var err error
+		if data, err = hider.hide(data); err != nil {
+			task.Finish(err)
+			return nil, err
+		}
+		name += ".png"
+	}
+	set, err := simulacra.ChunkData(data, name, options)
+	task.Finish(err)
+	return set, err
+}
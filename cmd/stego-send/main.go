@@ -1,198 +1,96 @@
 package main
 
 import (
-	"bytes"
-	"encoding/json"
+	"context"
+	"crypto/ed25519"
+	"errors"
 	"flag"
 	"fmt"
 	"github.com/faanross/simulacra_txt/internal/chunker"
-	"github.com/miekg/dns"
+	"github.com/faanross/simulacra_txt/internal/clientstate"
+	"github.com/faanross/simulacra_txt/internal/config"
+	"github.com/faanross/simulacra_txt/internal/credstore"
+	"github.com/faanross/simulacra_txt/internal/decoder"
+	dnsserver "github.com/faanross/simulacra_txt/internal/dns-server"
+	"github.com/faanross/simulacra_txt/internal/encoder"
+	"github.com/faanross/simulacra_txt/internal/fingerprint"
+	"github.com/faanross/simulacra_txt/internal/progress"
+	"github.com/faanross/simulacra_txt/internal/publisher"
+	"github.com/faanross/simulacra_txt/internal/transport"
+	"github.com/faanross/simulacra_txt/pkg/simulacra"
+	"image"
+	_ "image/png"
 	"log"
-	"math/rand"
 	"net/http"
 	"os"
+	"os/signal"
+	"path/filepath"
 	"strings"
+	"syscall"
 	"time"
 )
 
 // ================================================================================
 // DNS UPLOAD CLIENT - Sender side of covert channel
-// Uploads chunked steganographic images to DNS server
+// Uploads chunked steganographic images to DNS server (see pkg/simulacra)
 // ================================================================================
 
-// UploadClient handles covert uploads to DNS server
-type UploadClient struct {
-	server      string        // DNS server address
-	domain      string        // Target domain
-	rateLimit   time.Duration // Delay between queries
-	maxRetries  int           // Retry failed uploads
-	stealthMode bool          // Add random delays and cover traffic
-}
-
-// NewUploadClient creates an upload client
-func NewUploadClient(server, domain string) *UploadClient {
-	return &UploadClient{
-		server:      server,
-		domain:      domain,
-		rateLimit:   100 * time.Millisecond, // Default: 10 queries/sec
-		maxRetries:  3,
-		stealthMode: false,
-	}
-}
-
-// UploadMessage uploads a complete message to DNS server via HTTP
-func (uc *UploadClient) UploadMessage(msgID string, chunks []chunker.Chunk, manifest string) error {
-	totalChunks := len(chunks)
-
-	fmt.Printf("\n📤 UPLOADING MESSAGE: %s\n", msgID)
-	fmt.Printf("   Chunks to upload: %d\n", totalChunks)
-	fmt.Printf("   Server: %s\n", uc.server)
-
-	// Prepare chunks map
-	chunkMap := make(map[string]string)
-	for i, chunk := range chunks {
-		chunkName := fmt.Sprintf("c-%d-%s.data.%s", i, msgID, uc.domain)
-		chunkMap[chunkName] = chunk.Encoded
-	}
-
-	// Add manifest
-	manifestName := fmt.Sprintf("m-%s.data.%s", msgID, uc.domain)
-	chunkMap[manifestName] = manifest
-
-	// Create upload request
-	uploadReq := struct {
-		MessageID string            `json:"message_id"`
-		Chunks    map[string]string `json:"chunks"`
-		Manifest  string            `json:"manifest"`
-	}{
-		MessageID: msgID,
-		Chunks:    chunkMap,
-		Manifest:  manifest,
+// checkCarrier warns when a stego image's payload fills too much of its LSBs.
+// Files that aren't images are sent without a check.
+func checkCarrier(imagePath string) {
+	file, err := os.Open(imagePath)
+	if err != nil {
+		return
 	}
+	defer file.Close()
 
-	// Convert to JSON
-	jsonData, err := json.Marshal(uploadReq)
+	img, _, err := image.Decode(file)
 	if err != nil {
-		return fmt.Errorf("failed to marshal request: %w", err)
+		return
 	}
 
-	// Extract host from DNS server address (remove port)
-	serverHost := strings.Split(uc.server, ":")[0]
-	httpURL := fmt.Sprintf("http://%s:8080/upload", serverHost)
-
-	fmt.Printf("   Uploading to: %s\n", httpURL)
-
-	// Send HTTP POST request
-	resp, err := http.Post(httpURL, "application/json", bytes.NewBuffer(jsonData))
+	payloadBytes, err := decoder.ReadPayloadLength(img)
 	if err != nil {
-		return fmt.Errorf("HTTP upload failed: %w", err)
+		return
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("server returned status: %s", resp.Status)
+	bounds := img.Bounds()
+	report := encoder.AnalyzeCapacity(bounds.Dx(), bounds.Dy(), payloadBytes, encoder.CapacityOptions{
+		Mode: encoder.EMBED_SEQUENTIAL,
+	})
+	if !report.Fits {
+		// No valid length header: not one of our stego images
+		return
 	}
-
-	// Parse response
-	var result map[string]string
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		return fmt.Errorf("failed to parse response: %w", err)
+	if report.ShouldWarn() {
+		report.Print()
 	}
-
-	fmt.Printf("\n✅ Upload successful!\n")
-	fmt.Printf("   Message ID: %s\n", result["message_id"])
-	fmt.Printf("   Chunks uploaded: %s\n", result["chunks"])
-
-	return nil
 }
 
-// applyRateLimit adds delay between queries
-func (uc *UploadClient) applyRateLimit() {
-	if uc.stealthMode {
-		// Add jitter: 50% to 150% of base rate
-		jitter := uc.rateLimit/2 + time.Duration(rand.Int63n(int64(uc.rateLimit)))
-		time.Sleep(jitter)
-	} else {
-		time.Sleep(uc.rateLimit)
+// recordUpload appends upload statistics to the local transfer history
+func recordUpload(msgID, server string, chunks []simulacra.Chunk, startTime time.Time, uploadErr error) {
+	bytes := 0
+	for _, chunk := range chunks {
+		bytes += len(chunk.Payload)
 	}
-}
-
-// generateCoverTraffic creates legitimate-looking DNS queries
-func (uc *UploadClient) generateCoverTraffic() {
-	// LESSON: Cover Traffic
-	// Mix covert queries with legitimate ones to avoid detection
 
-	coverDomains := []string{
-		"www.google.com",
-		"www.cloudflare.com",
-		"cdn.jsdelivr.net",
-		"api.github.com",
+	record := clientstate.TransferRecord{
+		MessageID:   msgID,
+		Direction:   clientstate.DirectionUpload,
+		Server:      server,
+		StartedAt:   startTime,
+		Duration:    time.Since(startTime),
+		Bytes:       bytes,
+		TotalChunks: len(chunks),
+		Success:     uploadErr == nil,
 	}
-
-	domain := coverDomains[rand.Intn(len(coverDomains))]
-
-	c := new(dns.Client)
-	m := new(dns.Msg)
-	m.SetQuestion(dns.Fqdn(domain), dns.TypeA)
-
-	c.Exchange(m, uc.server) // Ignore response
-}
-
-// ProgressBar shows upload progress
-type ProgressBar struct {
-	total   int
-	current int
-}
-
-func NewProgressBar(total int) *ProgressBar {
-	return &ProgressBar{total: total}
-}
-
-func (pb *ProgressBar) Update(current int) {
-	pb.current = current
-
-	// Calculate percentage
-	percent := float64(pb.current) / float64(pb.total) * 100
-
-	// Build progress bar
-	barWidth := 30
-	filled := int(float64(barWidth) * percent / 100)
-
-	bar := strings.Repeat("█", filled) + strings.Repeat("░", barWidth-filled)
-
-	fmt.Printf("\r   [%s] %d/%d (%.1f%%)", bar, pb.current, pb.total, percent)
-}
-
-func (pb *ProgressBar) Finish() {
-	fmt.Println() // New line after progress bar
-}
-
-// LoadAndChunkImage prepares an image for upload
-func LoadAndChunkImage(imagePath string) (string, []chunker.Chunk, string, error) {
-	// Read image
-	data, err := os.ReadFile(imagePath)
-	if err != nil {
-		return "", nil, "", fmt.Errorf("failed to read image: %w", err)
+	if uploadErr != nil {
+		record.Error = uploadErr.Error()
 	}
 
-	// Create chunker
-	chk := chunker.NewChunker(chunker.ChunkerConfig{
-		Encoding: chunker.ENCODE_BASE32,
-	})
-
-	// Chunk the image
-	msg, err := chk.ChunkMessage(data)
-	if err != nil {
-		return "", nil, "", fmt.Errorf("failed to chunk: %w", err)
+	if err := clientstate.AppendHistory(record); err != nil {
+		log.Printf("Failed to record transfer history: %v", err)
 	}
-
-	// Generate message ID
-	msgID := fmt.Sprintf("%x", msg.ID[:8])
-
-	// Create manifest
-	manifest := fmt.Sprintf("%d:checksum:%d", len(msg.Chunks), time.Now().Unix())
-
-	return msgID, msg.Chunks, manifest, nil
 }
 
 func main() {
@@ -200,78 +98,573 @@ func main() {
 	server := flag.String("server", "localhost:5353", "DNS server address")
 	domain := flag.String("domain", "covert.example.com", "Target domain")
 	input := flag.String("input", "", "Input image file")
+	inputDir := flag.String("input-dir", "", "Upload every file in this directory as its own message, one after another (see -batch-manifest)")
+	batchManifest := flag.String("batch-manifest", "", "With -input-dir, list the uploaded messages in this file for stego-receive -batch-manifest (default: <dir>.batch.json)")
+	stego := flag.Bool("stego", false, "Hide each -input or -input-dir file in a stego PNG before chunking, as the encoder does (receivers -decode it)")
+	password := flag.String("password", "", "Password for -stego (prompt if not provided)")
+	stegoCover := flag.String("stego-cover", string(encoder.COVER_NOISE), "Cover image style for -stego ("+strings.Join(encoder.CoverStyleNames(), ", ")+")")
 	zoneFile := flag.String("zone", "", "Pre-generated zone file")
+	archive := flag.String("archive", "", "Upload a chunk set archive (written by -save-archive or the chunker) instead of -input")
+	saveArchive := flag.String("save-archive", "", "Also write the chunked message to this chunk set archive")
 	rateLimit := flag.Int("rate", 10, "Queries per second")
-	stealth := flag.Bool("stealth", false, "Enable stealth mode")
+	stealth := flag.Bool("stealth", false, "Enable stealth mode (the steady traffic profile and mixed query fingerprints)")
+	trafficProfile := flag.String("traffic-profile", "", "Shape the timing, order and cover traffic of -upload qname queries ("+strings.Join(simulacra.TrafficProfileNames(), ", ")+"; default: steady with -stealth, else none)")
+	coverRatio := flag.Float64("cover-ratio", 0, "Cover queries per chunk query, e.g. 0.5 (default: the traffic profile's; -1 = none)")
+	coverSites := flag.String("cover-sites", "", "Site list for cover queries: one \"domain host...\" line per site, most popular first (default: built-in list)")
+	coverMix := flag.String("cover-mix", "", "Cover query types and weights, e.g. A=50,AAAA=30,TXT=12,MX=8 (the default)")
+	coverTempo := flag.Float64("cover-tempo", 1, "Scale the pauses of -cover-background, e.g. 0.5 for twice as busy")
+	coverBackground := flag.Bool("cover-background", false, "Also send cover queries to -server at their own browsing-like timing for the whole run")
+	deterministic := flag.Bool("deterministic", false, "Content-addressed message ID (re-sending a file reuses its ID)")
+	shuffle := flag.Bool("shuffle", false, "Publish chunks in a keyed pseudo-random order")
+	workers := flag.Int("workers", 1, "Goroutines encoding chunks (-1 = one per CPU)")
+	meta := flag.Bool("meta", true, "Send an extended header with the filename, MIME type and SHA-256")
+	shard := flag.Bool("shard", false, "Serve each chunk split across TXT, AAAA and NULL records")
+	wireVersion := flag.Uint("wire-version", 0, "Chunk header format (2 = 16-bit sequence, 3 = 32-bit; 0 = smallest that fits)")
+	awaitReceipt := flag.Duration("await-receipt", 0, "Wait this long for the receiver's signed receipt (e.g. 10m; 0 = don't wait)")
+	timeout := flag.Duration("timeout", 0, "Give up on the upload after this long, retries included (e.g. 5m; 0 = no limit)")
+	queryTimeout := flag.Duration("query-timeout", simulacra.QUERY_TIMEOUT, "Wait this long for each DNS answer")
+	receiptKey := flag.String("receipt-key", "", "Shared secret receipts are signed with (default: the auth key)")
+	randomCase := flag.Bool("case", false, "Randomize the letter case of query names, as 0x20 resolvers do")
+	caseSignal := flag.String("case-signal", "", "Short signal (up to 254 bytes) written into query name case, read by dns-server -case-key")
+	caseKey := flag.String("case-key", "", "Shared secret for -case-signal")
+	queryProfile := flag.String("query-profile", "", "Query fingerprint randomization ("+strings.Join(fingerprint.ProfileNames(), ", ")+"; default: mixed with -stealth, else none)")
+	encoding := flag.String("encoding", chunker.ENCODE_BASE32, "Chunk encoding ("+strings.Join(chunker.EncodingNames(), ", ")+")")
+	fec := flag.Float64("fec", 0, "FEC redundancy factor (e.g. 0.25 = 25% parity chunks)")
+	integrity := flag.String("integrity", chunker.DEFAULT_INTEGRITY, "Per-chunk checksum ("+strings.Join(chunker.IntegrityNames(), ", ")+"; faster to more collision resistant: crc32c, xxhash64, crc64, sha256)")
+	fecScheme := flag.String("fec-scheme", chunker.FEC_REED_SOLOMON, "FEC scheme ("+strings.Join(chunker.FECSchemeNames(), ", ")+"; fountain allows -fec up to 4)")
+	compress := flag.String("compress", "", "Compress before chunking ("+strings.Join(chunker.CompressionNames()[1:], ", ")+")")
+	txtStrings := flag.Int("strings", 1, "TXT strings per chunk record (1-16, >1 needs EDNS0)")
+	sizing := flag.String("profile", "", "Sizing profile ("+strings.Join(chunker.ProfileNames(), ", ")+"; overrides -strings)")
+	names := flag.String("names", chunker.DEFAULT_NAME_TEMPLATE, "Chunk naming template ({seq}, {id}, or keyed {word} and {sub:a|b|c}; relative to -domain)")
+	nameKey := flag.String("names-key", "", "Secret keying {word} and {sub:...} names; receivers need it to find the chunks")
+	authKey := flag.String("auth-key", "", "Shared secret for per-chunk HMAC-SHA256 tags")
+	signKey := flag.String("sign-key", "", "Ed25519 private key (hex, or a file holding it) to sign the manifest with")
+	genSignKey := flag.String("gen-sign-key", "", "Create a signing key pair at this path (public key in <path>.pub) and exit")
+	useCreds := flag.Bool("creds", false, "Unlock the encrypted credential store at startup")
+	credsFile := flag.String("creds-file", "", "Credential store file (default: state dir)")
+	tlvSpec := flag.String("tlv", "", "Metadata TLVs sealed in the extended header, as type=value,... (types: transport, routing, fec or 0-255)")
+	manifestTLVSpec := flag.String("manifest-tlv", "", "Metadata TLVs published in the manifest for servers and relays (same syntax as -tlv)")
+	manifestFormatName := flag.String("manifest-format", chunker.MANIFEST_FORMAT_TEXT, "Manifest format ("+strings.Join(chunker.ManifestFormatNames(), ", ")+"; structured needs receivers that understand it)")
+	tags := flag.String("tags", "", "Comma-separated tags receivers can filter discovery by (e.g. campaign-q3,image)")
+	recipient := flag.String("to", "", "Address the message to a receiver's client ID: only that receiver discovers it (not with -upload update)")
+	burn := flag.Bool("burn", false, "Burn after reading: the server deletes the message once every chunk was fetched or it was acknowledged (not with -upload update)")
+	priority := flag.String("priority", "", "Discovery priority: receivers get urgent, then high, normal and low messages, oldest first within each (default normal; not with -upload update)")
+	visibility := flag.String("visibility", "", "Redeliver unless a receiver acknowledges within this after discovering the message, e.g. 10m (default: the server's -visibility-timeout; not with -upload update)")
+	uploadMethod := flag.String("upload", simulacra.UPLOAD_QNAME, "Upload method ("+simulacra.UPLOAD_QNAME+" to send chunks inside TXT query names, so upload and download both ride DNS - the server must run with -qname-uploads; "+simulacra.UPLOAD_HTTP+" to post the message to the server's API; or "+simulacra.UPLOAD_UPDATE+" for RFC 2136 DNS UPDATE to any authoritative -server)")
+	updateZone := flag.String("update-zone", "", "Zone to send DNS UPDATEs for (default: -domain)")
+	updateTTL := flag.String("ttl", "", "Record TTLs for -upload update, e.g. chunk=1h,manifest=5m,jitter=20% (default: 300s each)")
+	apiURL := flag.String("api-url", "", "Base URL of the server's HTTP API, e.g. https://ns1.example.com:8443 (default: http://<-server host>:8080)")
+	apiCA := flag.String("api-ca", "", "CA certificate (PEM) to trust for an HTTPS -api-url, e.g. a self-signed server's")
+	apiKey := flag.String("api-key", "", "Key for the server's HTTP API as id:secret (dns-server -api-keys); uploads are HMAC-signed")
+	qnameKey := flag.String("qname-key", "", "Secret signing -upload qname queries (dns-server -qname-key)")
+	tsigKey := flag.String("tsig", "", "TSIG key for DNS UPDATE as [algorithm:]name:secret (nsupdate -y format)")
+	verify := flag.Int("verify", 0, "After uploading, query the manifest and this many random chunks back and fail unless all are servable (-1 = every chunk)")
+	verifyResolver := flag.String("verify-resolver", "", "Resolver to verify through, e.g. 8.8.8.8:53 - the one receivers use (default: -server)")
+	probe := flag.Bool("canary", true, "Query the server's canary first and stop early if it is down, the domain is wrong or a resolver interferes")
+	progressMode := flag.String("progress", string(progress.MODE_BAR), "Progress reporting ("+strings.Join(progress.ModeNames(), ", ")+"; json writes events to stderr)")
+	flag.String(config.FLAG_NAME, "", config.USAGE)
 	flag.Parse()
+	if _, err := config.Apply(flag.CommandLine, "stego-send"); err != nil {
+		log.Fatalf("❌ Invalid configuration: %v", err)
+	}
+
+	if *genSignKey != "" {
+		public, err := simulacra.WriteSigningKey(*genSignKey)
+		if err != nil {
+			log.Fatalf("❌ %v", err)
+		}
+		fmt.Printf("🔑 Signing key written to %s\n", *genSignKey)
+		fmt.Printf("   Public key (give receivers -verify-key): %s\n", chunker.EncodeVerifyKey(public))
+		fmt.Printf("   Also saved to %s.pub\n", *genSignKey)
+		return
+	}
+
+	if *input == "" && *inputDir == "" && *zoneFile == "" && *archive == "" {
+		log.Fatal("Please provide -input (image), -input-dir (directory), -archive (chunk set) or -zone (zone file)")
+	}
+	if *inputDir != "" && (*input != "" || *zoneFile != "" || *archive != "") {
+		log.Fatal("-input-dir can't be combined with -input, -archive or -zone")
+	}
+	if *inputDir != "" && (*saveArchive != "" || *awaitReceipt > 0) {
+		log.Fatal("-save-archive and -await-receipt take one message, not -input-dir")
+	}
+	if *batchManifest != "" && *inputDir == "" {
+		log.Fatal("-batch-manifest needs -input-dir")
+	}
+	if *stego && *input == "" && *inputDir == "" {
+		log.Fatal("-stego hides -input or -input-dir files")
+	}
+	tlvs, err := chunker.ParseTLVSpec(*tlvSpec)
+	if err != nil {
+		log.Fatalf("Invalid -tlv: %v", err)
+	}
+	manifestTLVs, err := chunker.ParseTLVSpec(*manifestTLVSpec)
+	if err != nil {
+		log.Fatalf("Invalid -manifest-tlv: %v", err)
+	}
+	manifestFormat, err := chunker.ParseManifestFormat(*manifestFormatName)
+	if err != nil {
+		log.Fatalf("Invalid -manifest-format: %v", err)
+	}
+	if err := chunker.ValidateNameTemplate(*names); err != nil {
+		log.Fatal(err)
+	}
+	if _, err := chunker.ParseIntegrity(*integrity); err != nil {
+		log.Fatalf("Invalid -integrity: %v", err)
+	}
+	switch *uploadMethod {
+	case simulacra.UPLOAD_HTTP, simulacra.UPLOAD_QNAME, simulacra.UPLOAD_UPDATE:
+	default:
+		log.Fatalf("Unknown upload method %q (use %s, %s or %s)", *uploadMethod, simulacra.UPLOAD_HTTP, simulacra.UPLOAD_QNAME, simulacra.UPLOAD_UPDATE)
+	}
+	if *uploadMethod == simulacra.UPLOAD_UPDATE && *shard {
+		log.Fatal("-shard needs the simulacra DNS server, not -upload update")
+	}
+	if *burn && *uploadMethod == simulacra.UPLOAD_UPDATE {
+		log.Fatal("-burn needs the simulacra DNS server, not -upload update")
+	}
+	if *burn && *shard {
+		// Each sharded chunk is fetched once per share
+		log.Fatal("-burn can't be combined with -shard")
+	}
+	if *uploadMethod == simulacra.UPLOAD_QNAME && manifestFormat == chunker.MANIFEST_FORMAT_STRUCTURED {
+		// The manifest travels in a single query name
+		log.Fatal("-manifest-format structured is too long for -upload qname")
+	}
+	if *uploadMethod == simulacra.UPLOAD_QNAME && *sizing == "" {
+		// Chunks must fit in a query name, not just a TXT record
+		*sizing = chunker.PROFILE_QNAME_MULTI
+	}
 
-	if *input == "" && *zoneFile == "" {
-		log.Fatal("Please provide -input (image) or -zone (zone file)")
+	// Configure the upload client
+	upload := simulacra.UploadConfig{
+		Server:         *server,
+		Domain:         *domain,
+		Method:         *uploadMethod,
+		Stealth:        *stealth,
+		VerifySample:   *verify,
+		VerifyResolver: *verifyResolver,
+		Traffic:        *trafficProfile,
+		CoverRatio:     *coverRatio,
+		Burn:           *burn,
+		UpdateZone:     *updateZone,
+		QueryTimeout:   *queryTimeout,
+		Timeout:        *timeout,
+	}
+	if upload.UpdateTTL, err = chunker.ParseTTLPolicy(*updateTTL); err != nil {
+		log.Fatalf("Invalid -ttl: %v", err)
+	}
+	if *updateTTL != "" && upload.Method != simulacra.UPLOAD_UPDATE {
+		log.Fatal("-ttl applies to -upload update; the simulacra DNS server sets its own TTLs (dns-server -ttl)")
+	}
+	if (*trafficProfile != "" || *coverRatio != 0) && upload.Method != simulacra.UPLOAD_QNAME {
+		log.Fatal("-traffic-profile and -cover-ratio shape -upload qname queries")
+	}
+	if *coverRatio < 0 && *coverRatio != -1 {
+		log.Fatal("❌ Invalid -cover-ratio: use a ratio of 0 or more, or -1 for none")
+	}
+	if upload.Cover, err = simulacra.NewCoverEngine(*coverSites, *coverMix, *coverTempo); err != nil {
+		log.Fatalf("❌ Invalid cover traffic: %v", err)
+	}
+	if *verify < -1 {
+		log.Fatal("❌ Invalid -verify: use a number of chunks, or -1 for all")
+	}
+	if *verifyResolver != "" && *verify == 0 {
+		log.Fatal("-verify-resolver needs -verify")
+	}
+	if *queryProfile == "" && *stealth {
+		*queryProfile = string(fingerprint.PROFILE_MIXED)
+	}
+	if upload.Queries, err = simulacra.NewQueries(*queryProfile); err != nil {
+		log.Fatal(err)
+	}
+	if *randomCase || *caseSignal != "" {
+		writer, err := chunker.NewCaseWriter([]byte(*caseKey), []byte(*caseSignal))
+		if err != nil {
+			log.Fatalf("Invalid -case-signal: %v", err)
+		}
+		upload.Queries.SetCase(writer)
+	}
+	if upload.Reporter, err = progress.New(*progressMode); err != nil {
+		log.Fatal(err)
+	}
+	if upload.Tags, err = dnsserver.ParseTags(*tags); err != nil {
+		log.Fatalf("Invalid tags: %v", err)
+	}
+	if upload.Recipient, err = dnsserver.NormalizeRecipient(*recipient); err != nil {
+		log.Fatalf("Invalid -to: %v", err)
+	} else if upload.Recipient != "" && upload.Method == simulacra.UPLOAD_UPDATE {
+		log.Fatal("-to needs the simulacra DNS server, not -upload update")
+	}
+	if level, err := dnsserver.ParsePriority(*priority); err != nil {
+		log.Fatalf("Invalid -priority: %v", err)
+	} else if level != dnsserver.PRIORITY_NORMAL {
+		if upload.Method == simulacra.UPLOAD_UPDATE {
+			log.Fatal("-priority needs the simulacra DNS server, not -upload update")
+		}
+		upload.Priority = dnsserver.PriorityName(level)
+	}
+	if timeout, err := dnsserver.ParseVisibility(*visibility); err != nil {
+		log.Fatalf("Invalid -visibility: %v", err)
+	} else if timeout > 0 {
+		if upload.Method == simulacra.UPLOAD_UPDATE {
+			log.Fatal("-visibility needs the simulacra DNS server, not -upload update")
+		}
+		upload.Visibility = timeout.String()
 	}
 
-	// Create upload client
-	client := NewUploadClient(*server, *domain)
-	client.stealthMode = *stealth
+	if *useCreds {
+		creds, err := credstore.Unlock(*credsFile)
+		if err != nil {
+			log.Fatalf("Credential store: %v", err)
+		}
+		fmt.Printf("🔐 Credential store unlocked (%d entries)\n", len(creds.Names()))
+
+		if stored, ok := creds.Get(credstore.CRED_CHUNK_AUTH_KEY); ok && *authKey == "" {
+			*authKey = stored
+		}
+		if stored, ok := creds.Get(credstore.CRED_SIGNING_KEY); ok && *signKey == "" {
+			*signKey = stored
+		}
+		if stored, ok := creds.Get(credstore.CRED_TSIG_KEY); ok && *tsigKey == "" {
+			*tsigKey = stored
+		}
+		if stored, ok := creds.Get(credstore.CRED_NAME_KEY); ok && *nameKey == "" {
+			*nameKey = stored
+		}
+		if stored, ok := creds.Get(credstore.CRED_API_KEY); ok && *apiKey == "" {
+			*apiKey = stored
+		}
+		if stored, ok := creds.Get(credstore.CRED_QNAME_KEY); ok && *qnameKey == "" && upload.Method == simulacra.UPLOAD_QNAME {
+			*qnameKey = stored
+		}
+	}
+	if *apiKey != "" {
+		id, secret, ok := strings.Cut(*apiKey, ":")
+		if !ok || id == "" || secret == "" {
+			log.Fatal("❌ Invalid -api-key: use id:secret")
+		}
+		if upload.Method != simulacra.UPLOAD_HTTP {
+			log.Fatal("-api-key needs -upload http")
+		}
+		upload.APIKeyID, upload.APISecret = id, secret
+	}
+	if *apiURL != "" || *apiCA != "" {
+		if upload.Method != simulacra.UPLOAD_HTTP {
+			log.Fatal("-api-url and -api-ca need -upload http")
+		}
+		if *apiURL != "" && !strings.HasPrefix(*apiURL, "http://") && !strings.HasPrefix(*apiURL, "https://") {
+			log.Fatal("❌ Invalid -api-url: start it with http:// or https://")
+		}
+		tlsConfig, err := transport.LoadTLSConfig(*apiCA)
+		if err != nil {
+			log.Fatalf("❌ Invalid -api-ca: %v", err)
+		}
+		upload.APIURL = *apiURL
+		upload.APIClient = &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}}
+	}
+	if *tsigKey != "" {
+		if _, err := publisher.ParseTSIGKey(*tsigKey); err != nil {
+			log.Fatalf("❌ Invalid -tsig: %v", err)
+		}
+		upload.TSIGKey = *tsigKey
+	}
+	if *qnameKey != "" {
+		if upload.Method != simulacra.UPLOAD_QNAME {
+			log.Fatal("-qname-key needs -upload qname")
+		}
+		upload.QNameKey = []byte(*qnameKey)
+	}
+	upload.NameKey = []byte(*nameKey)
+	if upload.Method == simulacra.UPLOAD_QNAME && *nameKey != "" && chunker.KeyedTemplate(*names) {
+		// The server names QNAME uploads from the manifest, without the secret
+		log.Fatal("-names-key can't be used with -upload qname")
+	}
+	var signer ed25519.PrivateKey
+	if *signKey != "" {
+		if signer, err = simulacra.LoadSigningKey(*signKey); err != nil {
+			log.Fatalf("❌ Invalid -sign-key: %v", err)
+		}
+	}
 
 	// Calculate rate limit delay
 	if *rateLimit > 0 {
-		client.rateLimit = time.Second / time.Duration(*rateLimit)
+		upload.RateLimit = time.Second / time.Duration(*rateLimit)
 	}
+	client, err := simulacra.NewUploadClient(upload)
+	if err != nil {
+		log.Fatalf("❌ %v", err)
+	}
+
+	// Ctrl+C abandons the query in flight and stops retries and receipts
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
 	fmt.Println("\n🚀 DNS COVERT CHANNEL UPLOADER")
 
-	var msgID string
-	var chunks []chunker.Chunk
-	var manifest string
-	var err error
+	// Plain authoritative servers have no canary to probe
+	if *probe && upload.Method != simulacra.UPLOAD_UPDATE {
+		if _, err := client.CheckServer(ctx); err != nil {
+			if ctx.Err() != nil {
+				log.Fatal("🛑 Interrupted")
+			}
+			log.Fatalf("❌ %v (-canary=false skips this check)", err)
+		}
+	}
+
+	// How every file is chunked
+	chunkOptions := simulacra.ChunkOptions{
+		Config: chunker.ChunkerConfig{
+			Encoding:             *encoding,
+			AddRedundancy:        *fec > 0,
+			Redundancy:           *fec,
+			FECScheme:            *fecScheme,
+			Integrity:            *integrity,
+			Compression:          *compress != "" && *compress != "none",
+			CompressionAlgorithm: *compress,
+			Profile:              *sizing,
+			StringsPerRecord:     *txtStrings,
+			AuthKey:              []byte(*authKey),
+			DeterministicID:      *deterministic,
+			Shuffle:              *shuffle,
+			Workers:              *workers,
+			WireVersion:          uint8(*wireVersion),
+			TLVs:                 tlvs,
+		},
+		NameTemplate:   *names,
+		Meta:           *meta,
+		Shard:          *shard,
+		ManifestTLVs:   manifestTLVs,
+		ManifestFormat: manifestFormat,
+		NameKey:        []byte(*nameKey),
+		Recipient:      upload.Recipient,
+	}
+	var hider *stegoHider
+	if *stego {
+		if hider, err = newStegoHider(*password, *stegoCover); err != nil {
+			log.Fatalf("❌ Invalid -stego: %v", err)
+		}
+	}
+
+	if *inputDir != "" {
+		path := *batchManifest
+		if path == "" {
+			path = filepath.Clean(*inputDir) + ".batch.json"
+		}
+		b := &batchSend{
+			client:          client,
+			options:         chunkOptions,
+			hider:           hider,
+			signer:          signer,
+			reporter:        upload.Reporter,
+			server:          *server,
+			domain:          *domain,
+			coverBackground: *coverBackground,
+		}
+		b.run(ctx, *inputDir, path)
+		return
+	}
+
+	var set *simulacra.ChunkSet
 
 	if *input != "" {
 		// Load and chunk image
 		fmt.Printf("📷 Loading image: %s\n", *input)
-		msgID, chunks, manifest, err = LoadAndChunkImage(*input)
+		data, err := os.ReadFile(*input)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if hider == nil {
+			checkCarrier(*input)
+		}
+		set, err = chunkInput(data, filepath.Base(*input), chunkOptions, upload.Reporter, hider)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		fmt.Printf("   Size: %d bytes\n", len(data))
+		if hider != nil {
+			fmt.Printf("   🖼️  Hidden in a %d-byte stego image\n", len(set.Message.Data))
+		}
+		fmt.Printf("   Chunks: %d\n", len(set.Message.Chunks))
+		fmt.Printf("   Message ID: %s\n", set.MessageID())
+
+		if *saveArchive != "" {
+			if err := set.Save(*saveArchive); err != nil {
+				log.Fatalf("Failed to save archive: %v", err)
+			}
+			fmt.Printf("   Archive: %s\n", *saveArchive)
+		}
+	} else if *archive != "" {
+		// Upload a message chunked earlier, exactly as it was archived
+		fmt.Printf("🗄️ Loading chunk set: %s\n", *archive)
+		set, err = chunker.LoadChunkSet(*archive, chunker.NewChunker(chunker.ChunkerConfig{AuthKey: []byte(*authKey)}))
 		if err != nil {
 			log.Fatal(err)
 		}
+		if set.Manifest == "" {
+			log.Fatal("Archive has no manifest to publish")
+		}
 
-		fileInfo, _ := os.Stat(*input)
-		fmt.Printf("   Size: %d bytes\n", fileInfo.Size())
-		fmt.Printf("   Chunks: %d\n", len(chunks))
-		fmt.Printf("   Message ID: %s\n", msgID)
+		fmt.Printf("   Chunks: %d\n", len(set.Message.Chunks))
+		fmt.Printf("   Message ID: %s\n", set.MessageID())
 	} else {
-		// Load from zone file (TODO: implement zone file parser)
-		log.Fatal("Zone file loading not yet implemented")
+		// Upload a zone generated earlier (e.g. by the DNS encoder), after
+		// checking every chunk the manifest lists is there and intact
+		fmt.Printf("🗺️ Loading zone file: %s\n", *zoneFile)
+		set, err = chunker.LoadZoneChunkSet(*zoneFile, chunker.NewChunker(chunker.ChunkerConfig{AuthKey: []byte(*authKey)}))
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		fmt.Printf("   Chunks: %d (validated)\n", len(set.Message.Chunks))
+		fmt.Printf("   Message ID: %s\n", set.MessageID())
+	}
+	msgID, chunks := set.MessageID(), set.Message.Chunks
+
+	if signer != nil {
+		if err := simulacra.SignChunkSet(set, signer); err != nil {
+			log.Fatalf("❌ Signing manifest: %v", err)
+		}
+		fmt.Printf("   🔏 Manifest signed (key %s...)\n", chunker.EncodeVerifyKey(signer.Public().(ed25519.PublicKey))[:16])
 	}
 
 	// Display configuration
 	fmt.Printf("\n⚙️ Configuration:\n")
 	fmt.Printf("   Server: %s\n", *server)
 	fmt.Printf("   Domain: %s\n", *domain)
+	fmt.Printf("   Upload method: %s\n", client.Method())
 	fmt.Printf("   Rate limit: %d queries/sec\n", *rateLimit)
 	fmt.Printf("   Stealth mode: %v\n", *stealth)
+	traffic := client.Traffic()
+	if upload.Method == simulacra.UPLOAD_QNAME && traffic.Name != simulacra.TRAFFIC_NONE {
+		fmt.Printf("   Traffic profile: %s (%s)\n", traffic.Name, traffic.Description)
+	}
+	if len(upload.Tags) > 0 {
+		fmt.Printf("   Tags: %s\n", strings.Join(upload.Tags, ", "))
+	}
+	if upload.Recipient != "" {
+		fmt.Printf("   Recipient: %s (only that client ID discovers it)\n", upload.Recipient)
+	}
+	if upload.Burn {
+		fmt.Println("   🔥 Burn after reading: deleted once fetched")
+	}
+	if upload.Priority != "" {
+		fmt.Printf("   Priority: %s\n", upload.Priority)
+	}
+	if upload.Visibility != "" {
+		fmt.Printf("   Visibility timeout: %s (redelivered unless acknowledged)\n", upload.Visibility)
+	}
 
 	if *stealth {
 		fmt.Println("\n🥷 Stealth mode enabled:")
-		fmt.Println("   - Random chunk order")
-		fmt.Println("   - Timing jitter")
-		fmt.Println("   - Cover traffic")
+		if traffic.Shuffle {
+			fmt.Println("   - Random chunk order")
+		}
+		if traffic.Name != simulacra.TRAFFIC_NONE {
+			fmt.Printf("   - Timing: %s\n", traffic.Name)
+		}
+		if *coverRatio > 0 || (*coverRatio == 0 && traffic.CoverRatio > 0) {
+			fmt.Printf("   - Cover traffic: %s\n", client.Cover().Describe())
+		}
+		fmt.Printf("   - Query profile: %s\n", upload.Queries.Describe())
 	}
 
 	// Estimate upload time
-	estimatedTime := time.Duration(len(chunks)+1) * client.rateLimit
-	fmt.Printf("\n⏱️ Estimated upload time: %v\n", estimatedTime)
+	if upload.Method == simulacra.UPLOAD_QNAME {
+		fmt.Printf("\n⏱️ Estimated upload time: %v\n", client.EstimateUpload(len(chunks)).Round(time.Second/10))
+		if traffic.Hours != [2]int{} {
+			fmt.Printf("   (plus any wait for %02d:00-%02d:00 on a weekday)\n", traffic.Hours[0], traffic.Hours[1])
+		}
+	} else {
+		estimatedTime := time.Duration(len(chunks)+1) * client.RateLimit()
+		fmt.Printf("\n⏱️ Estimated upload time: %v\n", estimatedTime)
+	}
 
 	// Start upload
 	fmt.Printf("\nPress Enter to start upload...")
-	fmt.Scanln()
+	waitForEnter(ctx)
+
+	if *coverBackground {
+		fmt.Printf("\n🎭 Background cover traffic: %s\n", client.Cover().Describe())
+		coverCtx, stopCover := context.WithCancel(ctx)
+		defer stopCover()
+		go client.RunCover(coverCtx)
+	}
 
 	// Upload the message
-	err = client.UploadMessage(msgID, chunks, manifest)
+	startTime := time.Now()
+	result, err := client.UploadChunkSet(ctx, set)
+	recordUpload(msgID, *server, chunks, startTime, err)
 	if err != nil {
+		if errors.Is(err, context.Canceled) {
+			log.Fatal("🛑 Upload interrupted")
+		}
+		if result != nil {
+			// Published, but receivers may not be able to fetch it
+			log.Fatalf("❌ Message %s uploaded, but %v", msgID, err)
+		}
 		log.Fatalf("Upload failed: %v", err)
 	}
+	if v := result.Verification; v != nil {
+		fmt.Printf("\n🔎 Verified through %s: manifest and %d sampled chunks servable (%v)\n", v.Resolver, len(v.Servable), v.Duration.Round(time.Millisecond))
+	}
 
 	fmt.Println("\n🎉 Upload complete!")
+	if n := client.Cover().Generated(); n > 0 {
+		fmt.Printf("🎭 Cover queries: %d\n", n)
+	}
 	fmt.Printf("Receiver should query for message: %s\n", msgID)
 	fmt.Printf("\nExample receiver command:\n")
 	fmt.Printf("  go run cmd/stego-receive/main.go -server %s -msg %s\n", *server, msgID)
+
+	if *awaitReceipt > 0 {
+		key := []byte(*receiptKey)
+		if len(key) == 0 {
+			key = []byte(*authKey)
+		}
+		if len(key) == 0 {
+			log.Fatal("❌ -await-receipt needs -receipt-key or -auth-key to verify with")
+		}
+
+		fmt.Printf("\n🧾 Waiting up to %v for a receipt...\n", *awaitReceipt)
+		waitCtx, cancel := context.WithTimeout(ctx, *awaitReceipt)
+		receipt, err := client.AwaitReceipt(waitCtx, msgID, key)
+		cancel()
+		if err != nil {
+			if ctx.Err() != nil {
+				log.Fatal("🛑 Stopped waiting for a receipt")
+			}
+			log.Fatalf("❌ No valid receipt within %v", *awaitReceipt)
+		}
+
+		fmt.Printf("   ✅ Receipt: %s at %s\n", receipt.Status, receipt.Time.Format(time.RFC3339))
+		if data, err := os.ReadFile(*input); err == nil {
+			if receipt.Matches(data) {
+				fmt.Println("   ✅ Receiver's SHA-256 matches the uploaded file")
+			} else {
+				fmt.Println("   ⚠️  Receiver's SHA-256 differs from the uploaded file")
+			}
+		}
+		if receipt.Status == chunker.RECEIPT_FAILED {
+			log.Fatal("❌ Receiver could not decode the message")
+		}
+	}
+}
+
+// waitForEnter blocks until Enter is pressed; Ctrl+C meanwhile cancels the
+// upload before it starts
+func waitForEnter(ctx context.Context) {
+	pressed := make(chan struct{})
+	go func() {
+		fmt.Scanln()
+		close(pressed)
+	}()
+
+	select {
+	case <-pressed:
+	case <-ctx.Done():
+		fmt.Println()
+		log.Fatal("🛑 Upload cancelled")
+	}
 }
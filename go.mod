@@ -3,12 +3,19 @@ module github.com/faanross/simulacra_txt
 go 1.23.3
 
 require (
-	github.com/miekg/dns v1.1.68 // indirect
-	golang.org/x/crypto v0.41.0 // indirect
-	golang.org/x/mod v0.24.0 // indirect
+	github.com/andybalholm/brotli v1.2.0
+	github.com/klauspost/compress v1.18.0
+	github.com/miekg/dns v1.1.68
+	go.etcd.io/bbolt v1.3.11
+	golang.org/x/crypto v0.41.0
+	golang.org/x/term v0.34.0
+)
+
+require (
+	golang.org/x/mod v0.26.0 // indirect
 	golang.org/x/net v0.42.0 // indirect
-	golang.org/x/sync v0.14.0 // indirect
+	golang.org/x/sync v0.16.0 // indirect
 	golang.org/x/sys v0.35.0 // indirect
-	golang.org/x/term v0.34.0 // indirect
-	golang.org/x/tools v0.33.0 // indirect
+	golang.org/x/text v0.28.0 // indirect
+	golang.org/x/tools v0.35.0 // indirect
 )
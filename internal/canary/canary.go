@@ -0,0 +1,124 @@
+package canary
+
+import (
+	"context"
+	"fmt"
+	"github.com/faanross/simulacra_txt/internal/chunker"
+	"github.com/faanross/simulacra_txt/internal/fingerprint"
+	"github.com/miekg/dns"
+	"strings"
+	"time"
+)
+
+// ================================================================================
+// THEORY LESSON: Failing Fast
+// ================================================================================
+//
+// The canary record (see chunker.Canary) is only useful if its absence is
+// explained. Probe sends one canary query and turns whatever comes back - or
+// doesn't - into a Failure naming the most likely cause, so a client can
+// stop before its first chunk query with a message a human can act on:
+//
+//   ❌ server down: no response from 10.0.0.5:53 (i/o timeout)
+//   ❌ wrong domain: server answered NXDOMAIN for covert.example.com
+//   ❌ resolver interference: answer echoed nonce 1a2b..., expected 9f8e...
+//
+// A busy server is not a failure: the canary is returned and the client
+// decides whether to warn or wait.
+// ================================================================================
+
+// DEFAULT_TIMEOUT bounds a canary query
+const DEFAULT_TIMEOUT = 5 * time.Second
+
+// Cause classifies why a canary probe failed
+type Cause string
+
+const (
+	CAUSE_UNREACHABLE  Cause = "server down"           // No response at all
+	CAUSE_WRONG_DOMAIN Cause = "wrong domain"          // Server doesn't serve the domain
+	CAUSE_INTERFERENCE Cause = "resolver interference" // Answer not from our server
+	CAUSE_INCOMPATIBLE Cause = "incompatible server"   // Protocol versions don't overlap
+)
+
+// Failure is a diagnosed canary failure
+type Failure struct {
+	Cause  Cause
+	Detail string
+}
+
+// Error implements error
+func (f *Failure) Error() string {
+	return fmt.Sprintf("%s: %s", f.Cause, f.Detail)
+}
+
+// failure builds a Failure with a formatted detail
+func failure(cause Cause, format string, args ...interface{}) *Failure {
+	return &Failure{Cause: cause, Detail: fmt.Sprintf(format, args...)}
+}
+
+// Probe queries the canary for domain through server, returning the
+// server's self-report or a *Failure diagnosing why there is none. A
+// cancelled ctx is returned as is: nothing is wrong with the server.
+func Probe(ctx context.Context, queries *fingerprint.Randomizer, server, domain string, timeout time.Duration) (*chunker.Canary, error) {
+	if timeout <= 0 {
+		timeout = DEFAULT_TIMEOUT
+	}
+
+	nonce := chunker.NewCanaryNonce()
+	name := dns.Fqdn(chunker.CanaryName(nonce, domain))
+
+	m := new(dns.Msg)
+	m.SetQuestion(name, dns.TypeTXT)
+
+	resp, err := queries.Exchange(ctx, m, server, 0, timeout)
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return nil, ctxErr
+	}
+	if err != nil {
+		return nil, failure(CAUSE_UNREACHABLE, "no response from %s (%v)", server, err)
+	}
+
+	switch resp.Rcode {
+	case dns.RcodeSuccess:
+	case dns.RcodeNameError, dns.RcodeRefused, dns.RcodeNotAuth:
+		return nil, failure(CAUSE_WRONG_DOMAIN, "server answered %s for %s",
+			dns.RcodeToString[resp.Rcode], domain)
+	default:
+		return nil, failure(CAUSE_UNREACHABLE, "server answered %s (overloaded or failing)",
+			dns.RcodeToString[resp.Rcode])
+	}
+
+	var value string
+	for _, rr := range resp.Answer {
+		if txt, ok := rr.(*dns.TXT); ok && strings.EqualFold(txt.Hdr.Name, name) {
+			value = strings.Join(txt.Txt, "")
+			break
+		}
+	}
+	if value == "" {
+		if len(resp.Answer) > 0 {
+			return nil, failure(CAUSE_INTERFERENCE, "canary answered with %d unexpected records",
+				len(resp.Answer))
+		}
+		return nil, failure(CAUSE_WRONG_DOMAIN, "no canary record for %s (or the server predates canaries)", domain)
+	}
+
+	canary, err := chunker.ParseCanary(value)
+	if err != nil {
+		return nil, failure(CAUSE_INTERFERENCE, "unreadable canary %q (%v)", value, err)
+	}
+	if canary.Nonce != nonce {
+		return nil, failure(CAUSE_INTERFERENCE, "answer echoed nonce %s, expected %s", canary.Nonce, nonce)
+	}
+	if err := canary.Check(); err != nil {
+		return nil, failure(CAUSE_INCOMPATIBLE, "%v", err)
+	}
+
+	return &canary, nil
+}
+
+// Describe summarizes a canary for display
+func Describe(c *chunker.Canary) string {
+	return fmt.Sprintf("%s, protocol v%d, up %v, load %d%%, %d messages",
+		c.Status, c.Version, c.Uptime, c.Load, c.Messages)
+}
@@ -0,0 +1,78 @@
+package chunker
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+)
+
+// ================================================================================
+// THEORY LESSON: Checksums vs. Authentication
+// ================================================================================
+//
+// A CRC (or a truncated hash) catches accidental corruption, but anyone who
+// can rewrite a chunk - an on-path resolver, a poisoned cache - can simply
+// recompute it. A MAC can't be recomputed without the key:
+//
+//   tag = HMAC-SHA256(key, header || payload)[:16]
+//
+// The header is covered too, so a valid chunk can't be replayed under a
+// different sequence number or message ID. The tag travels as a trailer
+// after the payload:
+//
+//   [HEADER(29)][PAYLOAD(variable)][TAG(16)]
+//
+// and the integrity nibble is set to INTEGRITY_HMAC_SHA256. The checksum
+// field still carries a CRC32C, so hops without the key (the DNS server,
+// a relay) can keep dropping corrupted chunks.
+//
+// The envelope (envelope.go) authenticates the message as a whole, but only
+// after every chunk arrived; chunk tags reject forgeries one by one.
+// ================================================================================
+
+const (
+	// AUTH_TAG_SIZE is the truncated HMAC-SHA256 tag appended to authenticated chunks
+	AUTH_TAG_SIZE = 16
+)
+
+// ErrChunkAuth means a chunk's tag did not verify (tampered or wrong key)
+var ErrChunkAuth = errors.New("chunk authentication failed")
+
+// authenticating reports whether new chunks carry tags
+func (c *Chunker) authenticating() bool {
+	return c.integrity == INTEGRITY_HMAC_SHA256
+}
+
+// chunkTag computes the authentication tag over a serialized header and payload
+func (c *Chunker) chunkTag(header, payload []byte) []byte {
+	mac := hmac.New(sha256.New, c.config.AuthKey)
+	mac.Write(header)
+	mac.Write(payload)
+	return mac.Sum(nil)[:AUTH_TAG_SIZE]
+}
+
+// verifyTag checks an authenticated chunk against the configured key.
+// Unauthenticated chunks pass unless RequireAuth is set.
+func (c *Chunker) verifyTag(chunk *Chunk) error {
+	if chunk.Metadata.Integrity != INTEGRITY_HMAC_SHA256 {
+		if c.config.RequireAuth {
+			return fmt.Errorf("chunk %d is not authenticated (authentication required)", chunk.Metadata.Sequence)
+		}
+		return nil
+	}
+
+	if len(c.config.AuthKey) == 0 {
+		if c.config.RequireAuth {
+			return errors.New("authentication required but no key configured")
+		}
+		return nil // Can't verify; the checksum still guards against corruption
+	}
+
+	expected := c.chunkTag(marshalHeader(chunk.Metadata), chunk.Payload)
+	if !hmac.Equal(expected, chunk.Tag) {
+		return fmt.Errorf("%w: chunk %d", ErrChunkAuth, chunk.Metadata.Sequence)
+	}
+
+	return nil
+}
@@ -0,0 +1,88 @@
+package chunker
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ================================================================================
+// THEORY LESSON: Range Queries
+// ================================================================================
+//
+// One chunk per query means one round trip per ~120 bytes. A range query asks
+// for several chunks at once by naming the first and last sequence:
+//
+//   c-3-<msgid>     -> chunk 3
+//   c-0-9-<msgid>   -> chunks 0 through 9, one TXT record each
+//
+// A resolver may return the records of an RRset in any order, so every value
+// in a range answer is prefixed with its chunk index: "3:<encoded chunk>".
+// Ten chunks don't fit in a classic 512-byte UDP answer, so receivers must
+// advertise a larger EDNS0 buffer (or use TCP). Servers drop whole records
+// that don't fit and set the TC bit; receivers fetch the rest individually.
+// ================================================================================
+
+const (
+	// DEFAULT_BATCH_SIZE is the number of chunks requested per range query
+	DEFAULT_BATCH_SIZE = 10
+
+	// MAX_BATCH_SIZE caps how many chunks a server returns for one range
+	MAX_BATCH_SIZE = 16
+
+	// EDNS0_BUFFER_SIZE is the UDP payload size receivers advertise
+	EDNS0_BUFFER_SIZE = 4096
+)
+
+// ChunkLabel identifies the chunks requested by a query label
+type ChunkLabel struct {
+	MessageID string
+	First     int
+	Last      int // Equal to First for single-chunk queries
+}
+
+// IsRange reports whether the label requests more than one chunk
+func (cl ChunkLabel) IsRange() bool {
+	return cl.Last > cl.First
+}
+
+// RangeLabel builds the query label for chunks first..last (inclusive)
+func RangeLabel(first, last int, msgID string) string {
+	if first == last {
+		return fmt.Sprintf("c-%d-%s", first, msgID)
+	}
+	return fmt.Sprintf("c-%d-%d-%s", first, last, msgID)
+}
+
+// ParseChunkLabel decodes c-<seq>-<msgid> and c-<first>-<last>-<msgid>
+// (see ParseDataLabel)
+func ParseChunkLabel(label string) (ChunkLabel, error) {
+	dl, err := ParseDataLabel(label)
+	if err == nil && dl.Manifest {
+		err = ErrNotDataLabel
+	}
+	if err != nil {
+		return ChunkLabel{}, fmt.Errorf("not a chunk label: %w", err)
+	}
+	return dl.ChunkLabel, nil
+}
+
+// FormatRangeValue prefixes a chunk with its index for a range answer
+func FormatRangeValue(index int, value string) string {
+	return fmt.Sprintf("%d:%s", index, value)
+}
+
+// ParseRangeValue splits a range answer into chunk index and value
+func ParseRangeValue(value string) (int, string, error) {
+	idx := strings.IndexByte(value, ':')
+	if idx <= 0 {
+		return 0, "", fmt.Errorf("range answer missing index")
+	}
+
+	index, err := strconv.Atoi(value[:idx])
+	if err != nil {
+		return 0, "", fmt.Errorf("invalid range index: %w", err)
+	}
+
+	return index, value[idx+1:], nil
+}
@@ -0,0 +1,161 @@
+package chunker
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ================================================================================
+// THEORY LESSON: Canaries
+// ================================================================================
+//
+// A transfer against a dead server, a mistyped domain or a resolver that
+// rewrites answers used to fail the slow way: every chunk query timing out,
+// one after another, with nothing to say why. Before a transfer the client
+// now asks one question whose answer it can check:
+//
+//   _canary.<nonce>.covert.example.com TXT?
+//   "v=3; min=1; status=ok; nonce=<nonce>; up=3600; load=4; msgs=12"
+//
+// The nonce is fresh per probe, so no resolver can answer from its cache,
+// and it must come back unchanged: a resolver that invents answers (captive
+// portals, filtering resolvers, NXDOMAIN rewriting) can't know it.
+//
+//   OUTCOME                        DIAGNOSIS
+//   No response                    Server down or unreachable
+//   NXDOMAIN / REFUSED / empty     Wrong domain (or server predates canaries)
+//   Wrong nonce / unparsable       Resolver interference
+//   status=busy                    Server overloaded, expect retries
+//   Version outside our range      Incompatible server
+// ================================================================================
+
+const (
+	// CANARY_LABEL is the first label of canary queries
+	CANARY_LABEL = "_canary"
+
+	// CANARY_NONCE_SIZE is the canary nonce length in bytes
+	CANARY_NONCE_SIZE = 8
+
+	// Canary status values
+	CANARY_OK   = "ok"   // Serving normally
+	CANARY_BUSY = "busy" // Shedding or close to shedding queries
+)
+
+// Canary is the server's self-report in a canary answer
+type Canary struct {
+	Version    uint8         // Highest protocol version served
+	MinVersion uint8         // Oldest protocol version still accepted
+	Status     string        // CANARY_OK or CANARY_BUSY
+	Nonce      string        // Echo of the query's nonce
+	Uptime     time.Duration // Time since the server started
+	Load       int           // Percentage of query capacity in use
+	Messages   int           // Messages currently stored
+}
+
+// NewCanaryNonce returns a fresh random nonce for a canary query
+func NewCanaryNonce() string {
+	nonce := make([]byte, CANARY_NONCE_SIZE)
+	rand.Read(nonce)
+	return hex.EncodeToString(nonce)
+}
+
+// CanaryName returns the canary query name for a nonce under domain
+func CanaryName(nonce, domain string) string {
+	return fmt.Sprintf("%s.%s.%s", CANARY_LABEL, nonce, strings.TrimSuffix(domain, "."))
+}
+
+// ParseCanaryName extracts the nonce from a canary query name under
+// domain, reporting whether the name is a canary query
+func ParseCanaryName(qname, domain string) (string, bool) {
+	qname = strings.ToLower(strings.TrimSuffix(qname, "."))
+	rest, ok := strings.CutSuffix(qname, "."+strings.ToLower(strings.TrimSuffix(domain, ".")))
+	if !ok {
+		return "", false
+	}
+
+	nonce, ok := strings.CutPrefix(rest, CANARY_LABEL+".")
+	if !ok || nonce == "" || strings.Contains(nonce, ".") {
+		return "", false
+	}
+	return nonce, true
+}
+
+// String encodes the canary as a TXT record value
+func (c Canary) String() string {
+	return strings.Join([]string{
+		fmt.Sprintf("v=%d", c.Version),
+		fmt.Sprintf("min=%d", c.MinVersion),
+		fmt.Sprintf("status=%s", c.Status),
+		fmt.Sprintf("nonce=%s", c.Nonce),
+		fmt.Sprintf("up=%d", int64(c.Uptime.Seconds())),
+		fmt.Sprintf("load=%d", c.Load),
+		fmt.Sprintf("msgs=%d", c.Messages),
+	}, "; ")
+}
+
+// ParseCanary decodes a canary TXT record value
+func ParseCanary(value string) (Canary, error) {
+	canary := Canary{}
+
+	for _, field := range strings.Split(value, ";") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+
+		key, val, ok := strings.Cut(field, "=")
+		if !ok {
+			return Canary{}, fmt.Errorf("malformed canary field: %q", field)
+		}
+
+		switch strings.ToLower(key) {
+		case "v", "min":
+			n, err := strconv.ParseUint(val, 10, 8)
+			if err != nil {
+				return Canary{}, fmt.Errorf("invalid %s: %w", key, err)
+			}
+			if key == "v" {
+				canary.Version = uint8(n)
+			} else {
+				canary.MinVersion = uint8(n)
+			}
+		case "status":
+			canary.Status = strings.ToLower(val)
+		case "nonce":
+			canary.Nonce = strings.ToLower(val)
+		case "up", "load", "msgs":
+			n, err := strconv.Atoi(val)
+			if err != nil {
+				return Canary{}, fmt.Errorf("invalid %s: %w", key, err)
+			}
+			switch key {
+			case "up":
+				canary.Uptime = time.Duration(n) * time.Second
+			case "load":
+				canary.Load = n
+			default:
+				canary.Messages = n
+			}
+		default:
+			// Unknown keys belong to newer servers
+		}
+	}
+
+	if canary.Version == 0 || canary.Status == "" || canary.Nonce == "" {
+		return Canary{}, fmt.Errorf("canary missing version, status or nonce")
+	}
+	if canary.MinVersion == 0 {
+		canary.MinVersion = canary.Version
+	}
+
+	return canary, nil
+}
+
+// Check reports whether this build speaks a protocol version the server accepts
+func (c Canary) Check() error {
+	return Capabilities{Version: c.Version, MinVersion: c.MinVersion, Encoding: ENCODE_BASE32}.Check()
+}
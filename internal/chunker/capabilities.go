@@ -0,0 +1,226 @@
+package chunker
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ================================================================================
+// THEORY LESSON: Self-Describing Zones
+// ================================================================================
+//
+// Sender and receiver used to agree on encoding and chunk sizing by being the
+// same build with the same flags. Instead, the zone now publishes a single
+// capabilities record next to the data:
+//
+//   _simulacra.covert.example.com TXT "v=2; min=1; enc=base32; ..."
+//
+// The format borrows from SPF/DKIM: semicolon separated key=value pairs.
+// Unknown keys are ignored, so newer publishers can add fields without
+// breaking older receivers.
+// ================================================================================
+
+const (
+	// CAPABILITIES_LABEL is the record name prefix under the served domain
+	CAPABILITIES_LABEL = "_simulacra"
+
+	// MIN_PROTOCOL_VERSION is the oldest wire format this build still decodes
+	MIN_PROTOCOL_VERSION = 1
+)
+
+// Capabilities describes what a publisher speaks
+type Capabilities struct {
+	Version    uint8    // Highest protocol version in use
+	MinVersion uint8    // Oldest protocol version still accepted
+	Encoding   string   // Encoding of published chunks
+	Encodings  []string // Encodings the publisher understands
+	Integrity  string   // Integrity algorithm of new chunks
+	Algorithms []string // Integrity algorithms the publisher can verify
+	ChunkSize  int      // Maximum encoded chunk length (above SAFE_CHUNK_SIZE: multi-string)
+	Profile    string   // Sizing profile of published chunks
+	FEC        bool     // Parity chunks may be published
+	Batch      int      // Maximum chunks per range query (0 = no range support)
+	Compress   []string // Compression algorithms the publisher can decode
+}
+
+// DefaultCapabilities describes this build with default settings
+func DefaultCapabilities() Capabilities {
+	return Capabilities{
+		Version:    PROTOCOL_VERSION,
+		MinVersion: MIN_PROTOCOL_VERSION,
+		Encoding:   ENCODE_BASE32,
+		Encodings:  EncodingNames(),
+		Integrity:  DEFAULT_INTEGRITY,
+		Algorithms: IntegrityNames(),
+		ChunkSize:  SAFE_CHUNK_SIZE,
+		Profile:    DEFAULT_PROFILE,
+		FEC:        true,
+		Batch:      MAX_BATCH_SIZE,
+		Compress:   CompressionNames(),
+	}
+}
+
+// Capabilities describes the chunks this chunker produces
+func (c *Chunker) Capabilities() Capabilities {
+	caps := DefaultCapabilities()
+	caps.Version = c.maxWireVersion()
+	caps.Encoding = c.config.Encoding
+	caps.Integrity = c.integrity.String()
+	caps.FEC = c.config.AddRedundancy
+	caps.ChunkSize = c.config.MaxChunkSize
+	caps.Profile = c.profile.Name
+	return caps
+}
+
+// CapabilitiesName returns the capabilities record name for a domain
+func CapabilitiesName(domain string) string {
+	return fmt.Sprintf("%s.%s", CAPABILITIES_LABEL, strings.TrimSuffix(domain, "."))
+}
+
+// String encodes the capabilities as a TXT record value
+func (caps Capabilities) String() string {
+	fec := "0"
+	if caps.FEC {
+		fec = "1"
+	}
+
+	fields := []string{
+		fmt.Sprintf("v=%d", caps.Version),
+		fmt.Sprintf("min=%d", caps.MinVersion),
+		fmt.Sprintf("enc=%s", caps.Encoding),
+		fmt.Sprintf("encs=%s", strings.Join(caps.Encodings, ",")),
+		fmt.Sprintf("int=%s", caps.Integrity),
+		fmt.Sprintf("ints=%s", strings.Join(caps.Algorithms, ",")),
+		fmt.Sprintf("size=%d", caps.ChunkSize),
+		fmt.Sprintf("fec=%s", fec),
+		fmt.Sprintf("batch=%d", caps.Batch),
+		fmt.Sprintf("comp=%s", strings.Join(caps.Compress, ",")),
+	}
+	if caps.Profile != "" {
+		fields = append(fields, fmt.Sprintf("profile=%s", caps.Profile))
+	}
+
+	return strings.Join(fields, "; ")
+}
+
+// ParseCapabilities decodes a capabilities TXT record value
+func ParseCapabilities(value string) (Capabilities, error) {
+	caps := Capabilities{}
+
+	for _, field := range strings.Split(value, ";") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+
+		parts := strings.SplitN(field, "=", 2)
+		if len(parts) != 2 {
+			return Capabilities{}, fmt.Errorf("malformed capability field: %q", field)
+		}
+		key, val := strings.ToLower(parts[0]), parts[1]
+
+		switch key {
+		case "v", "min":
+			n, err := strconv.ParseUint(val, 10, 8)
+			if err != nil {
+				return Capabilities{}, fmt.Errorf("invalid %s: %w", key, err)
+			}
+			if key == "v" {
+				caps.Version = uint8(n)
+			} else {
+				caps.MinVersion = uint8(n)
+			}
+		case "enc":
+			caps.Encoding = strings.ToLower(val)
+		case "encs":
+			caps.Encodings = splitList(val)
+		case "int":
+			caps.Integrity = strings.ToLower(val)
+		case "ints":
+			caps.Algorithms = splitList(val)
+		case "size":
+			n, err := strconv.Atoi(val)
+			if err != nil {
+				return Capabilities{}, fmt.Errorf("invalid size: %w", err)
+			}
+			caps.ChunkSize = n
+		case "fec":
+			caps.FEC = val == "1"
+		case "batch":
+			n, err := strconv.Atoi(val)
+			if err != nil {
+				return Capabilities{}, fmt.Errorf("invalid batch: %w", err)
+			}
+			caps.Batch = n
+		case "comp":
+			caps.Compress = splitList(val)
+		case "profile":
+			caps.Profile = strings.ToLower(val)
+		default:
+			// Unknown keys belong to newer publishers
+		}
+	}
+
+	if caps.Version == 0 {
+		return Capabilities{}, fmt.Errorf("capabilities record missing version")
+	}
+	if caps.MinVersion == 0 {
+		caps.MinVersion = caps.Version
+	}
+	if caps.Encoding == "" {
+		caps.Encoding = ENCODE_BASE32
+	}
+
+	return caps, nil
+}
+
+// Check reports whether this build can read what the publisher produces
+func (caps Capabilities) Check() error {
+	if caps.MinVersion > PROTOCOL_VERSION {
+		return fmt.Errorf("publisher requires protocol v%d, this build speaks up to v%d",
+			caps.MinVersion, PROTOCOL_VERSION)
+	}
+	if caps.Version < MIN_PROTOCOL_VERSION {
+		return fmt.Errorf("publisher speaks protocol v%d, this build requires v%d or newer",
+			caps.Version, MIN_PROTOCOL_VERSION)
+	}
+	if !IsEncodingSupported(caps.Encoding) {
+		return fmt.Errorf("unsupported chunk encoding: %s", caps.Encoding)
+	}
+	if caps.Integrity != "" {
+		if _, err := ParseIntegrity(caps.Integrity); err != nil {
+			return err
+		}
+	}
+	if profile, err := LookupProfile(caps.Profile); err == nil && !profile.AllowsEncoding(caps.Encoding) {
+		return fmt.Errorf("encoding %s is not valid for sizing profile %s", caps.Encoding, profile.Name)
+	}
+	return nil
+}
+
+// ChunkerConfig returns a receiver configuration matching the publisher
+// Profiles this build doesn't know are sized from ChunkSize alone.
+func (caps Capabilities) ChunkerConfig() ChunkerConfig {
+	config := ChunkerConfig{
+		Encoding:     caps.Encoding,
+		MaxChunkSize: caps.ChunkSize,
+		Integrity:    caps.Integrity,
+	}
+	if _, err := LookupProfile(caps.Profile); err == nil {
+		config.Profile = caps.Profile
+	}
+	return config
+}
+
+// splitList parses a comma separated capability list
+func splitList(value string) []string {
+	items := make([]string, 0)
+	for _, item := range strings.Split(value, ",") {
+		item = strings.ToLower(strings.TrimSpace(item))
+		if item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,264 @@
+package chunker
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ================================================================================
+// THEORY LESSON: Record Carriers
+// ================================================================================
+//
+// TXT is the obvious home for text, and for that reason the first record
+// type anyone inspects. A chunk is just bytes, so other types can carry it:
+//
+//   TYPE    RDATA                                  CAPACITY
+//   TXT     "<encoded chunk>"                      255 chars per string
+//   NULL    \# <len> <hex of the raw chunk>        65535 bytes, binary
+//   CNAME   <encoded chunk in labels>.<domain>.    253 chars per name
+//   AAAA    [PREFIX(2)][INDEX(1)][DATA(13)] ...    13 bytes per address
+//
+// NULL carries the raw wire bytes with no encoding overhead at all. A CNAME
+// target is a name, so the chunk is cut into 63-character labels and must
+// use hex or base32 (names are case-insensitive; the case is restored as
+// for QNAME uploads) and a sizing profile that fits (qname-63 or
+// qname-multi). AAAA uses the shard address layout: a 2-byte length goes
+// first, every address carries its index since resolvers reorder RRsets,
+// and the last one is zero padded.
+//
+// The manifest always stays TXT and names the carrier ("; rr=aaaa"), so a
+// receiver knows which type to ask for. The dns-server serves uploaded
+// chunks as TXT; zones built with other carriers are meant for a regular
+// authoritative server.
+// ================================================================================
+
+// Record types a chunk can travel in
+const (
+	RECORD_TXT   = "TXT"
+	RECORD_NULL  = "NULL"
+	RECORD_CNAME = "CNAME"
+	RECORD_AAAA  = "AAAA"
+)
+
+// MAX_NULL_RDATA is the largest NULL record payload
+const MAX_NULL_RDATA = 65535
+
+// recordTypes lists the carriers in order of preference for display
+var recordTypes = []string{RECORD_TXT, RECORD_NULL, RECORD_CNAME, RECORD_AAAA}
+
+// RecordTypeNames lists the record types chunks can be carried in
+func RecordTypeNames() []string {
+	names := make([]string, len(recordTypes))
+	for i, name := range recordTypes {
+		names[i] = strings.ToLower(name)
+	}
+	return names
+}
+
+// ParseRecordType resolves a carrier name (any case; "" means TXT)
+func ParseRecordType(name string) (string, error) {
+	if name == "" {
+		return RECORD_TXT, nil
+	}
+	for _, recordType := range recordTypes {
+		if strings.EqualFold(name, recordType) {
+			return recordType, nil
+		}
+	}
+	return "", fmt.Errorf("unknown record type %q (use %s)", name, strings.Join(RecordTypeNames(), ", "))
+}
+
+// chunkRecords builds the records carrying one encoded chunk at name
+func (de *DNSEncoder) chunkRecords(chunk Chunk, name string) ([]DNSRecord, error) {
+	ttl := de.ttl.ChunkTTL() // One TTL for the whole RRset
+	record := DNSRecord{Name: name, Type: de.recordType, TTL: ttl}
+
+	switch de.recordType {
+	case RECORD_NULL:
+		raw, _, err := detectEncoding(chunk.Encoded)
+		if err != nil {
+			return nil, err
+		}
+		if len(raw) > MAX_NULL_RDATA {
+			return nil, fmt.Errorf("chunk is %d bytes, NULL records hold %d", len(raw), MAX_NULL_RDATA)
+		}
+		record.Value = fmt.Sprintf(`\# %d %s`, len(raw), hex.EncodeToString(raw))
+		return []DNSRecord{record}, nil
+
+	case RECORD_CNAME:
+		target, err := de.cnameTarget(chunk.Encoded)
+		if err != nil {
+			return nil, err
+		}
+		record.Value = target
+		return []DNSRecord{record}, nil
+
+	case RECORD_AAAA:
+		raw, _, err := detectEncoding(chunk.Encoded)
+		if err != nil {
+			return nil, err
+		}
+		addresses, err := splitAddresses(raw)
+		if err != nil {
+			return nil, err
+		}
+		records := make([]DNSRecord, len(addresses))
+		for i, ip := range addresses {
+			records[i] = record
+			records[i].Value = ip.String()
+		}
+		return records, nil
+	}
+
+	txt, err := de.createChunkRecord(chunk, name, ttl)
+	if err != nil {
+		return nil, err
+	}
+	return []DNSRecord{txt}, nil
+}
+
+// CarrierValue recovers the encoded chunk from the records at one name, with
+// values in presentation format. Binary carriers (NULL, AAAA) come back
+// base32 encoded.
+func (de *DNSEncoder) CarrierValue(records []DNSRecord) (string, error) {
+	switch strings.ToUpper(records[0].Type) {
+	case RECORD_NULL:
+		raw, err := parseGenericRData(records[0].Value)
+		if err != nil {
+			return "", err
+		}
+		return encodeBytes(ENCODE_BASE32, raw), nil
+
+	case RECORD_CNAME:
+		return de.parseCNAMETarget(records[0].Value)
+
+	case RECORD_AAAA:
+		addresses := make([]net.IP, 0, len(records))
+		for _, record := range records {
+			ip := net.ParseIP(record.Value)
+			if ip == nil {
+				return "", fmt.Errorf("invalid AAAA address %q", record.Value)
+			}
+			addresses = append(addresses, ip)
+		}
+		raw, err := joinAddresses(addresses)
+		if err != nil {
+			return "", err
+		}
+		return encodeBytes(ENCODE_BASE32, raw), nil
+	}
+
+	return de.unescapeTXTValue(records[0].Value), nil
+}
+
+// cnameSuffix is the part of CNAME targets after the chunk labels
+func (de *DNSEncoder) cnameSuffix() string {
+	return fmt.Sprintf("%s.%s", de.subdomain, strings.TrimSuffix(de.domain, "."))
+}
+
+// cnameTarget cuts an encoded chunk into labels in front of the domain
+func (de *DNSEncoder) cnameTarget(encoded string) (string, error) {
+	if _, err := restoreLabelCase(encoded); err != nil {
+		return "", fmt.Errorf("CNAME carrier needs hex or base32 chunks: %w", err)
+	}
+
+	data := strings.ToLower(encoded)
+	labels := make([]string, 0, len(data)/MAX_LABEL_SIZE+2)
+	for len(data) > MAX_LABEL_SIZE {
+		labels = append(labels, data[:MAX_LABEL_SIZE])
+		data = data[MAX_LABEL_SIZE:]
+	}
+	labels = append(labels, data, de.cnameSuffix())
+
+	target := strings.Join(labels, ".")
+	if len(target) > MAX_QNAME_LENGTH {
+		return "", fmt.Errorf("CNAME target is %d characters, max %d (use the %s or %s profile)",
+			len(target), MAX_QNAME_LENGTH, PROFILE_QNAME_63, PROFILE_QNAME_MULTI)
+	}
+	return target, nil
+}
+
+// parseCNAMETarget extracts the encoded chunk from a CNAME target
+func (de *DNSEncoder) parseCNAMETarget(target string) (string, error) {
+	target = strings.ToLower(strings.TrimSuffix(target, "."))
+	data, ok := strings.CutSuffix(target, "."+strings.ToLower(de.cnameSuffix()))
+	if !ok {
+		return "", fmt.Errorf("CNAME target %q is outside %s", target, de.cnameSuffix())
+	}
+	return restoreLabelCase(strings.ReplaceAll(data, ".", ""))
+}
+
+// splitAddresses spreads raw chunk bytes over indexed shard-style addresses
+func splitAddresses(raw []byte) ([]net.IP, error) {
+	framed := binary.BigEndian.AppendUint16(nil, uint16(len(raw)))
+	framed = append(framed, raw...)
+
+	count := (len(framed) + SHARD_AAAA_DATA - 1) / SHARD_AAAA_DATA
+	if len(raw) > 0xFFFF || count > 256 {
+		return nil, fmt.Errorf("chunk is %d bytes, an AAAA set holds %d", len(raw), 256*SHARD_AAAA_DATA-2)
+	}
+
+	addresses := make([]net.IP, count)
+	for i := range addresses {
+		ip := make(net.IP, net.IPv6len)
+		copy(ip, SHARD_AAAA_PREFIX[:])
+		ip[2] = byte(i)
+		copy(ip[3:], framed[i*SHARD_AAAA_DATA:])
+		addresses[i] = ip
+	}
+	return addresses, nil
+}
+
+// joinAddresses recombines addresses from splitAddresses, in any order
+func joinAddresses(addresses []net.IP) ([]byte, error) {
+	sorted := make([]net.IP, len(addresses))
+	copy(sorted, addresses)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].To16()[2] < sorted[j].To16()[2]
+	})
+
+	var framed []byte
+	for i, addr := range sorted {
+		ip := addr.To16()
+		if ip == nil || ip[0] != SHARD_AAAA_PREFIX[0] || ip[1] != SHARD_AAAA_PREFIX[1] || int(ip[2]) != i {
+			return nil, fmt.Errorf("address %s is not part %d", addr, i)
+		}
+		framed = append(framed, ip[3:]...)
+	}
+
+	if len(framed) < 2 {
+		return nil, errors.New("no addresses")
+	}
+	size := int(binary.BigEndian.Uint16(framed))
+	if size > len(framed)-2 {
+		return nil, fmt.Errorf("addresses hold %d bytes, length says %d", len(framed)-2, size)
+	}
+	return framed[2 : 2+size], nil
+}
+
+// parseGenericRData decodes RFC 3597 rdata: \# <length> <hex>
+func parseGenericRData(value string) ([]byte, error) {
+	fields := strings.Fields(value)
+	if len(fields) < 2 || fields[0] != `\#` {
+		return nil, fmt.Errorf("NULL rdata %q is not in \\# <length> <hex> form", value)
+	}
+
+	size, err := strconv.Atoi(fields[1])
+	if err != nil {
+		return nil, fmt.Errorf("invalid NULL rdata length: %w", err)
+	}
+	raw, err := hex.DecodeString(strings.Join(fields[2:], ""))
+	if err != nil {
+		return nil, fmt.Errorf("invalid NULL rdata: %w", err)
+	}
+	if len(raw) != size {
+		return nil, fmt.Errorf("NULL rdata holds %d bytes, length says %d", len(raw), size)
+	}
+	return raw, nil
+}
@@ -0,0 +1,248 @@
+package chunker
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// ================================================================================
+// THEORY LESSON: Bits in the Case of a Name
+// ================================================================================
+//
+// DNS names are case-insensitive (RFC 4343), but the case a query was sent
+// with reaches the authoritative server intact and is echoed back in the
+// answer. Resolvers use that for DNS 0x20: they randomize the case of
+// every query they send and drop answers that don't echo it, so mixed-case
+// names are ordinary traffic - and each letter is one bit nobody reads.
+//
+//   c-17-b180fbe873abeeaf.data.example.com
+//   C-17-b180FbE873aBEeaf.Data.eXAmple.cOm   <- same name, 31 letters = 31 bits
+//
+// The case channel sends a short signal (up to CASE_MAX_SIGNAL bytes) that
+// way, alongside whatever the queries are for. Each query with at least
+// CASE_FRAME_BITS letters carries one frame in its first letters:
+//
+//   [offset byte][data byte][check byte]   XOR a keyed mask
+//
+// where offset 255 announces the signal's length and the check byte is a
+// keyed MAC of the other two, so random case - from resolvers or other
+// clients - is almost never mistaken for a frame. Frames repeat in a loop,
+// so lost queries only delay the signal. Remaining letters are random.
+//
+// Random case is also a cheap cache-buster against caches that key on the
+// exact name; caches that follow RFC 4343 fold case and answer anyway.
+// Recursive resolvers that do 0x20 themselves re-randomize the case on the
+// way to us, so the signal only survives direct queries and resolvers that
+// pass names through unchanged.
+// ================================================================================
+
+const (
+	// CASE_FRAME_BITS is the number of letters a name needs to carry a frame
+	CASE_FRAME_BITS = 24
+
+	// CASE_MAX_SIGNAL is the longest signal, in bytes
+	CASE_MAX_SIGNAL = 254
+
+	// caseLengthOffset marks the frame announcing the signal length
+	caseLengthOffset = 0xFF
+
+	// CASE_KEY_CONTEXT separates case channel MACs from other uses of a key
+	CASE_KEY_CONTEXT = "simulacra-case-v1"
+)
+
+// CaseLetters counts the letters of a name: the bits its case can carry
+func CaseLetters(name string) int {
+	count := 0
+	for i := 0; i < len(name); i++ {
+		if isLetter(name[i]) {
+			count++
+		}
+	}
+	return count
+}
+
+// isLetter reports whether b is an ASCII letter
+func isLetter(b byte) bool {
+	return (b >= 'a' && b <= 'z') || (b >= 'A' && b <= 'Z')
+}
+
+// caseCodec holds the keyed parts shared by writers and readers
+type caseCodec struct {
+	key  []byte
+	mask [3]byte
+}
+
+// newCaseCodec derives the frame mask for key
+func newCaseCodec(key []byte) caseCodec {
+	c := caseCodec{key: key}
+	copy(c.mask[:], c.mac([]byte("mask")))
+	return c
+}
+
+// mac is HMAC-SHA256 under the channel key
+func (c caseCodec) mac(data []byte) []byte {
+	h := hmac.New(sha256.New, append([]byte(CASE_KEY_CONTEXT), c.key...))
+	h.Write(data)
+	return h.Sum(nil)
+}
+
+// frame builds the masked frame for one signal byte
+func (c caseCodec) frame(offset, data byte) [3]byte {
+	check := c.mac([]byte{'f', offset, data})[0]
+	return [3]byte{offset ^ c.mask[0], data ^ c.mask[1], check ^ c.mask[2]}
+}
+
+// open unmasks a frame, reporting whether its check byte is valid
+func (c caseCodec) open(frame [3]byte) (offset, data byte, ok bool) {
+	offset, data = frame[0]^c.mask[0], frame[1]^c.mask[1]
+	return offset, data, c.mac([]byte{'f', offset, data})[0] == frame[2]^c.mask[2]
+}
+
+// CaseWriter writes a signal into the case of successive query names
+type CaseWriter struct {
+	codec  caseCodec
+	frames [][3]byte // Sent in a loop (none = random case only)
+	next   int
+	mu     sync.Mutex
+}
+
+// NewCaseWriter creates a writer sending signal under key; an empty signal
+// only randomizes case
+func NewCaseWriter(key, signal []byte) (*CaseWriter, error) {
+	if len(signal) > CASE_MAX_SIGNAL {
+		return nil, fmt.Errorf("case signal is %d bytes, at most %d fit", len(signal), CASE_MAX_SIGNAL)
+	}
+	if len(signal) > 0 && len(key) == 0 {
+		return nil, errors.New("case signal needs a key")
+	}
+
+	w := &CaseWriter{codec: newCaseCodec(key)}
+	if len(signal) > 0 {
+		w.frames = append(w.frames, w.codec.frame(caseLengthOffset, byte(len(signal))))
+		for i, b := range signal {
+			w.frames = append(w.frames, w.codec.frame(byte(i), b))
+		}
+	}
+	return w, nil
+}
+
+// Apply returns name with its case carrying the next frame (when it has
+// enough letters) and random case elsewhere
+func (w *CaseWriter) Apply(name string) string {
+	bits := make([]byte, (CaseLetters(name)+7)/8)
+	rand.Read(bits)
+
+	if len(w.frames) > 0 && CaseLetters(name) >= CASE_FRAME_BITS {
+		w.mu.Lock()
+		frame := w.frames[w.next]
+		w.next = (w.next + 1) % len(w.frames)
+		w.mu.Unlock()
+		copy(bits, frame[:])
+	}
+
+	out := []byte(name)
+	letter := 0
+	for i, b := range out {
+		if !isLetter(b) {
+			continue
+		}
+		if bits[letter/8]&(0x80>>(letter%8)) != 0 {
+			out[i] = b &^ 0x20 // Upper
+		} else {
+			out[i] = b | 0x20 // Lower
+		}
+		letter++
+	}
+	return string(out)
+}
+
+// CaseReader collects a signal from the case of query names
+type CaseReader struct {
+	codec  caseCodec
+	data   [CASE_MAX_SIGNAL]byte
+	have   [CASE_MAX_SIGNAL]bool
+	length int    // -1 until the length frame arrives
+	last   []byte // Last signal reported
+	mu     sync.Mutex
+}
+
+// NewCaseReader creates a reader for signals sent under key
+func NewCaseReader(key []byte) *CaseReader {
+	return &CaseReader{codec: newCaseCodec(key), length: -1}
+}
+
+// Read takes the name of one query as received. It returns the signal
+// once all of it has arrived - once, though the writer keeps repeating it
+// - and starts collecting the next.
+func (r *CaseReader) Read(name string) ([]byte, bool) {
+	if CaseLetters(name) < CASE_FRAME_BITS {
+		return nil, false
+	}
+
+	var frame [3]byte
+	letter := 0
+	for i := 0; i < len(name) && letter < CASE_FRAME_BITS; i++ {
+		if !isLetter(name[i]) {
+			continue
+		}
+		if name[i] >= 'A' && name[i] <= 'Z' {
+			frame[letter/8] |= 0x80 >> (letter % 8)
+		}
+		letter++
+	}
+
+	offset, data, ok := r.codec.open(frame)
+	if !ok {
+		return nil, false
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// A frame contradicting what was collected starts a new signal
+	switch {
+	case offset == caseLengthOffset:
+		if int(data) > CASE_MAX_SIGNAL {
+			return nil, false
+		}
+		if r.length != -1 && r.length != int(data) {
+			r.reset()
+		}
+		r.length = int(data)
+	case int(offset) >= CASE_MAX_SIGNAL:
+		return nil, false
+	default:
+		if r.have[offset] && r.data[offset] != data {
+			r.reset()
+		}
+		r.data[offset], r.have[offset] = data, true
+	}
+
+	if r.length <= 0 {
+		return nil, false
+	}
+	for i := 0; i < r.length; i++ {
+		if !r.have[i] {
+			return nil, false
+		}
+	}
+
+	signal := append([]byte(nil), r.data[:r.length]...)
+	r.reset()
+	if bytes.Equal(signal, r.last) {
+		return nil, false
+	}
+	r.last = signal
+	return signal, true
+}
+
+// reset forgets a partly collected signal
+func (r *CaseReader) reset() {
+	r.have = [CASE_MAX_SIGNAL]bool{}
+	r.length = -1
+}
@@ -1,14 +1,16 @@
 package chunker
 
 import (
+	"crypto/hmac"
 	"crypto/sha256"
-	"encoding/base32"
 	"encoding/binary"
 	"encoding/hex"
 	"errors"
 	"fmt"
 	"math"
+	"runtime"
 	"sort"
+	"strings"
 	"time"
 )
 
@@ -50,29 +52,45 @@ const (
 	// Contains: Magic(4) + MessageID(16) + Sequence(2) + Total(2) + Checksum(4) = 28 bytes
 	METADATA_OVERHEAD = 28
 
-	// PAYLOAD_PER_CHUNK is the actual data we can fit per chunk
-	// This accounts for hex encoding: (250 - 28) / 2 = 111 bytes of raw data
-	// For base32: (250 - 28) / 1.6 ≈ 138 bytes of raw data
-	PAYLOAD_PER_CHUNK_HEX = (SAFE_CHUNK_SIZE - METADATA_OVERHEAD) / 2
-	// PAYLOAD_PER_CHUNK_B32 = int((SAFE_CHUNK_SIZE - METADATA_OVERHEAD) / 1.6)
+	// METADATA_OVERHEAD_V2 adds a flags byte (integrity algorithm, parity bit)
+	METADATA_OVERHEAD_V2 = METADATA_OVERHEAD + 1
 
 	// ENCODING TYPES
-	ENCODE_HEX    = "hex"
-	ENCODE_BASE32 = "base32"
+	ENCODE_HEX       = "hex"
+	ENCODE_BASE32    = "base32"
+	ENCODE_BASE64URL = "base64url"
+	ENCODE_BASE91    = "base91"
 
 	// MAGIC_BYTES identifies our chunk protocol version
 	// Allows future protocol evolution
-	CHUNK_MAGIC = 0x444E5343 // "DNSC" in hex
-
-	// Protocol version for future compatibility
-	PROTOCOL_VERSION = 1
+	CHUNK_MAGIC    = 0x444E5343 // "DNSC" in hex (v1)
+	CHUNK_MAGIC_V2 = 0x444E5332 // "DNS2" in hex (v2: flags byte)
+
+	// Protocol version for future compatibility (newest wire format, see wire.go)
+	PROTOCOL_VERSION = 3
+
+	// v2 header flags
+	FLAG_INTEGRITY_MASK    = 0x07 // Bits 0-2: IntegrityAlgorithm
+	FLAG_EXTENDED          = 0x08 // Extended header at the start of the message
+	FLAG_COMPRESSION_MASK  = 0x30 // Bits 4-5: CompressionAlgorithm
+	FLAG_COMPRESSION_SHIFT = 4
+	FLAG_ENCRYPTED         = 0x40 // Message wrapped in an AES-256-GCM envelope
+	FLAG_PARITY            = 0x80 // Reed-Solomon parity chunk
 )
 
-// For base32, we need to account for encoding the ENTIRE chunk (metadata + payload)
-// Target: 250 bytes encoded total
-// Math: (METADATA_OVERHEAD + payload) * 1.6 = 250
-// Therefore: payload = (250/1.6) - METADATA_OVERHEAD
-var PAYLOAD_PER_CHUNK_B32 = int(math.Floor(float64(SAFE_CHUNK_SIZE)/1.6)) - METADATA_OVERHEAD
+// PAYLOAD_PER_CHUNK is the actual data we can fit per chunk (v1 header).
+// We need to account for encoding the ENTIRE chunk (metadata + payload):
+// encodedLen(METADATA_OVERHEAD + payload) <= SAFE_CHUNK_SIZE
+// hex:       240 / 2    = 120 raw bytes -> 92 payload
+// base32:    240 / 1.6  = 150 raw bytes -> 122 payload
+// base64url: 240 / 1.33 = 180 raw bytes -> 152 payload
+// base91:    240 / 1.23 = 195 raw bytes -> 167 payload (worst case)
+var (
+	PAYLOAD_PER_CHUNK_HEX = maxRawBytes(ENCODE_HEX, SAFE_CHUNK_SIZE) - METADATA_OVERHEAD
+	PAYLOAD_PER_CHUNK_B32 = maxRawBytes(ENCODE_BASE32, SAFE_CHUNK_SIZE) - METADATA_OVERHEAD
+	PAYLOAD_PER_CHUNK_B64 = maxRawBytes(ENCODE_BASE64URL, SAFE_CHUNK_SIZE) - METADATA_OVERHEAD
+	PAYLOAD_PER_CHUNK_B91 = maxRawBytes(ENCODE_BASE91, SAFE_CHUNK_SIZE) - METADATA_OVERHEAD
+)
 
 // ================================================================================
 // LESSON: Chunk Structure Design
@@ -94,17 +112,25 @@ var PAYLOAD_PER_CHUNK_B32 = int(math.Floor(float64(SAFE_CHUNK_SIZE)/1.6)) - META
 type ChunkMetadata struct {
 	Magic       uint32   // Protocol identifier and version check
 	MessageID   [16]byte // Unique message identifier (128-bit)
-	Sequence    uint16   // Chunk number (0-based)
-	TotalChunks uint16   // Total number of chunks in message
-	Checksum    uint32   // CRC32 of this chunk's payload
+	Sequence    uint32   // Chunk number (0-based)
+	TotalChunks uint32   // Total number of chunks in message
+	Checksum    uint32   // Integrity value of this chunk's payload
 	Timestamp   int64    // Unix timestamp for TTL/cleanup
 	PayloadSize uint16   // Actual payload bytes (for last chunk)
+	Parity      bool     // Parity or fountain-coded chunk (not message data)
+
+	Version     uint8                // Wire protocol version (see WireFormat)
+	Integrity   IntegrityAlgorithm   // Algorithm that produced Checksum
+	Compression CompressionAlgorithm // Compression applied to the whole message
+	Encrypted   bool                 // Message sealed in an envelope (header in chunk 0)
+	Extended    bool                 // Extended header at the start of the message
 }
 
 // Chunk represents a single DNS-ready fragment
 type Chunk struct {
 	Metadata   ChunkMetadata
 	Payload    []byte // Raw data (before encoding)
+	Tag        []byte // Authentication tag (INTEGRITY_HMAC_SHA256 chunks only)
 	Encoded    string // DNS-ready encoded string
 	RecordName string // Suggested DNS record name
 }
@@ -117,21 +143,65 @@ type Message struct {
 	Encoding  string            // Encoding type used
 	CreatedAt time.Time         // Message creation time
 	Metadata  map[string]string // Additional metadata
+	Info      *ContentInfo      // Extended header contents (nil without one)
 }
 
 // ChunkerConfig allows customization of chunking behavior
 type ChunkerConfig struct {
-	Encoding      string // hex or base32
-	MaxChunkSize  int    // Override default chunk size
-	AddRedundancy bool   // Add error correction codes
-	Compression   bool   // Pre-compress data
-	DNSNamePrefix string // Prefix for DNS record names
+	Encoding      string  // hex, base32, base64url or base91
+	MaxChunkSize  int     // Encoded chunk size (derived from the profile)
+	AddRedundancy bool    // Add error correction codes
+	Redundancy    float64 // Parity chunks per data chunk (e.g. 0.25 = 25%)
+	FECScheme     string  // reed-solomon (default) or fountain
+	Compression   bool    // Pre-compress data
+	DNSNamePrefix string  // Prefix for DNS record names
+
+	Profile          string // Sizing profile: txt-255 (default), txt-multi, edns-4096, qname-63
+	StringsPerRecord int    // TXT strings packed into one chunk record (1-16, used without Profile)
+
+	CompressionAlgorithm string // gzip (default), zstd or brotli
+
+	// Integrity policy
+	Integrity       string // Checksum algorithm: crc32c (default), xxhash64, crc64, sha256, legacy
+	StrictIntegrity bool   // Reject chunks protected only by the legacy v1 sum
+
+	// Envelope encryption (either one enables it)
+	Password string // Derive a per-message key with PBKDF2
+	Key      []byte // Use a raw 32-byte AES-256 key
+
+	// Per-chunk authentication
+	AuthKey     []byte // Shared secret: tag every chunk with HMAC-SHA256
+	RequireAuth bool   // Reject chunks without a valid tag
+
+	// Content-addressed IDs: the same data always gets the same message ID
+	DeterministicID bool
+
+	// Emit chunks in a keyed pseudo-random order (receivers reorder by sequence)
+	Shuffle bool
+
+	// Goroutines encoding chunks (0 or 1 = serial, negative = one per CPU)
+	Workers int
+
+	// Metadata TLVs sealed into every message's extended header
+	// (ChunkMessage then adds a header without name or type to carry them)
+	TLVs TLVs
+
+	// Chunk header format: 0 picks the smallest that fits each message,
+	// 2 or 3 pins one (legacy integrity always uses v1)
+	WireVersion uint8
+
+	Logger Logger // Progress reports (nil prints to stdout, DiscardLogger silences)
 }
 
 // Chunker handles message fragmentation
 type Chunker struct {
-	config ChunkerConfig
-	stats  ChunkingStats
+	config      ChunkerConfig
+	integrity   IntegrityAlgorithm
+	compression CompressionAlgorithm
+	profile     SizingProfile
+	wire        *WireFormat // Header format of the message being chunked
+	stats       ChunkingStats
+	logger      Logger
 }
 
 // ChunkingStats tracks performance metrics
@@ -149,47 +219,230 @@ type ChunkingStats struct {
 
 // NewChunker creates a configured chunker instance
 func NewChunker(config ChunkerConfig) *Chunker {
+	logger := resolveLogger(config.Logger)
+
 	// Set defaults
 	if config.Encoding == "" {
 		config.Encoding = ENCODE_BASE32 // More efficient than hex
 	}
-	if config.MaxChunkSize == 0 {
-		config.MaxChunkSize = SAFE_CHUNK_SIZE
+	config.Encoding = strings.ToLower(config.Encoding)
+	if !IsEncodingSupported(config.Encoding) {
+		logEvent(logger, EVENT_WARNING, map[string]interface{}{"setting": "encoding", "value": config.Encoding, "fallback": ENCODE_HEX},
+			"⚠️  Unknown encoding %q, using %s", config.Encoding, ENCODE_HEX)
+		config.Encoding = ENCODE_HEX
+	}
+	// The sizing profile fixes the chunk size; a receiver configured from
+	// capabilities only knows the size, so the profile is derived from it
+	profile := resolveProfile(config, logger)
+	config.Profile = profile.Name
+	config.StringsPerRecord = profile.Strings
+	config.MaxChunkSize = profile.ChunkSize
+	if !profile.AllowsEncoding(config.Encoding) {
+		logEvent(logger, EVENT_WARNING, map[string]interface{}{"setting": "encoding", "value": config.Encoding, "fallback": profile.Encodings[0]},
+			"⚠️  Encoding %s is not valid for profile %s, using %s", config.Encoding, profile.Name, profile.Encodings[0])
+		config.Encoding = profile.Encodings[0]
+	}
+	if config.AddRedundancy && config.Redundancy <= 0 {
+		config.Redundancy = DEFAULT_REDUNDANCY
+	}
+	scheme, err := ParseFECScheme(config.FECScheme)
+	if err != nil {
+		logEvent(logger, EVENT_WARNING, map[string]interface{}{"setting": "fec_scheme", "value": config.FECScheme, "fallback": FEC_REED_SOLOMON},
+			"⚠️  %v, using %s", err, FEC_REED_SOLOMON)
+		scheme = FEC_REED_SOLOMON
+	}
+	config.FECScheme = scheme
+	if config.Workers < 0 {
+		config.Workers = runtime.NumCPU()
+	}
+	maxRedundancy := MAX_REDUNDANCY
+	if scheme == FEC_FOUNTAIN {
+		maxRedundancy = FOUNTAIN_MAX_REDUNDANCY
+	}
+	if config.Redundancy > maxRedundancy {
+		config.Redundancy = maxRedundancy
+	}
+	if config.Integrity == "" {
+		config.Integrity = DEFAULT_INTEGRITY
+	}
+
+	// Unknown names fall back to the default rather than failing silently later
+	integrity, err := ParseIntegrity(config.Integrity)
+	if err != nil {
+		logEvent(logger, EVENT_WARNING, map[string]interface{}{"setting": "integrity", "value": config.Integrity, "fallback": DEFAULT_INTEGRITY},
+			"⚠️  %v, using %s", err, DEFAULT_INTEGRITY)
+		config.Integrity = DEFAULT_INTEGRITY
+		integrity = INTEGRITY_CRC32C
+	}
+
+	compression := COMPRESS_NONE
+	if config.Compression {
+		if config.CompressionAlgorithm == "" {
+			config.CompressionAlgorithm = DEFAULT_COMPRESSION
+		}
+		compression, err = ParseCompression(config.CompressionAlgorithm)
+		if err != nil {
+			logEvent(logger, EVENT_WARNING, map[string]interface{}{"setting": "compression", "value": config.CompressionAlgorithm, "fallback": DEFAULT_COMPRESSION},
+				"⚠️  %v, using %s", err, DEFAULT_COMPRESSION)
+			config.CompressionAlgorithm = DEFAULT_COMPRESSION
+			compression = COMPRESS_GZIP
+		}
+
+		// The v1 header has no room to record the algorithm
+		if integrity == INTEGRITY_LEGACY && compression != COMPRESS_NONE {
+			logEvent(logger, EVENT_WARNING, map[string]interface{}{"setting": "compression", "value": compression.String(), "fallback": COMPRESS_NONE.String()},
+				"⚠️  Compression needs protocol v2, disabled with legacy integrity")
+			compression = COMPRESS_NONE
+		}
+	}
+
+	// A tag only makes sense with a key; with one, every chunk is tagged
+	if len(config.AuthKey) > 0 && integrity != INTEGRITY_HMAC_SHA256 {
+		if config.Integrity != DEFAULT_INTEGRITY {
+			logEvent(logger, EVENT_WARNING, map[string]interface{}{"setting": "integrity", "value": config.Integrity, "fallback": INTEGRITY_HMAC_SHA256.String()},
+				"⚠️  Authentication key set, using %s integrity instead of %s", INTEGRITY_HMAC_SHA256, config.Integrity)
+		}
+		config.Integrity = INTEGRITY_HMAC_SHA256.String()
+		integrity = INTEGRITY_HMAC_SHA256
+	} else if len(config.AuthKey) == 0 && integrity == INTEGRITY_HMAC_SHA256 {
+		logEvent(logger, EVENT_WARNING, map[string]interface{}{"setting": "integrity", "value": config.Integrity, "fallback": DEFAULT_INTEGRITY},
+			"⚠️  %s needs an authentication key, using %s", INTEGRITY_HMAC_SHA256, DEFAULT_INTEGRITY)
+		config.Integrity = DEFAULT_INTEGRITY
+		integrity = INTEGRITY_CRC32C
 	}
 
-	return &Chunker{
-		config: config,
+	// An encrypted message must be flagged, which v1 can't express either
+	if config.encrypting() && integrity == INTEGRITY_LEGACY {
+		logEvent(logger, EVENT_WARNING, map[string]interface{}{"setting": "integrity", "value": config.Integrity, "fallback": DEFAULT_INTEGRITY},
+			"⚠️  Encryption needs protocol v2, using %s integrity", DEFAULT_INTEGRITY)
+		config.Integrity = DEFAULT_INTEGRITY
+		integrity = INTEGRITY_CRC32C
 	}
+
+	// The legacy checksum is only expressible in the v1 format, which
+	// can't be chosen for anything else
+	if config.WireVersion != 0 {
+		format, err := LookupWireFormat(config.WireVersion)
+		if err == nil && !format.Flags && integrity != INTEGRITY_LEGACY {
+			err = fmt.Errorf("wire format v%d only carries the legacy checksum", config.WireVersion)
+		}
+		if err != nil {
+			logEvent(logger, EVENT_WARNING, map[string]interface{}{"setting": "wire_version", "value": config.WireVersion, "fallback": 0},
+				"⚠️  %v, choosing the format per message", err)
+			config.WireVersion = 0
+		}
+	}
+	if integrity == INTEGRITY_LEGACY {
+		config.WireVersion = 1
+	}
+
+	c := &Chunker{
+		config:      config,
+		integrity:   integrity,
+		compression: compression,
+		profile:     profile,
+		logger:      logger,
+	}
+	c.wire = c.configuredWire(0)
+	return c
 }
 
 // ChunkMessage fragments a message into DNS-ready chunks
 func (c *Chunker) ChunkMessage(data []byte) (*Message, error) {
+	return c.chunkMessage(data, nil)
+}
+
+// ChunkFile fragments a file, adding an extended header with its name,
+// content type (detected when empty) and SHA-256
+func (c *Chunker) ChunkFile(data []byte, filename, contentType string) (*Message, error) {
+	info, err := NewContentInfo(data, filename, contentType)
+	if err != nil {
+		return nil, err
+	}
+	return c.chunkMessage(data, info)
+}
+
+// chunkMessage fragments data, prefixed with an extended header when info is set
+func (c *Chunker) chunkMessage(data []byte, info *ContentInfo) (*Message, error) {
 	startTime := time.Now()
 
 	// LESSON: Message ID Generation
-	// We use SHA256 of data + timestamp for uniqueness
-	// This prevents duplicate messages from colliding
+	// By default we use SHA256 of data + timestamp for uniqueness, which
+	// prevents duplicate messages from colliding. Content-addressed IDs
+	// (DeterministicID) hash the data alone, so re-sending a file reuses
+	// its ID and the server can recognize the duplicate.
 	messageID := c.generateMessageID(data)
 
-	// Calculate payload size per chunk based on encoding
-	payloadSize := c.calculatePayloadSize()
+	c.log(EVENT_ANALYSIS, map[string]interface{}{"data_size": len(data)},
+		"\n📊 CHUNKING ANALYSIS:\n   Data size: %d bytes", len(data))
 
-	// LESSON: Chunk Count Calculation
-	// We must carefully calculate to avoid off-by-one errors
-	totalChunks := c.calculateTotalChunks(len(data), payloadSize)
+	// The extended header leads the stream, so compression and
+	// encryption cover it as well
+	stream := data
+	if len(c.config.TLVs) > 0 {
+		if info == nil {
+			info = &ContentInfo{SHA256: sha256.Sum256(data)}
+		}
+		info.TLVs = append(info.TLVs, c.config.TLVs...)
+	}
+	if info != nil && c.protocolVersion() == 1 {
+		logEvent(c.logger, EVENT_WARNING, map[string]interface{}{"setting": "extended_header", "value": true, "fallback": false},
+			"⚠️  Extended header needs protocol v2, disabled with legacy integrity")
+		info = nil
+	}
+	if info != nil {
+		stream = append(info.marshal(), data...)
+		c.log(EVENT_EXTENDED, map[string]interface{}{"filename": info.Filename, "content_type": info.ContentType},
+			"   Extended header: %s", info)
+	}
 
-	if totalChunks > math.MaxUint16 {
-		return nil, fmt.Errorf("message too large: requires %d chunks (max %d)",
-			totalChunks, math.MaxUint16)
+	// Compress the whole message before fragmenting it
+	payloadData, compression := c.CompressBeforeChunking(stream)
+
+	// Encrypt after compressing: ciphertext doesn't compress
+	encrypted := c.config.encrypting()
+	if encrypted {
+		sealed, err := c.sealEnvelope(payloadData, messageID)
+		if err != nil {
+			return nil, fmt.Errorf("encryption failed: %w", err)
+		}
+		c.log(EVENT_ENCRYPTION, map[string]interface{}{"cipher": "aes-256-gcm", "before": len(payloadData), "after": len(sealed)},
+			"   Encryption: AES-256-GCM envelope, %d -> %d bytes", len(payloadData), len(sealed))
+		payloadData = sealed
 	}
 
-	fmt.Printf("\n📊 CHUNKING ANALYSIS:\n")
-	fmt.Printf("   Data size: %d bytes\n", len(data))
-	fmt.Printf("   Encoding: %s\n", c.config.Encoding)
-	fmt.Printf("   Payload per chunk: %d bytes\n", payloadSize)
-	fmt.Printf("   Total chunks needed: %d\n", totalChunks)
-	fmt.Printf("   DNS records required: %d\n", totalChunks)
-	fmt.Printf("   Overhead: %.1f%%\n", c.calculateOverhead(len(data), totalChunks))
+	// Calculate payload size per chunk based on encoding, profile and the
+	// wire format the message needs
+	payloadSize, totalChunks, err := c.planChunks(len(payloadData))
+	if err != nil {
+		return nil, err
+	}
+
+	overhead := c.calculateOverhead(len(payloadData), totalChunks)
+	var plan strings.Builder
+	fmt.Fprintf(&plan, "   Encoding: %s\n", c.config.Encoding)
+	fmt.Fprintf(&plan, "   Wire format: %s\n", c.wire)
+	fmt.Fprintf(&plan, "   Sizing profile: %s\n", c.profile)
+	fmt.Fprintf(&plan, "   Payload per chunk: %d bytes\n", payloadSize)
+	if c.config.StringsPerRecord > 1 {
+		fmt.Fprintf(&plan, "   TXT strings per record: %d\n", c.config.StringsPerRecord)
+	}
+	fmt.Fprintf(&plan, "   Total chunks needed: %d\n", totalChunks)
+	fmt.Fprintf(&plan, "   DNS records required: %d\n", totalChunks)
+	if c.config.Workers > 1 && totalChunks >= MIN_PARALLEL_CHUNKS {
+		fmt.Fprintf(&plan, "   Encoding workers: %d\n", c.config.Workers)
+	}
+	fmt.Fprintf(&plan, "   Overhead: %.1f%%", overhead)
+	c.log(EVENT_PLAN, map[string]interface{}{
+		"encoding":          c.config.Encoding,
+		"wire_version":      c.wire.Version,
+		"profile":           c.profile.Name,
+		"payload_per_chunk": payloadSize,
+		"strings":           c.config.StringsPerRecord,
+		"total_chunks":      totalChunks,
+		"overhead":          overhead,
+		"workers":           c.config.Workers,
+	}, "%s", plan.String())
 
 	// Create message container
 	message := &Message{
@@ -199,27 +452,51 @@ func (c *Chunker) ChunkMessage(data []byte) (*Message, error) {
 		Encoding:  c.config.Encoding,
 		CreatedAt: time.Now(),
 		Metadata:  make(map[string]string),
+		Info:      info,
+	}
+	if compression != COMPRESS_NONE {
+		message.Metadata["compression"] = compression.String()
+		message.Metadata["compressed_size"] = fmt.Sprintf("%d", len(payloadData))
+	}
+	if encrypted {
+		message.Metadata["encryption"] = "aes-256-gcm"
+	}
+
+	// Fragment data into chunks (in parallel with Workers > 1)
+	message.Chunks = c.createChunks(payloadData, messageID, totalChunks, payloadSize, compression, encrypted, info != nil)
+
+	// Append Reed-Solomon parity or fountain-coded chunks
+	if c.config.AddRedundancy {
+		if c.config.FECScheme == FEC_FOUNTAIN {
+			message.Chunks = c.addFountain(message.Chunks, c.config.Redundancy)
+			message.Metadata["fec"] = FEC_FOUNTAIN
+		} else {
+			message.Chunks = c.AddRedundancy(message.Chunks, c.config.Redundancy)
+		}
 	}
 
-	// Fragment data into chunks
-	for i := 0; i < totalChunks; i++ {
-		chunk := c.createChunk(data, messageID, i, uint16(totalChunks), payloadSize)
-		message.Chunks = append(message.Chunks, chunk)
+	// Interleave data and parity chunks in a keyed order
+	if c.config.Shuffle {
+		message.Chunks = c.shuffleChunks(message.Chunks, messageID)
+		message.Metadata["order"] = "shuffled"
+		c.log(EVENT_SHUFFLED, map[string]interface{}{"chunks": len(message.Chunks)},
+			"   Emission order: keyed shuffle of %d chunks", len(message.Chunks))
 	}
 
 	// Update statistics
 	c.stats.MessagesChunked++
-	c.stats.TotalChunks += totalChunks
+	c.stats.TotalChunks += len(message.Chunks)
 	c.stats.TotalBytes += len(data)
 	c.stats.LastChunkingTime = time.Since(startTime)
 
-	fmt.Printf("   Chunking completed in: %v\n", c.stats.LastChunkingTime)
+	c.log(EVENT_CHUNKED, map[string]interface{}{"chunks": len(message.Chunks), "duration": c.stats.LastChunkingTime},
+		"   Chunking completed in: %v", c.stats.LastChunkingTime)
 
 	return message, nil
 }
 
 // createChunk creates a single chunk with all metadata
-func (c *Chunker) createChunk(data []byte, messageID [16]byte, sequence int, total uint16, payloadSize int) Chunk {
+func (c *Chunker) createChunk(data []byte, messageID [16]byte, sequence int, total uint32, payloadSize int, compression CompressionAlgorithm, encrypted, extended bool) Chunk {
 	// Calculate chunk boundaries
 	start := sequence * payloadSize
 	end := start + payloadSize
@@ -232,17 +509,23 @@ func (c *Chunker) createChunk(data []byte, messageID [16]byte, sequence int, tot
 
 	// Create metadata
 	metadata := ChunkMetadata{
-		Magic:       CHUNK_MAGIC,
+		Magic:       c.chunkMagic(false),
 		MessageID:   messageID,
-		Sequence:    uint16(sequence),
+		Sequence:    uint32(sequence),
 		TotalChunks: total,
 		Checksum:    c.calculateChecksum(payload),
 		Timestamp:   time.Now().Unix(),
 		PayloadSize: uint16(len(payload)),
+		Version:     c.protocolVersion(),
+		Integrity:   c.integrity,
+		Compression: compression,
+		Encrypted:   encrypted,
+		Extended:    extended,
 	}
 
-	// Encode the chunk
-	encoded := c.encodeChunk(metadata, payload)
+	// Authenticate and encode the chunk
+	tag := c.tagFor(metadata, payload)
+	encoded := c.encodeChunk(metadata, payload, tag)
 
 	// Generate DNS record name
 	// Format: seq-total-msgid.prefix.domain.com
@@ -251,74 +534,68 @@ func (c *Chunker) createChunk(data []byte, messageID [16]byte, sequence int, tot
 	return Chunk{
 		Metadata:   metadata,
 		Payload:    payload,
+		Tag:        tag,
 		Encoded:    encoded,
 		RecordName: recordName,
 	}
 }
 
-// encodeChunk combines metadata and payload into DNS-safe string
-func (c *Chunker) encodeChunk(metadata ChunkMetadata, payload []byte) string {
+// tagFor returns the authentication tag for a new chunk, or nil without a key
+func (c *Chunker) tagFor(metadata ChunkMetadata, payload []byte) []byte {
+	if !c.authenticating() {
+		return nil
+	}
+	return c.chunkTag(marshalHeader(metadata), payload)
+}
+
+// encodeChunk combines metadata, payload and tag into DNS-safe string
+func (c *Chunker) encodeChunk(metadata ChunkMetadata, payload, tag []byte) string {
 	// LESSON: Wire Format Design
 	// We need a consistent, parseable format:
-	// [MAGIC(4)][MSGID(16)][SEQ(2)][TOTAL(2)][CHECKSUM(4)][PAYLOAD(variable)]
-
-	// Serialize metadata
-	metaBytes := make([]byte, 0, METADATA_OVERHEAD)
-
-	// Add magic bytes
-	magicBytes := make([]byte, 4)
-	binary.BigEndian.PutUint32(magicBytes, metadata.Magic)
-	metaBytes = append(metaBytes, magicBytes...)
-
-	// Add message ID
-	metaBytes = append(metaBytes, metadata.MessageID[:]...)
-
-	// Add sequence number
-	seqBytes := make([]byte, 2)
-	binary.BigEndian.PutUint16(seqBytes, metadata.Sequence)
-	metaBytes = append(metaBytes, seqBytes...)
+	// v1: [MAGIC(4)][MSGID(16)][SEQ(2)][TOTAL(2)][CHECKSUM(4)][PAYLOAD(variable)]
+	// v2: [MAGIC(4)][MSGID(16)][SEQ(2)][TOTAL(2)][CHECKSUM(4)][FLAGS(1)][PAYLOAD(variable)][TAG(16, optional)]
 
-	// Add total chunks
-	totalBytes := make([]byte, 2)
-	binary.BigEndian.PutUint16(totalBytes, metadata.TotalChunks)
-	metaBytes = append(metaBytes, totalBytes...)
-
-	// Add checksum
-	checksumBytes := make([]byte, 4)
-	binary.BigEndian.PutUint32(checksumBytes, metadata.Checksum)
-	metaBytes = append(metaBytes, checksumBytes...)
-
-	// Combine metadata and payload
-	fullChunk := append(metaBytes, payload...)
+	// Combine metadata, payload and tag
+	fullChunk := append(marshalHeader(metadata), payload...)
+	fullChunk = append(fullChunk, tag...)
 
 	// Encode based on configuration
-	var encoded string
-	switch c.config.Encoding {
-	case ENCODE_HEX:
-		encoded = hex.EncodeToString(fullChunk)
-	case ENCODE_BASE32:
-		encoded = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(fullChunk)
-	default:
-		encoded = hex.EncodeToString(fullChunk)
-	}
+	encoded := encodeBytes(c.config.Encoding, fullChunk)
 
 	// SAFETY CHECK: Ensure we don't exceed DNS limits
-	if len(encoded) > MAX_DNS_STRING_SIZE {
-		panic(fmt.Sprintf("CRITICAL: Encoded chunk too large for DNS! Size: %d bytes (max: %d). Adjust PAYLOAD_PER_CHUNK_B32",
-			len(encoded), MAX_DNS_STRING_SIZE))
+	if err := c.profile.Fits(encoded); err != nil {
+		panic(fmt.Sprintf("CRITICAL: Encoded chunk too large for DNS! %v. Adjust PAYLOAD_PER_CHUNK", err))
 	}
 
 	return encoded
 }
 
+// marshalHeader serializes chunk metadata in the wire order of its magic
+func marshalHeader(metadata ChunkMetadata) []byte {
+	format, ok := WireFormatFor(metadata.Magic)
+	if !ok {
+		panic(fmt.Sprintf("CRITICAL: no wire format for magic %x", metadata.Magic))
+	}
+	return format.marshal(metadata)
+}
+
 // ================================================================================
 // REASSEMBLY FUNCTIONS
 // ================================================================================
 
-// ReassembleMessage reconstructs the original message from chunks
+// ReassembleMessage reconstructs the original message from chunks.
+// An extended header, if present, is verified and stripped.
 func (c *Chunker) ReassembleMessage(chunks []Chunk) ([]byte, error) {
+	data, _, err := c.ReassembleFile(chunks)
+	return data, err
+}
+
+// ReassembleFile reconstructs a message and returns its extended header
+// (nil when the sender didn't add one). The data is checked against the
+// header's SHA-256.
+func (c *Chunker) ReassembleFile(chunks []Chunk) ([]byte, *ContentInfo, error) {
 	if len(chunks) == 0 {
-		return nil, errors.New("no chunks provided")
+		return nil, nil, errors.New("no chunks provided")
 	}
 
 	// LESSON: Reassembly Challenges
@@ -327,8 +604,8 @@ func (c *Chunker) ReassembleMessage(chunks []Chunk) ([]byte, error) {
 	// 3. Chunks may be from different messages
 	// 4. Chunks may be corrupted
 
-	fmt.Printf("\n🔧 REASSEMBLY PROCESS:\n")
-	fmt.Printf("   Chunks received: %d\n", len(chunks))
+	c.log(EVENT_REASSEMBLY, map[string]interface{}{"chunks": len(chunks)},
+		"\n🔧 REASSEMBLY PROCESS:\n   Chunks received: %d", len(chunks))
 
 	// Verify all chunks belong to same message
 	messageID := chunks[0].Metadata.MessageID
@@ -336,20 +613,30 @@ func (c *Chunker) ReassembleMessage(chunks []Chunk) ([]byte, error) {
 
 	for _, chunk := range chunks {
 		if chunk.Metadata.MessageID != messageID {
-			return nil, fmt.Errorf("mixed messages detected: %x vs %x",
+			return nil, nil, fmt.Errorf("mixed messages detected: %x vs %x",
 				messageID[:8], chunk.Metadata.MessageID[:8])
 		}
 		if chunk.Metadata.TotalChunks != totalExpected {
-			return nil, fmt.Errorf("inconsistent total chunks: %d vs %d",
+			return nil, nil, fmt.Errorf("inconsistent total chunks: %d vs %d",
 				totalExpected, chunk.Metadata.TotalChunks)
 		}
 	}
 
+	// Use parity chunks (if any) to rebuild lost or corrupted data chunks
+	chunks, parity := splitParity(chunks)
+	if len(parity) > 0 {
+		recovered, err := c.recoverMissing(chunks, parity, totalExpected)
+		if err != nil {
+			return nil, nil, err
+		}
+		chunks = recovered
+	}
+
 	// Check for completeness
 	if len(chunks) != int(totalExpected) {
 		// Identify missing chunks for error report
 		missing := c.findMissingChunks(chunks, totalExpected)
-		return nil, fmt.Errorf("incomplete message: missing chunks %v", missing)
+		return nil, nil, fmt.Errorf("incomplete message: missing chunks %v", missing)
 	}
 
 	// Sort chunks by sequence number
@@ -359,14 +646,13 @@ func (c *Chunker) ReassembleMessage(chunks []Chunk) ([]byte, error) {
 
 	// Verify sequence integrity
 	for i, chunk := range chunks {
-		if chunk.Metadata.Sequence != uint16(i) {
-			return nil, fmt.Errorf("sequence error at position %d", i)
+		if chunk.Metadata.Sequence != uint32(i) {
+			return nil, nil, fmt.Errorf("sequence error at position %d", i)
 		}
 
 		// Verify checksum
-		calculatedChecksum := c.calculateChecksum(chunk.Payload)
-		if calculatedChecksum != chunk.Metadata.Checksum {
-			return nil, fmt.Errorf("checksum failed for chunk %d", i)
+		if err := c.verifyChecksum(&chunk); err != nil {
+			return nil, nil, fmt.Errorf("checksum failed for chunk %d: %w", i, err)
 		}
 	}
 
@@ -376,76 +662,95 @@ func (c *Chunker) ReassembleMessage(chunks []Chunk) ([]byte, error) {
 		reassembled = append(reassembled, chunk.Payload...)
 	}
 
-	fmt.Printf("   ✅ Successfully reassembled %d bytes\n", len(reassembled))
-
-	return reassembled, nil
-}
-
-// DecodeChunk parses a DNS TXT record back into a Chunk
-func (c *Chunker) DecodeChunk(encoded string) (*Chunk, error) {
-	// Decode from hex or base32
-	var rawData []byte
-	var err error
-
-	switch c.config.Encoding {
-	case ENCODE_HEX:
-		rawData, err = hex.DecodeString(encoded)
-	case ENCODE_BASE32:
-		rawData, err = base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(encoded)
-	default:
-		// Try hex first, then base32
-		rawData, err = hex.DecodeString(encoded)
+	// Open the envelope before decompressing (the reverse of ChunkMessage)
+	if chunks[0].Metadata.Encrypted {
+		opened, err := c.openEnvelope(reassembled, messageID)
 		if err != nil {
-			rawData, err = base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(encoded)
+			return nil, nil, err
 		}
+		c.log(EVENT_DECRYPTED, map[string]interface{}{"before": len(reassembled), "after": len(opened)},
+			"   Decrypted envelope: %d -> %d bytes", len(reassembled), len(opened))
+		reassembled = opened
 	}
 
-	if err != nil {
-		return nil, fmt.Errorf("decode failed: %w", err)
+	// Undo pre-chunking compression
+	if compression := chunks[0].Metadata.Compression; compression != COMPRESS_NONE {
+		decompressed, err := decompressData(compression, reassembled)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s decompression failed: %w", compression, err)
+		}
+		c.log(EVENT_DECOMPRESSED, map[string]interface{}{"algorithm": compression.String(), "before": len(reassembled), "after": len(decompressed)},
+			"   Decompressed (%s): %d -> %d bytes", compression, len(reassembled), len(decompressed))
+		reassembled = decompressed
 	}
 
-	// Verify minimum size
-	if len(rawData) < METADATA_OVERHEAD {
-		return nil, fmt.Errorf("chunk too small: %d bytes", len(rawData))
+	// Strip the extended header and check the end-to-end digest
+	var info *ContentInfo
+	if chunks[0].Metadata.Extended {
+		parsed, data, err := parseExtendedHeader(reassembled)
+		if err != nil {
+			return nil, nil, err
+		}
+		if err := parsed.Verify(data); err != nil {
+			return nil, nil, err
+		}
+		c.log(EVENT_EXTENDED, map[string]interface{}{"filename": parsed.Filename, "content_type": parsed.ContentType},
+			"   Extended header: %s, SHA-256 verified", parsed)
+		info, reassembled = parsed, data
 	}
 
-	// Parse metadata
-	metadata := ChunkMetadata{}
-	offset := 0
+	c.log(EVENT_REASSEMBLED, map[string]interface{}{"bytes": len(reassembled)},
+		"   ✅ Successfully reassembled %d bytes", len(reassembled))
 
-	// Parse magic
-	metadata.Magic = binary.BigEndian.Uint32(rawData[offset : offset+4])
-	offset += 4
+	return reassembled, info, nil
+}
 
-	if metadata.Magic != CHUNK_MAGIC {
-		return nil, fmt.Errorf("invalid magic: %x", metadata.Magic)
+// DecodeChunk parses a DNS TXT record back into a Chunk
+func (c *Chunker) DecodeChunk(encoded string) (*Chunk, error) {
+	// Decode with the configured encoding, falling back to auto-detection
+	// so receivers can read chunks produced with a different setting
+	rawData, err := decodeBytes(c.config.Encoding, encoded)
+	if err != nil || len(rawData) < 4 || !isChunkMagic(binary.BigEndian.Uint32(rawData[:4])) {
+		rawData, _, err = detectEncoding(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("decode failed: %w", err)
+		}
 	}
 
-	// Parse message ID
-	copy(metadata.MessageID[:], rawData[offset:offset+16])
-	offset += 16
-
-	// Parse sequence
-	metadata.Sequence = binary.BigEndian.Uint16(rawData[offset : offset+2])
-	offset += 2
-
-	// Parse total chunks
-	metadata.TotalChunks = binary.BigEndian.Uint16(rawData[offset : offset+2])
-	offset += 2
+	// Parse the header in whichever registered wire format the magic names
+	metadata, offset, err := parseHeader(rawData)
+	if err != nil {
+		return nil, err
+	}
 
-	// Parse checksum
-	metadata.Checksum = binary.BigEndian.Uint32(rawData[offset : offset+4])
-	offset += 4
+	if c.config.StrictIntegrity && metadata.Integrity == INTEGRITY_LEGACY {
+		return nil, fmt.Errorf("chunk %d uses legacy checksum (strict integrity enabled)", metadata.Sequence)
+	}
 
-	// Extract payload
+	// Extract payload (and the tag trailer of authenticated chunks)
 	payload := rawData[offset:]
+	var tag []byte
+	if metadata.Integrity == INTEGRITY_HMAC_SHA256 {
+		if len(payload) < AUTH_TAG_SIZE {
+			return nil, fmt.Errorf("chunk %d too small for authentication tag", metadata.Sequence)
+		}
+		tag = payload[len(payload)-AUTH_TAG_SIZE:]
+		payload = payload[:len(payload)-AUTH_TAG_SIZE]
+	}
 	metadata.PayloadSize = uint16(len(payload))
 
-	return &Chunk{
+	chunk := &Chunk{
 		Metadata: metadata,
 		Payload:  payload,
+		Tag:      tag,
 		Encoded:  encoded,
-	}, nil
+	}
+
+	if err := c.verifyTag(chunk); err != nil {
+		return nil, err
+	}
+
+	return chunk, nil
 }
 
 // ================================================================================
@@ -454,23 +759,83 @@ func (c *Chunker) DecodeChunk(encoded string) (*Chunk, error) {
 
 // generateMessageID creates a unique identifier for a message
 func (c *Chunker) generateMessageID(data []byte) [16]byte {
+	var id [16]byte
+	if c.config.DeterministicID {
+		copy(id[:], ContentID(data, c.config.idKey()))
+		return id
+	}
+
 	// Use SHA256 hash truncated to 128 bits
 	hash := sha256.Sum256(append(data, []byte(fmt.Sprintf("%d", time.Now().UnixNano()))...))
-	var id [16]byte
 	copy(id[:], hash[:16])
 	return id
 }
 
+// ContentID returns the content-addressed ID of data: SHA-256, or
+// HMAC-SHA256 when a key is given so that observers can't confirm a
+// guess of the content by hashing it themselves
+func ContentID(data, key []byte) []byte {
+	if len(key) == 0 {
+		hash := sha256.Sum256(data)
+		return hash[:16]
+	}
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write(data)
+	return mac.Sum(nil)[:16]
+}
+
+// idKey returns the secret content-addressed IDs are keyed with, if any
+func (cfg ChunkerConfig) idKey() []byte {
+	switch {
+	case len(cfg.Key) > 0:
+		return cfg.Key
+	case cfg.Password != "":
+		return []byte(cfg.Password)
+	}
+	return cfg.AuthKey
+}
+
 // calculatePayloadSize determines bytes per chunk based on encoding
 func (c *Chunker) calculatePayloadSize() int {
+	return c.payloadSizeFor(c.wire)
+}
+
+// payloadSizeFor returns the payload per data chunk with a given header format
+func (c *Chunker) payloadSizeFor(format *WireFormat) int {
+	var size int
 	switch c.config.Encoding {
 	case ENCODE_HEX:
-		return PAYLOAD_PER_CHUNK_HEX
+		size = PAYLOAD_PER_CHUNK_HEX
 	case ENCODE_BASE32:
-		return PAYLOAD_PER_CHUNK_B32
+		size = PAYLOAD_PER_CHUNK_B32
+	case ENCODE_BASE64URL:
+		size = PAYLOAD_PER_CHUNK_B64
+	case ENCODE_BASE91:
+		size = PAYLOAD_PER_CHUNK_B91
 	default:
-		return PAYLOAD_PER_CHUNK_HEX
+		size = PAYLOAD_PER_CHUNK_HEX
+	}
+
+	// Other profiles size chunks from their own budget
+	if c.config.MaxChunkSize != SAFE_CHUNK_SIZE {
+		size = maxRawBytes(c.config.Encoding, c.config.MaxChunkSize) - METADATA_OVERHEAD
 	}
+
+	// Headers beyond v1 (flags byte, wider sequence) and the tag come
+	// out of the payload budget
+	size -= format.HeaderSize - METADATA_OVERHEAD
+	if c.authenticating() {
+		size -= AUTH_TAG_SIZE
+	}
+
+	// Parity chunks carry an FEC header in front of the shard,
+	// so data chunks shrink to keep both within DNS limits
+	if c.config.AddRedundancy {
+		size -= FEC_HEADER_SIZE
+	}
+
+	return size
 }
 
 // calculateTotalChunks determines how many chunks are needed
@@ -479,21 +844,105 @@ func (c *Chunker) calculateTotalChunks(dataSize, payloadSize int) int {
 	return int(math.Ceil(float64(dataSize) / float64(payloadSize)))
 }
 
-// calculateChecksum computes CRC32 for integrity verification
+// calculateChecksum computes the configured integrity value for new chunks
 func (c *Chunker) calculateChecksum(data []byte) uint32 {
-	// Simple checksum using sum of bytes
-	// In production, use CRC32 or better
-	var sum uint32
-	for _, b := range data {
-		sum += uint32(b)
-		sum = (sum << 1) | (sum >> 31) // Rotate left by 1
-	}
+	sum, _ := ComputeChecksum(c.integrity, data)
 	return sum
 }
 
+// verifyChecksum checks a chunk using the algorithm recorded in its header
+func (c *Chunker) verifyChecksum(chunk *Chunk) error {
+	if c.config.StrictIntegrity && chunk.Metadata.Integrity == INTEGRITY_LEGACY {
+		return errors.New("legacy checksum rejected by integrity policy")
+	}
+	if c.config.RequireAuth && chunk.Metadata.Integrity != INTEGRITY_HMAC_SHA256 {
+		return errors.New("unauthenticated chunk rejected by integrity policy")
+	}
+
+	calculated, err := ComputeChecksum(chunk.Metadata.Integrity, chunk.Payload)
+	if err != nil {
+		return err
+	}
+
+	if calculated != chunk.Metadata.Checksum {
+		return fmt.Errorf("%s mismatch: expected %08x, got %08x",
+			chunk.Metadata.Integrity, chunk.Metadata.Checksum, calculated)
+	}
+
+	return nil
+}
+
+// VerifyIntegrity checks a decoded chunk's checksum with the algorithm its
+// header names, and its tag when the chunker holds the key
+func (c *Chunker) VerifyIntegrity(chunk *Chunk) error {
+	if err := c.verifyChecksum(chunk); err != nil {
+		return err
+	}
+	return c.verifyTag(chunk)
+}
+
+// protocolVersion returns the wire version used for new chunks
+func (c *Chunker) protocolVersion() uint8 {
+	return c.wire.Version
+}
+
+// chunkMagic returns the magic for new data or parity chunks
+func (c *Chunker) chunkMagic(parity bool) uint32 {
+	if parity {
+		return c.wire.ParityMagic
+	}
+	return c.wire.Magic
+}
+
+// headerSize returns the encoded metadata size for new chunks
+func (c *Chunker) headerSize() int {
+	return c.wire.HeaderSize
+}
+
+// configuredWire returns the pinned wire format, or else the smallest one
+// holding total chunks
+func (c *Chunker) configuredWire(total int) *WireFormat {
+	if c.config.WireVersion != 0 {
+		return wireFormats[c.config.WireVersion]
+	}
+	return smallestWireFormat(total)
+}
+
+// planChunks picks the wire format for a stream of n bytes and sizes its
+// chunks. Widening the header shrinks the payload, so the chunk count is
+// worked out again whenever a wider format is chosen.
+func (c *Chunker) planChunks(n int) (payloadSize, total int, err error) {
+	c.wire = c.configuredWire(0)
+	for {
+		payloadSize = c.calculatePayloadSize()
+		if payloadSize < 1 {
+			return 0, 0, fmt.Errorf("sizing profile %s leaves no room for payload: chunk overhead fills all %d %s characters",
+				c.profile.Name, c.config.MaxChunkSize, c.config.Encoding)
+		}
+
+		// LESSON: Chunk Count Calculation
+		// We must carefully calculate to avoid off-by-one errors
+		total = c.calculateTotalChunks(n, payloadSize)
+		if uint64(total) <= c.wire.MaxChunks() {
+			return payloadSize, total, nil
+		}
+
+		wider := c.configuredWire(total)
+		if wider == c.wire {
+			return 0, 0, fmt.Errorf("message too large: requires %d chunks (max %d in wire format v%d)",
+				total, c.wire.MaxChunks(), c.wire.Version)
+		}
+		c.wire = wider
+	}
+}
+
 // calculateOverhead determines the efficiency loss from chunking
 func (c *Chunker) calculateOverhead(originalSize, totalChunks int) float64 {
-	totalOverhead := totalChunks * METADATA_OVERHEAD
+	perChunk := c.headerSize()
+	if c.authenticating() {
+		perChunk += AUTH_TAG_SIZE
+	}
+	totalOverhead := totalChunks * perChunk
 	return float64(totalOverhead) / float64(originalSize) * 100
 }
 
@@ -502,9 +951,17 @@ func (c *Chunker) generateRecordName(metadata ChunkMetadata) string {
 	// Format: chunk-{seq}-{msgid}.{prefix}
 	// Example: chunk-0-a3f2b1.data.covert.example.com
 
-	msgIDShort := hex.EncodeToString(metadata.MessageID[:4])
+	kind := "chunk"
+	if metadata.Parity {
+		kind = "parity"
+	}
+	return c.recordName(kind, int(metadata.Sequence), metadata.MessageID)
+}
 
-	name := fmt.Sprintf("chunk-%03d-%s", metadata.Sequence, msgIDShort)
+// recordName formats a record name from its parts
+func (c *Chunker) recordName(kind string, index int, messageID [16]byte) string {
+	msgIDShort := hex.EncodeToString(messageID[:4])
+	name := fmt.Sprintf("%s-%03d-%s", kind, index, msgIDShort)
 
 	if c.config.DNSNamePrefix != "" {
 		name = fmt.Sprintf("%s.%s", name, c.config.DNSNamePrefix)
@@ -514,14 +971,14 @@ func (c *Chunker) generateRecordName(metadata ChunkMetadata) string {
 }
 
 // findMissingChunks identifies which sequence numbers are missing
-func (c *Chunker) findMissingChunks(chunks []Chunk, total uint16) []uint16 {
-	present := make(map[uint16]bool)
+func (c *Chunker) findMissingChunks(chunks []Chunk, total uint32) []uint32 {
+	present := make(map[uint32]bool)
 	for _, chunk := range chunks {
 		present[chunk.Metadata.Sequence] = true
 	}
 
-	var missing []uint16
-	for i := uint16(0); i < total; i++ {
+	var missing []uint32
+	for i := uint32(0); i < total; i++ {
 		if !present[i] {
 			missing = append(missing, i)
 		}
@@ -538,15 +995,31 @@ func (c *Chunker) GetStats() ChunkingStats {
 // ValidateChunk performs comprehensive chunk validation
 func (c *Chunker) ValidateChunk(chunk *Chunk) error {
 	// Check magic number
-	if chunk.Metadata.Magic != CHUNK_MAGIC {
+	format, ok := WireFormatFor(chunk.Metadata.Magic)
+	if !ok {
 		return fmt.Errorf("invalid magic number: %x", chunk.Metadata.Magic)
 	}
 
 	// Verify checksum
-	calculated := c.calculateChecksum(chunk.Payload)
-	if calculated != chunk.Metadata.Checksum {
-		return fmt.Errorf("checksum mismatch: expected %x, got %x",
-			chunk.Metadata.Checksum, calculated)
+	if err := c.verifyChecksum(chunk); err != nil {
+		return fmt.Errorf("checksum mismatch: %w", err)
+	}
+
+	// Verify authentication tag
+	if err := c.verifyTag(chunk); err != nil {
+		return err
+	}
+
+	// Decoded chunks must have fit the carrier
+	if chunk.Encoded != "" {
+		if err := c.profile.Fits(chunk.Encoded); err != nil {
+			return err
+		}
+	}
+
+	// Parity chunks are bounded by their FEC header instead
+	if chunk.Metadata.Parity {
+		return c.validateParityChunk(chunk)
 	}
 
 	// Check sequence bounds
@@ -560,7 +1033,7 @@ func (c *Chunker) ValidateChunk(chunk *Chunk) error {
 		return errors.New("empty payload")
 	}
 
-	maxPayload := c.calculatePayloadSize()
+	maxPayload := c.payloadSizeFor(format)
 	if len(chunk.Payload) > maxPayload {
 		return fmt.Errorf("payload too large: %d > %d", len(chunk.Payload), maxPayload)
 	}
@@ -568,22 +1041,27 @@ func (c *Chunker) ValidateChunk(chunk *Chunk) error {
 	return nil
 }
 
-// ================================================================================
-// ADVANCED FEATURES (for future lessons)
-// ================================================================================
+// validateParityChunk checks a parity chunk against its FEC header
+func (c *Chunker) validateParityChunk(chunk *Chunk) error {
+	if isFountainChunk(*chunk) {
+		_, _, err := parseFountainHeader(*chunk)
+		return err
+	}
 
-// AddRedundancy implements Reed-Solomon error correction
-// This will be covered in advanced lessons
-func (c *Chunker) AddRedundancy(chunks []Chunk, redundancyFactor float64) []Chunk {
-	// TODO: Implement FEC (Forward Error Correction)
-	// This allows recovery even with missing chunks
-	fmt.Println("📚 FUTURE LESSON: Error correction codes for lossy channels")
-	return chunks
-}
+	header, shard, err := parseFECHeader(*chunk)
+	if err != nil {
+		return err
+	}
+
+	groups := (int(chunk.Metadata.TotalChunks) + int(header.GroupSize) - 1) / int(header.GroupSize)
+	if int(chunk.Metadata.Sequence) >= groups*int(header.ParityPerGroup) {
+		return fmt.Errorf("parity sequence %d out of bounds (%d groups x %d)",
+			chunk.Metadata.Sequence, groups, header.ParityPerGroup)
+	}
 
-// CompressBeforeChunking applies compression to reduce chunk count
-func (c *Chunker) CompressBeforeChunking(data []byte) []byte {
-	// TODO: Implement compression
-	fmt.Println("📚 FUTURE LESSON: Compression strategies for covert channels")
-	return data
+	if len(shard) == 0 {
+		return errors.New("empty parity shard")
+	}
+
+	return nil
 }
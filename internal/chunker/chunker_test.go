@@ -0,0 +1,99 @@
+package chunker
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+// fecSettings are the redundancy configurations tests and benchmarks cover
+var fecSettings = []struct {
+	name       string
+	scheme     string
+	redundancy float64
+}{
+	{"nofec", "", 0},
+	{"rs-25", FEC_REED_SOLOMON, 0.25},
+	{"fountain-50", FEC_FOUNTAIN, 0.5},
+}
+
+// incompressible returns n random bytes, reproducibly
+func incompressible(n int) []byte {
+	data := make([]byte, n)
+	rand.New(rand.NewSource(int64(n))).Read(data)
+	return data
+}
+
+// testConfig is a silent chunker config for an encoding and FEC setting
+func testConfig(encoding, scheme string, redundancy float64) ChunkerConfig {
+	return ChunkerConfig{
+		Encoding:      encoding,
+		AddRedundancy: redundancy > 0,
+		Redundancy:    redundancy,
+		FECScheme:     scheme,
+		Logger:        DiscardLogger,
+	}
+}
+
+// Planning and quotas size transfers with EstimateChunks, so it has to
+// agree with what ChunkMessage actually produces
+func TestEstimateChunksMatchesChunkMessage(t *testing.T) {
+	for _, encoding := range EncodingNames() {
+		for _, fec := range fecSettings {
+			for _, size := range []int{1, 100, 1000, 20000} {
+				for _, password := range []string{"", "estimate password"} {
+					name := fmt.Sprintf("%s/%s/%d/encrypted=%v", encoding, fec.name, size, password != "")
+					t.Run(name, func(t *testing.T) {
+						config := testConfig(encoding, fec.scheme, fec.redundancy)
+						config.Password = password
+
+						estimate, err := EstimateChunks(size, config)
+						if err != nil {
+							t.Fatalf("EstimateChunks: %v", err)
+						}
+						msg, err := NewChunker(config).ChunkMessage(incompressible(size))
+						if err != nil {
+							t.Fatalf("ChunkMessage: %v", err)
+						}
+
+						if estimate.TotalChunks != len(msg.Chunks) {
+							t.Errorf("estimated %d chunks, ChunkMessage made %d", estimate.TotalChunks, len(msg.Chunks))
+						}
+						encoded := 0
+						for _, chunk := range msg.Chunks {
+							encoded += len(chunk.Encoded)
+						}
+						// base91's output length depends on the data, so its
+						// estimate is the worst case; the others are exact
+						switch {
+						case encoding == ENCODE_BASE91 && encoded > estimate.EncodedBytes:
+							t.Errorf("estimated at most %d encoded bytes, ChunkMessage made %d", estimate.EncodedBytes, encoded)
+						case encoding != ENCODE_BASE91 && encoded != estimate.EncodedBytes:
+							t.Errorf("estimated %d encoded bytes, ChunkMessage made %d", estimate.EncodedBytes, encoded)
+						}
+					})
+				}
+			}
+		}
+	}
+}
+
+func BenchmarkChunkMessage(b *testing.B) {
+	data := incompressible(64 * 1024)
+
+	for _, encoding := range EncodingNames() {
+		for _, fec := range fecSettings {
+			b.Run(encoding+"/"+fec.name, func(b *testing.B) {
+				c := NewChunker(testConfig(encoding, fec.scheme, fec.redundancy))
+				b.SetBytes(int64(len(data)))
+				b.ReportAllocs()
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					if _, err := c.ChunkMessage(data); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
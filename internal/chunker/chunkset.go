@@ -0,0 +1,269 @@
+package chunker
+
+import (
+	"bufio"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// ================================================================================
+// THEORY LESSON: Chunk Set Archives
+// ================================================================================
+//
+// A chunked message used to live on disk as one zone-file snippet per chunk,
+// which is awkward to copy around and loses everything but the TXT data.
+// A chunk set archive keeps a whole message in one file, as JSON lines:
+//
+//   {"format":"simulacra-chunkset","version":1,"id":"...","manifest":"...",...}
+//   {"name":"c-0-<id>.data","data":"<encoded chunk>"}
+//   {"name":"c-1-<id>.data","data":"<encoded chunk>"}
+//   ...
+//
+// 1. The first line describes the message: ID, encoding, creation time,
+//    chunk count, metadata and the manifest TXT value
+// 2. Every further line is one chunk exactly as it is published
+//
+// Only the encoded chunks are stored. Headers, payloads and tags are parsed
+// back out of them on load, so an archive can't disagree with the records
+// it describes - and a chunk that was tampered with fails the same checks
+// it would fail coming off the wire. Line-oriented JSON also means an
+// archive can be appended to, grepped, or read with a streaming decoder.
+// ================================================================================
+
+const (
+	// CHUNKSET_FORMAT identifies a chunk set archive
+	CHUNKSET_FORMAT = "simulacra-chunkset"
+
+	// CHUNKSET_VERSION is the archive layout written by this build
+	CHUNKSET_VERSION = 1
+
+	// CHUNKSET_EXTENSION is the conventional archive file extension
+	CHUNKSET_EXTENSION = ".chunkset"
+
+	// MAX_CHUNKSET_LINE bounds one archive line (largest chunks are a few KB)
+	MAX_CHUNKSET_LINE = 1 << 20
+)
+
+// ChunkSet is a chunked message together with its manifest
+type ChunkSet struct {
+	Message  *Message
+	Manifest string // Manifest TXT value (empty when none was made)
+}
+
+// chunkSetHeader is the first line of an archive
+type chunkSetHeader struct {
+	Format   string            `json:"format"`
+	Version  int               `json:"version"`
+	ID       string            `json:"id"`
+	Encoding string            `json:"encoding"`
+	Created  time.Time         `json:"created"`
+	Chunks   int               `json:"chunks"`
+	Manifest string            `json:"manifest,omitempty"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+// chunkSetRecord is one chunk line of an archive
+type chunkSetRecord struct {
+	Name string `json:"name,omitempty"`
+	Data string `json:"data"`
+}
+
+// NewChunkSet pairs a chunked message with its manifest value
+func NewChunkSet(msg *Message, manifest string) *ChunkSet {
+	return &ChunkSet{Message: msg, Manifest: manifest}
+}
+
+// MessageID returns the short message ID used in record names
+func (s *ChunkSet) MessageID() string {
+	return fmt.Sprintf("%x", s.Message.ID[:8])
+}
+
+// Encoded returns the encoded chunks in archive order
+func (s *ChunkSet) Encoded() []string {
+	encoded := make([]string, len(s.Message.Chunks))
+	for i, chunk := range s.Message.Chunks {
+		encoded[i] = chunk.Encoded
+	}
+	return encoded
+}
+
+// Write serializes the chunk set as JSON lines
+func (s *ChunkSet) Write(w io.Writer) error {
+	if s.Message == nil {
+		return errors.New("chunk set has no message")
+	}
+
+	buffered := bufio.NewWriter(w)
+	encoder := json.NewEncoder(buffered)
+
+	header := chunkSetHeader{
+		Format:   CHUNKSET_FORMAT,
+		Version:  CHUNKSET_VERSION,
+		ID:       hex.EncodeToString(s.Message.ID[:]),
+		Encoding: s.Message.Encoding,
+		Created:  s.Message.CreatedAt,
+		Chunks:   len(s.Message.Chunks),
+		Manifest: s.Manifest,
+		Metadata: s.Message.Metadata,
+	}
+	if err := encoder.Encode(header); err != nil {
+		return fmt.Errorf("failed to write archive header: %w", err)
+	}
+
+	for _, chunk := range s.Message.Chunks {
+		if err := encoder.Encode(chunkSetRecord{Name: chunk.RecordName, Data: chunk.Encoded}); err != nil {
+			return fmt.Errorf("failed to write chunk %d: %w", chunk.Metadata.Sequence, err)
+		}
+	}
+
+	return buffered.Flush()
+}
+
+// Save writes the chunk set to path, replacing any existing file atomically
+func (s *ChunkSet) Save(path string) error {
+	file, err := os.CreateTemp(filepath.Dir(path), ".chunkset-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temp file: %w", err)
+	}
+	tempFile := file.Name()
+
+	if err := s.Write(file); err != nil {
+		file.Close()
+		os.Remove(tempFile)
+		return err
+	}
+
+	if err := file.Close(); err != nil {
+		os.Remove(tempFile)
+		return fmt.Errorf("failed to close temp file: %w", err)
+	}
+
+	if err := os.Rename(tempFile, path); err != nil {
+		os.Remove(tempFile)
+		return fmt.Errorf("failed to rename temp file: %w", err)
+	}
+
+	return nil
+}
+
+// ReadChunkSet parses an archive, decoding every chunk with chk (nil uses
+// default settings: any encoding, tags not verified)
+func ReadChunkSet(r io.Reader, chk *Chunker) (*ChunkSet, error) {
+	if chk == nil {
+		chk = NewChunker(ChunkerConfig{})
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), MAX_CHUNKSET_LINE)
+
+	if !scanner.Scan() {
+		if err := scanner.Err(); err != nil {
+			return nil, fmt.Errorf("failed to read archive header: %w", err)
+		}
+		return nil, errors.New("empty archive")
+	}
+
+	var header chunkSetHeader
+	if err := json.Unmarshal(scanner.Bytes(), &header); err != nil {
+		return nil, fmt.Errorf("invalid archive header: %w", err)
+	}
+	if header.Format != CHUNKSET_FORMAT {
+		return nil, fmt.Errorf("not a chunk set archive (format %q)", header.Format)
+	}
+	if header.Version > CHUNKSET_VERSION {
+		return nil, fmt.Errorf("archive version %d is newer than supported (%d)", header.Version, CHUNKSET_VERSION)
+	}
+
+	msg := &Message{
+		Encoding:  header.Encoding,
+		CreatedAt: header.Created,
+		Metadata:  header.Metadata,
+	}
+	id, err := hex.DecodeString(header.ID)
+	if err != nil || len(id) != len(msg.ID) {
+		return nil, fmt.Errorf("invalid archive message ID %q", header.ID)
+	}
+	copy(msg.ID[:], id)
+
+	for line := 2; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+
+		var record chunkSetRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return nil, fmt.Errorf("line %d: invalid chunk record: %w", line, err)
+		}
+
+		chunk, err := chk.DecodeChunk(record.Data)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		if chunk.Metadata.MessageID != msg.ID {
+			return nil, fmt.Errorf("line %d: chunk belongs to message %x", line, chunk.Metadata.MessageID[:8])
+		}
+		chunk.RecordName = record.Name
+
+		msg.Chunks = append(msg.Chunks, *chunk)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read archive: %w", err)
+	}
+
+	if len(msg.Chunks) != header.Chunks {
+		return nil, fmt.Errorf("archive holds %d chunks, header says %d", len(msg.Chunks), header.Chunks)
+	}
+
+	return &ChunkSet{Message: msg, Manifest: header.Manifest}, nil
+}
+
+// LoadChunkSet reads an archive file (see ReadChunkSet)
+func LoadChunkSet(path string, chk *Chunker) (*ChunkSet, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open archive: %w", err)
+	}
+	defer file.Close()
+
+	return ReadChunkSet(file, chk)
+}
+
+// CollectChunks decodes fetched chunks into a message, skipping empty
+// (missing) entries, so a partial retrieval can be archived too
+func (c *Chunker) CollectChunks(encoded []string) (*Message, error) {
+	msg := &Message{
+		Encoding:  c.config.Encoding,
+		CreatedAt: time.Now(),
+		Metadata:  make(map[string]string),
+	}
+
+	for _, data := range encoded {
+		if data == "" {
+			continue
+		}
+
+		chunk, err := c.DecodeChunk(data)
+		if err != nil {
+			return nil, err
+		}
+		if len(msg.Chunks) == 0 {
+			msg.ID = chunk.Metadata.MessageID
+		} else if chunk.Metadata.MessageID != msg.ID {
+			return nil, fmt.Errorf("mixed messages detected: %x vs %x",
+				msg.ID[:8], chunk.Metadata.MessageID[:8])
+		}
+
+		msg.Chunks = append(msg.Chunks, *chunk)
+	}
+
+	if len(msg.Chunks) == 0 {
+		return nil, errors.New("no chunks to collect")
+	}
+	return msg, nil
+}
@@ -0,0 +1,177 @@
+package chunker
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+	"io"
+	"strings"
+)
+
+// ================================================================================
+// THEORY LESSON: Compression Before Chunking
+// ================================================================================
+//
+// Every byte we save before chunking is a DNS query we don't have to make.
+// Compression must happen BEFORE fragmentation: chunks are too small for any
+// compressor to find repetition in.
+//
+//   ALGORITHM   RATIO      SPEED      NOTES
+//   gzip        good       fast       everywhere, stdlib
+//   zstd        better     fastest    great default for large payloads
+//   brotli      best       slow       wins on text, slowest to compress
+//
+// Already-compressed data (PNG, JPEG, encrypted payloads) usually GROWS when
+// compressed again, so we keep whichever version is smaller. The algorithm
+// actually used is recorded in every v2 chunk header (bits 4-5 of FLAGS), so
+// receivers decompress without any out-of-band agreement.
+// ================================================================================
+
+// CompressionAlgorithm identifies the pre-chunking compressor
+type CompressionAlgorithm uint8
+
+const (
+	COMPRESS_NONE   CompressionAlgorithm = 0
+	COMPRESS_GZIP   CompressionAlgorithm = 1
+	COMPRESS_ZSTD   CompressionAlgorithm = 2
+	COMPRESS_BROTLI CompressionAlgorithm = 3
+
+	// DEFAULT_COMPRESSION is used when Compression is on but no algorithm is set
+	DEFAULT_COMPRESSION = "gzip"
+
+	// MAX_DECOMPRESSED_SIZE guards against decompression bombs
+	MAX_DECOMPRESSED_SIZE = 256 << 20
+)
+
+var compressionNames = map[CompressionAlgorithm]string{
+	COMPRESS_NONE:   "none",
+	COMPRESS_GZIP:   "gzip",
+	COMPRESS_ZSTD:   "zstd",
+	COMPRESS_BROTLI: "brotli",
+}
+
+// ParseCompression maps a configuration name to an algorithm
+func ParseCompression(name string) (CompressionAlgorithm, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	for algo, algoName := range compressionNames {
+		if algoName == name {
+			return algo, nil
+		}
+	}
+	return COMPRESS_NONE, fmt.Errorf("unknown compression %q (supported: %s)",
+		name, strings.Join(CompressionNames(), ", "))
+}
+
+// CompressionNames lists supported algorithm names
+func CompressionNames() []string {
+	return []string{"none", "gzip", "zstd", "brotli"}
+}
+
+// String returns the algorithm's configuration name
+func (a CompressionAlgorithm) String() string {
+	if name, ok := compressionNames[a]; ok {
+		return name
+	}
+	return fmt.Sprintf("unknown(%d)", uint8(a))
+}
+
+// CompressBeforeChunking applies the configured compression to reduce chunk count.
+// It returns the data unchanged (and COMPRESS_NONE) if compression doesn't help.
+func (c *Chunker) CompressBeforeChunking(data []byte) ([]byte, CompressionAlgorithm) {
+	if c.compression == COMPRESS_NONE || len(data) == 0 {
+		return data, COMPRESS_NONE
+	}
+
+	compressed, err := compressData(c.compression, data)
+	if err != nil {
+		c.log(EVENT_WARNING, map[string]interface{}{"setting": "compression", "value": c.compression.String(), "fallback": COMPRESS_NONE.String()},
+			"⚠️  %s compression failed (%v), sending uncompressed", c.compression, err)
+		return data, COMPRESS_NONE
+	}
+
+	if len(compressed) >= len(data) {
+		c.log(EVENT_COMPRESSION, map[string]interface{}{"algorithm": c.compression.String(), "before": len(data), "after": len(compressed), "skipped": true},
+			"   Compression: %s would grow data (%d -> %d bytes), skipped",
+			c.compression, len(data), len(compressed))
+		return data, COMPRESS_NONE
+	}
+
+	saved := 100 - float64(len(compressed))/float64(len(data))*100
+	c.log(EVENT_COMPRESSION, map[string]interface{}{"algorithm": c.compression.String(), "before": len(data), "after": len(compressed), "skipped": false},
+		"   Compression: %s %d -> %d bytes (%.1f%% saved)",
+		c.compression, len(data), len(compressed), saved)
+
+	return compressed, c.compression
+}
+
+// compressData runs a compressor over data
+func compressData(algo CompressionAlgorithm, data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	var w io.WriteCloser
+	var err error
+
+	switch algo {
+	case COMPRESS_NONE:
+		return data, nil
+	case COMPRESS_GZIP:
+		w, err = gzip.NewWriterLevel(&buf, gzip.BestCompression)
+	case COMPRESS_ZSTD:
+		w, err = zstd.NewWriter(&buf, zstd.WithEncoderLevel(zstd.SpeedBestCompression))
+	case COMPRESS_BROTLI:
+		w = brotli.NewWriterLevel(&buf, brotli.BestCompression)
+	default:
+		return nil, fmt.Errorf("unsupported compression: %s", algo)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// decompressData reverses compressData, refusing output above MAX_DECOMPRESSED_SIZE
+func decompressData(algo CompressionAlgorithm, data []byte) ([]byte, error) {
+	var r io.Reader
+
+	switch algo {
+	case COMPRESS_NONE:
+		return data, nil
+	case COMPRESS_GZIP:
+		gz, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		r = gz
+	case COMPRESS_ZSTD:
+		zr, err := zstd.NewReader(bytes.NewReader(data), zstd.WithDecoderMaxMemory(MAX_DECOMPRESSED_SIZE))
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		r = zr
+	case COMPRESS_BROTLI:
+		r = brotli.NewReader(bytes.NewReader(data))
+	default:
+		return nil, fmt.Errorf("unsupported compression: %s", algo)
+	}
+
+	out, err := io.ReadAll(io.LimitReader(r, MAX_DECOMPRESSED_SIZE+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(out) > MAX_DECOMPRESSED_SIZE {
+		return nil, fmt.Errorf("decompressed size exceeds %d bytes", MAX_DECOMPRESSED_SIZE)
+	}
+
+	return out, nil
+}
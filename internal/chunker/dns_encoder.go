@@ -1,40 +1,333 @@
 package chunker
 
 import (
+	"crypto/sha256"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"regexp"
+	"strconv"
 	"strings"
 	"time"
 )
 
+// DEFAULT_NAME_TEMPLATE is the chunk naming convention receivers assume
+// when a manifest doesn't say otherwise
+const DEFAULT_NAME_TEMPLATE = "c-{seq}-{id}.data"
+
 // DNSEncoder handles DNS-specific encoding requirements
 type DNSEncoder struct {
 	domain     string
 	subdomain  string
-	timePrefix bool // Add timestamp to prevent caching
+	timePrefix bool      // Add timestamp to prevent caching
+	recordType string    // Carrier of chunk records (RECORD_TXT etc.)
+	ttl        TTLPolicy // TTLs of chunk and manifest records
+	format     string    // Manifest format (MANIFEST_FORMAT_*)
+	names      string    // Chunk naming template ("" = time-prefixed default)
+	nameKey    []byte    // Naming secret for {word} and {sub:...}
+	wildcard   bool      // Add decoy wildcards under chunk subdomains
+	logger     Logger
+}
+
+// DNSEncoderConfig holds optional DNS encoder settings
+type DNSEncoderConfig struct {
+	RecordType     string    // Chunk carrier: TXT (default), NULL, CNAME or AAAA
+	TTL            TTLPolicy // Record TTLs (zero value = DefaultTTLPolicy)
+	ManifestFormat string    // text (default) or structured
+	NameTemplate   string    // Chunk naming template, e.g. {word}.{sub:cdn|img} ("" = t<minutes>-c-{seq}-{id}.data)
+	NameKey        []byte    // Naming secret receivers need to find keyed names (nil = unkeyed)
+	Wildcard       bool      // Add a decoy wildcard record under each chunk subdomain
+	Logger         Logger    // nil = stdout
 }
 
-// NewDNSEncoder creates an encoder for DNS transport
+// NewDNSEncoder creates an encoder for DNS transport with TXT chunk records
 func NewDNSEncoder(domain string) *DNSEncoder {
 	return &DNSEncoder{
 		domain:     domain,
 		subdomain:  "data",
 		timePrefix: true,
+		recordType: RECORD_TXT,
+		ttl:        DefaultTTLPolicy(),
+		format:     MANIFEST_FORMAT_TEXT,
+		logger:     ConsoleLogger{},
+	}
+}
+
+// NewDNSEncoderWithConfig creates an encoder for DNS transport with the
+// given settings
+func NewDNSEncoderWithConfig(domain string, config DNSEncoderConfig) (*DNSEncoder, error) {
+	recordType, err := ParseRecordType(config.RecordType)
+	if err != nil {
+		return nil, err
+	}
+
+	format, err := ParseManifestFormat(config.ManifestFormat)
+	if err != nil {
+		return nil, err
+	}
+
+	if config.NameTemplate != "" {
+		if err := ValidateNameTemplate(config.NameTemplate); err != nil {
+			return nil, err
+		}
+	}
+
+	de := NewDNSEncoder(domain)
+	de.recordType = recordType
+	de.format = format
+	de.names = config.NameTemplate
+	de.nameKey = config.NameKey
+	de.wildcard = config.Wildcard
+	if config.TTL != (TTLPolicy{}) {
+		if err := de.SetTTLPolicy(config.TTL); err != nil {
+			return nil, err
+		}
 	}
+	de.SetLogger(config.Logger)
+	return de, nil
+}
+
+// SetTTLPolicy sets the TTLs of the records the encoder creates
+func (de *DNSEncoder) SetTTLPolicy(policy TTLPolicy) error {
+	if err := policy.Check(); err != nil {
+		return err
+	}
+	de.ttl = policy
+	return nil
+}
+
+// SetLogger replaces the encoder's logger (nil restores stdout output)
+func (de *DNSEncoder) SetLogger(logger Logger) {
+	de.logger = resolveLogger(logger)
 }
 
 // DNSManifest describes a complete message for DNS transport
 type DNSManifest struct {
-	MessageID   string    `json:"id"`
-	TotalChunks int       `json:"total"`
-	Timestamp   time.Time `json:"timestamp"`
-	Checksum    string    `json:"checksum"`
-	ChunkIDs    []string  `json:"chunks"`
-	Domain      string    `json:"domain"`
+	MessageID    string    `json:"id"`
+	TotalChunks  int       `json:"total"`
+	Timestamp    time.Time `json:"timestamp"`
+	Checksum     string    `json:"checksum"` // SHA-256 of the message data (see VerifyData)
+	ChunkIDs     []string  `json:"chunks"`
+	Domain       string    `json:"domain"`
+	NameTemplate string    `json:"names,omitempty"`   // e.g. "c-{seq}-{id}.data"
+	Sharded      bool      `json:"sharded,omitempty"` // Chunks split across TXT, AAAA and NULL records
+	RecordType   string    `json:"rr,omitempty"`      // Chunk carrier ("" = TXT)
+	TLVs         TLVs      `json:"tlvs,omitempty"`    // Metadata for servers and relays
+	ChunkSet     string    `json:"set,omitempty"`     // Digest of the chunk set (see SignManifest)
+	Signature    []byte    `json:"sig,omitempty"`     // Sender's Ed25519 signature
+	Format       string    `json:"format,omitempty"`  // MANIFEST_FORMAT_* ("" = text)
+	Encoding     string    `json:"enc,omitempty"`     // Chunk encoding (structured format only)
+	Compression  string    `json:"z,omitempty"`       // Compression applied before chunking (structured format only)
+	KeyedNames   bool      `json:"keyed,omitempty"`   // Chunk names need the naming secret (see SetNameKey)
+	Recipient    string    `json:"to,omitempty"`      // Client ID the message is addressed to ("" = any receiver)
+	NameKey      []byte    `json:"-"`                 // Naming secret, never published
+
+	signedBody string // Manifest text the signature covers, as received
+}
+
+// LESSON: Manifest-Driven Names
+// Receivers used to rebuild chunk names as c-<i>-<msgid>.data.<domain>, so
+// any other naming (cache-busting prefixes, rotated subdomains) made the
+// chunks unreachable. The manifest now says how chunks are named, either as
+// a template or, when names follow no pattern, as an explicit list:
+//
+//   TOTAL:CHECKSUM:TIMESTAMP; names=t29442-c-{seq}-{id}.data
+//   TOTAL:CHECKSUM:TIMESTAMP; chunks=a1.x,b2.y,c3.z
+//
+// Template names are relative to the manifest's domain unless they end in a
+// dot. Old receivers read only TOTAL, so the suffix is backward compatible.
+
+// ChunkName returns the record name of chunk i
+func (m *DNSManifest) ChunkName(i int) string {
+	if m.NameTemplate == "" && i < len(m.ChunkIDs) {
+		return m.ChunkIDs[i]
+	}
+
+	template := m.NameTemplate
+	if template == "" {
+		template = DEFAULT_NAME_TEMPLATE
+	}
+	return expandNameTemplate(template, i, m.MessageID, m.Domain, m.NameKey)
+}
+
+// DefaultNaming reports whether chunks follow c-<seq>-<id>.data, the only
+// layout range queries understand
+func (m *DNSManifest) DefaultNaming() bool {
+	return len(m.ChunkIDs) == 0 &&
+		(m.NameTemplate == "" || m.NameTemplate == DEFAULT_NAME_TEMPLATE)
+}
+
+// Value encodes the manifest as a TXT record value
+func (m *DNSManifest) Value() string {
+	// A received signature only covers the text it was received as
+	if m.Signed() && m.signedBody != "" {
+		return m.signedBody + MANIFEST_SIG_FIELD + strings.ToLower(base32NoPad.EncodeToString(m.Signature))
+	}
+	if m.Format == MANIFEST_FORMAT_STRUCTURED {
+		value := m.structuredValue()
+		if m.ChunkSet != "" {
+			value += "; set=" + m.ChunkSet
+		}
+		return value
+	}
+
+	checksum := m.Checksum
+	if checksum == "" {
+		checksum = "pending"
+	}
+	value := fmt.Sprintf("%d:%s:%d", m.TotalChunks, checksum, m.Timestamp.Unix())
+
+	switch {
+	case m.NameTemplate != "" && m.NameTemplate != DEFAULT_NAME_TEMPLATE:
+		value += "; names=" + m.NameTemplate
+	case m.NameTemplate == "" && len(m.ChunkIDs) > 0:
+		relative := make([]string, len(m.ChunkIDs))
+		for i, name := range m.ChunkIDs {
+			relative[i] = strings.TrimSuffix(name, "."+m.Domain)
+		}
+		value += "; chunks=" + strings.Join(relative, ",")
+	}
+	if m.KeyedNames {
+		value += "; keyed=1"
+	}
+	if m.Sharded {
+		value += "; shards=1"
+	}
+	if m.RecordType != "" && m.RecordType != RECORD_TXT {
+		value += "; rr=" + strings.ToLower(m.RecordType)
+	}
+	if m.Recipient != "" {
+		value += "; to=" + m.Recipient
+	}
+	if len(m.TLVs) > 0 {
+		value += "; tlv=" + EncodeTLVs(m.TLVs)
+	}
+	if m.ChunkSet != "" {
+		value += "; set=" + m.ChunkSet
+	}
+
+	return value
+}
+
+// ParseManifest decodes a manifest TXT value for a message served under domain
+func ParseManifest(value, msgID, domain string) (*DNSManifest, error) {
+	fields := strings.Split(value, ";")
+	manifest := &DNSManifest{
+		MessageID: msgID,
+		Domain:    strings.TrimSuffix(domain, "."),
+	}
+
+	head := strings.TrimSpace(fields[0])
+	if strings.HasPrefix(strings.ToLower(head), MANIFEST_V2_PREFIX) {
+		if err := parseStructured(strings.ToLower(head), manifest); err != nil {
+			return nil, err
+		}
+	} else if err := parseTextHead(head, manifest); err != nil {
+		return nil, err
+	}
+	total := manifest.TotalChunks
+
+	var err error
+
+	for i, field := range fields[1:] {
+		key, val, ok := strings.Cut(strings.TrimSpace(field), "=")
+		if !ok {
+			continue
+		}
+		switch strings.ToLower(key) {
+		case "names":
+			manifest.NameTemplate = val
+		case "chunks":
+			for _, name := range strings.Split(val, ",") {
+				manifest.ChunkIDs = append(manifest.ChunkIDs, qualifyName(name, manifest.Domain))
+			}
+			if len(manifest.ChunkIDs) != total {
+				return nil, fmt.Errorf("manifest lists %d chunk names for %d chunks",
+					len(manifest.ChunkIDs), total)
+			}
+		case "keyed":
+			manifest.KeyedNames = val == "1"
+		case "shards":
+			manifest.Sharded = val == "1"
+		case "rr":
+			if manifest.RecordType, err = ParseRecordType(val); err != nil {
+				return nil, fmt.Errorf("manifest %w", err)
+			}
+		case "to":
+			manifest.Recipient = val
+		case "tlv":
+			if manifest.TLVs, err = DecodeTLVs(val); err != nil {
+				return nil, fmt.Errorf("manifest %w", err)
+			}
+		case "set":
+			manifest.ChunkSet = strings.ToLower(val)
+		case "sig":
+			// Fields after the signature would be unsigned
+			if i != len(fields)-2 {
+				return nil, fmt.Errorf("manifest has fields after its signature")
+			}
+			if manifest.Signature, err = base32NoPad.DecodeString(strings.ToUpper(val)); err != nil {
+				return nil, fmt.Errorf("invalid manifest signature: %w", err)
+			}
+			manifest.signedBody = strings.Join(fields[:i+1], ";")
+		}
+	}
+
+	return manifest, nil
+}
+
+// parseTextHead decodes the TOTAL:CHECKSUM:TIMESTAMP start of a text manifest
+func parseTextHead(head string, m *DNSManifest) error {
+	parts := strings.Split(head, ":")
+	total, err := strconv.Atoi(parts[0])
+	if err != nil || total < 0 {
+		return fmt.Errorf("invalid manifest chunk count: %q", parts[0])
+	}
+
+	m.TotalChunks = total
+	if len(parts) > 1 {
+		m.Checksum = parts[1]
+	}
+	if len(parts) > 2 {
+		if ts, err := strconv.ParseInt(parts[2], 10, 64); err == nil {
+			m.Timestamp = time.Unix(ts, 0)
+		}
+	}
+	return nil
+}
+
+// ValidateNameTemplate checks that a template yields a unique name per chunk and message
+// ({word} stands in for both, see naming.go)
+func ValidateNameTemplate(template string) error {
+	if !strings.Contains(template, "{word}") &&
+		(!strings.Contains(template, "{seq}") || !strings.Contains(template, "{id}")) {
+		return fmt.Errorf("name template %q must contain {seq} and {id}, or {word}", template)
+	}
+	return validateKeyedTemplate(template)
+}
+
+// ExpandNameTemplate fills {seq} and {id} into a naming template, and
+// {word} and {sub:...} keyed by message ID alone
+func ExpandNameTemplate(template string, seq int, msgID, domain string) string {
+	return expandNameTemplate(template, seq, msgID, domain, nil)
+}
+
+// expandNameTemplate fills a naming template, keying {word} and {sub:...}
+// with key
+func expandNameTemplate(template string, seq int, msgID, domain string, key []byte) string {
+	name := strings.NewReplacer("{seq}", strconv.Itoa(seq), "{id}", msgID).Replace(template)
+	return qualifyName(expandKeyed(name, seq, msgID, key), domain)
+}
+
+// qualifyName appends the domain to relative names; a trailing dot marks absolute ones
+func qualifyName(name, domain string) string {
+	if strings.HasSuffix(name, ".") {
+		return strings.TrimSuffix(name, ".")
+	}
+	return name + "." + domain
 }
 
-// EncodeToDNS converts chunks into DNS TXT records
+// EncodeToDNS converts chunks into DNS records of the configured type
 func (de *DNSEncoder) EncodeToDNS(msg *Message) (*DNSManifest, []DNSRecord, error) {
 	// LESSON: DNS names have strict rules:
 	// - Max 63 chars per label
@@ -43,85 +336,136 @@ func (de *DNSEncoder) EncodeToDNS(msg *Message) (*DNSManifest, []DNSRecord, erro
 	// - Case insensitive
 
 	manifest := &DNSManifest{
-		MessageID:   de.sanitizeForDNS(hex.EncodeToString(msg.ID[:8])),
-		TotalChunks: len(msg.Chunks),
-		Timestamp:   msg.CreatedAt,
-		Domain:      de.domain,
-		ChunkIDs:    make([]string, 0, len(msg.Chunks)),
+		MessageID:    de.sanitizeForDNS(hex.EncodeToString(msg.ID[:8])),
+		TotalChunks:  len(msg.Chunks),
+		Timestamp:    msg.CreatedAt,
+		Domain:       de.domain,
+		ChunkIDs:     make([]string, 0, len(msg.Chunks)),
+		NameTemplate: de.nameTemplate(),
+		RecordType:   de.recordType,
+		Format:       de.format,
+	}
+	if len(de.nameKey) > 0 && KeyedTemplate(manifest.NameTemplate) {
+		manifest.KeyedNames = true
+		manifest.NameKey = de.nameKey
+	}
+	if de.format == MANIFEST_FORMAT_STRUCTURED {
+		manifest.Encoding = msg.Encoding
+		if len(msg.Chunks) > 0 && msg.Chunks[0].Metadata.Compression != COMPRESS_NONE {
+			manifest.Compression = msg.Chunks[0].Metadata.Compression.String()
+		}
 	}
 
-	records := make([]DNSRecord, 0, len(msg.Chunks)+1)
-
-	// Create manifest record
-	// LESSON: The manifest helps receivers know what to expect
-	manifestRecord := de.createManifestRecord(manifest)
-	records = append(records, manifestRecord)
+	// Slot 0 is reserved for the manifest, written once names are final
+	records := make([]DNSRecord, 1, len(msg.Chunks)+1)
 
-	// Process each chunk
+	// Process each chunk (an AAAA chunk is a set of records at one name)
 	for i, chunk := range msg.Chunks {
-		record, err := de.createChunkRecord(chunk, i, manifest.MessageID)
+		name := manifest.ChunkName(i)
+		chunkRecords, err := de.chunkRecords(chunk, name)
 		if err != nil {
 			return nil, nil, fmt.Errorf("chunk %d encoding failed: %w", i, err)
 		}
 
-		records = append(records, record)
-		manifest.ChunkIDs = append(manifest.ChunkIDs, record.Name)
+		records = append(records, chunkRecords...)
+		manifest.ChunkIDs = append(manifest.ChunkIDs, name)
 	}
 
 	// Calculate overall checksum
-	manifest.Checksum = de.calculateManifestChecksum(msg.Data)
+	manifest.Checksum = MessageDigest(msg.Data)
+
+	if de.wildcard && len(msg.Chunks) > 0 {
+		records = append(records, de.wildcardRecords(manifest.ChunkIDs, len(msg.Chunks[0].Encoded))...)
+	}
+
+	// Create manifest record
+	// LESSON: The manifest helps receivers know what to expect,
+	// including how the chunk records are named
+	records[0] = de.createManifestRecord(manifest)
 
 	return manifest, records, nil
 }
 
-// DNSRecord represents a DNS TXT record
+// DNSRecord represents a DNS record
 type DNSRecord struct {
-	Name  string // Full DNS name (e.g., chunk-0-abc123.data.example.com)
-	Type  string // Always "TXT" for our use
-	TTL   int    // Time to live in seconds
-	Value string // The encoded chunk data
+	Name    string   // Full DNS name (e.g., chunk-0-abc123.data.example.com)
+	Type    string   // RECORD_TXT, or the encoder's carrier for chunk records
+	TTL     int      // Time to live in seconds
+	Value   string   // The encoded chunk data (presentation format for other types)
+	Strings []string // Value split into TXT character-strings (nil = one string)
 }
 
-// createChunkRecord creates a DNS TXT record for a chunk
-func (de *DNSEncoder) createChunkRecord(chunk Chunk, index int, msgID string) (DNSRecord, error) {
+// TXTStrings returns the record's character-strings
+func (r DNSRecord) TXTStrings() []string {
+	if len(r.Strings) > 0 {
+		return r.Strings
+	}
+	return []string{r.Value}
+}
+
+// RData returns the record's data in zone file presentation format
+func (r DNSRecord) RData() string {
+	switch r.Type {
+	case RECORD_TXT, "":
+		quoted := make([]string, 0, len(r.TXTStrings()))
+		for _, part := range r.TXTStrings() {
+			quoted = append(quoted, `"`+part+`"`)
+		}
+		return strings.Join(quoted, " ")
+	case RECORD_CNAME:
+		return r.Value + "."
+	}
+	return r.Value
+}
+
+// nameTemplate returns the naming template for this encoder's chunk records
+func (de *DNSEncoder) nameTemplate() string {
+	if de.names != "" {
+		return de.names
+	}
+
 	// LESSON: DNS Label Format Strategy
 	// We encode metadata in the DNS name itself for quick filtering
 	// Format: c-{seq}-{msgid}.{subdomain}.{domain}
 	// Example: c-0-abc123.data.covert.com
+	template := fmt.Sprintf("c-{seq}-{id}.%s", de.subdomain)
 
-	// Create DNS-safe label
-	label := fmt.Sprintf("c-%d-%s", index, msgID)
-
-	// Add optional timestamp prefix to prevent caching
+	// Add optional timestamp prefix to prevent caching.
+	// Computed once per message so every chunk shares it.
 	if de.timePrefix {
 		// Use minutes since epoch for cache busting
 		minutes := time.Now().Unix() / 60
-		label = fmt.Sprintf("t%d-%s", minutes, label)
+		template = fmt.Sprintf("t%d-%s", minutes, template)
 	}
 
+	return template
+}
+
+// createChunkRecord creates a DNS TXT record for a chunk
+func (de *DNSEncoder) createChunkRecord(chunk Chunk, fullName string, ttl int) (DNSRecord, error) {
 	// Validate label length (63 char limit)
+	label, _, _ := strings.Cut(fullName, ".")
 	if len(label) > 63 {
-		// Truncate message ID if needed
-		maxMsgIDLen := 63 - len(fmt.Sprintf("c-%d-", index))
-		if de.timePrefix {
-			maxMsgIDLen -= 12 // Account for timestamp
-		}
-		msgID = msgID[:maxMsgIDLen]
-		label = fmt.Sprintf("c-%d-%s", index, msgID)
+		return DNSRecord{}, fmt.Errorf("label %q exceeds 63 characters", label)
 	}
 
-	// Build full DNS name
-	fullName := fmt.Sprintf("%s.%s.%s", label, de.subdomain, de.domain)
-
 	// LESSON: TXT Record Value Encoding
 	// Must handle special characters that DNS doesn't like
+	// Split first so each escaped string still holds at most 255 raw bytes
 	encodedValue := de.escapeTXTValue(chunk.Encoded)
+	var parts []string
+	if len(chunk.Encoded) > MAX_DNS_STRING_SIZE {
+		for _, part := range chunk.Strings() {
+			parts = append(parts, de.escapeTXTValue(part))
+		}
+	}
 
 	return DNSRecord{
-		Name:  fullName,
-		Type:  "TXT",
-		TTL:   300, // 5 minutes - balance between caching and freshness
-		Value: encodedValue,
+		Name:    fullName,
+		Type:    "TXT",
+		TTL:     ttl, // See TTLPolicy - caching versus freshness
+		Value:   encodedValue,
+		Strings: parts,
 	}, nil
 }
 
@@ -138,17 +482,27 @@ func (de *DNSEncoder) createManifestRecord(manifest *DNSManifest) DNSRecord {
 	fullName := fmt.Sprintf("%s.%s.%s", label, de.subdomain, de.domain)
 
 	// Encode manifest data
-	// Format: TOTAL:CHECKSUM:TIMESTAMP
-	value := fmt.Sprintf("%d:%s:%d",
-		manifest.TotalChunks,
-		"pending", // Checksum calculated after all chunks
-		manifest.Timestamp.Unix())
+	// Format: TOTAL:CHECKSUM:TIMESTAMP[; names=TEMPLATE] or v2:<base32 JSON>
+	value := manifest.Value()
 
 	return DNSRecord{
-		Name:  fullName,
+		Name:    fullName,
+		Type:    "TXT",
+		TTL:     de.ttl.ManifestTTL(),
+		Value:   value,
+		Strings: SplitTXT(value),
+	}
+}
+
+// CapabilitiesRecord creates the zone's protocol version record
+func (de *DNSEncoder) CapabilitiesRecord(caps Capabilities) DNSRecord {
+	// LESSON: One record per zone, not per message
+	// Receivers look this up once and configure their decoder from it
+	return DNSRecord{
+		Name:  CapabilitiesName(de.domain),
 		Type:  "TXT",
-		TTL:   300,
-		Value: value,
+		TTL:   3600, // Rarely changes, safe to cache
+		Value: caps.String(),
 	}
 }
 
@@ -227,21 +581,58 @@ func (de *DNSEncoder) ParseFromDNS(records []DNSRecord) ([]Chunk, *DNSManifest,
 	// LESSON: Parsing Strategy
 	// 1. Find manifest record first
 	// 2. Validate expected vs received chunks
-	// 3. Decode chunk data
+	// 3. Decode chunk data, from all records at its name (an AAAA set)
 
+	var names []string
+	byName := make(map[string][]DNSRecord)
 	for _, record := range records {
-		if strings.Contains(record.Name, ".m-") {
+		if _, seen := byName[record.Name]; !seen {
+			names = append(names, record.Name)
+		}
+		byName[record.Name] = append(byName[record.Name], record)
+	}
+
+	for _, name := range names {
+		label, _, _ := strings.Cut(name, ".")
+		if strings.HasPrefix(label, "m-") {
 			// This is a manifest record
-			manifest = de.parseManifestRecord(record)
-			continue
+			manifest = de.parseManifestRecord(byName[name][0])
 		}
+	}
+
+	// Chunks are the names the manifest gives. Without a manifest they are
+	// the c- labels; without the secret for keyed names, every other record
+	// (chunks say their own sequence numbers).
+	isChunk := func(label, name string) bool {
+		return strings.Contains(label, "c-")
+	}
+	switch {
+	case manifest == nil:
+	case manifest.SetNameKey(de.nameKey) != nil:
+		isChunk = func(label, name string) bool {
+			return !strings.HasPrefix(label, "m-") && label != "*" &&
+				!strings.EqualFold(name, CapabilitiesName(de.domain))
+		}
+	default:
+		chunkNames := make(map[string]bool, manifest.TotalChunks)
+		for i := 0; i < manifest.TotalChunks; i++ {
+			chunkNames[strings.ToLower(manifest.ChunkName(i))] = true
+		}
+		isChunk = func(label, name string) bool {
+			return chunkNames[strings.ToLower(name)]
+		}
+	}
 
-		if strings.Contains(record.Name, ".c-") {
+	for _, name := range names {
+		label, _, _ := strings.Cut(name, ".")
+
+		if isChunk(label, name) {
 			// This is a chunk record
-			chunk, err := de.parseChunkRecord(record)
+			chunk, err := de.parseChunkRecords(byName[name])
 			if err != nil {
 				// Log but continue - DNS might have garbage
-				fmt.Printf("Warning: failed to parse %s: %v\n", record.Name, err)
+				logEvent(de.logger, EVENT_WARNING, map[string]interface{}{"record": name},
+					"Warning: failed to parse %s: %v", name, err)
 				continue
 			}
 			chunks = append(chunks, *chunk)
@@ -249,15 +640,17 @@ func (de *DNSEncoder) ParseFromDNS(records []DNSRecord) ([]Chunk, *DNSManifest,
 	}
 
 	if manifest != nil && len(chunks) != manifest.TotalChunks {
-		fmt.Printf("Warning: expected %d chunks, got %d\n",
-			manifest.TotalChunks, len(chunks))
+		logEvent(de.logger, EVENT_WARNING, map[string]interface{}{"expected": manifest.TotalChunks, "received": len(chunks)},
+			"Warning: expected %d chunks, got %d", manifest.TotalChunks, len(chunks))
 	}
 
 	return chunks, manifest, nil
 }
 
-// parseChunkRecord extracts a chunk from a DNS record
-func (de *DNSEncoder) parseChunkRecord(record DNSRecord) (*Chunk, error) {
+// parseChunkRecords extracts a chunk from the DNS records at its name
+func (de *DNSEncoder) parseChunkRecords(records []DNSRecord) (*Chunk, error) {
+	record := records[0]
+
 	// Extract sequence number from name
 	// Format: c-{seq}-{msgid} or t{time}-c-{seq}-{msgid}
 
@@ -282,41 +675,32 @@ func (de *DNSEncoder) parseChunkRecord(record DNSRecord) (*Chunk, error) {
 		fmt.Sscanf(label, "c-%d-", &seq)
 	}
 
-	// Unescape TXT value
-	unescaped := de.unescapeTXTValue(record.Value)
+	// Recover the encoded value from whichever type carried it
+	encoded, err := de.CarrierValue(records)
+	if err != nil {
+		return nil, err
+	}
 
 	// Decode the chunk (auto-detect encoding)
 	chunker := NewChunker(ChunkerConfig{})
-	return chunker.DecodeChunk(unescaped)
+	return chunker.DecodeChunk(encoded)
 }
 
 // parseManifestRecord extracts manifest from DNS record
 func (de *DNSEncoder) parseManifestRecord(record DNSRecord) *DNSManifest {
 	// Parse manifest value
-	// Format: TOTAL:CHECKSUM:TIMESTAMP
-
-	parts := strings.Split(record.Value, ":")
-	if len(parts) < 3 {
-		return nil
-	}
-
-	var total int
-	var timestamp int64
-	fmt.Sscanf(parts[0], "%d", &total)
-	fmt.Sscanf(parts[2], "%d", &timestamp)
+	// Format: TOTAL:CHECKSUM:TIMESTAMP[; names=TEMPLATE] or v2:<base32 JSON>
 
 	// Extract message ID from name
 	nameParts := strings.Split(record.Name, ".")
 	label := nameParts[0]
 	msgID := strings.TrimPrefix(label, "m-")
 
-	return &DNSManifest{
-		MessageID:   msgID,
-		TotalChunks: total,
-		Checksum:    parts[1],
-		Timestamp:   time.Unix(timestamp, 0),
-		Domain:      de.domain,
+	manifest, err := ParseManifest(record.Value, msgID, de.domain)
+	if err != nil {
+		return nil
 	}
+	return manifest
 }
 
 // unescapeTXTValue reverses TXT record escaping
@@ -328,9 +712,51 @@ func (de *DNSEncoder) unescapeTXTValue(value string) string {
 	return result
 }
 
-// calculateManifestChecksum creates a checksum for the entire message
-func (de *DNSEncoder) calculateManifestChecksum(data []byte) string {
-	// Simple checksum for verification
+// LESSON: Checking the Whole, Not Just the Parts
+// Chunk checksums prove each chunk arrived as sent, not that the right
+// chunks were put together: a stale chunk from a resent message, or a
+// reassembly bug, yields a file with every chunk valid. The manifest's
+// checksum field therefore carries the SHA-256 of the complete message
+// (64 hex digits), checked after reassembly. Older manifests carry an
+// 8-digit rolling sum (still verified), or a placeholder like "pending"
+// that can't be verified at all.
+
+// ErrNoDigest means a manifest carries no checksum to verify data against
+var ErrNoDigest = errors.New("manifest carries no message digest")
+
+// MessageDigest returns the manifest checksum of a message's data
+func MessageDigest(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// VerifyData checks reassembled data against the manifest's checksum,
+// returning ErrNoDigest when there is none to check
+func (m *DNSManifest) VerifyData(data []byte) error {
+	var expected string
+	switch checksum := strings.ToLower(m.Checksum); {
+	case len(checksum) == sha256.Size*2 && isHex(checksum):
+		expected = MessageDigest(data)
+	case len(checksum) == 8 && isHex(checksum):
+		expected = legacyMessageChecksum(data)
+	default:
+		return ErrNoDigest
+	}
+
+	if !strings.EqualFold(expected, m.Checksum) {
+		return fmt.Errorf("message digest mismatch: manifest says %s, data is %s", m.Checksum, expected)
+	}
+	return nil
+}
+
+// isHex reports whether s is all hex digits
+func isHex(s string) bool {
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
+
+// legacyMessageChecksum is the rolling sum older encoders put in manifests
+func legacyMessageChecksum(data []byte) string {
 	var sum uint32
 	for _, b := range data {
 		sum += uint32(b)
@@ -353,9 +779,9 @@ func (de *DNSEncoder) GenerateZoneFile(records []DNSRecord) string {
 	zone.WriteString(fmt.Sprintf("; Records: %d\n\n", len(records)))
 
 	for _, record := range records {
-		// Format: name TTL IN TXT "value"
-		zone.WriteString(fmt.Sprintf("%s. %d IN %s \"%s\"\n",
-			record.Name, record.TTL, record.Type, record.Value))
+		// Format: name TTL IN TYPE rdata (TXT: "value" ["value" ...])
+		zone.WriteString(fmt.Sprintf("%s. %d IN %s %s\n",
+			record.Name, record.TTL, record.Type, record.RData()))
 	}
 
 	return zone.String()
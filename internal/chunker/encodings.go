@@ -0,0 +1,231 @@
+package chunker
+
+import (
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// ================================================================================
+// THEORY LESSON: Text Encodings for TXT Records
+// ================================================================================
+//
+// TXT strings are 8-bit clean on the wire, but zone files, resolvers and
+// logging tools are not, so we encode binary chunks as text. The denser the
+// alphabet, the more payload fits in one 255-byte string:
+//
+//   ENCODING    CHARS PER BYTE   ALPHABET
+//   hex         2.00             0-9 a-f
+//   base32      1.60             A-Z 2-7
+//   base64url   1.33             A-Z a-z 0-9 - _
+//   base91      ~1.23            91 printable ASCII characters
+//
+// Base91 normally uses '"' which needs escaping in zone files, so we swap it
+// for '-' (unused by the standard alphabet). No encoding here produces a
+// backslash, a space or a double quote.
+// ================================================================================
+
+// chunkEncoding describes how chunk bytes become TXT-safe text
+type chunkEncoding struct {
+	encode     func(data []byte) string
+	decode     func(text string) ([]byte, error)
+	encodedLen func(n int) int // Worst-case encoded length of n raw bytes
+}
+
+var (
+	base32NoPad = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+	chunkEncodings = map[string]chunkEncoding{
+		ENCODE_HEX: {
+			encode:     hex.EncodeToString,
+			decode:     hex.DecodeString,
+			encodedLen: hex.EncodedLen,
+		},
+		ENCODE_BASE32: {
+			encode:     base32NoPad.EncodeToString,
+			decode:     base32NoPad.DecodeString,
+			encodedLen: base32NoPad.EncodedLen,
+		},
+		ENCODE_BASE64URL: {
+			encode:     base64.RawURLEncoding.EncodeToString,
+			decode:     base64.RawURLEncoding.DecodeString,
+			encodedLen: base64.RawURLEncoding.EncodedLen,
+		},
+		ENCODE_BASE91: {
+			encode:     base91Encode,
+			decode:     base91Decode,
+			encodedLen: base91EncodedLen,
+		},
+	}
+
+	// detectOrder lists encodings from the most to the least restrictive
+	// alphabet, so auto-detection tries the unambiguous ones first
+	detectOrder = []string{ENCODE_HEX, ENCODE_BASE32, ENCODE_BASE64URL, ENCODE_BASE91}
+)
+
+// EncodingNames lists the supported chunk encodings
+func EncodingNames() []string {
+	names := make([]string, len(detectOrder))
+	copy(names, detectOrder)
+	return names
+}
+
+// IsEncodingSupported reports whether a chunk encoding is known
+func IsEncodingSupported(name string) bool {
+	_, ok := chunkEncodings[name]
+	return ok
+}
+
+// maxRawBytes returns how many raw bytes encode to at most limit characters
+func maxRawBytes(encoding string, limit int) int {
+	enc, ok := chunkEncodings[encoding]
+	if !ok {
+		enc = chunkEncodings[ENCODE_HEX]
+	}
+
+	n := 0
+	for enc.encodedLen(n+1) <= limit {
+		n++
+	}
+	return n
+}
+
+// encodeBytes encodes data with the named encoding
+func encodeBytes(encoding string, data []byte) string {
+	enc, ok := chunkEncodings[encoding]
+	if !ok {
+		enc = chunkEncodings[ENCODE_HEX]
+	}
+	return enc.encode(data)
+}
+
+// decodeBytes decodes text with the named encoding
+func decodeBytes(encoding, text string) ([]byte, error) {
+	enc, ok := chunkEncodings[encoding]
+	if !ok {
+		return nil, fmt.Errorf("unknown encoding: %s", encoding)
+	}
+	return enc.decode(text)
+}
+
+// detectEncoding decodes a chunk whose encoding is unknown.
+// A candidate is accepted only if the result starts with a chunk magic.
+func detectEncoding(text string) ([]byte, string, error) {
+	for _, name := range detectOrder {
+		raw, err := chunkEncodings[name].decode(text)
+		if err != nil || len(raw) < 4 {
+			continue
+		}
+		if isChunkMagic(binary.BigEndian.Uint32(raw[:4])) {
+			return raw, name, nil
+		}
+	}
+	return nil, "", fmt.Errorf("no known encoding yields a valid chunk (tried %s)",
+		strings.Join(detectOrder, ", "))
+}
+
+// ================================================================================
+// BASE91 (DNS-safe variant)
+// ================================================================================
+
+// LESSON: How basE91 Works
+// Bits are consumed 13 at a time; if the 13-bit value is small enough
+// (<= 88) we take a 14th bit as well. Each value becomes two base-91 digits,
+// so two characters carry 13 or 14 bits instead of base64's 12.
+
+const base91Alphabet = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz" +
+	"0123456789!#$%&()*+,./:;<=>?@[]^_`{|}~-"
+
+var base91Decoding = func() [256]int {
+	var table [256]int
+	for i := range table {
+		table[i] = -1
+	}
+	for i := 0; i < len(base91Alphabet); i++ {
+		table[base91Alphabet[i]] = i
+	}
+	return table
+}()
+
+// base91Encode encodes data with the DNS-safe base91 alphabet
+func base91Encode(data []byte) string {
+	var out strings.Builder
+	out.Grow(base91EncodedLen(len(data)))
+
+	var queue, bits uint
+	for _, b := range data {
+		queue |= uint(b) << bits
+		bits += 8
+
+		if bits > 13 {
+			value := queue & 8191
+			if value > 88 {
+				queue >>= 13
+				bits -= 13
+			} else {
+				value = queue & 16383
+				queue >>= 14
+				bits -= 14
+			}
+			out.WriteByte(base91Alphabet[value%91])
+			out.WriteByte(base91Alphabet[value/91])
+		}
+	}
+
+	if bits > 0 {
+		out.WriteByte(base91Alphabet[queue%91])
+		if bits > 7 || queue > 90 {
+			out.WriteByte(base91Alphabet[queue/91])
+		}
+	}
+
+	return out.String()
+}
+
+// base91Decode reverses base91Encode
+func base91Decode(text string) ([]byte, error) {
+	out := make([]byte, 0, len(text)*14/16+1)
+
+	var queue, bits uint
+	value := -1
+	for i := 0; i < len(text); i++ {
+		digit := base91Decoding[text[i]]
+		if digit < 0 {
+			return nil, fmt.Errorf("illegal base91 character %q at offset %d", text[i], i)
+		}
+
+		if value < 0 {
+			value = digit
+			continue
+		}
+
+		value += digit * 91
+		queue |= uint(value) << bits
+		if value&8191 > 88 {
+			bits += 13
+		} else {
+			bits += 14
+		}
+
+		for bits > 7 {
+			out = append(out, byte(queue))
+			queue >>= 8
+			bits -= 8
+		}
+		value = -1
+	}
+
+	if value >= 0 {
+		out = append(out, byte(queue|uint(value)<<bits))
+	}
+
+	return out, nil
+}
+
+// base91EncodedLen is the worst case (13 bits per character pair)
+func base91EncodedLen(n int) int {
+	return 2 * ((n*8 + 12) / 13)
+}
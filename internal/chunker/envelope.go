@@ -0,0 +1,129 @@
+package chunker
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"github.com/faanross/simulacra_txt/internal/spec"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// ================================================================================
+// THEORY LESSON: Message Envelopes
+// ================================================================================
+//
+// TXT records are public: anyone who guesses a record name can read it. Stego
+// images carry their own encryption, but raw files chunked directly travel in
+// cleartext. The envelope seals the whole message BEFORE fragmentation:
+//
+//   data -> compress -> seal -> chunk
+//
+// Envelope layout (the start of the message stream, so it lands in chunk 0):
+//
+//   [KDF(1)][SALT(32)][NONCE(12)][CIPHERTEXT + GCM TAG(16)]
+//
+// KDF 1 derives the key from a password with PBKDF2 (salt is per message);
+// KDF 0 uses a raw 32-byte key and ignores the salt. The message ID is bound
+// in as additional authenticated data, so an envelope can't be replayed under
+// another message's chunks. Every chunk sets FLAG_ENCRYPTED, so a receiver
+// knows it needs a key before fetching anything else.
+// ================================================================================
+
+const (
+	ENVELOPE_KDF_RAW    = 0 // Key supplied directly
+	ENVELOPE_KDF_PBKDF2 = 1 // Key derived from a password
+
+	// ENVELOPE_HEADER_SIZE is the KDF byte, salt and nonce in front of the ciphertext
+	ENVELOPE_HEADER_SIZE = 1 + spec.SALT_SIZE + spec.NONCE_SIZE
+
+	// ENVELOPE_OVERHEAD is the total growth of a sealed message
+	ENVELOPE_OVERHEAD = ENVELOPE_HEADER_SIZE + spec.TAG_SIZE
+)
+
+// ErrEnvelopeAuth is returned when an envelope can't be opened.
+// A wrong password and tampered chunks are deliberately indistinguishable.
+var ErrEnvelopeAuth = errors.New("envelope authentication failed: wrong password/key or corrupted data")
+
+// encrypting reports whether the configuration enables envelope encryption
+func (cfg ChunkerConfig) encrypting() bool {
+	return cfg.Password != "" || len(cfg.Key) > 0
+}
+
+// sealEnvelope encrypts a whole message, returning the envelope bytes
+func (c *Chunker) sealEnvelope(data []byte, messageID [16]byte) ([]byte, error) {
+	header := make([]byte, ENVELOPE_HEADER_SIZE)
+	if _, err := rand.Read(header[1:]); err != nil {
+		return nil, fmt.Errorf("failed to generate salt/nonce: %w", err)
+	}
+
+	kdf := byte(ENVELOPE_KDF_RAW)
+	if len(c.config.Key) == 0 {
+		kdf = ENVELOPE_KDF_PBKDF2
+	}
+	header[0] = kdf
+
+	salt := header[1 : 1+spec.SALT_SIZE]
+	nonce := header[1+spec.SALT_SIZE:]
+
+	gcm, err := c.envelopeCipher(kdf, salt)
+	if err != nil {
+		return nil, err
+	}
+
+	return gcm.Seal(header, nonce, data, messageID[:]), nil
+}
+
+// openEnvelope reverses sealEnvelope
+func (c *Chunker) openEnvelope(envelope []byte, messageID [16]byte) ([]byte, error) {
+	if !c.config.encrypting() {
+		return nil, errors.New("message is encrypted: password or key required")
+	}
+	if len(envelope) < ENVELOPE_OVERHEAD {
+		return nil, fmt.Errorf("envelope too small: %d bytes", len(envelope))
+	}
+
+	kdf := envelope[0]
+	salt := envelope[1 : 1+spec.SALT_SIZE]
+	nonce := envelope[1+spec.SALT_SIZE : ENVELOPE_HEADER_SIZE]
+
+	gcm, err := c.envelopeCipher(kdf, salt)
+	if err != nil {
+		return nil, err
+	}
+
+	plaintext, err := gcm.Open(nil, nonce, envelope[ENVELOPE_HEADER_SIZE:], messageID[:])
+	if err != nil {
+		return nil, ErrEnvelopeAuth
+	}
+
+	return plaintext, nil
+}
+
+// envelopeCipher builds the AES-256-GCM cipher for an envelope
+func (c *Chunker) envelopeCipher(kdf byte, salt []byte) (cipher.AEAD, error) {
+	var key []byte
+
+	switch kdf {
+	case ENVELOPE_KDF_RAW:
+		if len(c.config.Key) != spec.KEY_SIZE {
+			return nil, fmt.Errorf("envelope needs a %d-byte key, have %d bytes", spec.KEY_SIZE, len(c.config.Key))
+		}
+		key = c.config.Key
+	case ENVELOPE_KDF_PBKDF2:
+		if c.config.Password == "" {
+			return nil, errors.New("envelope was sealed with a password, none configured")
+		}
+		key = pbkdf2.Key([]byte(c.config.Password), salt, spec.PBKDF2_ITERS, spec.KEY_SIZE, sha256.New)
+	default:
+		return nil, fmt.Errorf("unknown envelope KDF: %d", kdf)
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,117 @@
+package chunker
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+// ================================================================================
+// SIZE ESTIMATION
+// Plans a transfer from its size alone, without chunking anything
+// ================================================================================
+
+// LESSON: Estimating Without the Data
+// Chunk counts follow from arithmetic the chunker already does: the profile
+// and encoding fix the payload per chunk, encryption adds a fixed envelope,
+// and FEC adds a known number of parity chunks. The one thing that needs the
+// data is compression, so estimates assume incompressible input - an upper
+// bound, which is what quotas and schedules want. Records are fetched one
+// per query, so the transfer time at a given QPS is records / QPS.
+
+// ChunkEstimate describes the transfer a message of a given size would need
+type ChunkEstimate struct {
+	DataSize     int     // Input bytes
+	StreamSize   int     // Bytes after the envelope (before chunking)
+	PayloadSize  int     // Payload bytes per data chunk
+	DataChunks   int     // Chunks carrying message data
+	ParityChunks int     // Reed-Solomon parity or fountain-coded chunks
+	TotalChunks  int     // Data + parity chunks
+	Records      int     // DNS records to publish (chunks + manifest)
+	EncodedBytes int     // Characters of encoded chunk text across all chunks (worst case for base91)
+	Expansion    float64 // EncodedBytes / DataSize
+	WireVersion  uint8   // Chunk header format the message needs
+}
+
+// EstimateChunks plans the chunks ChunkMessage would create for dataSize
+// bytes under config, assuming the data doesn't compress. ChunkFile adds
+// an extended header of EXTENDED_HEADER_MIN_SIZE bytes plus the filename,
+// content type and TLVs.
+func EstimateChunks(dataSize int, config ChunkerConfig) (*ChunkEstimate, error) {
+	if dataSize < 0 {
+		return nil, errors.New("data size cannot be negative")
+	}
+
+	c := NewChunker(config)
+
+	estimate := &ChunkEstimate{
+		DataSize:   dataSize,
+		StreamSize: dataSize,
+	}
+	if c.config.encrypting() {
+		estimate.StreamSize += ENVELOPE_OVERHEAD
+	}
+
+	// Picks the wire format too, so the header size below matches
+	payloadSize, dataChunks, err := c.planChunks(estimate.StreamSize)
+	if err != nil {
+		return nil, err
+	}
+	estimate.PayloadSize = payloadSize
+	estimate.DataChunks = dataChunks
+	estimate.WireVersion = c.wire.Version
+
+	if c.config.AddRedundancy && estimate.DataChunks > 0 {
+		if c.config.FECScheme == FEC_FOUNTAIN {
+			estimate.ParityChunks = int(math.Ceil(float64(estimate.DataChunks) * c.config.Redundancy))
+		} else {
+			groupSize, parityPerGroup := fecGroups(estimate.DataChunks, c.config.Redundancy)
+			groups := (estimate.DataChunks + groupSize - 1) / groupSize
+			estimate.ParityChunks = groups * parityPerGroup
+		}
+	}
+	estimate.TotalChunks = estimate.DataChunks + estimate.ParityChunks
+	estimate.Records = estimate.TotalChunks + 1
+
+	// Encoded size of header + payload + tag for each chunk
+	perChunk := c.headerSize()
+	if c.authenticating() {
+		perChunk += AUTH_TAG_SIZE
+	}
+	encodedLen := chunkEncodings[c.config.Encoding].encodedLen
+
+	if estimate.DataChunks > 0 {
+		full := estimate.DataChunks - 1
+		last := estimate.StreamSize - full*estimate.PayloadSize
+		estimate.EncodedBytes = full*encodedLen(perChunk+estimate.PayloadSize) + encodedLen(perChunk+last)
+	}
+	estimate.EncodedBytes += estimate.ParityChunks * encodedLen(perChunk+FEC_HEADER_SIZE+estimate.PayloadSize)
+
+	if dataSize > 0 {
+		estimate.Expansion = float64(estimate.EncodedBytes) / float64(dataSize)
+	}
+
+	return estimate, nil
+}
+
+// TransferTime returns how long fetching every record takes at qps queries per second
+func (e *ChunkEstimate) TransferTime(qps float64) time.Duration {
+	if qps <= 0 {
+		return 0
+	}
+	return time.Duration(float64(e.Records) / qps * float64(time.Second))
+}
+
+// String summarizes the estimate for display
+func (e *ChunkEstimate) String() string {
+	var out strings.Builder
+	fmt.Fprintf(&out, "   Data: %d bytes (%d after envelope)\n", e.DataSize, e.StreamSize)
+	fmt.Fprintf(&out, "   Chunks: %d data + %d parity = %d (%d bytes payload each)\n",
+		e.DataChunks, e.ParityChunks, e.TotalChunks, e.PayloadSize)
+	fmt.Fprintf(&out, "   Records: %d (with manifest)\n", e.Records)
+	fmt.Fprintf(&out, "   Wire format: v%d\n", e.WireVersion)
+	fmt.Fprintf(&out, "   Encoded: %d bytes (%.2fx expansion)", e.EncodedBytes, e.Expansion)
+	return out.String()
+}
@@ -0,0 +1,203 @@
+package chunker
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strings"
+)
+
+// ================================================================================
+// THEORY LESSON: Extended Headers
+// ================================================================================
+//
+// A chunk header says where its payload belongs, never what the message is.
+// Receivers ended up saving everything as received_<id>.png and trusting
+// per-chunk checksums alone. The extended header describes the message
+// itself and rides at the start of the message stream, so it lands in
+// chunk 0 (like the envelope header) instead of costing every chunk:
+//
+//   [VERSION(1)][LENGTH(2)][SHA-256(32)][TYPE LEN(1)][TYPE][NAME LEN(1)][NAME][TLVs]
+//
+// LENGTH counts the bytes after it, so later versions can append fields
+// that older receivers skip - which is how the TLV section (tlv.go) was
+// added. The header is added BEFORE compression and
+// encryption, so an envelope hides the filename too:
+//
+//   header + data -> compress -> seal -> chunk
+//
+// Every chunk sets FLAG_EXTENDED (protocol v2 only), and the SHA-256 of the
+// original data is checked after reassembly: per-chunk checksums can't see
+// a chunk from another transfer of the same message ID, or a decompressor
+// bug - the end-to-end digest can.
+// ================================================================================
+
+const (
+	// EXTENDED_HEADER_VERSION is the layout written by this build
+	EXTENDED_HEADER_VERSION = 1
+
+	// EXTENDED_HEADER_MIN_SIZE is an extended header with empty type and name
+	EXTENDED_HEADER_MIN_SIZE = 1 + 2 + sha256.Size + 1 + 1
+
+	// MAX_EXTENDED_FIELD is the longest content type or filename
+	MAX_EXTENDED_FIELD = 255
+)
+
+// ErrDigestMismatch means the reassembled data doesn't match the sender's SHA-256
+var ErrDigestMismatch = errors.New("end-to-end SHA-256 mismatch")
+
+// ContentInfo describes the message an extended header travels with
+type ContentInfo struct {
+	ContentType string   // MIME type, e.g. image/png
+	Filename    string   // Original base name (no directories)
+	SHA256      [32]byte // Digest of the original, uncompressed data
+	TLVs        TLVs     // Metadata for higher layers (may be empty)
+}
+
+// NewContentInfo describes data, detecting the content type when it isn't given
+func NewContentInfo(data []byte, filename, contentType string) (*ContentInfo, error) {
+	if filename != "" {
+		filename = filepath.Base(filename)
+	}
+	if contentType == "" {
+		contentType = DetectContentType(filename, data)
+	}
+
+	if len(filename) > MAX_EXTENDED_FIELD {
+		return nil, fmt.Errorf("filename is %d bytes, extended header allows %d", len(filename), MAX_EXTENDED_FIELD)
+	}
+	if len(contentType) > MAX_EXTENDED_FIELD {
+		return nil, fmt.Errorf("content type is %d bytes, extended header allows %d", len(contentType), MAX_EXTENDED_FIELD)
+	}
+
+	return &ContentInfo{
+		ContentType: contentType,
+		Filename:    filename,
+		SHA256:      sha256.Sum256(data),
+	}, nil
+}
+
+// DetectContentType guesses a MIME type from the file extension, then the content
+func DetectContentType(filename string, data []byte) string {
+	if ext := filepath.Ext(filename); ext != "" {
+		if contentType := mime.TypeByExtension(strings.ToLower(ext)); contentType != "" {
+			return contentType
+		}
+	}
+	return http.DetectContentType(data)
+}
+
+// Verify checks data against the recorded SHA-256
+func (info *ContentInfo) Verify(data []byte) error {
+	if sha256.Sum256(data) != info.SHA256 {
+		return fmt.Errorf("%w: expected %x", ErrDigestMismatch, info.SHA256[:8])
+	}
+	return nil
+}
+
+// SafeFilename returns the filename reduced to a base name that is safe to
+// create, or "" when nothing usable is left. Senders are not trusted.
+func (info *ContentInfo) SafeFilename() string {
+	name := strings.ReplaceAll(info.Filename, "\\", "/")
+	if i := strings.LastIndex(name, "/"); i >= 0 {
+		name = name[i+1:]
+	}
+	name = strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f {
+			return -1
+		}
+		return r
+	}, name)
+
+	if name == "" || name == "." || name == ".." {
+		return ""
+	}
+	return name
+}
+
+// String describes the content for display
+func (info *ContentInfo) String() string {
+	name := info.Filename
+	if name == "" {
+		name = "(unnamed)"
+	}
+	if len(info.TLVs) > 0 {
+		return fmt.Sprintf("%s (%s, sha256 %x, %s)", name, info.ContentType, info.SHA256[:8], info.TLVs)
+	}
+	return fmt.Sprintf("%s (%s, sha256 %x)", name, info.ContentType, info.SHA256[:8])
+}
+
+// marshal serializes the extended header
+func (info *ContentInfo) marshal() []byte {
+	body := make([]byte, 0, EXTENDED_HEADER_MIN_SIZE+len(info.ContentType)+len(info.Filename))
+	body = append(body, info.SHA256[:]...)
+	body = append(body, byte(len(info.ContentType)))
+	body = append(body, info.ContentType...)
+	body = append(body, byte(len(info.Filename)))
+	body = append(body, info.Filename...)
+	body = append(body, info.TLVs.marshal()...)
+
+	header := []byte{EXTENDED_HEADER_VERSION, 0, 0}
+	binary.BigEndian.PutUint16(header[1:3], uint16(len(body)))
+	return append(header, body...)
+}
+
+// parseExtendedHeader splits a reassembled stream into its extended header and data
+func parseExtendedHeader(stream []byte) (*ContentInfo, []byte, error) {
+	if len(stream) < EXTENDED_HEADER_MIN_SIZE {
+		return nil, nil, fmt.Errorf("extended header truncated: %d bytes", len(stream))
+	}
+	if stream[0] == 0 {
+		return nil, nil, errors.New("invalid extended header version 0")
+	}
+
+	length := int(binary.BigEndian.Uint16(stream[1:3]))
+	if length < EXTENDED_HEADER_MIN_SIZE-3 {
+		return nil, nil, fmt.Errorf("extended header too short: %d bytes", length)
+	}
+	if 3+length > len(stream) {
+		return nil, nil, fmt.Errorf("extended header claims %d bytes, stream has %d", length, len(stream)-3)
+	}
+	body := bytes.NewReader(stream[3 : 3+length])
+	data := stream[3+length:]
+
+	info := &ContentInfo{}
+	if _, err := body.Read(info.SHA256[:]); err != nil {
+		return nil, nil, errors.New("extended header missing digest")
+	}
+	var err error
+	if info.ContentType, err = readField(body); err != nil {
+		return nil, nil, fmt.Errorf("extended header content type: %w", err)
+	}
+	if info.Filename, err = readField(body); err != nil {
+		return nil, nil, fmt.Errorf("extended header filename: %w", err)
+	}
+
+	// The rest is the TLV section (empty from older senders)
+	rest := make([]byte, body.Len())
+	body.Read(rest)
+	if info.TLVs, err = ParseTLVs(rest); err != nil {
+		return nil, nil, fmt.Errorf("extended header TLVs: %w", err)
+	}
+
+	return info, data, nil
+}
+
+// readField reads one length-prefixed string
+func readField(r *bytes.Reader) (string, error) {
+	n, err := r.ReadByte()
+	if err != nil {
+		return "", errors.New("missing length")
+	}
+	if int(n) > r.Len() {
+		return "", fmt.Errorf("length %d exceeds header", n)
+	}
+	field := make([]byte, n)
+	r.Read(field)
+	return string(field), nil
+}
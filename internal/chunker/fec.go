@@ -0,0 +1,532 @@
+package chunker
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// ================================================================================
+// THEORY LESSON: Reed-Solomon Forward Error Correction
+// ================================================================================
+//
+// DNS is lossy: resolvers drop queries, records expire, rate limits kick in.
+// Instead of re-querying every lost chunk we add PARITY chunks so that any
+// N of the N+M chunks in a group are enough to rebuild the data.
+//
+// HOW IT WORKS:
+// 1. Data chunks are split into groups (at most 255 shards per group, the
+//    size of our finite field GF(2^8))
+// 2. Every chunk payload is treated as a "shard" padded to the same length
+// 3. Each parity shard is a linear combination of the data shards, using
+//    coefficients from a Cauchy matrix (any square sub-matrix is invertible)
+// 4. To recover, we pick any N surviving rows, invert that N x N matrix and
+//    multiply it with the surviving shards
+//
+// WIRE FORMAT:
+// Parity chunks use their own magic ("DNSP") so older receivers simply
+// ignore them. Their payload starts with an FEC header:
+// [DATA_LENGTH(4)][GROUP_SIZE(2)][PARITY_PER_GROUP(2)][SHARD(variable)]
+// ================================================================================
+
+const (
+	// CHUNK_MAGIC_PARITY identifies Reed-Solomon parity chunks
+	CHUNK_MAGIC_PARITY = 0x444E5350 // "DNSP" in hex
+
+	// FEC_HEADER_SIZE is the parity payload prefix describing the code
+	FEC_HEADER_SIZE = 8
+
+	// FEC_MAX_SHARDS is the number of shards a GF(2^8) code can address
+	FEC_MAX_SHARDS = 255
+
+	// DEFAULT_REDUNDANCY adds 25% parity chunks
+	DEFAULT_REDUNDANCY = 0.25
+
+	// MAX_REDUNDANCY caps parity at one parity chunk per data chunk
+	MAX_REDUNDANCY = 1.0
+)
+
+// FECHeader describes how parity chunks were generated
+type FECHeader struct {
+	DataLength     uint32 // Total message bytes (to trim the last shard)
+	GroupSize      uint16 // Data chunks per FEC group
+	ParityPerGroup uint16 // Parity chunks per FEC group
+}
+
+// AddRedundancy implements Reed-Solomon error correction.
+// It returns the data chunks followed by the generated parity chunks.
+func (c *Chunker) AddRedundancy(chunks []Chunk, redundancyFactor float64) []Chunk {
+	if len(chunks) == 0 || redundancyFactor <= 0 {
+		return chunks
+	}
+	if redundancyFactor > MAX_REDUNDANCY {
+		redundancyFactor = MAX_REDUNDANCY
+	}
+
+	// Data chunks must be in sequence order
+	data := make([]Chunk, len(chunks))
+	copy(data, chunks)
+	sort.Slice(data, func(i, j int) bool {
+		return data[i].Metadata.Sequence < data[j].Metadata.Sequence
+	})
+
+	shardSize := c.calculatePayloadSize()
+	header := FECHeader{}
+	for _, chunk := range data {
+		header.DataLength += uint32(len(chunk.Payload))
+	}
+
+	groupSize, parityPerGroup := fecGroups(len(data), redundancyFactor)
+
+	header.GroupSize = uint16(groupSize)
+	header.ParityPerGroup = uint16(parityPerGroup)
+
+	messageID := data[0].Metadata.MessageID
+	total := data[0].Metadata.TotalChunks
+	reference := data[0].Metadata
+
+	result := make([]Chunk, 0, len(data)+parityPerGroup*(len(data)/groupSize+1))
+	result = append(result, data...)
+
+	paritySeq := 0
+	for start := 0; start < len(data); start += groupSize {
+		end := start + groupSize
+		if end > len(data) {
+			end = len(data)
+		}
+
+		// Pad every shard in the group to the same size
+		shards := make([][]byte, end-start)
+		for i := range shards {
+			shards[i] = make([]byte, shardSize)
+			copy(shards[i], data[start+i].Payload)
+		}
+
+		for p := 0; p < parityPerGroup; p++ {
+			shard := encodeParityShard(shards, p, shardSize)
+			result = append(result, c.createParityChunk(messageID, total, uint32(paritySeq), header, shard, reference))
+			paritySeq++
+		}
+	}
+
+	c.log(EVENT_FEC_ENCODED, map[string]interface{}{"parity_chunks": paritySeq, "per_group": parityPerGroup, "group_size": groupSize, "redundancy": redundancyFactor},
+		"   FEC: %d parity chunks (%d per group of %d, %.0f%% redundancy)",
+		paritySeq, parityPerGroup, groupSize, redundancyFactor*100)
+
+	return result
+}
+
+// fecGroups sizes the Reed-Solomon groups for n data chunks
+func fecGroups(n int, redundancyFactor float64) (groupSize, parityPerGroup int) {
+	// LESSON: Group Sizing
+	// GroupSize + ParityPerGroup must fit in the 255 field elements
+	maxGroup := int(math.Floor(FEC_MAX_SHARDS / (1 + redundancyFactor)))
+	groupSize = n
+	if groupSize > maxGroup {
+		groupSize = maxGroup
+	}
+	parityPerGroup = int(math.Ceil(float64(groupSize) * redundancyFactor))
+	return groupSize, parityPerGroup
+}
+
+// createParityChunk wraps a parity shard in a chunk, copying the message-wide
+// flags (compression, encryption, extended header) of the reference data chunk
+func (c *Chunker) createParityChunk(messageID [16]byte, total, sequence uint32, header FECHeader, shard []byte, reference ChunkMetadata) Chunk {
+	payload := make([]byte, FEC_HEADER_SIZE+len(shard))
+	binary.BigEndian.PutUint32(payload[0:4], header.DataLength)
+	binary.BigEndian.PutUint16(payload[4:6], header.GroupSize)
+	binary.BigEndian.PutUint16(payload[6:8], header.ParityPerGroup)
+	copy(payload[FEC_HEADER_SIZE:], shard)
+
+	metadata := ChunkMetadata{
+		Magic:       c.chunkMagic(true),
+		MessageID:   messageID,
+		Sequence:    sequence,
+		TotalChunks: total,
+		Checksum:    c.calculateChecksum(payload),
+		PayloadSize: uint16(len(payload)),
+		Parity:      true,
+		Version:     c.protocolVersion(),
+		Integrity:   c.integrity,
+		Compression: reference.Compression,
+		Encrypted:   reference.Encrypted,
+		Extended:    reference.Extended,
+	}
+
+	tag := c.tagFor(metadata, payload)
+
+	return Chunk{
+		Metadata:   metadata,
+		Payload:    payload,
+		Tag:        tag,
+		Encoded:    c.encodeChunk(metadata, payload, tag),
+		RecordName: c.generateRecordName(metadata),
+	}
+}
+
+// parseFECHeader extracts the FEC header and shard from a parity chunk
+func parseFECHeader(chunk Chunk) (FECHeader, []byte, error) {
+	if len(chunk.Payload) < FEC_HEADER_SIZE {
+		return FECHeader{}, nil, fmt.Errorf("parity chunk %d too small", chunk.Metadata.Sequence)
+	}
+
+	header := FECHeader{
+		DataLength:     binary.BigEndian.Uint32(chunk.Payload[0:4]),
+		GroupSize:      binary.BigEndian.Uint16(chunk.Payload[4:6]),
+		ParityPerGroup: binary.BigEndian.Uint16(chunk.Payload[6:8]),
+	}
+
+	if header.GroupSize == 0 || int(header.GroupSize)+int(header.ParityPerGroup) > FEC_MAX_SHARDS+1 {
+		return FECHeader{}, nil, fmt.Errorf("invalid FEC parameters: group %d, parity %d",
+			header.GroupSize, header.ParityPerGroup)
+	}
+
+	return header, chunk.Payload[FEC_HEADER_SIZE:], nil
+}
+
+// splitParity separates data chunks from parity chunks
+func splitParity(chunks []Chunk) (data, parity []Chunk) {
+	for _, chunk := range chunks {
+		if chunk.Metadata.Parity {
+			parity = append(parity, chunk)
+		} else {
+			data = append(data, chunk)
+		}
+	}
+	return data, parity
+}
+
+// recoverMissing rebuilds lost or corrupted data chunks from parity chunks
+func (c *Chunker) recoverMissing(data, parity []Chunk, total uint32) ([]Chunk, error) {
+	if isFountainChunk(parity[0]) {
+		return c.recoverFountain(data, parity, total)
+	}
+
+	// LESSON: Corruption as Erasure
+	// A chunk with a bad checksum is no better than a lost one, so we drop
+	// it and let the parity rebuild it.
+	present := make(map[uint32]Chunk)
+	for _, chunk := range data {
+		if c.verifyChecksum(&chunk) != nil {
+			c.log(EVENT_WARNING, map[string]interface{}{"sequence": chunk.Metadata.Sequence},
+				"   ⚠️  Dropping corrupted chunk %d (will try FEC)", chunk.Metadata.Sequence)
+			continue
+		}
+		present[chunk.Metadata.Sequence] = chunk
+	}
+
+	paritySeqs := make(map[uint32][]byte)
+	var header FECHeader
+	var shardSize int
+	for _, chunk := range parity {
+		if c.verifyChecksum(&chunk) != nil {
+			continue
+		}
+		h, shard, err := parseFECHeader(chunk)
+		if err != nil {
+			continue
+		}
+		header = h
+		shardSize = len(shard)
+		paritySeqs[chunk.Metadata.Sequence] = shard
+	}
+
+	missing := make([]uint32, 0)
+	for i := uint32(0); i < total; i++ {
+		if _, ok := present[i]; !ok {
+			missing = append(missing, i)
+		}
+	}
+
+	if len(missing) == 0 {
+		return sortedChunks(present), nil
+	}
+	if len(paritySeqs) == 0 {
+		return sortedChunks(present), nil
+	}
+
+	c.log(EVENT_FEC_RECOVERY, map[string]interface{}{"missing": len(missing), "parity_chunks": len(paritySeqs)},
+		"   🛠️  FEC recovery: %d data chunks missing, %d parity chunks available",
+		len(missing), len(paritySeqs))
+
+	groupSize := int(header.GroupSize)
+	parityPerGroup := int(header.ParityPerGroup)
+
+	for start := 0; start < int(total); start += groupSize {
+		end := start + groupSize
+		if end > int(total) {
+			end = int(total)
+		}
+		group := start / groupSize
+
+		// Collect which shards of this group we hold
+		var lost []int
+		for i := start; i < end; i++ {
+			if _, ok := present[uint32(i)]; !ok {
+				lost = append(lost, i-start)
+			}
+		}
+		if len(lost) == 0 {
+			continue
+		}
+
+		groupParity := make(map[int][]byte)
+		for p := 0; p < parityPerGroup; p++ {
+			if shard, ok := paritySeqs[uint32(group*parityPerGroup+p)]; ok {
+				groupParity[p] = shard
+			}
+		}
+
+		if len(groupParity) < len(lost) {
+			return nil, fmt.Errorf("FEC group %d: %d chunks lost but only %d parity chunks available",
+				group, len(lost), len(groupParity))
+		}
+
+		shards := make([][]byte, end-start)
+		for i := range shards {
+			if chunk, ok := present[uint32(start+i)]; ok {
+				shards[i] = make([]byte, shardSize)
+				copy(shards[i], chunk.Payload)
+			}
+		}
+
+		if err := reconstructGroup(shards, groupParity, lost, shardSize); err != nil {
+			return nil, fmt.Errorf("FEC group %d: %w", group, err)
+		}
+
+		// Turn rebuilt shards back into chunks
+		for _, idx := range lost {
+			seq := start + idx
+			payload := shards[idx]
+			if seq == int(total)-1 {
+				// Trim padding from the final shard
+				lastLen := int(header.DataLength) - (int(total)-1)*shardSize
+				if lastLen < 0 || lastLen > len(payload) {
+					return nil, fmt.Errorf("invalid data length %d", header.DataLength)
+				}
+				payload = payload[:lastLen]
+			}
+			present[uint32(seq)] = c.rebuildChunk(data, parity, uint32(seq), total, payload)
+		}
+	}
+
+	c.log(EVENT_FEC_RECOVERED, map[string]interface{}{"recovered": len(missing)},
+		"   ✅ FEC recovered %d chunks", len(missing))
+
+	return sortedChunks(present), nil
+}
+
+// rebuildChunk creates a data chunk for a recovered payload, matching the
+// protocol version and integrity algorithm of the received chunks
+func (c *Chunker) rebuildChunk(data, parity []Chunk, seq, total uint32, payload []byte) Chunk {
+	reference := parity[0].Metadata
+	if len(data) > 0 {
+		reference = data[0].Metadata
+	}
+
+	format, err := LookupWireFormat(reference.Version)
+	if err != nil {
+		format = c.wire
+	}
+	checksum, _ := ComputeChecksum(reference.Integrity, payload)
+
+	metadata := ChunkMetadata{
+		Magic:       format.Magic,
+		MessageID:   reference.MessageID,
+		Sequence:    seq,
+		TotalChunks: total,
+		Checksum:    checksum,
+		PayloadSize: uint16(len(payload)),
+		Version:     reference.Version,
+		Integrity:   reference.Integrity,
+		Compression: reference.Compression,
+		Encrypted:   reference.Encrypted,
+		Extended:    reference.Extended,
+	}
+
+	return Chunk{
+		Metadata:   metadata,
+		Payload:    payload,
+		RecordName: c.generateRecordName(metadata),
+	}
+}
+
+// sortedChunks returns map values ordered by sequence
+func sortedChunks(present map[uint32]Chunk) []Chunk {
+	chunks := make([]Chunk, 0, len(present))
+	for _, chunk := range present {
+		chunks = append(chunks, chunk)
+	}
+	sort.Slice(chunks, func(i, j int) bool {
+		return chunks[i].Metadata.Sequence < chunks[j].Metadata.Sequence
+	})
+	return chunks
+}
+
+// ================================================================================
+// GF(2^8) ARITHMETIC AND MATRIX OPERATIONS
+// ================================================================================
+
+var (
+	gfExp [512]byte
+	gfLog [256]byte
+)
+
+func init() {
+	// Build log/antilog tables for GF(2^8) with polynomial x^8+x^4+x^3+x^2+1
+	x := 1
+	for i := 0; i < 255; i++ {
+		gfExp[i] = byte(x)
+		gfLog[x] = byte(i)
+		x <<= 1
+		if x&0x100 != 0 {
+			x ^= 0x11d
+		}
+	}
+	for i := 255; i < 512; i++ {
+		gfExp[i] = gfExp[i-255]
+	}
+}
+
+// gfMul multiplies two field elements
+func gfMul(a, b byte) byte {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	return gfExp[int(gfLog[a])+int(gfLog[b])]
+}
+
+// gfInv returns the multiplicative inverse of a non-zero element
+func gfInv(a byte) byte {
+	return gfExp[255-int(gfLog[a])]
+}
+
+// cauchyCoefficient returns the encoding coefficient for parity row p and
+// data column d in a group of n data shards
+func cauchyCoefficient(p, d, n int) byte {
+	// x_p = n + p, y_d = d; addition in GF(2^8) is XOR
+	return gfInv(byte(n+p) ^ byte(d))
+}
+
+// encodeParityShard computes parity shard p for a group of data shards
+func encodeParityShard(shards [][]byte, p, shardSize int) []byte {
+	parity := make([]byte, shardSize)
+	n := len(shards)
+	for d, shard := range shards {
+		coef := cauchyCoefficient(p, d, n)
+		for i, b := range shard {
+			parity[i] ^= gfMul(coef, b)
+		}
+	}
+	return parity
+}
+
+// reconstructGroup fills in lost data shards using parity shards
+func reconstructGroup(shards [][]byte, parity map[int][]byte, lost []int, shardSize int) error {
+	n := len(shards)
+
+	// Build an n x n system from the surviving data rows plus enough parity rows
+	matrix := make([][]byte, 0, n)
+	inputs := make([][]byte, 0, n)
+
+	for d, shard := range shards {
+		if shard == nil {
+			continue
+		}
+		row := make([]byte, n)
+		row[d] = 1
+		matrix = append(matrix, row)
+		inputs = append(inputs, shard)
+	}
+
+	parityRows := make([]int, 0, len(parity))
+	for p := range parity {
+		parityRows = append(parityRows, p)
+	}
+	sort.Ints(parityRows)
+
+	for _, p := range parityRows {
+		if len(matrix) == n {
+			break
+		}
+		row := make([]byte, n)
+		for d := 0; d < n; d++ {
+			row[d] = cauchyCoefficient(p, d, n)
+		}
+		matrix = append(matrix, row)
+		inputs = append(inputs, parity[p])
+	}
+
+	inverse, err := invertMatrix(matrix)
+	if err != nil {
+		return err
+	}
+
+	for _, idx := range lost {
+		out := make([]byte, shardSize)
+		for r, input := range inputs {
+			coef := inverse[idx][r]
+			if coef == 0 {
+				continue
+			}
+			for i := 0; i < shardSize && i < len(input); i++ {
+				out[i] ^= gfMul(coef, input[i])
+			}
+		}
+		shards[idx] = out
+	}
+
+	return nil
+}
+
+// invertMatrix inverts a square matrix over GF(2^8) with Gauss-Jordan elimination
+func invertMatrix(matrix [][]byte) ([][]byte, error) {
+	n := len(matrix)
+
+	// Augment with identity
+	work := make([][]byte, n)
+	for i := range matrix {
+		work[i] = make([]byte, 2*n)
+		copy(work[i], matrix[i])
+		work[i][n+i] = 1
+	}
+
+	for col := 0; col < n; col++ {
+		// Find pivot
+		pivot := -1
+		for row := col; row < n; row++ {
+			if work[row][col] != 0 {
+				pivot = row
+				break
+			}
+		}
+		if pivot < 0 {
+			return nil, fmt.Errorf("singular FEC matrix")
+		}
+		work[col], work[pivot] = work[pivot], work[col]
+
+		// Normalize pivot row
+		inv := gfInv(work[col][col])
+		for j := range work[col] {
+			work[col][j] = gfMul(work[col][j], inv)
+		}
+
+		// Eliminate column from other rows
+		for row := 0; row < n; row++ {
+			if row == col || work[row][col] == 0 {
+				continue
+			}
+			factor := work[row][col]
+			for j := range work[row] {
+				work[row][j] ^= gfMul(factor, work[col][j])
+			}
+		}
+	}
+
+	inverse := make([][]byte, n)
+	for i := range work {
+		inverse[i] = work[i][n:]
+	}
+	return inverse, nil
+}
@@ -0,0 +1,521 @@
+package chunker
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"math/bits"
+	"math/rand"
+	"strings"
+)
+
+// ================================================================================
+// THEORY LESSON: Fountain Codes
+// ================================================================================
+//
+// Reed-Solomon fixes the amount of redundancy up front: with 25% parity, a
+// group that loses 26% of its chunks is gone. Recursive resolvers can be far
+// lossier than that, and the sender rarely knows how lossy in advance.
+//
+// An LT (Luby Transform) fountain code has no fixed rate. Each coded chunk
+// is the XOR of a few randomly chosen data chunks, and the sender can keep
+// producing new ones forever. A receiver decodes from ANY set of roughly
+// N(1+e) chunks - data or coded, in any order:
+//
+// 1. The encoding symbol ID (ESI) of a coded chunk seeds a PRNG that picks
+//    its degree d from the robust soliton distribution, then d neighbours
+// 2. The receiver rebuilds the same neighbour sets from the ESIs it sees
+// 3. Peeling: a coded chunk with one unknown neighbour reveals it, which
+//    may leave other coded chunks with one unknown, and so on
+// 4. When peeling stalls, Gaussian elimination over GF(2) finishes the job
+//
+// The code is systematic: data chunks are sent as usual, so a lossless
+// path needs no decoding at all. That changes the degree trade-off: most
+// data arrives directly, and a coded chunk only helps if it touches one of
+// the few missing chunks. Low-degree symbols rarely do, so degrees get a
+// floor of 3 ln(N) - peeling stalls more often, elimination finishes.
+//
+// WIRE FORMAT:
+// Coded chunks are parity chunks whose FEC header has GROUP_SIZE 0, which
+// Reed-Solomon never uses. The ESI is 32 bits: the low half is the chunk
+// sequence number, the high half takes the PARITY_PER_GROUP slot:
+// [DATA_LENGTH(4)][0(2)][ESI_HIGH(2)][SYMBOL(variable)]
+// ================================================================================
+
+const (
+	// FEC schemes for ChunkerConfig.FECScheme
+	FEC_REED_SOLOMON = "reed-solomon"
+	FEC_FOUNTAIN     = "fountain"
+
+	// Robust soliton parameters: LT_C scales the spike of extra low-degree
+	// symbols, LT_DELTA bounds the decoding failure probability
+	LT_C     = 0.1
+	LT_DELTA = 0.5
+
+	// LT_MIN_DEGREE_FACTOR sets the degree floor to this many times ln(N)
+	LT_MIN_DEGREE_FACTOR = 3.0
+
+	// FOUNTAIN_MAX_REDUNDANCY caps the coded chunks generated up front;
+	// a FountainEncoder can always produce more
+	FOUNTAIN_MAX_REDUNDANCY = 4.0
+)
+
+// FECSchemeNames lists the supported FEC schemes
+func FECSchemeNames() []string {
+	return []string{FEC_REED_SOLOMON, FEC_FOUNTAIN}
+}
+
+// ParseFECScheme validates an FEC scheme name ("" means Reed-Solomon)
+func ParseFECScheme(name string) (string, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	switch name {
+	case "", "rs", FEC_REED_SOLOMON:
+		return FEC_REED_SOLOMON, nil
+	case "lt", FEC_FOUNTAIN:
+		return FEC_FOUNTAIN, nil
+	}
+	return "", fmt.Errorf("unknown FEC scheme %q (supported: %s)", name, strings.Join(FECSchemeNames(), ", "))
+}
+
+// FountainEncoder produces an unbounded stream of coded chunks for one message
+type FountainEncoder struct {
+	chunker    *Chunker
+	symbols    [][]byte // Data payloads padded to symbolSize
+	symbolSize int
+	dataLength uint32
+	reference  ChunkMetadata
+	cdf        []float64
+	next       uint32
+}
+
+// NewFountainEncoder prepares coded chunk generation from a message's data
+// chunks (parity chunks are ignored)
+func (c *Chunker) NewFountainEncoder(chunks []Chunk) (*FountainEncoder, error) {
+	if !c.config.AddRedundancy {
+		return nil, errors.New("fountain encoder needs AddRedundancy (data chunks must leave room for the FEC header)")
+	}
+
+	data, _ := splitParity(chunks)
+	if len(data) == 0 {
+		return nil, errors.New("fountain encoder needs data chunks")
+	}
+
+	total := int(data[0].Metadata.TotalChunks)
+	if len(data) != total {
+		return nil, fmt.Errorf("fountain encoder needs all %d data chunks, have %d", total, len(data))
+	}
+
+	fe := &FountainEncoder{
+		chunker:    c,
+		symbols:    make([][]byte, total),
+		symbolSize: c.calculatePayloadSize(),
+		reference:  data[0].Metadata,
+		cdf:        robustSoliton(total),
+	}
+
+	for _, chunk := range data {
+		seq := int(chunk.Metadata.Sequence)
+		if seq >= total || fe.symbols[seq] != nil {
+			return nil, fmt.Errorf("invalid or repeated data chunk %d", seq)
+		}
+		if len(chunk.Payload) > fe.symbolSize {
+			return nil, fmt.Errorf("chunk %d payload (%d bytes) exceeds the %d-byte symbol size",
+				seq, len(chunk.Payload), fe.symbolSize)
+		}
+		fe.symbols[seq] = make([]byte, fe.symbolSize)
+		copy(fe.symbols[seq], chunk.Payload)
+		fe.dataLength += uint32(len(chunk.Payload))
+	}
+
+	return fe, nil
+}
+
+// Next returns the next coded chunk in the stream
+func (fe *FountainEncoder) Next() Chunk {
+	chunk := fe.Symbol(fe.next)
+	fe.next++
+	return chunk
+}
+
+// Symbol returns the coded chunk with a given ESI
+func (fe *FountainEncoder) Symbol(esi uint32) Chunk {
+	symbol := make([]byte, fe.symbolSize)
+	for _, index := range ltNeighbours(fe.reference.MessageID, esi, len(fe.symbols), fe.cdf) {
+		xorInto(symbol, fe.symbols[index])
+	}
+
+	payload := make([]byte, FEC_HEADER_SIZE+len(symbol))
+	binary.BigEndian.PutUint32(payload[0:4], fe.dataLength)
+	binary.BigEndian.PutUint16(payload[6:8], uint16(esi>>16))
+	copy(payload[FEC_HEADER_SIZE:], symbol)
+
+	c := fe.chunker
+	metadata := ChunkMetadata{
+		Magic:       c.chunkMagic(true),
+		MessageID:   fe.reference.MessageID,
+		Sequence:    esi & 0xFFFF, // High bits travel in the FEC header
+		TotalChunks: fe.reference.TotalChunks,
+		Checksum:    c.calculateChecksum(payload),
+		PayloadSize: uint16(len(payload)),
+		Parity:      true,
+		Version:     c.protocolVersion(),
+		Integrity:   c.integrity,
+		Compression: fe.reference.Compression,
+		Encrypted:   fe.reference.Encrypted,
+		Extended:    fe.reference.Extended,
+	}
+	tag := c.tagFor(metadata, payload)
+
+	return Chunk{
+		Metadata:   metadata,
+		Payload:    payload,
+		Tag:        tag,
+		Encoded:    c.encodeChunk(metadata, payload, tag),
+		RecordName: c.recordName("parity", int(esi), metadata.MessageID),
+	}
+}
+
+// addFountain appends ceil(N * redundancy) coded chunks to the data chunks
+func (c *Chunker) addFountain(chunks []Chunk, redundancy float64) []Chunk {
+	fe, err := c.NewFountainEncoder(chunks)
+	if err != nil {
+		c.log(EVENT_WARNING, map[string]interface{}{"error": err.Error()},
+			"   ⚠️  Fountain coding skipped: %v", err)
+		return chunks
+	}
+
+	count := int(math.Ceil(float64(len(chunks)) * redundancy))
+	for i := 0; i < count; i++ {
+		chunks = append(chunks, fe.Next())
+	}
+
+	c.log(EVENT_FEC_ENCODED, map[string]interface{}{"scheme": FEC_FOUNTAIN, "parity_chunks": count, "redundancy": redundancy},
+		"   FEC: %d fountain-coded chunks (%.0f%% redundancy, more can be generated on demand)",
+		count, redundancy*100)
+
+	return chunks
+}
+
+// isFountainChunk reports whether a parity chunk carries a fountain symbol
+func isFountainChunk(chunk Chunk) bool {
+	return chunk.Metadata.Parity && len(chunk.Payload) >= FEC_HEADER_SIZE &&
+		binary.BigEndian.Uint16(chunk.Payload[4:6]) == 0
+}
+
+// symbolID returns the identity of a parity chunk within its message:
+// the ESI of a fountain symbol, else the sequence number
+func symbolID(chunk Chunk) uint32 {
+	if isFountainChunk(chunk) {
+		return uint32(binary.BigEndian.Uint16(chunk.Payload[6:8]))<<16 | chunk.Metadata.Sequence&0xFFFF
+	}
+	return chunk.Metadata.Sequence
+}
+
+// parseFountainHeader extracts the data length and symbol of a coded chunk
+func parseFountainHeader(chunk Chunk) (uint32, []byte, error) {
+	if !isFountainChunk(chunk) {
+		return 0, nil, fmt.Errorf("chunk %d is not fountain coded", chunk.Metadata.Sequence)
+	}
+	symbol := chunk.Payload[FEC_HEADER_SIZE:]
+	if len(symbol) == 0 {
+		return 0, nil, fmt.Errorf("coded chunk %d has an empty symbol", symbolID(chunk))
+	}
+	return binary.BigEndian.Uint32(chunk.Payload[0:4]), symbol, nil
+}
+
+// ltEquation is one coded symbol during decoding: value = XOR of unknowns
+type ltEquation struct {
+	unknown map[int]bool
+	value   []byte
+}
+
+// recoverFountain rebuilds missing data chunks from fountain-coded chunks
+func (c *Chunker) recoverFountain(data, parity []Chunk, total uint32) ([]Chunk, error) {
+	present := make(map[uint32]Chunk)
+	for _, chunk := range data {
+		if c.verifyChecksum(&chunk) != nil {
+			c.log(EVENT_WARNING, map[string]interface{}{"sequence": chunk.Metadata.Sequence},
+				"   ⚠️  Dropping corrupted chunk %d (will try FEC)", chunk.Metadata.Sequence)
+			continue
+		}
+		present[chunk.Metadata.Sequence] = chunk
+	}
+
+	missing := int(total) - len(present)
+	if missing == 0 {
+		return sortedChunks(present), nil
+	}
+
+	var dataLength uint32
+	symbolSize := 0
+	seen := make(map[uint32]bool)
+	var coded []Chunk
+	for _, chunk := range parity {
+		if c.verifyChecksum(&chunk) != nil || seen[symbolID(chunk)] {
+			continue
+		}
+		length, symbol, err := parseFountainHeader(chunk)
+		if err != nil {
+			continue
+		}
+		dataLength, symbolSize = length, len(symbol)
+		seen[symbolID(chunk)] = true
+		coded = append(coded, chunk)
+	}
+	if len(coded) == 0 {
+		return sortedChunks(present), nil
+	}
+
+	c.log(EVENT_FEC_RECOVERY, map[string]interface{}{"scheme": FEC_FOUNTAIN, "missing": missing, "parity_chunks": len(coded)},
+		"   🛠️  Fountain decoding: %d data chunks missing, %d coded chunks available", missing, len(coded))
+
+	// Known symbols, padded to the symbol size
+	known := make(map[int][]byte, total)
+	for seq, chunk := range present {
+		symbol := make([]byte, symbolSize)
+		copy(symbol, chunk.Payload)
+		known[int(seq)] = symbol
+	}
+
+	// Reduce every coded chunk by the neighbours we already hold
+	cdf := robustSoliton(int(total))
+	var equations []*ltEquation
+	for _, chunk := range coded {
+		_, symbol, _ := parseFountainHeader(chunk)
+		eq := &ltEquation{unknown: make(map[int]bool), value: append([]byte(nil), symbol...)}
+		for _, index := range ltNeighbours(chunk.Metadata.MessageID, symbolID(chunk), int(total), cdf) {
+			if value, ok := known[index]; ok {
+				xorInto(eq.value, value)
+			} else {
+				eq.unknown[index] = true
+			}
+		}
+		if len(eq.unknown) > 0 {
+			equations = append(equations, eq)
+		}
+	}
+
+	peelEquations(equations, known)
+	if len(known) < int(total) {
+		eliminateEquations(equations, known, int(total))
+	}
+
+	if len(known) < int(total) {
+		return nil, fmt.Errorf("fountain decoding: %d data chunks still unknown after %d coded chunks (fetch more coded chunks)",
+			int(total)-len(known), len(coded))
+	}
+
+	// Turn decoded symbols back into chunks
+	for seq := 0; seq < int(total); seq++ {
+		if _, ok := present[uint32(seq)]; ok {
+			continue
+		}
+		payload := known[seq]
+		if seq == int(total)-1 {
+			// Trim padding from the final symbol
+			lastLen := int(dataLength) - (int(total)-1)*symbolSize
+			if lastLen < 0 || lastLen > len(payload) {
+				return nil, fmt.Errorf("invalid data length %d", dataLength)
+			}
+			payload = payload[:lastLen]
+		}
+		present[uint32(seq)] = c.rebuildChunk(data, parity, uint32(seq), total, payload)
+	}
+
+	c.log(EVENT_FEC_RECOVERED, map[string]interface{}{"recovered": missing},
+		"   ✅ Fountain decoding recovered %d chunks", missing)
+
+	return sortedChunks(present), nil
+}
+
+// peelEquations repeatedly solves equations with a single unknown
+func peelEquations(equations []*ltEquation, known map[int][]byte) {
+	for progress := true; progress; {
+		progress = false
+		for _, eq := range equations {
+			// Substitute symbols solved since this equation was reduced
+			for index := range eq.unknown {
+				if value, ok := known[index]; ok {
+					xorInto(eq.value, value)
+					delete(eq.unknown, index)
+				}
+			}
+			if len(eq.unknown) != 1 {
+				continue
+			}
+			for index := range eq.unknown {
+				known[index] = eq.value
+				delete(eq.unknown, index)
+			}
+			progress = true
+		}
+	}
+}
+
+// eliminateEquations solves what peeling left with Gaussian elimination
+// over GF(2), restricted to the still unknown symbols
+func eliminateEquations(equations []*ltEquation, known map[int][]byte, total int) {
+	// Column numbering for the unknown symbols
+	var columns []int
+	column := make(map[int]int)
+	for index := 0; index < total; index++ {
+		if _, ok := known[index]; !ok {
+			column[index] = len(columns)
+			columns = append(columns, index)
+		}
+	}
+
+	words := (len(columns) + 63) / 64
+	var rows [][]uint64
+	var values [][]byte
+	for _, eq := range equations {
+		if len(eq.unknown) == 0 {
+			continue
+		}
+		row := make([]uint64, words)
+		for index := range eq.unknown {
+			col := column[index]
+			row[col/64] |= 1 << (col % 64)
+		}
+		rows = append(rows, row)
+		values = append(values, append([]byte(nil), eq.value...))
+	}
+
+	// Forward elimination to reduced row echelon form
+	pivots := make([]int, len(columns))
+	for i := range pivots {
+		pivots[i] = -1
+	}
+	r := 0
+	for col := 0; col < len(columns) && r < len(rows); col++ {
+		word, bit := col/64, uint64(1)<<(col%64)
+
+		pivot := -1
+		for i := r; i < len(rows); i++ {
+			if rows[i][word]&bit != 0 {
+				pivot = i
+				break
+			}
+		}
+		if pivot < 0 {
+			continue
+		}
+		rows[r], rows[pivot] = rows[pivot], rows[r]
+		values[r], values[pivot] = values[pivot], values[r]
+
+		for i := range rows {
+			if i != r && rows[i][word]&bit != 0 {
+				for w := range rows[i] {
+					rows[i][w] ^= rows[r][w]
+				}
+				xorInto(values[i], values[r])
+			}
+		}
+		pivots[col] = r
+		r++
+	}
+
+	// A pivot row holding just its own column has solved that symbol
+	for col, row := range pivots {
+		if row < 0 {
+			continue
+		}
+		ones := 0
+		for _, w := range rows[row] {
+			ones += bits.OnesCount64(w)
+		}
+		if ones == 1 {
+			known[columns[col]] = values[row]
+		}
+	}
+}
+
+// ltNeighbours returns the data chunk indices XORed into coded symbol esi
+func ltNeighbours(messageID [16]byte, esi uint32, total int, cdf []float64) []int {
+	// math/rand's seeded sequences are stable across Go releases, so
+	// every receiver derives the same neighbours as the sender
+	seed := binary.BigEndian.Uint64(messageID[:8]) ^ uint64(esi)*0x9E3779B97F4A7C15
+	rng := rand.New(rand.NewSource(int64(seed)))
+
+	degree := 1
+	u := rng.Float64()
+	for degree < len(cdf) && u > cdf[degree-1] {
+		degree++
+	}
+	// Systematic degree floor (see the lesson above)
+	if floor := int(math.Ceil(LT_MIN_DEGREE_FACTOR * math.Log(float64(total)+1))); degree < floor {
+		degree = floor
+	}
+	if degree > total {
+		degree = total
+	}
+
+	// Distinct neighbours: rejection sampling for low degrees, a partial
+	// shuffle once the degree is a large share of the message
+	if degree*4 > total {
+		return rng.Perm(total)[:degree]
+	}
+	chosen := make(map[int]bool, degree)
+	neighbours := make([]int, 0, degree)
+	for len(neighbours) < degree {
+		index := rng.Intn(total)
+		if !chosen[index] {
+			chosen[index] = true
+			neighbours = append(neighbours, index)
+		}
+	}
+	return neighbours
+}
+
+// robustSoliton returns the cumulative robust soliton distribution over
+// degrees 1..k (cdf[d-1] = P(degree <= d))
+func robustSoliton(k int) []float64 {
+	if k <= 1 {
+		return []float64{1}
+	}
+
+	kf := float64(k)
+	r := LT_C * math.Log(kf/LT_DELTA) * math.Sqrt(kf)
+	spike := int(math.Round(kf / r))
+	if spike < 1 {
+		spike = 1
+	}
+
+	weights := make([]float64, k)
+	sum := 0.0
+	for d := 1; d <= k; d++ {
+		// Ideal soliton
+		w := 1 / (float64(d) * float64(d-1))
+		if d == 1 {
+			w = 1 / kf
+		}
+
+		// Extra mass on low degrees and a spike at k/R
+		switch {
+		case d < spike:
+			w += r / (float64(d) * kf)
+		case d == spike:
+			w += r * math.Log(r/LT_DELTA) / kf
+		}
+
+		weights[d-1] = w
+		sum += w
+	}
+
+	cdf := make([]float64, k)
+	acc := 0.0
+	for i, w := range weights {
+		acc += w / sum
+		cdf[i] = acc
+	}
+	cdf[k-1] = 1
+	return cdf
+}
+
+// xorInto XORs src into dst
+func xorInto(dst, src []byte) {
+	for i := 0; i < len(dst) && i < len(src); i++ {
+		dst[i] ^= src[i]
+	}
+}
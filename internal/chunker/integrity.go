@@ -0,0 +1,152 @@
+package chunker
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"hash/crc64"
+	"sort"
+	"strings"
+)
+
+// ================================================================================
+// THEORY LESSON: Chunk Integrity Schemes
+// ================================================================================
+//
+// Protocol v1 used a rotate-and-add byte sum. It is cheap but weak: swapping
+// two bytes that are 32 positions apart, or many multi-byte edits, produce
+// the same sum. Protocol v2 records WHICH algorithm protected a chunk in a
+// header flags byte, so sender and receiver never have to guess:
+//
+//   LEGACY   - v1 rotate-and-add sum (kept for old chunks only)
+//   CRC32C   - Castagnoli CRC, hardware accelerated, great burst detection
+//   XXHASH64 - XXH64 folded to 32 bits (fast and not linear, see xxhash.go)
+//   CRC64    - ECMA CRC64 folded to 32 bits
+//   SHA256   - first 4 bytes of SHA-256 (slow, but not linear)
+//   HMAC-SHA256 - CRC32C checksum plus a keyed tag trailer (see auth.go)
+//
+// The checksum field stays 4 bytes, so switching algorithms never changes
+// the chunk capacity. Roughly from fastest to most collision resistant:
+// crc32c, xxhash64, crc64, sha256. Whoever checks a chunk - the receiver or
+// the DNS server accepting an upload - uses the algorithm its header
+// names, so senders pick one without any negotiation.
+// ================================================================================
+
+// IntegrityAlgorithm identifies a per-chunk checksum function
+type IntegrityAlgorithm uint8
+
+const (
+	INTEGRITY_LEGACY IntegrityAlgorithm = 0 // v1 rotate-and-add sum
+	INTEGRITY_CRC32C IntegrityAlgorithm = 1
+	INTEGRITY_CRC64  IntegrityAlgorithm = 2
+	INTEGRITY_SHA256 IntegrityAlgorithm = 3
+
+	// INTEGRITY_HMAC_SHA256 marks chunks carrying an AUTH_TAG_SIZE trailer;
+	// the checksum field holds a CRC32C
+	INTEGRITY_HMAC_SHA256 IntegrityAlgorithm = 4
+
+	// INTEGRITY_XXHASH64 is XXH64 folded to 32 bits (see xxhash.go)
+	INTEGRITY_XXHASH64 IntegrityAlgorithm = 5
+
+	// DEFAULT_INTEGRITY is used when ChunkerConfig.Integrity is empty
+	DEFAULT_INTEGRITY = "crc32c"
+)
+
+// ChecksumFunc computes a 32-bit integrity value over a payload
+type ChecksumFunc func(data []byte) uint32
+
+// integrityScheme pairs an algorithm with its name and implementation
+type integrityScheme struct {
+	name string
+	fn   ChecksumFunc
+}
+
+var (
+	crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+	crc64Table  = crc64.MakeTable(crc64.ECMA)
+
+	integrityRegistry = map[IntegrityAlgorithm]integrityScheme{
+		INTEGRITY_LEGACY:   {"legacy", legacyChecksum},
+		INTEGRITY_CRC32C:   {"crc32c", func(data []byte) uint32 { return crc32.Checksum(data, crc32cTable) }},
+		INTEGRITY_XXHASH64: {"xxhash64", xxhash64Folded},
+		INTEGRITY_CRC64:    {"crc64", crc64Folded},
+		INTEGRITY_SHA256:   {"sha256", sha256Truncated},
+
+		INTEGRITY_HMAC_SHA256: {"hmac-sha256", func(data []byte) uint32 { return crc32.Checksum(data, crc32cTable) }},
+	}
+)
+
+// RegisterIntegrity adds a custom checksum algorithm to the registry.
+// IDs must fit in the header's integrity bits.
+func RegisterIntegrity(algo IntegrityAlgorithm, name string, fn ChecksumFunc) error {
+	if byte(algo)&^FLAG_INTEGRITY_MASK != 0 {
+		return fmt.Errorf("integrity id %d does not fit in header", algo)
+	}
+	if _, exists := integrityRegistry[algo]; exists {
+		return fmt.Errorf("integrity id %d already registered", algo)
+	}
+	integrityRegistry[algo] = integrityScheme{name: strings.ToLower(name), fn: fn}
+	return nil
+}
+
+// ParseIntegrity maps a configuration name to an algorithm
+func ParseIntegrity(name string) (IntegrityAlgorithm, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	for algo, scheme := range integrityRegistry {
+		if scheme.name == name {
+			return algo, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown integrity algorithm %q (supported: %s)",
+		name, strings.Join(IntegrityNames(), ", "))
+}
+
+// IntegrityNames lists registered algorithm names
+func IntegrityNames() []string {
+	names := make([]string, 0, len(integrityRegistry))
+	for _, scheme := range integrityRegistry {
+		names = append(names, scheme.name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// String returns the algorithm's configuration name
+func (a IntegrityAlgorithm) String() string {
+	if scheme, ok := integrityRegistry[a]; ok {
+		return scheme.name
+	}
+	return fmt.Sprintf("unknown(%d)", uint8(a))
+}
+
+// ComputeChecksum runs the given algorithm over data
+func ComputeChecksum(algo IntegrityAlgorithm, data []byte) (uint32, error) {
+	scheme, ok := integrityRegistry[algo]
+	if !ok {
+		return 0, fmt.Errorf("unsupported integrity algorithm %d", algo)
+	}
+	return scheme.fn(data), nil
+}
+
+// legacyChecksum is the protocol v1 rotate-and-add sum
+func legacyChecksum(data []byte) uint32 {
+	var sum uint32
+	for _, b := range data {
+		sum += uint32(b)
+		sum = (sum << 1) | (sum >> 31) // Rotate left by 1
+	}
+	return sum
+}
+
+// crc64Folded XORs both halves of a CRC64 into 32 bits
+func crc64Folded(data []byte) uint32 {
+	sum := crc64.Checksum(data, crc64Table)
+	return uint32(sum>>32) ^ uint32(sum)
+}
+
+// sha256Truncated returns the first 4 bytes of SHA-256
+func sha256Truncated(data []byte) uint32 {
+	hash := sha256.Sum256(data)
+	return binary.BigEndian.Uint32(hash[:4])
+}
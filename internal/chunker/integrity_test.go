@@ -0,0 +1,59 @@
+package chunker
+
+import (
+	"bytes"
+	"testing"
+)
+
+// Reference XXH64 digests (seed 0), as published with the xxHash spec
+var xxhash64Vectors = []struct {
+	input string
+	want  uint64
+}{
+	{"", 0xef46db3751d8e999},
+	{"a", 0xd24ec4f1a98c6e5b},
+	{"abc", 0x44bc2cf5ad770999},
+	{"Nobody inspects the spammish repetition", 0xfbcea83c8a378bf1}, // Over 32 bytes: the stripe loop runs
+}
+
+func TestXXHash64Vectors(t *testing.T) {
+	for _, v := range xxhash64Vectors {
+		if got := xxhash64([]byte(v.input)); got != v.want {
+			t.Errorf("xxhash64(%q) = %016x, want %016x", v.input, got, v.want)
+		}
+	}
+}
+
+func TestIntegritySchemesRoundTrip(t *testing.T) {
+	data := bytes.Repeat([]byte("integrity round trip "), 40)
+
+	for _, name := range IntegrityNames() {
+		t.Run(name, func(t *testing.T) {
+			config := ChunkerConfig{Encoding: ENCODE_BASE32, Integrity: name, Logger: DiscardLogger}
+			if name == "hmac-sha256" {
+				config.AuthKey = []byte("integrity test key")
+			}
+			c := NewChunker(config)
+
+			msg, err := c.ChunkMessage(data)
+			if err != nil {
+				t.Fatalf("ChunkMessage: %v", err)
+			}
+			got, err := c.ReassembleMessage(msg.Chunks)
+			if err != nil {
+				t.Fatalf("ReassembleMessage: %v", err)
+			}
+			if !bytes.Equal(got, data) {
+				t.Fatalf("reassembled %d bytes, want the original %d", len(got), len(data))
+			}
+
+			// A flipped payload bit must not pass the chunk's checksum
+			chunk := msg.Chunks[0]
+			chunk.Payload = append([]byte(nil), chunk.Payload...)
+			chunk.Payload[0] ^= 0x01
+			if err := c.VerifyIntegrity(&chunk); err == nil {
+				t.Errorf("corrupted chunk passed %s", name)
+			}
+		})
+	}
+}
@@ -0,0 +1,61 @@
+package chunker
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"math/rand/v2"
+)
+
+// ================================================================================
+// THEORY LESSON: Emission Order
+// ================================================================================
+//
+// Chunks are published and fetched slot by slot. Left alone, slot i holds
+// chunk i, so anyone decoding the traffic sees sequence numbers climb
+// 0, 1, 2, ... with the parity chunks neatly at the end - a pattern no
+// ordinary zone has. Shuffling permutes the chunks across the slots:
+//
+//   slot:      0   1   2   3   4   5
+//   sequence:  3   P0  0   5   2   1      (P0 = first parity chunk)
+//
+// The permutation is keyed with the message ID and, when one is configured,
+// the sender's secret, so it can be reproduced but not predicted. Receivers
+// don't need it: every header still carries its true sequence number and
+// reassembly already sorts by it. Data and parity chunks are interleaved
+// too, so losing a burst of consecutive slots spreads across FEC groups.
+// ================================================================================
+
+// ChunkOrder returns the keyed emission order for n chunks: slot i holds
+// chunk order[i]. The same message ID and key always give the same order.
+func ChunkOrder(messageID [16]byte, key []byte, n int) []int {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("simulacra-order"))
+	mac.Write(messageID[:])
+
+	var seed [32]byte
+	copy(seed[:], mac.Sum(nil))
+	rng := rand.New(rand.NewChaCha8(seed))
+
+	order := make([]int, n)
+	for i := range order {
+		order[i] = i
+	}
+	rng.Shuffle(n, func(i, j int) {
+		order[i], order[j] = order[j], order[i]
+	})
+	return order
+}
+
+// shuffleChunks places the chunks in their keyed emission order and renames
+// them by slot, so record names don't reveal the sequence either
+func (c *Chunker) shuffleChunks(chunks []Chunk, messageID [16]byte) []Chunk {
+	order := ChunkOrder(messageID, c.config.idKey(), len(chunks))
+
+	shuffled := make([]Chunk, len(chunks))
+	for slot, index := range order {
+		chunk := chunks[index]
+		chunk.RecordName = c.recordName("chunk", slot, chunk.Metadata.MessageID)
+		shuffled[slot] = chunk
+	}
+	return shuffled
+}
@@ -0,0 +1,88 @@
+package chunker
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+)
+
+// ================================================================================
+// DATA LABELS
+// One strict parser for the c- and m- labels every query for message data starts with
+// ================================================================================
+
+// LESSON: Names From the Network Are Hostile
+// A server reads the message ID out of whatever name a query carries.
+// Cutting "c-0-<id>" at its last dash works for the names we publish and
+// misroutes the rest: "c-" has no ID, "m-" an empty one, "c--x" a missing
+// index, "c-1-2-3-4-id" too many parts. Scanners and resolvers send names
+// like that all day, so every data label goes through one parser that
+// accepts exactly
+//
+//   c-<seq>-<id>          one chunk
+//   c-<first>-<last>-<id> a range of chunks (first <= last)
+//   m-<id>                the manifest
+//
+// with decimal indexes and an alphanumeric ID, and rejects everything else
+// with ErrMalformedLabel - answered REFUSED, without touching storage.
+// Labels that don't start with c- or m- aren't data at all
+// (ErrNotDataLabel): they may be template names or decoys.
+
+// MAX_MESSAGE_ID_LENGTH caps message IDs in labels (a label holds 63 characters)
+const MAX_MESSAGE_ID_LENGTH = 48
+
+var (
+	// ErrNotDataLabel means a label doesn't start with c- or m-
+	ErrNotDataLabel = errors.New("not a data label")
+
+	// ErrMalformedLabel means a c- or m- label breaks the layout
+	ErrMalformedLabel = errors.New("malformed data label")
+
+	// dataLabelPattern matches c-<seq>[-<last>]-<id> and m-<id>
+	dataLabelPattern = regexp.MustCompile(`^(?:c-([0-9]{1,9})(?:-([0-9]{1,9}))?|m)-([0-9A-Za-z]{1,` + strconv.Itoa(MAX_MESSAGE_ID_LENGTH) + `})$`)
+)
+
+// DataLabel is a parsed chunk or manifest label
+type DataLabel struct {
+	ChunkLabel      // Message ID, and the chunk range unless Manifest
+	Manifest   bool // m-<id>
+}
+
+// String formats the label as published: m-<id>, c-<seq>-<id> or
+// c-<first>-<last>-<id>
+func (dl DataLabel) String() string {
+	if dl.Manifest {
+		return "m-" + dl.MessageID
+	}
+	return RangeLabel(dl.First, dl.Last, dl.MessageID)
+}
+
+// ParseDataLabel strictly parses the first label of a data query
+func ParseDataLabel(label string) (DataLabel, error) {
+	if len(label) < 2 || (label[0] != 'c' && label[0] != 'm') || label[1] != '-' {
+		return DataLabel{}, ErrNotDataLabel
+	}
+
+	match := dataLabelPattern.FindStringSubmatch(label)
+	if match == nil {
+		return DataLabel{}, fmt.Errorf("%w: %q", ErrMalformedLabel, label)
+	}
+
+	dl := DataLabel{ChunkLabel: ChunkLabel{MessageID: match[3]}}
+	if label[0] == 'm' {
+		dl.Manifest = true
+		return dl, nil
+	}
+
+	// At most nine digits each, so these can't overflow
+	dl.First, _ = strconv.Atoi(match[1])
+	dl.Last = dl.First
+	if match[2] != "" {
+		dl.Last, _ = strconv.Atoi(match[2])
+		if dl.Last < dl.First {
+			return DataLabel{}, fmt.Errorf("%w: range %d-%d runs backwards", ErrMalformedLabel, dl.First, dl.Last)
+		}
+	}
+	return dl, nil
+}
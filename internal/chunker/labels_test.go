@@ -0,0 +1,54 @@
+package chunker
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// Labels near the edges of the layout, for the fuzzer to start from
+var dataLabelSeeds = []string{
+	"", "c", "m", "c-", "m-", "c--x", "c-1-", "c-1--x", "c--1-x", "c-1-2-3-4-id",
+	"c-0-abc123", "c-3-7-abc123", "c-7-3-abc123", "c-01-abc123", "c-5-5-abc123",
+	"m-abc123", "m-abc-123", "x-1-abc123", "C-1-abc123", "c-999999999-abc", "c-1234567890-abc",
+	"c-1-" + strings.Repeat("a", MAX_MESSAGE_ID_LENGTH), "c-1-" + strings.Repeat("a", MAX_MESSAGE_ID_LENGTH+1),
+	"m-" + strings.Repeat("Z9", 200),
+	"c-1-ümlaut", "m-訊息", "c-١-abc", "c-1-abc\x00", "m-\xff\xfe", "c-1-abc\n",
+}
+
+// Whatever the network sends, ParseDataLabel must answer without
+// panicking, and what it accepts must survive String and a second parse
+func FuzzParseDataLabel(f *testing.F) {
+	for _, seed := range dataLabelSeeds {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, label string) {
+		dl, err := ParseDataLabel(label)
+		if err != nil {
+			if !errors.Is(err, ErrNotDataLabel) && !errors.Is(err, ErrMalformedLabel) {
+				t.Fatalf("ParseDataLabel(%q) returned an unexpected error: %v", label, err)
+			}
+			return
+		}
+
+		if dl.MessageID == "" || len(dl.MessageID) > MAX_MESSAGE_ID_LENGTH {
+			t.Fatalf("ParseDataLabel(%q) accepted message ID %q", label, dl.MessageID)
+		}
+		if !dl.Manifest && (dl.First < 0 || dl.Last < dl.First) {
+			t.Fatalf("ParseDataLabel(%q) accepted range %d-%d", label, dl.First, dl.Last)
+		}
+
+		formatted := dl.String()
+		again, err := ParseDataLabel(formatted)
+		if err != nil {
+			t.Fatalf("%q parsed, but its formatted form %q doesn't: %v", label, formatted, err)
+		}
+		if again != dl {
+			t.Fatalf("%q parsed as %+v, its formatted form %q as %+v", label, dl, formatted, again)
+		}
+		if again.String() != formatted {
+			t.Fatalf("%q formats as %q, then as %q", label, formatted, again.String())
+		}
+	})
+}
@@ -0,0 +1,92 @@
+package chunker
+
+import (
+	"fmt"
+)
+
+// ================================================================================
+// LOGGING HOOKS
+// Lets library consumers silence or capture the chunker's progress output
+// ================================================================================
+
+// LESSON: Library Code Shouldn't Own stdout
+// The CLIs want the emoji progress report; a program embedding the chunker
+// usually wants nothing on stdout, or wants the numbers rather than the
+// prose. Every report is therefore an Event handed to a Logger:
+// 1. nil Logger (the default): ConsoleLogger prints the text as before
+// 2. DiscardLogger: silent operation
+// 3. LoggerFunc: receive Kind + Fields and do anything with them
+
+// EventKind identifies what a chunker event reports
+type EventKind string
+
+const (
+	EVENT_WARNING       EventKind = "warning"      // A setting fell back or input was skipped
+	EVENT_ANALYSIS      EventKind = "analysis"     // Chunking started
+	EVENT_COMPRESSION   EventKind = "compression"  // Pre-chunking compression result
+	EVENT_ENCRYPTION    EventKind = "encryption"   // Envelope sealed
+	EVENT_EXTENDED      EventKind = "extended"     // Extended header added or verified
+	EVENT_PLAN          EventKind = "plan"         // Chunk count and overhead
+	EVENT_FEC_ENCODED   EventKind = "fec_encoded"  // Parity chunks appended
+	EVENT_SHUFFLED      EventKind = "shuffled"     // Emission order permuted
+	EVENT_CHUNKED       EventKind = "chunked"      // Chunking finished
+	EVENT_REASSEMBLY    EventKind = "reassembly"   // Reassembly started
+	EVENT_FEC_RECOVERY  EventKind = "fec_recovery" // Rebuilding missing chunks from parity
+	EVENT_FEC_RECOVERED EventKind = "fec_recovered"
+	EVENT_DECRYPTED     EventKind = "decrypted"
+	EVENT_DECOMPRESSED  EventKind = "decompressed"
+	EVENT_REASSEMBLED   EventKind = "reassembled" // Reassembly finished
+)
+
+// Event is one progress report from the chunker
+type Event struct {
+	Kind   EventKind
+	Text   string                 // Human-readable report, as ConsoleLogger prints it
+	Fields map[string]interface{} // Machine-readable values (sizes, counts, names)
+}
+
+// Logger receives chunker events
+type Logger interface {
+	Log(event Event)
+}
+
+// LoggerFunc adapts a function to the Logger interface
+type LoggerFunc func(event Event)
+
+// Log calls f(event)
+func (f LoggerFunc) Log(event Event) {
+	f(event)
+}
+
+// ConsoleLogger prints event text to stdout (the default)
+type ConsoleLogger struct{}
+
+// Log prints the event's text
+func (ConsoleLogger) Log(event Event) {
+	fmt.Println(event.Text)
+}
+
+// DiscardLogger drops every event
+var DiscardLogger Logger = LoggerFunc(func(Event) {})
+
+// resolveLogger returns logger, or ConsoleLogger when it is nil
+func resolveLogger(logger Logger) Logger {
+	if logger == nil {
+		return ConsoleLogger{}
+	}
+	return logger
+}
+
+// logEvent builds an event from a format string and hands it to logger
+func logEvent(logger Logger, kind EventKind, fields map[string]interface{}, format string, args ...interface{}) {
+	logger.Log(Event{
+		Kind:   kind,
+		Text:   fmt.Sprintf(format, args...),
+		Fields: fields,
+	})
+}
+
+// log reports an event through the chunker's logger
+func (c *Chunker) log(kind EventKind, fields map[string]interface{}, format string, args ...interface{}) {
+	logEvent(c.logger, kind, fields, format, args...)
+}
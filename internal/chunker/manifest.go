@@ -0,0 +1,162 @@
+package chunker
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ================================================================================
+// THEORY LESSON: Structured Manifests
+// ================================================================================
+//
+// The text manifest grew one "; key=value" field at a time around a
+// "TOTAL:CHECKSUM:TIMESTAMP" core that can't change without breaking old
+// receivers, and nothing in it says which version wrote it. The structured
+// format is a versioned JSON document, base32 encoded so it survives every
+// path a manifest travels (TXT strings, zone files, archives) unescaped:
+//
+//   v2:<base32 of {"v":2,"id":"...","n":30,"ts":...,"sha256":"...",
+//                  "names":"c-{seq}-{id}.data","enc":"base32","z":"zstd"}>
+//
+// TXT strings hold 255 bytes, so the value is split across several strings
+// of one record like any long chunk. The chunk set digest and signature
+// are still appended as "; set=" and "; sig=" fields, so signing works the
+// same for both formats.
+//
+// Old receivers read the chunk count from the start of the text format
+// and fail on a structured manifest, which is why text stays the default:
+// senders opt in once their receivers understand version 2.
+// ================================================================================
+
+// Manifest formats
+const (
+	MANIFEST_FORMAT_TEXT       = "text"       // TOTAL:CHECKSUM:TIMESTAMP[; key=value...]
+	MANIFEST_FORMAT_STRUCTURED = "structured" // v2:<base32 JSON>
+
+	// MANIFEST_VERSION is the version of the structured format
+	MANIFEST_VERSION = 2
+
+	// MANIFEST_V2_PREFIX introduces a structured manifest
+	MANIFEST_V2_PREFIX = "v2:"
+)
+
+// manifestV2 is the JSON body of a structured manifest
+type manifestV2 struct {
+	Version     int      `json:"v"`
+	ID          string   `json:"id"`
+	Total       int      `json:"n"`
+	Timestamp   int64    `json:"ts"`
+	Digest      string   `json:"sha256,omitempty"`
+	Names       string   `json:"names,omitempty"`  // Chunk naming template
+	Keyed       bool     `json:"keyed,omitempty"`  // Names need the naming secret
+	Chunks      []string `json:"chunks,omitempty"` // Chunk names relative to the domain, without a template
+	Encoding    string   `json:"enc,omitempty"`
+	Compression string   `json:"z,omitempty"`
+	Sharded     bool     `json:"shards,omitempty"`
+	RecordType  string   `json:"rr,omitempty"`
+	TLVs        string   `json:"tlv,omitempty"` // EncodeTLVs form
+	Recipient   string   `json:"to,omitempty"`  // Client ID the message is addressed to
+}
+
+// ManifestFormatNames lists the supported manifest formats
+func ManifestFormatNames() []string {
+	return []string{MANIFEST_FORMAT_TEXT, MANIFEST_FORMAT_STRUCTURED}
+}
+
+// ParseManifestFormat resolves a format name ("" means text)
+func ParseManifestFormat(name string) (string, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "", MANIFEST_FORMAT_TEXT:
+		return MANIFEST_FORMAT_TEXT, nil
+	case MANIFEST_FORMAT_STRUCTURED, "v2":
+		return MANIFEST_FORMAT_STRUCTURED, nil
+	}
+	return "", fmt.Errorf("unknown manifest format %q (use %s)", name, strings.Join(ManifestFormatNames(), ", "))
+}
+
+// structuredValue encodes the manifest in the structured format
+func (m *DNSManifest) structuredValue() string {
+	body := manifestV2{
+		Version:     MANIFEST_VERSION,
+		ID:          m.MessageID,
+		Total:       m.TotalChunks,
+		Timestamp:   m.Timestamp.Unix(),
+		Digest:      m.Checksum,
+		Names:       m.NameTemplate,
+		Keyed:       m.KeyedNames,
+		Encoding:    m.Encoding,
+		Compression: m.Compression,
+		Sharded:     m.Sharded,
+		Recipient:   m.Recipient,
+	}
+	if m.NameTemplate == "" {
+		for _, name := range m.ChunkIDs {
+			body.Chunks = append(body.Chunks, strings.TrimSuffix(name, "."+m.Domain))
+		}
+	}
+	if m.RecordType != "" && m.RecordType != RECORD_TXT {
+		body.RecordType = strings.ToLower(m.RecordType)
+	}
+	if len(m.TLVs) > 0 {
+		body.TLVs = EncodeTLVs(m.TLVs)
+	}
+
+	data, _ := json.Marshal(body) // Strings, numbers and bools only
+	return MANIFEST_V2_PREFIX + strings.ToLower(base32NoPad.EncodeToString(data))
+}
+
+// parseStructured decodes a structured manifest into m, whose message ID
+// and domain are already set
+func parseStructured(value string, m *DNSManifest) error {
+	data, err := base32NoPad.DecodeString(strings.ToUpper(strings.TrimPrefix(value, MANIFEST_V2_PREFIX)))
+	if err != nil {
+		return fmt.Errorf("invalid structured manifest: %w", err)
+	}
+
+	var body manifestV2
+	if err := json.Unmarshal(data, &body); err != nil {
+		return fmt.Errorf("invalid structured manifest: %w", err)
+	}
+	if body.Version != MANIFEST_VERSION {
+		return fmt.Errorf("unsupported manifest version %d", body.Version)
+	}
+	if body.Total < 0 {
+		return fmt.Errorf("invalid manifest chunk count: %d", body.Total)
+	}
+	if m.MessageID == "" {
+		m.MessageID = body.ID
+	} else if !strings.EqualFold(body.ID, m.MessageID) {
+		return fmt.Errorf("manifest is for message %s, not %s", body.ID, m.MessageID)
+	}
+
+	m.Format = MANIFEST_FORMAT_STRUCTURED
+	m.TotalChunks = body.Total
+	m.Timestamp = time.Unix(body.Timestamp, 0)
+	m.Checksum = body.Digest
+	m.NameTemplate = body.Names
+	m.KeyedNames = body.Keyed
+	m.Encoding = body.Encoding
+	m.Compression = body.Compression
+	m.Sharded = body.Sharded
+	m.Recipient = body.Recipient
+
+	for _, name := range body.Chunks {
+		m.ChunkIDs = append(m.ChunkIDs, qualifyName(name, m.Domain))
+	}
+	if len(body.Chunks) > 0 && len(body.Chunks) != body.Total {
+		return fmt.Errorf("manifest lists %d chunk names for %d chunks", len(body.Chunks), body.Total)
+	}
+	if body.RecordType != "" {
+		if m.RecordType, err = ParseRecordType(body.RecordType); err != nil {
+			return fmt.Errorf("manifest %w", err)
+		}
+	}
+	if body.TLVs != "" {
+		if m.TLVs, err = DecodeTLVs(body.TLVs); err != nil {
+			return fmt.Errorf("manifest %w", err)
+		}
+	}
+	return nil
+}
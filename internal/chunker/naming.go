@@ -0,0 +1,194 @@
+package chunker
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ================================================================================
+// THEORY LESSON: Names an IDS Can't Match
+// ================================================================================
+//
+// c-17-b180fbe873abeeaf.data.example.com is easy to read and just as easy
+// to match: one regex on query logs finds every chunk of every message.
+// Naming templates can make chunk names look like anything else under a
+// zone, with two keyed placeholders:
+//
+//   {word}        - a pronounceable nonsense label ("kotemarisufa"), a
+//                   different one for every chunk
+//   {sub:a|b|c}   - one of several subdomains, rotated between chunks
+//
+//   e.g. {word}.{sub:cdn|img|static}  ->  dupokelavi.img.example.com
+//
+// Both come from HMAC-SHA256 over the message ID and chunk number, keyed
+// by an optional naming secret. Receivers rebuild the same names from the
+// manifest's template, so nothing is enumerated by guessing. {word} is a
+// keyed permutation of the chunk number (a small Feistel network), never a
+// truncated hash, so two chunks of one message can't collide.
+//
+// Without a secret, anyone holding the manifest can work out the names -
+// enough against pattern matching. With one (manifest "keyed=1"), names
+// can't be linked to the message without the secret as well.
+//
+// A zone file can add wildcard records under each subdomain chunks use, so
+// a probe for a made-up name gets an answer too instead of NXDOMAIN giving
+// the real chunk names away.
+// ================================================================================
+
+const (
+	// NAME_KEY_CONTEXT separates naming HMACs from other uses of a secret
+	NAME_KEY_CONTEXT = "simulacra-names-v1"
+
+	// WORD_ROUNDS is the number of Feistel rounds behind {word}
+	WORD_ROUNDS = 4
+
+	// wordHalfBits is the size of each Feistel half (48-bit words)
+	wordHalfBits = 24
+	wordHalfMask = 1<<wordHalfBits - 1
+)
+
+// Syllables are a consonant followed by a vowel: 80 of them
+const (
+	wordConsonants = "bdfghjklmnprstvz"
+	wordVowels     = "aeiou"
+)
+
+// subPattern matches a {sub:a|b|c} placeholder
+var subPattern = regexp.MustCompile(`\{sub:([^}]*)\}`)
+
+// ErrNameKeyRequired is returned when keyed chunk names are looked up
+// without the naming secret
+var ErrNameKeyRequired = errors.New("chunk names are keyed; the naming secret is needed to find them")
+
+// KeyedTemplate reports whether a template uses keyed placeholders
+func KeyedTemplate(template string) bool {
+	return strings.Contains(template, "{word}") || subPattern.MatchString(template)
+}
+
+// templateSubdomains returns the choices of a template's {sub:...} placeholder
+func templateSubdomains(template string) []string {
+	match := subPattern.FindStringSubmatch(template)
+	if match == nil {
+		return nil
+	}
+	return strings.Split(strings.ToLower(match[1]), "|")
+}
+
+// validateKeyedTemplate checks the {word} and {sub:...} placeholders
+func validateKeyedTemplate(template string) error {
+	if len(subPattern.FindAllString(template, -1)) > 1 {
+		return fmt.Errorf("name template %q has more than one {sub:...}", template)
+	}
+	for _, sub := range templateSubdomains(template) {
+		if !isDNSName(sub) {
+			return fmt.Errorf("name template %q: invalid subdomain %q", template, sub)
+		}
+	}
+	return nil
+}
+
+// expandKeyed fills the keyed placeholders of a template for chunk seq
+func expandKeyed(template string, seq int, msgID string, key []byte) string {
+	if strings.Contains(template, "{word}") {
+		template = strings.ReplaceAll(template, "{word}", chunkWord(seq, msgID, key))
+	}
+	if subs := templateSubdomains(template); len(subs) > 0 {
+		pick := nameHash(key, msgID, "sub", uint64(seq)) % uint32(len(subs))
+		template = subPattern.ReplaceAllLiteralString(template, subs[pick])
+	}
+	return template
+}
+
+// chunkWord renders the keyed permutation of seq as syllables
+func chunkWord(seq int, msgID string, key []byte) string {
+	left := uint32(seq>>wordHalfBits) & wordHalfMask
+	right := uint32(seq) & wordHalfMask
+	for round := 0; round < WORD_ROUNDS; round++ {
+		f := nameHash(key, msgID, "word"+strconv.Itoa(round), uint64(right)) & wordHalfMask
+		left, right = right, left^f
+	}
+
+	value := uint64(left)<<wordHalfBits | uint64(right)
+	var word strings.Builder
+	for {
+		syllable := value % uint64(len(wordConsonants)*len(wordVowels))
+		word.WriteByte(wordConsonants[syllable/uint64(len(wordVowels))])
+		word.WriteByte(wordVowels[syllable%uint64(len(wordVowels))])
+		value /= uint64(len(wordConsonants) * len(wordVowels))
+		if value == 0 {
+			return word.String()
+		}
+	}
+}
+
+// nameHash is the naming PRF: HMAC-SHA256 over the message ID, a purpose
+// label and a value, truncated to 32 bits
+func nameHash(key []byte, msgID, purpose string, value uint64) uint32 {
+	mac := hmac.New(sha256.New, append([]byte(NAME_KEY_CONTEXT), key...))
+	mac.Write([]byte(msgID))
+	mac.Write([]byte{0})
+	mac.Write([]byte(purpose))
+	mac.Write(binary.BigEndian.AppendUint64([]byte{0}, value))
+	return binary.BigEndian.Uint32(mac.Sum(nil))
+}
+
+// isDNSName reports whether s is a relative name of valid LDH labels
+func isDNSName(s string) bool {
+	for _, label := range strings.Split(s, ".") {
+		if label == "" || len(label) > MAX_LABEL_SIZE || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for i := 0; i < len(label); i++ {
+			if !isAlphanumeric(label[i]) && label[i] != '-' {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// SetNameKey gives the manifest the secret its chunk names were keyed
+// with; it fails if the names are keyed and no secret is given
+func (m *DNSManifest) SetNameKey(key []byte) error {
+	if m.KeyedNames && len(key) == 0 {
+		return ErrNameKeyRequired
+	}
+	if m.KeyedNames {
+		m.NameKey = key
+	}
+	return nil
+}
+
+// wildcardRecords returns a decoy TXT wildcard under every parent of the
+// given chunk names, each answering with a value as long as a chunk. The
+// manifests' subdomain is left out: a missing manifest must stay NXDOMAIN.
+func (de *DNSEncoder) wildcardRecords(names []string, valueLen int) []DNSRecord {
+	var records []DNSRecord
+	seen := map[string]bool{de.domain: true, de.subdomain + "." + de.domain: true}
+	for _, name := range names {
+		_, parent, _ := strings.Cut(name, ".")
+		if parent == "" || seen[parent] {
+			continue
+		}
+		seen[parent] = true
+
+		decoy := make([]byte, base32NoPad.DecodedLen(valueLen))
+		rand.Read(decoy)
+		value := strings.ToLower(base32NoPad.EncodeToString(decoy))
+		records = append(records, DNSRecord{
+			Name:    "*." + parent,
+			Type:    RECORD_TXT,
+			TTL:     de.ttl.ChunkTTL(),
+			Value:   value,
+			Strings: SplitTXT(value),
+		})
+	}
+	return records
+}
@@ -0,0 +1,57 @@
+package chunker
+
+import (
+	"sync"
+)
+
+// ================================================================================
+// PARALLEL CHUNK ENCODING
+// Spreads checksumming, tagging and text encoding over a worker pool
+// ================================================================================
+
+// LESSON: Why Order Stays Deterministic
+// Every chunk is a pure function of the payload slice it covers, so workers
+// need no coordination beyond handing out sequence numbers. Each worker
+// writes its result into the slot of a preallocated slice, never appending,
+// so Message.Chunks comes out in sequence order whichever worker finishes
+// first - identical to the serial path. Small messages stay serial: below
+// MIN_PARALLEL_CHUNKS, starting goroutines costs more than it saves.
+
+// MIN_PARALLEL_CHUNKS is the smallest message encoded by the worker pool
+const MIN_PARALLEL_CHUNKS = 64
+
+// createChunks creates every data chunk of a message, in sequence order
+func (c *Chunker) createChunks(data []byte, messageID [16]byte, total, payloadSize int, compression CompressionAlgorithm, encrypted, extended bool) []Chunk {
+	chunks := make([]Chunk, total)
+
+	workers := c.config.Workers
+	if workers > total {
+		workers = total
+	}
+	if workers <= 1 || total < MIN_PARALLEL_CHUNKS {
+		for i := range chunks {
+			chunks[i] = c.createChunk(data, messageID, i, uint32(total), payloadSize, compression, encrypted, extended)
+		}
+		return chunks
+	}
+
+	sequences := make(chan int, workers)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range sequences {
+				chunks[i] = c.createChunk(data, messageID, i, uint32(total), payloadSize, compression, encrypted, extended)
+			}
+		}()
+	}
+
+	for i := 0; i < total; i++ {
+		sequences <- i
+	}
+	close(sequences)
+	wg.Wait()
+
+	return chunks
+}
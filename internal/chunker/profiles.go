@@ -0,0 +1,196 @@
+package chunker
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ================================================================================
+// THEORY LESSON: Sizing Profiles
+// ================================================================================
+//
+// How much one chunk may hold depends on where it travels, not on the
+// chunker. SAFE_CHUNK_SIZE assumed a single TXT string in a plain UDP
+// answer; other carriers have very different budgets:
+//
+//   PROFILE     ENCODED LIMIT   CARRIER
+//   txt-255     240             One TXT string, classic 512-byte UDP
+//   txt-multi   960             4 TXT strings, fits the 1232-byte EDNS0 default
+//   edns-4096   3840            16 TXT strings, needs a 4096-byte EDNS0 buffer
+//   qname-63    63              One DNS label (letters, digits, hyphen only)
+//   qname-multi 180             Three labels of a query name (QNAME uploads)
+//
+// A profile fixes the encoded chunk size, and everything else - payload per
+// chunk, chunk count, overhead - is derived from it. Labels are also case
+// insensitive and can't hold base64/base91 characters, so a profile lists
+// the encodings it accepts.
+//
+// Small profiles can leave no room at all once the header, FEC header and
+// authentication tag are paid for; ChunkMessage refuses those instead of
+// producing chunks that don't fit.
+// ================================================================================
+
+const (
+	PROFILE_TXT_255     = "txt-255"
+	PROFILE_TXT_MULTI   = "txt-multi"
+	PROFILE_EDNS_4096   = "edns-4096"
+	PROFILE_QNAME_63    = "qname-63"
+	PROFILE_QNAME_MULTI = "qname-multi"
+
+	// DEFAULT_PROFILE matches the original single-string sizing
+	DEFAULT_PROFILE = PROFILE_TXT_255
+
+	// MAX_LABEL_SIZE is the DNS limit for one label
+	MAX_LABEL_SIZE = 63
+)
+
+// SizingProfile describes the space one chunk may occupy on its carrier
+type SizingProfile struct {
+	Name        string
+	Description string
+	ChunkSize   int      // Encoded characters per chunk (our budget)
+	Strings     int      // TXT strings per record (1 for labels)
+	Limit       int      // Hard protocol limit on the encoded length
+	EDNS0       bool     // Answers need an EDNS0 buffer above 512 bytes
+	Encodings   []string // Encodings that are valid on this carrier (nil = all)
+}
+
+// sizingProfiles are the named profiles
+var sizingProfiles = map[string]SizingProfile{
+	PROFILE_TXT_255:   txtProfile(PROFILE_TXT_255, "single TXT string, plain UDP", 1),
+	PROFILE_TXT_MULTI: txtProfile(PROFILE_TXT_MULTI, "4 TXT strings, 1232-byte EDNS0", 4),
+	PROFILE_EDNS_4096: txtProfile(PROFILE_EDNS_4096, "16 TXT strings, 4096-byte EDNS0", MAX_TXT_STRINGS),
+	PROFILE_QNAME_63: {
+		Name:        PROFILE_QNAME_63,
+		Description: "one DNS label in the query name",
+		ChunkSize:   MAX_LABEL_SIZE,
+		Strings:     1,
+		Limit:       MAX_LABEL_SIZE,
+		Encodings:   []string{ENCODE_BASE32, ENCODE_HEX},
+	},
+	PROFILE_QNAME_MULTI: {
+		Name:        PROFILE_QNAME_MULTI,
+		Description: "three labels of an upload query name",
+		ChunkSize:   3 * (MAX_LABEL_SIZE - 3),
+		Strings:     1,
+		Limit:       3 * MAX_LABEL_SIZE,
+		Encodings:   []string{ENCODE_BASE32, ENCODE_HEX},
+	},
+}
+
+// txtProfile builds a profile packing n SAFE_CHUNK_SIZE strings per record
+func txtProfile(name, description string, n int) SizingProfile {
+	return SizingProfile{
+		Name:        name,
+		Description: description,
+		ChunkSize:   SAFE_CHUNK_SIZE * n,
+		Strings:     n,
+		Limit:       MAX_DNS_STRING_SIZE * n,
+		EDNS0:       n > 1,
+	}
+}
+
+// ProfileNames lists the named sizing profiles
+func ProfileNames() []string {
+	names := make([]string, 0, len(sizingProfiles))
+	for name := range sizingProfiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// LookupProfile returns a named sizing profile
+func LookupProfile(name string) (SizingProfile, error) {
+	profile, ok := sizingProfiles[strings.ToLower(strings.TrimSpace(name))]
+	if !ok {
+		return SizingProfile{}, fmt.Errorf("unknown sizing profile %q (supported: %s)",
+			name, strings.Join(ProfileNames(), ", "))
+	}
+	return profile, nil
+}
+
+// TXTProfile returns the profile for n TXT strings per record,
+// naming it after a matching preset when there is one
+func TXTProfile(n int) SizingProfile {
+	if n < 1 {
+		n = 1
+	}
+	if n > MAX_TXT_STRINGS {
+		n = MAX_TXT_STRINGS
+	}
+
+	for _, profile := range sizingProfiles {
+		if profile.Limit == MAX_DNS_STRING_SIZE*n {
+			return profile
+		}
+	}
+	return txtProfile(fmt.Sprintf("txt-x%d", n), fmt.Sprintf("%d TXT strings", n), n)
+}
+
+// ProfileForChunkSize maps an advertised chunk size back to a profile.
+// Receivers configured from capabilities only know the size.
+func ProfileForChunkSize(size int) SizingProfile {
+	for _, profile := range sizingProfiles {
+		if profile.ChunkSize == size {
+			return profile
+		}
+	}
+	return TXTProfile(size / SAFE_CHUNK_SIZE)
+}
+
+// AllowsEncoding reports whether chunks in an encoding are valid on the carrier
+func (p SizingProfile) AllowsEncoding(encoding string) bool {
+	if p.Encodings == nil {
+		return true
+	}
+	for _, name := range p.Encodings {
+		if name == encoding {
+			return true
+		}
+	}
+	return false
+}
+
+// Fits checks an encoded chunk against the profile's hard limit
+func (p SizingProfile) Fits(encoded string) error {
+	if len(encoded) > p.Limit {
+		return fmt.Errorf("encoded chunk is %d characters, profile %s allows %d",
+			len(encoded), p.Name, p.Limit)
+	}
+	return nil
+}
+
+// String describes the profile for display
+func (p SizingProfile) String() string {
+	return fmt.Sprintf("%s (%d chars, %s)", p.Name, p.ChunkSize, p.Description)
+}
+
+// resolveProfile picks the sizing profile for a configuration: an explicit
+// Profile wins, then StringsPerRecord, then MaxChunkSize
+func resolveProfile(config ChunkerConfig, logger Logger) SizingProfile {
+	if config.Profile != "" {
+		profile, err := LookupProfile(config.Profile)
+		if err == nil {
+			return profile
+		}
+		logEvent(logger, EVENT_WARNING, map[string]interface{}{"setting": "profile", "value": config.Profile, "fallback": DEFAULT_PROFILE},
+			"⚠️  %v, using %s", err, DEFAULT_PROFILE)
+		return sizingProfiles[DEFAULT_PROFILE]
+	}
+
+	if config.StringsPerRecord > 0 {
+		return TXTProfile(config.StringsPerRecord)
+	}
+	if config.MaxChunkSize > 0 {
+		return ProfileForChunkSize(config.MaxChunkSize)
+	}
+
+	return sizingProfiles[DEFAULT_PROFILE]
+}
+
+// Profile returns the sizing profile the chunker uses
+func (c *Chunker) Profile() SizingProfile {
+	return c.profile
+}
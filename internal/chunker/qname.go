@@ -0,0 +1,404 @@
+package chunker
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// ================================================================================
+// THEORY LESSON: Data in the Query Name
+// ================================================================================
+//
+// Everywhere else our data rides in answers: the publisher stores chunks on
+// the server, receivers query for them. The classic exfiltration channel
+// runs the other way - the data is the question:
+//
+//   <data>.<data>.<data>.<index>.u-<msgid>.<domain>     TXT? (or A?)
+//
+// Any resolver forwards the query to the authoritative server for <domain>,
+// which reads the chunk out of the name and answers with nothing more than
+// an acknowledgement. No HTTP, no direct connection to the server: upload
+// and download both ride DNS.
+//
+// 1. A chunk is cut into labels of at most 63 characters; the whole name
+//    may not exceed 253, so chunks must be sized for it (qname-63 or
+//    qname-multi profiles)
+// 2. Names are case-insensitive and resolvers may randomize case (0x20),
+//    so only hex and base32 survive, and the server restores the case
+// 3. The manifest travels the same way as base32 under the label "m", and
+//    tells the server how many chunks to wait for; the upload options (tags,
+//    burn after reading, priority) travel under "o", before the manifest
+// 4. Once every chunk and the manifest arrived, the server publishes the
+//    message exactly as if it had been uploaded over HTTP
+//
+// A TXT query is acknowledged with a TXT record that also reports how far
+// the server got, so a sender can tell when parts were lost on its side
+// (a restart, an expired upload) and send them again. An A query gets an
+// address:
+//
+//   TXT ANSWER                      A ANSWER     MEANING
+//   "status=ack chunks=3 total=10"  127.0.0.1    Part stored, message incomplete
+//   "status=complete"               127.0.0.2    Message complete and published
+//   REFUSED                         REFUSED      Not a valid upload query
+//
+// total=0 means the manifest hasn't arrived yet.
+//
+// Anyone who can send the server a query could upload this way, so a
+// server can require a shared upload key: the sender then appends an HMAC
+// of the query to the message label,
+//
+//   <data>.<index>.u-<msgid>-<mac>.<domain>
+//
+// where mac is the first QNAME_MAC_SIZE bytes, in hex, of HMAC-SHA256 over
+// the domain, message ID, index label and case-folded data. The server
+// refuses queries whose mac is missing or wrong. Resending a signed query
+// only repeats a part the server already holds, so replays gain nothing.
+// ================================================================================
+
+const (
+	// QNAME_UPLOAD_PREFIX starts the label naming the message being uploaded
+	QNAME_UPLOAD_PREFIX = "u-"
+
+	// QNAME_MANIFEST_LABEL is the index label of the manifest part
+	QNAME_MANIFEST_LABEL = "m"
+
+	// QNAME_OPTIONS_LABEL is the index label of the upload options part
+	QNAME_OPTIONS_LABEL = "o"
+
+	// QNAME_INDEX_MANIFEST and QNAME_INDEX_OPTIONS are the Index of the
+	// manifest and options parts
+	QNAME_INDEX_MANIFEST = -1
+	QNAME_INDEX_OPTIONS  = -2
+
+	// QNAME_STATUS_ACK and QNAME_STATUS_COMPLETE are the status of a TXT
+	// acknowledgement
+	QNAME_STATUS_ACK      = "ack"
+	QNAME_STATUS_COMPLETE = "complete"
+
+	// MAX_QNAME_LENGTH is the DNS limit for a name in presentation format
+	MAX_QNAME_LENGTH = 253
+
+	// QNAME_MAC_SIZE is how many bytes of the HMAC a signed upload query
+	// carries (twice as many hex characters)
+	QNAME_MAC_SIZE = 6
+)
+
+var (
+	// QNAME_ACK acknowledges a stored part of an incomplete message
+	QNAME_ACK = net.IPv4(127, 0, 0, 1)
+
+	// QNAME_COMPLETE acknowledges the part that completed the message
+	QNAME_COMPLETE = net.IPv4(127, 0, 0, 2)
+
+	// ErrNotQNameUpload means a query name isn't an upload query
+	ErrNotQNameUpload = errors.New("not a QNAME upload query")
+
+	// ErrQNameSignature means an upload query's mac is missing or wrong
+	ErrQNameSignature = errors.New("upload query not signed with the upload key")
+
+	// manifestEncoding carries manifest text in labels
+	manifestEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+)
+
+// QNameEncoder builds and parses upload query names under a domain
+type QNameEncoder struct {
+	domain string
+	key    []byte // Upload key signing every query (nil = unsigned)
+}
+
+// NewQNameEncoder creates an encoder for uploads to domain; with a key,
+// queries are signed and ParseQuery refuses unsigned ones
+func NewQNameEncoder(domain string, key []byte) *QNameEncoder {
+	return &QNameEncoder{domain: strings.ToLower(strings.TrimSuffix(domain, ".")), key: key}
+}
+
+// Signed reports whether the encoder signs and verifies queries
+func (qe *QNameEncoder) Signed() bool {
+	return len(qe.key) > 0
+}
+
+// QNameUpload is one parsed upload query
+type QNameUpload struct {
+	MessageID string
+	Index     int    // Chunk slot in manifest order, QNAME_INDEX_MANIFEST or QNAME_INDEX_OPTIONS
+	Data      string // Encoded chunk with its case restored, or the manifest or options text
+}
+
+// IsManifest reports whether the query carried the manifest
+func (u *QNameUpload) IsManifest() bool {
+	return u.Index == QNAME_INDEX_MANIFEST
+}
+
+// IsOptions reports whether the query carried the upload options
+func (u *QNameUpload) IsOptions() bool {
+	return u.Index == QNAME_INDEX_OPTIONS
+}
+
+// suffix returns the labels after the data: <index>.u-<msgid>[-<mac>].<domain>
+func (qe *QNameEncoder) suffix(msgID, index, data string) string {
+	label := QNAME_UPLOAD_PREFIX + strings.ToLower(msgID)
+	if qe.Signed() {
+		label += "-" + qe.mac(msgID, index, data)
+	}
+	return fmt.Sprintf("%s.%s.%s", index, label, qe.domain)
+}
+
+// mac signs one upload part with the upload key
+func (qe *QNameEncoder) mac(msgID, index, data string) string {
+	h := hmac.New(sha256.New, qe.key)
+	for _, field := range []string{qe.domain, strings.ToLower(msgID), index, data} {
+		h.Write([]byte(field))
+		h.Write([]byte{0}) // Keeps "ab"+"c" apart from "a"+"bc"
+	}
+	return hex.EncodeToString(h.Sum(nil)[:QNAME_MAC_SIZE])
+}
+
+// Capacity returns how many data characters fit in the query for a chunk slot
+func (qe *QNameEncoder) Capacity(msgID string, index int) int {
+	free := MAX_QNAME_LENGTH - len(qe.suffix(msgID, strconv.Itoa(index), ""))
+	// Every label costs a dot, data labels hold up to MAX_LABEL_SIZE
+	capacity := free / (MAX_LABEL_SIZE + 1) * MAX_LABEL_SIZE
+	if rest := free % (MAX_LABEL_SIZE + 1); rest > 1 {
+		capacity += rest - 1
+	}
+	return capacity
+}
+
+// ChunkQuery returns the query name uploading an encoded chunk to slot index
+func (qe *QNameEncoder) ChunkQuery(msgID string, index int, encoded string) (string, error) {
+	return qe.query(msgID, strconv.Itoa(index), strings.ToLower(encoded))
+}
+
+// ManifestQuery returns the query name uploading a message's manifest value
+func (qe *QNameEncoder) ManifestQuery(msgID, manifest string) (string, error) {
+	return qe.query(msgID, QNAME_MANIFEST_LABEL, strings.ToLower(manifestEncoding.EncodeToString([]byte(manifest))))
+}
+
+// OptionsQuery returns the query name uploading a message's options
+func (qe *QNameEncoder) OptionsQuery(msgID string, options QNameOptions) (string, error) {
+	return qe.query(msgID, QNAME_OPTIONS_LABEL, strings.ToLower(manifestEncoding.EncodeToString([]byte(options.String()))))
+}
+
+// query splits data into labels in front of the suffix
+func (qe *QNameEncoder) query(msgID, index, data string) (string, error) {
+	if data == "" {
+		return "", errors.New("nothing to upload")
+	}
+
+	labels := make([]string, 0, len(data)/MAX_LABEL_SIZE+1)
+	for len(data) > MAX_LABEL_SIZE {
+		labels = append(labels, data[:MAX_LABEL_SIZE])
+		data = data[MAX_LABEL_SIZE:]
+	}
+	labels = append(labels, data)
+
+	name := strings.Join(labels, ".") + "." + qe.suffix(msgID, index, strings.Join(labels, ""))
+	if len(name) > MAX_QNAME_LENGTH {
+		return "", fmt.Errorf("query name for part %s is %d characters, max %d (use a smaller sizing profile or a shorter domain)",
+			index, len(name), MAX_QNAME_LENGTH)
+	}
+	return name, nil
+}
+
+// ParseQuery extracts an upload from a query name. Names outside the
+// upload layout return ErrNotQNameUpload; malformed uploads other errors.
+func (qe *QNameEncoder) ParseQuery(qname string) (*QNameUpload, error) {
+	qname = strings.ToLower(strings.TrimSuffix(qname, "."))
+	rest, ok := strings.CutSuffix(qname, "."+qe.domain)
+	if !ok {
+		return nil, ErrNotQNameUpload
+	}
+
+	labels := strings.Split(rest, ".")
+	if len(labels) < 3 || !strings.HasPrefix(labels[len(labels)-1], QNAME_UPLOAD_PREFIX) {
+		return nil, ErrNotQNameUpload
+	}
+
+	upload := &QNameUpload{MessageID: strings.TrimPrefix(labels[len(labels)-1], QNAME_UPLOAD_PREFIX)}
+	data := strings.Join(labels[:len(labels)-2], "")
+	indexLabel := labels[len(labels)-2]
+
+	if qe.Signed() {
+		msgID, mac, found := cutLast(upload.MessageID, "-")
+		if !found || !hmac.Equal([]byte(mac), []byte(qe.mac(msgID, indexLabel, data))) {
+			return nil, ErrQNameSignature
+		}
+		upload.MessageID = msgID
+	}
+	if upload.MessageID == "" {
+		return nil, errors.New("upload query without message ID")
+	}
+
+	switch indexLabel {
+	case QNAME_MANIFEST_LABEL, QNAME_OPTIONS_LABEL:
+		text, err := manifestEncoding.DecodeString(strings.ToUpper(data))
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s encoding: %w", indexLabel, err)
+		}
+		upload.Index = QNAME_INDEX_MANIFEST
+		if indexLabel == QNAME_OPTIONS_LABEL {
+			upload.Index = QNAME_INDEX_OPTIONS
+		}
+		upload.Data = string(text)
+		return upload, nil
+	}
+
+	index, err := strconv.Atoi(indexLabel)
+	if err != nil || index < 0 {
+		return nil, fmt.Errorf("invalid chunk index %q", indexLabel)
+	}
+	upload.Index = index
+
+	upload.Data, err = restoreLabelCase(data)
+	if err != nil {
+		return nil, fmt.Errorf("chunk %d: %w", index, err)
+	}
+	return upload, nil
+}
+
+// cutLast slices s around the last instance of sep
+func cutLast(s, sep string) (before, after string, found bool) {
+	if i := strings.LastIndex(s, sep); i >= 0 {
+		return s[:i], s[i+len(sep):], true
+	}
+	return s, "", false
+}
+
+// restoreLabelCase recovers a chunk's encoded form from case-folded labels:
+// hex chunks are lowercase, base32 chunks uppercase. The decoded bytes must
+// start with a chunk magic, which also rejects anything that isn't a chunk.
+func restoreLabelCase(data string) (string, error) {
+	candidates := []struct {
+		text   string
+		decode func(string) ([]byte, error)
+	}{
+		{strings.ToLower(data), hex.DecodeString},
+		{strings.ToUpper(data), base32NoPad.DecodeString},
+	}
+
+	for _, candidate := range candidates {
+		raw, err := candidate.decode(candidate.text)
+		if err == nil && len(raw) >= 4 && isChunkMagic(binary.BigEndian.Uint32(raw[:4])) {
+			return candidate.text, nil
+		}
+	}
+	return "", errors.New("labels don't hold a hex or base32 chunk")
+}
+
+// QNameOptions are the upload settings an HTTP upload sends alongside the
+// chunks
+type QNameOptions struct {
+	Tags       []string // Labels receivers can filter discovery by
+	Burn       bool     // Delete the message once it was fetched
+	Priority   string   // Discovery priority level ("" = normal)
+	Visibility string   // Redelivery timeout, e.g. "10m" ("" = server default)
+	Recipient  string   // Client ID the message is addressed to ("" = any receiver)
+}
+
+// IsZero reports whether no option is set, so the part can be left out
+func (o QNameOptions) IsZero() bool {
+	return len(o.Tags) == 0 && !o.Burn && o.Priority == "" && o.Visibility == "" && o.Recipient == ""
+}
+
+// String renders the options as sent: "tags=a,b burn=1 priority=high
+// visibility=10m to=receiver2"
+func (o QNameOptions) String() string {
+	var fields []string
+	if len(o.Tags) > 0 {
+		fields = append(fields, "tags="+strings.Join(o.Tags, ","))
+	}
+	if o.Burn {
+		fields = append(fields, "burn=1")
+	}
+	if o.Priority != "" {
+		fields = append(fields, "priority="+o.Priority)
+	}
+	if o.Visibility != "" {
+		fields = append(fields, "visibility="+o.Visibility)
+	}
+	if o.Recipient != "" {
+		fields = append(fields, "to="+o.Recipient)
+	}
+	return strings.Join(fields, " ")
+}
+
+// ParseQNameOptions reads options rendered by String; tags, priority,
+// visibility and recipient are returned as sent, for the server to validate
+func ParseQNameOptions(text string) (QNameOptions, error) {
+	var options QNameOptions
+	for _, field := range strings.Fields(text) {
+		key, value, _ := strings.Cut(field, "=")
+		switch key {
+		case "tags":
+			options.Tags = strings.Split(value, ",")
+		case "burn":
+			burn, err := strconv.ParseBool(value)
+			if err != nil {
+				return options, fmt.Errorf("invalid burn option %q", value)
+			}
+			options.Burn = burn
+		case "priority":
+			options.Priority = value
+		case "visibility":
+			options.Visibility = value
+		case "to":
+			options.Recipient = value
+		default:
+			return options, fmt.Errorf("unknown upload option %q", key)
+		}
+	}
+	return options, nil
+}
+
+// QNameAck is the TXT acknowledgement of an upload query
+type QNameAck struct {
+	Complete bool // The message is published
+	Chunks   int  // Chunks the server holds (while incomplete)
+	Total    int  // Chunks the manifest announced (0 = no manifest yet)
+}
+
+// String renders the acknowledgement as TXT data
+func (a QNameAck) String() string {
+	if a.Complete {
+		return "status=" + QNAME_STATUS_COMPLETE
+	}
+	return fmt.Sprintf("status=%s chunks=%d total=%d", QNAME_STATUS_ACK, a.Chunks, a.Total)
+}
+
+// ParseQNameAck reads a TXT acknowledgement
+func ParseQNameAck(text string) (QNameAck, error) {
+	var ack QNameAck
+	status := ""
+	for _, field := range strings.Fields(text) {
+		key, value, _ := strings.Cut(field, "=")
+		var err error
+		switch key {
+		case "status":
+			status = value
+		case "chunks":
+			ack.Chunks, err = strconv.Atoi(value)
+		case "total":
+			ack.Total, err = strconv.Atoi(value)
+		}
+		if err != nil {
+			return ack, fmt.Errorf("invalid acknowledgement field %q", field)
+		}
+	}
+
+	switch status {
+	case QNAME_STATUS_COMPLETE:
+		ack.Complete = true
+	case QNAME_STATUS_ACK:
+	default:
+		return ack, fmt.Errorf("not an upload acknowledgement: %q", text)
+	}
+	return ack, nil
+}
@@ -0,0 +1,59 @@
+package chunker
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestSignedQNameQueries(t *testing.T) {
+	key := []byte("upload key")
+	sender := NewQNameEncoder("covert.example.com", key)
+	msg, err := NewChunker(testConfig(ENCODE_BASE32, "", 0)).ChunkMessage([]byte("signed upload"))
+	if err != nil {
+		t.Fatalf("ChunkMessage: %v", err)
+	}
+	query, err := sender.ChunkQuery("msg-1", 0, msg.Chunks[0].Encoded)
+	if err != nil {
+		t.Fatalf("ChunkQuery: %v", err)
+	}
+
+	// Resolvers may randomize case: the signature has to survive it
+	upload, err := NewQNameEncoder("covert.example.com.", key).ParseQuery(strings.ToUpper(query))
+	if err != nil {
+		t.Fatalf("ParseQuery: %v", err)
+	}
+	if upload.MessageID != "msg-1" || upload.Index != 0 || upload.Data != msg.Chunks[0].Encoded {
+		t.Errorf("parsed %s/%d %q, want msg-1/0 %q", upload.MessageID, upload.Index, upload.Data, msg.Chunks[0].Encoded)
+	}
+
+	unsigned, err := NewQNameEncoder("covert.example.com", nil).ChunkQuery("msg-1", 0, msg.Chunks[0].Encoded)
+	if err != nil {
+		t.Fatalf("ChunkQuery: %v", err)
+	}
+	moved := strings.Replace(query, ".0.u-", ".1.u-", 1) // Signed for slot 0, sent as slot 1
+	edited := []byte(query)
+	edited[0] ^= 1
+	rejected := map[string]string{
+		"unsigned":    unsigned,
+		"wrong key":   mustQuery(t, NewQNameEncoder("covert.example.com", []byte("other key")), msg.Chunks[0].Encoded),
+		"other slot":  moved,
+		"other zone":  strings.Replace(mustQuery(t, NewQNameEncoder("other.example.com", key), msg.Chunks[0].Encoded), "other.", "covert.", 1),
+		"edited data": string(edited),
+	}
+	for name, query := range rejected {
+		if _, err := sender.ParseQuery(query); !errors.Is(err, ErrQNameSignature) {
+			t.Errorf("%s: ParseQuery returned %v, want ErrQNameSignature", name, err)
+		}
+	}
+}
+
+// mustQuery builds the upload query for chunk 0 of msg-1
+func mustQuery(t *testing.T, qe *QNameEncoder, encoded string) string {
+	t.Helper()
+	query, err := qe.ChunkQuery("msg-1", 0, encoded)
+	if err != nil {
+		t.Fatalf("ChunkQuery: %v", err)
+	}
+	return query
+}
@@ -0,0 +1,173 @@
+package chunker
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ================================================================================
+// THEORY LESSON: Delivery Receipts
+// ================================================================================
+//
+// Publishing is fire and forget: the sender never learns whether anyone
+// fetched the message, let alone decrypted it. A receipt closes the loop
+// over the same DNS channel, in the reverse direction:
+//
+// 1. The receiver signs a 14-byte receipt with the shared secret
+// 2. It sends it as the first label of a query:
+//      <token>.rcpt-<msgid>.<domain>     (token = base32 of receipt + tag)
+// 3. The server only relays it: it can't verify the signature, and it keeps
+//    the latest receipt per message it actually serves
+// 4. The sender polls TXT rcpt-<msgid>.<domain> and verifies the tag
+//
+// RECEIPT FORMAT:
+// [VERSION(1)][STATUS(1)][UNIX TIME(4)][SHA-256 PREFIX(8)][TAG(16)]
+// The digest covers the reassembled data, so the sender can check that the
+// bytes it published are the bytes that arrived. The tag binds the message
+// ID too, so a receipt can't be replayed for another message.
+// ================================================================================
+
+const (
+	// RECEIPT_VERSION is the receipt layout written by this build
+	RECEIPT_VERSION = 1
+
+	// RECEIPT_SIZE is a receipt without its tag
+	RECEIPT_SIZE = 1 + 1 + 4 + 8
+
+	// RECEIPT_LABEL_PREFIX starts the label naming a message's receipt
+	RECEIPT_LABEL_PREFIX = "rcpt-"
+)
+
+// ReceiptStatus is how far processing got at the receiver
+type ReceiptStatus byte
+
+const (
+	RECEIPT_DELIVERED ReceiptStatus = 'd' // Reassembled and verified
+	RECEIPT_DECODED   ReceiptStatus = 'x' // Stego payload extracted and decrypted
+	RECEIPT_FAILED    ReceiptStatus = 'f' // Reassembled, but decoding failed
+)
+
+// String names the status for display
+func (s ReceiptStatus) String() string {
+	switch s {
+	case RECEIPT_DELIVERED:
+		return "delivered"
+	case RECEIPT_DECODED:
+		return "decoded"
+	case RECEIPT_FAILED:
+		return "decode failed"
+	}
+	return fmt.Sprintf("unknown(%c)", byte(s))
+}
+
+// ErrBadReceipt means a receipt's tag didn't verify
+var ErrBadReceipt = errors.New("receipt signature invalid")
+
+// Receipt confirms what a receiver did with a message
+type Receipt struct {
+	MessageID string
+	Status    ReceiptStatus
+	Time      time.Time
+	Digest    [8]byte // SHA-256 prefix of the reassembled data
+}
+
+// NewReceipt creates a receipt for data received as msgID
+func NewReceipt(msgID string, data []byte, status ReceiptStatus) *Receipt {
+	receipt := &Receipt{
+		MessageID: msgID,
+		Status:    status,
+		Time:      time.Now(),
+	}
+	sum := sha256.Sum256(data)
+	copy(receipt.Digest[:], sum[:8])
+	return receipt
+}
+
+// Matches reports whether the receipt's digest is that of data
+func (r *Receipt) Matches(data []byte) bool {
+	sum := sha256.Sum256(data)
+	return hmac.Equal(r.Digest[:], sum[:8])
+}
+
+// Token signs the receipt and encodes it as a DNS label
+func (r *Receipt) Token(key []byte) string {
+	body := make([]byte, RECEIPT_SIZE)
+	body[0] = RECEIPT_VERSION
+	body[1] = byte(r.Status)
+	binary.BigEndian.PutUint32(body[2:6], uint32(r.Time.Unix()))
+	copy(body[6:], r.Digest[:])
+
+	token := append(body, receiptTag(key, r.MessageID, body)...)
+	return strings.ToLower(base32NoPad.EncodeToString(token))
+}
+
+// ParseReceipt verifies a receipt token for msgID
+func ParseReceipt(token, msgID string, key []byte) (*Receipt, error) {
+	raw, err := base32NoPad.DecodeString(strings.ToUpper(token))
+	if err != nil {
+		return nil, fmt.Errorf("invalid receipt token: %w", err)
+	}
+	if len(raw) != RECEIPT_SIZE+AUTH_TAG_SIZE {
+		return nil, fmt.Errorf("receipt token is %d bytes, expected %d", len(raw), RECEIPT_SIZE+AUTH_TAG_SIZE)
+	}
+
+	body, tag := raw[:RECEIPT_SIZE], raw[RECEIPT_SIZE:]
+	if !hmac.Equal(tag, receiptTag(key, msgID, body)) {
+		return nil, ErrBadReceipt
+	}
+	if body[0] != RECEIPT_VERSION {
+		return nil, fmt.Errorf("unsupported receipt version %d", body[0])
+	}
+
+	receipt := &Receipt{
+		MessageID: msgID,
+		Status:    ReceiptStatus(body[1]),
+		Time:      time.Unix(int64(binary.BigEndian.Uint32(body[2:6])), 0),
+	}
+	copy(receipt.Digest[:], body[6:])
+	return receipt, nil
+}
+
+// ValidReceiptToken reports whether token is shaped like a receipt
+// (relays check this much without the key)
+func ValidReceiptToken(token string) bool {
+	raw, err := base32NoPad.DecodeString(strings.ToUpper(token))
+	return err == nil && len(raw) == RECEIPT_SIZE+AUTH_TAG_SIZE
+}
+
+// ReceiptName returns the name a sender polls for a message's receipt
+func ReceiptName(msgID, domain string) string {
+	return RECEIPT_LABEL_PREFIX + msgID + "." + strings.TrimSuffix(domain, ".")
+}
+
+// ReceiptQueryName returns the name a receiver queries to deliver a receipt
+func ReceiptQueryName(token, msgID, domain string) string {
+	return token + "." + ReceiptName(msgID, domain)
+}
+
+// ParseReceiptName splits a receipt name relative to its domain into
+// the message ID and, for a delivering query, the token
+func ParseReceiptName(relative string) (msgID, token string, ok bool) {
+	labels := strings.Split(relative, ".")
+	switch {
+	case len(labels) == 1 && strings.HasPrefix(labels[0], RECEIPT_LABEL_PREFIX):
+		return strings.TrimPrefix(labels[0], RECEIPT_LABEL_PREFIX), "", true
+	case len(labels) == 2 && strings.HasPrefix(labels[1], RECEIPT_LABEL_PREFIX):
+		return strings.TrimPrefix(labels[1], RECEIPT_LABEL_PREFIX), labels[0], true
+	}
+	return "", "", false
+}
+
+// receiptTag authenticates a receipt body for one message
+func receiptTag(key []byte, msgID string, body []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("simulacra-receipt"))
+	mac.Write([]byte(msgID))
+	mac.Write(body)
+	return mac.Sum(nil)[:AUTH_TAG_SIZE]
+}
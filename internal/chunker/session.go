@@ -0,0 +1,288 @@
+package chunker
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+)
+
+// ================================================================================
+// INCREMENTAL REASSEMBLY
+// Accepts chunks as they arrive instead of all at once
+// ================================================================================
+
+// LESSON: Why a Session?
+// ReassembleMessage needs every chunk up front, so a receiver has to buffer
+// the whole transfer and only learns what's missing at the very end. A
+// session is fed chunks one at a time and can answer, at any moment:
+// 1. Which sequences are still missing (so we re-query only those)
+// 2. Whether a chunk is a duplicate (DNS retries and caches produce many)
+// 3. Whether the message is complete
+// Large transfers can flush received payloads to a spill file, keeping
+// only metadata in memory until Finalize reads them back.
+
+// ErrConflictingChunk means a sequence arrived twice with different payloads
+var ErrConflictingChunk = errors.New("conflicting duplicate chunk")
+
+// ReassemblySession collects the chunks of one message
+type ReassemblySession struct {
+	chunker    *Chunker
+	mu         sync.Mutex
+	started    bool
+	messageID  [16]byte
+	total      uint32
+	data       map[uint32]Chunk // Data chunks (payload nil once flushed)
+	parity     map[uint32]Chunk // Parity chunks by sequence, or ESI for fountain symbols
+	duplicates int
+
+	// Partial flush to disk
+	spillPath string
+	spill     *os.File
+	stride    int // Payload size of every data chunk except the last
+	flushed   map[uint32]bool
+}
+
+// SessionStatus is a snapshot of a session's progress
+type SessionStatus struct {
+	MessageID  [16]byte
+	Total      int
+	Received   int // Distinct data chunks
+	Parity     int // Distinct parity chunks
+	Duplicates int
+	Flushed    int // Data chunks held on disk rather than in memory
+	Missing    []uint32
+	Complete   bool
+}
+
+// NewReassemblySession starts an empty session using this chunker's settings
+func (c *Chunker) NewReassemblySession() *ReassemblySession {
+	return &ReassemblySession{
+		chunker: c,
+		data:    make(map[uint32]Chunk),
+		parity:  make(map[uint32]Chunk),
+		flushed: make(map[uint32]bool),
+	}
+}
+
+// AddEncoded decodes a TXT value and adds the chunk.
+// It returns false (and no error) for an exact duplicate.
+func (s *ReassemblySession) AddEncoded(encoded string) (bool, error) {
+	chunk, err := s.chunker.DecodeChunk(encoded)
+	if err != nil {
+		return false, err
+	}
+	return s.Add(*chunk)
+}
+
+// Add verifies and stores a chunk.
+// It returns false (and no error) for an exact duplicate.
+func (s *ReassemblySession) Add(chunk Chunk) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	meta := chunk.Metadata
+
+	// The first chunk fixes the message identity
+	if !s.started {
+		s.messageID = meta.MessageID
+		s.total = meta.TotalChunks
+		s.started = true
+	} else {
+		if meta.MessageID != s.messageID {
+			return false, fmt.Errorf("chunk belongs to message %x, session is %x",
+				meta.MessageID[:8], s.messageID[:8])
+		}
+		if meta.TotalChunks != s.total {
+			return false, fmt.Errorf("inconsistent total chunks: %d vs %d", meta.TotalChunks, s.total)
+		}
+	}
+
+	if !meta.Parity && meta.Sequence >= s.total {
+		return false, fmt.Errorf("sequence %d out of bounds (total: %d)", meta.Sequence, s.total)
+	}
+
+	if err := s.chunker.verifyChecksum(&chunk); err != nil {
+		return false, fmt.Errorf("chunk %d rejected: %w", meta.Sequence, err)
+	}
+
+	var existing Chunk
+	var seen bool
+	if meta.Parity {
+		existing, seen = s.parity[symbolID(chunk)]
+	} else {
+		existing, seen = s.data[meta.Sequence]
+	}
+
+	if seen {
+		s.duplicates++
+		if existing.Metadata.Checksum != meta.Checksum ||
+			(existing.Payload != nil && !bytes.Equal(existing.Payload, chunk.Payload)) {
+			return false, fmt.Errorf("%w: sequence %d", ErrConflictingChunk, meta.Sequence)
+		}
+		return false, nil
+	}
+
+	// Every data chunk but the last carries the same payload size
+	if !meta.Parity && meta.Sequence < s.total-1 {
+		if s.stride == 0 {
+			s.stride = len(chunk.Payload)
+		} else if len(chunk.Payload) != s.stride {
+			return false, fmt.Errorf("chunk %d has %d payload bytes, expected %d",
+				meta.Sequence, len(chunk.Payload), s.stride)
+		}
+	}
+
+	if meta.Parity {
+		s.parity[symbolID(chunk)] = chunk
+	} else {
+		s.data[meta.Sequence] = chunk
+	}
+	return true, nil
+}
+
+// Missing returns the data sequences not yet received
+func (s *ReassemblySession) Missing() []uint32 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.missing()
+}
+
+// missing lists absent sequences; callers hold the lock
+func (s *ReassemblySession) missing() []uint32 {
+	if !s.started {
+		return nil
+	}
+
+	var missing []uint32
+	for seq := uint32(0); seq < s.total; seq++ {
+		if _, ok := s.data[seq]; !ok {
+			missing = append(missing, seq)
+		}
+	}
+	return missing
+}
+
+// Complete reports whether every data chunk has arrived
+func (s *ReassemblySession) Complete() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.started && len(s.data) == int(s.total)
+}
+
+// Status returns a snapshot of the session's progress
+func (s *ReassemblySession) Status() SessionStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return SessionStatus{
+		MessageID:  s.messageID,
+		Total:      int(s.total),
+		Received:   len(s.data),
+		Parity:     len(s.parity),
+		Duplicates: s.duplicates,
+		Flushed:    len(s.flushed),
+		Missing:    s.missing(),
+		Complete:   s.started && len(s.data) == int(s.total),
+	}
+}
+
+// Flush writes buffered data payloads to a spill file at path and releases
+// them from memory. Every call must use the same path; the file is reused.
+// It returns the number of chunks flushed.
+func (s *ReassemblySession) Flush(path string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.spill == nil {
+		file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+		if err != nil {
+			return 0, fmt.Errorf("failed to open spill file: %w", err)
+		}
+		s.spill = file
+		s.spillPath = path
+	} else if path != s.spillPath {
+		return 0, fmt.Errorf("session already spills to %s", s.spillPath)
+	}
+
+	// Offsets are only known once a full-size chunk has been seen
+	if s.stride == 0 {
+		return 0, nil
+	}
+
+	flushed := 0
+	for seq, chunk := range s.data {
+		if s.flushed[seq] {
+			continue
+		}
+		if _, err := s.spill.WriteAt(chunk.Payload, int64(seq)*int64(s.stride)); err != nil {
+			return flushed, fmt.Errorf("failed to flush chunk %d: %w", seq, err)
+		}
+		chunk.Payload = nil
+		s.data[seq] = chunk
+		s.flushed[seq] = true
+		flushed++
+	}
+
+	return flushed, nil
+}
+
+// Finalize reads back flushed payloads and reassembles the message.
+// Missing data chunks may still be rebuilt from parity chunks.
+func (s *ReassemblySession) Finalize() ([]byte, error) {
+	data, _, err := s.FinalizeFile()
+	return data, err
+}
+
+// FinalizeFile is Finalize, also returning the message's extended header
+// (nil when the sender didn't add one)
+func (s *ReassemblySession) FinalizeFile() ([]byte, *ContentInfo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.started {
+		return nil, nil, errors.New("no chunks received")
+	}
+	if len(s.flushed) > 0 && s.spill == nil {
+		return nil, nil, errors.New("session closed: flushed chunks are no longer readable")
+	}
+
+	chunks := make([]Chunk, 0, len(s.data)+len(s.parity))
+	for seq, chunk := range s.data {
+		if s.flushed[seq] {
+			payload := make([]byte, chunk.Metadata.PayloadSize)
+			if _, err := s.spill.ReadAt(payload, int64(seq)*int64(s.stride)); err != nil {
+				return nil, nil, fmt.Errorf("failed to read flushed chunk %d: %w", seq, err)
+			}
+			chunk.Payload = payload
+		}
+		chunks = append(chunks, chunk)
+	}
+	for _, chunk := range s.parity {
+		chunks = append(chunks, chunk)
+	}
+
+	sort.Slice(chunks, func(i, j int) bool {
+		if chunks[i].Metadata.Parity != chunks[j].Metadata.Parity {
+			return !chunks[i].Metadata.Parity
+		}
+		return chunks[i].Metadata.Sequence < chunks[j].Metadata.Sequence
+	})
+
+	return s.chunker.ReassembleFile(chunks)
+}
+
+// Close releases the spill file (the file itself is left for the caller)
+func (s *ReassemblySession) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.spill == nil {
+		return nil
+	}
+	err := s.spill.Close()
+	s.spill = nil
+	return err
+}
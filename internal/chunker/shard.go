@@ -0,0 +1,126 @@
+package chunker
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+)
+
+// ================================================================================
+// THEORY LESSON: Heterogeneous Record Sharding
+// ================================================================================
+//
+// A zone full of 255-character base32 TXT records is easy to spot: no
+// legitimate service publishes thousands of them. Sharding spreads each
+// chunk's wire bytes over three record types at the chunk's name, so no
+// single type carries a recognizable blob:
+//
+//   TXT   first third, as a short base64url token
+//   AAAA  next bytes, 13 per address:  [PREFIX(2)][INDEX(1)][DATA(13)]
+//   NULL  [AAAA LENGTH(1)][remaining bytes], raw binary
+//
+// Resolvers may reorder the addresses of an AAAA set, so each carries its
+// index. The NULL record says how many AAAA bytes are real (the last
+// address is zero padded). The split is computed by the server from the
+// stored chunk, so senders upload chunks exactly as before and only flag
+// the message in its manifest ("; shards=1").
+// ================================================================================
+
+const (
+	// SHARD_AAAA_DATA is the number of chunk bytes carried per address
+	SHARD_AAAA_DATA = 13
+
+	// SHARD_MAX_AAAA caps the addresses in one chunk's AAAA set
+	SHARD_MAX_AAAA = 8
+)
+
+// SHARD_AAAA_PREFIX starts every shard address (a global unicast /16)
+var SHARD_AAAA_PREFIX = [2]byte{0x26, 0x06}
+
+// RecordShards holds one chunk split across record types
+type RecordShards struct {
+	TXT  string   // base64url token
+	AAAA []net.IP // Indexed 16-byte addresses, in any order
+	NULL []byte   // AAAA byte count, then the remaining chunk bytes
+}
+
+// ShardChunk splits an encoded chunk value into its record shards.
+// The encoding is detected, so the server needs no chunker settings.
+func ShardChunk(encoded string) (*RecordShards, error) {
+	raw, _, err := detectEncoding(encoded)
+	if err != nil {
+		return nil, err
+	}
+	return SplitShards(raw), nil
+}
+
+// SplitShards splits raw chunk bytes into TXT, AAAA and NULL shards
+func SplitShards(raw []byte) *RecordShards {
+	txtLen := (len(raw) + 2) / 3
+	aaaaLen := (len(raw) - txtLen + 1) / 2
+	if aaaaLen > SHARD_AAAA_DATA*SHARD_MAX_AAAA {
+		aaaaLen = SHARD_AAAA_DATA * SHARD_MAX_AAAA
+	}
+
+	shards := &RecordShards{
+		TXT: base64.RawURLEncoding.EncodeToString(raw[:txtLen]),
+	}
+
+	addresses := raw[txtLen : txtLen+aaaaLen]
+	for i := 0; i*SHARD_AAAA_DATA < len(addresses); i++ {
+		ip := make(net.IP, net.IPv6len)
+		copy(ip, SHARD_AAAA_PREFIX[:])
+		ip[2] = byte(i)
+		copy(ip[3:], addresses[i*SHARD_AAAA_DATA:])
+		shards.AAAA = append(shards.AAAA, ip)
+	}
+
+	shards.NULL = append([]byte{byte(aaaaLen)}, raw[txtLen+aaaaLen:]...)
+	return shards
+}
+
+// Join recombines the shards into raw chunk bytes
+func (s *RecordShards) Join() ([]byte, error) {
+	txt, err := base64.RawURLEncoding.DecodeString(s.TXT)
+	if err != nil {
+		return nil, fmt.Errorf("TXT shard: %w", err)
+	}
+	if len(s.NULL) == 0 {
+		return nil, errors.New("NULL shard is empty")
+	}
+
+	aaaaLen := int(s.NULL[0])
+	count := (aaaaLen + SHARD_AAAA_DATA - 1) / SHARD_AAAA_DATA
+	if len(s.AAAA) != count {
+		return nil, fmt.Errorf("AAAA shard has %d addresses, expected %d", len(s.AAAA), count)
+	}
+
+	addresses := make([]net.IP, len(s.AAAA))
+	copy(addresses, s.AAAA)
+	sort.Slice(addresses, func(i, j int) bool {
+		return addresses[i].To16()[2] < addresses[j].To16()[2]
+	})
+
+	raw := append([]byte(nil), txt...)
+	for i, addr := range addresses {
+		ip := addr.To16()
+		if ip == nil || ip[0] != SHARD_AAAA_PREFIX[0] || ip[1] != SHARD_AAAA_PREFIX[1] || int(ip[2]) != i {
+			return nil, fmt.Errorf("AAAA shard address %s is not part %d", addr, i)
+		}
+		raw = append(raw, ip[3:]...)
+	}
+	raw = raw[:len(txt)+aaaaLen]
+
+	return append(raw, s.NULL[1:]...), nil
+}
+
+// Value recombines the shards and encodes the chunk like a TXT chunk value
+func (s *RecordShards) Value(encoding string) (string, error) {
+	raw, err := s.Join()
+	if err != nil {
+		return "", err
+	}
+	return encodeBytes(encoding, raw), nil
+}
@@ -0,0 +1,198 @@
+package chunker
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// ================================================================================
+// THEORY LESSON: Signed Manifests
+// ================================================================================
+//
+// Chunk checksums catch corruption and chunk tags (auth.go) catch anyone
+// without the shared secret, but the DNS server holds every chunk and, with
+// a shared secret, every key. A compromised or spoofed server can publish a
+// different chunk set under the same message ID and nobody notices.
+//
+// A signed manifest closes that gap with a key pair: the sender keeps the
+// Ed25519 private key, receivers only need the public key, and a server
+// holding neither can't forge anything. The sender appends two fields:
+//
+//   30:checksum:1700000000; names=...; set=<digest>; sig=<signature>
+//
+//   set = SHA-256 over the raw bytes of every chunk, in sequence order
+//   sig = Ed25519(context || message ID || everything before "; sig=")
+//
+// The receiver verifies the signature as soon as the manifest arrives,
+// before a single chunk query, so a forged manifest stops the transfer.
+// Once the chunks are in, their digest must equal the signed set; chunks
+// are compared as raw bytes, so re-encoding them for another carrier
+// (NULL, AAAA) changes nothing. A signed message needs every chunk to be
+// verified: parity can rebuild a lost chunk's data, but not prove where
+// the chunks that arrived came from.
+//
+// The message ID is signed too, so a valid manifest can't be replayed
+// under another ID.
+// ================================================================================
+
+const (
+	// MANIFEST_SIG_CONTEXT separates manifest signatures from any other
+	// use of the same key
+	MANIFEST_SIG_CONTEXT = "simulacra-manifest-v1"
+
+	// MANIFEST_SIG_FIELD introduces the signature; it is always the last field
+	MANIFEST_SIG_FIELD = "; sig="
+)
+
+var (
+	// ErrManifestSignature means a manifest's signature didn't verify
+	ErrManifestSignature = errors.New("manifest signature invalid")
+
+	// ErrManifestUnsigned means a signature was required but the manifest has none
+	ErrManifestUnsigned = errors.New("manifest is not signed")
+
+	// ErrChunkSetMismatch means the fetched chunks aren't the signed chunk set
+	ErrChunkSetMismatch = errors.New("chunks don't match the signed chunk set")
+)
+
+// GenerateSigningKey creates a new Ed25519 key pair for signing manifests
+func GenerateSigningKey() (ed25519.PublicKey, ed25519.PrivateKey, error) {
+	return ed25519.GenerateKey(rand.Reader)
+}
+
+// EncodeSigningKey encodes a private key as hex of its 32-byte seed
+func EncodeSigningKey(key ed25519.PrivateKey) string {
+	return hex.EncodeToString(key.Seed())
+}
+
+// EncodeVerifyKey encodes a public key as hex
+func EncodeVerifyKey(key ed25519.PublicKey) string {
+	return hex.EncodeToString(key)
+}
+
+// ParseSigningKey decodes a private key written by EncodeSigningKey
+func ParseSigningKey(text string) (ed25519.PrivateKey, error) {
+	seed, err := hex.DecodeString(strings.TrimSpace(text))
+	if err != nil || len(seed) != ed25519.SeedSize {
+		return nil, fmt.Errorf("signing key must be %d hex characters", 2*ed25519.SeedSize)
+	}
+	return ed25519.NewKeyFromSeed(seed), nil
+}
+
+// ParseVerifyKey decodes a public key written by EncodeVerifyKey
+func ParseVerifyKey(text string) (ed25519.PublicKey, error) {
+	key, err := hex.DecodeString(strings.TrimSpace(text))
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("verify key must be %d hex characters", 2*ed25519.PublicKeySize)
+	}
+	return ed25519.PublicKey(key), nil
+}
+
+// ReadKeyText returns the contents of the file at value, or value itself
+// when no such file exists, so keys can be passed inline or as a path
+func ReadKeyText(value string) (string, error) {
+	data, err := os.ReadFile(value)
+	if errors.Is(err, os.ErrNotExist) {
+		return value, nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to read key file: %w", err)
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// ChunkSetDigest hashes the raw bytes of encoded chunks in sequence order,
+// whatever encoding each one arrived in
+func ChunkSetDigest(encoded []string) (string, error) {
+	h := sha256.New()
+	for i, text := range encoded {
+		raw, _, err := detectEncoding(text)
+		if err != nil {
+			return "", fmt.Errorf("chunk %d: %w", i, err)
+		}
+		h.Write(binary.BigEndian.AppendUint32(nil, uint32(len(raw))))
+		h.Write(raw)
+	}
+	return strings.ToLower(base32NoPad.EncodeToString(h.Sum(nil))), nil
+}
+
+// SignManifest appends the chunk set digest and an Ed25519 signature to a
+// manifest value published as msgID
+func SignManifest(value, msgID string, chunks []Chunk, key ed25519.PrivateKey) (string, error) {
+	if strings.Contains(value, MANIFEST_SIG_FIELD) {
+		return "", errors.New("manifest is already signed")
+	}
+
+	encoded := make([]string, len(chunks))
+	for i, chunk := range chunks {
+		encoded[i] = chunk.Encoded
+	}
+	digest, err := ChunkSetDigest(encoded)
+	if err != nil {
+		return "", err
+	}
+
+	body := value + "; set=" + digest
+	signature := ed25519.Sign(key, manifestSigningInput(msgID, body))
+	return body + MANIFEST_SIG_FIELD + strings.ToLower(base32NoPad.EncodeToString(signature)), nil
+}
+
+// manifestSigningInput binds the signed manifest text to its message ID
+func manifestSigningInput(msgID, body string) []byte {
+	input := make([]byte, 0, len(MANIFEST_SIG_CONTEXT)+len(msgID)+len(body)+2)
+	input = append(input, MANIFEST_SIG_CONTEXT...)
+	input = append(input, 0)
+	input = append(input, msgID...)
+	input = append(input, 0)
+	return append(input, body...)
+}
+
+// Signed reports whether the manifest carries a signature
+func (m *DNSManifest) Signed() bool {
+	return len(m.Signature) > 0
+}
+
+// Verify checks the manifest's signature against key
+func (m *DNSManifest) Verify(key ed25519.PublicKey) error {
+	if !m.Signed() {
+		return ErrManifestUnsigned
+	}
+	if m.ChunkSet == "" {
+		return fmt.Errorf("%w: no chunk set digest", ErrManifestSignature)
+	}
+	if !ed25519.Verify(key, manifestSigningInput(m.MessageID, m.signedBody), m.Signature) {
+		return ErrManifestSignature
+	}
+	return nil
+}
+
+// VerifyChunks checks fetched chunks (in sequence order, "" = missing)
+// against the signed chunk set
+func (m *DNSManifest) VerifyChunks(encoded []string) error {
+	missing := 0
+	for _, text := range encoded {
+		if text == "" {
+			missing++
+		}
+	}
+	if missing > 0 {
+		return fmt.Errorf("%w: %d/%d chunks missing, origin can't be verified",
+			ErrChunkSetMismatch, missing, len(encoded))
+	}
+
+	digest, err := ChunkSetDigest(encoded)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrChunkSetMismatch, err)
+	}
+	if digest != m.ChunkSet {
+		return ErrChunkSetMismatch
+	}
+	return nil
+}
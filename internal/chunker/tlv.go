@@ -0,0 +1,181 @@
+package chunker
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ================================================================================
+// THEORY LESSON: Metadata TLVs
+// ================================================================================
+//
+// Every new piece of message metadata used to mean a new header field, a
+// new version and a compatibility dance. A TLV section lets higher layers
+// (transports, routers, FEC) attach data without any of that:
+//
+//   [TYPE(1)][LENGTH(1)][VALUE(LENGTH)] [TYPE][LENGTH][VALUE] ...
+//
+// A receiver walks the section by lengths, so a type it doesn't know is
+// skipped, never misread. TLVs travel in two places:
+//
+// 1. The extended header (end of its body, which older receivers already
+//    skip): sealed with the message, for the receiver only
+// 2. The manifest, as "; tlv=<base64url>": readable by servers and relays,
+//    which is where routing and transport hints belong
+// ================================================================================
+
+const (
+	// Registered TLV types; others are carried and skipped
+	TLV_TRANSPORT_HINT = 1 // Preferred transport or carrier, e.g. "doh"
+	TLV_ROUTING_TAG    = 2 // Routing label for relays, e.g. "ops-east"
+	TLV_FEC_PARAMS     = 3 // FEC scheme and redundancy, e.g. "lt:0.5"
+
+	// MAX_TLV_VALUE is the longest value one TLV can hold
+	MAX_TLV_VALUE = 255
+)
+
+// tlvTypeNames names the registered types for flags and display
+var tlvTypeNames = map[uint8]string{
+	TLV_TRANSPORT_HINT: "transport",
+	TLV_ROUTING_TAG:    "routing",
+	TLV_FEC_PARAMS:     "fec",
+}
+
+// TLV is one typed metadata value
+type TLV struct {
+	Type  uint8
+	Value []byte
+}
+
+// TLVs is an ordered TLV section
+type TLVs []TLV
+
+// TLVTypeName names a TLV type ("0x2a" for unregistered ones)
+func TLVTypeName(t uint8) string {
+	if name, ok := tlvTypeNames[t]; ok {
+		return name
+	}
+	return fmt.Sprintf("0x%02x", t)
+}
+
+// Get returns the value of the first TLV of a type
+func (s TLVs) Get(t uint8) ([]byte, bool) {
+	for _, tlv := range s {
+		if tlv.Type == t {
+			return tlv.Value, true
+		}
+	}
+	return nil, false
+}
+
+// Set replaces the value of a type, or appends it
+func (s *TLVs) Set(t uint8, value []byte) error {
+	if len(value) > MAX_TLV_VALUE {
+		return fmt.Errorf("TLV %s value is %d bytes, max %d", TLVTypeName(t), len(value), MAX_TLV_VALUE)
+	}
+	for i := range *s {
+		if (*s)[i].Type == t {
+			(*s)[i].Value = value
+			return nil
+		}
+	}
+	*s = append(*s, TLV{Type: t, Value: value})
+	return nil
+}
+
+// String lists the TLVs for display
+func (s TLVs) String() string {
+	parts := make([]string, len(s))
+	for i, tlv := range s {
+		parts[i] = fmt.Sprintf("%s=%q", TLVTypeName(tlv.Type), tlv.Value)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// marshal serializes the section (values longer than MAX_TLV_VALUE are
+// rejected by Set and ParseTLVSpec, so they are truncated here)
+func (s TLVs) marshal() []byte {
+	var raw []byte
+	for _, tlv := range s {
+		value := tlv.Value
+		if len(value) > MAX_TLV_VALUE {
+			value = value[:MAX_TLV_VALUE]
+		}
+		raw = append(raw, tlv.Type, byte(len(value)))
+		raw = append(raw, value...)
+	}
+	return raw
+}
+
+// ParseTLVs parses a serialized TLV section
+func ParseTLVs(raw []byte) (TLVs, error) {
+	var s TLVs
+	for len(raw) > 0 {
+		if len(raw) < 2 {
+			return nil, errors.New("TLV section truncated")
+		}
+		t, length := raw[0], int(raw[1])
+		if 2+length > len(raw) {
+			return nil, fmt.Errorf("TLV %s claims %d bytes, %d left", TLVTypeName(t), length, len(raw)-2)
+		}
+		s = append(s, TLV{Type: t, Value: append([]byte(nil), raw[2:2+length]...)})
+		raw = raw[2+length:]
+	}
+	return s, nil
+}
+
+// EncodeTLVs encodes a section as DNS-safe text (for the manifest)
+func EncodeTLVs(s TLVs) string {
+	return base64.RawURLEncoding.EncodeToString(s.marshal())
+}
+
+// DecodeTLVs decodes a section encoded with EncodeTLVs
+func DecodeTLVs(text string) (TLVs, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid TLV encoding: %w", err)
+	}
+	return ParseTLVs(raw)
+}
+
+// ParseTLVSpec parses "type=value" pairs separated by commas, where the
+// type is a registered name or a number, e.g. "routing=ops-east,42=x"
+func ParseTLVSpec(spec string) (TLVs, error) {
+	var s TLVs
+	if strings.TrimSpace(spec) == "" {
+		return s, nil
+	}
+
+	for _, pair := range strings.Split(spec, ",") {
+		name, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok {
+			return nil, fmt.Errorf("TLV %q is not type=value", pair)
+		}
+		t, err := parseTLVType(name)
+		if err != nil {
+			return nil, err
+		}
+		if err := s.Set(t, []byte(value)); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// parseTLVType accepts a registered type name or a number (0-255)
+func parseTLVType(name string) (uint8, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	for t, registered := range tlvTypeNames {
+		if registered == name {
+			return t, nil
+		}
+	}
+	n, err := strconv.ParseUint(name, 0, 8)
+	if err != nil {
+		return 0, fmt.Errorf("unknown TLV type %q", name)
+	}
+	return uint8(n), nil
+}
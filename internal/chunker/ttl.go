@@ -0,0 +1,141 @@
+package chunker
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ================================================================================
+// THEORY LESSON: TTLs Are a Dial
+// ================================================================================
+//
+// Every record's TTL tells resolvers how long they may answer from cache
+// instead of asking the authoritative server again. For a covert channel
+// that cuts both ways:
+//
+//   Short TTL - every retrieval reaches our server (fresh data, more
+//               queries to the zone, each one a chance to be logged)
+//   Long TTL  - a resolver that fetched a chunk once keeps serving it; the
+//               records can even be removed from the zone while receivers
+//               still read them (the cache becomes a dead drop)
+//
+// A TTLPolicy sets chunk and manifest TTLs separately - the manifest is
+// what announces a message, so it's often kept shorter than the chunks -
+// and can jitter them: every record sharing one TTL like 300 is a pattern
+// of its own, while zones written by people mix values. Jitter is applied
+// per RRset, since records of one set must share a TTL.
+//
+// Spec format: chunk=1h,manifest=5m,jitter=20%  (bare numbers are seconds)
+// ================================================================================
+
+const (
+	DEFAULT_CHUNK_TTL    = 300
+	DEFAULT_MANIFEST_TTL = 300
+
+	// MAX_TTL is the longest TTL accepted; resolvers cap cached TTLs at
+	// around a day or a week anyway
+	MAX_TTL = 7 * 24 * 3600
+
+	// MAX_TTL_JITTER is the largest jitter, in percent of the TTL
+	MAX_TTL_JITTER = 90
+)
+
+// TTLPolicy chooses the TTLs of published records
+type TTLPolicy struct {
+	Chunk    int // Chunk record TTL, seconds
+	Manifest int // Manifest record TTL, seconds
+	Jitter   int // Each TTL drawn from +/- this percent of its base (0 = exact)
+}
+
+// DefaultTTLPolicy returns the TTLs records have always had
+func DefaultTTLPolicy() TTLPolicy {
+	return TTLPolicy{Chunk: DEFAULT_CHUNK_TTL, Manifest: DEFAULT_MANIFEST_TTL}
+}
+
+// ParseTTLPolicy parses a policy spec, starting from the defaults
+func ParseTTLPolicy(spec string) (TTLPolicy, error) {
+	policy := DefaultTTLPolicy()
+
+	for _, field := range strings.Split(spec, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+
+		key, val, ok := strings.Cut(field, "=")
+		if !ok {
+			return TTLPolicy{}, fmt.Errorf("malformed TTL rule: %q", field)
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		val = strings.TrimSpace(val)
+
+		var err error
+		switch key {
+		case "chunk":
+			policy.Chunk, err = parseTTL(val)
+		case "manifest":
+			policy.Manifest, err = parseTTL(val)
+		case "jitter":
+			policy.Jitter, err = strconv.Atoi(strings.TrimSuffix(val, "%"))
+		default:
+			return TTLPolicy{}, fmt.Errorf("unknown TTL rule: %s", key)
+		}
+		if err != nil {
+			return TTLPolicy{}, fmt.Errorf("invalid %s TTL: %w", key, err)
+		}
+	}
+
+	return policy, policy.Check()
+}
+
+// parseTTL reads a TTL as seconds or a duration ("90", "5m", "1h")
+func parseTTL(value string) (int, error) {
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return seconds, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, err
+	}
+	return int(d / time.Second), nil
+}
+
+// Check validates the policy's ranges
+func (p TTLPolicy) Check() error {
+	for _, ttl := range []int{p.Chunk, p.Manifest} {
+		if ttl < 0 || ttl > MAX_TTL {
+			return fmt.Errorf("TTL %d out of range (0-%d seconds)", ttl, MAX_TTL)
+		}
+	}
+	if p.Jitter < 0 || p.Jitter > MAX_TTL_JITTER {
+		return fmt.Errorf("TTL jitter %d%% out of range (0-%d%%)", p.Jitter, MAX_TTL_JITTER)
+	}
+	return nil
+}
+
+// ChunkTTL returns the TTL for the next chunk RRset
+func (p TTLPolicy) ChunkTTL() int {
+	return p.jittered(p.Chunk)
+}
+
+// ManifestTTL returns the TTL for the next manifest record
+func (p TTLPolicy) ManifestTTL() int {
+	return p.jittered(p.Manifest)
+}
+
+// jittered draws a TTL within +/- Jitter percent of base
+func (p TTLPolicy) jittered(base int) int {
+	spread := base * p.Jitter / 100
+	if spread == 0 {
+		return base
+	}
+	return base - spread + rand.Intn(2*spread+1)
+}
+
+// String describes the policy in ParseTTLPolicy syntax
+func (p TTLPolicy) String() string {
+	return fmt.Sprintf("chunk=%d,manifest=%d,jitter=%d%%", p.Chunk, p.Manifest, p.Jitter)
+}
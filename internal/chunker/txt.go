@@ -0,0 +1,99 @@
+package chunker
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ================================================================================
+// THEORY LESSON: Multi-String TXT Records
+// ================================================================================
+//
+// The 255-byte limit applies to each character-string inside a TXT record,
+// not to the record. One record may hold many strings back to back:
+//
+//   c-0-abc.data.example.com. 300 IN TXT "MZXW6YTB..." "OIFQXI2L..." "..."
+//
+// Packing a chunk into N strings cuts the number of DNS queries by roughly N.
+// We stop at MAX_TXT_STRINGS (16 x 240 = 3840 encoded characters) so a single
+// answer still fits a 4096-byte EDNS0 buffer. Plain 512-byte UDP can't carry
+// more than one string, so multi-string chunks need EDNS0 or TCP.
+//
+// Receivers simply concatenate all strings of a record before decoding.
+// ================================================================================
+
+const (
+	// MAX_TXT_STRINGS caps the strings packed into one chunk record
+	MAX_TXT_STRINGS = 16
+)
+
+// SplitTXT splits a value into character-strings of at most MAX_DNS_STRING_SIZE bytes
+func SplitTXT(value string) []string {
+	if len(value) <= MAX_DNS_STRING_SIZE {
+		return []string{value}
+	}
+
+	parts := make([]string, 0, len(value)/MAX_DNS_STRING_SIZE+1)
+	for len(value) > MAX_DNS_STRING_SIZE {
+		parts = append(parts, value[:MAX_DNS_STRING_SIZE])
+		value = value[MAX_DNS_STRING_SIZE:]
+	}
+	if value != "" {
+		parts = append(parts, value)
+	}
+	return parts
+}
+
+// JoinTXT reassembles the character-strings of one TXT record
+func JoinTXT(parts []string) string {
+	return strings.Join(parts, "")
+}
+
+// FormatTXT renders a value as zone file RDATA: one quoted string per part
+func FormatTXT(value string) string {
+	parts := SplitTXT(value)
+	for i, part := range parts {
+		parts[i] = `"` + part + `"`
+	}
+	return strings.Join(parts, " ")
+}
+
+// ParseTXT extracts and joins the quoted strings of zone file RDATA,
+// undoing \" and \\ escapes. It is the inverse of FormatTXT.
+func ParseTXT(rdata string) (string, error) {
+	var value strings.Builder
+	inQuotes := false
+	found := false
+
+	for i := 0; i < len(rdata); i++ {
+		ch := rdata[i]
+		switch {
+		case ch == '"':
+			inQuotes = !inQuotes
+			found = true
+		case !inQuotes:
+			// Whitespace between strings (or a trailing comment)
+			if ch == ';' {
+				i = len(rdata)
+			}
+		case ch == '\\' && i+1 < len(rdata):
+			i++
+			value.WriteByte(rdata[i])
+		default:
+			value.WriteByte(ch)
+		}
+	}
+
+	if !found {
+		return "", fmt.Errorf("no quoted TXT strings in %q", rdata)
+	}
+	if inQuotes {
+		return "", fmt.Errorf("unterminated TXT string in %q", rdata)
+	}
+	return value.String(), nil
+}
+
+// Strings returns the chunk's encoded value as TXT character-strings
+func (c Chunk) Strings() []string {
+	return SplitTXT(c.Encoded)
+}
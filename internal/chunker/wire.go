@@ -0,0 +1,217 @@
+package chunker
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// ================================================================================
+// THEORY LESSON: Versioned Wire Formats
+// ================================================================================
+//
+// Every chunk starts with a 4-byte magic, and the magic says which header
+// layout follows. Rather than one decoder that knows every layout by heart,
+// the layouts live in a registry and the decoder looks the magic up:
+//
+//   v1 "DNSC"/"DNSP"  [MAGIC][MSGID(16)][SEQ(2)][TOTAL(2)][CHECKSUM(4)]
+//   v2 "DNS2"         v1 header + [FLAGS(1)]
+//   v3 "DNS3"         [MAGIC][MSGID(16)][SEQ(4)][TOTAL(4)][CHECKSUM(4)][FLAGS(1)]
+//
+// All three decode side by side, so a receiver never has to be told which
+// one a publisher used. v3 exists for the sequence space: 16-bit sequence
+// numbers cap a message at 65535 chunks (about 8 MB with base32 TXT
+// records), 32-bit ones lift the cap at the cost of 4 bytes per chunk.
+// Senders therefore pick the smallest format that fits the message unless
+// a version is pinned (ChunkerConfig.WireVersion).
+// ================================================================================
+
+const (
+	// CHUNK_MAGIC_V3 identifies v3 chunks: 32-bit sequence space
+	CHUNK_MAGIC_V3 = 0x444E5333 // "DNS3" in hex
+
+	// METADATA_OVERHEAD_V3 widens sequence and total to 4 bytes each
+	METADATA_OVERHEAD_V3 = METADATA_OVERHEAD_V2 + 4
+)
+
+// WireFormat describes one chunk header layout
+type WireFormat struct {
+	Version     uint8
+	Name        string
+	Magic       uint32 // Data chunks
+	ParityMagic uint32 // Parity chunks (equal to Magic when a flag marks them)
+	HeaderSize  int
+	SeqBytes    int  // Width of the sequence and total fields
+	Flags       bool // Header ends with the flags byte
+}
+
+// MaxChunks returns the most chunks one message can have in this format
+func (w *WireFormat) MaxChunks() uint64 {
+	if w.SeqBytes == 4 {
+		return math.MaxUint32
+	}
+	return math.MaxUint16
+}
+
+// String names the format for display
+func (w *WireFormat) String() string {
+	return fmt.Sprintf("v%d (%s)", w.Version, w.Name)
+}
+
+// wireFormats is the registry, indexed by version
+var wireFormats = map[uint8]*WireFormat{
+	1: {Version: 1, Name: "legacy checksum, 16-bit sequence", Magic: CHUNK_MAGIC, ParityMagic: CHUNK_MAGIC_PARITY,
+		HeaderSize: METADATA_OVERHEAD, SeqBytes: 2},
+	2: {Version: 2, Name: "flags byte, 16-bit sequence", Magic: CHUNK_MAGIC_V2, ParityMagic: CHUNK_MAGIC_V2,
+		HeaderSize: METADATA_OVERHEAD_V2, SeqBytes: 2, Flags: true},
+	3: {Version: 3, Name: "flags byte, 32-bit sequence", Magic: CHUNK_MAGIC_V3, ParityMagic: CHUNK_MAGIC_V3,
+		HeaderSize: METADATA_OVERHEAD_V3, SeqBytes: 4, Flags: true},
+}
+
+// LookupWireFormat returns the registered format for a protocol version
+func LookupWireFormat(version uint8) (*WireFormat, error) {
+	if format, ok := wireFormats[version]; ok {
+		return format, nil
+	}
+	return nil, fmt.Errorf("unknown wire format v%d (known: %v)", version, WireVersions())
+}
+
+// WireFormatFor returns the format a magic belongs to
+func WireFormatFor(magic uint32) (*WireFormat, bool) {
+	for _, format := range wireFormats {
+		if format.Magic == magic || format.ParityMagic == magic {
+			return format, true
+		}
+	}
+	return nil, false
+}
+
+// WireVersions lists the registered versions, oldest first
+func WireVersions() []uint8 {
+	versions := make([]uint8, 0, len(wireFormats))
+	for version := range wireFormats {
+		versions = append(versions, version)
+	}
+	sort.Slice(versions, func(i, j int) bool { return versions[i] < versions[j] })
+	return versions
+}
+
+// smallestWireFormat returns the most compact flagged format holding total chunks
+func smallestWireFormat(total int) *WireFormat {
+	for _, version := range WireVersions() {
+		format := wireFormats[version]
+		if format.Flags && uint64(total) <= format.MaxChunks() {
+			return format
+		}
+	}
+	return wireFormats[PROTOCOL_VERSION]
+}
+
+// maxWireVersion returns the newest format the chunker may produce
+func (c *Chunker) maxWireVersion() uint8 {
+	if c.config.WireVersion != 0 {
+		return c.config.WireVersion
+	}
+	return PROTOCOL_VERSION
+}
+
+// isChunkMagic reports whether a value is one of our chunk magics
+func isChunkMagic(magic uint32) bool {
+	_, ok := WireFormatFor(magic)
+	return ok
+}
+
+// marshal serializes chunk metadata in this format's wire order
+func (w *WireFormat) marshal(metadata ChunkMetadata) []byte {
+	header := make([]byte, w.HeaderSize)
+	binary.BigEndian.PutUint32(header[0:4], metadata.Magic)
+	copy(header[4:20], metadata.MessageID[:])
+
+	offset := 20
+	if w.SeqBytes == 4 {
+		binary.BigEndian.PutUint32(header[offset:], metadata.Sequence)
+		binary.BigEndian.PutUint32(header[offset+4:], metadata.TotalChunks)
+	} else {
+		binary.BigEndian.PutUint16(header[offset:], uint16(metadata.Sequence))
+		binary.BigEndian.PutUint16(header[offset+2:], uint16(metadata.TotalChunks))
+	}
+	offset += 2 * w.SeqBytes
+
+	binary.BigEndian.PutUint32(header[offset:], metadata.Checksum)
+	offset += 4
+
+	if w.Flags {
+		flags := byte(metadata.Integrity) & FLAG_INTEGRITY_MASK
+		flags |= (byte(metadata.Compression) << FLAG_COMPRESSION_SHIFT) & FLAG_COMPRESSION_MASK
+		if metadata.Encrypted {
+			flags |= FLAG_ENCRYPTED
+		}
+		if metadata.Extended {
+			flags |= FLAG_EXTENDED
+		}
+		if metadata.Parity {
+			flags |= FLAG_PARITY
+		}
+		header[offset] = flags
+	}
+
+	return header
+}
+
+// unmarshal parses a header in this format from the start of raw
+func (w *WireFormat) unmarshal(raw []byte) (ChunkMetadata, error) {
+	if len(raw) < w.HeaderSize {
+		return ChunkMetadata{}, fmt.Errorf("chunk too small for v%d header: %d bytes", w.Version, len(raw))
+	}
+
+	metadata := ChunkMetadata{
+		Magic:   binary.BigEndian.Uint32(raw[0:4]),
+		Version: w.Version,
+	}
+	copy(metadata.MessageID[:], raw[4:20])
+
+	offset := 20
+	if w.SeqBytes == 4 {
+		metadata.Sequence = binary.BigEndian.Uint32(raw[offset:])
+		metadata.TotalChunks = binary.BigEndian.Uint32(raw[offset+4:])
+	} else {
+		metadata.Sequence = uint32(binary.BigEndian.Uint16(raw[offset:]))
+		metadata.TotalChunks = uint32(binary.BigEndian.Uint16(raw[offset+2:]))
+	}
+	offset += 2 * w.SeqBytes
+
+	metadata.Checksum = binary.BigEndian.Uint32(raw[offset:])
+	offset += 4
+
+	if w.Flags {
+		flags := raw[offset]
+		metadata.Integrity = IntegrityAlgorithm(flags & FLAG_INTEGRITY_MASK)
+		metadata.Compression = CompressionAlgorithm((flags & FLAG_COMPRESSION_MASK) >> FLAG_COMPRESSION_SHIFT)
+		metadata.Encrypted = flags&FLAG_ENCRYPTED != 0
+		metadata.Extended = flags&FLAG_EXTENDED != 0
+		metadata.Parity = flags&FLAG_PARITY != 0
+	} else {
+		metadata.Integrity = INTEGRITY_LEGACY
+		metadata.Parity = metadata.Magic == w.ParityMagic
+	}
+
+	return metadata, nil
+}
+
+// parseHeader detects the wire format of a raw chunk and parses its header,
+// returning the header length
+func parseHeader(raw []byte) (ChunkMetadata, int, error) {
+	if len(raw) < 4 {
+		return ChunkMetadata{}, 0, fmt.Errorf("chunk too small: %d bytes", len(raw))
+	}
+
+	magic := binary.BigEndian.Uint32(raw[0:4])
+	format, ok := WireFormatFor(magic)
+	if !ok {
+		return ChunkMetadata{}, 0, fmt.Errorf("invalid magic: %x", magic)
+	}
+
+	metadata, err := format.unmarshal(raw)
+	return metadata, format.HeaderSize, err
+}
@@ -0,0 +1,385 @@
+package chunker
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// ================================================================================
+// THEORY LESSON: Reading Zone Files
+// ================================================================================
+//
+// GenerateZoneFile writes one record per line, but a zone file that went
+// through an editor or a DNS provider's export rarely stays that tidy.
+// ParseZone reads the parts of RFC 1035 presentation format such files use:
+//
+//   $ORIGIN covert.example.com.          ; relative names end up here
+//   $TTL 300                             ; default TTL
+//   m-abc.data  IN TXT "30:1a2b:170..."  ; relative owner
+//   @           300 IN TXT ( "part one"  ; parentheses continue a record
+//                            "part two" )
+//               IN AAAA 2001:db8::1      ; blank owner = previous owner
+//
+// Comments start at a ';' outside quotes; inside quotes \" \\ and \DDD are
+// unescaped. TXT, NULL, CNAME and AAAA records are kept (the carriers,
+// carriers.go); SOA, NS and everything else are skipped.
+//
+// LoadZoneChunkSet then turns the records back into a chunk set: the
+// manifest says how many chunks there are and what they are called, every
+// chunk is looked up by that name, and each one must decode, pass its
+// checksum (and tag, with a key) and belong to the manifest's message -
+// the same checks it would face coming off the wire.
+// ================================================================================
+
+// zoneToken is one field of a zone file entry
+type zoneToken struct {
+	text   string
+	quoted bool
+}
+
+// zoneEntry is one logical record: its fields and where it started
+type zoneEntry struct {
+	line      int
+	blankName bool // Started with whitespace: the owner is the previous one
+	tokens    []zoneToken
+}
+
+// ParseZone reads the carrier records of a zone file. Relative names are
+// qualified with $ORIGIN, or origin until the file sets one.
+func ParseZone(r io.Reader, origin string) ([]DNSRecord, error) {
+	entries, err := splitZoneEntries(r)
+	if err != nil {
+		return nil, err
+	}
+
+	origin = strings.TrimSuffix(origin, ".")
+	ttl := 300
+	owner := ""
+	var records []DNSRecord
+
+	for _, entry := range entries {
+		tokens := entry.tokens
+		first := tokens[0].text
+
+		if !tokens[0].quoted && strings.HasPrefix(first, "$") {
+			switch strings.ToUpper(first) {
+			case "$ORIGIN":
+				if len(tokens) < 2 {
+					return nil, fmt.Errorf("line %d: $ORIGIN needs a name", entry.line)
+				}
+				origin = strings.TrimSuffix(qualifyZoneName(tokens[1].text, origin), ".")
+			case "$TTL":
+				if len(tokens) < 2 {
+					return nil, fmt.Errorf("line %d: $TTL needs a value", entry.line)
+				}
+				if ttl, err = strconv.Atoi(tokens[1].text); err != nil {
+					return nil, fmt.Errorf("line %d: invalid $TTL %q", entry.line, tokens[1].text)
+				}
+			default:
+				return nil, fmt.Errorf("line %d: unsupported directive %s", entry.line, first)
+			}
+			continue
+		}
+
+		if !entry.blankName {
+			owner = qualifyZoneName(first, origin)
+			tokens = tokens[1:]
+		} else if owner == "" {
+			return nil, fmt.Errorf("line %d: record without an owner name", entry.line)
+		}
+
+		record := DNSRecord{Name: strings.TrimSuffix(owner, "."), TTL: ttl}
+
+		// [TTL] [CLASS] TYPE, with TTL and class in either order
+		for len(tokens) > 0 && record.Type == "" {
+			field := tokens[0].text
+			tokens = tokens[1:]
+			if n, err := strconv.Atoi(field); err == nil {
+				record.TTL = n
+			} else if upper := strings.ToUpper(field); upper != "IN" && upper != "CH" && upper != "HS" {
+				record.Type = upper
+			}
+		}
+		if record.Type == "" {
+			return nil, fmt.Errorf("line %d: record for %s has no type", entry.line, owner)
+		}
+
+		switch record.Type {
+		case RECORD_TXT:
+			if len(tokens) == 0 {
+				return nil, fmt.Errorf("line %d: TXT record without strings", entry.line)
+			}
+			for _, token := range tokens {
+				record.Strings = append(record.Strings, token.text)
+			}
+			record.Value = JoinTXT(record.Strings)
+		case RECORD_NULL:
+			parts := make([]string, len(tokens))
+			for i, token := range tokens {
+				parts[i] = token.text
+			}
+			record.Value = strings.Join(parts, " ")
+		case RECORD_CNAME, RECORD_AAAA:
+			if len(tokens) != 1 {
+				return nil, fmt.Errorf("line %d: %s record needs exactly one value", entry.line, record.Type)
+			}
+			record.Value = tokens[0].text
+			if record.Type == RECORD_CNAME {
+				record.Value = strings.TrimSuffix(qualifyZoneName(record.Value, origin), ".")
+			}
+		default:
+			continue // SOA, NS, ... carry no chunks
+		}
+
+		records = append(records, record)
+	}
+
+	return records, nil
+}
+
+// qualifyZoneName resolves "@" and relative names against origin
+func qualifyZoneName(name, origin string) string {
+	switch {
+	case name == "@":
+		return origin + "."
+	case strings.HasSuffix(name, "."):
+		return name
+	case origin == "":
+		return name + "."
+	}
+	return name + "." + origin + "."
+}
+
+// splitZoneEntries tokenizes a zone file into logical records, joining
+// parenthesized continuations and dropping comments
+func splitZoneEntries(r io.Reader) ([]zoneEntry, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), MAX_CHUNKSET_LINE)
+
+	var entries []zoneEntry
+	var current *zoneEntry
+	depth := 0
+
+	for line := 1; scanner.Scan(); line++ {
+		text := scanner.Text()
+		if depth == 0 {
+			current = &zoneEntry{line: line, blankName: text != "" && (text[0] == ' ' || text[0] == '\t')}
+		}
+
+		for i := 0; i < len(text); i++ {
+			ch := text[i]
+			switch {
+			case ch == ';':
+				i = len(text)
+			case ch == ' ' || ch == '\t' || ch == '\r':
+			case ch == '(':
+				depth++
+			case ch == ')':
+				if depth == 0 {
+					return nil, fmt.Errorf("line %d: unbalanced ')'", line)
+				}
+				depth--
+			case ch == '"':
+				value, end, err := readQuoted(text, i+1)
+				if err != nil {
+					return nil, fmt.Errorf("line %d: %w", line, err)
+				}
+				current.tokens = append(current.tokens, zoneToken{text: value, quoted: true})
+				i = end
+			default:
+				end := i
+				for end < len(text) && !strings.ContainsRune(" \t\r;()\"", rune(text[end])) {
+					end++
+				}
+				current.tokens = append(current.tokens, zoneToken{text: text[i:end]})
+				i = end - 1
+			}
+		}
+
+		if depth == 0 && len(current.tokens) > 0 {
+			entries = append(entries, *current)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read zone file: %w", err)
+	}
+	if depth != 0 {
+		return nil, fmt.Errorf("line %d: unclosed '('", current.line)
+	}
+
+	return entries, nil
+}
+
+// readQuoted reads a quoted string starting after its opening quote,
+// returning the unescaped value and the index of the closing quote
+func readQuoted(text string, start int) (string, int, error) {
+	var value strings.Builder
+
+	for i := start; i < len(text); i++ {
+		ch := text[i]
+		switch {
+		case ch == '"':
+			return value.String(), i, nil
+		case ch == '\\' && i+3 < len(text) && isDigits(text[i+1:i+4]):
+			n, _ := strconv.Atoi(text[i+1 : i+4])
+			if n > 255 {
+				return "", 0, fmt.Errorf("invalid escape \\%s", text[i+1:i+4])
+			}
+			value.WriteByte(byte(n))
+			i += 3
+		case ch == '\\' && i+1 < len(text):
+			i++
+			value.WriteByte(text[i])
+		default:
+			value.WriteByte(ch)
+		}
+	}
+
+	return "", 0, errors.New("unterminated quoted string")
+}
+
+// isDigits reports whether s is all decimal digits
+func isDigits(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return s != ""
+}
+
+// ReadZoneChunkSet parses a zone file holding one message (as written by
+// the DNS encoder) and validates it into a chunk set. A manifest naming
+// another carrier is rewritten for TXT, the type the chunks are published
+// as from here on.
+func ReadZoneChunkSet(r io.Reader, chk *Chunker) (*ChunkSet, error) {
+	records, err := ParseZone(r, "")
+	if err != nil {
+		return nil, err
+	}
+
+	byName := make(map[string][]DNSRecord)
+	var manifests []DNSRecord
+	for _, record := range records {
+		name := strings.ToLower(record.Name)
+		byName[name] = append(byName[name], record)
+		if strings.HasPrefix(name, "m-") && record.Type == RECORD_TXT {
+			manifests = append(manifests, record)
+		}
+	}
+	switch len(manifests) {
+	case 0:
+		return nil, errors.New("zone file has no manifest (m-<id>) record")
+	case 1:
+	default:
+		return nil, fmt.Errorf("zone file holds %d manifests, load one message at a time", len(manifests))
+	}
+
+	// m-<id>.data.<domain>
+	label, rest, _ := strings.Cut(strings.ToLower(manifests[0].Name), ".")
+	msgID := strings.TrimPrefix(label, "m-")
+	domain := strings.TrimPrefix(rest, "data.")
+
+	manifest, err := ParseManifest(manifests[0].Value, msgID, domain)
+	if err != nil {
+		return nil, fmt.Errorf("invalid manifest: %w", err)
+	}
+	if manifest.TotalChunks == 0 {
+		return nil, errors.New("manifest lists no chunks")
+	}
+
+	de := NewDNSEncoder(domain)
+	de.SetLogger(DiscardLogger)
+
+	// Keyed names can't be rebuilt without the naming secret, but every
+	// chunk says its own sequence number
+	var keyedNames map[int]string
+	if manifest.KeyedNames {
+		keyedNames = scanChunkNames(byName, de, chk)
+	}
+
+	msg := &Message{CreatedAt: manifest.Timestamp, Metadata: make(map[string]string)}
+	for i := 0; i < manifest.TotalChunks; i++ {
+		name := strings.ToLower(manifest.ChunkName(i))
+		if keyedNames != nil {
+			name = keyedNames[i]
+		}
+		carried := byName[name]
+		if len(carried) == 0 {
+			return nil, fmt.Errorf("chunk %d (%s) missing from zone file", i, name)
+		}
+
+		encoded, err := de.CarrierValue(carried)
+		if err != nil {
+			return nil, fmt.Errorf("chunk %d (%s): %w", i, name, err)
+		}
+		chunk, err := chk.DecodeChunk(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("chunk %d (%s): %w", i, name, err)
+		}
+
+		if i == 0 {
+			msg.ID = chunk.Metadata.MessageID
+			_, msg.Encoding, _ = detectEncoding(encoded)
+			if id := fmt.Sprintf("%x", msg.ID[:8]); id != msgID {
+				return nil, fmt.Errorf("chunks belong to message %s, manifest to %s", id, msgID)
+			}
+		} else if chunk.Metadata.MessageID != msg.ID {
+			return nil, fmt.Errorf("chunk %d (%s) belongs to message %x", i, name, chunk.Metadata.MessageID[:8])
+		}
+
+		chunk.RecordName = strings.TrimSuffix(name, "."+domain)
+		msg.Chunks = append(msg.Chunks, *chunk)
+	}
+
+	value := manifests[0].Value
+	if hasCarrierType(manifest.RecordType) {
+		if manifest.Signed() {
+			return nil, fmt.Errorf("signed manifest names the %s carrier and can't be rewritten for TXT", manifest.RecordType)
+		}
+		manifest.RecordType = ""
+		value = manifest.Value()
+	}
+
+	return &ChunkSet{Message: msg, Manifest: value}, nil
+}
+
+// scanChunkNames maps sequence numbers to the names of the chunk records
+// among a zone's records, skipping manifests and wildcards
+func scanChunkNames(byName map[string][]DNSRecord, de *DNSEncoder, chk *Chunker) map[int]string {
+	names := make(map[int]string)
+	for name, records := range byName {
+		if strings.HasPrefix(name, "m-") || strings.HasPrefix(name, "*.") {
+			continue
+		}
+		encoded, err := de.CarrierValue(records)
+		if err != nil {
+			continue
+		}
+		if chunk, err := chk.DecodeChunk(encoded); err == nil {
+			names[int(chunk.Metadata.Sequence)] = name
+		}
+	}
+	return names
+}
+
+// hasCarrierType reports whether a record type is a carrier other than TXT
+func hasCarrierType(recordType string) bool {
+	return recordType != "" && recordType != RECORD_TXT
+}
+
+// LoadZoneChunkSet reads a zone file (see ReadZoneChunkSet)
+func LoadZoneChunkSet(path string, chk *Chunker) (*ChunkSet, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open zone file: %w", err)
+	}
+	defer file.Close()
+
+	return ReadZoneChunkSet(file, chk)
+}
@@ -0,0 +1,189 @@
+package clientstate
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// ================================================================================
+// CHUNK CACHE
+// Validated chunks kept on disk between retrieval attempts
+// ================================================================================
+
+// LESSON: Never Fetch a Chunk Twice
+// A retrieval that fails at chunk 900 of 1000 used to start over from chunk
+// 0 on the next attempt - 900 queries that add nothing but exposure. The
+// cache keeps every chunk that arrived and passed validation, one file per
+// chunk:
+//
+//   <state dir>/chunks/<msgID>/<seq>-<checksum>.json
+//
+// The key is the chunk's own header (sequence and checksum), not the record
+// it was fetched from, so a chunk republished under another name is still
+// the same entry. The entry remembers that name, so a later attempt knows
+// which queries it can skip. Entries are written to a temp file and renamed,
+// so a second receiver process on the same host never reads half an entry.
+// The cache stores what the wire carried; readers validate entries again
+// before trusting them.
+
+const (
+	// CACHE_DIR is the chunk cache directory inside the state directory
+	CACHE_DIR = "chunks"
+
+	// CACHE_MAX_AGE is how long a message's cached chunks are kept
+	CACHE_MAX_AGE = 7 * 24 * time.Hour
+)
+
+// cacheMessageID restricts message IDs to names safe as directory names
+var cacheMessageID = regexp.MustCompile(`^[0-9a-zA-Z_-]{1,64}$`)
+
+// CachedChunk is one cache entry
+type CachedChunk struct {
+	Name string `json:"name"` // Record the chunk was fetched from
+	Data string `json:"data"` // Encoded chunk as the wire carried it
+}
+
+// ChunkCache stores fetched chunks per message
+type ChunkCache struct {
+	dir string
+}
+
+// OpenChunkCache opens the cache in dir (default: the state directory),
+// dropping messages not touched for CACHE_MAX_AGE
+func OpenChunkCache(dir string) (*ChunkCache, error) {
+	if dir == "" {
+		path, err := Path(CACHE_DIR)
+		if err != nil {
+			return nil, err
+		}
+		dir = path
+	}
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create chunk cache: %w", err)
+	}
+
+	cache := &ChunkCache{dir: dir}
+	cache.prune(CACHE_MAX_AGE)
+	return cache, nil
+}
+
+// Dir returns the cache directory
+func (cc *ChunkCache) Dir() string {
+	return cc.dir
+}
+
+// messageDir returns the directory holding a message's chunks
+func (cc *ChunkCache) messageDir(msgID string) (string, error) {
+	if !cacheMessageID.MatchString(msgID) {
+		return "", fmt.Errorf("message ID %q can't be cached", msgID)
+	}
+	return filepath.Join(cc.dir, msgID), nil
+}
+
+// Put stores a chunk under its (msgID, seq, checksum) key
+func (cc *ChunkCache) Put(msgID string, seq, checksum uint32, entry CachedChunk) error {
+	dir, err := cc.messageDir(msgID)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("failed to create cache dir: %w", err)
+	}
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to marshal cache entry: %w", err)
+	}
+
+	file, err := os.CreateTemp(dir, ".entry-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temp file: %w", err)
+	}
+	tempFile := file.Name()
+
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		os.Remove(tempFile)
+		return fmt.Errorf("failed to write cache entry: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(tempFile)
+		return fmt.Errorf("failed to close cache entry: %w", err)
+	}
+
+	path := filepath.Join(dir, fmt.Sprintf("%d-%08x.json", seq, checksum))
+	if err := os.Rename(tempFile, path); err != nil {
+		os.Remove(tempFile)
+		return fmt.Errorf("failed to rename cache entry: %w", err)
+	}
+
+	return nil
+}
+
+// Load returns every cached chunk of a message. Unreadable entries are
+// skipped; validating the rest is up to the caller.
+func (cc *ChunkCache) Load(msgID string) ([]CachedChunk, error) {
+	dir, err := cc.messageDir(msgID)
+	if err != nil {
+		return nil, err
+	}
+
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read chunk cache: %w", err)
+	}
+
+	var entries []CachedChunk
+	for _, file := range files {
+		if !strings.HasSuffix(file.Name(), ".json") {
+			continue
+		}
+
+		data, err := os.ReadFile(filepath.Join(dir, file.Name()))
+		if err != nil {
+			continue
+		}
+		var entry CachedChunk
+		if err := json.Unmarshal(data, &entry); err != nil || entry.Data == "" {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
+
+// Purge removes every cached chunk of a message
+func (cc *ChunkCache) Purge(msgID string) error {
+	dir, err := cc.messageDir(msgID)
+	if err != nil {
+		return err
+	}
+	return os.RemoveAll(dir)
+}
+
+// prune drops messages whose cache directory hasn't changed for maxAge
+func (cc *ChunkCache) prune(maxAge time.Duration) {
+	dirs, err := os.ReadDir(cc.dir)
+	if err != nil {
+		return
+	}
+
+	cutoff := time.Now().Add(-maxAge)
+	for _, dir := range dirs {
+		info, err := dir.Info()
+		if err != nil || !dir.IsDir() || info.ModTime().After(cutoff) {
+			continue
+		}
+		os.RemoveAll(filepath.Join(cc.dir, dir.Name()))
+	}
+}
@@ -0,0 +1,100 @@
+package clientstate
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+// HISTORY_FILE stores one JSON transfer record per line
+const HISTORY_FILE = "history.jsonl"
+
+// Transfer directions
+const (
+	DirectionUpload   = "upload"
+	DirectionDownload = "download"
+)
+
+// TransferRecord captures statistics for a single transfer
+type TransferRecord struct {
+	MessageID    string        `json:"message_id"`
+	Direction    string        `json:"direction"` // upload or download
+	Server       string        `json:"server"`
+	StartedAt    time.Time     `json:"started_at"`
+	Duration     time.Duration `json:"duration"`
+	Bytes        int           `json:"bytes"`
+	TotalChunks  int           `json:"total_chunks"`
+	FailedChunks int           `json:"failed_chunks"`
+	Retries      int           `json:"retries"`
+	Success      bool          `json:"success"`
+	Error        string        `json:"error,omitempty"`
+}
+
+// Throughput returns effective bytes per second for the transfer
+func (tr TransferRecord) Throughput() float64 {
+	if tr.Duration <= 0 {
+		return 0
+	}
+	return float64(tr.Bytes) / tr.Duration.Seconds()
+}
+
+// AppendHistory adds a transfer record to the history journal
+func AppendHistory(record TransferRecord) error {
+	path, err := Path(HISTORY_FILE)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open history: %w", err)
+	}
+	defer file.Close()
+
+	line, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("failed to marshal record: %w", err)
+	}
+
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("failed to write history: %w", err)
+	}
+
+	return nil
+}
+
+// LoadHistory reads all transfer records, oldest first
+func LoadHistory() ([]TransferRecord, error) {
+	path, err := Path(HISTORY_FILE)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to open history: %w", err)
+	}
+	defer file.Close()
+
+	var records []TransferRecord
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var record TransferRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			// Skip corrupted lines rather than losing the whole history
+			continue
+		}
+		records = append(records, record)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read history: %w", err)
+	}
+
+	return records, nil
+}
@@ -0,0 +1,264 @@
+package clientstate
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ================================================================================
+// FETCH JOURNALS
+// Per-message record of the chunk slots a retrieval has filled so far
+// ================================================================================
+
+// LESSON: Resume, Don't Restart
+// The chunk cache answers "have I seen this chunk before?"; a fetch journal
+// answers "how far did this retrieval get?". It is one append-only file per
+// message:
+//
+//   <state dir>/fetch/<msgID>.journal
+//
+// The first line describes the transfer (manifest fingerprint, chunk count),
+// every further line is one filled slot and its chunk. Appending a line is
+// a single write, so a crash loses at most the line being written - and a
+// torn last line is dropped when the journal is resumed, which rewrites it
+// clean before appending again. A journal written against another manifest
+// (the message was republished) is discarded rather than mixed in.
+
+const (
+	// JOURNAL_DIR is the fetch journal directory inside the state directory
+	JOURNAL_DIR = "fetch"
+
+	// JOURNAL_MAX_AGE is how long an abandoned journal is kept
+	JOURNAL_MAX_AGE = CACHE_MAX_AGE
+
+	// journalMaxLine bounds one journal line (a chunk plus its slot)
+	journalMaxLine = 1 << 20
+)
+
+// FetchState is the header line of a journal
+type FetchState struct {
+	MessageID   string    `json:"message_id"`
+	Manifest    string    `json:"manifest"` // Fingerprint of the manifest the slots belong to
+	TotalChunks int       `json:"total_chunks"`
+	StartedAt   time.Time `json:"started_at"`
+}
+
+// FetchedChunk is one filled slot
+type FetchedChunk struct {
+	Slot int    `json:"slot"`
+	Data string `json:"data"` // Encoded chunk as the wire carried it
+}
+
+// FetchJournals stores one journal per message
+type FetchJournals struct {
+	dir string
+}
+
+// FetchJournal is an open journal being appended to
+type FetchJournal struct {
+	path string
+	mu   sync.Mutex // Fetch workers append concurrently
+	file *os.File
+}
+
+// OpenFetchJournals opens the journal directory (default: the state
+// directory), dropping journals not touched for JOURNAL_MAX_AGE
+func OpenFetchJournals(dir string) (*FetchJournals, error) {
+	if dir == "" {
+		path, err := Path(JOURNAL_DIR)
+		if err != nil {
+			return nil, err
+		}
+		dir = path
+	}
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create journal dir: %w", err)
+	}
+
+	journals := &FetchJournals{dir: dir}
+	journals.prune(JOURNAL_MAX_AGE)
+	return journals, nil
+}
+
+// Dir returns the journal directory
+func (fj *FetchJournals) Dir() string {
+	return fj.dir
+}
+
+// journalPath returns the file holding a message's journal
+func (fj *FetchJournals) journalPath(msgID string) (string, error) {
+	if !cacheMessageID.MatchString(msgID) {
+		return "", fmt.Errorf("message ID %q can't be journaled", msgID)
+	}
+	return filepath.Join(fj.dir, msgID+".journal"), nil
+}
+
+// Resume opens the journal of state.MessageID for appending. If a journal
+// for the same manifest exists, its slots are returned (validating them is
+// up to the caller) and kept; any other journal is started over.
+func (fj *FetchJournals) Resume(state FetchState) (*FetchJournal, []FetchedChunk, error) {
+	path, err := fj.journalPath(state.MessageID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	previous, entries, err := readJournal(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	if previous == nil || previous.Manifest != state.Manifest || previous.TotalChunks != state.TotalChunks {
+		entries = nil
+	} else {
+		state.StartedAt = previous.StartedAt
+	}
+
+	if err := writeJournal(fj.dir, path, state, entries); err != nil {
+		return nil, nil, err
+	}
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open journal: %w", err)
+	}
+	return &FetchJournal{path: path, file: file}, entries, nil
+}
+
+// Remove deletes a message's journal
+func (fj *FetchJournals) Remove(msgID string) error {
+	path, err := fj.journalPath(msgID)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove journal: %w", err)
+	}
+	return nil
+}
+
+// Append records a filled slot
+func (j *FetchJournal) Append(slot int, data string) error {
+	line, err := json.Marshal(FetchedChunk{Slot: slot, Data: data})
+	if err != nil {
+		return fmt.Errorf("failed to marshal journal entry: %w", err)
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.file == nil {
+		return fmt.Errorf("journal is closed")
+	}
+	if _, err := j.file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("failed to write journal: %w", err)
+	}
+	return nil
+}
+
+// Close closes the journal, keeping it on disk
+func (j *FetchJournal) Close() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.file == nil {
+		return nil
+	}
+	err := j.file.Close()
+	j.file = nil
+	return err
+}
+
+// readJournal loads a journal, skipping lines that don't parse. A missing
+// journal or one without a header returns a nil state.
+func readJournal(path string) (*FetchState, []FetchedChunk, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil, nil
+		}
+		return nil, nil, fmt.Errorf("failed to open journal: %w", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), journalMaxLine)
+
+	if !scanner.Scan() {
+		return nil, nil, nil
+	}
+	var state FetchState
+	if err := json.Unmarshal(scanner.Bytes(), &state); err != nil || state.MessageID == "" {
+		return nil, nil, nil
+	}
+
+	var entries []FetchedChunk
+	for scanner.Scan() {
+		var entry FetchedChunk
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil || entry.Data == "" {
+			// A torn line from an interrupted write
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	// A line over journalMaxLine ends the journal; what came before still counts
+
+	return &state, entries, nil
+}
+
+// writeJournal replaces a journal with a header and entries, atomically
+func writeJournal(dir, path string, state FetchState, entries []FetchedChunk) error {
+	file, err := os.CreateTemp(dir, ".journal-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temp file: %w", err)
+	}
+	tempFile := file.Name()
+
+	writer := bufio.NewWriter(file)
+	encoder := json.NewEncoder(writer)
+	err = encoder.Encode(state)
+	for _, entry := range entries {
+		if err != nil {
+			break
+		}
+		err = encoder.Encode(entry)
+	}
+	if err == nil {
+		err = writer.Flush()
+	}
+	if err != nil {
+		file.Close()
+		os.Remove(tempFile)
+		return fmt.Errorf("failed to write journal: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(tempFile)
+		return fmt.Errorf("failed to close journal: %w", err)
+	}
+
+	if err := os.Rename(tempFile, path); err != nil {
+		os.Remove(tempFile)
+		return fmt.Errorf("failed to rename journal: %w", err)
+	}
+	return nil
+}
+
+// prune drops journals that haven't changed for maxAge
+func (fj *FetchJournals) prune(maxAge time.Duration) {
+	files, err := os.ReadDir(fj.dir)
+	if err != nil {
+		return
+	}
+
+	cutoff := time.Now().Add(-maxAge)
+	for _, file := range files {
+		info, err := file.Info()
+		if err != nil || file.IsDir() || !strings.HasSuffix(file.Name(), ".journal") || info.ModTime().After(cutoff) {
+			continue
+		}
+		os.Remove(filepath.Join(fj.dir, file.Name()))
+	}
+}
@@ -0,0 +1,46 @@
+package clientstate
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// ================================================================================
+// CLIENT STATE STORE
+// Local directory shared by stego-send and stego-receive for persistent state
+// ================================================================================
+
+// STATE_DIR_ENV overrides the default state directory
+const STATE_DIR_ENV = "SIMULACRA_HOME"
+
+// DEFAULT_STATE_DIR is created under the user's home directory
+const DEFAULT_STATE_DIR = ".simulacra"
+
+// Dir returns the client state directory, creating it if needed
+func Dir() (string, error) {
+	dir := os.Getenv(STATE_DIR_ENV)
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			// Fall back to working directory
+			home = "."
+		}
+		dir = filepath.Join(home, DEFAULT_STATE_DIR)
+	}
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", fmt.Errorf("failed to create state dir: %w", err)
+	}
+
+	return dir, nil
+}
+
+// Path returns the full path of a file inside the state directory
+func Path(name string) (string, error) {
+	dir, err := Dir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, name), nil
+}
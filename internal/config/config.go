@@ -0,0 +1,243 @@
+package config
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ================================================================================
+// CONFIGURATION FILES
+// Settings for every program from one file and the environment, under the flags
+// ================================================================================
+
+// LESSON: One Place for Settings
+// A channel is a server, a sender and a receiver that must agree on the
+// domain, the encoding, the keys and the pacing. Typing those as flags on
+// three machines is how they drift apart. A config file names them once:
+//
+//   # simulacra.toml                      # simulacra.yaml
+//   domain = "cdn-assets.example.net"     domain: cdn-assets.example.net
+//   encoding = "base32"                   encoding: base32
+//
+//   [dns-server]                          dns-server:
+//   persistent = true                       persistent: true
+//   rate-qps = 50                           rate-qps: 50
+//
+//   [stego-send]                          stego-send:
+//   server = "192.0.2.53:53"                server: 192.0.2.53:53
+//
+// Keys are flag names. Top-level keys go to every program that has such a
+// flag (and are ignored by those that don't); a section goes to one
+// program, where a key it doesn't know is an error - a typo there would
+// otherwise silently keep the default. Lists become comma-separated
+// values, the form list flags take.
+//
+// Every flag can also come from the environment as SIMULACRA_<FLAG>, the
+// name uppercased with '-' as '_' (SIMULACRA_RATE_QPS=50), which suits
+// containers and service managers. What is given last wins:
+//
+//   defaults < config file (top level < section) < environment < command line
+//
+// The file is -config, or SIMULACRA_CONFIG by the same rule. Supported:
+// TOML (.toml), YAML (.yaml, .yml) and JSON (.json), each in the flat
+// subset above - scalars and lists under at most one level of sections.
+
+const (
+	// ENV_PREFIX starts the environment variable of every flag
+	ENV_PREFIX = "SIMULACRA_"
+
+	// FLAG_NAME is the flag naming the config file
+	FLAG_NAME = "config"
+
+	// USAGE describes the -config flag
+	USAGE = "Config file (TOML, YAML or JSON) with defaults for these flags; top-level keys and a section named after the program (env: SIMULACRA_CONFIG, SIMULACRA_<FLAG> overrides each flag)"
+)
+
+// File is a parsed config file
+type File struct {
+	Path     string
+	Global   map[string]string            // Top-level keys
+	Sections map[string]map[string]string // Program -> keys
+}
+
+// newFile returns an empty config file
+func newFile(path string) *File {
+	return &File{
+		Path:     path,
+		Global:   make(map[string]string),
+		Sections: make(map[string]map[string]string),
+	}
+}
+
+// Load reads a config file, its format chosen by extension
+func Load(path string) (*File, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config: %w", err)
+	}
+
+	var file *File
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".toml":
+		file, err = ParseTOML(data)
+	case ".yaml", ".yml":
+		file, err = ParseYAML(data)
+	case ".json":
+		file, err = ParseJSON(data)
+	default:
+		return nil, fmt.Errorf("config %s: unknown format %q (use .toml, .yaml or .json)", path, ext)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("config %s: %w", path, err)
+	}
+	file.Path = path
+	return file, nil
+}
+
+// EnvName returns the environment variable of a flag
+func EnvName(flagName string) string {
+	return ENV_PREFIX + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// Apply fills in the flags of fs the command line didn't set: from the
+// environment, then from the config file's section for program, then its
+// top level. Call it right after fs is parsed; it returns the config file
+// used ("" = none).
+func Apply(fs *flag.FlagSet, program string) (string, error) {
+	given := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { given[f.Name] = true })
+
+	var envErr error
+	fs.VisitAll(func(f *flag.Flag) {
+		if given[f.Name] || envErr != nil {
+			return
+		}
+		if value, ok := os.LookupEnv(EnvName(f.Name)); ok {
+			if err := fs.Set(f.Name, value); err != nil {
+				envErr = fmt.Errorf("%s: %w", EnvName(f.Name), err)
+			}
+			given[f.Name] = true
+		}
+	})
+	if envErr != nil {
+		return "", envErr
+	}
+
+	configFlag := fs.Lookup(FLAG_NAME)
+	if configFlag == nil || configFlag.Value.String() == "" {
+		return "", nil
+	}
+	file, err := Load(configFlag.Value.String())
+	if err != nil {
+		return "", err
+	}
+	return file.Path, file.apply(fs, program, given)
+}
+
+// apply sets the flags not in given from the file
+func (f *File) apply(fs *flag.FlagSet, program string, given map[string]bool) error {
+	section := f.Sections[program]
+	for _, key := range sortedKeys(section) {
+		if key == FLAG_NAME {
+			return fmt.Errorf("[%s] %s: a config file can't name another", program, key)
+		}
+		if fs.Lookup(key) == nil {
+			return fmt.Errorf("[%s] unknown setting %q (not a flag of %s)", program, key, program)
+		}
+	}
+
+	for _, key := range sortedKeys(f.Global) {
+		if _, overridden := section[key]; overridden || key == FLAG_NAME || given[key] || fs.Lookup(key) == nil {
+			continue
+		}
+		if err := fs.Set(key, f.Global[key]); err != nil {
+			return fmt.Errorf("%s: %w", key, err)
+		}
+	}
+	for _, key := range sortedKeys(section) {
+		if given[key] {
+			continue
+		}
+		if err := fs.Set(key, section[key]); err != nil {
+			return fmt.Errorf("[%s] %s: %w", program, key, err)
+		}
+	}
+	return nil
+}
+
+// ParseJSON reads a config file written as JSON: scalars and arrays at the
+// top level, objects as sections
+func ParseJSON(data []byte) (*File, error) {
+	var raw map[string]interface{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("invalid JSON: %w", err)
+	}
+
+	file := newFile("")
+	for key, value := range raw {
+		object, isSection := value.(map[string]interface{})
+		if !isSection {
+			text, err := jsonValue(value)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", key, err)
+			}
+			file.Global[key] = text
+			continue
+		}
+
+		section := make(map[string]string)
+		for name, value := range object {
+			text, err := jsonValue(value)
+			if err != nil {
+				return nil, fmt.Errorf("%s.%s: %w", key, name, err)
+			}
+			section[name] = text
+		}
+		file.Sections[key] = section
+	}
+	return file, nil
+}
+
+// jsonValue renders a JSON scalar or array of scalars as a flag value
+func jsonValue(value interface{}) (string, error) {
+	switch v := value.(type) {
+	case string:
+		return v, nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case []interface{}:
+		items := make([]string, 0, len(v))
+		for _, item := range v {
+			if _, nested := item.([]interface{}); nested {
+				return "", fmt.Errorf("nested lists aren't supported")
+			}
+			text, err := jsonValue(item)
+			if err != nil {
+				return "", err
+			}
+			items = append(items, text)
+		}
+		return strings.Join(items, ","), nil
+	case nil:
+		return "", nil
+	}
+	return "", fmt.Errorf("only scalars, lists and one level of sections are supported")
+}
+
+// sortedKeys returns a map's keys in order, so errors are reproducible
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
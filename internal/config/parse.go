@@ -0,0 +1,375 @@
+package config
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ================================================================================
+// TOML AND YAML
+// Just enough of each to hold flag values
+// ================================================================================
+
+// LESSON: A Subset on Purpose
+// Flags are strings, numbers, booleans and comma-separated lists, so a
+// config file needs nothing more: no nested tables, anchors, multi-line
+// strings or dates. Parsing that subset here keeps the programs free of
+// dependencies, and anything outside it is an error with a line number
+// rather than a silent misreading.
+//
+//   TOML: key = value, [section]; values are "strings", 'literal strings',
+//         numbers, true/false and [lists]; # comments
+//   YAML: key: value, "section:" with indented keys below; values plain,
+//         "double" or 'single' quoted, [flow, lists] or "- item" lines;
+//         # comments
+
+// ParseTOML reads a config file written in TOML
+func ParseTOML(data []byte) (*File, error) {
+	file := newFile("")
+	target := file.Global
+	section := ""
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(stripComment(scanner.Text()))
+		if text == "" {
+			continue
+		}
+
+		if strings.HasPrefix(text, "[") {
+			if !strings.HasSuffix(text, "]") || strings.HasPrefix(text, "[[") {
+				return nil, fmt.Errorf("line %d: malformed section header %q", line, text)
+			}
+			name, err := tomlKey(strings.TrimSpace(text[1 : len(text)-1]))
+			if err != nil || strings.Contains(name, ".") && !strings.HasPrefix(strings.TrimSpace(text[1:]), "\"") {
+				return nil, fmt.Errorf("line %d: section %q: only one level of sections is supported", line, text)
+			}
+			if _, exists := file.Sections[name]; exists {
+				return nil, fmt.Errorf("line %d: section [%s] given twice", line, name)
+			}
+			section = name
+			target = make(map[string]string)
+			file.Sections[name] = target
+			continue
+		}
+
+		rawKey, rawValue, ok := strings.Cut(text, "=")
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected key = value, got %q", line, text)
+		}
+		key, err := tomlKey(strings.TrimSpace(rawKey))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		value, err := tomlValue(strings.TrimSpace(rawValue))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s: %w", line, key, err)
+		}
+		if _, exists := target[key]; exists {
+			return nil, fmt.Errorf("line %d: %s given twice%s", line, key, inSection(section))
+		}
+		target[key] = value
+	}
+	return file, scanner.Err()
+}
+
+// tomlKey reads a bare or quoted key
+func tomlKey(text string) (string, error) {
+	if strings.HasPrefix(text, "\"") || strings.HasPrefix(text, "'") {
+		return unquote(text)
+	}
+	if text == "" {
+		return "", fmt.Errorf("empty key")
+	}
+	for _, r := range text {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_' || r == '.') {
+			return "", fmt.Errorf("key %q needs quotes", text)
+		}
+	}
+	return text, nil
+}
+
+// tomlValue reads a scalar or a single-line list
+func tomlValue(text string) (string, error) {
+	switch {
+	case text == "":
+		return "", fmt.Errorf("missing value")
+	case strings.HasPrefix(text, "\"\"\"") || strings.HasPrefix(text, "'''"):
+		return "", fmt.Errorf("multi-line strings aren't supported")
+	case strings.HasPrefix(text, "\"") || strings.HasPrefix(text, "'"):
+		return unquote(text)
+	case strings.HasPrefix(text, "["):
+		return flowList(text, tomlValue)
+	case text == "true" || text == "false":
+		return text, nil
+	}
+	number := strings.ReplaceAll(text, "_", "")
+	if _, err := strconv.ParseFloat(number, 64); err == nil {
+		return number, nil
+	}
+	return "", fmt.Errorf("%q isn't a TOML value (strings need quotes)", text)
+}
+
+// ParseYAML reads a config file written in YAML
+func ParseYAML(data []byte) (*File, error) {
+	file := newFile("")
+	var lines []yamlLine
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; scanner.Scan(); line++ {
+		raw := strings.TrimRight(stripComment(scanner.Text()), " \t")
+		text := strings.TrimLeft(raw, " ")
+		if text == "" || text == "---" {
+			continue
+		}
+		if strings.HasPrefix(text, "\t") || strings.HasPrefix(raw, "\t") {
+			return nil, fmt.Errorf("line %d: YAML is indented with spaces, not tabs", line)
+		}
+		lines = append(lines, yamlLine{number: line, indent: len(raw) - len(text), text: text})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := 0; i < len(lines); {
+		line := lines[i]
+		if line.indent != 0 {
+			return nil, fmt.Errorf("line %d: unexpected indentation", line.number)
+		}
+		key, value, err := line.keyValue()
+		if err != nil {
+			return nil, err
+		}
+		i++
+
+		// A key without a value opens a section or a block list
+		if value == "" && i < len(lines) && lines[i].indent > 0 {
+			if strings.HasPrefix(lines[i].text, "- ") || lines[i].text == "-" {
+				list, next, err := yamlBlockList(lines, i, lines[i].indent)
+				if err != nil {
+					return nil, err
+				}
+				file.Global[key], i = list, next
+				continue
+			}
+			section, next, err := yamlSection(lines, i)
+			if err != nil {
+				return nil, err
+			}
+			if _, exists := file.Sections[key]; exists {
+				return nil, fmt.Errorf("line %d: section %s given twice", line.number, key)
+			}
+			file.Sections[key], i = section, next
+			continue
+		}
+
+		if _, exists := file.Global[key]; exists {
+			return nil, fmt.Errorf("line %d: %s given twice", line.number, key)
+		}
+		if file.Global[key], err = yamlValue(value); err != nil {
+			return nil, fmt.Errorf("line %d: %s: %w", line.number, key, err)
+		}
+	}
+	return file, nil
+}
+
+// yamlLine is one non-blank line of a YAML file
+type yamlLine struct {
+	number int
+	indent int
+	text   string
+}
+
+// keyValue splits a "key: value" line
+func (l yamlLine) keyValue() (string, string, error) {
+	key, value, ok := strings.Cut(l.text, ":")
+	if strings.HasPrefix(l.text, "\"") || strings.HasPrefix(l.text, "'") {
+		end := strings.LastIndex(l.text, l.text[:1]+":")
+		if end < 1 {
+			return "", "", fmt.Errorf("line %d: malformed quoted key", l.number)
+		}
+		quoted, err := unquote(l.text[:end+1])
+		if err != nil {
+			return "", "", fmt.Errorf("line %d: %w", l.number, err)
+		}
+		return quoted, strings.TrimSpace(l.text[end+2:]), nil
+	}
+	if !ok || strings.HasPrefix(l.text, "- ") {
+		return "", "", fmt.Errorf("line %d: expected key: value, got %q", l.number, l.text)
+	}
+	if value != "" && value[0] != ' ' {
+		return "", "", fmt.Errorf("line %d: expected a space after %q", l.number, key+":")
+	}
+	return strings.TrimSpace(key), strings.TrimSpace(value), nil
+}
+
+// yamlSection reads the indented keys of a section starting at lines[i]
+func yamlSection(lines []yamlLine, i int) (map[string]string, int, error) {
+	section := make(map[string]string)
+	indent := lines[i].indent
+	for i < len(lines) && lines[i].indent > 0 {
+		line := lines[i]
+		if line.indent != indent {
+			return nil, 0, fmt.Errorf("line %d: only one level of sections is supported", line.number)
+		}
+		key, value, err := line.keyValue()
+		if err != nil {
+			return nil, 0, err
+		}
+		if _, exists := section[key]; exists {
+			return nil, 0, fmt.Errorf("line %d: %s given twice", line.number, key)
+		}
+		i++
+
+		if value == "" && i < len(lines) && lines[i].indent > indent {
+			if !strings.HasPrefix(lines[i].text, "-") {
+				return nil, 0, fmt.Errorf("line %d: only one level of sections is supported", lines[i].number)
+			}
+			section[key], i, err = yamlBlockList(lines, i, lines[i].indent)
+			if err != nil {
+				return nil, 0, err
+			}
+			continue
+		}
+		if section[key], err = yamlValue(value); err != nil {
+			return nil, 0, fmt.Errorf("line %d: %s: %w", line.number, key, err)
+		}
+	}
+	return section, i, nil
+}
+
+// yamlBlockList reads "- item" lines at indent starting at lines[i]
+func yamlBlockList(lines []yamlLine, i, indent int) (string, int, error) {
+	var items []string
+	for ; i < len(lines) && lines[i].indent >= indent; i++ {
+		line := lines[i]
+		if line.indent != indent || !(strings.HasPrefix(line.text, "- ") || line.text == "-") {
+			return "", 0, fmt.Errorf("line %d: expected a list item", line.number)
+		}
+		item, err := yamlValue(strings.TrimSpace(strings.TrimPrefix(line.text, "-")))
+		if err != nil {
+			return "", 0, fmt.Errorf("line %d: %w", line.number, err)
+		}
+		items = append(items, item)
+	}
+	return strings.Join(items, ","), i, nil
+}
+
+// yamlValue reads a scalar or a flow list
+func yamlValue(text string) (string, error) {
+	switch {
+	case strings.HasPrefix(text, "\"") || strings.HasPrefix(text, "'"):
+		return unquote(text)
+	case strings.HasPrefix(text, "["):
+		return flowList(text, yamlValue)
+	case strings.HasPrefix(text, "{"):
+		return "", fmt.Errorf("only one level of sections is supported")
+	case strings.HasPrefix(text, "|") || strings.HasPrefix(text, ">"):
+		return "", fmt.Errorf("multi-line strings aren't supported")
+	case strings.HasPrefix(text, "&") || strings.HasPrefix(text, "*"):
+		return "", fmt.Errorf("anchors and aliases aren't supported")
+	}
+	switch strings.ToLower(text) {
+	case "~", "null":
+		return "", nil
+	case "yes", "on":
+		return "true", nil
+	case "no", "off":
+		return "false", nil
+	}
+	return text, nil
+}
+
+// flowList reads a single-line [a, b, c] list into a comma-separated value
+func flowList(text string, item func(string) (string, error)) (string, error) {
+	if !strings.HasSuffix(text, "]") {
+		return "", fmt.Errorf("lists must be closed on the same line")
+	}
+	inner := strings.TrimSpace(text[1 : len(text)-1])
+	if inner == "" {
+		return "", nil
+	}
+
+	var items []string
+	for _, field := range splitList(inner) {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue // Trailing comma
+		}
+		if strings.HasPrefix(field, "[") {
+			return "", fmt.Errorf("nested lists aren't supported")
+		}
+		value, err := item(field)
+		if err != nil {
+			return "", err
+		}
+		items = append(items, value)
+	}
+	return strings.Join(items, ","), nil
+}
+
+// splitList splits list items at commas outside quotes
+func splitList(text string) []string {
+	var fields []string
+	var quote rune
+	start := 0
+	for i, r := range text {
+		switch {
+		case quote != 0:
+			if r == quote && (quote == '\'' || i == 0 || text[i-1] != '\\') {
+				quote = 0
+			}
+		case r == '"' || r == '\'':
+			quote = r
+		case r == ',':
+			fields = append(fields, text[start:i])
+			start = i + 1
+		}
+	}
+	return append(fields, text[start:])
+}
+
+// stripComment drops a # comment that isn't inside quotes
+func stripComment(line string) string {
+	var quote rune
+	for i, r := range line {
+		switch {
+		case quote != 0:
+			if r == quote && (quote == '\'' || line[i-1] != '\\') {
+				quote = 0
+			}
+		case r == '"' || r == '\'':
+			quote = r
+		case r == '#' && (i == 0 || line[i-1] == ' ' || line[i-1] == '\t'):
+			return line[:i]
+		}
+	}
+	return line
+}
+
+// unquote reads a "double" (with escapes) or 'single' quoted string
+func unquote(text string) (string, error) {
+	if len(text) < 2 || text[len(text)-1] != text[0] {
+		return "", fmt.Errorf("unterminated string %s", text)
+	}
+	if text[0] == '\'' {
+		inner := text[1 : len(text)-1]
+		return strings.ReplaceAll(inner, "''", "'"), nil
+	}
+	value, err := strconv.Unquote(text)
+	if err != nil {
+		return "", fmt.Errorf("invalid string %s", text)
+	}
+	return value, nil
+}
+
+// inSection names the section an error is in
+func inSection(section string) string {
+	if section == "" {
+		return ""
+	}
+	return " in [" + section + "]"
+}
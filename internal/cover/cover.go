@@ -0,0 +1,242 @@
+package cover
+
+import (
+	"context"
+	"fmt"
+	"github.com/miekg/dns"
+	"math"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ================================================================================
+// THEORY LESSON: Cover Traffic That Looks Like Someone
+// ================================================================================
+//
+// A cover query only hides anything if it looks like the traffic around
+// it. Four lookups of the same four hostnames, always type A, spaced
+// like a metronome, are a signature of their own. Real DNS traffic from a
+// workstation has three properties an Engine reproduces:
+//
+//   Popularity - a few services get most lookups and a long tail gets the
+//                rest: sites are weighted by rank (Zipf's law)
+//   Type mix   - mostly A and AAAA, some TXT and MX (see DefaultMix)
+//   Rhythm     - long quiet spells, reading, and page loads that fire a
+//                dozen lookups of one site at once
+//
+// The rhythm is a Markov chain over three states. Each query moves the
+// chain, and the pause before the next one is drawn from an exponential
+// distribution around the new state's mean:
+//
+//   STATE     MEAN PAUSE   MOSTLY GOES TO
+//   idle      20s          idle, sometimes browsing
+//   browsing  2s           browsing or a page load
+//   loading   150ms        more of the page load, then browsing
+//
+// During a page load most lookups stay on the site being loaded, the way
+// a browser fetches a page's CDN, font and API hosts together.
+//
+// The clients use an Engine two ways: single cover queries interleaved
+// with their own (a ratio per chunk query), and Run, which follows the
+// chain's own timing in the background for as long as a client works.
+// ================================================================================
+
+// State is a state of the browsing Markov chain
+type State int
+
+const (
+	STATE_IDLE     State = iota // Nobody at the keyboard
+	STATE_BROWSING              // Reading, following a link now and then
+	STATE_LOADING               // A page load: many lookups at once, mostly of one site
+)
+
+// Engine tuning
+const (
+	// ZIPF_EXPONENT sets how steeply site popularity falls with rank
+	ZIPF_EXPONENT = 0.9
+
+	// SAME_SITE is the chance a page-load lookup stays on the page's site
+	SAME_SITE = 0.8
+)
+
+// stateNames name the states for Describe
+var stateNames = [...]string{"idle", "browsing", "loading"}
+
+// meanGaps are the mean pauses before a query in each state
+var meanGaps = [...]time.Duration{
+	STATE_IDLE:     20 * time.Second,
+	STATE_BROWSING: 2 * time.Second,
+	STATE_LOADING:  150 * time.Millisecond,
+}
+
+// transitions[s][t] is the chance of moving from state s to state t
+var transitions = [...][3]float64{
+	STATE_IDLE:     {0.55, 0.40, 0.05},
+	STATE_BROWSING: {0.15, 0.50, 0.35},
+	STATE_LOADING:  {0.02, 0.28, 0.70},
+}
+
+// Config selects what an Engine asks for and how busily
+type Config struct {
+	Sites []Site  // Most popular first (nil = DefaultSites)
+	Mix   Mix     // Query type weights (nil = DefaultMix)
+	Tempo float64 // Scales the chain's pauses (0 = 1; 0.5 = twice as busy)
+}
+
+// Engine produces cover queries and the timing between them. It is safe
+// for concurrent use.
+type Engine struct {
+	sites     []Site
+	siteCum   []float64 // Cumulative site weights
+	types     []uint16
+	typeCum   []float64 // Cumulative query type weights
+	tempo     float64
+	mix       Mix
+	mu        sync.Mutex
+	rng       *rand.Rand
+	state     State
+	site      int // Site of the current page load
+	generated atomic.Int64
+}
+
+// New creates an engine, filling in defaults
+func New(config Config) (*Engine, error) {
+	if config.Sites == nil {
+		config.Sites = DefaultSites
+	}
+	if len(config.Sites) == 0 {
+		return nil, fmt.Errorf("cover traffic needs at least one site")
+	}
+	if config.Mix == nil {
+		config.Mix = DefaultMix
+	}
+	if err := config.Mix.check(); err != nil {
+		return nil, err
+	}
+	if config.Tempo < 0 {
+		return nil, fmt.Errorf("cover tempo can't be negative")
+	}
+	if config.Tempo == 0 {
+		config.Tempo = 1
+	}
+
+	e := &Engine{
+		sites: config.Sites,
+		tempo: config.Tempo,
+		mix:   config.Mix,
+		rng:   rand.New(rand.NewSource(time.Now().UnixNano())),
+		state: STATE_BROWSING,
+	}
+	total := 0.0
+	for rank := range e.sites {
+		total += 1 / math.Pow(float64(rank+1), ZIPF_EXPONENT)
+		e.siteCum = append(e.siteCum, total)
+	}
+	total = 0
+	for _, qtype := range config.Mix.types() {
+		if config.Mix[qtype] > 0 {
+			total += config.Mix[qtype]
+			e.types = append(e.types, qtype)
+			e.typeCum = append(e.typeCum, total)
+		}
+	}
+	return e, nil
+}
+
+// Default returns an engine with the default sites, mix and tempo
+func Default() *Engine {
+	e, _ := New(Config{})
+	return e
+}
+
+// Next picks the name and type of the next cover query
+func (e *Engine) Next() (string, uint16) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.state != STATE_LOADING || e.rng.Float64() >= SAME_SITE {
+		e.site = pick(e.rng, e.siteCum)
+	}
+	site := e.sites[e.site]
+	qtype := e.types[pick(e.rng, e.typeCum)]
+
+	// Mail and policy records belong to the domain, addresses to its hosts
+	name := site.Domain
+	if (qtype == dns.TypeA || qtype == dns.TypeAAAA) && len(site.Hosts) > 0 {
+		name = site.Hosts[e.rng.Intn(len(site.Hosts))]
+	}
+	return name, qtype
+}
+
+// Query builds the next cover query
+func (e *Engine) Query() *dns.Msg {
+	name, qtype := e.Next()
+	m := new(dns.Msg)
+	m.SetQuestion(dns.Fqdn(name), qtype)
+	e.generated.Add(1)
+	return m
+}
+
+// Gap moves the chain one step and returns the pause before the next
+// query in the new state
+func (e *Engine) Gap() time.Duration {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	row := transitions[e.state]
+	e.state = State(pick(e.rng, []float64{row[0], row[0] + row[1], row[0] + row[1] + row[2]}))
+	mean := float64(meanGaps[e.state]) * e.tempo
+	return time.Duration(e.rng.ExpFloat64() * mean)
+}
+
+// Run sends cover queries at the chain's timing until ctx is done
+func (e *Engine) Run(ctx context.Context, send func(context.Context, *dns.Msg)) {
+	for {
+		timer := time.NewTimer(e.Gap())
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		send(ctx, e.Query())
+	}
+}
+
+// Generated returns how many cover queries the engine has built
+func (e *Engine) Generated() int64 {
+	return e.generated.Load()
+}
+
+// State returns the chain's current state
+func (e *Engine) State() State {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.state
+}
+
+// String names a state
+func (s State) String() string {
+	if s < 0 || int(s) >= len(stateNames) {
+		return fmt.Sprintf("state(%d)", int(s))
+	}
+	return stateNames[s]
+}
+
+// Describe summarizes the engine's settings for display
+func (e *Engine) Describe() string {
+	return fmt.Sprintf("%d sites, mix %s, tempo %gx", len(e.sites), e.mix, e.tempo)
+}
+
+// pick draws an index from cumulative weights
+func pick(rng *rand.Rand, cumulative []float64) int {
+	x := rng.Float64() * cumulative[len(cumulative)-1]
+	for i, c := range cumulative {
+		if x < c {
+			return i
+		}
+	}
+	return len(cumulative) - 1
+}
@@ -0,0 +1,174 @@
+package cover
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/miekg/dns"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ================================================================================
+// COVER DOMAINS AND QUERY MIXES
+// What ordinary DNS traffic asks for, and how often
+// ================================================================================
+
+// Site is one service cover queries may ask about
+type Site struct {
+	Domain string   // Registrable domain, asked for MX and TXT
+	Hosts  []string // Names under it asked for A and AAAA (none = the domain itself)
+}
+
+// DefaultSites are popular services in rough order of popularity; a
+// site's weight falls with its rank (see New)
+var DefaultSites = []Site{
+	{"google.com", []string{"www.google.com", "accounts.google.com", "mail.google.com", "apis.google.com"}},
+	{"googleapis.com", []string{"fonts.googleapis.com", "www.googleapis.com", "ajax.googleapis.com"}},
+	{"gstatic.com", []string{"www.gstatic.com", "fonts.gstatic.com", "ssl.gstatic.com"}},
+	{"microsoft.com", []string{"www.microsoft.com", "login.microsoftonline.com", "graph.microsoft.com"}},
+	{"apple.com", []string{"www.apple.com", "gateway.icloud.com", "gsp-ssl.ls.apple.com"}},
+	{"youtube.com", []string{"www.youtube.com", "i.ytimg.com", "yt3.ggpht.com"}},
+	{"facebook.com", []string{"www.facebook.com", "graph.facebook.com", "static.xx.fbcdn.net"}},
+	{"amazonaws.com", []string{"s3.amazonaws.com", "dynamodb.us-east-1.amazonaws.com", "sts.amazonaws.com"}},
+	{"cloudflare.com", []string{"www.cloudflare.com", "cdnjs.cloudflare.com", "challenges.cloudflare.com"}},
+	{"office.com", []string{"www.office.com", "outlook.office.com", "outlook.office365.com"}},
+	{"windowsupdate.com", []string{"ctldl.windowsupdate.com", "download.windowsupdate.com"}},
+	{"akamaiedge.net", []string{"e1234.dscb.akamaiedge.net", "e673.dsce9.akamaiedge.net"}},
+	{"instagram.com", []string{"www.instagram.com", "i.instagram.com"}},
+	{"amazon.com", []string{"www.amazon.com", "images-na.ssl-images-amazon.com", "fls-na.amazon.com"}},
+	{"github.com", []string{"github.com", "api.github.com", "avatars.githubusercontent.com", "raw.githubusercontent.com"}},
+	{"linkedin.com", []string{"www.linkedin.com", "static.licdn.com", "media.licdn.com"}},
+	{"twitter.com", []string{"twitter.com", "api.twitter.com", "abs.twimg.com", "pbs.twimg.com"}},
+	{"wikipedia.org", []string{"en.wikipedia.org", "upload.wikimedia.org", "www.wikipedia.org"}},
+	{"netflix.com", []string{"www.netflix.com", "assets.nflxext.com", "occ-0-1-1.1.nflxso.net"}},
+	{"zoom.us", []string{"zoom.us", "us02web.zoom.us", "st1.zoom.us"}},
+	{"slack.com", []string{"app.slack.com", "edgeapi.slack.com", "a.slack-edge.com"}},
+	{"doubleclick.net", []string{"ad.doubleclick.net", "stats.g.doubleclick.net", "googleads.g.doubleclick.net"}},
+	{"jsdelivr.net", []string{"cdn.jsdelivr.net", "fastly.jsdelivr.net"}},
+	{"office.net", []string{"res.cdn.office.net", "statics.teams.cdn.office.net"}},
+	{"reddit.com", []string{"www.reddit.com", "styles.redditmedia.com", "i.redd.it"}},
+	{"dropbox.com", []string{"www.dropbox.com", "client.dropbox.com", "dl.dropboxusercontent.com"}},
+	{"spotify.com", []string{"open.spotify.com", "api.spotify.com", "i.scdn.co"}},
+	{"adobe.com", []string{"www.adobe.com", "use.typekit.net", "assets.adobedtm.com"}},
+	{"yahoo.com", []string{"www.yahoo.com", "s.yimg.com", "mail.yahoo.com"}},
+	{"bing.com", []string{"www.bing.com", "th.bing.com"}},
+	{"whatsapp.net", []string{"web.whatsapp.com", "mmg.whatsapp.net", "g.whatsapp.net"}},
+	{"ubuntu.com", []string{"archive.ubuntu.com", "security.ubuntu.com", "api.snapcraft.io"}},
+	{"npmjs.org", []string{"registry.npmjs.org", "www.npmjs.com"}},
+	{"pypi.org", []string{"pypi.org", "files.pythonhosted.org"}},
+	{"docker.io", []string{"registry-1.docker.io", "auth.docker.io", "production.cloudflare.docker.com"}},
+	{"stackoverflow.com", []string{"stackoverflow.com", "cdn.sstatic.net", "i.sstatic.net"}},
+	{"salesforce.com", []string{"login.salesforce.com", "c.la1-c2-ia2.salesforceliveagent.com"}},
+	{"atlassian.net", []string{"id.atlassian.com", "api.atlassian.com", "aui-cdn.atlassian.com"}},
+	{"digicert.com", []string{"ocsp.digicert.com", "crl3.digicert.com"}},
+	{"letsencrypt.org", []string{"r3.o.lencr.org", "x1.c.lencr.org"}},
+}
+
+// DefaultMix weights the query types of cover queries. A and AAAA
+// dominate real traffic; TXT and MX are rarer, but not absent - and TXT
+// is what chunk queries ask for.
+var DefaultMix = Mix{
+	dns.TypeA:    50,
+	dns.TypeAAAA: 30,
+	dns.TypeTXT:  12,
+	dns.TypeMX:   8,
+}
+
+// Mix weights query types by how often cover queries ask for them
+type Mix map[uint16]float64
+
+// String renders a mix as TYPE=weight pairs, heaviest first
+func (m Mix) String() string {
+	types := m.types()
+	parts := make([]string, len(types))
+	for i, qtype := range types {
+		parts[i] = fmt.Sprintf("%s=%g", dns.TypeToString[qtype], m[qtype])
+	}
+	return strings.Join(parts, ",")
+}
+
+// types lists the mix's query types, heaviest first
+func (m Mix) types() []uint16 {
+	types := make([]uint16, 0, len(m))
+	for qtype := range m {
+		types = append(types, qtype)
+	}
+	sort.Slice(types, func(i, j int) bool {
+		if m[types[i]] != m[types[j]] {
+			return m[types[i]] > m[types[j]]
+		}
+		return types[i] < types[j]
+	})
+	return types
+}
+
+// ParseMix reads a query type mix like "A=50,AAAA=30,TXT=12,MX=8"
+func ParseMix(spec string) (Mix, error) {
+	mix := make(Mix)
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(part, "=")
+		if !ok {
+			return nil, fmt.Errorf("%q: use TYPE=weight", part)
+		}
+		qtype, known := dns.StringToType[strings.ToUpper(strings.TrimSpace(name))]
+		if !known {
+			return nil, fmt.Errorf("unknown query type %q", name)
+		}
+		weight, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil || weight < 0 {
+			return nil, fmt.Errorf("%q: weight must be a number of 0 or more", part)
+		}
+		mix[qtype] = weight
+	}
+	return mix, mix.check()
+}
+
+// check rejects mixes that can't pick anything
+func (m Mix) check() error {
+	for _, weight := range m {
+		if weight > 0 {
+			return nil
+		}
+	}
+	return fmt.Errorf("query mix has no type with a weight above 0")
+}
+
+// LoadSites reads a site list: one site per line, its domain followed by
+// the host names asked for under it, most popular site first. Blank lines
+// and # comments are skipped.
+func LoadSites(path string) ([]Site, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open site list: %w", err)
+	}
+	defer file.Close()
+
+	var sites []Site
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		text, _, _ := strings.Cut(scanner.Text(), "#")
+		fields := strings.Fields(text)
+		if len(fields) == 0 {
+			continue
+		}
+		for _, name := range fields {
+			if _, ok := dns.IsDomainName(name); !ok {
+				return nil, fmt.Errorf("%s:%d: %q is not a domain name", path, line, name)
+			}
+		}
+		sites = append(sites, Site{Domain: fields[0], Hosts: fields[1:]})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read site list: %w", err)
+	}
+	if len(sites) == 0 {
+		return nil, fmt.Errorf("%s lists no sites", path)
+	}
+	return sites, nil
+}
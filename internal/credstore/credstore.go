@@ -0,0 +1,296 @@
+package credstore
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"github.com/faanross/simulacra_txt/internal/clientstate"
+	"github.com/faanross/simulacra_txt/internal/scrypto"
+	"github.com/faanross/simulacra_txt/internal/spec"
+	"golang.org/x/crypto/pbkdf2"
+	"io"
+	"os"
+	"sort"
+)
+
+// ================================================================================
+// ENCRYPTED CREDENTIAL STORE
+// Holds API keys, HMAC secrets and pinned fingerprints for the client binaries
+// ================================================================================
+
+// LESSON: Secrets at Rest
+// Credentials are kept in a single JSON map which is sealed with AES-256-GCM.
+// The key is derived from a master password with PBKDF2, exactly like the
+// stego payloads, so the same security parameters apply to both.
+//
+// The header (version, salt, iterations) sits in the clear next to the
+// ciphertext, so it is bound to it as GCM additional data: change any of
+// it and the file no longer decrypts. The iteration count is checked
+// against a fixed range before deriving anything - a tampered file can't
+// weaken the key with 0 iterations or hang the client with 2^31.
+
+// DEFAULT_FILE is the credential file name inside the client state directory
+const DEFAULT_FILE = "credentials.enc"
+
+// PASSWORD_ENV allows unattended unlocking (e.g. from a service manager)
+const PASSWORD_ENV = "SIMULACRA_CREDS_PASSWORD"
+
+// FILE_VERSION identifies the on-disk format written; version 1 files
+// (header not authenticated) are still read, and rewritten as the current
+// version on the next Save
+const FILE_VERSION = 2
+
+// PBKDF2 iteration counts accepted from a credential file
+const (
+	MIN_ITERATIONS = 100_000
+	MAX_ITERATIONS = 10_000_000
+)
+
+// Well-known credential names used by the clients
+const (
+	CRED_API_KEY         = "api-key"
+	CRED_HMAC_SECRET     = "hmac-secret"
+	CRED_DECODE_PASSWORD = "decode-password"
+	CRED_CHUNK_AUTH_KEY  = "chunk-auth-key"
+	CRED_SIGNING_KEY     = "manifest-signing-key" // Ed25519 seed, hex (sender)
+	CRED_VERIFY_KEY      = "manifest-verify-key"  // Ed25519 public key, hex (receiver)
+	CRED_TSIG_KEY        = "tsig-key"             // [algorithm:]name:secret for DNS UPDATE (sender)
+	CRED_NAME_KEY        = "chunk-name-key"       // Secret keying {word} and {sub:...} chunk names
+	CRED_QNAME_KEY       = "qname-upload-key"     // Secret signing QNAME upload queries (sender)
+)
+
+// sealedFile is the on-disk representation
+type sealedFile struct {
+	Version    int    `json:"version"`
+	Iterations int    `json:"iterations"`
+	Salt       []byte `json:"salt"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// Store is an unlocked credential store
+type Store struct {
+	path       string
+	password   []byte
+	iterations int
+	entries    map[string]string
+}
+
+// DefaultPath returns the credential file location in the state directory
+func DefaultPath() (string, error) {
+	return clientstate.Path(DEFAULT_FILE)
+}
+
+// Open unlocks the credential file at path, or returns an empty store if
+// the file does not exist yet
+func Open(path string, password []byte) (*Store, error) {
+	store := &Store{
+		path:       path,
+		password:   password,
+		iterations: spec.PBKDF2_ITERS,
+		entries:    make(map[string]string),
+	}
+
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return store, nil
+		}
+		return nil, fmt.Errorf("failed to read credentials: %w", err)
+	}
+
+	var sealed sealedFile
+	if err := json.Unmarshal(raw, &sealed); err != nil {
+		return nil, fmt.Errorf("failed to parse credentials: %w", err)
+	}
+
+	if sealed.Version != 1 && sealed.Version != FILE_VERSION {
+		return nil, fmt.Errorf("unsupported credentials version: %d", sealed.Version)
+	}
+	if sealed.Iterations < MIN_ITERATIONS || sealed.Iterations > MAX_ITERATIONS {
+		return nil, fmt.Errorf("credentials file has %d PBKDF2 iterations (accepted: %d-%d): corrupted or tampered with", sealed.Iterations, MIN_ITERATIONS, MAX_ITERATIONS)
+	}
+	if len(sealed.Salt) != spec.SALT_SIZE || len(sealed.Nonce) != spec.NONCE_SIZE {
+		return nil, fmt.Errorf("credentials file has a malformed salt or nonce")
+	}
+
+	gcm, err := newGCM(password, sealed.Salt, sealed.Iterations)
+	if err != nil {
+		return nil, err
+	}
+
+	var additional []byte
+	if sealed.Version != 1 {
+		additional = sealed.header()
+	}
+	plaintext, err := gcm.Open(nil, sealed.Nonce, sealed.Ciphertext, additional)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unlock credentials: wrong password or corrupted file")
+	}
+
+	if err := json.Unmarshal(plaintext, &store.entries); err != nil {
+		return nil, fmt.Errorf("failed to decode credentials: %w", err)
+	}
+	store.iterations = sealed.Iterations
+
+	return store, nil
+}
+
+// Unlock opens the credential file, taking the master password from the
+// environment or prompting for it on the terminal - twice when the file
+// doesn't exist yet and the password will create it
+func Unlock(path string) (*Store, error) {
+	if path == "" {
+		var err error
+		path, err = DefaultPath()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if env := os.Getenv(PASSWORD_ENV); env != "" {
+		return Open(path, []byte(env))
+	}
+
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		password, err := newPassword(path)
+		if err != nil {
+			return nil, err
+		}
+		return Open(path, password)
+	}
+
+	password, err := scrypto.GetSecurePassword("\n🔐 Credential store password: ")
+	if err != nil {
+		return nil, err
+	}
+
+	return Open(path, password)
+}
+
+// newPassword prompts twice for the password of a store that doesn't exist
+// yet: a typo would otherwise lock its credentials away for good
+func newPassword(path string) ([]byte, error) {
+	fmt.Printf("\n🆕 No credential store at %s, creating one\n", path)
+	password, err := scrypto.GetSecurePassword("🔐 New credential store password: ")
+	if err != nil {
+		return nil, err
+	}
+	confirm, err := scrypto.GetSecurePassword("🔐 Repeat the password: ")
+	if err != nil {
+		return nil, err
+	}
+	if subtle.ConstantTimeCompare(password, confirm) != 1 {
+		return nil, fmt.Errorf("passwords don't match")
+	}
+	return password, nil
+}
+
+// Get returns a credential by name
+func (s *Store) Get(name string) (string, bool) {
+	value, ok := s.entries[name]
+	return value, ok
+}
+
+// Set adds or replaces a credential (call Save to persist)
+func (s *Store) Set(name, value string) {
+	s.entries[name] = value
+}
+
+// Delete removes a credential (call Save to persist)
+func (s *Store) Delete(name string) bool {
+	if _, ok := s.entries[name]; !ok {
+		return false
+	}
+	delete(s.entries, name)
+	return true
+}
+
+// Names returns all credential names in sorted order
+func (s *Store) Names() []string {
+	names := make([]string, 0, len(s.entries))
+	for name := range s.entries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Save re-encrypts the store with a fresh salt and nonce and writes it to disk
+func (s *Store) Save() error {
+	plaintext, err := json.Marshal(s.entries)
+	if err != nil {
+		return fmt.Errorf("failed to encode credentials: %w", err)
+	}
+
+	salt := make([]byte, spec.SALT_SIZE)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return fmt.Errorf("salt generation failed: %w", err)
+	}
+
+	nonce := make([]byte, spec.NONCE_SIZE)
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return fmt.Errorf("nonce generation failed: %w", err)
+	}
+
+	gcm, err := newGCM(s.password, salt, s.iterations)
+	if err != nil {
+		return err
+	}
+
+	sealed := sealedFile{
+		Version:    FILE_VERSION,
+		Iterations: s.iterations,
+		Salt:       salt,
+		Nonce:      nonce,
+	}
+	sealed.Ciphertext = gcm.Seal(nil, nonce, plaintext, sealed.header())
+
+	data, err := json.MarshalIndent(sealed, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal credentials: %w", err)
+	}
+
+	// Atomic write (write to temp, then rename)
+	tempFile := s.path + ".tmp"
+	if err := os.WriteFile(tempFile, data, 0600); err != nil {
+		return fmt.Errorf("failed to write temp file: %w", err)
+	}
+
+	if err := os.Rename(tempFile, s.path); err != nil {
+		return fmt.Errorf("failed to rename temp file: %w", err)
+	}
+
+	return nil
+}
+
+// header returns the clear-text fields the ciphertext is bound to, as GCM
+// additional data
+func (f *sealedFile) header() []byte {
+	header := make([]byte, 8, 8+len(f.Salt))
+	binary.BigEndian.PutUint32(header[0:4], uint32(f.Version))
+	binary.BigEndian.PutUint32(header[4:8], uint32(f.Iterations))
+	return append(header, f.Salt...)
+}
+
+// newGCM derives the store key and builds the AES-GCM cipher
+func newGCM(password, salt []byte, iterations int) (cipher.AEAD, error) {
+	key := pbkdf2.Key(password, salt, iterations, spec.KEY_SIZE, sha256.New)
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("cipher creation failed: %w", err)
+	}
+
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("GCM creation failed: %w", err)
+	}
+
+	return gcm, nil
+}
@@ -0,0 +1,210 @@
+package decoder
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"sort"
+	"strings"
+)
+
+// ================================================================================
+// BIT-PLANE INSPECTION
+// Renders and measures individual bit planes of a carrier
+// ================================================================================
+
+// LESSON: Seeing the LSB Plane
+// The eye can't see a change of 1 in a colour value, but a bit plane makes
+// it obvious. Rendering bit 0 of every channel as black/white shows:
+// 1. Natural photos: LSBs are noisy but still echo edges and flat areas
+// 2. Encrypted payload: uniform static with no structure at all
+// 3. Sequential embedding: static in the top rows, structure below -
+//    exactly the boundary steganalysis looks for
+// Per-region entropy puts numbers on the same picture: a region whose
+// entropy differs sharply from the rest is worth a closer look.
+
+// PlaneChannel selects which colour channels a bit plane covers
+type PlaneChannel string
+
+const (
+	PLANE_RGB   PlaneChannel = "rgb" // Each channel's bit drives that colour
+	PLANE_RED   PlaneChannel = "r"
+	PLANE_GREEN PlaneChannel = "g"
+	PLANE_BLUE  PlaneChannel = "b"
+
+	// REGION_ENTROPY_DEVIATION flags regions whose byte entropy differs
+	// from the image median by more than this fraction
+	REGION_ENTROPY_DEVIATION = 0.05
+)
+
+// ParsePlaneChannel maps a name (rgb, r, g, b) to a channel selection
+func ParsePlaneChannel(name string) (PlaneChannel, error) {
+	switch channel := PlaneChannel(strings.ToLower(strings.TrimSpace(name))); channel {
+	case PLANE_RGB, PLANE_RED, PLANE_GREEN, PLANE_BLUE:
+		return channel, nil
+	}
+	return "", fmt.Errorf("unknown channel %q (supported: rgb, r, g, b)", name)
+}
+
+// RegionStats measures one region of a bit plane
+type RegionStats struct {
+	Bounds      image.Rectangle
+	Bits        int     // Plane bits in the region
+	Ones        float64 // Fraction of bits set (0.5 for random data)
+	BitEntropy  float64 // Shannon entropy per bit (max 1.0)
+	ByteEntropy float64 // Entropy of the bits packed into bytes (max 8.0)
+	Suspicious  bool    // ByteEntropy differs from the image median
+}
+
+// channelBits returns the selected bits of a pixel, in R, G, B order
+func channelBits(c color.Color, bit uint, channel PlaneChannel) []byte {
+	r, g, b, _ := c.RGBA()
+	values := []uint8{uint8(r >> 8), uint8(g >> 8), uint8(b >> 8)}
+
+	switch channel {
+	case PLANE_RED:
+		values = values[:1]
+	case PLANE_GREEN:
+		values = values[1:2]
+	case PLANE_BLUE:
+		values = values[2:]
+	}
+
+	bits := make([]byte, len(values))
+	for i, v := range values {
+		bits[i] = (v >> bit) & 1
+	}
+	return bits
+}
+
+// RenderBitPlane draws one bit plane: set bits are bright, clear bits dark.
+// With PLANE_RGB each channel's bit drives that channel of the output.
+func RenderBitPlane(img image.Image, bit uint, channel PlaneChannel) *image.RGBA {
+	bounds := img.Bounds()
+	out := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			bits := channelBits(img.At(x, y), bit, channel)
+
+			var px color.RGBA
+			if channel == PLANE_RGB {
+				px = color.RGBA{R: bits[0] * 255, G: bits[1] * 255, B: bits[2] * 255, A: 255}
+			} else {
+				v := bits[0] * 255
+				px = color.RGBA{R: v, G: v, B: v, A: 255}
+			}
+			out.SetRGBA(x-bounds.Min.X, y-bounds.Min.Y, px)
+		}
+	}
+
+	return out
+}
+
+// PlaneEntropy splits the image into a cols x rows grid and measures the
+// bit plane in each region, flagging regions that stand out
+func PlaneEntropy(img image.Image, bit uint, channel PlaneChannel, cols, rows int) []RegionStats {
+	bounds := img.Bounds()
+	if cols < 1 {
+		cols = 1
+	}
+	if rows < 1 {
+		rows = 1
+	}
+	cols = min(cols, bounds.Dx())
+	rows = min(rows, bounds.Dy())
+
+	regions := make([]RegionStats, 0, cols*rows)
+	for row := 0; row < rows; row++ {
+		for col := 0; col < cols; col++ {
+			rect := image.Rect(
+				bounds.Min.X+col*bounds.Dx()/cols,
+				bounds.Min.Y+row*bounds.Dy()/rows,
+				bounds.Min.X+(col+1)*bounds.Dx()/cols,
+				bounds.Min.Y+(row+1)*bounds.Dy()/rows,
+			)
+			regions = append(regions, measureRegion(img, rect, bit, channel))
+		}
+	}
+
+	// Flag regions far from the typical region (equal-sized regions
+	// share the same entropy ceiling, so they compare directly)
+	median := medianByteEntropy(regions)
+	for i := range regions {
+		regions[i].Suspicious = median > 0 &&
+			math.Abs(regions[i].ByteEntropy-median)/median > REGION_ENTROPY_DEVIATION
+	}
+
+	return regions
+}
+
+// measureRegion computes the statistics of one region
+func measureRegion(img image.Image, rect image.Rectangle, bit uint, channel PlaneChannel) RegionStats {
+	stats := RegionStats{Bounds: rect}
+	frequency := make(map[byte]int)
+	ones := 0
+
+	var current byte
+	filled := 0
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		for x := rect.Min.X; x < rect.Max.X; x++ {
+			for _, b := range channelBits(img.At(x, y), bit, channel) {
+				stats.Bits++
+				ones += int(b)
+
+				current = current<<1 | b
+				filled++
+				if filled == 8 {
+					frequency[current]++
+					current, filled = 0, 0
+				}
+			}
+		}
+	}
+
+	if stats.Bits == 0 {
+		return stats
+	}
+
+	stats.Ones = float64(ones) / float64(stats.Bits)
+	stats.BitEntropy = shannon([]float64{stats.Ones, 1 - stats.Ones})
+
+	total := 0
+	for _, count := range frequency {
+		total += count
+	}
+	probabilities := make([]float64, 0, len(frequency))
+	for _, count := range frequency {
+		probabilities = append(probabilities, float64(count)/float64(total))
+	}
+	stats.ByteEntropy = shannon(probabilities)
+
+	return stats
+}
+
+// shannon returns the entropy in bits of a probability distribution
+func shannon(probabilities []float64) float64 {
+	entropy := 0.0
+	for _, p := range probabilities {
+		if p > 0 {
+			entropy -= p * math.Log2(p)
+		}
+	}
+	return entropy
+}
+
+// medianByteEntropy returns the median ByteEntropy of the regions
+func medianByteEntropy(regions []RegionStats) float64 {
+	if len(regions) == 0 {
+		return 0
+	}
+
+	values := make([]float64, len(regions))
+	for i, r := range regions {
+		values[i] = r.ByteEntropy
+	}
+	sort.Float64s(values)
+
+	return values[len(values)/2]
+}
@@ -5,11 +5,11 @@ import (
 	"compress/gzip"
 	"crypto/aes"
 	"crypto/cipher"
-	"crypto/sha256"
 	"encoding/binary"
+	"errors"
 	"fmt"
+	"github.com/faanross/simulacra_txt/internal/kdf"
 	"github.com/faanross/simulacra_txt/internal/spec"
-	"golang.org/x/crypto/pbkdf2"
 	"io"
 	"strings"
 )
@@ -19,11 +19,16 @@ func (ssd *SecureStegoDecoder) DecryptPayload() (*ExtractedMessage, error) {
 	fmt.Printf("\n🔓 Decryption process:\n")
 
 	// Parse secure payload structure
-	if len(ssd.securePayload) < spec.SALT_SIZE+spec.NONCE_SIZE+spec.TAG_SIZE {
+	params, kdfHeader, err := ssd.KDF()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(ssd.securePayload) < len(kdfHeader)+spec.SALT_SIZE+spec.NONCE_SIZE+spec.TAG_SIZE {
 		return nil, fmt.Errorf("payload too small for decryption")
 	}
 
-	offset := 0
+	offset := len(kdfHeader)
 
 	// Extract salt
 	salt := ssd.securePayload[offset : offset+spec.SALT_SIZE]
@@ -45,8 +50,11 @@ func (ssd *SecureStegoDecoder) DecryptPayload() (*ExtractedMessage, error) {
 
 	// Derive key from password
 	fmt.Printf("\n🔑 Key derivation:\n")
-	fmt.Printf("   Using PBKDF2 with %d iterations...\n", spec.PBKDF2_ITERS)
-	key := pbkdf2.Key(ssd.password, salt, spec.PBKDF2_ITERS, spec.KEY_SIZE, sha256.New)
+	if len(kdfHeader) == 0 {
+		fmt.Printf("   No KDF header (legacy payload)\n")
+	}
+	fmt.Printf("   Using %s...\n", params)
+	key := params.Derive(ssd.password, salt)
 
 	fingerprint := fmt.Sprintf("%X", key[:4])
 	fmt.Printf("   Key fingerprint: %s...\n", fingerprint)
@@ -64,7 +72,7 @@ func (ssd *SecureStegoDecoder) DecryptPayload() (*ExtractedMessage, error) {
 
 	// Decrypt and authenticate
 	fmt.Printf("\n🔐 Attempting decryption...\n")
-	plaintext, err := gcm.Open(nil, nonce, ciphertext, nil)
+	plaintext, err := gcm.Open(nil, nonce, ciphertext, kdfHeader)
 	if err != nil {
 		if strings.Contains(err.Error(), "authentication failed") {
 			return nil, fmt.Errorf("❌ AUTHENTICATION FAILED - Wrong password or corrupted data")
@@ -91,22 +99,10 @@ func (ssd *SecureStegoDecoder) DecryptPayload() (*ExtractedMessage, error) {
 	messageData := plaintext[4:]
 
 	// Try to decompress
-	wasCompressed := false
-	finalMessage := messageData
-
-	// Check if data might be compressed (gzip magic: 1f8b)
-	if len(messageData) >= 2 && messageData[0] == 0x1f && messageData[1] == 0x8b {
-		fmt.Printf("\n📦 Detected compression, decompressing...\n")
-		reader, err := gzip.NewReader(bytes.NewReader(messageData))
-		if err == nil {
-			decompressed, err := io.ReadAll(reader)
-			reader.Close()
-			if err == nil {
-				wasCompressed = true
-				finalMessage = decompressed
-				fmt.Printf("   Decompressed: %d → %d bytes\n", len(messageData), len(decompressed))
-			}
-		}
+	finalMessage, wasCompressed := maybeDecompress(messageData)
+	if wasCompressed {
+		fmt.Printf("\n📦 Detected compression, decompressed: %d → %d bytes\n",
+			len(messageData), len(finalMessage))
 	}
 
 	return &ExtractedMessage{
@@ -118,6 +114,105 @@ func (ssd *SecureStegoDecoder) DecryptPayload() (*ExtractedMessage, error) {
 	}, nil
 }
 
+// ErrDecryptionFailed is the single error reported by TryDecrypt.
+// Extraction problems, wrong passwords and corrupted data all look the same.
+var ErrDecryptionFailed = errors.New("decryption failed")
+
+// TryDecrypt attempts decryption with a password, without any logging.
+//
+// LESSON: Uniform Timing
+// A bad payload used to fail instantly while a wrong password cost a full
+// PBKDF2 run, so timing told an observer WHY an attempt failed. Here every
+// attempt derives a key and runs GCM authentication - against a dummy
+// payload if extraction failed - and every failure returns the same error.
+func (ssd *SecureStegoDecoder) TryDecrypt(password []byte) (*ExtractedMessage, error) {
+	minSize := spec.SALT_SIZE + spec.NONCE_SIZE + spec.TAG_SIZE + 4
+
+	// A header over the cost limits is treated like any other bad payload
+	params, kdfHeader, err := ssd.KDF()
+	payload := ssd.securePayload[len(kdfHeader):]
+	valid := err == nil && len(payload) >= minSize
+	if !valid {
+		params = kdf.Default()
+		kdfHeader = nil
+		payload = make([]byte, minSize)
+	}
+
+	salt := payload[:spec.SALT_SIZE]
+	nonce := payload[spec.SALT_SIZE : spec.SALT_SIZE+spec.NONCE_SIZE]
+	ciphertext := payload[spec.SALT_SIZE+spec.NONCE_SIZE:]
+
+	key := params.Derive(password, salt)
+	defer Wipe(key)
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, ErrDecryptionFailed
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, ErrDecryptionFailed
+	}
+
+	plaintext, err := gcm.Open(nil, nonce, ciphertext, kdfHeader)
+	if err != nil || !valid || len(plaintext) < 4 ||
+		binary.BigEndian.Uint32(plaintext[:4]) != spec.MAGIC_HEADER {
+		return nil, ErrDecryptionFailed
+	}
+
+	message, wasCompressed := maybeDecompress(plaintext[4:])
+
+	return &ExtractedMessage{
+		Message:       message,
+		WasCompressed: wasCompressed,
+		EncryptedSize: len(ciphertext),
+		DecryptedSize: len(message),
+		Authenticated: true,
+	}, nil
+}
+
+// KDF returns the key derivation parameters of the extracted payload and
+// the raw header bytes (nil for legacy payloads). Parameters costlier than
+// the decoder's limits are an error.
+func (ssd *SecureStegoDecoder) KDF() (kdf.Params, []byte, error) {
+	params, found := kdf.ParseHeader(ssd.securePayload)
+	if !found {
+		return params, nil, nil
+	}
+
+	if err := params.CheckLimits(ssd.kdfLimits); err != nil {
+		return params, nil, fmt.Errorf("refusing payload KDF %s: %w", params, err)
+	}
+	return params, ssd.securePayload[:kdf.HEADER_SIZE], nil
+}
+
+// maybeDecompress inflates gzip data (magic 1f8b), returning other data unchanged
+func maybeDecompress(data []byte) ([]byte, bool) {
+	if len(data) < 2 || data[0] != 0x1f || data[1] != 0x8b {
+		return data, false
+	}
+
+	reader, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return data, false
+	}
+	defer reader.Close()
+
+	decompressed, err := io.ReadAll(reader)
+	if err != nil {
+		return data, false
+	}
+
+	return decompressed, true
+}
+
+// Wipe overwrites sensitive bytes (keys, passwords) once they are no longer needed
+func Wipe(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
+
 // ExtractedMessage contains decrypted message and metadata
 type ExtractedMessage struct {
 	Message       []byte
@@ -3,6 +3,7 @@ package decoder
 import (
 	"encoding/binary"
 	"fmt"
+	"github.com/faanross/simulacra_txt/internal/kdf"
 	"github.com/faanross/simulacra_txt/internal/spec"
 	"image"
 )
@@ -15,19 +16,26 @@ type SecureStegoDecoder struct {
 	password      []byte
 	bits          []bool
 	securePayload []byte
+	kdfLimits     kdf.Limits // Highest KDF cost accepted from a payload header
 }
 
 // NewSecureStegoDecoder creates a decoder instance
 func NewSecureStegoDecoder(img image.Image, password []byte) *SecureStegoDecoder {
 	bounds := img.Bounds()
 	return &SecureStegoDecoder{
-		img:      img,
-		width:    bounds.Max.X - bounds.Min.X,
-		height:   bounds.Max.Y - bounds.Min.Y,
-		password: password,
+		img:       img,
+		width:     bounds.Max.X - bounds.Min.X,
+		height:    bounds.Max.Y - bounds.Min.Y,
+		password:  password,
+		kdfLimits: kdf.DefaultLimits(),
 	}
 }
 
+// SetKDFLimits changes the highest KDF cost accepted from a payload header
+func (ssd *SecureStegoDecoder) SetKDFLimits(limits kdf.Limits) {
+	ssd.kdfLimits = limits
+}
+
 // ExtractBitStream extracts all LSBs from the image
 func (ssd *SecureStegoDecoder) ExtractBitStream() {
 	maxBits := ssd.width * ssd.height * spec.CHANNELS
@@ -58,6 +66,34 @@ func (ssd *SecureStegoDecoder) ExtractBitStream() {
 	fmt.Printf("   Total bits extracted: %d\n", len(ssd.bits))
 }
 
+// ReadPayloadLength reads the embedded payload length without extracting
+// the whole bit stream. It returns the size of the length header plus payload
+// (random padding after the payload isn't recorded, so it isn't counted).
+func ReadPayloadLength(img image.Image) (int, error) {
+	bounds := img.Bounds()
+	headerBits := spec.HEADER_SIZE * spec.BITS_PER_BYTE
+	if bounds.Dx()*bounds.Dy()*spec.CHANNELS < headerBits {
+		return 0, fmt.Errorf("image too small for a payload header")
+	}
+
+	var length uint32
+	bit := 0
+	for y := bounds.Min.Y; y < bounds.Max.Y && bit < headerBits; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X && bit < headerBits; x++ {
+			r, g, b, _ := img.At(x, y).RGBA()
+			for _, c := range []uint32{r, g, b} {
+				if bit == headerBits {
+					break
+				}
+				length = length<<1 | (c>>8)&1
+				bit++
+			}
+		}
+	}
+
+	return spec.HEADER_SIZE + int(length), nil
+}
+
 // ExtractSecurePayload reconstructs the encrypted payload from bits
 func (ssd *SecureStegoDecoder) ExtractSecurePayload() error {
 	if len(ssd.bits) < spec.HEADER_SIZE*spec.BITS_PER_BYTE {
@@ -0,0 +1,217 @@
+package dnsserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"strings"
+)
+
+// ================================================================================
+// SPLIT-HORIZON VIEWS
+// Decides, per source address, whether a query sees the real zone
+// ================================================================================
+
+// LESSON: Two Zones in One
+// Split-horizon DNS gives different clients different answers for the
+// same name - usually internal and external views of a company zone. Here
+// it hides the channel: allow-listed receivers get the chunks, everyone
+// else (scanners, analysts replaying a query from a log) gets NXDOMAIN or
+// a decoy TXT record that looks like a chunk and decodes to nothing.
+//
+// The source is whoever sends us the query: the receiver itself when it
+// queries us directly, otherwise its recursive resolver - so allow-list
+// the resolvers receivers use, not just the receivers.
+//
+// ACL file (JSON), first matching view wins:
+//
+//   {
+//     "default": "nxdomain",
+//     "views": [
+//       {"name": "receivers", "sources": ["203.0.113.7", "10.0.0.0/8"], "action": "answer"},
+//       {"name": "scanners",  "sources": ["198.51.100.0/24"], "action": "decoy"}
+//     ]
+//   }
+
+// ACL actions
+const (
+	ACL_ANSWER   = "answer"   // Real answers
+	ACL_NXDOMAIN = "nxdomain" // The name doesn't exist
+	ACL_DECOY    = "decoy"    // A TXT record that looks like a chunk
+)
+
+// ACLView applies one action to a set of sources
+type ACLView struct {
+	Name    string   `json:"name"`
+	Sources []string `json:"sources"` // IP addresses or CIDR prefixes
+	Action  string   `json:"action"`
+
+	prefixes []netip.Prefix
+}
+
+// ACL maps query sources to actions
+type ACL struct {
+	Default string    `json:"default"` // Action for sources no view lists
+	Views   []ACLView `json:"views"`
+}
+
+// ParseACL reads an ACL from JSON and validates it
+func ParseACL(data []byte) (*ACL, error) {
+	var acl ACL
+	if err := json.Unmarshal(data, &acl); err != nil {
+		return nil, fmt.Errorf("invalid ACL: %w", err)
+	}
+
+	if acl.Default == "" {
+		acl.Default = ACL_NXDOMAIN
+	}
+	if err := checkACLAction(acl.Default); err != nil {
+		return nil, fmt.Errorf("ACL default: %w", err)
+	}
+
+	for i := range acl.Views {
+		view := &acl.Views[i]
+		if view.Name == "" {
+			view.Name = fmt.Sprintf("view-%d", i+1)
+		}
+		if err := checkACLAction(view.Action); err != nil {
+			return nil, fmt.Errorf("ACL view %s: %w", view.Name, err)
+		}
+		if len(view.Sources) == 0 {
+			return nil, fmt.Errorf("ACL view %s lists no sources", view.Name)
+		}
+		for _, source := range view.Sources {
+			prefix, err := parseSource(source)
+			if err != nil {
+				return nil, fmt.Errorf("ACL view %s: %w", view.Name, err)
+			}
+			view.prefixes = append(view.prefixes, prefix)
+		}
+	}
+
+	return &acl, nil
+}
+
+// LoadACL reads an ACL file
+func LoadACL(path string) (*ACL, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read ACL: %w", err)
+	}
+	return ParseACL(data)
+}
+
+// checkACLAction validates an action name
+func checkACLAction(action string) error {
+	switch action {
+	case ACL_ANSWER, ACL_NXDOMAIN, ACL_DECOY:
+		return nil
+	}
+	return fmt.Errorf("unknown action %q (use %s, %s or %s)", action, ACL_ANSWER, ACL_NXDOMAIN, ACL_DECOY)
+}
+
+// parseSource reads an address or prefix; a bare address matches itself
+func parseSource(source string) (netip.Prefix, error) {
+	source = strings.TrimSpace(source)
+	if strings.Contains(source, "/") {
+		prefix, err := netip.ParsePrefix(source)
+		if err != nil {
+			return netip.Prefix{}, fmt.Errorf("invalid source %q: %w", source, err)
+		}
+		return prefix.Masked(), nil
+	}
+
+	addr, err := netip.ParseAddr(source)
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("invalid source %q: %w", source, err)
+	}
+	return netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()), nil
+}
+
+// Match returns the action for a query source and the view that chose it
+// ("" for the default)
+func (a *ACL) Match(source net.Addr) (action, view string) {
+	addr, ok := sourceAddr(source)
+	if !ok {
+		return a.Default, ""
+	}
+
+	for _, v := range a.Views {
+		for _, prefix := range v.prefixes {
+			if prefix.Contains(addr) {
+				return v.Action, v.Name
+			}
+		}
+	}
+	return a.Default, ""
+}
+
+// sourceAddr extracts the IP of a UDP or TCP peer
+func sourceAddr(source net.Addr) (netip.Addr, bool) {
+	var ip net.IP
+	switch peer := source.(type) {
+	case *net.UDPAddr:
+		ip = peer.IP
+	case *net.TCPAddr:
+		ip = peer.IP
+	default:
+		return netip.Addr{}, false
+	}
+
+	addr, ok := netip.AddrFromSlice(ip)
+	return addr.Unmap(), ok
+}
+
+// String summarizes the ACL for display
+func (a *ACL) String() string {
+	sources := 0
+	for _, v := range a.Views {
+		sources += len(v.prefixes)
+	}
+	return fmt.Sprintf("%d views, %d sources, default %s", len(a.Views), sources, a.Default)
+}
+
+// SourceList is a set of source addresses and prefixes, e.g. the servers
+// allowed to transfer the zone
+type SourceList []netip.Prefix
+
+// ParseSourceList reads comma-separated addresses and CIDR prefixes
+func ParseSourceList(spec string) (SourceList, error) {
+	var list SourceList
+	for _, source := range strings.Split(spec, ",") {
+		if strings.TrimSpace(source) == "" {
+			continue
+		}
+		prefix, err := parseSource(source)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, prefix)
+	}
+	return list, nil
+}
+
+// Contains reports whether a UDP or TCP peer is in the list
+func (l SourceList) Contains(source net.Addr) bool {
+	addr, ok := sourceAddr(source)
+	if !ok {
+		return false
+	}
+	for _, prefix := range l {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// String lists the sources for display
+func (l SourceList) String() string {
+	sources := make([]string, len(l))
+	for i, prefix := range l {
+		sources[i] = prefix.String()
+	}
+	return strings.Join(sources, ",")
+}
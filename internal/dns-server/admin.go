@@ -0,0 +1,164 @@
+package dnsserver
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ================================================================================
+// MESSAGE ADMINISTRATION
+// Listing, inspecting and moving messages by hand
+// ================================================================================
+
+// LESSON: Operators Need More Than a Printout
+// The GC and burn-after-reading decide when messages go, and receivers
+// decide when they are delivered and consumed. Sometimes an operator has
+// to step in: a receiver lost a message and needs it again, an upload went
+// to the wrong zone, a message must stop being served right now. The admin
+// API lets them:
+//
+//   list     - summaries, filtered by state and tags
+//   inspect  - chunk counts, coverage and every consumer
+//   delete   - gone at once, like a burn
+//   expire   - no longer served or discovered; the GC removes it (first when
+//              over capacity, or after the "expired=" age)
+//   requeue  - back to new, deliveries forgotten (in every consumer group
+//              too), so receivers discover and fetch it as if it had just
+//              been uploaded
+//   export   - the message's records as a zone file, for -zone elsewhere
+
+// MessageSummary describes a message without its chunks
+type MessageSummary struct {
+	ID          string    `json:"id"`
+	State       string    `json:"state"`
+	CreatedAt   time.Time `json:"created_at"`
+	StateSince  time.Time `json:"state_since"`
+	TotalChunks int       `json:"total_chunks"`
+	Served      int       `json:"served"` // Distinct chunks served to anyone
+	Bytes       int64     `json:"bytes"`
+	Consumers   int       `json:"consumers"` // Distinct clients that fetched it
+	Tags        []string  `json:"tags,omitempty"`
+	Recipient   string    `json:"recipient,omitempty"`
+	Burn        bool      `json:"burn,omitempty"`
+	Priority    string    `json:"priority"`
+
+	Groups map[string]string `json:"groups,omitempty"` // Consumer group -> its state (see groups.go)
+}
+
+// Summarize describes a message
+func Summarize(msg *Message) MessageSummary {
+	coverage := Coverage(msg)
+	return MessageSummary{
+		ID:          msg.ID,
+		State:       msg.State.String(),
+		CreatedAt:   msg.CreatedAt,
+		StateSince:  msg.StateSince(),
+		TotalChunks: coverage.TotalChunks,
+		Served:      coverage.Served,
+		Bytes:       msg.Size(),
+		Consumers:   len(coverage.Clients),
+		Tags:        msg.Tags,
+		Recipient:   msg.Recipient,
+		Burn:        msg.Burn,
+		Priority:    PriorityName(msg.Priority),
+		Groups:      groupStates(msg),
+	}
+}
+
+// groupStates names the state of msg in each consumer group
+func groupStates(msg *Message) map[string]string {
+	if len(msg.Groups) == 0 {
+		return nil
+	}
+	states := make(map[string]string, len(msg.Groups))
+	for group, cursor := range msg.Groups {
+		states[group] = cursor.State.String()
+	}
+	return states
+}
+
+// ParseStates parses a comma-separated state filter, e.g. "new,delivered"
+// (empty = every state)
+func ParseStates(spec string) ([]MessageState, error) {
+	var states []MessageState
+	for _, name := range strings.Split(spec, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		state := parseStateName(name)
+		if state.String() != name {
+			return nil, fmt.Errorf("unknown state: %s (new, delivered, consumed, expired)", name)
+		}
+		states = append(states, state)
+	}
+	return states, nil
+}
+
+// ListSummaries summarizes the stored messages in one of states (any, if
+// none given) carrying every tag, oldest first
+func (qm *QueueManager) ListSummaries(states []MessageState, tags []string) ([]MessageSummary, error) {
+	messages, err := qm.storage.ListMessages()
+	if err != nil {
+		return nil, err
+	}
+
+	summaries := make([]MessageSummary, 0, len(messages))
+	for _, msg := range FilterByTags(messages, tags) {
+		if len(states) > 0 && !hasState(states, msg.State) {
+			continue
+		}
+		summaries = append(summaries, Summarize(msg))
+	}
+	sort.Slice(summaries, func(a, b int) bool {
+		if !summaries[a].CreatedAt.Equal(summaries[b].CreatedAt) {
+			return summaries[a].CreatedAt.Before(summaries[b].CreatedAt)
+		}
+		return summaries[a].ID < summaries[b].ID
+	})
+	return summaries, nil
+}
+
+// hasState reports whether state is one of states
+func hasState(states []MessageState, state MessageState) bool {
+	for _, s := range states {
+		if s == state {
+			return true
+		}
+	}
+	return false
+}
+
+// Requeue moves a message back to new and forgets its deliveries, in
+// every consumer group as well
+func (qm *QueueManager) Requeue(msgID string) error {
+	msg, err := qm.storage.GetMessage(msgID)
+	if err != nil {
+		return err
+	}
+	if err := qm.storage.SetState(msgID, StateNew); err != nil {
+		return err
+	}
+	for _, group := range msg.GroupNames() {
+		if err := qm.storage.SetGroupState(msgID, group, "", StateNew); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Expire stops a message from being served and discovered, leaving its
+// removal to the GC
+func (qm *QueueManager) Expire(msgID string) error {
+	return qm.storage.SetState(msgID, StateExpired)
+}
+
+// Delete removes a message now
+func (qm *QueueManager) Delete(msgID string) error {
+	if qm.storage.DeleteMessages(msgID) == 0 {
+		return fmt.Errorf("message %s not found", msgID)
+	}
+	return nil
+}
@@ -0,0 +1,338 @@
+package dnsserver
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ================================================================================
+// HTTP API KEYS
+// Who may upload, discover and administer through the HTTP API
+// ================================================================================
+
+// LESSON: Keys, Scopes and Signatures
+// Without keys, anyone who can reach port 8080 can upload messages into
+// the zone or drain the discovery queue. A key file lists the keys the API
+// accepts and what each may do:
+//
+//   {
+//     "keys": [
+//       {"id": "sender-1", "secret": "...", "scopes": ["upload"]},
+//       {"id": "host-c",   "secret": "...", "scopes": ["consume"], "signed_only": true},
+//       {"id": "ops",      "secret": "...", "scopes": ["*"]}
+//     ]
+//   }
+//
+// A request proves it holds a key one of two ways:
+//
+//   Bearer  Authorization: Bearer <secret> - simple, but the secret crosses
+//           the wire with every request
+//   HMAC    Authorization: SIMULACRA-HMAC-SHA256 key=<id>,ts=<unix>,sig=<hex>
+//           sig = HMAC-SHA256(secret, METHOD \n URI \n ts \n hex(SHA-256(body)))
+//
+// A signature covers the method, path, query and body, so it can't be
+// moved to another request; it is only accepted within MAX_SIGNATURE_AGE
+// of its timestamp, and only once. Keys marked signed_only refuse bearer
+// use. No key gets 401; a key without the endpoint's scope gets 403.
+
+// API scopes
+const (
+	SCOPE_UPLOAD  = "upload"  // POST /upload
+	SCOPE_CONSUME = "consume" // /messages and /consume
+	SCOPE_ADMIN   = "admin"   // /status, /progress, /flush, /signals, /quota, /admin/...
+	SCOPE_METRICS = "metrics" // GET /metrics (for a Prometheus scraper)
+	SCOPE_ALL     = "*"
+
+	// HMAC_AUTH_SCHEME names signed requests in the Authorization header
+	HMAC_AUTH_SCHEME = "SIMULACRA-HMAC-SHA256"
+
+	// MAX_SIGNATURE_AGE is how far a signature's timestamp may be from now
+	MAX_SIGNATURE_AGE = 5 * time.Minute
+
+	// MIN_SECRET_LENGTH keeps guessable secrets out of key files
+	MIN_SECRET_LENGTH = 16
+)
+
+// APIKey is one key of a key file
+type APIKey struct {
+	ID         string   `json:"id"`
+	Secret     string   `json:"secret"`
+	Scopes     []string `json:"scopes"`
+	SignedOnly bool     `json:"signed_only,omitempty"` // Refuse bearer use
+}
+
+// Allows reports whether the key carries a scope
+func (k *APIKey) Allows(scope string) bool {
+	for _, s := range k.Scopes {
+		if s == scope || s == SCOPE_ALL {
+			return true
+		}
+	}
+	return false
+}
+
+// KeyRing holds the keys the HTTP API accepts
+type KeyRing struct {
+	Keys []APIKey `json:"keys"`
+
+	byID map[string]*APIKey
+	seen map[string]time.Time // Signatures accepted -> when they stop being fresh
+	mu   sync.Mutex
+}
+
+// AuthError is a failed authentication (401) or authorization (403)
+type AuthError struct {
+	Status  int    `json:"-"`
+	Code    string `json:"error"` // "unauthorized", "forbidden" or "too_large"
+	Message string `json:"message"`
+	Scope   string `json:"scope,omitempty"` // Scope the endpoint needs
+	KeyID   string `json:"key_id,omitempty"`
+}
+
+func (e *AuthError) Error() string {
+	return e.Code + ": " + e.Message
+}
+
+// unauthorized builds a 401
+func unauthorized(scope, format string, args ...interface{}) *AuthError {
+	return &AuthError{Status: http.StatusUnauthorized, Code: "unauthorized", Message: fmt.Sprintf(format, args...), Scope: scope}
+}
+
+// ParseKeyRing reads a key file and validates it
+func ParseKeyRing(data []byte) (*KeyRing, error) {
+	var ring KeyRing
+	if err := json.Unmarshal(data, &ring); err != nil {
+		return nil, fmt.Errorf("invalid key file: %w", err)
+	}
+	if len(ring.Keys) == 0 {
+		return nil, fmt.Errorf("key file lists no keys")
+	}
+
+	ring.byID = make(map[string]*APIKey)
+	ring.seen = make(map[string]time.Time)
+	secrets := make(map[string]string)
+	for i := range ring.Keys {
+		key := &ring.Keys[i]
+		if key.ID == "" || strings.ContainsAny(key.ID, ", =") {
+			return nil, fmt.Errorf("key %d: id must be set, without spaces, ',' or '='", i+1)
+		}
+		if _, exists := ring.byID[key.ID]; exists {
+			return nil, fmt.Errorf("key %s listed twice", key.ID)
+		}
+		if len(key.Secret) < MIN_SECRET_LENGTH {
+			return nil, fmt.Errorf("key %s: secret shorter than %d characters", key.ID, MIN_SECRET_LENGTH)
+		}
+		if other, exists := secrets[key.Secret]; exists {
+			return nil, fmt.Errorf("keys %s and %s share a secret", other, key.ID)
+		}
+		if len(key.Scopes) == 0 {
+			return nil, fmt.Errorf("key %s has no scopes", key.ID)
+		}
+		for _, scope := range key.Scopes {
+			switch scope {
+			case SCOPE_UPLOAD, SCOPE_CONSUME, SCOPE_ADMIN, SCOPE_METRICS, SCOPE_ALL:
+			default:
+				return nil, fmt.Errorf("key %s: unknown scope %q (use %s, %s, %s, %s or %s)", key.ID, scope, SCOPE_UPLOAD, SCOPE_CONSUME, SCOPE_ADMIN, SCOPE_METRICS, SCOPE_ALL)
+			}
+		}
+		ring.byID[key.ID] = key
+		secrets[key.Secret] = key.ID
+	}
+
+	return &ring, nil
+}
+
+// LoadKeyRing reads a key file, warning when others can read it
+func LoadKeyRing(path string) (*KeyRing, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key file: %w", err)
+	}
+	if info, err := os.Stat(path); err == nil && info.Mode().Perm()&0077 != 0 {
+		apiLog.Warn("⚠️  Key file is readable by others; chmod 600 it", "path", path, "mode", info.Mode().Perm().String())
+	}
+	return ParseKeyRing(data)
+}
+
+// Authenticate finds the key a request carries and checks it may use
+// scope. Signed requests have their body read and put back.
+func (k *KeyRing) Authenticate(r *http.Request, scope string) (*APIKey, *AuthError) {
+	header := r.Header.Get("Authorization")
+	scheme, credentials, _ := strings.Cut(header, " ")
+
+	var key *APIKey
+	var authErr *AuthError
+	switch {
+	case header == "":
+		return nil, unauthorized(scope, "no API key given")
+	case strings.EqualFold(scheme, "Bearer"):
+		key, authErr = k.bearer(strings.TrimSpace(credentials), scope)
+	case scheme == HMAC_AUTH_SCHEME:
+		key, authErr = k.signed(r, credentials, scope)
+	default:
+		return nil, unauthorized(scope, "unsupported Authorization scheme %q (use Bearer or %s)", scheme, HMAC_AUTH_SCHEME)
+	}
+	if authErr != nil {
+		return nil, authErr
+	}
+
+	if !key.Allows(scope) {
+		return key, &AuthError{
+			Status:  http.StatusForbidden,
+			Code:    "forbidden",
+			Message: fmt.Sprintf("key %s lacks the %s scope", key.ID, scope),
+			Scope:   scope,
+			KeyID:   key.ID,
+		}
+	}
+	return key, nil
+}
+
+// bearer finds the key with a secret, comparing every key in constant time
+func (k *KeyRing) bearer(secret, scope string) (*APIKey, *AuthError) {
+	var found *APIKey
+	for i := range k.Keys {
+		if hmac.Equal([]byte(k.Keys[i].Secret), []byte(secret)) {
+			found = &k.Keys[i]
+		}
+	}
+	if found == nil {
+		return nil, unauthorized(scope, "unknown API key")
+	}
+	if found.SignedOnly {
+		return nil, unauthorized(scope, "key %s only accepts signed requests (%s)", found.ID, HMAC_AUTH_SCHEME)
+	}
+	return found, nil
+}
+
+// signed verifies an HMAC-signed request
+func (k *KeyRing) signed(r *http.Request, credentials, scope string) (*APIKey, *AuthError) {
+	fields := make(map[string]string)
+	for _, field := range strings.Split(credentials, ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(field), "=")
+		fields[name] = value
+	}
+
+	key, exists := k.byID[fields["key"]]
+	if !exists {
+		return nil, unauthorized(scope, "unknown API key %q", fields["key"])
+	}
+	ts, err := strconv.ParseInt(fields["ts"], 10, 64)
+	if err != nil {
+		return nil, unauthorized(scope, "signature has no valid ts")
+	}
+	at := time.Unix(ts, 0)
+	if age := time.Since(at); age > MAX_SIGNATURE_AGE || age < -MAX_SIGNATURE_AGE {
+		return nil, unauthorized(scope, "signature timestamp is %s off (allowed: %s)", age.Round(time.Second), MAX_SIGNATURE_AGE)
+	}
+	sig, err := hex.DecodeString(fields["sig"])
+	if err != nil || len(sig) != sha256.Size {
+		return nil, unauthorized(scope, "signature is not a hex HMAC-SHA256")
+	}
+
+	// Only now is the body read - key, timestamp and signature format are
+	// checked - and never more of it than any request may have
+	body, err := io.ReadAll(http.MaxBytesReader(nil, r.Body, MAX_REQUEST_BODY))
+	if BodyTooLarge(err) {
+		return nil, &AuthError{
+			Status:  http.StatusRequestEntityTooLarge,
+			Code:    "too_large",
+			Message: fmt.Sprintf("request body exceeds %d bytes", MAX_REQUEST_BODY),
+			Scope:   scope,
+			KeyID:   key.ID,
+		}
+	}
+	if err != nil {
+		return nil, unauthorized(scope, "failed to read body: %v", err)
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
+	if !hmac.Equal(sig, requestSignature(key.Secret, r.Method, r.URL.RequestURI(), ts, body)) {
+		return nil, unauthorized(scope, "bad signature for key %s", key.ID)
+	}
+
+	// A captured request can't be sent again while its timestamp is fresh
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	now := time.Now()
+	for seen, expires := range k.seen {
+		if now.After(expires) {
+			delete(k.seen, seen)
+		}
+	}
+	if _, replayed := k.seen[fields["sig"]]; replayed {
+		return nil, unauthorized(scope, "signature already used")
+	}
+	k.seen[fields["sig"]] = at.Add(MAX_SIGNATURE_AGE)
+
+	return key, nil
+}
+
+// Inherit takes over the signatures a previous key ring accepted, so
+// reloading the key file doesn't let them be replayed
+func (k *KeyRing) Inherit(previous *KeyRing) {
+	if previous == nil {
+		return
+	}
+	previous.mu.Lock()
+	defer previous.mu.Unlock()
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	for sig, expires := range previous.seen {
+		k.seen[sig] = expires
+	}
+}
+
+// SignRequest adds an HMAC signature over the request and its body to req
+func SignRequest(req *http.Request, keyID, secret string, body []byte) {
+	ts := time.Now().Unix()
+	sig := requestSignature(secret, req.Method, req.URL.RequestURI(), ts, body)
+	req.Header.Set("Authorization", fmt.Sprintf("%s key=%s,ts=%d,sig=%s", HMAC_AUTH_SCHEME, keyID, ts, hex.EncodeToString(sig)))
+}
+
+// requestSignature computes the HMAC of a request's canonical form
+func requestSignature(secret, method, uri string, ts int64, body []byte) []byte {
+	digest := sha256.Sum256(body)
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%s\n%s\n%d\n%s", method, uri, ts, hex.EncodeToString(digest[:]))
+	return mac.Sum(nil)
+}
+
+// WriteAuthError sends an authentication failure as JSON
+func WriteAuthError(w http.ResponseWriter, err *AuthError) {
+	if err.Status == http.StatusUnauthorized {
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf("Bearer, %s", HMAC_AUTH_SCHEME))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(err.Status)
+	json.NewEncoder(w).Encode(err)
+}
+
+// String summarizes the key ring for display
+func (k *KeyRing) String() string {
+	counts := make(map[string]int)
+	for _, key := range k.Keys {
+		for _, scope := range key.Scopes {
+			counts[scope]++
+		}
+	}
+	var parts []string
+	for _, scope := range []string{SCOPE_UPLOAD, SCOPE_CONSUME, SCOPE_ADMIN, SCOPE_METRICS, SCOPE_ALL} {
+		if counts[scope] > 0 {
+			parts = append(parts, fmt.Sprintf("%s=%d", scope, counts[scope]))
+		}
+	}
+	return fmt.Sprintf("%d keys (%s)", len(k.Keys), strings.Join(parts, ", "))
+}
@@ -0,0 +1,234 @@
+package dnsserver
+
+import (
+	"fmt"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ================================================================================
+// ZONE AUTHORITY
+// The SOA and NS data that make the server look like a real authoritative one
+// ================================================================================
+
+// LESSON: What Resolvers Expect
+// A recursive resolver delegated to us checks more than the TXT answers:
+//
+//   SOA at the apex    - the zone's serial and timers; NXDOMAIN and NODATA
+//                        answers carry it in the authority section, and its
+//                        minimum field is how long resolvers cache the "no"
+//   NS at the apex     - who serves the zone; should match the delegation
+//   A for the NS names - so the servers can be found (glue)
+//   REFUSED elsewhere  - an authoritative-only server doesn't recurse, and
+//                        says so instead of answering for zones it isn't
+//                        authoritative for
+//
+// A server that answers TXT and shrugs at everything else works, but looks
+// like nothing a registrar ever delegated to.
+//
+// Name server spec: comma-separated name[=address], relative names are in
+// the zone: "ns1=192.0.2.53,ns2=2001:db8::53,ns.other-provider.net"
+//
+// SOA spec: serial=2024060100,refresh=2h,retry=1h,expire=14d,minimum=5m,
+// ttl=1h,mbox=hostmaster (bare numbers are seconds; mbox relative to the zone)
+
+// SOA defaults (RFC 1912 recommendations, short negative caching)
+const (
+	DEFAULT_SOA_REFRESH  = 2 * time.Hour
+	DEFAULT_SOA_RETRY    = 1 * time.Hour
+	DEFAULT_SOA_EXPIRE   = 14 * 24 * time.Hour
+	DEFAULT_NEGATIVE_TTL = 5 * time.Minute
+	DEFAULT_SOA_TTL      = 1 * time.Hour
+	DEFAULT_SOA_MBOX     = "hostmaster"
+	DEFAULT_NAME_SERVER  = "ns1"
+)
+
+// NameServer is one server named in the zone's NS records
+type NameServer struct {
+	Name  string       // Fully qualified, without the trailing dot
+	Addrs []netip.Addr // Glue, for names inside the zone
+}
+
+// Authority is the zone's SOA and NS data
+type Authority struct {
+	Zone        string
+	NameServers []NameServer
+	Mbox        string // Responsible mailbox as a name (hostmaster.<zone>)
+	Serial      uint32
+	Refresh     time.Duration
+	Retry       time.Duration
+	Expire      time.Duration
+	Minimum     time.Duration // Negative caching TTL
+	TTL         time.Duration // TTL of the SOA and NS records
+}
+
+// ParseAuthority builds a zone's authority from a name server spec and an
+// SOA spec; empty specs get ns1.<zone> and the defaults, with a serial
+// from today's date
+func ParseAuthority(zone, nsSpec, soaSpec string) (*Authority, error) {
+	zone = strings.ToLower(strings.TrimSuffix(zone, "."))
+	a := &Authority{
+		Zone:    zone,
+		Mbox:    DEFAULT_SOA_MBOX + "." + zone,
+		Serial:  dateSerial(time.Now()),
+		Refresh: DEFAULT_SOA_REFRESH,
+		Retry:   DEFAULT_SOA_RETRY,
+		Expire:  DEFAULT_SOA_EXPIRE,
+		Minimum: DEFAULT_NEGATIVE_TTL,
+		TTL:     DEFAULT_SOA_TTL,
+	}
+
+	if strings.TrimSpace(nsSpec) == "" {
+		nsSpec = DEFAULT_NAME_SERVER
+	}
+	for _, field := range strings.Split(nsSpec, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		name, address, hasAddr := strings.Cut(field, "=")
+		server := NameServer{Name: a.qualify(name)}
+		if hasAddr {
+			addr, err := netip.ParseAddr(strings.TrimSpace(address))
+			if err != nil {
+				return nil, fmt.Errorf("invalid address of name server %s: %w", server.Name, err)
+			}
+			if !a.Contains(server.Name) {
+				return nil, fmt.Errorf("name server %s is outside %s, so it can't have glue", server.Name, zone)
+			}
+			server.Addrs = append(server.Addrs, addr.Unmap())
+		}
+		a.addNameServer(server)
+	}
+
+	for _, field := range strings.Split(soaSpec, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		key, val, ok := strings.Cut(field, "=")
+		if !ok {
+			return nil, fmt.Errorf("malformed SOA field: %q", field)
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		val = strings.TrimSpace(val)
+
+		var err error
+		switch key {
+		case "serial":
+			var serial uint64
+			serial, err = strconv.ParseUint(val, 10, 32)
+			a.Serial = uint32(serial)
+		case "refresh":
+			a.Refresh, err = parseSeconds(val)
+		case "retry":
+			a.Retry, err = parseSeconds(val)
+		case "expire":
+			a.Expire, err = parseSeconds(val)
+		case "minimum":
+			a.Minimum, err = parseSeconds(val)
+		case "ttl":
+			a.TTL, err = parseSeconds(val)
+		case "mbox":
+			a.Mbox = a.qualify(strings.ReplaceAll(val, "@", "."))
+		default:
+			return nil, fmt.Errorf("unknown SOA field: %s", key)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid SOA %s: %w", key, err)
+		}
+	}
+
+	return a, nil
+}
+
+// addNameServer adds a server, merging addresses given for the same name
+func (a *Authority) addNameServer(server NameServer) {
+	for i := range a.NameServers {
+		if a.NameServers[i].Name == server.Name {
+			a.NameServers[i].Addrs = append(a.NameServers[i].Addrs, server.Addrs...)
+			return
+		}
+	}
+	a.NameServers = append(a.NameServers, server)
+}
+
+// qualify makes a name relative to the zone fully qualified; names with a
+// dot (or a trailing one) are taken as they are
+func (a *Authority) qualify(name string) string {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if strings.HasSuffix(name, ".") {
+		return strings.TrimSuffix(name, ".")
+	}
+	if !strings.Contains(name, ".") {
+		return name + "." + a.Zone
+	}
+	return name
+}
+
+// Contains reports whether a name is the zone's apex or below it
+func (a *Authority) Contains(name string) bool {
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	return name == a.Zone || strings.HasSuffix(name, "."+a.Zone)
+}
+
+// IsApex reports whether a name is the zone's apex
+func (a *Authority) IsApex(name string) bool {
+	return strings.ToLower(strings.TrimSuffix(name, ".")) == a.Zone
+}
+
+// Primary returns the name server named in the SOA record
+func (a *Authority) Primary() string {
+	return a.NameServers[0].Name
+}
+
+// Glue returns the addresses of a name server in the zone (nil if the name
+// isn't one)
+func (a *Authority) Glue(name string) []netip.Addr {
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	for _, server := range a.NameServers {
+		if server.Name == name {
+			return server.Addrs
+		}
+	}
+	return nil
+}
+
+// NegativeTTL returns how long a negative answer may be cached: the
+// smaller of the SOA's TTL and its minimum (RFC 2308)
+func (a *Authority) NegativeTTL() uint32 {
+	return uint32(min(a.TTL, a.Minimum) / time.Second)
+}
+
+// String describes the authority for display
+func (a *Authority) String() string {
+	names := make([]string, 0, len(a.NameServers))
+	for _, server := range a.NameServers {
+		name := server.Name
+		if len(server.Addrs) > 0 {
+			addrs := make([]string, 0, len(server.Addrs))
+			for _, addr := range server.Addrs {
+				addrs = append(addrs, addr.String())
+			}
+			name += " (" + strings.Join(addrs, ", ") + ")"
+		}
+		names = append(names, name)
+	}
+	return fmt.Sprintf("NS %s; serial %d, negative TTL %v", strings.Join(names, ", "), a.Serial, a.Minimum)
+}
+
+// dateSerial returns the conventional YYYYMMDDnn serial of a day
+func dateSerial(t time.Time) uint32 {
+	year, month, day := t.UTC().Date()
+	return uint32(year*1000000 + int(month)*10000 + day*100)
+}
+
+// parseSeconds reads a time as seconds or a duration ("300", "5m", "14d")
+func parseSeconds(value string) (time.Duration, error) {
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(seconds) * time.Second, nil
+	}
+	return parseGCDuration(value)
+}
@@ -0,0 +1,71 @@
+package dnsserver
+
+import (
+	"sync"
+	"time"
+)
+
+// ================================================================================
+// BURN AFTER READING
+// Deletes a message as soon as its receiver has it
+// ================================================================================
+
+// LESSON: The Shortest Exposure Window
+// A message left on the server after delivery is evidence: anyone who
+// seizes the server, or simply queries the same names, gets it too. The GC
+// removes messages eventually; a message uploaded with "burn" goes the
+// moment the last of its chunks has been served, or when the receiver
+// acknowledges it, whichever comes first. Storage records which chunks of
+// every message were served (see progress.go), so the rule holds for every
+// path that serves chunks.
+//
+// Burning has limits. A lost answer can't be fetched again, so burn suits
+// receivers on reliable paths. And resolvers keep cached answers until
+// their TTL runs out - burning removes the source, not the copies.
+
+// BURN_MEMORY is how long a burned message's ID is remembered, so a late
+// acknowledgement still succeeds
+const BURN_MEMORY = time.Hour
+
+// burnTracker remembers the messages burned recently
+type burnTracker struct {
+	burned map[string]time.Time // msgID -> when it was burned
+	mu     sync.Mutex
+}
+
+// newBurnTracker creates an empty tracker
+func newBurnTracker() *burnTracker {
+	return &burnTracker{
+		burned: make(map[string]time.Time),
+	}
+}
+
+// Burned reports whether a message was burned recently
+func (qm *QueueManager) Burned(id string) bool {
+	qm.burns.mu.Lock()
+	defer qm.burns.mu.Unlock()
+
+	_, burned := qm.burns.burned[id]
+	return burned
+}
+
+// burn deletes a message and remembers that it did, reporting whether the
+// message was still there
+func (qm *QueueManager) burn(id string) bool {
+	removed := qm.storage.DeleteMessages(id) > 0
+
+	qm.burns.mu.Lock()
+	defer qm.burns.mu.Unlock()
+
+	now := time.Now()
+	if removed {
+		qm.burns.burned[id] = now
+	}
+	for old, at := range qm.burns.burned {
+		if now.Sub(at) > BURN_MEMORY {
+			delete(qm.burns.burned, old)
+		}
+	}
+
+	return removed
+}
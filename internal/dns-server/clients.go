@@ -0,0 +1,124 @@
+package dnsserver
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ================================================================================
+// CLIENT IDENTIFICATION
+// Decides who a discovery or chunk query comes from, for delivery tracking
+// ================================================================================
+
+// LESSON: Who Is Asking?
+// Delivery tracking only works if the server can tell receivers apart: a
+// message delivered to one must still be new to the next. A DNS query
+// carries several hints, each surviving a different path:
+//
+//   name    consume.<client>.<domain> - the receiver's chosen ID, survives
+//           any resolver but is visible in every query log on the way
+//   edns    an EDNS0 option (CLIENT_ID_OPTION) holding the same ID - kept
+//           out of the name, but resolvers drop unknown options, so only
+//           direct queries carry it
+//   subnet  EDNS0 Client Subnet - the receiver's network as a resolver
+//           reports it
+//   cookie  the EDNS0 client cookie - stable for one receiver process
+//   ip      the source address - the receiver, or its resolver
+//
+// The server tries the sources in a configured order and takes the first
+// that yields an ID. Name and edns IDs are whatever the receiver claims;
+// a server that doesn't trust claims can identify by network only.
+
+// Client identification sources
+const (
+	CLIENT_VIA_NAME   = "name"
+	CLIENT_VIA_EDNS   = "edns"
+	CLIENT_VIA_SUBNET = "subnet"
+	CLIENT_VIA_COOKIE = "cookie"
+	CLIENT_VIA_IP     = "ip"
+	CLIENT_VIA_HTTP   = "http" // ?client= on the HTTP API
+
+	// CLIENT_ID_OPTION is the EDNS0 option code carrying a client ID, from
+	// the local/experimental range (RFC 6891)
+	CLIENT_ID_OPTION = 65001
+
+	// DEFAULT_CLIENT_SOURCES is the default identification order
+	DEFAULT_CLIENT_SOURCES = "name,edns,subnet,cookie,ip"
+)
+
+// ClientInfo identifies a client fetching messages
+type ClientInfo struct {
+	ID   string // Keys delivery tracking: what the client has seen
+	Addr string // Source address of the request, when known
+	Via  string // How ID was established (CLIENT_VIA_*)
+}
+
+// String renders the client for logs
+func (c ClientInfo) String() string {
+	if c.Via == "" {
+		return c.ID
+	}
+	return fmt.Sprintf("%s (%s)", c.ID, c.Via)
+}
+
+// record returns the consumer record of a fetch by the client
+func (c ClientInfo) record(at time.Time) ConsumerRecord {
+	return ConsumerRecord{
+		ClientIP:  c.Addr,
+		ClientID:  c.ID,
+		Via:       c.Via,
+		FetchedAt: at,
+	}
+}
+
+// ParseClientSources parses an identification order, e.g. "edns,ip"
+func ParseClientSources(spec string) ([]string, error) {
+	var sources []string
+	seen := make(map[string]bool)
+	for _, source := range strings.Split(spec, ",") {
+		source = strings.ToLower(strings.TrimSpace(source))
+		switch source {
+		case "":
+			continue
+		case CLIENT_VIA_NAME, CLIENT_VIA_EDNS, CLIENT_VIA_SUBNET, CLIENT_VIA_COOKIE, CLIENT_VIA_IP:
+		default:
+			return nil, fmt.Errorf("unknown client source %q (use %s)", source, DEFAULT_CLIENT_SOURCES)
+		}
+		if !seen[source] {
+			seen[source] = true
+			sources = append(sources, source)
+		}
+	}
+	if len(sources) == 0 {
+		return nil, fmt.Errorf("no client sources given")
+	}
+	return sources, nil
+}
+
+// ValidClientID reports whether id can key delivery tracking: printable,
+// at most one DNS label long, without the separators discovery uses
+func ValidClientID(id string) bool {
+	if id == "" || len(id) > MAX_TAG_LENGTH {
+		return false
+	}
+	for _, r := range id {
+		if r <= ' ' || r > '~' || r == ',' || r == '.' {
+			return false
+		}
+	}
+	return true
+}
+
+// ConsumeClient extracts the client label of a discovery query name,
+// <tags...>.consume[-<group>].<client>.<domain>
+func ConsumeClient(qname, domain string) (string, bool) {
+	rest := strings.TrimSuffix(strings.ToLower(strings.TrimSuffix(qname, ".")), "."+strings.ToLower(strings.TrimSuffix(domain, ".")))
+	labels := strings.Split(rest, ".")
+	for i, label := range labels {
+		if _, ok := groupLabel(label, CONSUME_LABEL); ok && i == len(labels)-2 && ValidClientID(labels[i+1]) {
+			return labels[i+1], true
+		}
+	}
+	return "", false
+}
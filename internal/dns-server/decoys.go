@@ -0,0 +1,256 @@
+package dnsserver
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"net/netip"
+	"os"
+	"strings"
+)
+
+// ================================================================================
+// DECOY RESPONSES
+// Plausible answers for names the server doesn't know
+// ================================================================================
+
+// LESSON: A Zone That Looks Lived In
+// An authoritative server that answers NXDOMAIN for everything except
+// odd-looking TXT names is easy to spot: ask for the apex, www or the
+// mail records and nothing is there. With decoys a zone answers those
+// questions the way an ordinary domain would:
+//
+//   covert.example.com            TXT  "v=spf1 ip4:192.0.2.10 include:_spf.google.com ~all"
+//   _dmarc.covert.example.com     TXT  "v=DMARC1; p=quarantine; rua=mailto:dmarc@covert.example.com"
+//   s1._domainkey.covert...       TXT  "v=DKIM1; k=rsa; p=MIIBIjANBgkqhkiG9w0BAQEFAAOCAQ8AMIIBCgKCAQEA..."
+//   www.covert.example.com        A    192.0.2.10
+//
+// Values are derived from the name under a key, so asking twice gets the
+// same answer twice, like a real record would. The key is drawn at startup
+// unless the zone sets a seed - with a seed the records also survive a
+// restart, which matters to anyone comparing answers across days.
+//
+// Names the protocol uses (chunks, manifests, receipts, discovery) are
+// never given decoys: a receiver polling for a message not uploaded yet
+// must see NXDOMAIN, not a "chunk" that decodes to junk. Hiding those is
+// the ACL's job (see acl.go).
+//
+// Decoy file (JSON), one entry per zone; "" or "@" means the server's domain:
+//
+//   {
+//     "zones": [
+//       {"zone": "@", "txt": true, "a": ["192.0.2.10"], "aaaa": ["2001:db8::10"], "ttl": 3600, "seed": "stable"}
+//     ]
+//   }
+
+// DEFAULT_DECOY_TTL is the TTL of decoy answers when a zone sets none
+const DEFAULT_DECOY_TTL = 3600
+
+// dkimKeyPrefix is the DER header of an RSA-2048 public key, up to the modulus
+const dkimKeyPrefix = "MIIBIjANBgkqhkiG9w0BAQEFAAOCAQ8AMIIBCgKCAQEA"
+
+// spfIncludes are mail providers decoy SPF records delegate to
+var spfIncludes = []string{"_spf.google.com", "spf.protection.outlook.com", "mailgun.org", "sendgrid.net", "_spf.mx.cloudflare.net"}
+
+// DecoyZone says which decoys one zone answers with
+type DecoyZone struct {
+	Zone string   `json:"zone"`
+	TXT  bool     `json:"txt"`  // SPF/DKIM/DMARC-looking TXT records for unknown names
+	A    []string `json:"a"`    // Addresses for A queries of unknown names
+	AAAA []string `json:"aaaa"` // Addresses for AAAA queries of unknown names
+	SPF  string   `json:"spf"`  // The apex SPF record ("" = derived from A/AAAA)
+	TTL  uint32   `json:"ttl"`  // 0 = DEFAULT_DECOY_TTL
+	Seed string   `json:"seed"` // Keys derived values ("" = a key drawn at startup)
+
+	a    []netip.Addr
+	aaaa []netip.Addr
+}
+
+// Decoys is the decoy configuration of every zone
+type Decoys struct {
+	Zones []DecoyZone `json:"zones"`
+}
+
+// ParseDecoys reads a decoy configuration from JSON; zones named "" or
+// "@" are domain
+func ParseDecoys(data []byte, domain string) (*Decoys, error) {
+	var decoys Decoys
+	if err := json.Unmarshal(data, &decoys); err != nil {
+		return nil, fmt.Errorf("invalid decoys: %w", err)
+	}
+	if len(decoys.Zones) == 0 {
+		return nil, fmt.Errorf("decoys list no zones")
+	}
+
+	seen := make(map[string]bool)
+	for i := range decoys.Zones {
+		zone := &decoys.Zones[i]
+		zone.Zone = strings.ToLower(strings.Trim(strings.TrimSpace(zone.Zone), "."))
+		if zone.Zone == "" || zone.Zone == "@" {
+			zone.Zone = strings.ToLower(strings.TrimSuffix(domain, "."))
+		}
+		if seen[zone.Zone] {
+			return nil, fmt.Errorf("decoy zone %s listed twice", zone.Zone)
+		}
+		seen[zone.Zone] = true
+
+		if zone.TTL == 0 {
+			zone.TTL = DEFAULT_DECOY_TTL
+		}
+		var err error
+		if zone.a, err = parseDecoyAddrs(zone.A, false); err != nil {
+			return nil, fmt.Errorf("decoy zone %s: %w", zone.Zone, err)
+		}
+		if zone.aaaa, err = parseDecoyAddrs(zone.AAAA, true); err != nil {
+			return nil, fmt.Errorf("decoy zone %s: %w", zone.Zone, err)
+		}
+		if !zone.TXT && len(zone.a) == 0 && len(zone.aaaa) == 0 {
+			return nil, fmt.Errorf("decoy zone %s answers nothing (set txt, a or aaaa)", zone.Zone)
+		}
+	}
+	return &decoys, nil
+}
+
+// LoadDecoys reads a decoy file
+func LoadDecoys(path, domain string) (*Decoys, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read decoys: %w", err)
+	}
+	return ParseDecoys(data, domain)
+}
+
+// parseDecoyAddrs reads IPv4 (or, with ipv6, IPv6) addresses
+func parseDecoyAddrs(list []string, ipv6 bool) ([]netip.Addr, error) {
+	var addrs []netip.Addr
+	for _, text := range list {
+		addr, err := netip.ParseAddr(strings.TrimSpace(text))
+		if err != nil {
+			return nil, fmt.Errorf("invalid address %q: %w", text, err)
+		}
+		addr = addr.Unmap()
+		if addr.Is6() != ipv6 {
+			if ipv6 {
+				return nil, fmt.Errorf("%s is not an IPv6 address", addr)
+			}
+			return nil, fmt.Errorf("%s is not an IPv4 address", addr)
+		}
+		addrs = append(addrs, addr)
+	}
+	return addrs, nil
+}
+
+// Match returns the zone a query name falls in, the most specific one if
+// zones nest (nil if none)
+func (d *Decoys) Match(qname string) *DecoyZone {
+	if d == nil {
+		return nil
+	}
+	qname = strings.ToLower(strings.TrimSuffix(qname, "."))
+
+	var best *DecoyZone
+	for i := range d.Zones {
+		zone := &d.Zones[i]
+		if qname != zone.Zone && !strings.HasSuffix(qname, "."+zone.Zone) {
+			continue
+		}
+		if best == nil || len(zone.Zone) > len(best.Zone) {
+			best = zone
+		}
+	}
+	return best
+}
+
+// String summarizes the decoys for display
+func (d *Decoys) String() string {
+	zones := make([]string, 0, len(d.Zones))
+	for _, zone := range d.Zones {
+		var answers []string
+		if zone.TXT {
+			answers = append(answers, "TXT")
+		}
+		if len(zone.a) > 0 {
+			answers = append(answers, "A")
+		}
+		if len(zone.aaaa) > 0 {
+			answers = append(answers, "AAAA")
+		}
+		zones = append(zones, fmt.Sprintf("%s (%s)", zone.Zone, strings.Join(answers, "/")))
+	}
+	return strings.Join(zones, ", ")
+}
+
+// Addrs returns the addresses A (or, with ipv6, AAAA) queries are answered with
+func (z *DecoyZone) Addrs(ipv6 bool) []netip.Addr {
+	if ipv6 {
+		return z.aaaa
+	}
+	return z.a
+}
+
+// TXTValue returns the decoy TXT record for a name in the zone: SPF at the
+// apex, DMARC at _dmarc, a DKIM key under _domainkey, and for other names
+// an SPF or site-verification record. key is used when the zone has no seed.
+func (z *DecoyZone) TXTValue(qname string, key []byte) string {
+	qname = strings.ToLower(strings.TrimSuffix(qname, "."))
+	if z.Seed != "" {
+		key = []byte(z.Seed)
+	}
+	relative := strings.TrimSuffix(strings.TrimSuffix(qname, z.Zone), ".")
+	derived := DecoyBytes(key, qname, 32)
+
+	switch {
+	case relative == "":
+		return z.spf(derived)
+	case relative == "_dmarc":
+		return fmt.Sprintf("v=DMARC1; p=quarantine; rua=mailto:dmarc@%s; pct=100", z.Zone)
+	case strings.HasSuffix(relative, "._domainkey"):
+		return "v=DKIM1; k=rsa; p=" + dkimKey(key, qname)
+	case derived[0]%2 == 0 && !strings.HasPrefix(relative, "_"):
+		return "v=spf1 -all" // Hosts that send no mail say so
+	}
+	return "google-site-verification=" + base64.RawURLEncoding.EncodeToString(derived)
+}
+
+// spf returns the zone's apex SPF record
+func (z *DecoyZone) spf(derived []byte) string {
+	if z.SPF != "" {
+		return z.SPF
+	}
+	parts := []string{"v=spf1"}
+	for _, addr := range z.a {
+		parts = append(parts, "ip4:"+addr.String())
+	}
+	for _, addr := range z.aaaa {
+		parts = append(parts, "ip6:"+addr.String())
+	}
+	parts = append(parts, "include:"+spfIncludes[int(derived[0])%len(spfIncludes)], "~all")
+	return strings.Join(parts, " ")
+}
+
+// dkimKey derives a base64 RSA-2048 public key: the real DER layout around
+// a derived modulus, so it parses like one at a glance
+func dkimKey(key []byte, qname string) string {
+	der, _ := base64.StdEncoding.DecodeString(dkimKeyPrefix)
+	modulus := DecoyBytes(key, "dkim:"+qname, 256)
+	modulus[0] |= 0x80   // Full 2048 bits
+	modulus[255] |= 0x01 // Odd, as an RSA modulus is
+	der = append(der, modulus...)
+	der = append(der, 0x02, 0x03, 0x01, 0x00, 0x01) // Exponent 65537
+	return base64.StdEncoding.EncodeToString(der)
+}
+
+// DecoyBytes derives n bytes from a name under key, the same every time
+func DecoyBytes(key []byte, name string, n int) []byte {
+	var out []byte
+	for block := uint32(0); len(out) < n; block++ {
+		mac := hmac.New(sha256.New, key)
+		binary.Write(mac, binary.BigEndian, block)
+		mac.Write([]byte(name))
+		out = mac.Sum(out)
+	}
+	return out[:n]
+}
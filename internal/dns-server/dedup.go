@@ -0,0 +1,83 @@
+package dnsserver
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// ================================================================================
+// DEDUPLICATION
+// Keeps a re-sent message from being stored (and served) twice
+// ================================================================================
+
+// LESSON: Content Addressing
+// Senders using content-addressed message IDs produce byte-identical chunks
+// when they upload the same file again. Each stored message carries a digest
+// of its chunk data, so a commit can tell the two cases apart:
+// 1. Same content already stored: nothing is written, the existing message
+//    is reported back (DuplicateError)
+// 2. Same ID, different content: a conflict, the upload is rejected
+// Chunk names are left out of the digest, so re-uploading with another
+// naming template still counts as the same content.
+
+// ErrConflict means a message ID is taken by different content
+var ErrConflict = errors.New("message ID already in use")
+
+// DuplicateError reports that a message's content is already stored
+type DuplicateError struct {
+	ID       string // Message being committed
+	Existing string // Stored message with the same content
+}
+
+func (e *DuplicateError) Error() string {
+	return fmt.Sprintf("message %s duplicates stored message %s", e.ID, e.Existing)
+}
+
+// ContentDigest hashes a message's chunk data independent of chunk names
+func ContentDigest(chunks map[string]string) string {
+	values := make([]string, 0, len(chunks))
+	for _, data := range chunks {
+		values = append(values, data)
+	}
+	sort.Strings(values)
+
+	hash := sha256.New()
+	for _, data := range values {
+		fmt.Fprintf(hash, "%d:%s\n", len(data), data)
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// ContentDigest returns the message's digest, computing it for messages
+// stored before digests were recorded
+func (m *Message) ContentDigest() string {
+	if m.Digest != "" {
+		return m.Digest
+	}
+	return ContentDigest(m.Chunks)
+}
+
+// checkDuplicate compares a message about to be stored with stored ones
+func (qm *QueueManager) checkDuplicate(msg *Message) error {
+	if existing, err := qm.storage.GetMessage(msg.ID); err == nil {
+		if existing.ContentDigest() == msg.Digest {
+			return &DuplicateError{ID: msg.ID, Existing: existing.ID}
+		}
+		return fmt.Errorf("%w: %s holds different content", ErrConflict, msg.ID)
+	}
+
+	messages, err := qm.storage.ListMessages()
+	if err != nil {
+		return err
+	}
+	for _, existing := range messages {
+		if existing.ContentDigest() == msg.Digest {
+			return &DuplicateError{ID: msg.ID, Existing: existing.ID}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,109 @@
+package dnsserver
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// ================================================================================
+// ZONE DELEGATION
+// What the parent zone must publish before any resolver asks this server
+// ================================================================================
+
+// LESSON: Getting Resolvers to Ask Us
+// A server answering for data.covert.example.com is invisible until the
+// zone above it (covert.example.com, at a registrar or DNS host) delegates:
+//
+//   data.covert.example.com.      NS  ns1.data.covert.example.com.
+//   ns1.data.covert.example.com.  A   203.0.113.53                  (glue)
+//
+// The NS records say who answers for the zone. A name server named inside
+// the zone it serves needs glue - its address published by the parent -
+// or resolvers would have to ask ns1.data.covert.example.com where
+// ns1.data.covert.example.com is. Name servers outside the zone need none,
+// but their own zone must resolve them.
+//
+// The parent's NS set and the one at our apex (-ns) should match: resolvers
+// see both and trust the child's. Then, through any public resolver:
+//
+//   NS of the zone        - our name servers: the delegation is in place
+//   A/AAAA of in-zone NS  - the glue addresses
+//   SOA of the zone       - answered by our server, primary name server ours
+//   capabilities TXT      - the running server answers through the resolver
+//
+// Delegation changes travel at the parent's TTL, often hours: a check that
+// fails right after registering can pass later without any change here.
+
+// PUBLIC_RESOLVERS are the resolvers -check-delegation uses for "public"
+var PUBLIC_RESOLVERS = []string{"8.8.8.8", "1.1.1.1", "9.9.9.9"}
+
+// Parent returns the zone the delegation must be published in
+func (a *Authority) Parent() string {
+	_, parent, found := strings.Cut(a.Zone, ".")
+	if !found {
+		return "." // A top-level zone is delegated by the root
+	}
+	return parent
+}
+
+// NeedsGlue reports whether a name server is inside the zone, so the
+// parent must publish its addresses
+func (a *Authority) NeedsGlue(server NameServer) bool {
+	return a.Contains(server.Name)
+}
+
+// DelegationProblems lists what would keep the delegation from working or
+// being accepted by a registrar
+func (a *Authority) DelegationProblems() []string {
+	var problems []string
+	if len(a.NameServers) < 2 {
+		problems = append(problems, "only one name server: most registrars require two (-ns ns1=...,ns2=...)")
+	}
+
+	for _, server := range a.NameServers {
+		if a.NeedsGlue(server) && len(server.Addrs) == 0 {
+			problems = append(problems, fmt.Sprintf("%s is inside the zone but has no address for glue (-ns %s=<public address>)",
+				server.Name, strings.TrimSuffix(server.Name, "."+a.Zone)))
+		}
+		for _, addr := range server.Addrs {
+			ip := net.IP(addr.AsSlice())
+			if ip.IsPrivate() || ip.IsLoopback() || ip.IsUnspecified() || ip.IsLinkLocalUnicast() {
+				problems = append(problems, fmt.Sprintf("%s has address %s, which public resolvers can't reach", server.Name, addr))
+			}
+		}
+	}
+	return problems
+}
+
+// ParseResolvers parses a comma-separated resolver list ("public" names
+// PUBLIC_RESOLVERS), adding port 53 where none is given
+func ParseResolvers(spec string) ([]string, error) {
+	var resolvers []string
+	for _, resolver := range strings.Split(spec, ",") {
+		resolver = strings.TrimSpace(resolver)
+		switch {
+		case resolver == "":
+			continue
+		case resolver == "public":
+			for _, public := range PUBLIC_RESOLVERS {
+				resolvers = append(resolvers, net.JoinHostPort(public, "53"))
+			}
+			continue
+		}
+
+		if _, _, err := net.SplitHostPort(resolver); err != nil {
+			resolver = net.JoinHostPort(strings.Trim(resolver, "[]"), "53")
+		}
+		host, _, _ := net.SplitHostPort(resolver)
+		if net.ParseIP(host) == nil {
+			return nil, fmt.Errorf("resolver %q is not an IP address", host)
+		}
+		resolvers = append(resolvers, resolver)
+	}
+
+	if len(resolvers) == 0 {
+		return nil, fmt.Errorf("no resolvers given")
+	}
+	return resolvers, nil
+}
@@ -0,0 +1,337 @@
+package dnsserver
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ================================================================================
+// GARBAGE COLLECTION POLICY ENGINE
+// Decides which messages to drop, and when
+// ================================================================================
+
+// LESSON: Why One TTL Isn't Enough
+// A single "older than X" rule either deletes unread messages too early or
+// keeps already-consumed ones far too long. The policy engine combines:
+// 1. Age limits: a global max-age plus per-state limits measured from the
+//    last state change (e.g. consumed messages 1h, unconsumed 72h)
+// 2. Capacity limits: max messages and max total bytes. When over capacity
+//    we evict consumed messages first, then delivered, then new - oldest
+//    first within each state.
+
+// GCPolicy configures the garbage collector
+type GCPolicy struct {
+	MaxAge        time.Duration                  // Remove any message older than this (0 = no limit)
+	MaxTotalBytes int64                          // Evict until total size is below (0 = no limit)
+	MaxMessages   int                            // Evict until message count is below (0 = no limit)
+	StateMaxAge   map[MessageState]time.Duration // Per-state age, measured from the last state change
+	Interval      time.Duration                  // How often the policy is evaluated
+}
+
+// GCReport summarizes one collection run
+type GCReport struct {
+	Scanned    int
+	Removed    int
+	FreedBytes int64
+	ByReason   map[string]int // Rule name -> messages removed
+}
+
+// DefaultGCPolicy keeps unread messages for 3 days and consumed ones for an hour
+func DefaultGCPolicy() GCPolicy {
+	return GCPolicy{
+		MaxAge: 7 * 24 * time.Hour,
+		StateMaxAge: map[MessageState]time.Duration{
+			StateNew:       72 * time.Hour,
+			StateDelivered: 24 * time.Hour,
+			StateConsumed:  1 * time.Hour,
+		},
+		Interval: 1 * time.Hour,
+	}
+}
+
+// ParseGCPolicy reads a policy from a comma separated spec, e.g.
+// "max-age=168h,max-bytes=500MB,max-messages=1000,consumed=1h,new=72h,interval=10m".
+// Rules not mentioned keep their DefaultGCPolicy values; "0" disables a rule.
+func ParseGCPolicy(spec string) (GCPolicy, error) {
+	policy := DefaultGCPolicy()
+
+	for _, field := range strings.Split(spec, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+
+		parts := strings.SplitN(field, "=", 2)
+		if len(parts) != 2 {
+			return GCPolicy{}, fmt.Errorf("malformed GC rule: %q", field)
+		}
+		key := strings.ToLower(strings.TrimSpace(parts[0]))
+		val := strings.TrimSpace(parts[1])
+
+		var err error
+		switch key {
+		case "max-age":
+			policy.MaxAge, err = parseGCDuration(val)
+		case "max-bytes":
+			policy.MaxTotalBytes, err = ParseByteSize(val)
+		case "max-messages":
+			policy.MaxMessages, err = strconv.Atoi(val)
+		case "interval":
+			policy.Interval, err = parseGCDuration(val)
+		case "new", "delivered", "consumed", "expired":
+			var age time.Duration
+			age, err = parseGCDuration(val)
+			state := parseStateName(key)
+			if age == 0 {
+				delete(policy.StateMaxAge, state)
+			} else {
+				policy.StateMaxAge[state] = age
+			}
+		default:
+			return GCPolicy{}, fmt.Errorf("unknown GC rule: %s", key)
+		}
+
+		if err != nil {
+			return GCPolicy{}, fmt.Errorf("invalid %s: %w", key, err)
+		}
+	}
+
+	if policy.Interval <= 0 {
+		return GCPolicy{}, fmt.Errorf("interval must be positive")
+	}
+
+	return policy, nil
+}
+
+// String describes the policy in ParseGCPolicy syntax
+func (p GCPolicy) String() string {
+	fields := []string{
+		fmt.Sprintf("max-age=%v", p.MaxAge),
+		fmt.Sprintf("max-bytes=%d", p.MaxTotalBytes),
+		fmt.Sprintf("max-messages=%d", p.MaxMessages),
+	}
+	for _, state := range []MessageState{StateNew, StateDelivered, StateConsumed, StateExpired} {
+		if age, ok := p.StateMaxAge[state]; ok {
+			fields = append(fields, fmt.Sprintf("%s=%v", state, age))
+		}
+	}
+	fields = append(fields, fmt.Sprintf("interval=%v", p.Interval))
+	return strings.Join(fields, ",")
+}
+
+// GarbageCollector evaluates a GCPolicy against a storage backend
+type GarbageCollector struct {
+	storage Storage
+	policy  GCPolicy
+	stop    chan struct{}
+	once    sync.Once
+}
+
+// NewGarbageCollector creates a collector for a storage backend
+func NewGarbageCollector(storage Storage, policy GCPolicy) *GarbageCollector {
+	return &GarbageCollector{
+		storage: storage,
+		policy:  policy,
+		stop:    make(chan struct{}),
+	}
+}
+
+// Policy returns the active policy
+func (gc *GarbageCollector) Policy() GCPolicy {
+	return gc.policy
+}
+
+// Run evaluates the policy once
+func (gc *GarbageCollector) Run(now time.Time) GCReport {
+	report := GCReport{ByReason: make(map[string]int)}
+
+	messages, err := gc.storage.ListMessages()
+	if err != nil {
+		return report
+	}
+	report.Scanned = len(messages)
+
+	var doomed []string
+	var survivors []*Message
+
+	// Step 1: Age rules
+	for _, msg := range messages {
+		if reason := gc.ageRule(msg, now); reason != "" {
+			doomed = append(doomed, msg.ID)
+			report.ByReason[reason]++
+			report.FreedBytes += msg.Size()
+			continue
+		}
+		survivors = append(survivors, msg)
+	}
+
+	// Step 2: Capacity rules, evicting the least valuable messages first
+	sort.Slice(survivors, func(i, j int) bool {
+		ri, rj := evictionRank(survivors[i].State), evictionRank(survivors[j].State)
+		if ri != rj {
+			return ri < rj
+		}
+		return survivors[i].CreatedAt.Before(survivors[j].CreatedAt)
+	})
+
+	var totalBytes int64
+	for _, msg := range survivors {
+		totalBytes += msg.Size()
+	}
+
+	remaining := len(survivors)
+	for _, msg := range survivors {
+		overCount := gc.policy.MaxMessages > 0 && remaining > gc.policy.MaxMessages
+		overBytes := gc.policy.MaxTotalBytes > 0 && totalBytes > gc.policy.MaxTotalBytes
+		if !overCount && !overBytes {
+			break
+		}
+
+		reason := "max-messages"
+		if overBytes {
+			reason = "max-bytes"
+		}
+
+		doomed = append(doomed, msg.ID)
+		report.ByReason[reason]++
+		report.FreedBytes += msg.Size()
+		totalBytes -= msg.Size()
+		remaining--
+	}
+
+	if len(doomed) > 0 {
+		report.Removed = gc.storage.DeleteMessages(doomed...)
+	}
+
+	return report
+}
+
+// Start evaluates the policy every Interval until Stop is called.
+// onRun (optional) receives the report of every run.
+func (gc *GarbageCollector) Start(onRun func(GCReport)) {
+	go func() {
+		ticker := time.NewTicker(gc.policy.Interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				report := gc.Run(time.Now())
+				if onRun != nil {
+					onRun(report)
+				}
+			case <-gc.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop ends scheduled collection
+func (gc *GarbageCollector) Stop() {
+	gc.once.Do(func() { close(gc.stop) })
+}
+
+// ageRule returns the name of the age rule a message violates, if any
+func (gc *GarbageCollector) ageRule(msg *Message, now time.Time) string {
+	if gc.policy.MaxAge > 0 && now.Sub(msg.CreatedAt) > gc.policy.MaxAge {
+		return "max-age"
+	}
+
+	if limit, ok := gc.policy.StateMaxAge[msg.State]; ok && limit > 0 {
+		if now.Sub(msg.StateSince()) > limit {
+			return msg.State.String()
+		}
+	}
+
+	return ""
+}
+
+// evictionRank orders states from cheapest to most valuable to keep
+func evictionRank(state MessageState) int {
+	switch state {
+	case StateExpired:
+		return 0
+	case StateConsumed:
+		return 1
+	case StateDelivered:
+		return 2
+	default:
+		return 3
+	}
+}
+
+// String returns the state's policy name
+func (s MessageState) String() string {
+	switch s {
+	case StateNew:
+		return "new"
+	case StateDelivered:
+		return "delivered"
+	case StateConsumed:
+		return "consumed"
+	case StateExpired:
+		return "expired"
+	}
+	return fmt.Sprintf("state(%d)", int(s))
+}
+
+// parseStateName maps a policy key to a state
+func parseStateName(name string) MessageState {
+	switch name {
+	case "delivered":
+		return StateDelivered
+	case "consumed":
+		return StateConsumed
+	case "expired":
+		return StateExpired
+	}
+	return StateNew
+}
+
+// parseGCDuration accepts Go durations plus "d" for days; "0" disables a rule
+func parseGCDuration(value string) (time.Duration, error) {
+	if value == "0" {
+		return 0, nil
+	}
+	if strings.HasSuffix(value, "d") {
+		days, err := strconv.Atoi(strings.TrimSuffix(value, "d"))
+		if err != nil {
+			return 0, err
+		}
+		return time.Duration(days) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(value)
+}
+
+// ParseByteSize accepts plain bytes or KB/MB/GB suffixes (e.g. "64MB")
+func ParseByteSize(value string) (int64, error) {
+	upper := strings.ToUpper(value)
+	multiplier := int64(1)
+
+	for _, unit := range []struct {
+		suffix string
+		size   int64
+	}{
+		{"GB", 1 << 30},
+		{"MB", 1 << 20},
+		{"KB", 1 << 10},
+		{"B", 1},
+	} {
+		if strings.HasSuffix(upper, unit.suffix) {
+			multiplier = unit.size
+			upper = strings.TrimSuffix(upper, unit.suffix)
+			break
+		}
+	}
+
+	n, err := strconv.ParseInt(strings.TrimSpace(upper), 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	return n * multiplier, nil
+}
@@ -0,0 +1,178 @@
+package dnsserver
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ================================================================================
+// CONSUMER GROUPS
+// Named groups of receivers, each reading every message on its own cursor
+// ================================================================================
+
+// LESSON: One Queue, Several Readers
+// Without groups a message has one state for everybody: once a receiver
+// consumed it (or fetched every chunk), every other receiver stops
+// discovering it. That is right for a pool of workers sharing the load and
+// wrong for an archiver and an analyst who both need every message.
+//
+// A consumer group is a named reader with its own cursor on each message:
+//
+//   new        the group hasn't been handed the message yet
+//   delivered  one member discovered it; the other members don't see it
+//   consumed   a member acknowledged it
+//
+// Groups don't see each other's cursors, so every group gets every
+// message, and within a group each message goes to one member - a pool of
+// workers is one group, each independent reader another. Receivers join a
+// group through the discovery and acknowledgement names:
+//
+//   <tags...>.consume-<group>.<client>.<domain>
+//   ack-<group>.<message ID>.<client>.<domain>
+//
+// and on the HTTP API with ?group= and "group". Receivers outside any group
+// keep the message's own state, as before. Burn after reading still deletes
+// the message for everybody, and the GC goes by the message's own state, so
+// a message only groups read is kept by the new= and max-age rules.
+
+// GroupCursor is where one consumer group stands with a message
+type GroupCursor struct {
+	State    MessageState `json:"state"`
+	Since    time.Time    `json:"since"`
+	ClientID string       `json:"client_id,omitempty"` // Member that moved it there
+}
+
+// ParseGroup validates a consumer group name: one label of a-z, 0-9, '-'
+// and '_', short enough to follow "consume-"
+func ParseGroup(name string) (string, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if name == "" {
+		return "", fmt.Errorf("consumer group name is empty")
+	}
+	if max := MAX_TAG_LENGTH - len(CONSUME_LABEL) - 1; len(name) > max {
+		return "", fmt.Errorf("consumer group %q is longer than %d characters", name, max)
+	}
+	for _, r := range name {
+		if !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '-' || r == '_') {
+			return "", fmt.Errorf("consumer group %q contains %q (allowed: a-z, 0-9, '-', '_')", name, r)
+		}
+	}
+	return name, nil
+}
+
+// groupLabel splits a label into its base and consumer group, e.g.
+// "consume-workers" into "consume" and "workers"
+func groupLabel(label, base string) (string, bool) {
+	if label == base {
+		return "", true
+	}
+	group, found := strings.CutPrefix(label, base+"-")
+	if !found {
+		return "", false
+	}
+	_, err := ParseGroup(group)
+	return group, err == nil
+}
+
+// withGroup appends a consumer group to a label ("" = none)
+func withGroup(label, group string) string {
+	if group == "" {
+		return label
+	}
+	return label + "-" + group
+}
+
+// newForGroup reports whether a consumer group still has to be handed msg
+func (m *Message) newForGroup(group string) bool {
+	if m.State == StateExpired {
+		return false
+	}
+	cursor, exists := m.Groups[group]
+	return !exists || cursor.State == StateNew
+}
+
+// setGroupState moves msg within a consumer group; back to new, the group
+// forgets it was handed msg. The map is replaced rather than changed in
+// place, as listed messages are read without the storage lock.
+func (m *Message) setGroupState(group, clientID string, state MessageState, at time.Time) {
+	groups := make(map[string]GroupCursor, len(m.Groups)+1)
+	for name, cursor := range m.Groups {
+		groups[name] = cursor
+	}
+	if state == StateNew {
+		delete(groups, group)
+	} else {
+		groups[group] = GroupCursor{State: state, Since: at, ClientID: clientID}
+	}
+	if len(groups) == 0 {
+		groups = nil
+	}
+	m.Groups = groups
+}
+
+// GroupNames returns the consumer groups that have a cursor on msg, sorted
+func (m *Message) GroupNames() []string {
+	names := make([]string, 0, len(m.Groups))
+	for name := range m.Groups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ConsumeGroup hands a member of a consumer group the messages the group
+// wasn't handed yet, only those addressed to the member or nobody and
+// carrying every tag given, in queue order and at most one batch. They
+// become delivered within the group only.
+func (qm *QueueManager) ConsumeGroup(group string, client ClientInfo, tags ...string) ([]*Message, error) {
+	// Two members asking at once must not both be handed a message
+	qm.groupMu.Lock()
+	defer qm.groupMu.Unlock()
+
+	messages, err := qm.storage.GetGroupMessages(group)
+	if err != nil {
+		return nil, err
+	}
+	messages = qm.batch(FilterByTags(FilterByRecipient(messages, client.ID), tags))
+
+	for _, msg := range messages {
+		qm.storage.SetGroupState(msg.ID, group, client.ID, StateDelivered)
+	}
+	return messages, nil
+}
+
+// AcknowledgeGroup marks a message consumed within a consumer group,
+// burning it if it was uploaded with burn. Acknowledging a message that
+// already burned succeeds.
+func (qm *QueueManager) AcknowledgeGroup(msgID, group, clientID string) error {
+	if qm.Burned(msgID) {
+		return nil
+	}
+	if err := qm.storage.SetGroupState(msgID, group, clientID, StateConsumed); err != nil {
+		return err
+	}
+
+	if msg, err := qm.storage.GetMessage(msgID); err == nil && msg.Burn {
+		qm.burn(msgID)
+	}
+	return nil
+}
+
+// groupLeasesExpired returns the groups that were handed msg but didn't
+// acknowledge it within its visibility timeout (see visibility.go)
+func (qm *QueueManager) groupLeasesExpired(msg *Message, now time.Time) []string {
+	timeout := qm.visibilityOf(msg)
+	if timeout <= 0 || msg.State == StateExpired {
+		return nil
+	}
+
+	var expired []string
+	for _, group := range msg.GroupNames() {
+		if cursor := msg.Groups[group]; cursor.State == StateDelivered && now.Sub(cursor.Since) >= timeout {
+			expired = append(expired, group)
+		}
+	}
+	return expired
+}
@@ -0,0 +1,172 @@
+package dnsserver
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/acme/autocert"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ================================================================================
+// HTTP API LISTENER
+// Where the HTTP API listens, over plain HTTP or TLS, and how it stops
+// ================================================================================
+
+// LESSON: Don't Shout on Every Interface
+// The API used to listen on :8080 in plaintext - every interface, API keys
+// and uploaded chunks readable by anyone on the path. Binding to loopback
+// or an internal address keeps it off the internet entirely; TLS protects
+// it where it must be reachable. The certificate comes from PEM files, or
+// from Let's Encrypt through autocert: the names listed are requested on
+// first use (TLS-ALPN-01 challenge, so the listener must be reachable on
+// port 443 under those names) and kept in a cache directory for renewals.
+//
+// Shutdown is graceful: the listener closes at once, requests in flight get
+// HTTP_SHUTDOWN_TIMEOUT to finish, so an upload being stored isn't cut off
+// halfway.
+
+const (
+	// DEFAULT_HTTP_ADDR is where the API listens unless told otherwise
+	DEFAULT_HTTP_ADDR = ":8080"
+
+	// DEFAULT_AUTOCERT_CACHE keeps autocert's certificates and account key
+	DEFAULT_AUTOCERT_CACHE = "dns_autocert"
+
+	// HTTP_SHUTDOWN_TIMEOUT bounds how long requests in flight may take at shutdown
+	HTTP_SHUTDOWN_TIMEOUT = 10 * time.Second
+
+	// MAX_REQUEST_BODY caps the body of any API request, uploads included,
+	// so nobody - signed in or not - can make the server buffer what it sends
+	MAX_REQUEST_BODY = 64 << 20
+)
+
+// LimitBody caps a request's body at MAX_REQUEST_BODY; reading past it
+// fails with an error BodyTooLarge recognizes
+func LimitBody(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, MAX_REQUEST_BODY)
+}
+
+// BodyTooLarge reports whether err comes from reading past MAX_REQUEST_BODY
+func BodyTooLarge(err error) bool {
+	var tooLarge *http.MaxBytesError
+	return errors.As(err, &tooLarge)
+}
+
+// HTTPConfig says where and how the HTTP API listens
+type HTTPConfig struct {
+	Addr          string   // host:port (DEFAULT_HTTP_ADDR if empty)
+	CertFile      string   // TLS certificate (PEM)
+	KeyFile       string   // TLS private key (PEM)
+	AutocertHosts []string // Names to get Let's Encrypt certificates for
+	AutocertCache string   // Directory for autocert state (DEFAULT_AUTOCERT_CACHE if empty)
+}
+
+// Validate checks that the TLS settings fit together
+func (c HTTPConfig) Validate() error {
+	if (c.CertFile == "") != (c.KeyFile == "") {
+		return errors.New("a TLS certificate needs both a cert and a key file")
+	}
+	if c.CertFile != "" && len(c.AutocertHosts) > 0 {
+		return errors.New("use either certificate files or autocert, not both")
+	}
+	for _, host := range c.AutocertHosts {
+		if host == "" || strings.ContainsAny(host, ":/ ") {
+			return fmt.Errorf("invalid autocert host %q", host)
+		}
+	}
+	return nil
+}
+
+// TLS reports whether the API is served over TLS
+func (c HTTPConfig) TLS() bool {
+	return c.CertFile != "" || len(c.AutocertHosts) > 0
+}
+
+// String describes the listener for display
+func (c HTTPConfig) String() string {
+	addr := c.Addr
+	if addr == "" {
+		addr = DEFAULT_HTTP_ADDR
+	}
+	switch {
+	case len(c.AutocertHosts) > 0:
+		return fmt.Sprintf("https://%s (autocert: %s)", addr, strings.Join(c.AutocertHosts, ", "))
+	case c.CertFile != "":
+		return fmt.Sprintf("https://%s (%s)", addr, c.CertFile)
+	}
+	return "http://" + addr
+}
+
+// ListenHTTP binds the API's address and serves handler on it in the
+// background. Binding happens before it returns, so a taken port or a bad
+// certificate fails at startup; later serve errors go to onError.
+func ListenHTTP(config HTTPConfig, handler http.Handler, onError func(error)) (*http.Server, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	addr := config.Addr
+	if addr == "" {
+		addr = DEFAULT_HTTP_ADDR
+	}
+
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	switch {
+	case config.CertFile != "":
+		cert, err := tls.LoadX509KeyPair(config.CertFile, config.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load HTTP API certificate: %w", err)
+		}
+		server.TLSConfig = &tls.Config{
+			Certificates: []tls.Certificate{cert},
+			MinVersion:   tls.VersionTLS12,
+		}
+	case len(config.AutocertHosts) > 0:
+		cache := config.AutocertCache
+		if cache == "" {
+			cache = DEFAULT_AUTOCERT_CACHE
+		}
+		manager := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(config.AutocertHosts...),
+			Cache:      autocert.DirCache(cache),
+		}
+		server.TLSConfig = manager.TLSConfig()
+		server.TLSConfig.MinVersion = tls.VersionTLS12
+	}
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("HTTP API can't listen on %s: %w", addr, err)
+	}
+	if server.TLSConfig != nil {
+		listener = tls.NewListener(listener, server.TLSConfig)
+	}
+
+	go func() {
+		if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) && onError != nil {
+			onError(err)
+		}
+	}()
+	return server, nil
+}
+
+// ShutdownHTTP stops accepting requests and waits up to
+// HTTP_SHUTDOWN_TIMEOUT for those in flight
+func ShutdownHTTP(server *http.Server) error {
+	if server == nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), HTTP_SHUTDOWN_TIMEOUT)
+	defer cancel()
+	return server.Shutdown(ctx)
+}
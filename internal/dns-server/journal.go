@@ -0,0 +1,373 @@
+package dnsserver
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ================================================================================
+// WRITE-BEHIND JOURNAL
+// Makes FileStorage changes durable without rewriting the data file
+// ================================================================================
+
+// LESSON: Snapshot + Journal
+// FileStorage used to rewrite its whole data file on every upload, so each
+// upload waited for every other message to be serialized and synced. Now a
+// change is one line appended to a journal (synced by default), and the
+// data file becomes a snapshot written in the background every so often.
+// Recovery loads the snapshot, then replays the journal entries it doesn't
+// include yet.
+//
+// Every entry carries a sequence number and every snapshot records the
+// last one it includes. A snapshot first moves the journal aside as a
+// numbered segment (stores carry on in a fresh file), then writes the data
+// file, then deletes the segments it covers. A crash at any point leaves a
+// snapshot plus segments whose new entries are replayed; entries the
+// snapshot already has are skipped by number, so nothing applies twice.
+//
+//   dns_data.json                  snapshot, journal_seq = 40
+//   dns_data.json.journal.00000052 entries 41-52 (moved aside, not yet covered)
+//   dns_data.json.journal          entries 53-...
+
+const (
+	// JOURNAL_SUFFIX is appended to the data file name for the journal
+	JOURNAL_SUFFIX = ".journal"
+
+	// DEFAULT_SNAPSHOT_INTERVAL is how often changes are folded into the data file
+	DEFAULT_SNAPSHOT_INTERVAL = 30 * time.Second
+
+	// MAX_JOURNAL_LINE bounds one journal entry (a stored message with all its chunks)
+	MAX_JOURNAL_LINE = 256 << 20
+)
+
+// Journal operations
+const (
+	JOURNAL_STORE     = "store"
+	JOURNAL_DELIVERED = "delivered"
+	JOURNAL_CONSUMED  = "consumed"
+	JOURNAL_SERVED    = "served"
+	JOURNAL_USAGE     = "usage"
+	JOURNAL_DELETE    = "delete"
+	JOURNAL_STATE     = "state"
+	JOURNAL_GROUP     = "group"
+	JOURNAL_EVICT     = "evict"   // A delete by the retention policy (see retention.go)
+	JOURNAL_REPLICA   = "replica" // A message copied from a primary (see replication.go)
+)
+
+// journalEntry is one line of the journal
+type journalEntry struct {
+	Seq     uint64       `json:"seq"`
+	Op      string       `json:"op"`
+	Message *Message     `json:"message,omitempty"` // store, replica
+	ID      string       `json:"id,omitempty"`      // delivered, served, consumed, state, group
+	Client  string       `json:"client,omitempty"`  // delivered, served, consumed, usage, group
+	Addr    string       `json:"addr,omitempty"`    // delivered, served
+	Via     string       `json:"via,omitempty"`     // delivered, served
+	Chunks  []string     `json:"chunks,omitempty"`  // served
+	Day     string       `json:"day,omitempty"`     // usage
+	Bytes   int64        `json:"bytes,omitempty"`   // usage
+	IDs     []string     `json:"ids,omitempty"`     // delete, evict
+	State   MessageState `json:"state,omitempty"`   // state, group
+	Group   string       `json:"group,omitempty"`   // group
+	At      time.Time    `json:"at"`
+}
+
+// journal appends entries to the current segment file
+type journal struct {
+	path      string
+	sync      bool     // Sync after every entry
+	file      *os.File // Current segment, nil until open
+	seq       uint64   // Last sequence number written
+	saved     uint64   // Last sequence number in the data file
+	valid     int64    // Bytes of whole entries in the current segment, found by replay
+	unrotated bool     // The current segment holds entries
+	mu        sync.Mutex
+}
+
+// open opens the current segment for appending, cutting off a torn last
+// entry so new entries start on a line of their own
+func (j *journal) open() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	file, err := os.OpenFile(j.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open journal: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("failed to open journal: %w", err)
+	}
+
+	switch size := info.Size(); {
+	case size > j.valid:
+		err = file.Truncate(j.valid)
+	case size == j.valid-1:
+		// The last entry is whole but its newline never made it
+		_, err = file.Write([]byte{'\n'})
+	}
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("failed to repair journal: %w", err)
+	}
+
+	j.file = file
+	j.unrotated = j.valid > 0
+	return nil
+}
+
+// append numbers an entry and writes it as one line
+func (j *journal) append(entry journalEntry) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.file == nil {
+		return errors.New("journal is closed")
+	}
+
+	entry.Seq = j.seq + 1
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to marshal journal entry: %w", err)
+	}
+	if _, err := j.file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("failed to write journal: %w", err)
+	}
+	if j.sync {
+		if err := j.file.Sync(); err != nil {
+			return fmt.Errorf("failed to sync journal: %w", err)
+		}
+	}
+
+	j.seq = entry.Seq
+	j.unrotated = true
+	return nil
+}
+
+// rotate moves the current segment aside and starts a new one, returning
+// the last sequence number written. Callers keep changes from being made
+// until the state for that number is captured.
+func (j *journal) rotate() (uint64, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.file == nil || !j.unrotated {
+		return j.seq, nil
+	}
+
+	if err := j.file.Sync(); err != nil {
+		return 0, fmt.Errorf("failed to sync journal: %w", err)
+	}
+	if err := j.file.Close(); err != nil {
+		return 0, fmt.Errorf("failed to close journal: %w", err)
+	}
+	j.file = nil
+
+	if err := os.Rename(j.path, j.segmentPath(j.seq)); err != nil {
+		return 0, fmt.Errorf("failed to rotate journal: %w", err)
+	}
+	file, err := os.OpenFile(j.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return 0, fmt.Errorf("failed to reopen journal: %w", err)
+	}
+
+	j.file = file
+	j.unrotated = false
+	return j.seq, nil
+}
+
+// snapshotted records that the data file includes every entry up to seq
+// and removes the segments it covers
+func (j *journal) snapshotted(seq uint64) {
+	j.mu.Lock()
+	if seq > j.saved {
+		j.saved = seq
+	}
+	j.mu.Unlock()
+
+	segments, _ := j.segments()
+	for _, segment := range segments {
+		if segment.last <= seq {
+			if err := os.Remove(segment.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+				storageLog.Warn("⚠️  Failed to remove journal segment", "path", segment.path, "err", err)
+			}
+		}
+	}
+}
+
+// pending reports whether entries were written since the last snapshot
+func (j *journal) pending() bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.seq > j.saved
+}
+
+// leftover reports whether journal files hold anything, even unreadable
+// entries, that a snapshot should clear away
+func (j *journal) leftover() bool {
+	j.mu.Lock()
+	unrotated := j.unrotated
+	j.mu.Unlock()
+
+	segments, _ := j.segments()
+	return unrotated || len(segments) > 0
+}
+
+// close syncs and closes the current segment
+func (j *journal) close() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.file == nil {
+		return nil
+	}
+	err := j.file.Sync()
+	if closeErr := j.file.Close(); err == nil {
+		err = closeErr
+	}
+	j.file = nil
+	return err
+}
+
+// segmentPath names the segment whose last entry is seq; the zero padding
+// keeps segments in order when listed
+func (j *journal) segmentPath(seq uint64) string {
+	return fmt.Sprintf("%s.%08d", j.path, seq)
+}
+
+// journalSegment is a journal file moved aside by a snapshot
+type journalSegment struct {
+	path string
+	last uint64 // Last sequence number in it
+}
+
+// segments lists the segments moved aside, oldest first
+func (j *journal) segments() ([]journalSegment, error) {
+	matches, err := filepath.Glob(j.path + ".*")
+	if err != nil {
+		return nil, err
+	}
+
+	var segments []journalSegment
+	for _, match := range matches {
+		last, err := strconv.ParseUint(strings.TrimPrefix(match, j.path+"."), 10, 64)
+		if err != nil {
+			continue
+		}
+		segments = append(segments, journalSegment{path: match, last: last})
+	}
+	sort.Slice(segments, func(a, b int) bool { return segments[a].last < segments[b].last })
+	return segments, nil
+}
+
+// replay applies the journal entries the snapshot doesn't include
+func (fs *FileStorage) replay() error {
+	fs.MemoryStorage.mu.Lock()
+	defer fs.MemoryStorage.mu.Unlock()
+
+	segments, err := fs.journal.segments()
+	if err != nil {
+		return err
+	}
+	paths := make([]string, 0, len(segments)+1)
+	for _, segment := range segments {
+		paths = append(paths, segment.path)
+	}
+	paths = append(paths, fs.journal.path)
+
+	replayed := 0
+	for _, path := range paths {
+		file, err := os.Open(path)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		var valid int64
+		scanner := bufio.NewScanner(file)
+		scanner.Buffer(nil, MAX_JOURNAL_LINE)
+		for scanner.Scan() {
+			var entry journalEntry
+			if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+				// A crash mid-append leaves a torn last line; nothing after it was acknowledged
+				storageLog.Warn("⚠️  Journal replay stopped at an unreadable entry", "path", path, "after", fs.journal.seq)
+				break
+			}
+			valid += int64(len(scanner.Bytes())) + 1
+			if entry.Seq <= fs.journal.seq {
+				continue
+			}
+			fs.apply(entry)
+			fs.journal.seq = entry.Seq
+			replayed++
+		}
+		err = scanner.Err()
+		file.Close()
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", path, err)
+		}
+		if path == fs.journal.path {
+			fs.journal.valid = valid
+		}
+	}
+
+	if replayed > 0 {
+		storageLog.Info("♻️  Recovered journal entries", "entries", replayed, "through", fs.journal.seq)
+	}
+	return nil
+}
+
+// apply replays one entry; callers hold the write lock
+func (fs *FileStorage) apply(entry journalEntry) {
+	switch entry.Op {
+	case JOURNAL_STORE:
+		if entry.Message == nil {
+			return
+		}
+		if _, exists := fs.messages[entry.Message.ID]; !exists {
+			fs.insert(entry.Message)
+		}
+	case JOURNAL_DELIVERED:
+		if msg, exists := fs.editable(entry.ID); exists {
+			fs.markDelivered(msg, ClientInfo{ID: entry.Client, Addr: entry.Addr, Via: entry.Via}, entry.At)
+		}
+	case JOURNAL_SERVED:
+		if msg, exists := fs.editable(entry.ID); exists {
+			fs.markServed(msg, ClientInfo{ID: entry.Client, Addr: entry.Addr, Via: entry.Via}, entry.Chunks, entry.At)
+		}
+	case JOURNAL_CONSUMED:
+		if msg, exists := fs.editable(entry.ID); exists {
+			fs.markConsumed(msg, entry.At)
+		}
+	case JOURNAL_USAGE:
+		addUsage(fs.usage, entry.Client, entry.Day, entry.Bytes)
+	case JOURNAL_DELETE:
+		fs.deleteMessages(entry.IDs)
+	case JOURNAL_EVICT:
+		fs.removeEvicted(entry.IDs)
+	case JOURNAL_REPLICA:
+		if entry.Message != nil {
+			fs.putReplica(entry.Message)
+		}
+	case JOURNAL_STATE:
+		if msg, exists := fs.editable(entry.ID); exists {
+			fs.setState(msg, entry.State, entry.At)
+		}
+	case JOURNAL_GROUP:
+		if msg, exists := fs.editable(entry.ID); exists {
+			msg.setGroupState(entry.Group, entry.Client, entry.State, entry.At)
+		}
+	}
+}
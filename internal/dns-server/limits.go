@@ -0,0 +1,231 @@
+package dnsserver
+
+import (
+	"errors"
+	"time"
+)
+
+// ================================================================================
+// BOUNDED STORAGE LOOKUPS
+// Caps concurrent backend calls and gives each one a deadline
+// ================================================================================
+
+// LESSON: Why Bound the Backend?
+// The DNS library answers every datagram in its own goroutine. With the
+// in-memory store a lookup takes microseconds, so goroutines never pile up.
+// A slow backend (SQLite on a busy disk, S3 across the internet) changes
+// that: under a query flood each goroutine blocks on storage, and thousands
+// of them queue behind the same slow calls. LimitedStorage puts a fixed pool
+// of worker slots in front of the backend. A call that can't get a slot, or
+// whose backend call outlives the deadline, fails fast with ErrStorageBusy
+// or ErrStorageTimeout. The server answers those with SERVFAIL, which
+// resolvers retry - unlike NXDOMAIN, which they would cache. A timed-out
+// backend call keeps its slot until it really returns, so the number of
+// calls in flight never exceeds the pool size.
+
+var (
+	// ErrStorageBusy means every worker slot stayed taken until the deadline
+	ErrStorageBusy = errors.New("storage busy: no worker available")
+
+	// ErrStorageTimeout means the backend didn't answer before the deadline
+	ErrStorageTimeout = errors.New("storage lookup timed out")
+)
+
+// IsOverloaded reports whether err came from the limits rather than the backend
+func IsOverloaded(err error) bool {
+	return errors.Is(err, ErrStorageBusy) || errors.Is(err, ErrStorageTimeout)
+}
+
+// LimitedStorage runs another Storage's calls on a bounded worker pool
+type LimitedStorage struct {
+	inner   Storage
+	slots   chan struct{}
+	timeout time.Duration
+}
+
+// NewLimitedStorage wraps inner with at most workers concurrent calls, each
+// given timeout to complete (0 waits indefinitely)
+func NewLimitedStorage(inner Storage, workers int, timeout time.Duration) *LimitedStorage {
+	if workers < 1 {
+		workers = 1
+	}
+	return &LimitedStorage{
+		inner:   inner,
+		slots:   make(chan struct{}, workers),
+		timeout: timeout,
+	}
+}
+
+// Share wraps another backend on the same worker pool and deadline, so
+// several backends together stay within one limit
+func (ls *LimitedStorage) Share(inner Storage) *LimitedStorage {
+	return &LimitedStorage{inner: inner, slots: ls.slots, timeout: ls.timeout}
+}
+
+// Inner returns the wrapped backend
+func (ls *LimitedStorage) Inner() Storage {
+	return ls.inner
+}
+
+// Workers returns the size of the worker pool
+func (ls *LimitedStorage) Workers() int {
+	return cap(ls.slots)
+}
+
+// InFlight returns the number of backend calls currently running
+func (ls *LimitedStorage) InFlight() int {
+	return len(ls.slots)
+}
+
+// run executes op on a worker slot, giving up at the deadline. op must only
+// write variables the caller reads after a nil return.
+func (ls *LimitedStorage) run(op func()) error {
+	var deadline <-chan time.Time
+	if ls.timeout > 0 {
+		timer := time.NewTimer(ls.timeout)
+		defer timer.Stop()
+		deadline = timer.C
+	}
+
+	select {
+	case ls.slots <- struct{}{}:
+	case <-deadline:
+		return ErrStorageBusy
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer func() { <-ls.slots }()
+		op()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-deadline:
+		return ErrStorageTimeout
+	}
+}
+
+func (ls *LimitedStorage) StoreMessage(msg *Message) error {
+	var err error
+	if limitErr := ls.run(func() { err = ls.inner.StoreMessage(msg) }); limitErr != nil {
+		return limitErr
+	}
+	return err
+}
+
+func (ls *LimitedStorage) GetMessage(id string) (*Message, error) {
+	var msg *Message
+	var err error
+	if limitErr := ls.run(func() { msg, err = ls.inner.GetMessage(id) }); limitErr != nil {
+		return nil, limitErr
+	}
+	return msg, err
+}
+
+func (ls *LimitedStorage) GetChunk(msgID, chunkName string) (string, error) {
+	var chunk string
+	var err error
+	if limitErr := ls.run(func() { chunk, err = ls.inner.GetChunk(msgID, chunkName) }); limitErr != nil {
+		return "", limitErr
+	}
+	return chunk, err
+}
+
+func (ls *LimitedStorage) GetNewMessages(clientID string) ([]*Message, error) {
+	var messages []*Message
+	var err error
+	if limitErr := ls.run(func() { messages, err = ls.inner.GetNewMessages(clientID) }); limitErr != nil {
+		return nil, limitErr
+	}
+	return messages, err
+}
+
+func (ls *LimitedStorage) MarkAsDelivered(msgID string, client ClientInfo) error {
+	var err error
+	if limitErr := ls.run(func() { err = ls.inner.MarkAsDelivered(msgID, client) }); limitErr != nil {
+		return limitErr
+	}
+	return err
+}
+
+func (ls *LimitedStorage) MarkChunksServed(msgID string, client ClientInfo, chunkNames ...string) (bool, error) {
+	var complete bool
+	var err error
+	if limitErr := ls.run(func() { complete, err = ls.inner.MarkChunksServed(msgID, client, chunkNames...) }); limitErr != nil {
+		return false, limitErr
+	}
+	return complete, err
+}
+
+func (ls *LimitedStorage) MarkAsConsumed(msgID, clientID string) error {
+	var err error
+	if limitErr := ls.run(func() { err = ls.inner.MarkAsConsumed(msgID, clientID) }); limitErr != nil {
+		return limitErr
+	}
+	return err
+}
+
+func (ls *LimitedStorage) GetGroupMessages(group string) ([]*Message, error) {
+	var messages []*Message
+	var err error
+	if limitErr := ls.run(func() { messages, err = ls.inner.GetGroupMessages(group) }); limitErr != nil {
+		return nil, limitErr
+	}
+	return messages, err
+}
+
+func (ls *LimitedStorage) SetGroupState(msgID, group, clientID string, state MessageState) error {
+	var err error
+	if limitErr := ls.run(func() { err = ls.inner.SetGroupState(msgID, group, clientID, state) }); limitErr != nil {
+		return limitErr
+	}
+	return err
+}
+
+func (ls *LimitedStorage) AddUsage(clientID, day string, bytes int64) (int64, error) {
+	var total int64
+	var err error
+	if limitErr := ls.run(func() { total, err = ls.inner.AddUsage(clientID, day, bytes) }); limitErr != nil {
+		return 0, limitErr
+	}
+	return total, err
+}
+
+func (ls *LimitedStorage) GetUsage(clientID, day string) (int64, error) {
+	var total int64
+	var err error
+	if limitErr := ls.run(func() { total, err = ls.inner.GetUsage(clientID, day) }); limitErr != nil {
+		return 0, limitErr
+	}
+	return total, err
+}
+
+func (ls *LimitedStorage) ListMessages() ([]*Message, error) {
+	var messages []*Message
+	var err error
+	if limitErr := ls.run(func() { messages, err = ls.inner.ListMessages() }); limitErr != nil {
+		return nil, limitErr
+	}
+	return messages, err
+}
+
+func (ls *LimitedStorage) SetState(msgID string, state MessageState) error {
+	var err error
+	if limitErr := ls.run(func() { err = ls.inner.SetState(msgID, state) }); limitErr != nil {
+		return limitErr
+	}
+	return err
+}
+
+// DeleteMessages and GetStats pass straight through: neither returns an
+// error to report overload with, and neither is on the query path
+func (ls *LimitedStorage) DeleteMessages(ids ...string) int {
+	return ls.inner.DeleteMessages(ids...)
+}
+
+func (ls *LimitedStorage) GetStats() StorageStats {
+	return ls.inner.GetStats()
+}
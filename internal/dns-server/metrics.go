@@ -0,0 +1,142 @@
+package dnsserver
+
+import (
+	"github.com/faanross/simulacra_txt/internal/metrics"
+	"time"
+)
+
+// ================================================================================
+// SERVER METRICS
+// The metrics both servers export at /metrics
+// ================================================================================
+
+// LESSON: Same Names Everywhere
+// dns-server and simula-server register the same set, so one dashboard
+// watches either. Labels stay coarse - query type, rcode, how an upload
+// arrived - and storage figures are read from GetStats at scrape time
+// instead of being counted along the way, so they can't drift from what
+// storage holds.
+
+// METRICS_NAMESPACE prefixes every metric name
+const METRICS_NAMESPACE = "simulacra_"
+
+// Ways an upload arrives
+const (
+	UPLOAD_VIA_HTTP  = "http"
+	UPLOAD_VIA_QNAME = "qname"
+)
+
+// ServerMetrics instruments a server. A nil *ServerMetrics records nothing.
+type ServerMetrics struct {
+	Registry *metrics.Registry
+
+	queries     *metrics.CounterVec   // type, rcode
+	latency     *metrics.HistogramVec // type
+	dropped     *metrics.CounterVec   // reason
+	chunks      *metrics.CounterVec   // result
+	uploads     *metrics.HistogramVec // via
+	reassembled *metrics.CounterVec   // result
+	messages    *metrics.GaugeVec     // state
+	chunkCount  *metrics.GaugeVec
+	dataBytes   *metrics.GaugeVec
+	evictions   *metrics.GaugeVec
+	evicted     *metrics.GaugeVec
+}
+
+// StatsSource is anything reporting storage figures: a Storage, or a
+// server's zones added up
+type StatsSource interface {
+	GetStats() StorageStats
+}
+
+// NewServerMetrics registers the server metrics, with storage figures
+// read from storage on every scrape
+func NewServerMetrics(storage StatsSource) *ServerMetrics {
+	reg := metrics.NewRegistry()
+	m := &ServerMetrics{
+		Registry: reg,
+		queries: reg.Counter(METRICS_NAMESPACE+"dns_queries_total",
+			"DNS queries answered, by question type and response code", "type", "rcode"),
+		latency: reg.Histogram(METRICS_NAMESPACE+"dns_query_duration_seconds",
+			"Time from receiving a DNS query to writing its answer", metrics.LATENCY_BUCKETS, "type"),
+		dropped: reg.Counter(METRICS_NAMESPACE+"dns_queries_dropped_total",
+			"DNS queries not answered normally: over a rate limit, shed under load, or a malformed data name", "reason"),
+		chunks: reg.Counter(METRICS_NAMESPACE+"chunk_lookups_total",
+			"Chunk and manifest queries, by whether the data was found", "result"),
+		uploads: reg.Histogram(METRICS_NAMESPACE+"upload_bytes",
+			"Size of uploaded messages as storage counts it (chunk names and data, manifest)", metrics.SIZE_BUCKETS, "via"),
+		reassembled: reg.Counter(METRICS_NAMESPACE+"reassembly_completions_total",
+			"Uploads assembled from parts sent in query names, by outcome", "result"),
+		messages: reg.Gauge(METRICS_NAMESPACE+"storage_messages",
+			"Stored messages by state", "state"),
+		chunkCount: reg.Gauge(METRICS_NAMESPACE+"storage_chunks",
+			"Chunks held by stored messages"),
+		dataBytes: reg.Gauge(METRICS_NAMESPACE+"storage_data_bytes",
+			"Message data held by storage"),
+		evictions: reg.Gauge(METRICS_NAMESPACE+"storage_evictions",
+			"Messages the retention policy evicted since storage was created"),
+		evicted: reg.Gauge(METRICS_NAMESPACE+"storage_evicted_bytes",
+			"Message data the retention policy evicted since storage was created"),
+	}
+
+	reg.OnCollect(func() {
+		stats := storage.GetStats()
+		m.messages.Set(float64(stats.NewMessages), StateNew.String())
+		m.messages.Set(float64(stats.Delivered), StateDelivered.String())
+		m.messages.Set(float64(stats.Consumed), StateConsumed.String())
+		m.chunkCount.Set(float64(stats.TotalChunks))
+		m.dataBytes.Set(float64(stats.MemoryUsage))
+		m.evictions.Set(float64(stats.Evictions))
+		m.evicted.Set(float64(stats.EvictedBytes))
+	})
+	return m
+}
+
+// Query records a DNS query answered after took
+func (m *ServerMetrics) Query(qtype, rcode string, took time.Duration) {
+	if m == nil {
+		return
+	}
+	m.queries.Inc(qtype, rcode)
+	m.latency.Observe(took.Seconds(), qtype)
+}
+
+// Dropped records a query turned away for reason ("rate", "load", "malformed")
+func (m *ServerMetrics) Dropped(reason string) {
+	if m == nil {
+		return
+	}
+	m.dropped.Inc(reason)
+}
+
+// ChunkLookup records whether a chunk or manifest query found its data
+func (m *ServerMetrics) ChunkLookup(hit bool) {
+	if m == nil {
+		return
+	}
+	if hit {
+		m.chunks.Inc("hit")
+	} else {
+		m.chunks.Inc("miss")
+	}
+}
+
+// Uploaded records an upload of bytes arriving via UPLOAD_VIA_*
+func (m *ServerMetrics) Uploaded(via string, bytes int64) {
+	if m == nil {
+		return
+	}
+	m.uploads.Observe(float64(bytes), via)
+}
+
+// Reassembled records an assembled upload, and whether it was stored
+func (m *ServerMetrics) Reassembled(stored bool) {
+	if m == nil {
+		return
+	}
+	if stored {
+		m.reassembled.Inc("complete")
+	} else {
+		m.reassembled.Inc("rejected")
+	}
+}
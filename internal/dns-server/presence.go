@@ -0,0 +1,222 @@
+package dnsserver
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ================================================================================
+// CLIENT PRESENCE
+// When each receiver was last heard from, and whether it still beacons
+// ================================================================================
+
+// LESSON: Is Anyone There?
+// A receiver that polls every few seconds shows it is alive with every
+// discovery query. One that polls hourly, or is busy fetching a large
+// message, or only runs -msg now and then, doesn't - and during a long
+// simulation the operator can't tell a quiet receiver from a dead one.
+//
+// Beacons fill the gap: a tiny TXT query every few minutes, with jitter so
+// it doesn't tick like a clock, naming the client and how often it
+// promised to beacon:
+//
+//   beacon.<interval seconds>.<nonce>.<client>.<domain>
+//
+// The nonce keeps resolvers from answering a beacon out of their cache.
+// The server remembers, per client ID, when it was last heard from - by
+// beacon, discovery or acknowledgement - and judges it against its own
+// promise:
+//
+//   alive   heard from within twice the beacon interval
+//   late    within four times - a few beacons lost, or a slow resolver
+//   silent  longer than that
+//   unknown never beaconed, so there is no promise to judge by
+//
+// Presence lives in memory only: after a restart every receiver is unknown
+// until its next beacon. Client IDs are whatever receivers claim, so the
+// tracker keeps at most MAX_TRACKED_CLIENTS and forgets the longest silent
+// first.
+
+const (
+	// BEACON_LABEL marks a receiver's heartbeat query
+	BEACON_LABEL = "beacon"
+
+	// MAX_TRACKED_CLIENTS caps the clients whose presence is remembered
+	MAX_TRACKED_CLIENTS = 1024
+
+	// A client is late after PRESENCE_LATE_FACTOR beacon intervals without
+	// a word, silent after PRESENCE_SILENT_FACTOR
+	PRESENCE_LATE_FACTOR   = 2
+	PRESENCE_SILENT_FACTOR = 4
+)
+
+// Presence statuses
+const (
+	PRESENCE_ALIVE   = "alive"
+	PRESENCE_LATE    = "late"
+	PRESENCE_SILENT  = "silent"
+	PRESENCE_UNKNOWN = "unknown"
+)
+
+// BeaconName returns the query name of a heartbeat from a client promising
+// one per interval; nonce makes each beacon's name unique
+func BeaconName(clientID, nonce string, interval time.Duration, domain string) string {
+	seconds := int64(interval.Round(time.Second) / time.Second)
+	return strings.Join([]string{BEACON_LABEL, strconv.FormatInt(seconds, 10), nonce, clientID, strings.TrimSuffix(domain, ".")}, ".")
+}
+
+// ParseBeaconName extracts the client ID and beacon interval from a
+// heartbeat query name
+func ParseBeaconName(qname, domain string) (clientID string, interval time.Duration, ok bool) {
+	rest, found := strings.CutSuffix(strings.TrimSuffix(qname, "."), "."+strings.TrimSuffix(domain, "."))
+	if !found {
+		return "", 0, false
+	}
+	labels := strings.Split(rest, ".")
+	if len(labels) != 4 || labels[0] != BEACON_LABEL || labels[2] == "" || !ValidClientID(labels[3]) {
+		return "", 0, false
+	}
+	seconds, err := strconv.ParseInt(labels[1], 10, 64)
+	if err != nil || seconds <= 0 {
+		return "", 0, false
+	}
+	return labels[3], time.Duration(seconds) * time.Second, true
+}
+
+// ParseBeaconInterval parses a beacon interval, e.g. "5m" ("" or "0" =
+// no beacons)
+func ParseBeaconInterval(spec string) (time.Duration, error) {
+	spec = strings.TrimSpace(spec)
+	if spec == "" {
+		return 0, nil
+	}
+	interval, err := parseGCDuration(spec)
+	if err != nil {
+		return 0, fmt.Errorf("invalid beacon interval %q: %w", spec, err)
+	}
+	if interval < 0 {
+		return 0, fmt.Errorf("beacon interval %q is negative", spec)
+	}
+	if interval > 0 && interval < time.Second {
+		return 0, fmt.Errorf("beacon interval %q is shorter than a second", spec)
+	}
+	return interval, nil
+}
+
+// ClientPresence is what the server knows of one client's liveness
+type ClientPresence struct {
+	ClientID        string     `json:"client"`
+	Status          string     `json:"status"` // PRESENCE_*, as of the report
+	Via             string     `json:"via,omitempty"`
+	Addr            string     `json:"addr,omitempty"` // Source of the last query: the receiver or its resolver
+	FirstSeen       time.Time  `json:"first_seen"`
+	LastSeen        time.Time  `json:"last_seen"`
+	LastBeacon      *time.Time `json:"last_beacon,omitempty"`
+	SinceSeconds    int64      `json:"since_seconds"`              // Since LastSeen, as of the report
+	IntervalSeconds int64      `json:"interval_seconds,omitempty"` // Beacon interval the client announced
+	Beacons         int64      `json:"beacons"`
+	Discoveries     int64      `json:"discoveries"` // Discovery and acknowledgement queries
+}
+
+// status judges a client's presence at now
+func (p *ClientPresence) status(now time.Time) string {
+	if p.IntervalSeconds <= 0 {
+		return PRESENCE_UNKNOWN
+	}
+	since := now.Sub(p.LastSeen)
+	interval := time.Duration(p.IntervalSeconds) * time.Second
+	switch {
+	case since <= PRESENCE_LATE_FACTOR*interval:
+		return PRESENCE_ALIVE
+	case since <= PRESENCE_SILENT_FACTOR*interval:
+		return PRESENCE_LATE
+	}
+	return PRESENCE_SILENT
+}
+
+// PresenceTracker remembers when each client was last heard from
+type PresenceTracker struct {
+	clients map[string]*ClientPresence
+	mu      sync.Mutex
+}
+
+// NewPresenceTracker creates an empty tracker
+func NewPresenceTracker() *PresenceTracker {
+	return &PresenceTracker{clients: make(map[string]*ClientPresence)}
+}
+
+// Beacon records a heartbeat from a client promising one per interval
+func (pt *PresenceTracker) Beacon(client ClientInfo, interval time.Duration, at time.Time) {
+	pt.mu.Lock()
+	defer pt.mu.Unlock()
+
+	p := pt.touch(client, at)
+	p.Beacons++
+	p.LastBeacon = &at
+	p.IntervalSeconds = int64(interval / time.Second)
+}
+
+// Seen records any other query that identified a client: discovery or
+// acknowledgement
+func (pt *PresenceTracker) Seen(client ClientInfo, at time.Time) {
+	pt.mu.Lock()
+	defer pt.mu.Unlock()
+
+	pt.touch(client, at).Discoveries++
+}
+
+// touch returns a client's entry, updated to at, making room for new clients
+func (pt *PresenceTracker) touch(client ClientInfo, at time.Time) *ClientPresence {
+	id := strings.ToLower(client.ID)
+	p, ok := pt.clients[id]
+	if !ok {
+		if len(pt.clients) >= MAX_TRACKED_CLIENTS {
+			pt.forgetOldest()
+		}
+		p = &ClientPresence{ClientID: id, FirstSeen: at}
+		pt.clients[id] = p
+	}
+	if at.After(p.LastSeen) {
+		p.LastSeen = at
+	}
+	p.Via, p.Addr = client.Via, client.Addr
+	return p
+}
+
+// forgetOldest drops the client heard from longest ago
+func (pt *PresenceTracker) forgetOldest() {
+	var oldest *ClientPresence
+	for _, p := range pt.clients {
+		if oldest == nil || p.LastSeen.Before(oldest.LastSeen) {
+			oldest = p
+		}
+	}
+	if oldest != nil {
+		delete(pt.clients, oldest.ClientID)
+	}
+}
+
+// Report returns every tracked client as of now, most recently heard from first
+func (pt *PresenceTracker) Report(now time.Time) []ClientPresence {
+	pt.mu.Lock()
+	report := make([]ClientPresence, 0, len(pt.clients))
+	for _, p := range pt.clients {
+		entry := *p
+		entry.Status = p.status(now)
+		entry.SinceSeconds = int64(now.Sub(p.LastSeen) / time.Second)
+		report = append(report, entry)
+	}
+	pt.mu.Unlock()
+
+	sort.Slice(report, func(a, b int) bool {
+		if !report[a].LastSeen.Equal(report[b].LastSeen) {
+			return report[a].LastSeen.After(report[b].LastSeen)
+		}
+		return report[a].ClientID < report[b].ClientID
+	})
+	return report
+}
@@ -0,0 +1,101 @@
+package dnsserver
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ================================================================================
+// QUEUE ORDER
+// Priorities, oldest-first delivery and bounded discovery batches
+// ================================================================================
+
+// LESSON: Who Goes First
+// Storage keeps messages in maps and buckets, so "the new messages" came
+// back in whatever order a map iterated - a receiver polling a busy queue
+// could see the message uploaded an hour ago after the one from a second
+// ago, or never, if it only handled the first few. Discovery now has a
+// defined order:
+//
+//   1. Priority, highest first (urgent > high > normal > low)
+//   2. Age, oldest first (FIFO within a priority)
+//   3. Message ID, so equal timestamps still sort the same way every time
+//
+// With a batch size a discovery call hands out at most that many messages
+// and marks only those delivered; the rest stay new for the next call, in
+// the same order. A flood of uploads then can't bury an urgent message,
+// and a receiver works through the backlog oldest first.
+
+// Priority levels, sent by the uploader
+const (
+	PRIORITY_LOW    = -1
+	PRIORITY_NORMAL = 0
+	PRIORITY_HIGH   = 1
+	PRIORITY_URGENT = 2
+)
+
+// priorityNames names the levels, lowest first
+var priorityNames = []string{"low", "normal", "high", "urgent"}
+
+// ParsePriority parses a level name or its number ("" = normal)
+func ParsePriority(spec string) (int, error) {
+	spec = strings.ToLower(strings.TrimSpace(spec))
+	if spec == "" {
+		return PRIORITY_NORMAL, nil
+	}
+	for i, name := range priorityNames {
+		if spec == name {
+			return PRIORITY_LOW + i, nil
+		}
+	}
+	if level, err := strconv.Atoi(spec); err == nil && level >= PRIORITY_LOW && level <= PRIORITY_URGENT {
+		return level, nil
+	}
+	return 0, fmt.Errorf("unknown priority %q (%s, or %d to %d)", spec, strings.Join(priorityNames, ", "), PRIORITY_LOW, PRIORITY_URGENT)
+}
+
+// PriorityName names a priority level
+func PriorityName(level int) string {
+	if level < PRIORITY_LOW || level > PRIORITY_URGENT {
+		return strconv.Itoa(level)
+	}
+	return priorityNames[level-PRIORITY_LOW]
+}
+
+// SortQueue orders messages for delivery: highest priority, then oldest,
+// then by ID
+func SortQueue(messages []*Message) {
+	sort.SliceStable(messages, func(a, b int) bool {
+		ma, mb := messages[a], messages[b]
+		if ma.Priority != mb.Priority {
+			return ma.Priority > mb.Priority
+		}
+		if !ma.CreatedAt.Equal(mb.CreatedAt) {
+			return ma.CreatedAt.Before(mb.CreatedAt)
+		}
+		return ma.ID < mb.ID
+	})
+}
+
+// SetMaxBatch caps the messages one ConsumeMessages call hands out
+// (0 = all of them)
+func (qm *QueueManager) SetMaxBatch(n int) {
+	qm.mu.Lock()
+	defer qm.mu.Unlock()
+	qm.maxBatch = n
+}
+
+// batch returns the messages one discovery call hands out
+func (qm *QueueManager) batch(messages []*Message) []*Message {
+	qm.mu.Lock()
+	limit := qm.maxBatch
+	qm.mu.Unlock()
+
+	SortQueue(messages)
+	if limit > 0 && len(messages) > limit {
+		return messages[:limit]
+	}
+	return messages
+}
@@ -0,0 +1,213 @@
+package dnsserver
+
+import (
+	"sort"
+	"time"
+)
+
+// ================================================================================
+// TRANSFER PROGRESS
+// Tracks which chunks of a message were served, and to whom
+// ================================================================================
+
+// LESSON: Delivered Means Every Chunk
+// Discovery only hands a receiver message IDs; the message itself travels
+// one chunk query at a time, and a receiver can stop halfway. So a message
+// stays new until each of its chunks has been served at least once - the
+// discovery query alone no longer makes it "delivered".
+//
+// Every chunk answer is recorded in the consumer record of the client that
+// asked (see clients.go), so the coverage report shows how far each
+// receiver got. Coverage across clients decides the state, though: behind
+// a shared resolver, chunk queries often can't be told apart, and one
+// cached answer serves every receiver behind it.
+
+// ChunkCoverage reports how much of a message has been served
+type ChunkCoverage struct {
+	MessageID   string           `json:"message_id"`
+	State       string           `json:"state"`
+	TotalChunks int              `json:"total_chunks"`
+	Served      int              `json:"served"`            // Distinct chunks served to anyone
+	Percent     float64          `json:"percent"`           // Served / TotalChunks
+	Missing     []string         `json:"missing,omitempty"` // Chunks never served (when the chunks are loaded)
+	Clients     []ClientCoverage `json:"clients,omitempty"`
+}
+
+// ClientCoverage reports how much of a message one client fetched
+type ClientCoverage struct {
+	ClientID  string    `json:"client_id"`
+	Addr      string    `json:"addr,omitempty"`
+	Via       string    `json:"via,omitempty"`
+	Fetched   int       `json:"fetched"`
+	Percent   float64   `json:"percent"`
+	FirstSeen time.Time `json:"first_seen"`
+}
+
+// Coverage builds the coverage report of a message
+func Coverage(msg *Message) ChunkCoverage {
+	total := expectedChunks(msg)
+	served := servedChunks(msg)
+
+	coverage := ChunkCoverage{
+		MessageID:   msg.ID,
+		State:       msg.State.String(),
+		TotalChunks: total,
+		Served:      len(served),
+		Percent:     percentOf(len(served), total),
+	}
+
+	// Listed messages come without chunks; then nothing is known to be missing
+	if len(msg.Chunks) == total {
+		for name := range msg.Chunks {
+			if !served[name] {
+				coverage.Missing = append(coverage.Missing, name)
+			}
+		}
+		sort.Strings(coverage.Missing)
+	}
+
+	byClient := make(map[string]int)
+	fetched := make(map[string]map[string]bool)
+	for _, record := range msg.Consumers {
+		id := record.client()
+		i, seen := byClient[id]
+		if !seen {
+			i = len(coverage.Clients)
+			byClient[id] = i
+			fetched[id] = make(map[string]bool)
+			coverage.Clients = append(coverage.Clients, ClientCoverage{
+				ClientID:  id,
+				Addr:      record.ClientIP,
+				Via:       record.Via,
+				FirstSeen: record.FetchedAt,
+			})
+		}
+		for _, name := range record.ChunksFetched {
+			fetched[id][name] = true
+		}
+		coverage.Clients[i].Fetched = len(fetched[id])
+		coverage.Clients[i].Percent = percentOf(len(fetched[id]), total)
+	}
+
+	return coverage
+}
+
+// RecordFetch records chunks of msg served to a client, burning msg once
+// every chunk was served if it was uploaded with burn; it reports whether
+// msg was burned. Names that aren't chunks of msg (the manifest) are ignored.
+func (qm *QueueManager) RecordFetch(msg *Message, client ClientInfo, chunkNames ...string) (bool, error) {
+	var chunks []string
+	for _, name := range chunkNames {
+		if _, exists := msg.Chunks[name]; exists {
+			chunks = append(chunks, name)
+		}
+	}
+	if len(chunks) == 0 {
+		return false, nil
+	}
+
+	complete, err := qm.storage.MarkChunksServed(msg.ID, client, chunks...)
+	if err != nil || !complete || !msg.Burn {
+		return false, err
+	}
+	return qm.burn(msg.ID), nil
+}
+
+// Progress returns the coverage report of a message
+func (qm *QueueManager) Progress(msgID string) (ChunkCoverage, error) {
+	msg, err := qm.storage.GetMessage(msgID)
+	if err != nil {
+		return ChunkCoverage{}, err
+	}
+	return Coverage(msg), nil
+}
+
+// client returns the ID of the client a record belongs to; records written
+// before clients had IDs kept the ID in ClientIP
+func (r ConsumerRecord) client() string {
+	if r.ClientID == "" {
+		return r.ClientIP
+	}
+	return r.ClientID
+}
+
+// recordServed adds chunk names to the client's latest consumer record,
+// starting one if the client never discovered the message, and returns the
+// names that are new for that client
+func (m *Message) recordServed(client ClientInfo, chunkNames []string, at time.Time) []string {
+	added := m.unserved(client, chunkNames)
+	if len(added) == 0 {
+		return nil
+	}
+
+	record := m.consumer(client.ID)
+	if record < 0 {
+		m.Consumers = append(m.Consumers, client.record(at))
+		record = len(m.Consumers) - 1
+	}
+	// A new list: readers may hold the old one (see MemoryStorage.editable)
+	fetched := m.Consumers[record].ChunksFetched
+	m.Consumers[record].ChunksFetched = append(fetched[:len(fetched):len(fetched)], added...)
+	return added
+}
+
+// unserved returns the chunk names not yet recorded for a client
+func (m *Message) unserved(client ClientInfo, chunkNames []string) []string {
+	known := make(map[string]bool)
+	if record := m.consumer(client.ID); record >= 0 {
+		for _, name := range m.Consumers[record].ChunksFetched {
+			known[name] = true
+		}
+	}
+
+	var names []string
+	for _, name := range chunkNames {
+		if !known[name] {
+			known[name] = true
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// consumer returns the index of a client's latest consumer record, or -1
+func (m *Message) consumer(clientID string) int {
+	for i := len(m.Consumers) - 1; i >= 0; i-- {
+		if m.Consumers[i].client() == clientID {
+			return i
+		}
+	}
+	return -1
+}
+
+// fullyServed reports whether every chunk of a message was served to someone
+func (m *Message) fullyServed() bool {
+	return len(servedChunks(m)) >= expectedChunks(m)
+}
+
+// servedChunks collects the chunks served to any client
+func servedChunks(msg *Message) map[string]bool {
+	served := make(map[string]bool)
+	for _, record := range msg.Consumers {
+		for _, name := range record.ChunksFetched {
+			served[name] = true
+		}
+	}
+	return served
+}
+
+// expectedChunks returns how many chunks a message has
+func expectedChunks(msg *Message) int {
+	if msg.TotalChunks > 0 {
+		return msg.TotalChunks
+	}
+	return msg.chunkCount()
+}
+
+// percentOf returns part as a percentage of total, rounded to one decimal
+func percentOf(part, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(part*1000/total) / 10
+}
@@ -0,0 +1,292 @@
+package dnsserver
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ================================================================================
+// DAILY QUOTAS
+// Caps the bytes of message data served to each client per day
+// ================================================================================
+
+// LESSON: Counting Bytes, Not Queries
+// The rate limiter stops floods, but a patient scraper staying under it can
+// still pull the whole zone over a day. A quota counts what actually left
+// the server - the chunk data each client was answered with - per UTC day,
+// and refuses a client's chunk queries once it has had its share.
+//
+// Usage is kept in Storage so a restart doesn't hand everyone a fresh
+// quota. Writing it on every answer would double the storage writes of the
+// query path, though, so the tracker counts in memory and writes what it
+// counted every QUOTA_FLUSH_INTERVAL: a crash forgets at most that much.
+// "Client" is whatever identified the query (see clients.go); unidentified
+// clients all share client-default's quota, so -client-id ip gives every
+// address a quota of its own.
+
+const (
+	// QUOTA_FLUSH_INTERVAL is how often counted usage is written to storage
+	QUOTA_FLUSH_INTERVAL = 10 * time.Second
+
+	// USAGE_DAYS_KEPT is how many days of usage storage keeps (today included)
+	USAGE_DAYS_KEPT = 2
+
+	// USAGE_DAY_FORMAT names a day of usage (UTC)
+	USAGE_DAY_FORMAT = "2006-01-02"
+)
+
+// UsageDay returns the day usage at t counts towards
+func UsageDay(t time.Time) string {
+	return t.UTC().Format(USAGE_DAY_FORMAT)
+}
+
+// usageCutoff returns the oldest day still kept when day is current
+func usageCutoff(day string) string {
+	t, err := time.Parse(USAGE_DAY_FORMAT, day)
+	if err != nil {
+		return day
+	}
+	return UsageDay(t.AddDate(0, 0, 1-USAGE_DAYS_KEPT))
+}
+
+// usageKey is one client's usage on one day
+type usageKey struct {
+	day    string
+	client string
+}
+
+// QuotaTracker enforces a daily byte quota per client
+type QuotaTracker struct {
+	storage Storage
+	limit   int64
+
+	day      string
+	used     map[string]int64   // Today's bytes per client (stored + counted)
+	loaded   map[string]bool    // Clients whose stored usage is included in used
+	exceeded map[string]bool    // Clients that reached the quota today
+	pending  map[usageKey]int64 // Counted, not yet written
+	mu       sync.Mutex
+
+	stop chan struct{} // Closed to end the flush loop
+	done chan struct{} // Closed when the flush loop has ended
+}
+
+// NewQuotaTracker allows each client dailyBytes of message data a day; a
+// limit of 0 returns nil, which allows everything
+func NewQuotaTracker(storage Storage, dailyBytes int64) *QuotaTracker {
+	if dailyBytes <= 0 {
+		return nil
+	}
+	return &QuotaTracker{
+		storage:  storage,
+		limit:    dailyBytes,
+		day:      UsageDay(time.Now()),
+		used:     make(map[string]int64),
+		loaded:   make(map[string]bool),
+		exceeded: make(map[string]bool),
+		pending:  make(map[usageKey]int64),
+	}
+}
+
+// Allow reports whether a client is still within today's quota
+func (qt *QuotaTracker) Allow(clientID string) bool {
+	if qt == nil {
+		return true
+	}
+	return qt.Used(clientID) < qt.limit
+}
+
+// Charge counts bytes served to a client, reporting whether this charge
+// took the client over its quota
+func (qt *QuotaTracker) Charge(clientID string, bytes int64) bool {
+	if qt == nil || bytes <= 0 {
+		return false
+	}
+	qt.Used(clientID) // Stored usage first, so it isn't counted on top of what's flushed
+
+	qt.mu.Lock()
+	defer qt.mu.Unlock()
+	qt.rollover()
+	qt.used[clientID] += bytes
+	qt.pending[usageKey{day: qt.day, client: clientID}] += bytes
+	if qt.used[clientID] < qt.limit || qt.exceeded[clientID] {
+		return false
+	}
+	qt.exceeded[clientID] = true
+	return true
+}
+
+// Used returns the bytes a client was served today
+func (qt *QuotaTracker) Used(clientID string) int64 {
+	if qt == nil {
+		return 0
+	}
+
+	qt.mu.Lock()
+	qt.rollover()
+	day := qt.day
+	if qt.loaded[clientID] {
+		defer qt.mu.Unlock()
+		return qt.used[clientID]
+	}
+	qt.mu.Unlock()
+
+	// Storage is read without the lock, so a slow backend holds up only
+	// this client's first query of the day
+	stored, err := qt.storage.GetUsage(clientID, day)
+
+	qt.mu.Lock()
+	defer qt.mu.Unlock()
+	if err != nil {
+		quotaLog.Warn("⚠️  Failed to read quota usage", "client", clientID, "err", err)
+		return qt.used[clientID]
+	}
+	if qt.day == day && !qt.loaded[clientID] {
+		qt.used[clientID] += stored
+		qt.loaded[clientID] = true
+	}
+	return qt.used[clientID]
+}
+
+// rollover starts a new day's counts when the day changed; pending usage
+// of the old day is still written to it. Callers hold the lock.
+func (qt *QuotaTracker) rollover() {
+	if today := UsageDay(time.Now()); today != qt.day {
+		qt.day = today
+		qt.used = make(map[string]int64)
+		qt.loaded = make(map[string]bool)
+		qt.exceeded = make(map[string]bool)
+	}
+}
+
+// Flush writes counted usage to storage; usage that fails to write is
+// kept for the next flush
+func (qt *QuotaTracker) Flush() error {
+	if qt == nil {
+		return nil
+	}
+
+	qt.mu.Lock()
+	pending := qt.pending
+	qt.pending = make(map[usageKey]int64)
+	qt.mu.Unlock()
+
+	var firstErr error
+	for key, bytes := range pending {
+		if _, err := qt.storage.AddUsage(key.client, key.day, bytes); err != nil {
+			if firstErr == nil {
+				firstErr = fmt.Errorf("failed to store usage of %s: %w", key.client, err)
+			}
+			qt.mu.Lock()
+			qt.pending[key] += bytes
+			qt.mu.Unlock()
+		}
+	}
+	return firstErr
+}
+
+// Start flushes counted usage every interval in the background until Stop
+func (qt *QuotaTracker) Start(interval time.Duration) {
+	if qt == nil || interval <= 0 || qt.stop != nil {
+		return
+	}
+	qt.stop = make(chan struct{})
+	qt.done = make(chan struct{})
+
+	go func() {
+		defer close(qt.done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				if err := qt.Flush(); err != nil {
+					quotaLog.Warn("⚠️  Quota flush failed (retried next time)", "err", err)
+				}
+			case <-qt.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop ends background flushing and writes what is still counted
+func (qt *QuotaTracker) Stop() error {
+	if qt == nil {
+		return nil
+	}
+	if qt.stop != nil {
+		close(qt.stop)
+		<-qt.done
+		qt.stop = nil
+	}
+	return qt.Flush()
+}
+
+// QuotaReport lists today's usage of the clients seen since the server started
+type QuotaReport struct {
+	Day        string        `json:"day"`
+	DailyBytes int64         `json:"daily_bytes"`
+	Clients    []ClientUsage `json:"clients"`
+}
+
+// ClientUsage is one client's usage today
+type ClientUsage struct {
+	ClientID string `json:"client_id"`
+	Bytes    int64  `json:"bytes"`
+	Exceeded bool   `json:"exceeded"`
+}
+
+// Report returns today's usage, heaviest clients first
+func (qt *QuotaTracker) Report() QuotaReport {
+	if qt == nil {
+		return QuotaReport{}
+	}
+
+	qt.mu.Lock()
+	defer qt.mu.Unlock()
+	qt.rollover()
+
+	report := QuotaReport{Day: qt.day, DailyBytes: qt.limit, Clients: make([]ClientUsage, 0, len(qt.used))}
+	for client, bytes := range qt.used {
+		report.Clients = append(report.Clients, ClientUsage{ClientID: client, Bytes: bytes, Exceeded: bytes >= qt.limit})
+	}
+	sort.Slice(report.Clients, func(a, b int) bool {
+		if report.Clients[a].Bytes != report.Clients[b].Bytes {
+			return report.Clients[a].Bytes > report.Clients[b].Bytes
+		}
+		return report.Clients[a].ClientID < report.Clients[b].ClientID
+	})
+	return report
+}
+
+// String describes the quota for display
+func (qt *QuotaTracker) String() string {
+	if qt == nil {
+		return "off"
+	}
+	return fmt.Sprintf("%d bytes per client per day (UTC)", qt.limit)
+}
+
+// addUsage adds bytes to a client's usage on a day in a day -> client ->
+// bytes map, dropping days older than USAGE_DAYS_KEPT, and returns the
+// client's total for that day
+func addUsage(usage map[string]map[string]int64, clientID, day string, bytes int64) int64 {
+	cutoff := usageCutoff(day)
+	for old := range usage {
+		if old < cutoff {
+			delete(usage, old)
+		}
+	}
+
+	clients, exists := usage[day]
+	if !exists {
+		clients = make(map[string]int64)
+		usage[day] = clients
+	}
+	clients[clientID] += bytes
+	return clients[clientID]
+}
@@ -0,0 +1,155 @@
+package dnsserver
+
+import (
+	"fmt"
+	"math"
+	"net/netip"
+	"sync"
+	"time"
+)
+
+// ================================================================================
+// RATE LIMITING
+// A token bucket per source address, so one scanner can't take every answer
+// ================================================================================
+
+// LESSON: Token Buckets
+// Each source gets a bucket holding up to burst tokens, refilled at qps
+// tokens a second; a query takes one token, and a query finding the bucket
+// empty is limited. A receiver pulling a message in bursts stays within
+// the burst, while a scanner walking the zone runs dry after a second and
+// is then held to qps for as long as it keeps going.
+//
+// A bucket left alone long enough to fill up again is the same as no bucket
+// at all, so idle ones are pruned. Spoofed floods can still invent sources
+// faster than pruning frees them: beyond MAX_RATE_BUCKETS, new sources share
+// one overflow bucket instead of growing the map. IPv6 sources are keyed by
+// their /64 - a single host is usually handed a whole one, and rotating
+// through it would otherwise buy a fresh bucket per query.
+
+const (
+	// MAX_RATE_BUCKETS bounds how many sources get buckets of their own
+	MAX_RATE_BUCKETS = 100000
+
+	// RATE_PRUNE_INTERVAL is how often full (idle) buckets are dropped
+	RATE_PRUNE_INTERVAL = time.Minute
+
+	// RATE_OVERFLOW_KEY is the bucket shared by sources beyond MAX_RATE_BUCKETS
+	RATE_OVERFLOW_KEY = "overflow"
+)
+
+// tokenBucket is one source's allowance
+type tokenBucket struct {
+	tokens float64
+	last   time.Time // When tokens was last refilled
+}
+
+// RateLimiter keeps a token bucket per source
+type RateLimiter struct {
+	qps     float64
+	burst   float64
+	buckets map[string]*tokenBucket
+	pruned  time.Time
+	mu      sync.Mutex
+}
+
+// NewRateLimiter allows each source qps queries a second, with bursts of
+// up to burst queries (0 = twice qps); qps of 0 returns nil, which allows
+// everything
+func NewRateLimiter(qps float64, burst int) *RateLimiter {
+	if qps <= 0 {
+		return nil
+	}
+	if burst <= 0 {
+		burst = int(math.Ceil(qps * 2))
+	}
+	return &RateLimiter{
+		qps:     qps,
+		burst:   float64(burst),
+		buckets: make(map[string]*tokenBucket),
+		pruned:  time.Now(),
+	}
+}
+
+// Allow takes a token from key's bucket, reporting whether there was one
+func (rl *RateLimiter) Allow(key string) bool {
+	if rl == nil {
+		return true
+	}
+	now := time.Now()
+
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	if now.Sub(rl.pruned) >= RATE_PRUNE_INTERVAL {
+		rl.prune(now)
+	}
+
+	bucket, exists := rl.buckets[key]
+	if !exists {
+		if len(rl.buckets) >= MAX_RATE_BUCKETS {
+			rl.prune(now)
+		}
+		if len(rl.buckets) >= MAX_RATE_BUCKETS {
+			key = RATE_OVERFLOW_KEY
+			bucket = rl.buckets[key]
+		}
+	}
+	if bucket == nil {
+		bucket = &tokenBucket{tokens: rl.burst, last: now}
+		rl.buckets[key] = bucket
+	}
+
+	bucket.tokens = math.Min(rl.burst, bucket.tokens+now.Sub(bucket.last).Seconds()*rl.qps)
+	bucket.last = now
+	if bucket.tokens < 1 {
+		return false
+	}
+	bucket.tokens--
+	return true
+}
+
+// prune drops buckets that have refilled completely; callers hold the lock
+func (rl *RateLimiter) prune(now time.Time) {
+	refill := time.Duration(rl.burst / rl.qps * float64(time.Second))
+	for key, bucket := range rl.buckets {
+		if now.Sub(bucket.last) >= refill {
+			delete(rl.buckets, key)
+		}
+	}
+	rl.pruned = now
+}
+
+// Sources returns how many sources currently have a bucket
+func (rl *RateLimiter) Sources() int {
+	if rl == nil {
+		return 0
+	}
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	return len(rl.buckets)
+}
+
+// String describes the limit for display
+func (rl *RateLimiter) String() string {
+	if rl == nil {
+		return "off"
+	}
+	return fmt.Sprintf("%g queries/s per source, bursts of %g", rl.qps, rl.burst)
+}
+
+// RateKey returns the bucket key of a source IP: the address itself, or
+// its /64 for IPv6
+func RateKey(ip string) string {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return ip
+	}
+	addr = addr.Unmap()
+	if addr.Is6() {
+		if prefix, err := addr.Prefix(64); err == nil {
+			return prefix.String()
+		}
+	}
+	return addr.String()
+}
@@ -0,0 +1,61 @@
+package dnsserver
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ================================================================================
+// MESSAGE ADDRESSING
+// Messages for one receiver, on a server several receivers share
+// ================================================================================
+
+// LESSON: A Mailbox Per Client ID
+// Tags let a receiver pick what it is interested in; they don't stop any
+// other receiver from taking the same message first. A recipient does:
+// the sender addresses a message to a client ID, and discovery - the DNS
+// consume query and /messages alike - only hands it to that client:
+//
+//   stego-send -to receiver2 ...        addressed: receiver2 discovers it
+//   stego-send ...                      unaddressed: anybody does, as before
+//
+// The consume query already names the asking client
+// (<tags...>.consume.<client>.<domain>), so addressing costs receivers
+// nothing. Resolvers may change the case of query names (0x20), so
+// recipients are stored lowercased and compared without regard to case.
+//
+// The recipient travels in the upload options and in the manifest, where
+// a signature covers it; when both name one, they must agree. Addressing
+// decides who discovers a message, not who can read it: anyone who knows
+// the message ID can still fetch its chunks, which is what encryption is
+// for.
+
+// NormalizeRecipient validates a recipient client ID and lowercases it
+// ("" = unaddressed)
+func NormalizeRecipient(clientID string) (string, error) {
+	clientID = strings.ToLower(strings.TrimSpace(clientID))
+	if clientID == "" {
+		return "", nil
+	}
+	if !ValidClientID(clientID) {
+		return "", fmt.Errorf("recipient %q is not a client ID (printable, no ',' or '.', at most %d characters)", clientID, MAX_TAG_LENGTH)
+	}
+	return clientID, nil
+}
+
+// AddressedTo reports whether a client may discover the message: it is
+// the message's recipient, or the message has none
+func (m *Message) AddressedTo(clientID string) bool {
+	return m.Recipient == "" || strings.EqualFold(m.Recipient, clientID)
+}
+
+// FilterByRecipient keeps the messages a client may discover
+func FilterByRecipient(messages []*Message, clientID string) []*Message {
+	var addressed []*Message
+	for _, msg := range messages {
+		if msg.AddressedTo(clientID) {
+			addressed = append(addressed, msg)
+		}
+	}
+	return addressed
+}
@@ -0,0 +1,467 @@
+package dnsserver
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	bolt "go.etcd.io/bbolt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ================================================================================
+// HOT STATE REPLICATION
+// A secondary server mirrors its primary's message store over the HTTP API
+// ================================================================================
+
+// LESSON: A Dead Drop With a Spare
+// One server is one failure away from losing every message it holds. A
+// secondary started with -replicate-from tails the primary's store:
+//
+//   1. Every interval it lists the primary's messages - metadata only,
+//      no chunks (GET /admin/replication)
+//   2. Messages it doesn't hold yet are fetched whole, chunks included
+//      (GET /admin/replication/{id}), and stored exactly as the primary
+//      holds them: state, timestamps, consumers, consumer groups
+//   3. Messages whose metadata changed (a delivery, an acknowledgement, a
+//      requeue) take the primary's metadata; chunks never change
+//   4. Messages it copied that the primary no longer lists (consumed and
+//      collected, burned, deleted) are removed
+//
+// The secondary answers DNS for the same zone all along, so with both
+// servers in the zone's NS set resolvers fail over by themselves, and a
+// receiver pointed at the secondary finds the same queue. The primary's
+// state wins: what receivers do on the secondary is overwritten by the
+// next sync while the primary is up. Messages uploaded to the secondary
+// itself are not sent back, and are never removed by a sync - only those
+// copied from this primary (their Origin) are.
+//
+// The API is polled rather than streamed: a poll survives restarts of
+// either side with no log to replay, and costs one small listing per
+// interval when nothing changed. The interval bounds what a failure can
+// lose.
+
+const (
+	// DEFAULT_REPLICATION_INTERVAL is how often a secondary polls its primary
+	DEFAULT_REPLICATION_INTERVAL = 5 * time.Second
+
+	// MIN_REPLICATION_INTERVAL keeps signed polls at least a timestamp apart
+	MIN_REPLICATION_INTERVAL = 1 * time.Second
+)
+
+// ReplicaStore is a storage backend a secondary can mirror a primary into
+type ReplicaStore interface {
+	// PutReplica stores a message exactly as the primary holds it. A
+	// message already stored only takes the metadata: chunks never change.
+	PutReplica(msg *Message) error
+}
+
+// ReplicationListing is the primary's answer to GET /admin/replication
+type ReplicationListing struct {
+	Zone     string     `json:"zone"`
+	Messages []*Message `json:"messages"` // Without chunks
+}
+
+// ReplicationConfig says where a secondary copies from
+type ReplicationConfig struct {
+	Primary  string        // Base URL of the primary's HTTP API
+	Zone     string        // Zone to copy, sent as ?zone=
+	KeyID    string        // Admin API key signing the requests ("" = none)
+	Secret   string        // Its secret, never sent
+	Client   *http.Client  // nil = http.DefaultClient
+	Interval time.Duration // How often to poll (0 = DEFAULT_REPLICATION_INTERVAL)
+}
+
+// ReplicationReport lists what one sync changed
+type ReplicationReport struct {
+	Added   []string // Copied for the first time
+	Updated []string // Metadata replaced
+	Removed []string // Gone from the primary
+}
+
+// Changed reports whether the sync changed anything
+func (r ReplicationReport) Changed() bool {
+	return len(r.Added)+len(r.Updated)+len(r.Removed) > 0
+}
+
+// ReplicationStatus is how a secondary stands with its primary
+type ReplicationStatus struct {
+	Primary   string    `json:"primary"`
+	Zone      string    `json:"zone"`
+	LastSync  time.Time `json:"last_sync,omitempty"` // Last successful sync
+	LastError string    `json:"last_error,omitempty"`
+	Failures  int       `json:"failures"` // Failed syncs since the last success
+	Messages  int       `json:"messages"` // Messages the primary listed
+}
+
+// Replicator keeps a storage backend in step with a primary server
+type Replicator struct {
+	config  ReplicationConfig
+	storage Storage
+	replica ReplicaStore
+
+	mu     sync.Mutex
+	status ReplicationStatus
+	cancel context.CancelFunc // Ends the syncs started by Start
+}
+
+// NewReplicator creates a replicator copying config.Primary into storage
+func NewReplicator(storage Storage, config ReplicationConfig) (*Replicator, error) {
+	replica, ok := storage.(ReplicaStore)
+	if !ok {
+		return nil, errors.New("storage can't hold replicas")
+	}
+	primary, err := url.Parse(config.Primary)
+	if err != nil || (primary.Scheme != "http" && primary.Scheme != "https") || primary.Host == "" {
+		return nil, fmt.Errorf("primary %q is not an http:// or https:// URL", config.Primary)
+	}
+	config.Primary = strings.TrimSuffix(config.Primary, "/")
+	if config.Interval == 0 {
+		config.Interval = DEFAULT_REPLICATION_INTERVAL
+	}
+	if config.Interval < MIN_REPLICATION_INTERVAL {
+		return nil, fmt.Errorf("replication interval %s is shorter than %s", config.Interval, MIN_REPLICATION_INTERVAL)
+	}
+	if config.Client == nil {
+		config.Client = http.DefaultClient
+	}
+
+	return &Replicator{
+		config:  config,
+		storage: storage,
+		replica: replica,
+		status:  ReplicationStatus{Primary: config.Primary, Zone: config.Zone},
+	}, nil
+}
+
+// Sync brings storage in step with the primary once. A sync cut short by
+// ctx is not counted as a failure: the primary may be fine.
+func (r *Replicator) Sync(ctx context.Context) (ReplicationReport, error) {
+	report, listed, err := r.sync(ctx)
+	if ctx.Err() != nil {
+		return report, ctx.Err()
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err != nil {
+		r.status.LastError = err.Error()
+		r.status.Failures++
+	} else {
+		r.status.LastSync = time.Now()
+		r.status.LastError = ""
+		r.status.Failures = 0
+		r.status.Messages = listed
+	}
+	return report, err
+}
+
+// sync runs one sync, returning the number of messages the primary listed
+func (r *Replicator) sync(ctx context.Context) (ReplicationReport, int, error) {
+	var report ReplicationReport
+
+	var listing ReplicationListing
+	if err := r.get(ctx, "/admin/replication", &listing); err != nil {
+		return report, 0, err
+	}
+	held, err := r.storage.ListMessages()
+	if err != nil {
+		return report, 0, fmt.Errorf("failed to list local messages: %w", err)
+	}
+	local := make(map[string]*Message, len(held))
+	for _, msg := range held {
+		local[msg.ID] = msg
+	}
+
+	primary := make(map[string]bool, len(listing.Messages))
+	for _, listed := range listing.Messages {
+		if listed == nil || listed.ID == "" {
+			continue
+		}
+		primary[listed.ID] = true
+		listed.Origin = r.config.Primary
+
+		current, exists := local[listed.ID]
+		switch {
+		case !exists:
+			var msg Message
+			if err := r.get(ctx, "/admin/replication/"+url.PathEscape(listed.ID), &msg); err != nil {
+				return report, 0, err
+			}
+			msg.Origin = r.config.Primary
+			if err := r.replica.PutReplica(&msg); err != nil {
+				return report, 0, fmt.Errorf("failed to store message %s: %w", msg.ID, err)
+			}
+			report.Added = append(report.Added, msg.ID)
+		case replicaMetadata(current) != replicaMetadata(listed):
+			listed.Chunks = nil
+			if err := r.replica.PutReplica(listed); err != nil {
+				return report, 0, fmt.Errorf("failed to update message %s: %w", listed.ID, err)
+			}
+			report.Updated = append(report.Updated, listed.ID)
+		}
+	}
+
+	var gone []string
+	for id, msg := range local {
+		if msg.Origin == r.config.Primary && !primary[id] {
+			gone = append(gone, id)
+		}
+	}
+	if len(gone) > 0 && r.storage.DeleteMessages(gone...) > 0 {
+		report.Removed = gone
+	}
+	return report, len(primary), nil
+}
+
+// replicaMetadata renders what a sync compares: everything but the chunks
+// and where the copy came from
+func replicaMetadata(msg *Message) string {
+	metadata := *msg
+	metadata.Chunks = nil
+	metadata.Origin = ""
+	data, _ := json.Marshal(&metadata)
+	return string(data)
+}
+
+// get fetches a primary API path (with ?zone=) into v
+func (r *Replicator) get(ctx context.Context, path string, v interface{}) error {
+	target := r.config.Primary + path
+	if r.config.Zone != "" {
+		target += "?zone=" + url.QueryEscape(r.config.Zone)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return err
+	}
+	if r.config.KeyID != "" {
+		SignRequest(req, r.config.KeyID, r.config.Secret, nil)
+	}
+
+	resp, err := r.config.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("primary unreachable: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("primary answered %s for %s: %s", resp.Status, path, strings.TrimSpace(string(body)))
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("primary sent an unreadable answer for %s: %w", path, err)
+	}
+	return nil
+}
+
+// Status reports how the secondary stands with its primary
+func (r *Replicator) Status() ReplicationStatus {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.status
+}
+
+// Start syncs now and then every interval until ctx is done or Stop.
+// onSync (optional) receives the report of every completed sync, and its
+// error.
+func (r *Replicator) Start(ctx context.Context, onSync func(ReplicationReport, error)) {
+	ctx, cancel := context.WithCancel(ctx)
+	r.mu.Lock()
+	r.cancel = cancel
+	r.mu.Unlock()
+
+	go func() {
+		ticker := time.NewTicker(r.config.Interval)
+		defer ticker.Stop()
+
+		for {
+			report, err := r.Sync(ctx)
+			if ctx.Err() != nil {
+				return
+			}
+			if onSync != nil {
+				onSync(report, err)
+			}
+
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// Stop ends the syncs started by Start, abandoning one in progress
+func (r *Replicator) Stop() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.cancel != nil {
+		r.cancel()
+	}
+}
+
+// PutReplica stores a message as its primary holds it; see ReplicaStore
+func (ms *MemoryStorage) PutReplica(msg *Message) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	return ms.putReplica(msg)
+}
+
+// putReplica stores a replicated message; callers hold the write lock
+func (ms *MemoryStorage) putReplica(msg *Message) error {
+	current, exists := ms.messages[msg.ID]
+	if !exists {
+		if msg.Chunks == nil {
+			return fmt.Errorf("message %s not stored here, and sent without chunks", msg.ID)
+		}
+		ms.insert(msg)
+		ms.countState(StateNew, msg.State)
+		ms.reindex(msg)
+		return nil
+	}
+
+	replica := *msg
+	replica.Chunks = current.Chunks
+	replica.spilledBytes = current.spilledBytes
+	replica.spilledChunks = current.spilledChunks
+	ms.messages[msg.ID] = &replica
+	ms.countState(current.State, replica.State)
+	ms.reindex(&replica)
+	return nil
+}
+
+// countState updates the statistics for a message moving between states;
+// callers hold the write lock
+func (ms *MemoryStorage) countState(from, to MessageState) {
+	if from == to {
+		return
+	}
+	if from == StateNew {
+		ms.stats.NewMessages--
+	}
+	switch to {
+	case StateNew:
+		ms.stats.NewMessages++
+	case StateDelivered:
+		ms.stats.Delivered++
+	case StateConsumed:
+		ms.stats.Consumed++
+	}
+}
+
+// reindex lists msg as seen by exactly its consumers; callers hold the
+// write lock
+func (ms *MemoryStorage) reindex(msg *Message) {
+	for clientID, ids := range ms.index {
+		ms.index[clientID] = removeID(ids, msg.ID)
+	}
+	for _, clientID := range consumerIDs(msg) {
+		ms.index[clientID] = append(ms.index[clientID], msg.ID)
+	}
+}
+
+// consumerIDs returns the distinct clients in a message's consumer records
+func consumerIDs(msg *Message) []string {
+	seen := make(map[string]bool)
+	var ids []string
+	for _, record := range msg.Consumers {
+		id := record.ClientID
+		if id == "" {
+			id = record.ClientIP // Records from before client IDs
+		}
+		if id != "" && !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// PutReplica journals and stores a message as its primary holds it
+func (fs *FileStorage) PutReplica(msg *Message) error {
+	fs.MemoryStorage.mu.Lock()
+	defer fs.MemoryStorage.mu.Unlock()
+
+	if _, exists := fs.messages[msg.ID]; !exists && msg.Chunks == nil {
+		return fmt.Errorf("message %s not stored here, and sent without chunks", msg.ID)
+	}
+	if err := fs.journal.append(journalEntry{Op: JOURNAL_REPLICA, Message: msg, At: time.Now()}); err != nil {
+		return fmt.Errorf("message %s not committed: %w", msg.ID, err)
+	}
+	return fs.putReplica(msg)
+}
+
+// PutReplica stores a message as its primary holds it, in one transaction
+func (bs *BoltStorage) PutReplica(msg *Message) error {
+	return bs.db.Update(func(tx *bolt.Tx) error {
+		current, err := getRecord(tx, msg.ID)
+		previous := StateNew
+		var added StorageStats
+		if err != nil {
+			if msg.Chunks == nil {
+				return fmt.Errorf("message %s not stored here, and sent without chunks", msg.ID)
+			}
+			if err := putMessage(tx, msg); err != nil {
+				return err
+			}
+			added.TotalMessages++
+			added.NewMessages++
+			added.TotalChunks += len(msg.Chunks)
+			added.MemoryUsage += msg.Size()
+		} else {
+			previous = current.State
+			record := boltRecord{Message: *msg, ChunkBytes: current.ChunkBytes, ChunkCount: current.ChunkCount}
+			record.Chunks = nil
+			if err := putRecord(tx, &record); err != nil {
+				return err
+			}
+		}
+
+		// Seen by exactly its consumers
+		index := tx.Bucket(boltIndex)
+		err = index.ForEach(func(clientID, _ []byte) error {
+			if seen := index.Bucket(clientID); seen != nil {
+				return seen.Delete([]byte(msg.ID))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, clientID := range consumerIDs(msg) {
+			seen, err := index.CreateBucketIfNotExists([]byte(clientID))
+			if err != nil {
+				return fmt.Errorf("failed to index client %s: %w", clientID, err)
+			}
+			if err := seen.Put([]byte(msg.ID), []byte(msg.StateSince().Format(time.RFC3339))); err != nil {
+				return err
+			}
+		}
+
+		return updateStats(tx, func(stats *StorageStats) {
+			stats.TotalMessages += added.TotalMessages
+			stats.NewMessages += added.NewMessages
+			stats.TotalChunks += added.TotalChunks
+			stats.MemoryUsage += added.MemoryUsage
+			if previous != msg.State {
+				if previous == StateNew {
+					stats.NewMessages--
+				}
+				switch msg.State {
+				case StateNew:
+					stats.NewMessages++
+				case StateDelivered:
+					stats.Delivered++
+				case StateConsumed:
+					stats.Consumed++
+				}
+			}
+		})
+	})
+}
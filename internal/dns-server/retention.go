@@ -0,0 +1,304 @@
+package dnsserver
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ================================================================================
+// SIZE-BOUNDED RETENTION
+// Caps what storage holds on every store, evicting to make room
+// ================================================================================
+
+// LESSON: Bounded Between Sweeps
+// The GC policy's max-bytes and max-messages rules run once per interval
+// (an hour by default). Between sweeps nothing stops a burst of uploads:
+// a long-running server grows with whatever arrives, and a sweep that
+// finally runs has to remove a lot at once. Retention limits are enforced
+// by the storage itself, in the same call that stores a message:
+//
+//   1. The message goes in
+//   2. While storage holds more than max-messages or max-bytes, the least
+//      valuable message goes - expired, then consumed, then delivered,
+//      then new, like the GC - never the one just stored
+//   3. Within a state, evict=oldest removes the oldest upload first and
+//      evict=lru the message whose chunks were looked up longest ago, so
+//      a message receivers are still fetching outlives an untouched one
+//
+// A message larger than max-bytes on its own is refused rather than
+// emptying the store for it. Evictions are counted in StorageStats and,
+// with -persistent, journaled like deletions; the next snapshot compacts
+// them out of the data file. Lookup times for LRU live only in memory:
+// after a restart every message counts as last used when it was uploaded.
+
+// ErrOverRetention means a message alone is larger than the retention limit
+var ErrOverRetention = errors.New("message larger than the retention limit")
+
+// Eviction orders within a message state
+const (
+	EVICT_OLDEST EvictionOrder = "oldest" // Oldest upload first
+	EVICT_LRU    EvictionOrder = "lru"    // Least recently looked up first
+)
+
+// EvictionOrder picks which of equally valuable messages is evicted first
+type EvictionOrder string
+
+// RetentionPolicy bounds what a storage backend holds
+type RetentionPolicy struct {
+	MaxTotalBytes int64         // Evict while message data exceeds this (0 = no limit)
+	MaxMessages   int           // Evict while the message count exceeds this (0 = no limit)
+	Evict         EvictionOrder // Order within a state ("" = EVICT_OLDEST)
+}
+
+// ParseRetention reads a policy from a comma separated spec, e.g.
+// "max-bytes=500MB,max-messages=10000,evict=lru" ("" = no limits)
+func ParseRetention(spec string) (RetentionPolicy, error) {
+	policy := RetentionPolicy{Evict: EVICT_OLDEST}
+
+	for _, field := range strings.Split(spec, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+
+		key, val, found := strings.Cut(field, "=")
+		if !found {
+			return RetentionPolicy{}, fmt.Errorf("malformed retention rule: %q", field)
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		val = strings.TrimSpace(val)
+
+		var err error
+		switch key {
+		case "max-bytes":
+			policy.MaxTotalBytes, err = ParseByteSize(val)
+		case "max-messages":
+			policy.MaxMessages, err = strconv.Atoi(val)
+		case "evict":
+			policy.Evict = EvictionOrder(strings.ToLower(val))
+		default:
+			return RetentionPolicy{}, fmt.Errorf("unknown retention rule: %s", key)
+		}
+		if err != nil {
+			return RetentionPolicy{}, fmt.Errorf("invalid %s: %w", key, err)
+		}
+	}
+
+	return policy, policy.validate()
+}
+
+// validate rejects negative limits and unknown eviction orders
+func (p RetentionPolicy) validate() error {
+	if p.MaxTotalBytes < 0 {
+		return fmt.Errorf("max-bytes must not be negative, got %d", p.MaxTotalBytes)
+	}
+	if p.MaxMessages < 0 {
+		return fmt.Errorf("max-messages must not be negative, got %d", p.MaxMessages)
+	}
+	switch p.Evict {
+	case "", EVICT_OLDEST, EVICT_LRU:
+		return nil
+	}
+	return fmt.Errorf("unknown eviction order %q (%s, %s)", p.Evict, EVICT_OLDEST, EVICT_LRU)
+}
+
+// Enabled reports whether the policy limits anything
+func (p RetentionPolicy) Enabled() bool {
+	return p.MaxTotalBytes > 0 || p.MaxMessages > 0
+}
+
+// String describes the policy in ParseRetention syntax
+func (p RetentionPolicy) String() string {
+	evict := p.Evict
+	if evict == "" {
+		evict = EVICT_OLDEST
+	}
+	return fmt.Sprintf("max-bytes=%d,max-messages=%d,evict=%s", p.MaxTotalBytes, p.MaxMessages, evict)
+}
+
+// over reports whether messages holding bytes exceed the policy
+func (p RetentionPolicy) over(messages int, bytes int64) bool {
+	return (p.MaxMessages > 0 && messages > p.MaxMessages) || (p.MaxTotalBytes > 0 && bytes > p.MaxTotalBytes)
+}
+
+// retention is the policy a MemoryStorage enforces, with the lookup times
+// EVICT_LRU orders by
+type retention struct {
+	policy  RetentionPolicy
+	onEvict func(ids []string)
+
+	mu   sync.Mutex           // Guards used: lookups hold only the read lock
+	used map[string]time.Time // Message ID -> last lookup
+}
+
+// SetRetention bounds what MemoryStorage holds, evicting at once if it
+// already holds more. onEvict (optional) receives the IDs of every
+// eviction, called with the storage locked. Call it before the storage is
+// shared.
+func (ms *MemoryStorage) SetRetention(policy RetentionPolicy, onEvict func(ids []string)) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	if err := ms.setRetention(policy, onEvict); err != nil {
+		return err
+	}
+	ms.removeEvicted(ms.overRetention(""))
+	return nil
+}
+
+// setRetention installs a policy; callers hold the write lock
+func (ms *MemoryStorage) setRetention(policy RetentionPolicy, onEvict func(ids []string)) error {
+	if err := policy.validate(); err != nil {
+		return err
+	}
+	if !policy.Enabled() {
+		ms.retention = nil
+		return nil
+	}
+	ms.retention = &retention{policy: policy, onEvict: onEvict, used: make(map[string]time.Time)}
+	return nil
+}
+
+// fits refuses a message larger than the whole retention limit
+func (ms *MemoryStorage) fits(msg *Message) error {
+	if ms.retention == nil || ms.retention.policy.MaxTotalBytes <= 0 {
+		return nil
+	}
+	if size := msg.Size(); size > ms.retention.policy.MaxTotalBytes {
+		return fmt.Errorf("message %s is %d bytes, limit %d: %w", msg.ID, size, ms.retention.policy.MaxTotalBytes, ErrOverRetention)
+	}
+	return nil
+}
+
+// touch records a lookup of a message for EVICT_LRU
+func (ms *MemoryStorage) touch(id string) {
+	r := ms.retention
+	if r == nil || r.policy.Evict != EVICT_LRU {
+		return
+	}
+	r.mu.Lock()
+	r.used[id] = time.Now()
+	r.mu.Unlock()
+}
+
+// lastUsed returns when a message was last looked up, or uploaded
+func (r *retention) lastUsed(msg *Message) time.Time {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if used, ok := r.used[msg.ID]; ok && used.After(msg.CreatedAt) {
+		return used
+	}
+	return msg.CreatedAt
+}
+
+// forget drops a removed message's lookup time
+func (r *retention) forget(id string) {
+	r.mu.Lock()
+	delete(r.used, id)
+	r.mu.Unlock()
+}
+
+// overRetention returns the messages (never keep) to evict until storage
+// is within the policy, least valuable first; callers hold the write lock
+func (ms *MemoryStorage) overRetention(keep string) []string {
+	r := ms.retention
+	if r == nil {
+		return nil
+	}
+	count, bytes := len(ms.messages), ms.stats.StoredBytes
+	if !r.policy.over(count, bytes) {
+		return nil
+	}
+
+	candidates := make([]*Message, 0, len(ms.messages))
+	for id, msg := range ms.messages {
+		if id != keep {
+			candidates = append(candidates, msg)
+		}
+	}
+	at := func(msg *Message) time.Time {
+		if r.policy.Evict == EVICT_LRU {
+			return r.lastUsed(msg)
+		}
+		return msg.CreatedAt
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		ri, rj := evictionRank(candidates[i].State), evictionRank(candidates[j].State)
+		if ri != rj {
+			return ri < rj
+		}
+		ti, tj := at(candidates[i]), at(candidates[j])
+		if !ti.Equal(tj) {
+			return ti.Before(tj)
+		}
+		return candidates[i].ID < candidates[j].ID
+	})
+
+	var victims []string
+	for _, msg := range candidates {
+		if !r.policy.over(count, bytes) {
+			break
+		}
+		victims = append(victims, msg.ID)
+		count--
+		bytes -= msg.Size()
+	}
+	return victims
+}
+
+// removeEvicted removes messages evicted by the retention policy and counts
+// them; callers hold the write lock
+func (ms *MemoryStorage) removeEvicted(ids []string) {
+	if len(ids) == 0 {
+		return
+	}
+	var freed int64
+	for _, id := range ids {
+		if msg, exists := ms.messages[id]; exists {
+			freed += msg.Size()
+		}
+	}
+
+	ms.stats.Evictions += ms.deleteMessages(ids)
+	ms.stats.EvictedBytes += freed
+	if ms.retention != nil && ms.retention.onEvict != nil {
+		ms.retention.onEvict(ids)
+	}
+}
+
+// SetRetention bounds what FileStorage holds; evictions are journaled like
+// deletions. See MemoryStorage.SetRetention.
+func (fs *FileStorage) SetRetention(policy RetentionPolicy, onEvict func(ids []string)) error {
+	fs.MemoryStorage.mu.Lock()
+	defer fs.MemoryStorage.mu.Unlock()
+
+	if err := fs.setRetention(policy, onEvict); err != nil {
+		return err
+	}
+	return fs.enforceRetention("")
+}
+
+// enforceRetention journals and removes the messages (never keep) the
+// retention policy evicts; callers hold the write lock
+func (fs *FileStorage) enforceRetention(keep string) error {
+	victims := fs.overRetention(keep)
+	if len(victims) == 0 {
+		return nil
+	}
+	if err := fs.journal.append(journalEntry{Op: JOURNAL_EVICT, IDs: victims, At: time.Now()}); err != nil {
+		return fmt.Errorf("failed to persist evictions: %w", err)
+	}
+	fs.removeEvicted(victims)
+	return nil
+}
+
+// SetRetention is not supported: the database keeps its messages on disk,
+// bounded by the GC policy's max-bytes and max-messages rules
+func (bs *BoltStorage) SetRetention(policy RetentionPolicy, onEvict func(ids []string)) error {
+	return errors.New("database storage is bounded by the GC policy (-gc max-bytes=,max-messages=); retention limits need memory or persistent storage")
+}
@@ -0,0 +1,161 @@
+package dnsserver
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// retained describes one stored message of an eviction case
+type retained struct {
+	id    string
+	state MessageState
+	age   time.Duration // Uploaded this long ago
+	used  time.Duration // Looked up this long ago (0 = never)
+}
+
+func TestOverRetentionOrder(t *testing.T) {
+	tests := []struct {
+		name     string
+		policy   RetentionPolicy
+		messages []retained
+		keep     string
+		want     []string
+	}{
+		{
+			name:   "least valuable state first",
+			policy: RetentionPolicy{MaxMessages: 1},
+			messages: []retained{
+				{id: "new", state: StateNew, age: 4 * time.Hour},
+				{id: "delivered", state: StateDelivered, age: 3 * time.Hour},
+				{id: "consumed", state: StateConsumed, age: 2 * time.Hour},
+				{id: "expired", state: StateExpired, age: time.Hour},
+			},
+			want: []string{"expired", "consumed", "delivered"},
+		},
+		{
+			name:   "oldest upload first within a state",
+			policy: RetentionPolicy{MaxMessages: 1, Evict: EVICT_OLDEST},
+			messages: []retained{
+				{id: "a", state: StateNew, age: 3 * time.Hour, used: time.Minute},
+				{id: "b", state: StateNew, age: time.Hour},
+				{id: "c", state: StateNew, age: 2 * time.Hour, used: 10 * time.Minute},
+			},
+			want: []string{"a", "c"},
+		},
+		{
+			name:   "least recently looked up first within a state",
+			policy: RetentionPolicy{MaxMessages: 1, Evict: EVICT_LRU},
+			messages: []retained{
+				{id: "a", state: StateNew, age: 3 * time.Hour, used: time.Minute},
+				{id: "b", state: StateNew, age: time.Hour},
+				{id: "c", state: StateNew, age: 2 * time.Hour, used: 10 * time.Minute},
+			},
+			want: []string{"b", "c"},
+		},
+		{
+			name:   "state outranks lookups",
+			policy: RetentionPolicy{MaxMessages: 1, Evict: EVICT_LRU},
+			messages: []retained{
+				{id: "fresh", state: StateConsumed, age: 3 * time.Hour, used: time.Minute},
+				{id: "stale", state: StateNew, age: 3 * time.Hour},
+			},
+			want: []string{"fresh"},
+		},
+		{
+			name:   "never the message just stored",
+			policy: RetentionPolicy{MaxMessages: 1},
+			messages: []retained{
+				{id: "stored", state: StateExpired, age: 5 * time.Hour},
+				{id: "other", state: StateNew, age: time.Minute},
+			},
+			keep: "stored",
+			want: []string{"other"},
+		},
+		{
+			name:   "only as many as the byte limit needs",
+			policy: RetentionPolicy{MaxTotalBytes: 2 * testMessage("a", 1).Size()},
+			messages: []retained{
+				{id: "a", state: StateNew, age: 3 * time.Hour},
+				{id: "b", state: StateNew, age: 2 * time.Hour},
+				{id: "c", state: StateNew, age: time.Hour},
+			},
+			want: []string{"a"},
+		},
+		{
+			name:   "nothing within the limits",
+			policy: RetentionPolicy{MaxMessages: 2},
+			messages: []retained{
+				{id: "a", state: StateExpired, age: 3 * time.Hour},
+				{id: "b", state: StateNew, age: time.Hour},
+			},
+			want: nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			storage := NewMemoryStorage()
+			now := time.Now()
+			for _, m := range tt.messages {
+				if err := storage.StoreMessage(testMessage(m.id, 1)); err != nil {
+					t.Fatalf("StoreMessage(%s): %v", m.id, err)
+				}
+				msg := storage.messages[m.id]
+				msg.State = m.state
+				msg.CreatedAt = now.Add(-m.age)
+			}
+			if err := storage.setRetention(tt.policy, nil); err != nil {
+				t.Fatalf("setRetention: %v", err)
+			}
+			for _, m := range tt.messages {
+				if m.used > 0 {
+					storage.retention.used[m.id] = now.Add(-m.used)
+				}
+			}
+
+			if got := storage.overRetention(tt.keep); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("overRetention(%q) = %v, want %v", tt.keep, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStoreMessageOverRetention(t *testing.T) {
+	size := testMessage("a", 1).Size()
+	storage := NewMemoryStorage()
+	if err := storage.SetRetention(RetentionPolicy{MaxTotalBytes: 2 * size}, nil); err != nil {
+		t.Fatalf("SetRetention: %v", err)
+	}
+	for _, id := range []string{"a", "b"} {
+		if err := storage.StoreMessage(testMessage(id, 1)); err != nil {
+			t.Fatalf("StoreMessage(%s): %v", id, err)
+		}
+	}
+
+	// Larger than the whole limit: refused, nothing evicted for it
+	err := storage.StoreMessage(testMessage("big", 3))
+	if !errors.Is(err, ErrOverRetention) {
+		t.Fatalf("StoreMessage(big) = %v, want ErrOverRetention", err)
+	}
+	if _, err := storage.GetMessage("big"); err == nil {
+		t.Error("refused message was stored")
+	}
+	if stats := storage.GetStats(); stats.TotalMessages != 2 || stats.Evictions != 0 {
+		t.Errorf("after refusal: %d messages, %d evictions, want 2 and 0", stats.TotalMessages, stats.Evictions)
+	}
+
+	// Within the limit: stored, evicting the oldest to make room
+	var evicted []string
+	storage.retention.onEvict = func(ids []string) { evicted = append(evicted, ids...) }
+	if err := storage.StoreMessage(testMessage("c", 1)); err != nil {
+		t.Fatalf("StoreMessage(c): %v", err)
+	}
+	if !reflect.DeepEqual(evicted, []string{"a"}) {
+		t.Errorf("evicted %v, want [a]", evicted)
+	}
+	if stats := storage.GetStats(); stats.TotalMessages != 2 || stats.Evictions != 1 || stats.EvictedBytes != size {
+		t.Errorf("after eviction: %d messages, %d evictions, %d bytes, want 2, 1 and %d", stats.TotalMessages, stats.Evictions, stats.EvictedBytes, size)
+	}
+}
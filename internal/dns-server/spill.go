@@ -0,0 +1,306 @@
+package dnsserver
+
+import (
+	"container/list"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ================================================================================
+// MEMORY LIMIT WITH DISK SPILL
+// Keeps recently used messages in RAM and parks the rest on disk
+// ================================================================================
+
+// LESSON: Bounded Memory, Unbounded Backlog
+// MemoryStorage used to hold every chunk of every message until the garbage
+// collector removed it, so a busy server's memory grew with its backlog.
+// With a memory limit set, the storage tracks which messages were used
+// last. When the chunk data in RAM exceeds the limit, the least recently
+// used messages are spilled: their chunks are written to the spill store
+// and dropped from memory, while the metadata (state, manifest, digest,
+// consumers) stays so listings, queue semantics and the GC work unchanged.
+// The first lookup of a spilled message reads its chunks back in and makes
+// it the most recently used one. Messages never change after they are
+// stored, so a spill file stays valid and a message that goes cold again
+// is dropped without writing anything.
+//
+// Spilled messages are replaced by a copy without chunks instead of being
+// modified, so a query still holding the old *Message keeps reading a
+// complete one.
+
+// SpillStore holds the chunks of messages evicted from memory
+type SpillStore interface {
+	Put(msgID string, chunks map[string]string) error
+	Get(msgID string) (map[string]string, error)
+	Delete(msgID string) error
+}
+
+// DirSpill keeps spilled chunks as one JSON file per message
+type DirSpill struct {
+	dir string
+}
+
+// NewDirSpill creates a spill store in dir. Files left by an earlier run
+// are removed: the memory storage they belonged to is gone.
+func NewDirSpill(dir string) (*DirSpill, error) {
+	if err := os.RemoveAll(dir); err != nil {
+		return nil, fmt.Errorf("failed to clear spill directory: %w", err)
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create spill directory: %w", err)
+	}
+	return &DirSpill{dir: dir}, nil
+}
+
+// Dir returns the spill directory
+func (ds *DirSpill) Dir() string {
+	return ds.dir
+}
+
+// path returns the spill file of a message
+func (ds *DirSpill) path(msgID string) (string, error) {
+	if msgID == "" || msgID == "." || msgID == ".." || strings.ContainsAny(msgID, `/\`) {
+		return "", fmt.Errorf("message ID %q can't be spilled", msgID)
+	}
+	return filepath.Join(ds.dir, msgID+".json"), nil
+}
+
+// Put writes a message's chunks, replacing any earlier file atomically
+func (ds *DirSpill) Put(msgID string, chunks map[string]string) error {
+	path, err := ds.path(msgID)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(chunks)
+	if err != nil {
+		return fmt.Errorf("failed to marshal chunks: %w", err)
+	}
+
+	file, err := os.CreateTemp(ds.dir, ".spill-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temp file: %w", err)
+	}
+	tempFile := file.Name()
+
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		os.Remove(tempFile)
+		return fmt.Errorf("failed to write spill file: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(tempFile)
+		return fmt.Errorf("failed to close spill file: %w", err)
+	}
+	if err := os.Rename(tempFile, path); err != nil {
+		os.Remove(tempFile)
+		return fmt.Errorf("failed to rename spill file: %w", err)
+	}
+
+	return nil
+}
+
+// Get reads a message's chunks back
+func (ds *DirSpill) Get(msgID string) (map[string]string, error) {
+	path, err := ds.path(msgID)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read spill file: %w", err)
+	}
+
+	var chunks map[string]string
+	if err := json.Unmarshal(data, &chunks); err != nil {
+		return nil, fmt.Errorf("corrupt spill file: %w", err)
+	}
+	return chunks, nil
+}
+
+// Delete removes a message's spill file, if any
+func (ds *DirSpill) Delete(msgID string) error {
+	path, err := ds.path(msgID)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// memoryLRU orders resident messages by last use
+type memoryLRU struct {
+	limit   int64                    // Chunk bytes allowed in RAM
+	spill   SpillStore               // Where cold messages go
+	order   *list.List               // Resident message IDs, most recently used first
+	items   map[string]*list.Element // Resident message ID -> its place in order
+	written map[string]bool          // Messages with a current spill file
+}
+
+// SetMemoryLimit bounds the message data MemoryStorage holds in RAM to limit
+// bytes, spilling the least recently used messages to spill. Call it before
+// the storage is shared.
+func (ms *MemoryStorage) SetMemoryLimit(limit int64, spill SpillStore) error {
+	if limit <= 0 {
+		return fmt.Errorf("memory limit must be positive, got %d", limit)
+	}
+	if spill == nil {
+		return errors.New("memory limit needs a spill store")
+	}
+
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	if ms.lru != nil {
+		return errors.New("memory limit already set")
+	}
+	ms.lru = &memoryLRU{
+		limit:   limit,
+		spill:   spill,
+		order:   list.New(),
+		items:   make(map[string]*list.Element),
+		written: make(map[string]bool),
+	}
+	for id := range ms.messages {
+		ms.lru.items[id] = ms.lru.order.PushFront(id)
+	}
+	ms.evict("")
+
+	return nil
+}
+
+// Spilled returns the number of messages whose chunks are on disk
+func (ms *MemoryStorage) Spilled() int {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	if ms.lru == nil {
+		return 0
+	}
+	return len(ms.messages) - len(ms.lru.items)
+}
+
+// resident returns a message with its chunks in memory, reloading them if
+// it was spilled, and marks it most recently used
+func (ms *MemoryStorage) resident(id string) (*Message, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	msg, exists := ms.messages[id]
+	if !exists {
+		return nil, fmt.Errorf("message %s not found", id)
+	}
+
+	if elem, ok := ms.lru.items[id]; ok {
+		ms.lru.order.MoveToFront(elem)
+		return msg, nil
+	}
+
+	chunks, err := ms.lru.spill.Get(id)
+	if err != nil {
+		return nil, fmt.Errorf("message %s spilled but unreadable: %w", id, err)
+	}
+
+	warm := *msg
+	warm.Chunks = chunks
+	warm.spilledBytes = 0
+	warm.spilledChunks = 0
+	ms.messages[id] = &warm
+	for name, data := range chunks {
+		ms.chunks[name] = data
+	}
+	ms.stats.MemoryUsage += msg.spilledBytes
+	ms.lru.items[id] = ms.lru.order.PushFront(id)
+
+	// Never evict the message being returned, even if it alone exceeds the limit
+	ms.evict(id)
+	return &warm, nil
+}
+
+// track registers a newly inserted message as most recently used;
+// callers hold the write lock
+func (ms *MemoryStorage) track(msg *Message) {
+	ms.stats.MemoryUsage += msg.Size()
+	ms.stats.StoredBytes += msg.Size()
+	if ms.lru == nil {
+		return
+	}
+	ms.lru.items[msg.ID] = ms.lru.order.PushFront(msg.ID)
+	ms.evict(msg.ID)
+}
+
+// untrack forgets a removed message; callers hold the write lock
+func (ms *MemoryStorage) untrack(msg *Message) {
+	ms.stats.MemoryUsage -= msg.Size() - msg.spilledBytes
+	ms.stats.StoredBytes -= msg.Size()
+	if ms.retention != nil {
+		ms.retention.forget(msg.ID)
+	}
+	if ms.lru == nil {
+		return
+	}
+	if elem, ok := ms.lru.items[msg.ID]; ok {
+		ms.lru.order.Remove(elem)
+		delete(ms.lru.items, msg.ID)
+	}
+	if ms.lru.written[msg.ID] {
+		if err := ms.lru.spill.Delete(msg.ID); err != nil {
+			storageLog.Warn("⚠️  Failed to remove spilled chunks", "msg_id", msg.ID, "err", err)
+		}
+		delete(ms.lru.written, msg.ID)
+	}
+}
+
+// evict spills least recently used messages (except keep) until memory is
+// within the limit; callers hold the write lock
+func (ms *MemoryStorage) evict(keep string) {
+	for elem := ms.lru.order.Back(); elem != nil && ms.stats.MemoryUsage > ms.lru.limit; {
+		prev := elem.Prev()
+		if id := elem.Value.(string); id != keep {
+			if err := ms.spillMessage(id); err != nil {
+				// Over the limit beats losing data; the next insert tries again
+				storageLog.Warn("⚠️  Failed to spill message", "msg_id", id, "err", err)
+				return
+			}
+		}
+		elem = prev
+	}
+}
+
+// spillMessage moves a resident message's chunks to the spill store;
+// callers hold the write lock
+func (ms *MemoryStorage) spillMessage(id string) error {
+	msg := ms.messages[id]
+
+	if !ms.lru.written[id] {
+		if err := ms.lru.spill.Put(id, msg.Chunks); err != nil {
+			return err
+		}
+		ms.lru.written[id] = true
+	}
+
+	cold := *msg
+	if cold.Digest == "" {
+		cold.Digest = ContentDigest(msg.Chunks)
+	}
+	cold.spilledBytes = msg.Size() - int64(len(msg.Manifest))
+	cold.spilledChunks = len(msg.Chunks)
+	cold.Chunks = nil
+	ms.messages[id] = &cold
+
+	for name := range msg.Chunks {
+		delete(ms.chunks, name)
+	}
+	ms.stats.MemoryUsage -= cold.spilledBytes
+
+	ms.lru.order.Remove(ms.lru.items[id])
+	delete(ms.lru.items, id)
+	return nil
+}
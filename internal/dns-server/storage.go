@@ -2,7 +2,9 @@ package dnsserver
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"github.com/faanross/simulacra_txt/internal/logging"
 	"os"
 	"sync"
 	"time"
@@ -18,6 +20,13 @@ import (
 // 2. Persistent: Survives restarts - good for reliability
 // 3. Hybrid: Memory cache + disk backup - best of both
 
+// Loggers of the package's components (see internal/logging)
+var (
+	storageLog = logging.For("storage")
+	quotaLog   = logging.For("quota")
+	apiLog     = logging.For("api")
+)
+
 // Message represents a complete covert channel message
 type Message struct {
 	ID          string            `json:"id"`           // Unique message identifier
@@ -27,23 +36,61 @@ type Message struct {
 	CreatedAt   time.Time         `json:"created_at"`
 	State       MessageState      `json:"state"`     // NEW, DELIVERED, CONSUMED
 	Consumers   []ConsumerRecord  `json:"consumers"` // Who has fetched this
+
+	StateChangedAt time.Time              `json:"state_changed_at,omitempty"` // Last state transition
+	Digest         string                 `json:"digest,omitempty"`           // SHA-256 of the chunk data (see ContentDigest)
+	Tags           []string               `json:"tags,omitempty"`             // Sender-chosen labels for filtered discovery
+	Recipient      string                 `json:"recipient,omitempty"`        // Client ID it is addressed to ("" = any, see recipients.go)
+	Burn           bool                   `json:"burn,omitempty"`             // Delete once fully fetched or acknowledged (see burn.go)
+	Priority       int                    `json:"priority,omitempty"`         // Discovery order, highest first (see priority.go)
+	Visibility     time.Duration          `json:"visibility,omitempty"`       // Redelivered when not acknowledged in time (0 = queue default, see visibility.go)
+	Groups         map[string]GroupCursor `json:"groups,omitempty"`           // Consumer group -> its state (see groups.go)
+	Origin         string                 `json:"origin,omitempty"`           // Primary it was replicated from (see replication.go)
+
+	spilledBytes  int64 // Chunk bytes parked in the spill store (see SetMemoryLimit)
+	spilledChunks int   // Chunks parked in the spill store
+}
+
+// Size returns the bytes a message occupies (chunks + manifest), counting
+// chunks spilled to disk
+func (m *Message) Size() int64 {
+	size := int64(len(m.Manifest)) + m.spilledBytes
+	for name, data := range m.Chunks {
+		size += int64(len(name) + len(data))
+	}
+	return size
+}
+
+// chunkCount returns the number of chunks, counting chunks spilled to disk
+func (m *Message) chunkCount() int {
+	return len(m.Chunks) + m.spilledChunks
+}
+
+// StateSince returns when the message entered its current state
+func (m *Message) StateSince() time.Time {
+	if m.StateChangedAt.IsZero() {
+		return m.CreatedAt
+	}
+	return m.StateChangedAt
 }
 
 // MessageState tracks lifecycle
 type MessageState int
 
 const (
-	StateNew       MessageState = iota // Just uploaded, never fetched
-	StateDelivered                     // Fetched at least once
+	StateNew       MessageState = iota // Just uploaded, not yet fully fetched
+	StateDelivered                     // Every chunk served at least once (see progress.go)
 	StateConsumed                      // Marked as consumed/processed
 	StateExpired                       // TTL exceeded
 )
 
 // ConsumerRecord tracks who fetched what
 type ConsumerRecord struct {
-	ClientIP      string    `json:"client_ip"`
+	ClientIP      string    `json:"client_ip"` // Source address (older records: the client ID)
+	ClientID      string    `json:"client_id,omitempty"`
+	Via           string    `json:"via,omitempty"` // How the client was identified (see clients.go)
 	FetchedAt     time.Time `json:"fetched_at"`
-	ChunksFetched []string  `json:"chunks_fetched"`
+	ChunksFetched []string  `json:"chunks_fetched"` // Chunk names served to the client, in order
 }
 
 // Storage is our main storage interface
@@ -55,12 +102,22 @@ type Storage interface {
 
 	// Queue semantics (for covert channel)
 	GetNewMessages(clientID string) ([]*Message, error)
-	MarkAsDelivered(msgID, clientID string) error
+	MarkAsDelivered(msgID string, client ClientInfo) error
+	MarkChunksServed(msgID string, client ClientInfo, chunkNames ...string) (bool, error) // Reports whether every chunk is now served
 	MarkAsConsumed(msgID, clientID string) error
 
+	// Consumer groups, each with its own state per message (see groups.go)
+	GetGroupMessages(group string) ([]*Message, error)
+	SetGroupState(msgID, group, clientID string, state MessageState) error
+
+	// Daily usage per client (see quota.go)
+	AddUsage(clientID, day string, bytes int64) (int64, error) // Returns the client's total for the day
+	GetUsage(clientID, day string) (int64, error)
+
 	// Management
 	ListMessages() ([]*Message, error)
-	CleanExpired(ttl time.Duration) int
+	DeleteMessages(ids ...string) int                // Returns number removed (see GarbageCollector)
+	SetState(msgID string, state MessageState) error // Moves a message by hand (see admin.go)
 	GetStats() StorageStats
 }
 
@@ -71,7 +128,10 @@ type StorageStats struct {
 	Delivered     int
 	Consumed      int
 	TotalChunks   int
-	MemoryUsage   int64
+	MemoryUsage   int64 // Message data in RAM
+	StoredBytes   int64 // Message data held, in RAM or spilled (see retention.go)
+	Evictions     int   // Messages removed by the retention policy
+	EvictedBytes  int64 // Message data they held
 }
 
 // ================================================================================
@@ -80,11 +140,14 @@ type StorageStats struct {
 
 // MemoryStorage keeps everything in RAM
 type MemoryStorage struct {
-	messages map[string]*Message // msgID -> Message
-	chunks   map[string]string   // full_chunk_name -> data
-	index    map[string][]string // clientID -> []msgID (for tracking)
-	mu       sync.RWMutex
-	stats    StorageStats
+	messages  map[string]*Message         // msgID -> Message
+	chunks    map[string]string           // full_chunk_name -> data
+	index     map[string][]string         // clientID -> []msgID (for tracking)
+	usage     map[string]map[string]int64 // day -> clientID -> bytes served
+	mu        sync.RWMutex
+	stats     StorageStats
+	lru       *memoryLRU // nil keeps every chunk in RAM
+	retention *retention // nil keeps every message until the GC removes it
 }
 
 // NewMemoryStorage creates in-memory storage
@@ -93,6 +156,7 @@ func NewMemoryStorage() *MemoryStorage {
 		messages: make(map[string]*Message),
 		chunks:   make(map[string]string),
 		index:    make(map[string][]string),
+		usage:    make(map[string]map[string]int64),
 	}
 }
 
@@ -109,10 +173,21 @@ func (ms *MemoryStorage) StoreMessage(msg *Message) error {
 	if _, exists := ms.messages[msg.ID]; exists {
 		return fmt.Errorf("message %s already exists", msg.ID)
 	}
+	if err := ms.fits(msg); err != nil {
+		return err
+	}
 
 	// Store message metadata
 	msg.State = StateNew
 	msg.CreatedAt = time.Now()
+	ms.insert(msg)
+	ms.removeEvicted(ms.overRetention(msg.ID))
+
+	return nil
+}
+
+// insert makes a message visible to lookups; callers hold the write lock
+func (ms *MemoryStorage) insert(msg *Message) {
 	ms.messages[msg.ID] = msg
 
 	// Store individual chunks for fast lookup
@@ -124,12 +199,31 @@ func (ms *MemoryStorage) StoreMessage(msg *Message) error {
 	ms.stats.TotalMessages++
 	ms.stats.NewMessages++
 	ms.stats.TotalChunks += len(msg.Chunks)
+	ms.track(msg)
+}
 
-	return nil
+// editable returns a stored message for a writer to change: a copy, put in
+// the original's place. Readers get stored messages and read them without
+// the lock, so a message they may hold is never changed - each write makes
+// a new one, as spilling does. Callers hold the write lock.
+func (ms *MemoryStorage) editable(id string) (*Message, bool) {
+	msg, exists := ms.messages[id]
+	if !exists {
+		return nil, false
+	}
+	edit := *msg
+	edit.Consumers = append([]ConsumerRecord(nil), msg.Consumers...)
+	ms.messages[id] = &edit
+	return &edit, true
 }
 
-// GetMessage retrieves a message by ID
+// GetMessage retrieves a message by ID, with its chunks
 func (ms *MemoryStorage) GetMessage(id string) (*Message, error) {
+	ms.touch(id)
+	if ms.lru != nil {
+		return ms.resident(id)
+	}
+
 	ms.mu.RLock()
 	defer ms.mu.RUnlock()
 
@@ -143,6 +237,14 @@ func (ms *MemoryStorage) GetMessage(id string) (*Message, error) {
 
 // GetChunk retrieves a specific chunk
 func (ms *MemoryStorage) GetChunk(msgID, chunkName string) (string, error) {
+	ms.touch(msgID)
+	if ms.lru != nil {
+		// A spilled message has to be read back before its chunks exist
+		if _, err := ms.resident(msgID); err != nil {
+			return "", err
+		}
+	}
+
 	ms.mu.RLock()
 	defer ms.mu.RUnlock()
 
@@ -157,7 +259,8 @@ func (ms *MemoryStorage) GetChunk(msgID, chunkName string) (string, error) {
 	return data, nil
 }
 
-// GetNewMessages returns undelivered messages for a client
+// GetNewMessages returns undelivered messages for a client. With a memory
+// limit, spilled messages come without chunks; GetMessage loads them.
 func (ms *MemoryStorage) GetNewMessages(clientID string) ([]*Message, error) {
 	ms.mu.RLock()
 	defer ms.mu.RUnlock()
@@ -183,36 +286,94 @@ func (ms *MemoryStorage) GetNewMessages(clientID string) ([]*Message, error) {
 		}
 	}
 
+	SortQueue(newMessages)
 	return newMessages, nil
 }
 
-// MarkAsDelivered marks message as delivered to a client
-func (ms *MemoryStorage) MarkAsDelivered(msgID, clientID string) error {
+// GetGroupMessages returns the messages a consumer group wasn't handed yet,
+// in queue order
+func (ms *MemoryStorage) GetGroupMessages(group string) ([]*Message, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	var newMessages []*Message
+	for _, msg := range ms.messages {
+		if msg.newForGroup(group) {
+			newMessages = append(newMessages, msg)
+		}
+	}
+
+	SortQueue(newMessages)
+	return newMessages, nil
+}
+
+// SetGroupState moves a message to a state within a consumer group
+func (ms *MemoryStorage) SetGroupState(msgID, group, clientID string, state MessageState) error {
 	ms.mu.Lock()
 	defer ms.mu.Unlock()
 
-	msg, exists := ms.messages[msgID]
+	msg, exists := ms.editable(msgID)
 	if !exists {
 		return fmt.Errorf("message %s not found", msgID)
 	}
 
-	// Update message state
-	if msg.State == StateNew {
-		msg.State = StateDelivered
-		ms.stats.NewMessages--
-		ms.stats.Delivered++
+	msg.setGroupState(group, clientID, state, time.Now())
+	return nil
+}
+
+// MarkAsDelivered records that a client discovered a message, so it isn't
+// listed to the client again
+func (ms *MemoryStorage) MarkAsDelivered(msgID string, client ClientInfo) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	msg, exists := ms.editable(msgID)
+	if !exists {
+		return fmt.Errorf("message %s not found", msgID)
 	}
 
-	// Record consumer
-	msg.Consumers = append(msg.Consumers, ConsumerRecord{
-		ClientIP:  clientID,
-		FetchedAt: time.Now(),
-	})
+	ms.markDelivered(msg, client, time.Now())
+	return nil
+}
+
+// markDelivered records a discovery of msg by a client at a given time;
+// callers hold the write lock
+func (ms *MemoryStorage) markDelivered(msg *Message, client ClientInfo, at time.Time) {
+	// Record consumer (its chunks are added as they are served)
+	msg.Consumers = append(msg.Consumers, client.record(at))
 
 	// Update index
-	ms.index[clientID] = append(ms.index[clientID], msgID)
+	ms.index[client.ID] = append(ms.index[client.ID], msg.ID)
+}
 
-	return nil
+// MarkChunksServed records chunks of a message served to a client; the
+// message becomes delivered once every chunk was served
+func (ms *MemoryStorage) MarkChunksServed(msgID string, client ClientInfo, chunkNames ...string) (bool, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	msg, exists := ms.editable(msgID)
+	if !exists {
+		return false, fmt.Errorf("message %s not found", msgID)
+	}
+
+	ms.markServed(msg, client, chunkNames, time.Now())
+	return msg.fullyServed(), nil
+}
+
+// markServed records served chunks and moves msg to the delivered state
+// once all were served; callers hold the write lock
+func (ms *MemoryStorage) markServed(msg *Message, client ClientInfo, chunkNames []string, at time.Time) {
+	if msg.recordServed(client, chunkNames, at) == nil {
+		return
+	}
+
+	if msg.State == StateNew && msg.fullyServed() {
+		msg.State = StateDelivered
+		msg.StateChangedAt = at
+		ms.stats.NewMessages--
+		ms.stats.Delivered++
+	}
 }
 
 // MarkAsConsumed marks message as fully processed
@@ -220,21 +381,88 @@ func (ms *MemoryStorage) MarkAsConsumed(msgID, clientID string) error {
 	ms.mu.Lock()
 	defer ms.mu.Unlock()
 
-	msg, exists := ms.messages[msgID]
+	msg, exists := ms.editable(msgID)
 	if !exists {
 		return fmt.Errorf("message %s not found", msgID)
 	}
 
-	// Update state
+	ms.markConsumed(msg, time.Now())
+	return nil
+}
+
+// markConsumed moves msg to the consumed state; callers hold the write lock
+func (ms *MemoryStorage) markConsumed(msg *Message, at time.Time) {
 	if msg.State != StateConsumed {
 		msg.State = StateConsumed
+		msg.StateChangedAt = at
 		ms.stats.Consumed++
 	}
+}
 
+// SetState moves a message to a state; back to new, its deliveries are
+// forgotten so receivers discover and fetch it again
+func (ms *MemoryStorage) SetState(msgID string, state MessageState) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	msg, exists := ms.editable(msgID)
+	if !exists {
+		return fmt.Errorf("message %s not found", msgID)
+	}
+
+	ms.setState(msg, state, time.Now())
 	return nil
 }
 
-// ListMessages returns all messages
+// setState moves msg to a state; callers hold the write lock
+func (ms *MemoryStorage) setState(msg *Message, state MessageState, at time.Time) {
+	if state == StateNew {
+		msg.Consumers = nil
+		for clientID, ids := range ms.index {
+			ms.index[clientID] = removeID(ids, msg.ID)
+		}
+	}
+	if msg.State == state {
+		return
+	}
+
+	if msg.State == StateNew {
+		ms.stats.NewMessages--
+	}
+	if state == StateNew {
+		ms.stats.NewMessages++
+	}
+	msg.State = state
+	msg.StateChangedAt = at
+}
+
+// removeID returns ids without id
+func removeID(ids []string, id string) []string {
+	kept := ids[:0]
+	for _, other := range ids {
+		if other != id {
+			kept = append(kept, other)
+		}
+	}
+	return kept
+}
+
+// AddUsage adds bytes to a client's usage on a day
+func (ms *MemoryStorage) AddUsage(clientID, day string, bytes int64) (int64, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	return addUsage(ms.usage, clientID, day, bytes), nil
+}
+
+// GetUsage returns a client's usage on a day
+func (ms *MemoryStorage) GetUsage(clientID, day string) (int64, error) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	return ms.usage[day][clientID], nil
+}
+
+// ListMessages returns all messages. With a memory limit, spilled messages
+// come without chunks; GetMessage loads them.
 func (ms *MemoryStorage) ListMessages() ([]*Message, error) {
 	ms.mu.RLock()
 	defer ms.mu.RUnlock()
@@ -247,31 +475,43 @@ func (ms *MemoryStorage) ListMessages() ([]*Message, error) {
 	return messages, nil
 }
 
-// CleanExpired removes old messages
-func (ms *MemoryStorage) CleanExpired(ttl time.Duration) int {
+// DeleteMessages removes messages and their chunks
+func (ms *MemoryStorage) DeleteMessages(ids ...string) int {
 	ms.mu.Lock()
 	defer ms.mu.Unlock()
 
+	return ms.deleteMessages(ids)
+}
+
+// deleteMessages removes messages and their chunks; callers hold the write lock
+func (ms *MemoryStorage) deleteMessages(ids []string) int {
 	// LESSON: Garbage Collection
-	// Prevents unbounded memory growth
+	// Prevents unbounded memory growth. WHICH messages go is decided
+	// by the GarbageCollector policy; storage only does the removal.
 
-	cutoff := time.Now().Add(-ttl)
 	removed := 0
 
-	for id, msg := range ms.messages {
-		if msg.CreatedAt.Before(cutoff) {
-			// Remove chunks
-			for chunkName := range msg.Chunks {
-				delete(ms.chunks, chunkName)
-			}
+	for _, id := range ids {
+		msg, exists := ms.messages[id]
+		if !exists {
+			continue
+		}
+
+		// Remove chunks
+		for chunkName := range msg.Chunks {
+			delete(ms.chunks, chunkName)
+		}
 
-			// Remove message
-			delete(ms.messages, id)
-			removed++
+		// Remove message
+		delete(ms.messages, id)
+		ms.untrack(msg)
+		removed++
 
-			// Update stats
-			ms.stats.TotalMessages--
-			ms.stats.TotalChunks -= len(msg.Chunks)
+		// Update stats
+		ms.stats.TotalMessages--
+		ms.stats.TotalChunks -= msg.chunkCount()
+		if msg.State == StateNew {
+			ms.stats.NewMessages--
 		}
 	}
 
@@ -290,40 +530,245 @@ func (ms *MemoryStorage) GetStats() StorageStats {
 // PERSISTENT STORAGE IMPLEMENTATION
 // ================================================================================
 
-// FileStorage adds persistence to memory storage
+// FileStorage adds persistence to memory storage: a JSON snapshot of
+// everything plus a journal of the changes made since (see journal.go)
 type FileStorage struct {
 	*MemoryStorage
 	dataFile string
-	mu       sync.Mutex
+	mu       sync.Mutex // Serializes snapshots
+
+	journal journal
+	stop    chan struct{} // Closed to end the snapshot loop
+	done    chan struct{} // Closed when the snapshot loop has ended
+}
+
+// snapshotData is the layout of the data file
+type snapshotData struct {
+	Messages   map[string]*Message         `json:"messages"`
+	Index      map[string][]string         `json:"index"`
+	Usage      map[string]map[string]int64 `json:"usage,omitempty"` // Daily usage (see quota.go)
+	Stats      StorageStats                `json:"stats"`
+	JournalSeq uint64                      `json:"journal_seq,omitempty"` // Last journal entry included
 }
 
-// NewFileStorage creates persistent storage
+// NewFileStorage creates persistent storage, recovering the changes a crash
+// left in the journal
 func NewFileStorage(dataFile string) (*FileStorage, error) {
 	fs := &FileStorage{
 		MemoryStorage: NewMemoryStorage(),
 		dataFile:      dataFile,
+		journal:       journal{path: dataFile + JOURNAL_SUFFIX, sync: true},
 	}
 
+	// A temp file means a save was interrupted; the data file is still intact
+	os.Remove(dataFile + ".tmp")
+
 	// Load existing data
 	if err := fs.Load(); err != nil && !os.IsNotExist(err) {
 		return nil, fmt.Errorf("failed to load data: %w", err)
 	}
 
+	if err := fs.replay(); err != nil {
+		return nil, fmt.Errorf("failed to replay journal: %w", err)
+	}
+	if err := fs.journal.open(); err != nil {
+		return nil, err
+	}
+
+	// Fold recovered changes into a snapshot, leaving an empty journal
+	if fs.journal.pending() || fs.journal.leftover() {
+		if err := fs.Save(); err != nil {
+			return nil, fmt.Errorf("failed to snapshot recovered data: %w", err)
+		}
+	}
+
 	return fs, nil
 }
 
-// StoreMessage adds message and persists to disk
+// SetJournalSync chooses whether every journal entry is synced to disk
+// before the change becomes visible (the default). Without it, a crash can
+// lose the last changes the OS had not written yet, and uploads don't wait
+// for the disk at all.
+func (fs *FileStorage) SetJournalSync(sync bool) {
+	fs.journal.mu.Lock()
+	defer fs.journal.mu.Unlock()
+	fs.journal.sync = sync
+}
+
+// StoreMessage journals a message, then makes it visible
 func (fs *FileStorage) StoreMessage(msg *Message) error {
-	// Store in memory first
-	if err := fs.MemoryStorage.StoreMessage(msg); err != nil {
+	fs.MemoryStorage.mu.Lock()
+	defer fs.MemoryStorage.mu.Unlock()
+
+	// LESSON: Durable Before Visible
+	// Storing in memory first would let DNS queries serve a message that a
+	// crash (or a failed write) then loses. Instead the message is appended
+	// to the journal first; only after that succeeds does it appear in
+	// memory. Either way, queries see all of it or none of it. Rewriting
+	// the whole data file is left to the snapshots, off the upload path.
+
+	if _, exists := fs.messages[msg.ID]; exists {
+		return fmt.Errorf("message %s already exists", msg.ID)
+	}
+	if err := fs.fits(msg); err != nil {
 		return err
 	}
 
-	// Persist to disk
-	return fs.Save()
+	msg.State = StateNew
+	msg.CreatedAt = time.Now()
+
+	if err := fs.journal.append(journalEntry{Op: JOURNAL_STORE, Message: msg, At: msg.CreatedAt}); err != nil {
+		return fmt.Errorf("message %s not committed: %w", msg.ID, err)
+	}
+
+	fs.insert(msg)
+	if err := fs.enforceRetention(msg.ID); err != nil {
+		// The message is committed; the next store tries again
+		storageLog.Warn("⚠️  Over the retention limit", "err", err)
+	}
+	return nil
+}
+
+// MarkAsDelivered journals and records a discovery
+func (fs *FileStorage) MarkAsDelivered(msgID string, client ClientInfo) error {
+	fs.MemoryStorage.mu.Lock()
+	defer fs.MemoryStorage.mu.Unlock()
+
+	msg, exists := fs.editable(msgID)
+	if !exists {
+		return fmt.Errorf("message %s not found", msgID)
+	}
+
+	at := time.Now()
+	if err := fs.journal.append(journalEntry{Op: JOURNAL_DELIVERED, ID: msgID, Client: client.ID, Addr: client.Addr, Via: client.Via, At: at}); err != nil {
+		return err
+	}
+	fs.markDelivered(msg, client, at)
+	return nil
+}
+
+// MarkChunksServed journals and records chunks served to a client. Chunks
+// the client was already served are not journaled again.
+func (fs *FileStorage) MarkChunksServed(msgID string, client ClientInfo, chunkNames ...string) (bool, error) {
+	fs.MemoryStorage.mu.Lock()
+	defer fs.MemoryStorage.mu.Unlock()
+
+	msg, exists := fs.editable(msgID)
+	if !exists {
+		return false, fmt.Errorf("message %s not found", msgID)
+	}
+
+	added := msg.unserved(client, chunkNames)
+	if len(added) == 0 {
+		return msg.fullyServed(), nil
+	}
+
+	at := time.Now()
+	if err := fs.journal.append(journalEntry{Op: JOURNAL_SERVED, ID: msgID, Client: client.ID, Addr: client.Addr, Via: client.Via, Chunks: added, At: at}); err != nil {
+		return false, err
+	}
+	fs.markServed(msg, client, added, at)
+	return msg.fullyServed(), nil
 }
 
-// Save writes current state to disk
+// MarkAsConsumed journals and records a message as consumed
+func (fs *FileStorage) MarkAsConsumed(msgID, clientID string) error {
+	fs.MemoryStorage.mu.Lock()
+	defer fs.MemoryStorage.mu.Unlock()
+
+	msg, exists := fs.editable(msgID)
+	if !exists {
+		return fmt.Errorf("message %s not found", msgID)
+	}
+	if msg.State == StateConsumed {
+		return nil
+	}
+
+	at := time.Now()
+	if err := fs.journal.append(journalEntry{Op: JOURNAL_CONSUMED, ID: msgID, Client: clientID, At: at}); err != nil {
+		return err
+	}
+	fs.markConsumed(msg, at)
+	return nil
+}
+
+// SetGroupState journals and records a message's move within a consumer group
+func (fs *FileStorage) SetGroupState(msgID, group, clientID string, state MessageState) error {
+	fs.MemoryStorage.mu.Lock()
+	defer fs.MemoryStorage.mu.Unlock()
+
+	msg, exists := fs.editable(msgID)
+	if !exists {
+		return fmt.Errorf("message %s not found", msgID)
+	}
+
+	at := time.Now()
+	if err := fs.journal.append(journalEntry{Op: JOURNAL_GROUP, ID: msgID, Group: group, Client: clientID, State: state, At: at}); err != nil {
+		return err
+	}
+	msg.setGroupState(group, clientID, state, at)
+	return nil
+}
+
+// AddUsage journals and adds bytes to a client's usage on a day
+func (fs *FileStorage) AddUsage(clientID, day string, bytes int64) (int64, error) {
+	fs.MemoryStorage.mu.Lock()
+	defer fs.MemoryStorage.mu.Unlock()
+
+	if err := fs.journal.append(journalEntry{Op: JOURNAL_USAGE, Client: clientID, Day: day, Bytes: bytes, At: time.Now()}); err != nil {
+		return 0, err
+	}
+	return addUsage(fs.usage, clientID, day, bytes), nil
+}
+
+// SetState journals and records a message's move to a state
+func (fs *FileStorage) SetState(msgID string, state MessageState) error {
+	fs.MemoryStorage.mu.Lock()
+	defer fs.MemoryStorage.mu.Unlock()
+
+	msg, exists := fs.editable(msgID)
+	if !exists {
+		return fmt.Errorf("message %s not found", msgID)
+	}
+
+	at := time.Now()
+	if err := fs.journal.append(journalEntry{Op: JOURNAL_STATE, ID: msgID, State: state, At: at}); err != nil {
+		return err
+	}
+	fs.setState(msg, state, at)
+	return nil
+}
+
+// SetMemoryLimit is not supported: every save writes all chunks, so they
+// have to stay in memory
+func (fs *FileStorage) SetMemoryLimit(limit int64, spill SpillStore) error {
+	return errors.New("persistent storage keeps every chunk in memory; memory limits need memory storage")
+}
+
+// DeleteMessages journals and removes messages
+func (fs *FileStorage) DeleteMessages(ids ...string) int {
+	fs.MemoryStorage.mu.Lock()
+	defer fs.MemoryStorage.mu.Unlock()
+
+	var present []string
+	for _, id := range ids {
+		if _, exists := fs.messages[id]; exists {
+			present = append(present, id)
+		}
+	}
+	if len(present) == 0 {
+		return 0
+	}
+
+	if err := fs.journal.append(journalEntry{Op: JOURNAL_DELETE, IDs: present, At: time.Now()}); err != nil {
+		storageLog.Error("⚠️  Failed to persist deletions", "err", err)
+		return 0
+	}
+	return fs.deleteMessages(present)
+}
+
+// Save writes a snapshot of the current state to disk and drops the
+// journal entries it covers
 func (fs *FileStorage) Save() error {
 	fs.mu.Lock()
 	defer fs.mu.Unlock()
@@ -332,36 +777,120 @@ func (fs *FileStorage) Save() error {
 	// Simple: JSON file (good for small datasets)
 	// Better: SQLite or BoltDB (for larger datasets)
 	// Best: Dedicated database (for production)
+	//
+	// Changes stop only while the state is marshalled and the journal
+	// switches to a new segment; the slow part - writing and syncing the
+	// file - runs while stores append to the new segment.
 
-	data := struct {
-		Messages map[string]*Message `json:"messages"`
-		Index    map[string][]string `json:"index"`
-		Stats    StorageStats        `json:"stats"`
-	}{
-		Messages: fs.messages,
-		Index:    fs.index,
-		Stats:    fs.stats,
+	fs.MemoryStorage.mu.RLock()
+	seq, err := fs.journal.rotate()
+	if err != nil {
+		fs.MemoryStorage.mu.RUnlock()
+		return err
 	}
-
-	jsonData, err := json.MarshalIndent(data, "", "  ")
+	jsonData, err := json.MarshalIndent(snapshotData{
+		Messages:   fs.messages,
+		Index:      fs.index,
+		Usage:      fs.usage,
+		Stats:      fs.stats,
+		JournalSeq: seq,
+	}, "", "  ")
+	fs.MemoryStorage.mu.RUnlock()
 	if err != nil {
 		return fmt.Errorf("failed to marshal data: %w", err)
 	}
 
-	// Atomic write (write to temp, then rename)
+	if err := fs.write(jsonData); err != nil {
+		return err
+	}
+	fs.journal.snapshotted(seq)
+	return nil
+}
+
+// Flush snapshots the state if anything changed since the last snapshot
+func (fs *FileStorage) Flush() error {
+	if !fs.journal.pending() {
+		return nil
+	}
+	return fs.Save()
+}
+
+// StartSnapshots flushes to the data file every interval in the background
+// (write-behind) until Close
+func (fs *FileStorage) StartSnapshots(interval time.Duration) {
+	if interval <= 0 || fs.stop != nil {
+		return
+	}
+	fs.stop = make(chan struct{})
+	fs.done = make(chan struct{})
+
+	go func() {
+		defer close(fs.done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				if err := fs.Flush(); err != nil {
+					storageLog.Warn("⚠️  Snapshot failed (changes stay in the journal)", "err", err)
+				}
+			case <-fs.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Close stops background snapshots, flushes and closes the journal
+func (fs *FileStorage) Close() error {
+	if fs.stop != nil {
+		close(fs.stop)
+		<-fs.done
+		fs.stop = nil
+	}
+	if err := fs.Flush(); err != nil {
+		return err
+	}
+	return fs.journal.close()
+}
+
+// write atomically replaces the data file
+func (fs *FileStorage) write(jsonData []byte) error {
+	// Atomic write (write to temp, sync, then rename)
 	tempFile := fs.dataFile + ".tmp"
-	if err := os.WriteFile(tempFile, jsonData, 0644); err != nil {
+	file, err := os.OpenFile(tempFile, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to create temp file: %w", err)
+	}
+
+	if _, err := file.Write(jsonData); err != nil {
+		file.Close()
+		os.Remove(tempFile)
 		return fmt.Errorf("failed to write temp file: %w", err)
 	}
 
+	// Without a sync, a crash after the rename can leave an empty data file
+	if err := file.Sync(); err != nil {
+		file.Close()
+		os.Remove(tempFile)
+		return fmt.Errorf("failed to sync temp file: %w", err)
+	}
+
+	if err := file.Close(); err != nil {
+		os.Remove(tempFile)
+		return fmt.Errorf("failed to close temp file: %w", err)
+	}
+
 	if err := os.Rename(tempFile, fs.dataFile); err != nil {
+		os.Remove(tempFile)
 		return fmt.Errorf("failed to rename temp file: %w", err)
 	}
 
 	return nil
 }
 
-// Load reads state from disk
+// Load reads the snapshot from disk
 func (fs *FileStorage) Load() error {
 	fs.mu.Lock()
 	defer fs.mu.Unlock()
@@ -371,26 +900,37 @@ func (fs *FileStorage) Load() error {
 		return err
 	}
 
-	var data struct {
-		Messages map[string]*Message `json:"messages"`
-		Index    map[string][]string `json:"index"`
-		Stats    StorageStats        `json:"stats"`
-	}
-
+	var data snapshotData
 	if err := json.Unmarshal(jsonData, &data); err != nil {
 		return fmt.Errorf("failed to unmarshal data: %w", err)
 	}
 
 	fs.messages = data.Messages
 	fs.index = data.Index
+	fs.usage = data.Usage
 	fs.stats = data.Stats
+	fs.journal.seq = data.JournalSeq
+	fs.journal.saved = data.JournalSeq
+	if fs.messages == nil {
+		fs.messages = make(map[string]*Message)
+	}
+	if fs.index == nil {
+		fs.index = make(map[string][]string)
+	}
+	if fs.usage == nil {
+		fs.usage = make(map[string]map[string]int64)
+	}
 
 	// Rebuild chunks index
 	fs.chunks = make(map[string]string)
+	fs.stats.MemoryUsage = 0
+	fs.stats.StoredBytes = 0
 	for _, msg := range fs.messages {
 		for chunkName, chunkData := range msg.Chunks {
 			fs.chunks[chunkName] = chunkData
 		}
+		fs.stats.MemoryUsage += msg.Size()
+		fs.stats.StoredBytes += msg.Size()
 	}
 
 	return nil
@@ -402,55 +942,123 @@ func (fs *FileStorage) Load() error {
 
 // QueueManager adds queue semantics on top of storage
 type QueueManager struct {
-	storage Storage
-	mu      sync.Mutex
+	storage  Storage
+	mu       sync.Mutex
+	staging  map[string]*PublishTxn // Open publish transactions by message ID
+	burns    *burnTracker           // Fetches of burn-after-reading messages
+	maxBatch int                    // Messages per ConsumeMessages call (0 = all, see priority.go)
+	groupMu  sync.Mutex             // Serializes group discovery (see groups.go)
+
+	visibility time.Duration // Default visibility timeout (0 = none, see visibility.go)
+	redelivery chan struct{} // Closed to stop redelivery sweeps
+
+	commitMu sync.Mutex // Serializes duplicate checks with stores
 }
 
 // NewQueueManager creates a queue manager
 func NewQueueManager(storage Storage) *QueueManager {
 	return &QueueManager{
 		storage: storage,
+		staging: make(map[string]*PublishTxn),
+		burns:   newBurnTracker(),
 	}
 }
 
-// PublishMessage adds a new message to the queue
-func (qm *QueueManager) PublishMessage(id string, chunks map[string]string, manifest string) error {
-	msg := &Message{
-		ID:          id,
-		Chunks:      chunks,
-		TotalChunks: len(chunks),
-		Manifest:    manifest,
-		CreatedAt:   time.Now(),
-		State:       StateNew,
+// PublishOptions are the sender's settings for a published message
+type PublishOptions struct {
+	Tags      []string // Labels receivers can filter discovery by
+	Recipient string   // Client ID that alone discovers the message ("" = any)
+	Burn      bool     // Delete once fully fetched or acknowledged
+	Priority  int      // PRIORITY_LOW to PRIORITY_URGENT
+
+	Visibility time.Duration // Redelivered unless acknowledged within (0 = queue default)
+}
+
+// PublishMessage adds a new message to the queue in a single transaction
+func (qm *QueueManager) PublishMessage(id string, chunks map[string]string, manifest string, tags ...string) error {
+	return qm.Publish(id, chunks, manifest, PublishOptions{Tags: tags})
+}
+
+// PublishBurnAfterReading adds a message that is deleted once its
+// receiver has fetched every chunk or acknowledged it
+func (qm *QueueManager) PublishBurnAfterReading(id string, chunks map[string]string, manifest string, tags ...string) error {
+	return qm.Publish(id, chunks, manifest, PublishOptions{Tags: tags, Burn: true})
+}
+
+// Publish stages and commits a message with its options in one transaction
+func (qm *QueueManager) Publish(id string, chunks map[string]string, manifest string, options PublishOptions) error {
+	txn, err := qm.Begin(id)
+	if err != nil {
+		return err
+	}
+	txn.SetBurn(options.Burn)
+	if err := txn.SetVisibility(options.Visibility); err != nil {
+		txn.Abort()
+		return err
 	}
+	if err := txn.SetPriority(options.Priority); err != nil {
+		txn.Abort()
+		return err
+	}
+
+	if err := txn.SetTags(options.Tags); err != nil {
+		txn.Abort()
+		return err
+	}
+	if err := txn.SetRecipient(options.Recipient); err != nil {
+		txn.Abort()
+		return err
+	}
+
+	for name, data := range chunks {
+		if err := txn.AddChunk(name, data); err != nil {
+			txn.Abort()
+			return err
+		}
+	}
+	txn.SetManifest(manifest)
 
-	return qm.storage.StoreMessage(msg)
+	return txn.Commit()
 }
 
-// ConsumeMessages gets new messages for a client
-func (qm *QueueManager) ConsumeMessages(clientID string) ([]*Message, error) {
+// ConsumeMessages gets new messages for a client, only those addressed to
+// it or nobody and carrying every tag given, in queue order and at most
+// one batch (see priority.go)
+func (qm *QueueManager) ConsumeMessages(client ClientInfo, tags ...string) ([]*Message, error) {
 	// LESSON: Consumer Pattern
 	// 1. Get new messages
 	// 2. Mark as delivered
 	// 3. Client processes
 	// 4. Client acknowledges (mark consumed)
 
-	messages, err := qm.storage.GetNewMessages(clientID)
+	messages, err := qm.storage.GetNewMessages(client.ID)
 	if err != nil {
 		return nil, err
 	}
+	messages = qm.batch(FilterByTags(FilterByRecipient(messages, client.ID), tags))
 
 	// Mark all as delivered
 	for _, msg := range messages {
-		qm.storage.MarkAsDelivered(msg.ID, clientID)
+		qm.storage.MarkAsDelivered(msg.ID, client)
 	}
 
 	return messages, nil
 }
 
-// AcknowledgeMessage marks a message as consumed
+// AcknowledgeMessage marks a message as consumed, burning it if it was
+// uploaded with burn. Acknowledging a message that already burned succeeds.
 func (qm *QueueManager) AcknowledgeMessage(msgID, clientID string) error {
-	return qm.storage.MarkAsConsumed(msgID, clientID)
+	if qm.Burned(msgID) {
+		return nil
+	}
+	if err := qm.storage.MarkAsConsumed(msgID, clientID); err != nil {
+		return err
+	}
+
+	if msg, err := qm.storage.GetMessage(msgID); err == nil && msg.Burn {
+		qm.burn(msgID)
+	}
+	return nil
 }
 
 // GetMessageStatus returns current state of a message
@@ -0,0 +1,88 @@
+package dnsserver
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+)
+
+// testMessage builds a message of n chunks named as the server stores them
+func testMessage(id string, n int) *Message {
+	chunks := make(map[string]string, n)
+	for i := 0; i < n; i++ {
+		chunks[fmt.Sprintf("c-%d-%s", i, id)] = fmt.Sprintf("chunk %d", i)
+	}
+	return &Message{ID: id, Chunks: chunks, TotalChunks: n}
+}
+
+// Stored messages are read without the storage lock (GetMessage,
+// ListMessages), so recording fetches must never change a message a reader
+// holds. Run with -race.
+func TestRecordFetchWhileReading(t *testing.T) {
+	const chunks = 200
+	storage := NewMemoryStorage()
+	if err := storage.StoreMessage(testMessage("race", chunks)); err != nil {
+		t.Fatalf("StoreMessage: %v", err)
+	}
+	qm := NewQueueManager(storage)
+
+	held, err := storage.GetMessage("race")
+	if err != nil {
+		t.Fatalf("GetMessage: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	for c := 0; c < 4; c++ {
+		client := ClientInfo{ID: fmt.Sprintf("client%d", c)}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < chunks; i++ {
+				msg, err := storage.GetMessage("race")
+				if err != nil {
+					t.Errorf("GetMessage: %v", err)
+					return
+				}
+				if _, err := qm.RecordFetch(msg, client, fmt.Sprintf("c-%d-race", i)); err != nil {
+					t.Errorf("RecordFetch: %v", err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < chunks; i++ {
+			if _, err := qm.Progress("race"); err != nil {
+				t.Errorf("Progress: %v", err)
+				return
+			}
+			messages, _ := storage.ListMessages()
+			for _, msg := range messages {
+				metadata := *msg
+				_ = Coverage(&metadata)
+			}
+		}
+	}()
+	wg.Wait()
+
+	if len(held.Consumers) != 0 || held.State != StateNew {
+		t.Errorf("message held before the fetches changed: %d consumers, state %s", len(held.Consumers), held.State)
+	}
+	coverage, err := qm.Progress("race")
+	if err != nil {
+		t.Fatalf("Progress: %v", err)
+	}
+	if len(coverage.Clients) != 4 {
+		t.Fatalf("coverage lists %d clients, want 4", len(coverage.Clients))
+	}
+	for _, client := range coverage.Clients {
+		if client.Fetched != chunks {
+			t.Errorf("%s fetched %d chunks, want %d", client.ClientID, client.Fetched, chunks)
+		}
+	}
+	if msg, _ := storage.GetMessage("race"); msg.State != StateDelivered {
+		t.Errorf("state %s after every chunk was served, want %s", msg.State, StateDelivered)
+	}
+}
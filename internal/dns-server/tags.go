@@ -0,0 +1,153 @@
+package dnsserver
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ================================================================================
+// MESSAGE TAGS
+// Free-form labels senders attach so receivers can subscribe selectively
+// ================================================================================
+
+// LESSON: Tags as DNS Labels
+// A receiver that only handles one campaign shouldn't have to fetch every
+// message to find out it isn't interested. Uploads can carry tags
+// ("campaign-q3", "priority-high", "image"), and discovery returns only the
+// messages carrying every tag asked for. Discovery also runs over DNS, where
+// the tags travel as labels in front of the consume label:
+//
+//   <tag>.<tag>.consume.<client>.<domain>
+//
+// so a tag is restricted to what a label can hold without escaping:
+// lowercase letters, digits, '-' and '_'. Messages a filter skips are not
+// marked delivered, so a receiver with another filter still discovers them.
+
+const (
+	// MAX_TAGS caps the tags on one message (and in one filter)
+	MAX_TAGS = 8
+
+	// MAX_TAG_LENGTH is the longest tag, one DNS label
+	MAX_TAG_LENGTH = 63
+
+	// CONSUME_LABEL marks a discovery query (consume-<group> for a consumer
+	// group, see groups.go)
+	CONSUME_LABEL = "consume"
+)
+
+// NormalizeTags validates tags and returns them lowercased, deduplicated and sorted
+func NormalizeTags(tags []string) ([]string, error) {
+	seen := make(map[string]bool)
+	var normalized []string
+
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" || seen[tag] {
+			continue
+		}
+		if len(tag) > MAX_TAG_LENGTH {
+			return nil, fmt.Errorf("tag %q is longer than %d characters", tag, MAX_TAG_LENGTH)
+		}
+		for _, r := range tag {
+			if !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '-' || r == '_') {
+				return nil, fmt.Errorf("tag %q contains %q (allowed: a-z, 0-9, '-', '_')", tag, r)
+			}
+		}
+		seen[tag] = true
+		normalized = append(normalized, tag)
+	}
+
+	if len(normalized) > MAX_TAGS {
+		return nil, fmt.Errorf("%d tags given, at most %d allowed", len(normalized), MAX_TAGS)
+	}
+
+	sort.Strings(normalized)
+	return normalized, nil
+}
+
+// ParseTags parses a comma-separated tag list, e.g. "campaign-q3,image"
+func ParseTags(spec string) ([]string, error) {
+	if strings.TrimSpace(spec) == "" {
+		return nil, nil
+	}
+	return NormalizeTags(strings.Split(spec, ","))
+}
+
+// HasTags reports whether the message carries every tag in filter
+// (an empty filter matches every message)
+func (m *Message) HasTags(filter []string) bool {
+	for _, want := range filter {
+		found := false
+		for _, tag := range m.Tags {
+			if tag == want {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// FilterByTags keeps the messages carrying every tag in filter
+func FilterByTags(messages []*Message, filter []string) []*Message {
+	if len(filter) == 0 {
+		return messages
+	}
+
+	var matched []*Message
+	for _, msg := range messages {
+		if msg.HasTags(filter) {
+			matched = append(matched, msg)
+		}
+	}
+	return matched
+}
+
+// ConsumeName returns the discovery query name for a client, in a consumer
+// group ("" = none), filtered by tags
+func ConsumeName(clientID, group, domain string, tags []string) string {
+	labels := append(append([]string{}, tags...), withGroup(CONSUME_LABEL, group), clientID, strings.TrimSuffix(domain, "."))
+	return strings.Join(labels, ".")
+}
+
+// IsConsumeName reports whether a query name is a discovery query
+func IsConsumeName(qname string) bool {
+	_, i := consumeLabel(qname)
+	return i >= 0
+}
+
+// ConsumeTags extracts the tag filter from a discovery query name
+func ConsumeTags(qname string) ([]string, error) {
+	labels, i := consumeLabel(qname)
+	if i < 0 {
+		return nil, fmt.Errorf("%s is not a discovery query", qname)
+	}
+	return NormalizeTags(labels[:i])
+}
+
+// ConsumeGroup extracts the consumer group of a discovery query name ("" =
+// none)
+func ConsumeGroup(qname string) string {
+	labels, i := consumeLabel(qname)
+	if i < 0 {
+		return ""
+	}
+	group, _ := groupLabel(labels[i], CONSUME_LABEL)
+	return group
+}
+
+// consumeLabel splits a query name into lowercase labels and finds the
+// consume label among them (-1 if none)
+func consumeLabel(qname string) ([]string, int) {
+	labels := strings.Split(strings.ToLower(strings.TrimSuffix(qname, ".")), ".")
+	for i, label := range labels {
+		if _, ok := groupLabel(label, CONSUME_LABEL); ok {
+			return labels, i
+		}
+	}
+	return labels, -1
+}
@@ -0,0 +1,212 @@
+package dnsserver
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ================================================================================
+// TRANSACTIONAL PUBLISH
+// Stages a message's chunks and makes them visible all at once
+// ================================================================================
+
+// LESSON: Why Stage?
+// A receiver that queries while a message is half-published sees some
+// chunks and NXDOMAIN for the rest, retries, and may give up on a message
+// that would have been complete a second later. Uploads are therefore
+// collected in a staging area that DNS lookups never read. Commit hands the
+// finished message to storage in one StoreMessage call, which is atomic for
+// every backend (FileStorage only exposes it once it is on disk,
+// BoltStorage writes it in one database transaction). A crash
+// before commit loses the staged upload, never half of a published one.
+
+// ErrTxnClosed is returned when a committed or aborted transaction is reused
+var ErrTxnClosed = errors.New("publish transaction already closed")
+
+// PublishTxn is a message being assembled in the staging area
+type PublishTxn struct {
+	queue    *QueueManager
+	id       string
+	chunks   map[string]string
+	manifest string
+	tags     []string
+	to       string // Recipient (see recipients.go)
+	burn     bool
+	priority int
+	visible  time.Duration // Visibility timeout (see visibility.go)
+	closed   bool
+	mu       sync.Mutex
+}
+
+// Begin opens a publish transaction for a new message ID
+func (qm *QueueManager) Begin(id string) (*PublishTxn, error) {
+	if id == "" {
+		return nil, errors.New("message ID is required")
+	}
+
+	qm.mu.Lock()
+	defer qm.mu.Unlock()
+
+	if _, staged := qm.staging[id]; staged {
+		return nil, fmt.Errorf("message %s is already being published", id)
+	}
+
+	txn := &PublishTxn{
+		queue:  qm,
+		id:     id,
+		chunks: make(map[string]string),
+	}
+	qm.staging[id] = txn
+
+	return txn, nil
+}
+
+// ID returns the message ID being published
+func (t *PublishTxn) ID() string {
+	return t.id
+}
+
+// AddChunk stages a chunk; re-adding a name replaces its data
+func (t *PublishTxn) AddChunk(name, data string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.closed {
+		return ErrTxnClosed
+	}
+	if name == "" {
+		return errors.New("chunk name is required")
+	}
+
+	t.chunks[name] = data
+	return nil
+}
+
+// SetManifest stages the manifest record
+func (t *PublishTxn) SetManifest(manifest string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.manifest = manifest
+}
+
+// SetTags stages the message's tags, replacing any set before
+func (t *PublishTxn) SetTags(tags []string) error {
+	normalized, err := NormalizeTags(tags)
+	if err != nil {
+		return err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.tags = normalized
+	return nil
+}
+
+// SetRecipient stages the client ID the message is addressed to ("" = any)
+func (t *PublishTxn) SetRecipient(clientID string) error {
+	normalized, err := NormalizeRecipient(clientID)
+	if err != nil {
+		return err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.to = normalized
+	return nil
+}
+
+// SetBurn stages whether the message burns after reading (see burn.go)
+func (t *PublishTxn) SetBurn(burn bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.burn = burn
+}
+
+// SetPriority stages the message's discovery priority (see priority.go)
+func (t *PublishTxn) SetPriority(priority int) error {
+	if priority < PRIORITY_LOW || priority > PRIORITY_URGENT {
+		return fmt.Errorf("priority %d outside %d to %d", priority, PRIORITY_LOW, PRIORITY_URGENT)
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.priority = priority
+	return nil
+}
+
+// SetVisibility stages the message's visibility timeout (see visibility.go)
+func (t *PublishTxn) SetVisibility(timeout time.Duration) error {
+	if timeout < 0 {
+		return fmt.Errorf("visibility timeout %v is negative", timeout)
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.visible = timeout
+	return nil
+}
+
+// Commit publishes every staged chunk at once and closes the transaction.
+// On failure nothing becomes visible and the transaction is closed. A
+// message whose content is already stored returns a *DuplicateError.
+func (t *PublishTxn) Commit() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.closed {
+		return ErrTxnClosed
+	}
+	t.closed = true
+	defer t.queue.unstage(t.id)
+
+	if len(t.chunks) == 0 {
+		return fmt.Errorf("message %s has no chunks", t.id)
+	}
+
+	msg := &Message{
+		ID:          t.id,
+		Chunks:      t.chunks,
+		TotalChunks: len(t.chunks),
+		Manifest:    t.manifest,
+		CreatedAt:   time.Now(),
+		State:       StateNew,
+		Digest:      ContentDigest(t.chunks),
+		Tags:        t.tags,
+		Recipient:   t.to,
+		Burn:        t.burn,
+		Priority:    t.priority,
+		Visibility:  t.visible,
+	}
+
+	// The duplicate check and the store must not interleave with another commit
+	t.queue.commitMu.Lock()
+	defer t.queue.commitMu.Unlock()
+
+	if err := t.queue.checkDuplicate(msg); err != nil {
+		return err
+	}
+
+	return t.queue.storage.StoreMessage(msg)
+}
+
+// Abort discards the staged chunks
+func (t *PublishTxn) Abort() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.closed {
+		return
+	}
+	t.closed = true
+	t.chunks = nil
+	t.queue.unstage(t.id)
+}
+
+// unstage removes a closed transaction from the staging area
+func (qm *QueueManager) unstage(id string) {
+	qm.mu.Lock()
+	defer qm.mu.Unlock()
+	delete(qm.staging, id)
+}
@@ -0,0 +1,210 @@
+package dnsserver
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ================================================================================
+// UPLOAD ASSEMBLY
+// Collects messages that arrive one part per query (QNAME uploads)
+// ================================================================================
+
+// LESSON: Assembling Without a Session
+// An HTTP upload hands over a whole message in one request. A QNAME upload
+// arrives as hundreds of independent queries, possibly through different
+// resolvers, out of order and with retransmissions. The assembler keeps the
+// parts of each unfinished message in memory until the manifest (which
+// carries the chunk count) and every chunk are present, then hands the
+// complete set back exactly once. Options (tags, burn, priority) may arrive
+// before that and travel with it. Senders that vanish halfway must not pin
+// memory forever, so unfinished uploads expire and their number is capped.
+
+const (
+	// DEFAULT_UPLOAD_TIMEOUT drops uploads that received no part for this long
+	DEFAULT_UPLOAD_TIMEOUT = 10 * time.Minute
+
+	// DEFAULT_MAX_PENDING_UPLOADS caps unfinished uploads held at once
+	DEFAULT_MAX_PENDING_UPLOADS = 64
+
+	// MAX_UPLOAD_CHUNKS caps the chunks of one assembled message
+	MAX_UPLOAD_CHUNKS = 1 << 16
+)
+
+var (
+	// ErrTooManyUploads means the pending upload limit is reached
+	ErrTooManyUploads = errors.New("too many unfinished uploads")
+
+	// ErrUploadTooLarge means a part lies beyond MAX_UPLOAD_CHUNKS
+	ErrUploadTooLarge = fmt.Errorf("upload exceeds %d chunks", MAX_UPLOAD_CHUNKS)
+)
+
+// CompletedUpload is a fully assembled message
+type CompletedUpload struct {
+	MessageID string
+	Chunks    []string // Encoded chunks in manifest order
+	Manifest  string
+	Options   PublishOptions
+}
+
+// pendingUpload holds the parts received so far
+type pendingUpload struct {
+	chunks   map[int]string
+	manifest string
+	total    int // From the manifest (0 until it arrives)
+	options  PublishOptions
+	updated  time.Time
+}
+
+// UploadAssembler collects upload parts per message
+type UploadAssembler struct {
+	pending    map[string]*pendingUpload
+	timeout    time.Duration
+	maxPending int
+	mu         sync.Mutex
+}
+
+// NewUploadAssembler creates an assembler holding at most maxPending
+// unfinished uploads, each dropped after timeout without a new part
+func NewUploadAssembler(timeout time.Duration, maxPending int) *UploadAssembler {
+	if timeout <= 0 {
+		timeout = DEFAULT_UPLOAD_TIMEOUT
+	}
+	if maxPending <= 0 {
+		maxPending = DEFAULT_MAX_PENDING_UPLOADS
+	}
+	return &UploadAssembler{
+		pending:    make(map[string]*pendingUpload),
+		timeout:    timeout,
+		maxPending: maxPending,
+	}
+}
+
+// AddChunk stores the chunk for slot index, returning the message once complete
+func (ua *UploadAssembler) AddChunk(msgID string, index int, data string) (*CompletedUpload, error) {
+	if index < 0 || index >= MAX_UPLOAD_CHUNKS {
+		return nil, ErrUploadTooLarge
+	}
+
+	ua.mu.Lock()
+	defer ua.mu.Unlock()
+
+	upload, err := ua.get(msgID)
+	if err != nil {
+		return nil, err
+	}
+	if upload.total > 0 && index >= upload.total {
+		return nil, fmt.Errorf("chunk %d beyond the manifest's %d chunks", index, upload.total)
+	}
+
+	upload.chunks[index] = data
+	return ua.complete(msgID, upload), nil
+}
+
+// SetManifest stores the manifest (announcing total chunks), returning the
+// message once complete
+func (ua *UploadAssembler) SetManifest(msgID, manifest string, total int) (*CompletedUpload, error) {
+	if total <= 0 || total > MAX_UPLOAD_CHUNKS {
+		return nil, fmt.Errorf("manifest announces %d chunks (1-%d allowed)", total, MAX_UPLOAD_CHUNKS)
+	}
+
+	ua.mu.Lock()
+	defer ua.mu.Unlock()
+
+	upload, err := ua.get(msgID)
+	if err != nil {
+		return nil, err
+	}
+	for index := range upload.chunks {
+		if index >= total {
+			delete(upload.chunks, index)
+		}
+	}
+
+	upload.manifest = manifest
+	upload.total = total
+	return ua.complete(msgID, upload), nil
+}
+
+// SetOptions stores the upload options of a message that isn't complete yet
+func (ua *UploadAssembler) SetOptions(msgID string, options PublishOptions) error {
+	ua.mu.Lock()
+	defer ua.mu.Unlock()
+
+	upload, err := ua.get(msgID)
+	if err != nil {
+		return err
+	}
+	upload.options = options
+	return nil
+}
+
+// Progress returns how many chunks of an unfinished upload arrived and how
+// many the manifest announced (0 until it arrives)
+func (ua *UploadAssembler) Progress(msgID string) (int, int) {
+	ua.mu.Lock()
+	defer ua.mu.Unlock()
+
+	upload, exists := ua.pending[msgID]
+	if !exists {
+		return 0, 0
+	}
+	return len(upload.chunks), upload.total
+}
+
+// Pending returns the number of unfinished uploads
+func (ua *UploadAssembler) Pending() int {
+	ua.mu.Lock()
+	defer ua.mu.Unlock()
+
+	ua.expire()
+	return len(ua.pending)
+}
+
+// get returns the pending upload for a message, starting one if there is room
+func (ua *UploadAssembler) get(msgID string) (*pendingUpload, error) {
+	upload, exists := ua.pending[msgID]
+	if !exists {
+		ua.expire()
+		if len(ua.pending) >= ua.maxPending {
+			return nil, ErrTooManyUploads
+		}
+		upload = &pendingUpload{chunks: make(map[int]string)}
+		ua.pending[msgID] = upload
+	}
+
+	upload.updated = time.Now()
+	return upload, nil
+}
+
+// complete hands back the message when every part is present
+func (ua *UploadAssembler) complete(msgID string, upload *pendingUpload) *CompletedUpload {
+	if upload.total == 0 || len(upload.chunks) < upload.total {
+		return nil
+	}
+
+	done := &CompletedUpload{
+		MessageID: msgID,
+		Chunks:    make([]string, upload.total),
+		Manifest:  upload.manifest,
+		Options:   upload.options,
+	}
+	for index, data := range upload.chunks {
+		done.Chunks[index] = data
+	}
+
+	delete(ua.pending, msgID)
+	return done
+}
+
+// expire drops uploads idle for longer than the timeout
+func (ua *UploadAssembler) expire() {
+	cutoff := time.Now().Add(-ua.timeout)
+	for msgID, upload := range ua.pending {
+		if upload.updated.Before(cutoff) {
+			delete(ua.pending, msgID)
+		}
+	}
+}
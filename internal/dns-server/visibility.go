@@ -0,0 +1,227 @@
+package dnsserver
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ================================================================================
+// VISIBILITY TIMEOUT
+// Returns messages a receiver took but never acknowledged to the new queue
+// ================================================================================
+
+// LESSON: Leases, Not Deliveries
+// Discovery hides a message from the client that asked: the next discovery
+// query won't list it again. If that receiver crashes before fetching the
+// chunks, or after fetching but before acknowledging, nobody ever sees the
+// message again - it sits in new or delivered until the GC removes it.
+//
+// With a visibility timeout, discovery is a lease instead (like SQS):
+//
+//   1. A receiver discovers (or starts fetching) the message: the lease starts
+//   2. It acknowledges within the timeout: consumed, done
+//   3. It doesn't: the message goes back to new with its deliveries
+//      forgotten, and the next discovery query - from any receiver - lists
+//      it again
+//
+// Consumer groups hold a lease of their own (see groups.go): a group whose
+// member doesn't acknowledge in time gets the message back, for any member,
+// while other groups keep their cursors.
+//
+// That makes delivery at-least-once: a slow receiver that acknowledges just
+// after the timeout may get the message a second time, so receivers should
+// tolerate duplicates (the content digest identifies them). The timeout is
+// per message - the sender knows how long its receiver needs - falling back
+// to the server's default; zero on both leaves leases off, as before.
+//
+// Receivers acknowledge over the API (/consume) or with a query for
+// ack[-<group>].<message ID>.<client ID>.<domain>.
+
+const (
+	// VISIBILITY_SWEEP_INTERVAL is how often expired leases are looked for
+	VISIBILITY_SWEEP_INTERVAL = 30 * time.Second
+
+	// ACK_LABEL marks an acknowledgement query
+	ACK_LABEL = "ack"
+)
+
+// AckName returns the query name a client acknowledges a message with, in
+// a consumer group ("" = none)
+func AckName(msgID, clientID, group, domain string) string {
+	return strings.Join([]string{withGroup(ACK_LABEL, group), msgID, clientID, strings.TrimSuffix(domain, ".")}, ".")
+}
+
+// ParseAckName extracts the message and client IDs and the consumer group
+// ("" = none) from an acknowledgement query name
+func ParseAckName(qname, domain string) (msgID, clientID, group string, ok bool) {
+	rest, found := strings.CutSuffix(strings.TrimSuffix(qname, "."), "."+strings.TrimSuffix(domain, "."))
+	if !found {
+		return "", "", "", false
+	}
+	labels := strings.Split(rest, ".")
+	if len(labels) != 3 || labels[1] == "" || labels[2] == "" {
+		return "", "", "", false
+	}
+	if group, ok = groupLabel(labels[0], ACK_LABEL); !ok {
+		return "", "", "", false
+	}
+	return labels[1], labels[2], group, true
+}
+
+// ParseVisibility parses a visibility timeout, e.g. "10m" or "1d" ("" or
+// "0" = the server's default)
+func ParseVisibility(spec string) (time.Duration, error) {
+	spec = strings.TrimSpace(spec)
+	if spec == "" {
+		return 0, nil
+	}
+	timeout, err := parseGCDuration(spec)
+	if err != nil {
+		return 0, fmt.Errorf("invalid visibility timeout %q: %w", spec, err)
+	}
+	if timeout < 0 {
+		return 0, fmt.Errorf("visibility timeout %q is negative", spec)
+	}
+	return timeout, nil
+}
+
+// leasedAt returns when the first receiver discovered or started fetching
+// a message, if anyone did
+func (m *Message) leasedAt() (time.Time, bool) {
+	var first time.Time
+	for _, record := range m.Consumers {
+		if first.IsZero() || record.FetchedAt.Before(first) {
+			first = record.FetchedAt
+		}
+	}
+	return first, !first.IsZero()
+}
+
+// SetVisibilityTimeout sets the timeout of messages uploaded without their
+// own (0 = no redelivery)
+func (qm *QueueManager) SetVisibilityTimeout(timeout time.Duration) {
+	qm.mu.Lock()
+	defer qm.mu.Unlock()
+	qm.visibility = timeout
+}
+
+// visibilityOf returns a message's visibility timeout (0 = none)
+func (qm *QueueManager) visibilityOf(msg *Message) time.Duration {
+	if msg.Visibility > 0 {
+		return msg.Visibility
+	}
+	qm.mu.Lock()
+	defer qm.mu.Unlock()
+	return qm.visibility
+}
+
+// leaseExpired reports whether a message was taken but not acknowledged
+// within its visibility timeout
+func (qm *QueueManager) leaseExpired(msg *Message, now time.Time) bool {
+	if msg.State != StateNew && msg.State != StateDelivered {
+		return false
+	}
+	timeout := qm.visibilityOf(msg)
+	if timeout <= 0 {
+		return false
+	}
+	leased, ok := msg.leasedAt()
+	return ok && now.Sub(leased) >= timeout
+}
+
+// RedeliverExpired moves every message whose lease expired back to new,
+// returning their IDs - as <group>/<ID> for a lease of a consumer group
+func (qm *QueueManager) RedeliverExpired(now time.Time) ([]string, error) {
+	messages, err := qm.storage.ListMessages()
+	if err != nil {
+		return nil, err
+	}
+
+	var redelivered []string
+	for _, listed := range messages {
+		redelivered = append(redelivered, qm.redeliverGroups(listed, now)...)
+		if !qm.leaseExpired(listed, now) {
+			continue
+		}
+
+		// Look again right before requeueing: the receiver may just have
+		// acknowledged it
+		msg, err := qm.storage.GetMessage(listed.ID)
+		if err != nil || !qm.leaseExpired(msg, now) {
+			continue
+		}
+		if err := qm.storage.SetState(msg.ID, StateNew); err != nil {
+			storageLog.Warn("⚠️  Failed to redeliver message", "msg_id", msg.ID, "err", err)
+			continue
+		}
+		redelivered = append(redelivered, msg.ID)
+	}
+	return redelivered, nil
+}
+
+// redeliverGroups returns a message to the consumer groups whose lease on
+// it expired, returning <group>/<ID> for each
+func (qm *QueueManager) redeliverGroups(listed *Message, now time.Time) []string {
+	if len(qm.groupLeasesExpired(listed, now)) == 0 {
+		return nil
+	}
+	msg, err := qm.storage.GetMessage(listed.ID)
+	if err != nil {
+		return nil
+	}
+
+	var redelivered []string
+	for _, group := range qm.groupLeasesExpired(msg, now) {
+		if err := qm.storage.SetGroupState(msg.ID, group, "", StateNew); err != nil {
+			storageLog.Warn("⚠️  Failed to redeliver message", "msg_id", msg.ID, "group", group, "err", err)
+			continue
+		}
+		redelivered = append(redelivered, group+"/"+msg.ID)
+	}
+	return redelivered
+}
+
+// StartRedelivery looks for expired leases every interval until
+// StopRedelivery. onRedeliver (optional) receives the IDs of every sweep
+// that moved messages.
+func (qm *QueueManager) StartRedelivery(interval time.Duration, onRedeliver func(ids []string)) {
+	qm.mu.Lock()
+	defer qm.mu.Unlock()
+	if qm.redelivery != nil {
+		return
+	}
+	stop := make(chan struct{})
+	qm.redelivery = stop
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case now := <-ticker.C:
+				ids, err := qm.RedeliverExpired(now)
+				if err != nil {
+					storageLog.Warn("⚠️  Redelivery sweep failed", "err", err)
+					continue
+				}
+				if len(ids) > 0 && onRedeliver != nil {
+					onRedeliver(ids)
+				}
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+// StopRedelivery ends the sweeps started by StartRedelivery
+func (qm *QueueManager) StopRedelivery() {
+	qm.mu.Lock()
+	defer qm.mu.Unlock()
+	if qm.redelivery != nil {
+		close(qm.redelivery)
+		qm.redelivery = nil
+	}
+}
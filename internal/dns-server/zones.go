@@ -0,0 +1,128 @@
+package dnsserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// ================================================================================
+// ZONES
+// More domains served by one server, each a channel of its own
+// ================================================================================
+
+// LESSON: One Server, Several Channels
+// Running a server per channel means a machine, an address and a
+// delegation per channel. A server can answer for several zones instead,
+// keeping them apart where it matters:
+//
+//   Storage  - each zone stores its messages in a namespace of its own (a
+//              separate data file or database), so a listing, a
+//              clean-up or a leaked key of one never touches another
+//   API keys - uploads and discovery are authorized per zone, and QNAME
+//              uploads are signed with a per-zone qname_key
+//   TTLs     - each zone picks how long resolvers cache it
+//   SOA/NS   - each zone has its own authority data
+//
+// Listeners, query limits, rate limits, the ACL and decoys are shared:
+// they protect the server, not a channel. DNS queries find their zone by
+// name (the longest matching domain); HTTP API requests name it with
+// ?zone=<domain> and default to the -domain zone.
+//
+// Zone file (JSON), added to the zone the flags configure:
+//
+//   {
+//     "zones": [
+//       {"domain": "cdn-assets.example.net", "ttl": "chunk=1h", "api_keys": "assets-keys.json",
+//        "qname_key": "assets-upload-secret", "ns": "ns1=192.0.2.53", "soa": "minimum=1m",
+//        "storage": "assets"}
+//     ]
+//   }
+
+// ZoneConfig is one zone beyond the -domain one
+type ZoneConfig struct {
+	Domain   string `json:"domain"`
+	Storage  string `json:"storage"`   // Storage namespace ("" = the domain)
+	TTL      string `json:"ttl"`       // TTL policy spec ("" = the -ttl one)
+	APIKeys  string `json:"api_keys"`  // API key file ("" = the -api-keys one)
+	QNameKey string `json:"qname_key"` // Secret signing QNAME uploads ("" = the -qname-key one)
+	NS       string `json:"ns"`        // Name server spec (see ParseAuthority)
+	SOA      string `json:"soa"`       // SOA spec (see ParseAuthority)
+}
+
+// ParseZones reads zone configurations from JSON; primary is the domain
+// the server already serves, which no zone may repeat
+func ParseZones(data []byte, primary string) ([]ZoneConfig, error) {
+	var file struct {
+		Zones []ZoneConfig `json:"zones"`
+	}
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("invalid zones: %w", err)
+	}
+
+	domains := map[string]bool{strings.ToLower(strings.TrimSuffix(primary, ".")): true}
+	namespaces := make(map[string]bool)
+	for i := range file.Zones {
+		zone := &file.Zones[i]
+		zone.Domain = strings.ToLower(strings.Trim(strings.TrimSpace(zone.Domain), "."))
+		if zone.Domain == "" {
+			return nil, fmt.Errorf("zone %d has no domain", i+1)
+		}
+		if domains[zone.Domain] {
+			return nil, fmt.Errorf("zone %s listed twice", zone.Domain)
+		}
+		domains[zone.Domain] = true
+
+		if zone.Storage == "" {
+			zone.Storage = zone.Domain
+		}
+		if err := checkNamespace(zone.Storage); err != nil {
+			return nil, fmt.Errorf("zone %s: %w", zone.Domain, err)
+		}
+		if namespaces[zone.Storage] {
+			return nil, fmt.Errorf("zone %s: storage namespace %q is used by another zone", zone.Domain, zone.Storage)
+		}
+		namespaces[zone.Storage] = true
+
+		if _, err := ParseAuthority(zone.Domain, zone.NS, zone.SOA); err != nil {
+			return nil, fmt.Errorf("zone %s: %w", zone.Domain, err)
+		}
+	}
+	return file.Zones, nil
+}
+
+// LoadZones reads a zone file
+func LoadZones(path, primary string) ([]ZoneConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read zones: %w", err)
+	}
+	return ParseZones(data, primary)
+}
+
+// checkNamespace accepts namespaces that are safe in file names
+func checkNamespace(namespace string) error {
+	for _, r := range namespace {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_' || r == '.') {
+			return fmt.Errorf("storage namespace %q may only hold letters, digits, '-', '_' and '.'", namespace)
+		}
+	}
+	if strings.HasPrefix(namespace, ".") {
+		return fmt.Errorf("storage namespace %q can't start with '.'", namespace)
+	}
+	return nil
+}
+
+// NamespacedPath inserts a storage namespace into a data file's name, before
+// its extension: dns_data.json -> dns_data.assets.json ("" = unchanged)
+func NamespacedPath(path, namespace string) string {
+	if namespace == "" {
+		return path
+	}
+	dot := strings.LastIndex(path, ".")
+	if dot <= strings.LastIndex(path, "/") {
+		return path + "." + namespace
+	}
+	return path[:dot] + "." + namespace + path[dot:]
+}
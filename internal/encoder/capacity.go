@@ -0,0 +1,133 @@
+package encoder
+
+import (
+	"fmt"
+	"github.com/faanross/simulacra_txt/internal/spec"
+	"math"
+)
+
+// ================================================================================
+// CAPACITY AND DETECTABILITY
+// How much a carrier can hold, and how risky it is to fill it
+// ================================================================================
+
+// LESSON: Why Utilization Matters
+// Steganalysis (chi-square, RS analysis) looks for LSB statistics that differ
+// from natural images. The more LSBs we overwrite, the stronger the signal:
+// a carrier at 10% is hard to flag, one at 100% is trivial. Two things drive
+// the risk:
+// 1. Utilization: the fraction of available LSBs carrying payload
+// 2. Embedding mode: sequential embedding packs all changes into the top rows,
+//    so a region-by-region comparison finds them; higher bit planes change
+//    visible colour values, not just noise
+// Above RISK_WARN_UTILIZATION the CLIs warn before sending a carrier.
+
+// EmbedMode describes how payload bits are placed in the carrier
+type EmbedMode int
+
+const (
+	EMBED_SEQUENTIAL EmbedMode = iota // Scan order from the top-left pixel (what CreateStegoImage does)
+	EMBED_SCATTERED                   // Spread across the whole carrier
+)
+
+const (
+	// RISK_WARN_UTILIZATION is the utilization above which CLIs warn
+	RISK_WARN_UTILIZATION = 0.5
+
+	// Risk score thresholds (0-100)
+	RISK_MODERATE = 25
+	RISK_HIGH     = 50
+	RISK_CRITICAL = 75
+)
+
+// CapacityOptions describes the encoder settings used for a carrier
+type CapacityOptions struct {
+	BitsPerChannel int       // LSBs used per colour channel (default 1)
+	Channels       int       // Colour channels used per pixel (default spec.CHANNELS)
+	Mode           EmbedMode // Bit placement
+}
+
+// CapacityReport is the result of AnalyzeCapacity
+type CapacityReport struct {
+	Width         int
+	Height        int
+	CapacityBits  int     // Total embeddable bits
+	CapacityBytes int     // Total embeddable bytes
+	PayloadBytes  int     // Bytes to embed, including the length header
+	Utilization   float64 // PayloadBytes / CapacityBytes (0-1, may exceed 1)
+	RiskScore     float64 // 0 (safe) - 100 (trivially detectable)
+	Fits          bool
+}
+
+// AnalyzeCapacity returns the capacity of a width x height carrier and the
+// detectability risk of embedding payloadBytes into it
+func AnalyzeCapacity(width, height, payloadBytes int, opts CapacityOptions) CapacityReport {
+	if opts.BitsPerChannel <= 0 {
+		opts.BitsPerChannel = 1
+	}
+	if opts.Channels <= 0 {
+		opts.Channels = spec.CHANNELS
+	}
+
+	report := CapacityReport{
+		Width:        width,
+		Height:       height,
+		CapacityBits: width * height * opts.Channels * opts.BitsPerChannel,
+		PayloadBytes: payloadBytes,
+	}
+	report.CapacityBytes = report.CapacityBits / spec.BITS_PER_BYTE
+	report.Fits = payloadBytes <= report.CapacityBytes
+
+	if report.CapacityBytes == 0 {
+		report.Utilization = math.Inf(1)
+		report.RiskScore = 100
+		return report
+	}
+	report.Utilization = float64(payloadBytes) / float64(report.CapacityBytes)
+
+	// Scale utilization by how visible the embedding mode is
+	multiplier := 1.0
+	if opts.Mode == EMBED_SEQUENTIAL {
+		multiplier *= 1.25
+	}
+	multiplier *= 1 + 0.6*float64(opts.BitsPerChannel-1)
+
+	report.RiskScore = math.Min(100, report.Utilization*100*multiplier)
+
+	return report
+}
+
+// RiskLevel names the band the risk score falls into
+func (r CapacityReport) RiskLevel() string {
+	switch {
+	case r.RiskScore >= RISK_CRITICAL:
+		return "critical"
+	case r.RiskScore >= RISK_HIGH:
+		return "high"
+	case r.RiskScore >= RISK_MODERATE:
+		return "moderate"
+	}
+	return "low"
+}
+
+// ShouldWarn reports whether the payload uses more than RISK_WARN_UTILIZATION of the LSBs
+func (r CapacityReport) ShouldWarn() bool {
+	return r.Utilization > RISK_WARN_UTILIZATION
+}
+
+// Print displays the report, with a warning above RISK_WARN_UTILIZATION
+func (r CapacityReport) Print() {
+	fmt.Printf("\n📐 Carrier Capacity:\n")
+	fmt.Printf("   Dimensions: %dx%d\n", r.Width, r.Height)
+	fmt.Printf("   Capacity: %d bytes (%d bits)\n", r.CapacityBytes, r.CapacityBits)
+	fmt.Printf("   Payload: %d bytes\n", r.PayloadBytes)
+	fmt.Printf("   Utilization: %.1f%%\n", r.Utilization*100)
+	fmt.Printf("   Detectability risk: %.0f/100 (%s)\n", r.RiskScore, r.RiskLevel())
+
+	if !r.Fits {
+		fmt.Printf("   ❌ Payload does not fit in this carrier\n")
+	} else if r.ShouldWarn() {
+		fmt.Printf("   ⚠️  Payload uses more than %.0f%% of the carrier's LSBs - consider a larger carrier\n",
+			RISK_WARN_UTILIZATION*100)
+	}
+}
@@ -33,7 +33,7 @@ func (sse *SecureStegoEncoder) EncryptMessage() (*scrypto.SecureMessage, error)
 	}
 
 	// Step 3: Derive key from password
-	key := scrypto.DeriveKey(sse.password, salt)
+	key := scrypto.DeriveKey(sse.password, salt, sse.kdf)
 
 	// Step 4: Create AES-GCM cipher
 	block, err := aes.NewCipher(key)
@@ -58,7 +58,12 @@ func (sse *SecureStegoEncoder) EncryptMessage() (*scrypto.SecureMessage, error)
 	copy(payload[4:], dataToEncrypt)
 
 	// Step 7: Encrypt with authentication
-	ciphertext := gcm.Seal(nil, nonce, payload, nil)
+	// The KDF header (if any) is authenticated along with the ciphertext
+	var aad []byte
+	if !sse.kdf.IsLegacy() {
+		aad = sse.kdf.MarshalHeader()
+	}
+	ciphertext := gcm.Seal(nil, nonce, payload, aad)
 
 	// The Seal function appends the auth tag to the ciphertext
 	// Split them for clarity
@@ -70,6 +75,7 @@ func (sse *SecureStegoEncoder) EncryptMessage() (*scrypto.SecureMessage, error)
 	fmt.Printf("   Auth tag: %X...\n", authTag[:4])
 
 	return &scrypto.SecureMessage{
+		KDF:            sse.kdf,
 		Salt:           salt,
 		Nonce:          nonce,
 		EncryptedData:  encryptedData,
@@ -88,9 +94,14 @@ func (sse *SecureStegoEncoder) PrepareSecurePayload() error {
 	}
 
 	// Create payload structure:
-	// [TotalLength(4)][Salt(32)][Nonce(12)][EncryptedData][AuthTag(16)]
+	// [TotalLength(4)][KDFHeader(14, optional)][Salt(32)][Nonce(12)][EncryptedData][AuthTag(16)]
+
+	var kdfHeader []byte
+	if !secMsg.KDF.IsLegacy() {
+		kdfHeader = secMsg.KDF.MarshalHeader()
+	}
 
-	totalSize := spec.SALT_SIZE + spec.NONCE_SIZE + len(secMsg.EncryptedData) + spec.TAG_SIZE
+	totalSize := len(kdfHeader) + spec.SALT_SIZE + spec.NONCE_SIZE + len(secMsg.EncryptedData) + spec.TAG_SIZE
 	payload := make([]byte, 4+totalSize)
 
 	// Write total length
@@ -98,6 +109,9 @@ func (sse *SecureStegoEncoder) PrepareSecurePayload() error {
 
 	// Write components
 	offset := 4
+	copy(payload[offset:], kdfHeader)
+	offset += len(kdfHeader)
+
 	copy(payload[offset:], secMsg.Salt)
 	offset += spec.SALT_SIZE
 
@@ -119,6 +133,9 @@ func (sse *SecureStegoEncoder) PrepareSecurePayload() error {
 
 	fmt.Printf("\n📦 Secure Payload Structure:\n")
 	fmt.Printf("   Header: 4 bytes\n")
+	if len(kdfHeader) > 0 {
+		fmt.Printf("   KDF: %d bytes (%s)\n", len(kdfHeader), secMsg.KDF)
+	}
 	fmt.Printf("   Salt: %d bytes\n", spec.SALT_SIZE)
 	fmt.Printf("   Nonce: %d bytes\n", spec.NONCE_SIZE)
 	fmt.Printf("   Encrypted: %d bytes\n", len(secMsg.EncryptedData))
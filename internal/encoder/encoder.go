@@ -1,8 +1,8 @@
 package encoder
 
 import (
-	"crypto/rand"
 	"fmt"
+	"github.com/faanross/simulacra_txt/internal/kdf"
 	"github.com/faanross/simulacra_txt/internal/spec"
 	"image"
 	"image/color"
@@ -17,6 +17,9 @@ type SecureStegoEncoder struct {
 	securePayload  []byte
 	useCompression bool
 	addDecoy       bool
+	maxUtilization float64 // Grow the carrier to keep utilization below this (0 = pack tightly)
+	kdf            kdf.Params
+	coverStyle     CoverStyle // How carrier pixels are synthesized
 }
 
 // NewSecureStegoEncoder creates an encoder with encryption
@@ -26,9 +29,43 @@ func NewSecureStegoEncoder(message []byte, password []byte, width int, compress
 		password:       password,
 		message:        message,
 		useCompression: compress,
+		kdf:            kdf.Default(),
+		coverStyle:     COVER_NOISE,
 	}
 }
 
+// SetCoverStyle selects how the carrier's pixels are synthesized
+func (sse *SecureStegoEncoder) SetCoverStyle(style CoverStyle) error {
+	if _, err := ParseCoverStyle(string(style)); err != nil {
+		return err
+	}
+	sse.coverStyle = style
+	return nil
+}
+
+// SetKDF selects the key derivation function and cost for this message.
+// Anything but the legacy default is recorded in the payload header.
+func (sse *SecureStegoEncoder) SetKDF(params kdf.Params) error {
+	if err := params.Validate(); err != nil {
+		return err
+	}
+	sse.kdf = params
+	return nil
+}
+
+// SetMaxUtilization sizes future carriers so the payload uses at most
+// this fraction of their LSBs (e.g. 0.5). Zero packs the carrier tightly.
+func (sse *SecureStegoEncoder) SetMaxUtilization(fraction float64) {
+	sse.maxUtilization = fraction
+}
+
+// Capacity reports the capacity and detectability risk of the current carrier
+func (sse *SecureStegoEncoder) Capacity() CapacityReport {
+	return AnalyzeCapacity(sse.width, sse.height, len(sse.securePayload), CapacityOptions{
+		Mode: EMBED_SEQUENTIAL,
+	})
+}
+
 // EmbedBit modifies the LSB of a color value to store a bit
 func EmbedBit(colorValue uint8, bit bool) uint8 {
 	if bit {
@@ -63,15 +100,15 @@ func (sse *SecureStegoEncoder) CreateStegoImage() (*image.RGBA, error) {
 	img := image.NewRGBA(image.Rect(0, 0, sse.width, sse.height))
 
 	fmt.Printf("\n🎨 Embedding Encrypted Data:\n")
+	fmt.Printf("   Cover style: %s\n", sse.coverStyle)
 
-	// Use cryptographically secure random base colors
-	// This makes the image appear more random and harder to detect
+	// Base colors come from the cover synthesizer: cryptographically
+	// random by default, or a plausible procedural image
+	synth := newCoverSynth(sse.coverStyle, sse.width, sse.height)
 	bitIndex := 0
 	for y := 0; y < sse.height; y++ {
 		for x := 0; x < sse.width; x++ {
-			// Generate cryptographically random base colors
-			var baseColors [3]byte
-			rand.Read(baseColors[:])
+			baseColors := synth.pixel(x, y)
 
 			// Embed bits in LSBs
 			if bitIndex < len(bits) {
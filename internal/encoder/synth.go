@@ -0,0 +1,264 @@
+package encoder
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"math"
+	mrand "math/rand"
+	"strings"
+)
+
+// ================================================================================
+// THEORY LESSON: Cover Synthesis
+// ================================================================================
+//
+// A carrier made of uniformly random pixels hides its payload perfectly from
+// statistics on the LSB plane - and is obviously not a photo the moment
+// anyone opens it. Cover synthesis draws something a person would shrug at
+// instead:
+//
+//   noise     - cryptographically random pixels (the original behavior)
+//   gradient  - a soft two-color gradient with a vignette, like a wallpaper
+//   plasma    - fractal value noise through a color palette, like abstract art
+//   static    - grainy gray TV static with faint scanlines
+//
+// Smooth images have a problem of their own: their LSBs are highly
+// structured, so the region holding (random-looking) ciphertext would stand
+// out from the untouched rest. Every style therefore adds a few levels of
+// sensor-like grain, which randomizes the LSB plane everywhere while staying
+// invisible to the eye. This defeats casual inspection, not steganalysis.
+// ================================================================================
+
+// CoverStyle selects how carrier pixels are synthesized
+type CoverStyle string
+
+const (
+	COVER_NOISE    CoverStyle = "noise"
+	COVER_GRADIENT CoverStyle = "gradient"
+	COVER_PLASMA   CoverStyle = "plasma"
+	COVER_STATIC   CoverStyle = "static"
+
+	// COVER_GRAIN is the +/- amplitude of the grain added to smooth styles
+	COVER_GRAIN = 3
+)
+
+// CoverStyleNames lists the supported cover styles
+func CoverStyleNames() []string {
+	return []string{string(COVER_NOISE), string(COVER_GRADIENT), string(COVER_PLASMA), string(COVER_STATIC)}
+}
+
+// ParseCoverStyle maps a name to a cover style
+func ParseCoverStyle(name string) (CoverStyle, error) {
+	style := CoverStyle(strings.ToLower(strings.TrimSpace(name)))
+	for _, known := range CoverStyleNames() {
+		if string(style) == known {
+			return style, nil
+		}
+	}
+	return "", fmt.Errorf("unknown cover style %q (supported: %s)", name, strings.Join(CoverStyleNames(), ", "))
+}
+
+// coverSynth generates the base pixels of one carrier
+type coverSynth struct {
+	style  CoverStyle
+	width  int
+	height int
+	rng    *mrand.Rand
+
+	// gradient
+	from, to [3]float64
+	angle    float64
+
+	// plasma and static
+	octaves []valueNoise
+	palette [4][3]float64 // Cosine palette: a + b*cos(2pi(c*t + d))
+}
+
+// newCoverSynth prepares a generator, seeded from crypto/rand so no two
+// carriers look alike
+func newCoverSynth(style CoverStyle, width, height int) *coverSynth {
+	var seed [8]byte
+	rand.Read(seed[:])
+
+	cs := &coverSynth{
+		style:  style,
+		width:  width,
+		height: height,
+		rng:    mrand.New(mrand.NewSource(int64(binary.BigEndian.Uint64(seed[:])))),
+	}
+
+	switch style {
+	case COVER_GRADIENT:
+		cs.from = cs.randomColor()
+		cs.to = cs.randomColor()
+		cs.angle = cs.rng.Float64() * 2 * math.Pi
+	case COVER_PLASMA:
+		cs.octaves = cs.noiseOctaves(maxInt(width, height)/2, 5)
+		for i := range cs.palette {
+			for c := 0; c < 3; c++ {
+				cs.palette[i][c] = cs.rng.Float64()
+			}
+		}
+		cs.palette[0] = [3]float64{0.5, 0.5, 0.5} // Keep colors mid-range
+	case COVER_STATIC:
+		cs.octaves = cs.noiseOctaves(8, 2)
+	}
+
+	return cs
+}
+
+// pixel returns the base color at (x, y), before embedding
+func (cs *coverSynth) pixel(x, y int) [3]byte {
+	var rgb [3]float64
+
+	switch cs.style {
+	case COVER_GRADIENT:
+		rgb = cs.gradient(x, y)
+	case COVER_PLASMA:
+		rgb = cs.plasma(x, y)
+	case COVER_STATIC:
+		rgb = cs.static(x, y)
+	default:
+		var raw [3]byte
+		rand.Read(raw[:])
+		return raw
+	}
+
+	// An even number of grain levels (-3..+4) keeps both LSB values equally likely
+	var out [3]byte
+	for c := range rgb {
+		grain := float64(cs.rng.Intn(2*COVER_GRAIN+2) - COVER_GRAIN)
+		out[c] = clampByte(rgb[c] + grain)
+	}
+	return out
+}
+
+// gradient blends two colors along a random direction and darkens the corners
+func (cs *coverSynth) gradient(x, y int) [3]float64 {
+	u := float64(x)/float64(maxInt(cs.width-1, 1)) - 0.5
+	v := float64(y)/float64(maxInt(cs.height-1, 1)) - 0.5
+
+	t := 0.5 + u*math.Cos(cs.angle) + v*math.Sin(cs.angle)
+	t = math.Max(0, math.Min(1, t))
+	vignette := 1 - 0.35*(u*u+v*v)*2
+
+	var rgb [3]float64
+	for c := range rgb {
+		rgb[c] = (cs.from[c]*(1-t) + cs.to[c]*t) * vignette
+	}
+	return rgb
+}
+
+// plasma maps fractal noise through the palette
+func (cs *coverSynth) plasma(x, y int) [3]float64 {
+	t := fractal(cs.octaves, x, y)
+
+	var rgb [3]float64
+	for c := range rgb {
+		a, b, freq, phase := cs.palette[0][c], cs.palette[1][c]*0.5, cs.palette[2][c]+0.5, cs.palette[3][c]
+		rgb[c] = 255 * (a + b*math.Cos(2*math.Pi*(freq*t+phase)))
+	}
+	return rgb
+}
+
+// static is gray speckle over a faint blotchy signal, with scanlines
+func (cs *coverSynth) static(x, y int) [3]float64 {
+	level := 40 + 120*fractal(cs.octaves, x, y) + float64(cs.rng.Intn(90))
+	if y%2 == 1 {
+		level *= 0.88
+	}
+	return [3]float64{level, level, level * 1.03}
+}
+
+// randomColor returns a muted color (photos rarely use saturated extremes)
+func (cs *coverSynth) randomColor() [3]float64 {
+	var rgb [3]float64
+	for c := range rgb {
+		rgb[c] = 30 + cs.rng.Float64()*195
+	}
+	return rgb
+}
+
+// noiseOctaves builds count octaves of value noise, halving the cell size each time
+func (cs *coverSynth) noiseOctaves(cell, count int) []valueNoise {
+	octaves := make([]valueNoise, 0, count)
+	for i := 0; i < count && cell >= 1; i++ {
+		octaves = append(octaves, newValueNoise(cs.rng, cs.width, cs.height, cell))
+		cell /= 2
+	}
+	return octaves
+}
+
+// valueNoise is a lattice of random values, smoothly interpolated
+type valueNoise struct {
+	cell int
+	cols int
+	grid []float64
+}
+
+// newValueNoise fills a lattice covering a width x height image
+func newValueNoise(rng *mrand.Rand, width, height, cell int) valueNoise {
+	cols := width/cell + 2
+	rows := height/cell + 2
+	grid := make([]float64, cols*rows)
+	for i := range grid {
+		grid[i] = rng.Float64()
+	}
+	return valueNoise{cell: cell, cols: cols, grid: grid}
+}
+
+// at returns the noise value (0-1) at a pixel
+func (n valueNoise) at(x, y int) float64 {
+	fx := float64(x) / float64(n.cell)
+	fy := float64(y) / float64(n.cell)
+	ix, iy := int(fx), int(fy)
+	tx, ty := smoothstep(fx-float64(ix)), smoothstep(fy-float64(iy))
+
+	v00 := n.grid[iy*n.cols+ix]
+	v10 := n.grid[iy*n.cols+ix+1]
+	v01 := n.grid[(iy+1)*n.cols+ix]
+	v11 := n.grid[(iy+1)*n.cols+ix+1]
+
+	top := v00 + (v10-v00)*tx
+	bottom := v01 + (v11-v01)*tx
+	return top + (bottom-top)*ty
+}
+
+// fractal sums octaves with halving amplitude, normalized to 0-1
+func fractal(octaves []valueNoise, x, y int) float64 {
+	sum, total, amplitude := 0.0, 0.0, 1.0
+	for _, octave := range octaves {
+		sum += octave.at(x, y) * amplitude
+		total += amplitude
+		amplitude /= 2
+	}
+	if total == 0 {
+		return 0
+	}
+	return sum / total
+}
+
+// smoothstep eases interpolation so lattice edges don't show
+func smoothstep(t float64) float64 {
+	return t * t * (3 - 2*t)
+}
+
+// clampByte rounds a channel value into 0-255
+func clampByte(v float64) byte {
+	if v < 0 {
+		return 0
+	}
+	if v > 255 {
+		return 255
+	}
+	return byte(v + 0.5)
+}
+
+// maxInt returns the larger of two integers
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
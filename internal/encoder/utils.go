@@ -9,19 +9,21 @@ import (
 	"math"
 )
 
-// CalculateImageDimensions determines required image size
+// CalculateImageDimensions determines required image size.
+// With a max utilization set, the image grows so the payload stays below it.
 func (sse *SecureStegoEncoder) CalculateImageDimensions() {
 	totalBits := len(sse.securePayload) * spec.BITS_PER_BYTE
 	pixelsNeeded := int(math.Ceil(float64(totalBits) / float64(spec.CHANNELS)))
+	if sse.maxUtilization > 0 && sse.maxUtilization < 1 {
+		pixelsNeeded = int(math.Ceil(float64(pixelsNeeded) / sse.maxUtilization))
+	}
 	sse.height = int(math.Ceil(float64(pixelsNeeded) / float64(sse.width)))
 
 	fmt.Printf("\n📊 Steganography Parameters:\n")
 	fmt.Printf("   Payload size: %d bytes\n", len(sse.securePayload))
 	fmt.Printf("   Bits needed: %d\n", totalBits)
-	fmt.Printf("   Image dimensions: %dx%d\n", sse.width, sse.height)
-	fmt.Printf("   Total capacity: %d bits\n", sse.width*sse.height*spec.CHANNELS)
-	fmt.Printf("   Utilization: %.1f%%\n",
-		float64(totalBits)*100/float64(sse.width*sse.height*spec.CHANNELS))
+
+	sse.Capacity().Print()
 }
 
 // min returns the smaller of two integers
@@ -0,0 +1,341 @@
+package fingerprint
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"github.com/faanross/simulacra_txt/internal/chunker"
+	"github.com/faanross/simulacra_txt/internal/transport"
+	"github.com/miekg/dns"
+	mrand "math/rand"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ================================================================================
+// THEORY LESSON: Client Fingerprints
+// ================================================================================
+//
+// Every query our clients send used to look exactly the same: RD set, no
+// EDNS0 (or always a 4096-byte buffer), always UDP. Real traffic to a resolver
+// comes from many different stacks, and each leaves its own marks:
+//
+//   RD flag       - stub resolvers set it, recursive resolvers don't
+//   EDNS0         - absent, or a buffer size of 512 / 1232 / 4096
+//   DO bit        - set by DNSSEC-aware resolvers
+//   COOKIE option - sent by modern resolvers (RFC 7873)
+//   Transport     - mostly UDP, with some TCP
+//
+// A client with one fixed combination is trivially clustered. A Randomizer
+// picks a persona - a plausible combination of these marks - per client
+// (stub profile) or per query (mixed profile), so there's no single stable
+// signature to match on. Query IDs are drawn fresh for every attempt,
+// retries included.
+// ================================================================================
+
+// Profile selects how much query characteristics vary
+type Profile string
+
+const (
+	PROFILE_NONE  Profile = "none"  // Fixed characteristics (the original behavior)
+	PROFILE_STUB  Profile = "stub"  // One random persona, kept for the client's lifetime
+	PROFILE_MIXED Profile = "mixed" // A random persona for every query, plus some TCP
+
+	// MIXED_TCP_SHARE is the fraction of mixed-profile queries sent over TCP
+	MIXED_TCP_SHARE = 0.1
+
+	// COOKIE_SIZE is the length of an RFC 7873 client cookie
+	COOKIE_SIZE = 8
+)
+
+// persona is one plausible combination of query characteristics
+type persona struct {
+	name      string
+	recursion bool   // RD flag
+	udpSize   uint16 // EDNS0 buffer size (0 = no OPT record)
+	dnssecOK  bool   // DO bit
+	cookie    bool   // Send an EDNS0 COOKIE option
+}
+
+// personas are loosely modelled on common stub and recursive resolvers
+var personas = []persona{
+	{name: "legacy-stub", recursion: true},
+	{name: "edns-stub", recursion: true, udpSize: 1232},
+	{name: "validating-stub", recursion: true, udpSize: 1232, dnssecOK: true},
+	{name: "forwarder", recursion: true, udpSize: 4096, dnssecOK: true, cookie: true},
+	{name: "recursive", recursion: false, udpSize: 1232, dnssecOK: true, cookie: true},
+}
+
+// ProfileNames lists the supported profiles
+func ProfileNames() []string {
+	return []string{string(PROFILE_NONE), string(PROFILE_STUB), string(PROFILE_MIXED)}
+}
+
+// ParseProfile maps a name to a profile
+func ParseProfile(name string) (Profile, error) {
+	switch Profile(strings.ToLower(strings.TrimSpace(name))) {
+	case "", PROFILE_NONE:
+		return PROFILE_NONE, nil
+	case PROFILE_STUB:
+		return PROFILE_STUB, nil
+	case PROFILE_MIXED:
+		return PROFILE_MIXED, nil
+	}
+	return "", fmt.Errorf("unknown query profile %q (supported: %s)", name, strings.Join(ProfileNames(), ", "))
+}
+
+// Randomizer shapes outgoing queries according to a profile
+type Randomizer struct {
+	profile     Profile
+	fixed       persona             // The persona used by the stub profile
+	cookie      []byte              // Client cookie, stable for the randomizer's lifetime
+	udpFloor    uint16              // EDNS0 buffer advertised at least, on every query (0 = persona decides)
+	tcpFallback bool                // Repeat truncated UDP answers over TCP
+	transport   transport.Transport // Carries every query when set (nil = UDP/TCP per persona)
+	cacheFirst  bool                // Ask the resolver's cache (RD clear) before recursing
+	cacheOnly   bool                // Never recurse: cache misses stay misses
+	caseWriter  *chunker.CaseWriter // Writes the case of every query name (nil = as given)
+	options     []dns.EDNS0         // EDNS0 options added to every query
+	cacheHits   atomic.Int64
+	cacheMisses atomic.Int64
+	rng         *mrand.Rand
+	mu          sync.Mutex
+}
+
+// NewRandomizer creates a randomizer for a profile
+func NewRandomizer(profile Profile) *Randomizer {
+	r := &Randomizer{
+		profile:     profile,
+		cookie:      make([]byte, COOKIE_SIZE),
+		tcpFallback: true,
+		rng:         mrand.New(mrand.NewSource(time.Now().UnixNano())),
+	}
+	rand.Read(r.cookie)
+	r.fixed = personas[r.rng.Intn(len(personas))]
+
+	return r
+}
+
+// SetEDNS0 makes every query advertise an EDNS0 buffer of at least size
+// bytes (0 leaves it to the persona)
+func (r *Randomizer) SetEDNS0(size uint16) {
+	r.udpFloor = size
+}
+
+// SetTCPFallback controls whether truncated UDP answers are repeated over TCP
+func (r *Randomizer) SetTCPFallback(enabled bool) {
+	r.tcpFallback = enabled
+}
+
+// SetTransport sends every query through t instead of plain UDP/TCP. The
+// persona still shapes the query; only its TCP choice is ignored.
+func (r *Randomizer) SetTransport(t transport.Transport) {
+	r.transport = t
+}
+
+// SetCase has w set the letter case of every query name, for 0x20-style
+// random case or a case signal (nil sends names as given)
+func (r *Randomizer) SetCase(w *chunker.CaseWriter) {
+	r.caseWriter = w
+}
+
+// SetOptions adds EDNS0 options to every query, which then always carries
+// an OPT record
+func (r *Randomizer) SetOptions(options ...dns.EDNS0) {
+	r.options = options
+}
+
+// SetCacheFirst makes every query ask the resolver's cache first, with RD
+// clear, and repeat misses as recursive queries unless cacheOnly is set
+func (r *Randomizer) SetCacheFirst(enabled, cacheOnly bool) {
+	r.cacheFirst = enabled
+	r.cacheOnly = enabled && cacheOnly
+}
+
+// CacheStats returns how many cache-first queries the resolver answered
+// from its cache, and how many it missed
+func (r *Randomizer) CacheStats() (hits, misses int64) {
+	return r.cacheHits.Load(), r.cacheMisses.Load()
+}
+
+// Profile returns the randomizer's profile
+func (r *Randomizer) Profile() Profile {
+	return r.profile
+}
+
+// Describe summarizes the profile for display
+func (r *Randomizer) Describe() string {
+	switch r.profile {
+	case PROFILE_STUB:
+		return fmt.Sprintf("%s (persona: %s)", r.profile, r.fixed.name)
+	case PROFILE_MIXED:
+		return fmt.Sprintf("%s (%d personas, %.0f%% TCP)", r.profile, len(personas), MIXED_TCP_SHARE*100)
+	}
+	return string(PROFILE_NONE)
+}
+
+// pick returns the persona and transport for the next query
+func (r *Randomizer) pick() (persona, string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	switch r.profile {
+	case PROFILE_STUB:
+		return r.fixed, "udp"
+	case PROFILE_MIXED:
+		p := personas[r.rng.Intn(len(personas))]
+		if r.rng.Float64() < MIXED_TCP_SHARE {
+			return p, "tcp"
+		}
+		return p, "udp"
+	}
+
+	return persona{name: "fixed", recursion: true}, "udp"
+}
+
+// Prepare applies a persona to a query with its question already set.
+// minUDP is the EDNS0 buffer the answer needs (0 = none); a persona
+// advertising less is raised to it. It returns the network to use.
+func (r *Randomizer) Prepare(m *dns.Msg, minUDP uint16) string {
+	p, network := r.pick()
+
+	m.Id = dns.Id()
+	m.RecursionDesired = p.recursion
+	if r.caseWriter != nil {
+		for i := range m.Question {
+			m.Question[i].Name = r.caseWriter.Apply(m.Question[i].Name)
+		}
+	}
+
+	udpSize := p.udpSize
+	if udpSize < minUDP {
+		udpSize = minUDP
+	}
+	if udpSize < r.udpFloor {
+		udpSize = r.udpFloor
+	}
+
+	// Options the caller set survive the persona's own OPT record; they
+	// force EDNS0 even for personas that don't use it. A query prepared
+	// before (a retry) already holds the options added here, so those are
+	// left for this pass to add again.
+	var options []dns.EDNS0
+	if opt := m.IsEdns0(); opt != nil {
+		for _, option := range opt.Option {
+			if !r.adds(option.Option()) {
+				options = append(options, option)
+			}
+		}
+		extra := m.Extra[:0]
+		for _, rr := range m.Extra {
+			if rr.Header().Rrtype != dns.TypeOPT {
+				extra = append(extra, rr)
+			}
+		}
+		m.Extra = extra
+		if udpSize == 0 {
+			udpSize = opt.UDPSize()
+		}
+	}
+	options = append(options, r.options...)
+	if udpSize == 0 && len(options) > 0 {
+		udpSize = dns.MinMsgSize
+	}
+	if udpSize == 0 {
+		return network
+	}
+
+	m.SetEdns0(udpSize, p.dnssecOK)
+	opt := m.IsEdns0()
+	opt.Option = append(opt.Option, options...)
+	if p.cookie {
+		opt.Option = append(opt.Option, &dns.EDNS0_COOKIE{
+			Code:   dns.EDNS0COOKIE,
+			Cookie: hex.EncodeToString(r.cookie),
+		})
+	}
+
+	return network
+}
+
+// adds reports whether Prepare adds options with this code itself
+func (r *Randomizer) adds(code uint16) bool {
+	if code == dns.EDNS0COOKIE {
+		return true
+	}
+	for _, option := range r.options {
+		if option.Option() == code {
+			return true
+		}
+	}
+	return false
+}
+
+// Exchange prepares and sends a query, asking the resolver's cache first
+// when SetCacheFirst is on. Cancelling ctx abandons the query.
+func (r *Randomizer) Exchange(ctx context.Context, m *dns.Msg, server string, minUDP uint16, timeout time.Duration) (*dns.Msg, error) {
+	network := r.Prepare(m, minUDP)
+	if !r.cacheFirst {
+		return r.send(ctx, m, network, server, timeout)
+	}
+
+	// LESSON: Reading a Resolver's Cache
+	// A recursive resolver given a query without RD answers only from its
+	// cache (RFC 1034 4.3.1): a cached record comes back, anything else
+	// gets an empty answer, a referral or REFUSED - and nothing is asked
+	// upstream. So a message whose records some earlier query pulled into
+	// the cache can be read without our authoritative server seeing a
+	// single query, for as long as the records' TTL lasts.
+	m.RecursionDesired = false
+	resp, err := r.send(ctx, m, network, server, timeout)
+	if err == nil && len(resp.Answer) > 0 {
+		r.cacheHits.Add(1)
+		return resp, nil
+	}
+	r.cacheMisses.Add(1)
+	if r.cacheOnly || ctx.Err() != nil {
+		return resp, err
+	}
+
+	m.Id = dns.Id()
+	m.RecursionDesired = true
+	return r.send(ctx, m, network, server, timeout)
+}
+
+// send sends a prepared query. A TCP attempt that fails (many servers only
+// listen on UDP) is retried over UDP with a fresh ID. With a transport set,
+// the query goes through it unchanged.
+func (r *Randomizer) send(ctx context.Context, m *dns.Msg, network, server string, timeout time.Duration) (*dns.Msg, error) {
+	if r.transport != nil {
+		return r.transport.Exchange(ctx, m, server, timeout)
+	}
+
+	plain := transport.UDP
+	if network == transport.NET_TCP {
+		plain = transport.TCP
+	}
+	resp, err := plain.Exchange(ctx, m, server, timeout)
+	if err != nil && plain == transport.TCP && ctx.Err() == nil {
+		m.Id = dns.Id()
+		plain = transport.UDP
+		resp, err = plain.Exchange(ctx, m, server, timeout)
+	}
+
+	// LESSON: The TC Bit
+	// An answer too big for the advertised buffer (512 bytes without
+	// EDNS0) arrives with the TC flag set and records missing. RFC 7766
+	// says to ask again over TCP, which has no size limit. If TCP is
+	// unavailable the truncated answer is still returned - callers can use
+	// what did fit.
+	if err == nil && resp.Truncated && plain == transport.UDP && r.tcpFallback {
+		m.Id = dns.Id()
+		if full, tcpErr := transport.TCP.Exchange(ctx, m, server, timeout); tcpErr == nil {
+			resp = full
+		}
+	}
+
+	return resp, err
+}
@@ -0,0 +1,229 @@
+package kdf
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"github.com/faanross/simulacra_txt/internal/spec"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/pbkdf2"
+	"strings"
+)
+
+// ================================================================================
+// THEORY LESSON: Password-Based Key Derivation
+// ================================================================================
+//
+// A password is low-entropy, so the key derived from it must be expensive to
+// compute: every guess an attacker makes pays the same cost we pay once.
+//
+//   PBKDF2-SHA256 - cost is CPU time only (iterations). Cheap to parallelize
+//                   on GPUs, but available everywhere.
+//   Argon2id      - cost is CPU time AND memory. Each guess needs its own
+//                   memory block, which is what makes GPU/ASIC cracking slow.
+//
+// The right cost depends on the hardware and the threat, so it is chosen per
+// message and recorded in front of the salt:
+//
+//   [MAGIC "KDF1"(4)][ALGORITHM(1)][ITERATIONS(4)][MEMORY KiB(4)][THREADS(1)]
+//
+// The header is bound to the ciphertext as GCM additional data. Payloads
+// without it are legacy: PBKDF2 with spec.PBKDF2_ITERS.
+// ================================================================================
+
+// Algorithm identifies a key derivation function
+type Algorithm uint8
+
+const (
+	KDF_PBKDF2   Algorithm = 1
+	KDF_ARGON2ID Algorithm = 2
+
+	// HEADER_MAGIC marks a payload that records its KDF ("KDF1")
+	HEADER_MAGIC = 0x4B444631
+	HEADER_SIZE  = 14
+
+	// Argon2id defaults (RFC 9106 second recommendation, 64 MiB)
+	DEFAULT_ARGON2_TIME    = 3
+	DEFAULT_ARGON2_MEMORY  = 64 * 1024 // KiB
+	DEFAULT_ARGON2_THREADS = 4
+
+	// Floors below which a password offers little protection
+	MIN_PBKDF2_ITERS  = 10000
+	MIN_ARGON2_MEMORY = 8 * 1024 // KiB
+
+	// Decoders refuse headers above these costs (a crafted image
+	// could otherwise demand gigabytes of memory or hours of CPU)
+	DEFAULT_MAX_ITERATIONS = 10000000
+	DEFAULT_MAX_MEMORY     = 1024 * 1024 // KiB (1 GiB)
+)
+
+// Params selects a KDF and its cost
+type Params struct {
+	Algorithm  Algorithm
+	Iterations uint32 // PBKDF2 rounds or Argon2 passes
+	Memory     uint32 // Argon2 memory in KiB
+	Threads    uint8  // Argon2 parallelism
+}
+
+// Limits caps the cost a decoder accepts from a payload header
+type Limits struct {
+	MaxIterations uint32
+	MaxMemory     uint32 // KiB
+}
+
+// Default returns the legacy parameters (PBKDF2, spec.PBKDF2_ITERS)
+func Default() Params {
+	return Params{Algorithm: KDF_PBKDF2, Iterations: spec.PBKDF2_ITERS}
+}
+
+// DefaultArgon2 returns the recommended Argon2id parameters
+func DefaultArgon2() Params {
+	return Params{
+		Algorithm:  KDF_ARGON2ID,
+		Iterations: DEFAULT_ARGON2_TIME,
+		Memory:     DEFAULT_ARGON2_MEMORY,
+		Threads:    DEFAULT_ARGON2_THREADS,
+	}
+}
+
+// DefaultLimits returns the decoder's default cost limits
+func DefaultLimits() Limits {
+	return Limits{MaxIterations: DEFAULT_MAX_ITERATIONS, MaxMemory: DEFAULT_MAX_MEMORY}
+}
+
+// ParseAlgorithm maps a name (pbkdf2, argon2id) to an algorithm
+func ParseAlgorithm(name string) (Algorithm, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "pbkdf2", "pbkdf2-sha256":
+		return KDF_PBKDF2, nil
+	case "argon2id", "argon2":
+		return KDF_ARGON2ID, nil
+	}
+	return 0, fmt.Errorf("unknown KDF %q (supported: pbkdf2, argon2id)", name)
+}
+
+// String returns the algorithm's configuration name
+func (a Algorithm) String() string {
+	switch a {
+	case KDF_PBKDF2:
+		return "pbkdf2"
+	case KDF_ARGON2ID:
+		return "argon2id"
+	}
+	return fmt.Sprintf("unknown(%d)", uint8(a))
+}
+
+// ForAlgorithm returns default parameters for an algorithm, overriding
+// any non-zero cost values
+func ForAlgorithm(algo Algorithm, iterations, memoryKiB uint32, threads uint8) (Params, error) {
+	var params Params
+	switch algo {
+	case KDF_PBKDF2:
+		params = Default()
+	case KDF_ARGON2ID:
+		params = DefaultArgon2()
+	default:
+		return Params{}, fmt.Errorf("unsupported KDF %s", algo)
+	}
+
+	if iterations > 0 {
+		params.Iterations = iterations
+	}
+	if algo == KDF_ARGON2ID {
+		if memoryKiB > 0 {
+			params.Memory = memoryKiB
+		}
+		if threads > 0 {
+			params.Threads = threads
+		}
+	}
+
+	return params, params.Validate()
+}
+
+// Validate rejects parameters too weak (or malformed) to use
+func (p Params) Validate() error {
+	switch p.Algorithm {
+	case KDF_PBKDF2:
+		if p.Iterations < MIN_PBKDF2_ITERS {
+			return fmt.Errorf("PBKDF2 needs at least %d iterations, got %d", MIN_PBKDF2_ITERS, p.Iterations)
+		}
+	case KDF_ARGON2ID:
+		if p.Iterations < 1 {
+			return fmt.Errorf("Argon2id needs at least 1 pass")
+		}
+		if p.Memory < MIN_ARGON2_MEMORY {
+			return fmt.Errorf("Argon2id needs at least %d KiB of memory, got %d", MIN_ARGON2_MEMORY, p.Memory)
+		}
+		if p.Threads < 1 {
+			return fmt.Errorf("Argon2id needs at least 1 thread")
+		}
+	default:
+		return fmt.Errorf("unsupported KDF %s", p.Algorithm)
+	}
+	return nil
+}
+
+// CheckLimits rejects parameters costlier than a decoder allows
+func (p Params) CheckLimits(limits Limits) error {
+	if limits.MaxIterations > 0 && p.Iterations > limits.MaxIterations {
+		return fmt.Errorf("KDF iterations %d exceed limit %d", p.Iterations, limits.MaxIterations)
+	}
+	if limits.MaxMemory > 0 && p.Memory > limits.MaxMemory {
+		return fmt.Errorf("KDF memory %d KiB exceeds limit %d KiB", p.Memory, limits.MaxMemory)
+	}
+	return nil
+}
+
+// IsLegacy reports whether a payload with these parameters can omit the header
+func (p Params) IsLegacy() bool {
+	return p == Default()
+}
+
+// Derive computes a spec.KEY_SIZE key from a password and salt
+func (p Params) Derive(password, salt []byte) []byte {
+	if p.Algorithm == KDF_ARGON2ID {
+		return argon2.IDKey(password, salt, p.Iterations, p.Memory, p.Threads, spec.KEY_SIZE)
+	}
+	return pbkdf2.Key(password, salt, int(p.Iterations), spec.KEY_SIZE, sha256.New)
+}
+
+// String describes the parameters for display
+func (p Params) String() string {
+	if p.Algorithm == KDF_ARGON2ID {
+		return fmt.Sprintf("Argon2id (t=%d, m=%d MiB, p=%d)", p.Iterations, p.Memory/1024, p.Threads)
+	}
+	return fmt.Sprintf("PBKDF2-SHA256 (%d iterations)", p.Iterations)
+}
+
+// MarshalHeader encodes the parameters as a payload header
+func (p Params) MarshalHeader() []byte {
+	header := make([]byte, HEADER_SIZE)
+	binary.BigEndian.PutUint32(header[0:4], HEADER_MAGIC)
+	header[4] = byte(p.Algorithm)
+	binary.BigEndian.PutUint32(header[5:9], p.Iterations)
+	binary.BigEndian.PutUint32(header[9:13], p.Memory)
+	header[13] = p.Threads
+	return header
+}
+
+// ParseHeader reads a KDF header from the start of a payload.
+// It returns false for legacy payloads, which start directly with the salt.
+func ParseHeader(data []byte) (Params, bool) {
+	if len(data) < HEADER_SIZE || binary.BigEndian.Uint32(data[0:4]) != HEADER_MAGIC {
+		return Default(), false
+	}
+
+	params := Params{
+		Algorithm:  Algorithm(data[4]),
+		Iterations: binary.BigEndian.Uint32(data[5:9]),
+		Memory:     binary.BigEndian.Uint32(data[9:13]),
+		Threads:    data[13],
+	}
+	if params.Validate() != nil {
+		// A random salt that happens to start with the magic
+		return Default(), false
+	}
+
+	return params, true
+}
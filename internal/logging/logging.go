@@ -0,0 +1,282 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+)
+
+// ================================================================================
+// STRUCTURED LOGGING
+// Leveled, per-component logs as text or JSON, to the console or a rotated file
+// ================================================================================
+
+// LESSON: Logs Are Data
+// "Served: c-3-ab12.covert.example.com TXT -> 180 bytes" reads fine in a
+// terminal and is miserable to grep across a 24-hour run. A structured
+// record keeps the message and its values apart:
+//
+//   time=... level=INFO msg="✅ Uploaded message" component=http msg_id=ab12 chunks=47
+//   {"time":"...","level":"INFO","msg":"✅ Uploaded message","component":"http","msg_id":"ab12","chunks":47}
+//
+// Every logger belongs to a component (dns, http, storage, ...), and each
+// component can run at its own level: "-log-level info,dns=debug" keeps
+// the per-query chatter of the DNS side while everything else stays quiet.
+//
+// Loggers from For can be created anywhere - package variables included -
+// before Setup runs: they look up the configured handler for every record,
+// not when they are made. The standard log package is routed through the
+// same handler, so a stray log.Printf still lands in the same file, at the
+// default level.
+
+// Output formats
+const (
+	FORMAT_TEXT = "text"
+	FORMAT_JSON = "json"
+)
+
+const (
+	// DEFAULT_LEVEL is the level of components not named in a level spec
+	DEFAULT_LEVEL = "info"
+
+	// DEFAULT_MAX_SIZE is the size at which a log file is rotated
+	DEFAULT_MAX_SIZE = 100 << 20
+
+	// DEFAULT_MAX_BACKUPS is how many rotated log files are kept
+	DEFAULT_MAX_BACKUPS = 5
+
+	// COMPONENT_KEY is the attribute naming a record's component
+	COMPONENT_KEY = "component"
+)
+
+// Config says where logs go and how much of them
+type Config struct {
+	Level      string // Level spec: "info", or "warn,dns=debug,storage=info"
+	Format     string // FORMAT_TEXT (default) or FORMAT_JSON
+	File       string // Log file ("" = stderr)
+	Console    bool   // With File, also write to stderr
+	MaxSize    int64  // Rotate File at this many bytes (0 = never)
+	MaxBackups int    // Rotated files kept (0 = DEFAULT_MAX_BACKUPS)
+}
+
+// Levels is a default level plus per-component overrides
+type Levels struct {
+	Default    slog.Level
+	Components map[string]slog.Level
+}
+
+// For returns the level of a component
+func (l Levels) For(component string) slog.Level {
+	if level, exists := l.Components[component]; exists {
+		return level
+	}
+	return l.Default
+}
+
+// lowest returns the most verbose level in use
+func (l Levels) lowest() slog.Level {
+	lowest := l.Default
+	for _, level := range l.Components {
+		lowest = min(lowest, level)
+	}
+	return lowest
+}
+
+// ParseLevel maps a level name (debug, info, warn, error) to a level
+func ParseLevel(name string) (slog.Level, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(strings.TrimSpace(name))); err != nil {
+		return 0, fmt.Errorf("unknown log level %q (use debug, info, warn or error)", name)
+	}
+	return level, nil
+}
+
+// ParseLevels parses a level spec: a default level, then component=level
+// overrides, comma-separated ("" = DEFAULT_LEVEL)
+func ParseLevels(spec string) (Levels, error) {
+	levels := Levels{Components: make(map[string]slog.Level)}
+	levels.Default, _ = ParseLevel(DEFAULT_LEVEL)
+
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		component, name, scoped := strings.Cut(part, "=")
+		level, err := ParseLevel(name)
+		if !scoped {
+			level, err = ParseLevel(component)
+		}
+		if err != nil {
+			return Levels{}, err
+		}
+		if scoped {
+			levels.Components[strings.TrimSpace(component)] = level
+		} else {
+			levels.Default = level
+		}
+	}
+	return levels, nil
+}
+
+// Validate checks the configuration without opening anything
+func (c Config) Validate() error {
+	if _, err := ParseLevels(c.Level); err != nil {
+		return err
+	}
+	switch c.Format {
+	case "", FORMAT_TEXT, FORMAT_JSON:
+	default:
+		return fmt.Errorf("unknown log format %q (use %s or %s)", c.Format, FORMAT_TEXT, FORMAT_JSON)
+	}
+	if c.MaxSize < 0 || c.MaxBackups < 0 {
+		return fmt.Errorf("log rotation sizes can't be negative")
+	}
+	return nil
+}
+
+// String describes the configuration for display
+func (c Config) String() string {
+	format := c.Format
+	if format == "" {
+		format = FORMAT_TEXT
+	}
+	level := c.Level
+	if level == "" {
+		level = DEFAULT_LEVEL
+	}
+
+	where := "stderr"
+	if c.File != "" {
+		where = c.File
+		if c.MaxSize > 0 {
+			where += fmt.Sprintf(" (rotated at %d bytes, %d kept)", c.MaxSize, c.backups())
+		}
+		if c.Console {
+			where += " + stderr"
+		}
+	}
+	return fmt.Sprintf("%s, %s, to %s", level, format, where)
+}
+
+// backups returns how many rotated files are kept
+func (c Config) backups() int {
+	if c.MaxBackups == 0 {
+		return DEFAULT_MAX_BACKUPS
+	}
+	return c.MaxBackups
+}
+
+// ================================================================================
+// SETUP
+// ================================================================================
+
+// active is the configuration Setup installed
+var active struct {
+	mu      sync.RWMutex
+	handler slog.Handler // Unfiltered: components filter for themselves
+	levels  Levels
+}
+
+// Setup installs config as the process-wide logging configuration, for
+// slog, the log package and every logger from For. The returned closer
+// closes the log file.
+func Setup(config Config) (io.Closer, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	levels, _ := ParseLevels(config.Level)
+
+	var out io.Writer = os.Stderr
+	var closer io.Closer = io.NopCloser(nil)
+	if config.File != "" {
+		file, err := OpenRotating(config.File, config.MaxSize, config.backups())
+		if err != nil {
+			return nil, err
+		}
+		out, closer = file, file
+		if config.Console {
+			out = io.MultiWriter(file, os.Stderr)
+		}
+	}
+
+	options := &slog.HandlerOptions{Level: levels.lowest()}
+	var handler slog.Handler = slog.NewTextHandler(out, options)
+	if config.Format == FORMAT_JSON {
+		handler = slog.NewJSONHandler(out, options)
+	}
+
+	active.mu.Lock()
+	active.handler = handler
+	active.levels = levels
+	active.mu.Unlock()
+
+	// Records without a component (slog.Info, log.Printf) get the default level
+	slog.SetDefault(slog.New(&componentHandler{}))
+	return closer, nil
+}
+
+// For returns the logger of a component
+func For(component string) *slog.Logger {
+	return slog.New(&componentHandler{component: component})
+}
+
+// current returns the installed handler and the level of a component;
+// before Setup, records go to stderr as text, at info level
+func current(component string) (slog.Handler, slog.Level) {
+	active.mu.RLock()
+	defer active.mu.RUnlock()
+	if active.handler == nil {
+		return fallback, slog.LevelInfo
+	}
+	return active.handler, active.levels.For(component)
+}
+
+// fallback writes until Setup runs
+var fallback slog.Handler = slog.NewTextHandler(os.Stderr, nil)
+
+// componentHandler tags records with a component and filters them by the
+// component's level, resolving both when the record is logged
+type componentHandler struct {
+	component string
+	wrap      []func(slog.Handler) slog.Handler // WithAttrs/WithGroup, in order
+}
+
+func (h *componentHandler) Enabled(_ context.Context, level slog.Level) bool {
+	_, minimum := current(h.component)
+	return level >= minimum
+}
+
+func (h *componentHandler) Handle(ctx context.Context, record slog.Record) error {
+	handler, minimum := current(h.component)
+	if record.Level < minimum {
+		return nil
+	}
+	if h.component != "" {
+		handler = handler.WithAttrs([]slog.Attr{slog.String(COMPONENT_KEY, h.component)})
+	}
+	for _, wrap := range h.wrap {
+		handler = wrap(handler)
+	}
+	return handler.Handle(ctx, record)
+}
+
+func (h *componentHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return h.with(func(next slog.Handler) slog.Handler { return next.WithAttrs(attrs) })
+}
+
+func (h *componentHandler) WithGroup(name string) slog.Handler {
+	return h.with(func(next slog.Handler) slog.Handler { return next.WithGroup(name) })
+}
+
+// with returns a copy of h applying one more wrapper
+func (h *componentHandler) with(wrap func(slog.Handler) slog.Handler) *componentHandler {
+	return &componentHandler{
+		component: h.component,
+		wrap:      append(append([]func(slog.Handler) slog.Handler{}, h.wrap...), wrap),
+	}
+}
@@ -0,0 +1,107 @@
+package logging
+
+import (
+	"fmt"
+	"os"
+	"sync"
+)
+
+// LESSON: Rotating by Size
+// A 24-hour simulation at debug level writes a line per query; left alone
+// the file grows until the disk is full. Once the file reaches its size
+// limit it is renamed to <file>.1 (an older .1 becomes .2, and so on), the
+// oldest beyond the backup count is dropped, and writing continues in a
+// fresh file. A single line is never split across two files.
+
+// RotatingFile is a log file that rotates itself by size
+type RotatingFile struct {
+	path       string
+	maxSize    int64 // 0 = never rotate
+	maxBackups int
+	file       *os.File
+	size       int64
+	mu         sync.Mutex
+}
+
+// OpenRotating opens (appending to) a log file rotated at maxSize bytes,
+// keeping maxBackups rotated files
+func OpenRotating(path string, maxSize int64, maxBackups int) (*RotatingFile, error) {
+	rf := &RotatingFile{path: path, maxSize: maxSize, maxBackups: maxBackups}
+	if err := rf.open(); err != nil {
+		return nil, err
+	}
+	return rf, nil
+}
+
+// open opens the current file for appending
+func (rf *RotatingFile) open() error {
+	file, err := os.OpenFile(rf.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open log file: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("failed to open log file: %w", err)
+	}
+	rf.file, rf.size = file, info.Size()
+	return nil
+}
+
+// Write appends p, rotating first if p would take the file past its limit
+func (rf *RotatingFile) Write(p []byte) (int, error) {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+
+	if rf.file == nil {
+		return 0, os.ErrClosed
+	}
+	if rf.maxSize > 0 && rf.size > 0 && rf.size+int64(len(p)) > rf.maxSize {
+		if err := rf.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := rf.file.Write(p)
+	rf.size += int64(n)
+	return n, err
+}
+
+// rotate shifts the backups along and starts a new file; callers hold the lock
+func (rf *RotatingFile) rotate() error {
+	if err := rf.file.Close(); err != nil {
+		return fmt.Errorf("failed to close log file: %w", err)
+	}
+	rf.file = nil
+
+	os.Remove(rf.backup(rf.maxBackups))
+	for i := rf.maxBackups - 1; i >= 1; i-- {
+		os.Rename(rf.backup(i), rf.backup(i+1))
+	}
+	if rf.maxBackups > 0 {
+		if err := os.Rename(rf.path, rf.backup(1)); err != nil {
+			return fmt.Errorf("failed to rotate log file: %w", err)
+		}
+	} else {
+		os.Remove(rf.path)
+	}
+
+	return rf.open()
+}
+
+// backup returns the name of the nth rotated file
+func (rf *RotatingFile) backup(n int) string {
+	return fmt.Sprintf("%s.%d", rf.path, n)
+}
+
+// Close closes the file
+func (rf *RotatingFile) Close() error {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	if rf.file == nil {
+		return nil
+	}
+	err := rf.file.Close()
+	rf.file = nil
+	return err
+}
@@ -0,0 +1,361 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ================================================================================
+// PROMETHEUS METRICS
+// Counters, gauges and histograms served in the Prometheus text format
+// ================================================================================
+
+// LESSON: The Exposition Format
+// Prometheus scrapes a plain-text page: a HELP and a TYPE line per metric,
+// then one line per series - the name, its labels in braces, the value.
+//
+//   # HELP simulacra_dns_queries_total DNS queries answered
+//   # TYPE simulacra_dns_queries_total counter
+//   simulacra_dns_queries_total{type="TXT",rcode="NOERROR"} 1432
+//
+// A histogram is a set of counters: one per bucket (le = "less or equal",
+// cumulative), plus _sum and _count, so quantiles can be estimated across
+// any time window at query time. The format is simple enough that writing
+// it ourselves costs less than a client library and its dependencies.
+//
+// Label values should come from small, fixed sets (query types, rcodes):
+// every distinct combination is a series Prometheus stores forever, so
+// message or client IDs never belong in labels.
+
+// CONTENT_TYPE is the exposition format's media type
+const CONTENT_TYPE = "text/plain; version=0.0.4; charset=utf-8"
+
+// Default histogram buckets
+var (
+	// LATENCY_BUCKETS covers 100µs to 2.5s, in seconds
+	LATENCY_BUCKETS = []float64{0.0001, 0.00025, 0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5}
+
+	// SIZE_BUCKETS covers 1KB to 64MB, in bytes
+	SIZE_BUCKETS = ExponentialBuckets(1024, 4, 9)
+)
+
+// ExponentialBuckets returns count bucket bounds, starting at start and
+// each factor times the last
+func ExponentialBuckets(start, factor float64, count int) []float64 {
+	buckets := make([]float64, count)
+	for i := range buckets {
+		buckets[i] = start
+		start *= factor
+	}
+	return buckets
+}
+
+// metric is anything the registry can write
+type metric interface {
+	write(w *bufio.Writer)
+}
+
+// Registry holds the metrics a /metrics page shows
+type Registry struct {
+	metrics   []metric
+	names     map[string]bool
+	collect   []func() // Run before every scrape
+	mu        sync.Mutex
+	collectMu sync.Mutex // One scrape updates gauges at a time
+}
+
+// NewRegistry creates an empty registry
+func NewRegistry() *Registry {
+	return &Registry{names: make(map[string]bool)}
+}
+
+// register adds a metric, refusing duplicate names (a programming error)
+func (r *Registry) register(name string, m metric) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.names[name] {
+		panic(fmt.Sprintf("metric %s registered twice", name))
+	}
+	r.names[name] = true
+	r.metrics = append(r.metrics, m)
+}
+
+// OnCollect runs fn before every scrape, to set gauges from current state
+func (r *Registry) OnCollect(fn func()) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.collect = append(r.collect, fn)
+}
+
+// WriteTo writes every metric in the exposition format
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	collect := append([]func(){}, r.collect...)
+	metrics := append([]metric{}, r.metrics...)
+	r.mu.Unlock()
+
+	r.collectMu.Lock()
+	for _, fn := range collect {
+		fn()
+	}
+	r.collectMu.Unlock()
+
+	counter := &countingWriter{w: w}
+	buf := bufio.NewWriter(counter)
+	for _, m := range metrics {
+		m.write(buf)
+	}
+	err := buf.Flush()
+	return counter.n, err
+}
+
+// ServeHTTP serves the metrics page
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != "GET" && req.Method != "HEAD" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", CONTENT_TYPE)
+	r.WriteTo(w)
+}
+
+// countingWriter counts bytes written, for WriteTo's result
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// ================================================================================
+// SERIES
+// ================================================================================
+
+// family is what every metric type shares: a name, help text, label names
+// and one series per combination of label values
+type family struct {
+	name   string
+	help   string
+	kind   string
+	labels []string
+	mu     sync.Mutex
+}
+
+// key joins label values into a series key, checking their number
+func (f *family) key(values []string) string {
+	if len(values) != len(f.labels) {
+		panic(fmt.Sprintf("metric %s takes %d label values, got %d", f.name, len(f.labels), len(values)))
+	}
+	return strings.Join(values, "\xff")
+}
+
+// header writes the HELP and TYPE lines
+func (f *family) header(w *bufio.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n", f.name, strings.ReplaceAll(f.help, "\n", " "))
+	fmt.Fprintf(w, "# TYPE %s %s\n", f.name, f.kind)
+}
+
+// labelText formats label pairs, with extra pairs appended ("" if none)
+func (f *family) labelText(key string, extra ...string) string {
+	var pairs []string
+	if len(f.labels) > 0 {
+		for i, value := range strings.Split(key, "\xff") {
+			pairs = append(pairs, f.labels[i]+`="`+escapeLabel(value)+`"`)
+		}
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		pairs = append(pairs, extra[i]+`="`+escapeLabel(extra[i+1])+`"`)
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// sortedKeys returns series keys in a stable order
+func sortedKeys(series map[string]float64) []string {
+	keys := make([]string, 0, len(series))
+	for key := range series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// escapeLabel escapes a label value for the exposition format
+func escapeLabel(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+// formatValue writes a sample value the way Prometheus expects it
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// ================================================================================
+// COUNTERS AND GAUGES
+// ================================================================================
+
+// CounterVec is a counter per combination of label values; counters only go up
+type CounterVec struct {
+	family
+	series map[string]float64
+}
+
+// Counter registers a counter with the given label names
+func (r *Registry) Counter(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{family: family{name: name, help: help, kind: "counter", labels: labels}, series: make(map[string]float64)}
+	r.register(name, c)
+	return c
+}
+
+// Inc adds one to the series with these label values
+func (c *CounterVec) Inc(values ...string) {
+	c.Add(1, values...)
+}
+
+// Add adds v (which must not be negative) to the series with these label values
+func (c *CounterVec) Add(v float64, values ...string) {
+	if c == nil || v < 0 {
+		return
+	}
+	key := c.key(values)
+	c.mu.Lock()
+	c.series[key] += v
+	c.mu.Unlock()
+}
+
+func (c *CounterVec) write(w *bufio.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.header(w)
+	for _, key := range sortedKeys(c.series) {
+		fmt.Fprintf(w, "%s%s %s\n", c.name, c.labelText(key), formatValue(c.series[key]))
+	}
+}
+
+// GaugeVec is a value per combination of label values that can go up and down
+type GaugeVec struct {
+	family
+	series map[string]float64
+}
+
+// Gauge registers a gauge with the given label names
+func (r *Registry) Gauge(name, help string, labels ...string) *GaugeVec {
+	g := &GaugeVec{family: family{name: name, help: help, kind: "gauge", labels: labels}, series: make(map[string]float64)}
+	r.register(name, g)
+	return g
+}
+
+// Set sets the series with these label values to v
+func (g *GaugeVec) Set(v float64, values ...string) {
+	if g == nil {
+		return
+	}
+	key := g.key(values)
+	g.mu.Lock()
+	g.series[key] = v
+	g.mu.Unlock()
+}
+
+func (g *GaugeVec) write(w *bufio.Writer) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.header(w)
+	for _, key := range sortedKeys(g.series) {
+		fmt.Fprintf(w, "%s%s %s\n", g.name, g.labelText(key), formatValue(g.series[key]))
+	}
+}
+
+// ================================================================================
+// HISTOGRAMS
+// ================================================================================
+
+// histogramSeries is one histogram's bucket counts
+type histogramSeries struct {
+	counts []uint64 // Per bucket, not cumulative; the last is +Inf
+	sum    float64
+	count  uint64
+}
+
+// HistogramVec is a histogram per combination of label values
+type HistogramVec struct {
+	family
+	buckets []float64
+	series  map[string]*histogramSeries
+}
+
+// Histogram registers a histogram with the given bucket upper bounds
+// (ascending) and label names
+func (r *Registry) Histogram(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	bounds := append([]float64{}, buckets...)
+	sort.Float64s(bounds)
+	h := &HistogramVec{
+		family:  family{name: name, help: help, kind: "histogram", labels: labels},
+		buckets: bounds,
+		series:  make(map[string]*histogramSeries),
+	}
+	r.register(name, h)
+	return h
+}
+
+// Observe records v in the series with these label values
+func (h *HistogramVec) Observe(v float64, values ...string) {
+	if h == nil {
+		return
+	}
+	key := h.key(values)
+	bucket := sort.SearchFloat64s(h.buckets, v) // First bound >= v
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s, exists := h.series[key]
+	if !exists {
+		s = &histogramSeries{counts: make([]uint64, len(h.buckets)+1)}
+		h.series[key] = s
+	}
+	s.counts[bucket]++
+	s.sum += v
+	s.count++
+}
+
+func (h *HistogramVec) write(w *bufio.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.header(w)
+	keys := make([]string, 0, len(h.series))
+	for key := range h.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		s := h.series[key]
+		var cumulative uint64
+		for i, bound := range h.buckets {
+			cumulative += s.counts[i]
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, h.labelText(key, "le", formatValue(bound)), cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, h.labelText(key, "le", "+Inf"), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, h.labelText(key), formatValue(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, h.labelText(key), s.count)
+	}
+}
@@ -0,0 +1,45 @@
+package progress
+
+import (
+	"github.com/faanross/simulacra_txt/internal/chunker"
+	"time"
+)
+
+// ================================================================================
+// CHUNKER EVENTS
+// Routes the chunker's own progress reports through a Reporter
+// ================================================================================
+
+// reassemblyEvents are the chunker events raised while putting a message back together
+var reassemblyEvents = map[chunker.EventKind]bool{
+	chunker.EVENT_REASSEMBLY:    true,
+	chunker.EVENT_FEC_RECOVERY:  true,
+	chunker.EVENT_FEC_RECOVERED: true,
+	chunker.EVENT_DECRYPTED:     true,
+	chunker.EVENT_DECOMPRESSED:  true,
+	chunker.EVENT_REASSEMBLED:   true,
+}
+
+// ChunkerLogger adapts a reporter for ChunkerConfig.Logger. Chunker events
+// become notes named after their kind, so the bar reporter prints them as
+// before and the JSON reporter keeps their fields. Reassembly events belong
+// to the reassemble stage; the rest (including warnings and extended header
+// reports, raised both ways) to stage.
+func ChunkerLogger(r Reporter, stage Stage, subject string) chunker.Logger {
+	return chunker.LoggerFunc(func(event chunker.Event) {
+		eventStage := stage
+		if reassemblyEvents[event.Kind] {
+			eventStage = STAGE_REASSEMBLE
+		}
+
+		r.Report(Event{
+			Time:    time.Now(),
+			Stage:   eventStage,
+			Kind:    EVENT_NOTE,
+			Subject: subject,
+			Name:    string(event.Kind),
+			Text:    event.Text,
+			Fields:  event.Fields,
+		})
+	})
+}
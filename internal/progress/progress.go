@@ -0,0 +1,274 @@
+package progress
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ================================================================================
+// THEORY LESSON: Progress as Events
+// ================================================================================
+//
+// Every tool used to draw its own progress: the receiver had a bar, the
+// sender had a (different) bar, and the chunker printed prose. None of it
+// could be read by a script driving the tools. Here every stage reports
+// events instead, and the user picks what becomes of them:
+//
+//   bar    - a progress bar on a terminal, a summary line when piped
+//   json   - one JSON object per event on stderr, for scripts and dashboards
+//   silent - nothing
+//
+// A stage is one step of a transfer: chunk, upload, retrieve, reassemble,
+// decode. It reports start, progress (current of total), notes, and done
+// or failed, tagged with the message it concerns, so interleaved transfers
+// can be told apart in the event stream.
+// ================================================================================
+
+// Mode selects how events are presented
+type Mode string
+
+const (
+	MODE_BAR    Mode = "bar"    // Terminal progress bar (the default)
+	MODE_JSON   Mode = "json"   // JSON lines on stderr
+	MODE_SILENT Mode = "silent" // No progress output
+
+	// BAR_WIDTH is the number of cells in a progress bar
+	BAR_WIDTH = 30
+)
+
+// Stage names a step of a transfer
+type Stage string
+
+const (
+	STAGE_CHUNK      Stage = "chunk"
+	STAGE_UPLOAD     Stage = "upload"
+	STAGE_RETRIEVE   Stage = "retrieve"
+	STAGE_REASSEMBLE Stage = "reassemble"
+	STAGE_DECODE     Stage = "decode"
+)
+
+// EventKind says what an event reports about its stage
+type EventKind string
+
+const (
+	EVENT_START    EventKind = "start"
+	EVENT_PROGRESS EventKind = "progress"
+	EVENT_NOTE     EventKind = "note"
+	EVENT_DONE     EventKind = "done"
+	EVENT_FAILED   EventKind = "failed"
+)
+
+// Event is one progress report
+type Event struct {
+	Time    time.Time              `json:"time"`
+	Stage   Stage                  `json:"stage"`
+	Kind    EventKind              `json:"event"`
+	Subject string                 `json:"subject,omitempty"` // Message the event concerns
+	Name    string                 `json:"name,omitempty"`    // Finer-grained event name for notes
+	Current int                    `json:"current,omitempty"`
+	Total   int                    `json:"total,omitempty"`
+	Text    string                 `json:"text,omitempty"` // Human-readable report
+	Fields  map[string]interface{} `json:"fields,omitempty"`
+}
+
+// Reporter receives progress events
+type Reporter interface {
+	Report(event Event)
+}
+
+// ModeNames lists the supported modes
+func ModeNames() []string {
+	return []string{string(MODE_BAR), string(MODE_JSON), string(MODE_SILENT)}
+}
+
+// ParseMode maps a name to a mode
+func ParseMode(name string) (Mode, error) {
+	switch Mode(strings.ToLower(strings.TrimSpace(name))) {
+	case "", MODE_BAR:
+		return MODE_BAR, nil
+	case MODE_JSON:
+		return MODE_JSON, nil
+	case MODE_SILENT:
+		return MODE_SILENT, nil
+	}
+	return "", fmt.Errorf("unknown progress mode %q (supported: %s)", name, strings.Join(ModeNames(), ", "))
+}
+
+// New creates the reporter for a mode name
+func New(name string) (Reporter, error) {
+	mode, err := ParseMode(name)
+	if err != nil {
+		return nil, err
+	}
+
+	switch mode {
+	case MODE_JSON:
+		return NewJSONReporter(os.Stderr), nil
+	case MODE_SILENT:
+		return Silent, nil
+	}
+	return NewBarReporter(os.Stdout), nil
+}
+
+// ================================================================================
+// REPORTERS
+// ================================================================================
+
+// silentReporter drops every event
+type silentReporter struct{}
+
+func (silentReporter) Report(Event) {}
+
+// Silent is a reporter that drops every event
+var Silent Reporter = silentReporter{}
+
+// BarReporter draws progress bars and prints notes
+type BarReporter struct {
+	out io.Writer
+	tty bool // Redraw in place; otherwise print one line per finished stage
+	mu  sync.Mutex
+}
+
+// NewBarReporter creates a bar reporter writing to out
+func NewBarReporter(out io.Writer) *BarReporter {
+	return &BarReporter{out: out, tty: isTerminal(out)}
+}
+
+// Report renders one event
+func (b *BarReporter) Report(event Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch event.Kind {
+	case EVENT_NOTE:
+		fmt.Fprintln(b.out, event.Text)
+	case EVENT_PROGRESS:
+		if b.tty && event.Total > 0 {
+			fmt.Fprintf(b.out, "\r%s", bar(event))
+		}
+	case EVENT_DONE, EVENT_FAILED:
+		if event.Total <= 0 {
+			return
+		}
+		if b.tty {
+			fmt.Fprintln(b.out) // New line after progress bar
+		} else {
+			fmt.Fprintln(b.out, bar(event))
+		}
+	}
+}
+
+// bar formats a progress line
+func bar(event Event) string {
+	percent := float64(event.Current) / float64(event.Total) * 100
+	filled := BAR_WIDTH * event.Current / event.Total
+	if filled > BAR_WIDTH {
+		filled = BAR_WIDTH
+	}
+
+	line := fmt.Sprintf("   [%s] %d/%d (%.1f%%)",
+		strings.Repeat("█", filled)+strings.Repeat("░", BAR_WIDTH-filled),
+		event.Current, event.Total, percent)
+	if event.Subject != "" {
+		line += " " + event.Subject
+	}
+	return line
+}
+
+// isTerminal reports whether out is a character device (a terminal)
+func isTerminal(out io.Writer) bool {
+	file, ok := out.(*os.File)
+	if !ok {
+		return false
+	}
+	info, err := file.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// JSONReporter writes each event as one line of JSON
+type JSONReporter struct {
+	encoder *json.Encoder
+	mu      sync.Mutex
+}
+
+// NewJSONReporter creates a JSON reporter writing to out
+func NewJSONReporter(out io.Writer) *JSONReporter {
+	return &JSONReporter{encoder: json.NewEncoder(out)}
+}
+
+// Report writes one event
+func (j *JSONReporter) Report(event Event) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.encoder.Encode(event)
+}
+
+// ================================================================================
+// STAGE TRACKING
+// ================================================================================
+
+// Task tracks one stage of one transfer
+type Task struct {
+	reporter Reporter
+	stage    Stage
+	subject  string
+	total    int
+	current  int
+}
+
+// Begin reports the start of a stage with total steps (0 = unknown)
+func Begin(r Reporter, stage Stage, subject string, total int) *Task {
+	t := &Task{reporter: r, stage: stage, subject: subject, total: total}
+	t.emit(EVENT_START, "")
+	return t
+}
+
+// Update reports that current steps are complete
+func (t *Task) Update(current int) {
+	t.current = current
+	t.emit(EVENT_PROGRESS, "")
+}
+
+// Add reports n more completed steps
+func (t *Task) Add(n int) {
+	t.Update(t.current + n)
+}
+
+// Finish reports the end of the stage, failed when err is set
+func (t *Task) Finish(err error) {
+	if err != nil {
+		t.emit(EVENT_FAILED, err.Error())
+		return
+	}
+	t.emit(EVENT_DONE, "")
+}
+
+// emit sends a task event
+func (t *Task) emit(kind EventKind, text string) {
+	t.reporter.Report(Event{
+		Time:    time.Now(),
+		Stage:   t.stage,
+		Kind:    kind,
+		Subject: t.subject,
+		Current: t.current,
+		Total:   t.total,
+		Text:    text,
+	})
+}
+
+// Note reports a human-readable message with optional machine-readable fields
+func Note(r Reporter, stage Stage, subject string, fields map[string]interface{}, format string, args ...interface{}) {
+	r.Report(Event{
+		Time:    time.Now(),
+		Stage:   stage,
+		Kind:    EVENT_NOTE,
+		Subject: subject,
+		Text:    fmt.Sprintf(format, args...),
+		Fields:  fields,
+	})
+}
@@ -0,0 +1,187 @@
+package publisher
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/faanross/simulacra_txt/internal/chunker"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// ================================================================================
+// CLOUDFLARE
+// Reconciles each RRset with the zone's records through the v4 API
+// ================================================================================
+
+// LESSON: Records, Not Sets
+// Cloudflare stores records individually, so an RRset is upserted by
+// listing what exists at the name, creating what's missing and deleting
+// what's no longer wanted. Records that already match are left alone,
+// which keeps republishing a message cheap.
+
+const (
+	CLOUDFLARE_ENDPOINT = "https://api.cloudflare.com/client/v4"
+
+	// Records listed per page; more than any RRset we publish
+	CLOUDFLARE_PAGE_SIZE = 100
+)
+
+// cloudflare publishes to a Cloudflare zone
+type cloudflare struct {
+	config Config
+}
+
+// newCloudflare creates a Cloudflare publisher
+func newCloudflare(config Config) (*cloudflare, error) {
+	config.Token = fromEnv(config.Token, "CLOUDFLARE_API_TOKEN", "CF_API_TOKEN")
+	if config.Token == "" {
+		return nil, errors.New("cloudflare: no API token (set CLOUDFLARE_API_TOKEN)")
+	}
+	if config.Endpoint == "" {
+		config.Endpoint = CLOUDFLARE_ENDPOINT
+	}
+	config.Endpoint = strings.TrimSuffix(config.Endpoint, "/")
+	return &cloudflare{config: config}, nil
+}
+
+// Name implements RecordPublisher
+func (c *cloudflare) Name() string {
+	return BACKEND_CLOUDFLARE
+}
+
+// cloudflareRecord is a DNS record as the API represents it
+type cloudflareRecord struct {
+	ID      string `json:"id,omitempty"`
+	Type    string `json:"type"`
+	Name    string `json:"name"`
+	Content string `json:"content"`
+	TTL     int    `json:"ttl"`
+}
+
+// cloudflareEnvelope wraps every API response
+type cloudflareEnvelope struct {
+	Success bool `json:"success"`
+	Errors  []struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"errors"`
+	Result json.RawMessage `json:"result"`
+}
+
+// Publish implements RecordPublisher
+func (c *cloudflare) Publish(ctx context.Context, records []chunker.DNSRecord) error {
+	sets := groupRRSets(records)
+	if err := checkTypes(BACKEND_CLOUDFLARE, sets); err != nil {
+		return err
+	}
+
+	for _, set := range sets {
+		if err := c.upsert(ctx, set); err != nil {
+			return fmt.Errorf("%s %s: %w", set.Name, set.Type, err)
+		}
+	}
+	return nil
+}
+
+// upsert makes the records at a name and type exactly the RRset
+func (c *cloudflare) upsert(ctx context.Context, set rrset) error {
+	var existing []cloudflareRecord
+	query := url.Values{
+		"type":     {set.Type},
+		"name":     {set.Name},
+		"per_page": {fmt.Sprint(CLOUDFLARE_PAGE_SIZE)},
+	}
+	if err := c.call(ctx, http.MethodGet, "/dns_records?"+query.Encode(), nil, &existing); err != nil {
+		return err
+	}
+
+	for _, record := range set.Records {
+		want := cloudflareRecord{Type: set.Type, Name: set.Name, Content: cloudflareContent(record), TTL: set.TTL}
+
+		found := -1
+		for i, have := range existing {
+			if have.Content == want.Content || `"`+have.Content+`"` == want.Content {
+				found = i
+				break
+			}
+		}
+		if found < 0 {
+			if err := c.call(ctx, http.MethodPost, "/dns_records", want, nil); err != nil {
+				return err
+			}
+			continue
+		}
+
+		have := existing[found]
+		existing = append(existing[:found], existing[found+1:]...)
+		if have.TTL != want.TTL {
+			if err := c.call(ctx, http.MethodPatch, "/dns_records/"+have.ID, map[string]int{"ttl": want.TTL}, nil); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Whatever is left was published before and isn't part of the set now
+	for _, stale := range existing {
+		if err := c.call(ctx, http.MethodDelete, "/dns_records/"+stale.ID, nil, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// cloudflareContent returns a record's content field: TXT as quoted
+// character-strings, names without the trailing dot
+func cloudflareContent(record chunker.DNSRecord) string {
+	if record.Type == chunker.RECORD_TXT || record.Type == "" {
+		return record.RData()
+	}
+	return record.Value
+}
+
+// call sends one API request under the zone and decodes its result into out
+func (c *cloudflare) call(ctx context.Context, method, path string, in, out interface{}) error {
+	var body []byte
+	if in != nil {
+		var err error
+		if body, err = json.Marshal(in); err != nil {
+			return fmt.Errorf("cloudflare: failed to build request: %w", err)
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.config.Endpoint+"/zones/"+url.PathEscape(c.config.Zone)+path, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("cloudflare: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+c.config.Token)
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	reply, err := do(c.config.Client, BACKEND_CLOUDFLARE, req)
+	if err != nil {
+		return err
+	}
+
+	var envelope cloudflareEnvelope
+	if err := json.Unmarshal(reply, &envelope); err != nil {
+		return fmt.Errorf("cloudflare: unexpected response: %w", err)
+	}
+	if !envelope.Success {
+		messages := make([]string, 0, len(envelope.Errors))
+		for _, e := range envelope.Errors {
+			messages = append(messages, fmt.Sprintf("%d %s", e.Code, e.Message))
+		}
+		return fmt.Errorf("cloudflare API: %s", strings.Join(messages, "; "))
+	}
+	if out != nil {
+		if err := json.Unmarshal(envelope.Result, out); err != nil {
+			return fmt.Errorf("cloudflare: unexpected result: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+package publisher
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/faanross/simulacra_txt/internal/chunker"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// ================================================================================
+// POWERDNS
+// Replaces RRsets in one PATCH through the PowerDNS HTTP API
+// ================================================================================
+
+// LESSON: One Atomic Change
+// PowerDNS applies a zone PATCH as a single transaction: either every
+// RRset of the message is replaced or none is, so receivers never see half
+// a message. The manifest still goes last within the PATCH, for servers
+// that replicate changes in order.
+
+// DEFAULT_POWERDNS_SERVER is the server ID of a standalone PowerDNS
+const DEFAULT_POWERDNS_SERVER = "localhost"
+
+// powerDNS publishes to a zone on a PowerDNS authoritative server
+type powerDNS struct {
+	config Config
+}
+
+// newPowerDNS creates a PowerDNS publisher
+func newPowerDNS(config Config) (*powerDNS, error) {
+	config.Token = fromEnv(config.Token, "PDNS_API_KEY")
+	config.Endpoint = fromEnv(config.Endpoint, "PDNS_API_URL")
+	if config.Token == "" {
+		return nil, errors.New("powerdns: no API key (set PDNS_API_KEY)")
+	}
+	if config.Endpoint == "" {
+		return nil, errors.New("powerdns: no API endpoint (e.g. http://127.0.0.1:8081, or set PDNS_API_URL)")
+	}
+	if config.Server == "" {
+		config.Server = DEFAULT_POWERDNS_SERVER
+	}
+	config.Endpoint = strings.TrimSuffix(config.Endpoint, "/")
+	return &powerDNS{config: config}, nil
+}
+
+// Name implements RecordPublisher
+func (p *powerDNS) Name() string {
+	return BACKEND_POWERDNS
+}
+
+// powerDNSRecord is one record of an RRset
+type powerDNSRecord struct {
+	Content  string `json:"content"`
+	Disabled bool   `json:"disabled"`
+}
+
+// powerDNSRRSet is an RRset change as the API represents it
+type powerDNSRRSet struct {
+	Name       string           `json:"name"`
+	Type       string           `json:"type"`
+	TTL        int              `json:"ttl"`
+	ChangeType string           `json:"changetype"`
+	Records    []powerDNSRecord `json:"records"`
+}
+
+// Publish implements RecordPublisher
+func (p *powerDNS) Publish(ctx context.Context, records []chunker.DNSRecord) error {
+	sets := groupRRSets(records)
+	if err := checkTypes(BACKEND_POWERDNS, sets); err != nil {
+		return err
+	}
+
+	changes := make([]powerDNSRRSet, 0, len(sets))
+	for _, set := range sets {
+		change := powerDNSRRSet{Name: set.Name + ".", Type: set.Type, TTL: set.TTL, ChangeType: "REPLACE"}
+		for _, record := range set.Records {
+			change.Records = append(change.Records, powerDNSRecord{Content: record.RData()})
+		}
+		changes = append(changes, change)
+	}
+
+	body, err := json.Marshal(map[string]interface{}{"rrsets": changes})
+	if err != nil {
+		return fmt.Errorf("powerdns: failed to build request: %w", err)
+	}
+
+	zone := p.config.Zone
+	if !strings.HasSuffix(zone, ".") {
+		zone += "."
+	}
+	target := fmt.Sprintf("%s/api/v1/servers/%s/zones/%s", p.config.Endpoint,
+		url.PathEscape(p.config.Server), url.PathEscape(zone))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPatch, target, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("powerdns: %w", err)
+	}
+	req.Header.Set("X-API-Key", p.config.Token)
+	req.Header.Set("Content-Type", "application/json")
+
+	_, err = do(p.config.Client, BACKEND_POWERDNS, req)
+	return err
+}
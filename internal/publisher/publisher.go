@@ -0,0 +1,198 @@
+package publisher
+
+import (
+	"context"
+	"fmt"
+	"github.com/faanross/simulacra_txt/internal/chunker"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ================================================================================
+// THEORY LESSON: Publishing to Real DNS
+// ================================================================================
+//
+// Our own DNS server answers for the covert domain only if it is the
+// domain's authoritative server. Often it's simpler to publish the chunk
+// records with a DNS provider that already is, through its API:
+//
+//   route53    - AWS, ChangeResourceRecordSets (SigV4-signed XML)
+//   cloudflare - Cloudflare API v4, one call per record (bearer token)
+//   powerdns   - PowerDNS HTTP API, one PATCH for the whole zone (API key)
+//   rfc2136    - standard DNS UPDATE to any primary server (TSIG key)
+//
+// Providers think in RRsets - all records sharing a name and type - not in
+// single records: an AAAA chunk is several addresses at one name, and
+// replacing the set replaces them all. Publish therefore groups records
+// into RRsets and upserts each, so publishing a message twice is harmless.
+//
+// Order matters for receivers polling a live zone: the manifest tells them
+// to start fetching, so it goes out last, after every chunk it names.
+// None of the provider APIs take NULL records; use TXT, CNAME or AAAA, or
+// DNS UPDATE, which carries any type.
+// ================================================================================
+
+// Supported backends
+const (
+	BACKEND_ROUTE53    = "route53"
+	BACKEND_CLOUDFLARE = "cloudflare"
+	BACKEND_POWERDNS   = "powerdns"
+	BACKEND_RFC2136    = "rfc2136"
+)
+
+// DEFAULT_TIMEOUT bounds each API request
+const DEFAULT_TIMEOUT = 30 * time.Second
+
+// MAX_RESPONSE_SIZE caps how much of an API response is read
+const MAX_RESPONSE_SIZE = 1 << 20
+
+// RecordPublisher pushes DNS records to an authoritative DNS provider
+type RecordPublisher interface {
+	// Name identifies the backend
+	Name() string
+
+	// Publish creates or replaces the records, one RRset per name and type.
+	// Cancelling ctx abandons the request in flight and any still to send.
+	Publish(ctx context.Context, records []chunker.DNSRecord) error
+}
+
+// Config selects where and as whom records are published. Credentials
+// left empty are read from the backend's usual environment variables.
+type Config struct {
+	Zone     string // Route53 hosted zone ID, Cloudflare zone ID, or zone name (PowerDNS, RFC 2136)
+	Token    string // Cloudflare API token, PowerDNS API key or AWS secret access key
+	KeyID    string // AWS access key ID
+	Session  string // AWS session token (temporary credentials only)
+	Endpoint string // API base URL (required for PowerDNS), or the primary's address for RFC 2136
+	Server   string // PowerDNS server ID (default: localhost)
+	TSIG     string // RFC 2136 TSIG key as [algorithm:]name:secret (nsupdate -y format)
+
+	Client *http.Client // Default: a client with DEFAULT_TIMEOUT
+}
+
+// BackendNames lists the supported backends
+func BackendNames() []string {
+	return []string{BACKEND_ROUTE53, BACKEND_CLOUDFLARE, BACKEND_POWERDNS, BACKEND_RFC2136}
+}
+
+// New creates the publisher for a backend
+func New(backend string, config Config) (RecordPublisher, error) {
+	if config.Client == nil {
+		config.Client = &http.Client{Timeout: DEFAULT_TIMEOUT}
+	}
+	if config.Zone == "" {
+		return nil, fmt.Errorf("%s: no zone given", backend)
+	}
+
+	switch strings.ToLower(backend) {
+	case BACKEND_ROUTE53:
+		return newRoute53(config)
+	case BACKEND_CLOUDFLARE:
+		return newCloudflare(config)
+	case BACKEND_POWERDNS:
+		return newPowerDNS(config)
+	case BACKEND_RFC2136:
+		return newRFC2136(config)
+	}
+	return nil, fmt.Errorf("unknown publishing backend %q (supported: %s)",
+		backend, strings.Join(BackendNames(), ", "))
+}
+
+// fromEnv returns value, or the first environment variable that is set
+func fromEnv(value string, names ...string) string {
+	for _, name := range names {
+		if value != "" {
+			break
+		}
+		value = os.Getenv(name)
+	}
+	return value
+}
+
+// rrset is the records sharing one name and type
+type rrset struct {
+	Name    string // Fully qualified, without the trailing dot
+	Type    string
+	TTL     int
+	Records []chunker.DNSRecord
+}
+
+// isManifest reports whether the set holds a message manifest
+func (s rrset) isManifest() bool {
+	return strings.HasPrefix(s.Name, "m-")
+}
+
+// groupRRSets groups records into RRsets in first-seen order, with
+// manifests moved to the end
+func groupRRSets(records []chunker.DNSRecord) []rrset {
+	var sets []rrset
+	index := make(map[string]int)
+
+	for _, record := range records {
+		recordType := record.Type
+		if recordType == "" {
+			recordType = chunker.RECORD_TXT
+		}
+		name := strings.ToLower(strings.TrimSuffix(record.Name, "."))
+		key := name + "/" + recordType
+
+		i, ok := index[key]
+		if !ok {
+			i = len(sets)
+			index[key] = i
+			sets = append(sets, rrset{Name: name, Type: recordType, TTL: record.TTL})
+		}
+		sets[i].Records = append(sets[i].Records, record)
+	}
+
+	sort.SliceStable(sets, func(i, j int) bool {
+		return !sets[i].isManifest() && sets[j].isManifest()
+	})
+	return sets
+}
+
+// checkTypes rejects record types a backend can't publish
+func checkTypes(backend string, sets []rrset) error {
+	for _, set := range sets {
+		switch set.Type {
+		case chunker.RECORD_TXT, chunker.RECORD_CNAME, chunker.RECORD_AAAA:
+		default:
+			return fmt.Errorf("%s can't publish %s records (%s)", backend, set.Type, set.Name)
+		}
+	}
+	return nil
+}
+
+// APIError is an error response from a provider's API
+type APIError struct {
+	Backend string
+	Status  int
+	Message string
+}
+
+// Error implements error
+func (e *APIError) Error() string {
+	return fmt.Sprintf("%s API: %d %s: %s", e.Backend, e.Status, http.StatusText(e.Status), e.Message)
+}
+
+// do sends a request, returning the body of a 2xx response or an *APIError
+func do(client *http.Client, backend string, req *http.Request) ([]byte, error) {
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%s API: %w", backend, err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, MAX_RESPONSE_SIZE))
+	if err != nil {
+		return nil, fmt.Errorf("%s API: failed to read response: %w", backend, err)
+	}
+	if resp.StatusCode/100 != 2 {
+		return nil, &APIError{Backend: backend, Status: resp.StatusCode, Message: strings.TrimSpace(string(body))}
+	}
+	return body, nil
+}
@@ -0,0 +1,174 @@
+package publisher
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"github.com/faanross/simulacra_txt/internal/chunker"
+	"github.com/miekg/dns"
+	"net"
+	"strings"
+	"time"
+)
+
+// ================================================================================
+// RFC 2136 DYNAMIC UPDATE
+// Publishes RRsets with standard DNS UPDATE messages, signed with TSIG
+// ================================================================================
+
+// LESSON: No API Needed
+// BIND, Knot, PowerDNS and most other authoritative servers accept DNS
+// UPDATE (RFC 2136) for zones configured to allow it: the update section
+// lists RRsets to delete and records to add, and the server applies the
+// whole message atomically or not at all. TSIG (RFC 8945) authenticates
+// the message with a shared HMAC key - the same "name:secret" nsupdate
+// takes with -y. Unlike the provider APIs, UPDATE carries any record type,
+// so NULL chunks can be published too.
+//
+// Updates go over TCP and are split into messages of at most
+// RFC2136_MAX_MESSAGE bytes; the manifest travels in the last one.
+
+const (
+	// RFC2136_MAX_MESSAGE keeps update messages well inside TCP's 64KB
+	RFC2136_MAX_MESSAGE = 48 * 1024
+
+	// TSIG_FUDGE is the clock skew (seconds) the server may allow
+	TSIG_FUDGE = 300
+
+	DEFAULT_TSIG_ALGORITHM = "hmac-sha256"
+)
+
+// tsigAlgorithms maps nsupdate algorithm names to TSIG algorithm names
+var tsigAlgorithms = map[string]string{
+	"hmac-md5":    dns.HmacMD5,
+	"hmac-sha1":   dns.HmacSHA1,
+	"hmac-sha224": dns.HmacSHA224,
+	"hmac-sha256": dns.HmacSHA256,
+	"hmac-sha384": dns.HmacSHA384,
+	"hmac-sha512": dns.HmacSHA512,
+}
+
+// TSIGKey is a shared key for signing DNS messages
+type TSIGKey struct {
+	Algorithm string // TSIG algorithm name (e.g. dns.HmacSHA256)
+	Name      string // Key name, fully qualified
+	Secret    string // Base64 secret
+}
+
+// ParseTSIGKey parses a key in nsupdate -y format: [algorithm:]name:secret
+func ParseTSIGKey(spec string) (*TSIGKey, error) {
+	parts := strings.Split(strings.TrimSpace(spec), ":")
+	if len(parts) == 2 {
+		parts = append([]string{DEFAULT_TSIG_ALGORITHM}, parts...)
+	}
+	if len(parts) != 3 || parts[1] == "" || parts[2] == "" {
+		return nil, errors.New("TSIG key must be [algorithm:]name:secret")
+	}
+
+	algorithm, ok := tsigAlgorithms[strings.ToLower(parts[0])]
+	if !ok {
+		return nil, fmt.Errorf("unknown TSIG algorithm %q", parts[0])
+	}
+	if _, err := base64.StdEncoding.DecodeString(parts[2]); err != nil {
+		return nil, errors.New("TSIG secret must be base64")
+	}
+	return &TSIGKey{Algorithm: algorithm, Name: dns.Fqdn(strings.ToLower(parts[1])), Secret: parts[2]}, nil
+}
+
+// rfc2136 publishes with DNS UPDATE messages
+type rfc2136 struct {
+	config Config
+	zone   string
+	key    *TSIGKey
+	client *dns.Client
+}
+
+// newRFC2136 creates a DNS UPDATE publisher
+func newRFC2136(config Config) (*rfc2136, error) {
+	if config.Endpoint == "" {
+		return nil, errors.New("rfc2136: no server address (the zone's primary, e.g. ns1.example.com:53)")
+	}
+	if _, _, err := net.SplitHostPort(config.Endpoint); err != nil {
+		config.Endpoint += ":53"
+	}
+
+	u := &rfc2136{
+		config: config,
+		zone:   dns.Fqdn(strings.ToLower(config.Zone)),
+		client: &dns.Client{Net: "tcp", Timeout: DEFAULT_TIMEOUT},
+	}
+
+	if spec := fromEnv(config.TSIG, "TSIG_KEY"); spec != "" {
+		key, err := ParseTSIGKey(spec)
+		if err != nil {
+			return nil, fmt.Errorf("rfc2136: %w", err)
+		}
+		u.key = key
+		u.client.TsigSecret = map[string]string{key.Name: key.Secret}
+	}
+	return u, nil
+}
+
+// Name implements RecordPublisher
+func (u *rfc2136) Name() string {
+	return BACKEND_RFC2136
+}
+
+// Publish implements RecordPublisher
+func (u *rfc2136) Publish(ctx context.Context, records []chunker.DNSRecord) error {
+	var batch [][]dns.RR
+	size := 0
+
+	for _, set := range groupRRSets(records) {
+		rrs := make([]dns.RR, 0, len(set.Records))
+		setSize := 0
+		for _, record := range set.Records {
+			rr, err := dns.NewRR(fmt.Sprintf("%s. %d IN %s %s", set.Name, set.TTL, set.Type, record.RData()))
+			if err != nil {
+				return fmt.Errorf("rfc2136: %s %s: %w", set.Name, set.Type, err)
+			}
+			if !dns.IsSubDomain(u.zone, rr.Header().Name) {
+				return fmt.Errorf("rfc2136: %s is outside zone %s", set.Name, u.zone)
+			}
+			rrs = append(rrs, rr)
+			setSize += dns.Len(rr)
+		}
+
+		if len(batch) > 0 && size+setSize > RFC2136_MAX_MESSAGE {
+			if err := u.update(ctx, batch); err != nil {
+				return err
+			}
+			batch, size = nil, 0
+		}
+		batch = append(batch, rrs)
+		size += setSize
+	}
+
+	if len(batch) > 0 {
+		return u.update(ctx, batch)
+	}
+	return nil
+}
+
+// update sends one UPDATE replacing each RRset in sets
+func (u *rfc2136) update(ctx context.Context, sets [][]dns.RR) error {
+	msg := new(dns.Msg)
+	msg.SetUpdate(u.zone)
+	for _, rrs := range sets {
+		msg.RemoveRRset(rrs[:1]) // Deletes the whole name+type, whatever it held
+		msg.Insert(rrs)
+	}
+	if u.key != nil {
+		msg.SetTsig(u.key.Name, u.key.Algorithm, TSIG_FUDGE, time.Now().Unix())
+	}
+
+	reply, _, err := u.client.ExchangeContext(ctx, msg, u.config.Endpoint)
+	if err != nil {
+		return fmt.Errorf("rfc2136: update to %s failed: %w", u.config.Endpoint, err)
+	}
+	if reply.Rcode != dns.RcodeSuccess {
+		return fmt.Errorf("rfc2136: %s refused the update: %s", u.config.Endpoint, dns.RcodeToString[reply.Rcode])
+	}
+	return nil
+}
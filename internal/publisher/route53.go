@@ -0,0 +1,233 @@
+package publisher
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"github.com/faanross/simulacra_txt/internal/chunker"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ================================================================================
+// ROUTE53
+// Upserts RRsets with ChangeResourceRecordSets, signed with AWS SigV4
+// ================================================================================
+
+// LESSON: Signing Without the SDK
+// Every Route53 call is signed with Signature Version 4: a canonical form
+// of the request is hashed, and the hash is MAC'd with a key derived from
+// the secret key, the date, the region and the service. Route53 is a
+// global service, so the region is always us-east-1.
+
+const (
+	ROUTE53_ENDPOINT = "https://route53.amazonaws.com"
+	ROUTE53_REGION   = "us-east-1"
+	ROUTE53_SERVICE  = "route53"
+	ROUTE53_XMLNS    = "https://route53.amazonaws.com/doc/2013-04-01/"
+
+	// Per ChangeResourceRecordSets request; UPSERTs count twice
+	ROUTE53_MAX_RECORDS = 1000
+	ROUTE53_MAX_CHARS   = 32000
+)
+
+// route53 publishes to an AWS Route53 hosted zone
+type route53 struct {
+	config Config
+	now    func() time.Time
+}
+
+// newRoute53 creates a Route53 publisher
+func newRoute53(config Config) (*route53, error) {
+	config.KeyID = fromEnv(config.KeyID, "AWS_ACCESS_KEY_ID")
+	config.Token = fromEnv(config.Token, "AWS_SECRET_ACCESS_KEY")
+	config.Session = fromEnv(config.Session, "AWS_SESSION_TOKEN")
+	if config.KeyID == "" || config.Token == "" {
+		return nil, errors.New("route53: no AWS credentials (set AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY)")
+	}
+	if config.Endpoint == "" {
+		config.Endpoint = ROUTE53_ENDPOINT
+	}
+	config.Zone = strings.TrimPrefix(config.Zone, "/hostedzone/")
+	return &route53{config: config, now: time.Now}, nil
+}
+
+// Name implements RecordPublisher
+func (r *route53) Name() string {
+	return BACKEND_ROUTE53
+}
+
+// Route53 request and response bodies
+type route53Record struct {
+	Value string `xml:"Value"`
+}
+
+type route53Change struct {
+	Action string `xml:"Action"`
+	Set    struct {
+		Name    string          `xml:"Name"`
+		Type    string          `xml:"Type"`
+		TTL     int             `xml:"TTL"`
+		Records []route53Record `xml:"ResourceRecords>ResourceRecord"`
+	} `xml:"ResourceRecordSet"`
+}
+
+type route53ChangeRequest struct {
+	XMLName xml.Name        `xml:"ChangeResourceRecordSetsRequest"`
+	XMLNS   string          `xml:"xmlns,attr"`
+	Comment string          `xml:"ChangeBatch>Comment"`
+	Changes []route53Change `xml:"ChangeBatch>Changes>Change"`
+}
+
+type route53ChangeResponse struct {
+	ID     string `xml:"ChangeInfo>Id"`
+	Status string `xml:"ChangeInfo>Status"`
+}
+
+// Publish implements RecordPublisher. Chunks go out in batches sized to
+// Route53's limits; the batch holding the manifest is sent last.
+func (r *route53) Publish(ctx context.Context, records []chunker.DNSRecord) error {
+	sets := groupRRSets(records)
+	if err := checkTypes(BACKEND_ROUTE53, sets); err != nil {
+		return err
+	}
+
+	var batch []route53Change
+	count, chars := 0, 0
+	for _, set := range sets {
+		change := route53Change{Action: "UPSERT"}
+		change.Set.Name = set.Name + "."
+		change.Set.Type = set.Type
+		change.Set.TTL = set.TTL
+		size := 0
+		for _, record := range set.Records {
+			value := record.RData()
+			change.Set.Records = append(change.Set.Records, route53Record{Value: value})
+			size += len(value)
+		}
+
+		if len(batch) > 0 && (count+2*len(set.Records) > ROUTE53_MAX_RECORDS || chars+2*size > ROUTE53_MAX_CHARS) {
+			if err := r.change(ctx, batch); err != nil {
+				return err
+			}
+			batch, count, chars = nil, 0, 0
+		}
+		batch = append(batch, change)
+		count += 2 * len(set.Records)
+		chars += 2 * size
+	}
+	if len(batch) > 0 {
+		return r.change(ctx, batch)
+	}
+	return nil
+}
+
+// change submits one ChangeResourceRecordSets request
+func (r *route53) change(ctx context.Context, changes []route53Change) error {
+	body, err := xml.Marshal(route53ChangeRequest{
+		XMLNS:   ROUTE53_XMLNS,
+		Comment: fmt.Sprintf("%d record sets", len(changes)),
+		Changes: changes,
+	})
+	if err != nil {
+		return fmt.Errorf("route53: failed to build request: %w", err)
+	}
+	body = append([]byte(xml.Header), body...)
+
+	url := fmt.Sprintf("%s/2013-04-01/hostedzone/%s/rrset", strings.TrimSuffix(r.config.Endpoint, "/"), r.config.Zone)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("route53: %w", err)
+	}
+	req.Header.Set("Content-Type", "text/xml")
+	r.sign(req, body)
+
+	reply, err := do(r.config.Client, BACKEND_ROUTE53, req)
+	if err != nil {
+		return err
+	}
+	var result route53ChangeResponse
+	if err := xml.Unmarshal(reply, &result); err != nil {
+		return fmt.Errorf("route53: unexpected response: %w", err)
+	}
+	return nil
+}
+
+// sign adds SigV4 authentication headers to a request
+func (r *route53) sign(req *http.Request, body []byte) {
+	now := r.now().UTC()
+	amzDate := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+	payloadHash := sha256Hex(body)
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	if r.config.Session != "" {
+		req.Header.Set("X-Amz-Security-Token", r.config.Session)
+	}
+
+	// Canonical headers: lowercase names, sorted, host included
+	headers := map[string]string{
+		"content-type": req.Header.Get("Content-Type"),
+		"host":         req.URL.Host,
+		"x-amz-date":   amzDate,
+	}
+	names := []string{"content-type", "host", "x-amz-date"}
+	if r.config.Session != "" {
+		headers["x-amz-security-token"] = r.config.Session
+		names = append(names, "x-amz-security-token")
+	}
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + strings.TrimSpace(headers[name]) + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		path,
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := day + "/" + ROUTE53_REGION + "/" + ROUTE53_SERVICE + "/aws4_request"
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		sha256Hex([]byte(canonicalRequest)),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+r.config.Token), day)
+	key = hmacSHA256(key, ROUTE53_REGION)
+	key = hmacSHA256(key, ROUTE53_SERVICE)
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		r.config.KeyID, scope, signedHeaders, signature))
+}
+
+// sha256Hex returns the hex SHA-256 of data
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// hmacSHA256 computes HMAC-SHA256 of data under key
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
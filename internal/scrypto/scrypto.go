@@ -1,19 +1,18 @@
 package scrypto
 
 import (
-	"crypto/sha256"
 	"fmt"
 	"github.com/faanross/simulacra_txt/internal/decoder"
-	"github.com/faanross/simulacra_txt/internal/spec"
-	"golang.org/x/crypto/pbkdf2"
+	"github.com/faanross/simulacra_txt/internal/kdf"
 	"golang.org/x/term"
 	"image"
-	"strings"
 	"syscall"
+	"time"
 )
 
 // SecureMessage contains all cryptographic components
 type SecureMessage struct {
+	KDF            kdf.Params // Recorded in the payload unless legacy
 	Salt           []byte
 	Nonce          []byte
 	EncryptedData  []byte
@@ -22,14 +21,15 @@ type SecureMessage struct {
 	OriginalSize   int
 }
 
-// DeriveKey generates encryption key from password using PBKDF2
-func DeriveKey(password, salt []byte) []byte {
+// DeriveKey generates encryption key from password with the given KDF
+func DeriveKey(password, salt []byte, params kdf.Params) []byte {
 	fmt.Printf("\n🔑 Key Derivation:\n")
-	fmt.Printf("   Algorithm: PBKDF2-SHA256\n")
-	fmt.Printf("   Iterations: %d\n", spec.PBKDF2_ITERS)
+	fmt.Printf("   Algorithm: %s\n", params)
 	fmt.Printf("   Salt length: %d bytes\n", len(salt))
 
-	key := pbkdf2.Key(password, salt, spec.PBKDF2_ITERS, spec.KEY_SIZE, sha256.New)
+	start := time.Now()
+	key := params.Derive(password, salt)
+	fmt.Printf("   Derivation time: %v\n", time.Since(start).Round(time.Millisecond))
 
 	// Display key fingerprint (first 4 bytes as hex)
 	fingerprint := fmt.Sprintf("%X", key[:4])
@@ -55,41 +55,52 @@ func GetSecurePassword(prompt string) ([]byte, error) {
 	return password, nil
 }
 
-// TryMultiplePasswords attempts decryption with multiple passwords
-func TryMultiplePasswords(img image.Image, passwords []string) {
+// TryMultiplePasswords attempts decryption with multiple passwords.
+// Every attempt takes the same path and fails with the same message, whether
+// the payload was unreadable or the password was wrong. The plaintext preview
+// is only printed when showPreview is set. Payload KDF headers costlier than
+// limits are refused up front. It returns the index of the matching password
+// and the decrypted message, or -1 and nil.
+func TryMultiplePasswords(img image.Image, passwords []string, showPreview bool, limits kdf.Limits) (int, *decoder.ExtractedMessage) {
 	fmt.Printf("\n🔑 Trying %d passwords:\n", len(passwords))
 
-	for i, pass := range passwords {
-		fmt.Printf("\n   Attempt %d/%d: ", i+1, len(passwords))
+	// Extraction doesn't depend on the password, so do it once. A failure is
+	// deliberately not reported: TryDecrypt handles it like a wrong password.
+	stegDecoder := decoder.NewSecureStegoDecoder(img, nil)
+	stegDecoder.SetKDFLimits(limits)
+	stegDecoder.ExtractBitStream()
+	stegDecoder.ExtractSecurePayload()
+
+	// Every attempt would pay the refused cost, so stop before the first
+	if _, _, err := stegDecoder.KDF(); err != nil {
+		fmt.Printf("   ❌ %v\n", err)
+		return -1, nil
+	}
 
-		stegDecoder := decoder.NewSecureStegoDecoder(img, []byte(pass))
-		stegDecoder.ExtractBitStream()
+	for i, pass := range passwords {
+		password := []byte(pass)
+		result, err := stegDecoder.TryDecrypt(password)
+		decoder.Wipe(password)
 
-		err := stegDecoder.ExtractSecurePayload()
 		if err != nil {
-			fmt.Printf("❌ Failed (extraction)\n")
+			fmt.Printf("   Attempt %d/%d: ❌ Failed\n", i+1, len(passwords))
 			continue
 		}
 
-		result, err := stegDecoder.DecryptPayload()
-		if err != nil {
-			if strings.Contains(err.Error(), "AUTHENTICATION FAILED") {
-				fmt.Printf("❌ Wrong password\n")
-			} else {
-				fmt.Printf("❌ Failed: %v\n", err)
+		fmt.Printf("   Attempt %d/%d: ✅ SUCCESS!\n", i+1, len(passwords))
+		if showPreview {
+			fmt.Printf("\n📝 Decrypted message preview:\n")
+			preview := string(result.Message)
+			if len(preview) > 100 {
+				preview = preview[:100] + "..."
 			}
-			continue
-		}
-
-		fmt.Printf("✅ SUCCESS!\n")
-		fmt.Printf("\n📝 Decrypted message preview:\n")
-		preview := string(result.Message)
-		if len(preview) > 100 {
-			preview = preview[:100] + "..."
+			fmt.Printf("%s\n", preview)
+		} else {
+			fmt.Printf("   (%d bytes decrypted, content not displayed)\n", len(result.Message))
 		}
-		fmt.Printf("%s\n", preview)
-		return
+		return i, result
 	}
 
 	fmt.Printf("\n❌ All passwords failed\n")
+	return -1, nil
 }
@@ -0,0 +1,154 @@
+package transport
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"github.com/miekg/dns"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// ================================================================================
+// DNS-OVER-HTTPS
+// Carries queries as RFC 8484 POST requests to a DoH resolver
+// ================================================================================
+
+// LESSON: Looking Like a Browser
+// A DoH query is an HTTPS POST of the wire-format message with content
+// type application/dns-message; the answer comes back the same way. The
+// connection is kept open and reused (HTTP/2 where the resolver offers
+// it), so a whole transfer is one long TLS session to cloudflare-dns.com
+// or dns.google - traffic half the browsers on a network produce anyway.
+//
+// TLS hides the names but not the sizes. A chunk query's length tracks
+// its name, so queries carrying EDNS0 are padded to a multiple of
+// PADDING_BLOCK bytes (RFC 8467) as Firefox and Chrome do. The message ID
+// is sent as 0 (RFC 8484 4.1) and restored on the answer.
+
+const (
+	// DOH_CONTENT_TYPE is the media type of DNS messages over HTTPS
+	DOH_CONTENT_TYPE = "application/dns-message"
+
+	// DEFAULT_DOH_TIMEOUT bounds an exchange when the caller gives none
+	DEFAULT_DOH_TIMEOUT = 5 * time.Second
+
+	// PADDING_BLOCK is the size queries are padded to a multiple of
+	PADDING_BLOCK = 128
+)
+
+// dohResolvers are public resolvers that can be named instead of a URL
+var dohResolvers = map[string]string{
+	"cloudflare": "https://cloudflare-dns.com/dns-query",
+	"google":     "https://dns.google/dns-query",
+	"quad9":      "https://dns.quad9.net/dns-query",
+}
+
+// ResolveDoHURL returns the endpoint URL for a resolver name or URL
+func ResolveDoHURL(spec string) (string, error) {
+	spec = strings.TrimSpace(spec)
+	if known, ok := dohResolvers[strings.ToLower(spec)]; ok {
+		return known, nil
+	}
+
+	u, err := url.Parse(spec)
+	if err != nil {
+		return "", fmt.Errorf("invalid DoH URL: %w", err)
+	}
+	if u.Scheme != "https" || u.Host == "" {
+		return "", fmt.Errorf("DoH resolver must be an https:// URL or one of cloudflare, google, quad9 (got %q)", spec)
+	}
+	if u.Path == "" {
+		u.Path = "/dns-query"
+	}
+	return u.String(), nil
+}
+
+// DoH is DNS over HTTPS
+type DoH struct {
+	client *http.Client
+}
+
+// NewDoH creates a DoH transport with its own connection pool; config may
+// be nil for the system roots
+func NewDoH(config *tls.Config) *DoH {
+	pool := http.DefaultTransport.(*http.Transport).Clone()
+	pool.TLSClientConfig = config
+	return &DoH{client: &http.Client{Transport: pool}}
+}
+
+// Name implements Transport
+func (d *DoH) Name() string {
+	return NET_DOH
+}
+
+// Exchange implements Transport; server is the resolver's URL
+func (d *DoH) Exchange(ctx context.Context, m *dns.Msg, server string, timeout time.Duration) (*dns.Msg, error) {
+	if timeout == 0 {
+		timeout = DEFAULT_DOH_TIMEOUT
+	}
+
+	query := m.Copy()
+	query.Id = 0
+	pad(query)
+	wire, err := query.Pack()
+	if err != nil {
+		return nil, fmt.Errorf("doh: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, server, bytes.NewReader(wire))
+	if err != nil {
+		return nil, fmt.Errorf("doh: %w", err)
+	}
+	req.Header.Set("Content-Type", DOH_CONTENT_TYPE)
+	req.Header.Set("Accept", DOH_CONTENT_TYPE)
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("doh: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("doh: %s answered %s", server, resp.Status)
+	}
+	if contentType := resp.Header.Get("Content-Type"); !strings.HasPrefix(contentType, DOH_CONTENT_TYPE) {
+		return nil, fmt.Errorf("doh: %s answered with %q, not a DNS message", server, contentType)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, dns.MaxMsgSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("doh: failed to read answer: %w", err)
+	}
+	if len(body) > dns.MaxMsgSize {
+		return nil, errors.New("doh: answer larger than a DNS message")
+	}
+
+	reply := new(dns.Msg)
+	if err := reply.Unpack(body); err != nil {
+		return nil, fmt.Errorf("doh: invalid answer: %w", err)
+	}
+	reply.Id = m.Id
+	return reply, nil
+}
+
+// pad adds an EDNS0 padding option bringing the query to a multiple of
+// PADDING_BLOCK bytes. Queries without EDNS0 are left alone: adding an
+// OPT record would change the persona.
+func pad(m *dns.Msg) {
+	opt := m.IsEdns0()
+	if opt == nil {
+		return
+	}
+	length := m.Len() + 4 // Option code and length
+	opt.Option = append(opt.Option, &dns.EDNS0_PADDING{
+		Padding: make([]byte, (PADDING_BLOCK-length%PADDING_BLOCK)%PADDING_BLOCK),
+	})
+}
@@ -0,0 +1,137 @@
+package transport
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"github.com/miekg/dns"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ================================================================================
+// DNS-OVER-TLS
+// Carries queries over TLS to port 853 (RFC 7858)
+// ================================================================================
+
+// LESSON: One Handshake, Many Queries
+// DoT is DNS-over-TCP wrapped in TLS, so the expensive part is the
+// handshake, not the query. Connections are kept after each exchange and
+// reused for the next (up to MAX_IDLE_CONNS per server), as RFC 7858
+// recommends; one the server has since closed fails on reuse and the
+// query is repeated on a fresh connection. A transfer therefore looks like
+// one or two long TLS sessions to port 853.
+//
+// Unlike DoH, DoT can point at our own server directly (dns-server -dot)
+// as well as at a public resolver.
+
+const (
+	// DOT_PORT is the standard DNS-over-TLS port
+	DOT_PORT = "853"
+
+	// MAX_IDLE_CONNS is how many open connections are kept per server
+	MAX_IDLE_CONNS = 4
+
+	// DEFAULT_DOT_TIMEOUT bounds an exchange when the caller gives none
+	DEFAULT_DOT_TIMEOUT = 5 * time.Second
+)
+
+// dotResolvers are public resolvers that can be named instead of an address
+var dotResolvers = map[string]string{
+	"cloudflare": "cloudflare-dns.com:853",
+	"google":     "dns.google:853",
+	"quad9":      "dns.quad9.net:853",
+}
+
+// ResolveDoTAddress returns host:port for a resolver name or address,
+// adding DOT_PORT when no port is given
+func ResolveDoTAddress(spec string) (string, error) {
+	spec = strings.TrimSpace(spec)
+	if known, ok := dotResolvers[strings.ToLower(spec)]; ok {
+		return known, nil
+	}
+	if spec == "" {
+		return "", errors.New("no DoT server given")
+	}
+	if _, _, err := net.SplitHostPort(spec); err != nil {
+		return net.JoinHostPort(strings.Trim(spec, "[]"), DOT_PORT), nil
+	}
+	return spec, nil
+}
+
+// DoT is DNS over TLS
+type DoT struct {
+	config *tls.Config
+	mu     sync.Mutex
+	idle   map[string][]*dns.Conn // Server -> open connections
+}
+
+// NewDoT creates a DoT transport; config may be nil for the system roots
+func NewDoT(config *tls.Config) *DoT {
+	return &DoT{config: config, idle: make(map[string][]*dns.Conn)}
+}
+
+// Name implements Transport
+func (d *DoT) Name() string {
+	return NET_DOT
+}
+
+// Exchange implements Transport
+func (d *DoT) Exchange(ctx context.Context, m *dns.Msg, server string, timeout time.Duration) (*dns.Msg, error) {
+	if timeout == 0 {
+		timeout = DEFAULT_DOT_TIMEOUT
+	}
+	client := &dns.Client{Net: "tcp-tls", TLSConfig: d.config, Timeout: timeout}
+
+	if conn := d.take(server); conn != nil {
+		if resp, err := exchangeConn(ctx, client, m, conn); err == nil {
+			d.keep(server, conn)
+			return resp, nil
+		}
+		conn.Close() // Closed by the server while idle, most likely
+		if err := ctx.Err(); err != nil {
+			return nil, fmt.Errorf("dot: %w", err)
+		}
+	}
+
+	conn, err := client.DialContext(ctx, server)
+	if err != nil {
+		return nil, fmt.Errorf("dot: %w", err)
+	}
+	resp, err := exchangeConn(ctx, client, m, conn)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("dot: %w", err)
+	}
+	d.keep(server, conn)
+	return resp, nil
+}
+
+// take removes an idle connection to server from the pool (nil if none)
+func (d *DoT) take(server string) *dns.Conn {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	conns := d.idle[server]
+	if len(conns) == 0 {
+		return nil
+	}
+	conn := conns[len(conns)-1]
+	d.idle[server] = conns[:len(conns)-1]
+	return conn
+}
+
+// keep returns a connection to the pool, closing it if the pool is full
+func (d *DoT) keep(server string, conn *dns.Conn) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if len(d.idle[server]) >= MAX_IDLE_CONNS {
+		conn.Close()
+		return
+	}
+	d.idle[server] = append(d.idle[server], conn)
+}
@@ -0,0 +1,166 @@
+package transport
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"github.com/miekg/dns"
+	"os"
+	"strings"
+	"time"
+)
+
+// ================================================================================
+// THEORY LESSON: How a Query Travels
+// ================================================================================
+//
+// A DNS message is the same bytes however it is carried; only the envelope
+// changes, and with it who can read the question:
+//
+//   udp - one datagram each way, port 53. Everyone on the path sees it.
+//   tcp - the same, length-prefixed on a stream (large answers, RFC 7766)
+//   dot - the TCP stream inside TLS on port 853 (RFC 7858). The network
+//         sees a TLS session, but to a port that only ever carries DNS.
+//   doh - the message as an HTTPS request body (RFC 8484). The network sees
+//         a TLS connection to a big resolver like any browser's, not DNS.
+//
+// With plain DNS the client talks to our server (or a resolver recursing
+// to it) directly. With DoH it talks to a public resolver over TLS and the
+// resolver does the recursion: the chunk names never cross the local
+// network in the clear, only the resolver's own queries to our
+// authoritative server do - from the resolver's address, not ours.
+//
+// A Transport only moves messages. The query itself - ID, flags, EDNS0 -
+// is shaped beforehand (see fingerprint.Randomizer), so every transport
+// carries the same persona.
+//
+// Every exchange takes a context. Its deadline caps the timeout, and
+// cancelling it (Ctrl+C in a client, shutdown in the server) abandons a
+// query that is still waiting for its answer instead of sitting out the
+// full timeout.
+// ================================================================================
+
+// Transport names
+const (
+	NET_UDP = "udp"
+	NET_TCP = "tcp"
+	NET_DOT = "dot"
+	NET_DOH = "doh"
+)
+
+// Names lists the transports
+func Names() []string {
+	return []string{NET_UDP, NET_TCP, NET_DOT, NET_DOH}
+}
+
+// New returns the transport called name; tlsConfig is used by DoT and DoH
+// (nil = the system roots). NET_UDP is UDP with whatever TCP the caller
+// falls back to, so callers that shape queries may prefer no transport.
+func New(name string, tlsConfig *tls.Config) (Transport, error) {
+	switch strings.ToLower(name) {
+	case NET_UDP:
+		return UDP, nil
+	case NET_TCP:
+		return TCP, nil
+	case NET_DOT:
+		return NewDoT(tlsConfig), nil
+	case NET_DOH:
+		return NewDoH(tlsConfig), nil
+	}
+	return nil, fmt.Errorf("unknown transport %q (supported: %s)", name, strings.Join(Names(), ", "))
+}
+
+// ResolveServer turns a server given by a user into what the transport's
+// Exchange expects: a DoH URL or DoT address (public resolver names such
+// as cloudflare included), or host:port as given for plain DNS
+func ResolveServer(name, spec string) (string, error) {
+	switch strings.ToLower(name) {
+	case NET_DOT:
+		return ResolveDoTAddress(spec)
+	case NET_DOH:
+		return ResolveDoHURL(spec)
+	}
+	return strings.TrimSpace(spec), nil
+}
+
+// Transport sends a DNS query and returns the answer
+type Transport interface {
+	// Name identifies the transport
+	Name() string
+
+	// Exchange sends m to server - host:port, or a URL for DoH - and
+	// waits at most timeout for the answer (0 = the transport's default),
+	// or until ctx is done
+	Exchange(ctx context.Context, m *dns.Msg, server string, timeout time.Duration) (*dns.Msg, error)
+}
+
+// Plain is classic DNS over UDP or TCP
+type Plain struct {
+	Net string // NET_UDP or NET_TCP
+}
+
+// UDP and TCP are the plain transports
+var (
+	UDP = &Plain{Net: NET_UDP}
+	TCP = &Plain{Net: NET_TCP}
+)
+
+// Name implements Transport
+func (p *Plain) Name() string {
+	return p.Net
+}
+
+// Exchange implements Transport
+func (p *Plain) Exchange(ctx context.Context, m *dns.Msg, server string, timeout time.Duration) (*dns.Msg, error) {
+	client := &dns.Client{Net: p.Net, Timeout: timeout}
+	conn, err := client.DialContext(ctx, server)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	return exchangeConn(ctx, client, m, conn)
+}
+
+// exchangeConn sends m on conn. The DNS library honours ctx's deadline
+// but not its cancellation, so cancelling ctx expires the connection's
+// deadline to wake a read that is still waiting.
+func exchangeConn(ctx context.Context, client *dns.Client, m *dns.Msg, conn *dns.Conn) (*dns.Msg, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	stop := context.AfterFunc(ctx, func() {
+		conn.SetDeadline(time.Now())
+	})
+	defer stop()
+
+	resp, _, err := client.ExchangeWithConnContext(ctx, m, conn)
+	if err != nil && ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	return resp, err
+}
+
+// LoadTLSConfig returns the TLS settings for DoT and DoH: the system roots,
+// plus the PEM certificates in caFile when given (for self-signed servers)
+func LoadTLSConfig(caFile string) (*tls.Config, error) {
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+	if caFile == "" {
+		return config, nil
+	}
+
+	pem, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA certificate: %w", err)
+	}
+	roots, err := x509.SystemCertPool()
+	if err != nil {
+		roots = x509.NewCertPool()
+	}
+	if !roots.AppendCertsFromPEM(pem) {
+		return nil, errors.New("no PEM certificates in " + caFile)
+	}
+	config.RootCAs = roots
+	return config, nil
+}
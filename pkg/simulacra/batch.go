@@ -0,0 +1,134 @@
+package simulacra
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ================================================================================
+// BATCH MANIFESTS
+// The list of messages a directory was sent as, for receivers to fetch
+// ================================================================================
+
+// LESSON: One File, One Message
+// A batch send could pack a directory into a single message, but then one
+// chunk lost for good costs every file, and a receiver can't take the
+// small files first. So each file becomes its own message with its own ID
+// and manifest, and nothing in DNS ties them together. The batch manifest
+// does that instead: a JSON file the sender writes locally - file names,
+// message IDs, sizes and SHA-256 digests, never keys or passwords - and
+// hands to receivers out of band, for stego-receive -batch-manifest to
+// fetch every message it lists.
+//
+// Files that failed to chunk or upload stay in the manifest with the
+// error, so a partly sent batch says what is missing.
+
+// BATCH_MANIFEST_VERSION is the batch manifest format written
+const BATCH_MANIFEST_VERSION = 1
+
+// BatchManifest lists the messages of a batch send
+type BatchManifest struct {
+	Version  int          `json:"version"`
+	Created  time.Time    `json:"created"`
+	Server   string       `json:"server"`
+	Domain   string       `json:"domain"`
+	Messages []BatchEntry `json:"messages"`
+}
+
+// BatchEntry is one file of a batch send
+type BatchEntry struct {
+	File      string `json:"file"`                 // Name within the batch directory
+	MessageID string `json:"message_id,omitempty"` // "" = never chunked
+	Bytes     int64  `json:"bytes"`                // Size of the file, before any stego encoding
+	SHA256    string `json:"sha256,omitempty"`     // Of the file, before any stego encoding
+	Stego     bool   `json:"stego,omitempty"`      // Sent hidden in a stego image
+	Chunks    int    `json:"chunks,omitempty"`
+	Uploaded  bool   `json:"uploaded"`
+	Error     string `json:"error,omitempty"` // What went wrong, if anything
+}
+
+// BatchFiles lists the files a batch send uploads from dir: its regular,
+// non-hidden files (not subdirectories), sorted by name
+func BatchFiles(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read batch directory: %w", err)
+	}
+
+	var files []string
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		files = append(files, filepath.Join(dir, entry.Name()))
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// Uploaded returns the entries whose message was uploaded
+func (b *BatchManifest) Uploaded() []BatchEntry {
+	var uploaded []BatchEntry
+	for _, entry := range b.Messages {
+		if entry.Uploaded {
+			uploaded = append(uploaded, entry)
+		}
+	}
+	return uploaded
+}
+
+// Save writes the batch manifest to path, replacing any existing file atomically
+func (b *BatchManifest) Save(path string) error {
+	data, err := json.MarshalIndent(b, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode batch manifest: %w", err)
+	}
+
+	file, err := os.CreateTemp(filepath.Dir(path), ".batch-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temp file: %w", err)
+	}
+	tempFile := file.Name()
+
+	if _, err := file.Write(append(data, '\n')); err != nil {
+		file.Close()
+		os.Remove(tempFile)
+		return fmt.Errorf("failed to write batch manifest: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(tempFile)
+		return fmt.Errorf("failed to close temp file: %w", err)
+	}
+	if err := os.Rename(tempFile, path); err != nil {
+		os.Remove(tempFile)
+		return fmt.Errorf("failed to rename temp file: %w", err)
+	}
+	return nil
+}
+
+// LoadBatchManifest reads a batch manifest written by a batch send
+func LoadBatchManifest(path string) (*BatchManifest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read batch manifest: %w", err)
+	}
+
+	var b BatchManifest
+	if err := json.Unmarshal(data, &b); err != nil {
+		return nil, fmt.Errorf("invalid batch manifest %s: %w", path, err)
+	}
+	if b.Version != BATCH_MANIFEST_VERSION {
+		return nil, fmt.Errorf("unsupported batch manifest version %d (want %d)", b.Version, BATCH_MANIFEST_VERSION)
+	}
+	for i, entry := range b.Messages {
+		if entry.Uploaded && entry.MessageID == "" {
+			return nil, fmt.Errorf("invalid batch manifest %s: entry %d (%s) has no message ID", path, i, entry.File)
+		}
+	}
+	return &b, nil
+}
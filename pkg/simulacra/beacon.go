@@ -0,0 +1,88 @@
+package simulacra
+
+import (
+	"context"
+	"fmt"
+	"github.com/faanross/simulacra_txt/internal/chunker"
+	dnsserver "github.com/faanross/simulacra_txt/internal/dns-server"
+	"github.com/faanross/simulacra_txt/internal/progress"
+	"github.com/miekg/dns"
+	"time"
+)
+
+// ================================================================================
+// HEARTBEAT BEACONS
+// A receiver telling the server now and then that it is still there
+// ================================================================================
+
+// LESSON: Low and Slow on Purpose
+// A beacon is one TXT query for beacon.<interval>.<nonce>.<client>.<domain>
+// every Beacon, each pause varied by BeaconJitter - the server's presence
+// view (GET /admin/clients) marks the receiver alive, late or silent
+// against the interval the beacon announces. A beacon every few minutes
+// is a handful of queries an hour, next to nothing beside the chunk
+// queries, and it goes to Server like discovery and acknowledgements do:
+// those already name the client, so the beacon tells an observer nothing
+// new about who is polling.
+//
+// Lost beacons aren't retried: the next one is due soon enough, and the
+// server allows for a lost one before calling the receiver late.
+
+// SendBeacon sends one heartbeat for clientID, announcing the configured
+// interval
+func (r *Receiver) SendBeacon(ctx context.Context, clientID string) error {
+	if r.config.Beacon <= 0 {
+		return fmt.Errorf("no beacon interval configured")
+	}
+	name := dnsserver.BeaconName(clientID, chunker.NewCanaryNonce(), r.config.Beacon, r.config.Domain)
+
+	m := new(dns.Msg)
+	m.SetQuestion(dns.Fqdn(name), dns.TypeTXT)
+
+	resp, err := r.config.Queries.Exchange(ctx, m, r.config.Server, 0, r.config.QueryTimeout)
+	if err == nil && resp.Rcode != dns.RcodeSuccess {
+		err = fmt.Errorf("server answered %s", dns.RcodeToString[resp.Rcode])
+	}
+	if err != nil {
+		return fmt.Errorf("beacon not answered: %w", err)
+	}
+	r.beacons.Add(1)
+	return nil
+}
+
+// RunBeacon sends a heartbeat for clientID right away and then every
+// Beacon, jittered, until ctx is done. It does nothing without a Beacon
+// interval.
+func (r *Receiver) RunBeacon(ctx context.Context, clientID string) {
+	if r.config.Beacon <= 0 {
+		return
+	}
+
+	lost := false
+	for ctx.Err() == nil {
+		// Only changes are worth a note: the first lost beacon and the recovery
+		err := r.SendBeacon(ctx, clientID)
+		switch {
+		case err != nil && !lost && ctx.Err() == nil:
+			r.note(progress.STAGE_RETRIEVE, "", "⚠️  Beacon lost: %v", err)
+			lost = true
+		case err == nil && lost:
+			r.note(progress.STAGE_RETRIEVE, "", "💓 Beacons answered again")
+			lost = false
+		}
+
+		if sleep(ctx, jitter(float64(r.config.Beacon), r.config.BeaconJitter)) != nil {
+			return
+		}
+	}
+}
+
+// Beacons returns how many heartbeats the server answered
+func (r *Receiver) Beacons() int64 {
+	return r.beacons.Load()
+}
+
+// BeaconInterval returns the configured heartbeat interval (0 = none)
+func (r *Receiver) BeaconInterval() time.Duration {
+	return r.config.Beacon
+}
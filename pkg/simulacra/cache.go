@@ -0,0 +1,113 @@
+package simulacra
+
+import (
+	"fmt"
+	"github.com/faanross/simulacra_txt/internal/chunker"
+	"github.com/faanross/simulacra_txt/internal/clientstate"
+	"github.com/faanross/simulacra_txt/internal/progress"
+	"strings"
+)
+
+// ================================================================================
+// CHUNK CACHE
+// Reuses chunks fetched by earlier attempts (or another receiver on this host)
+// ================================================================================
+
+// LESSON: Trust, but Revalidate
+// Any process on the host can write to the cache directory, so an entry is
+// just a claim. Each one is decoded and validated like a freshly fetched
+// chunk (checksum, authentication tag when a key is set, message ID) before
+// it fills a slot, and only chunks that validated are written back.
+
+// cacheKeyName normalizes a record name for matching cache entries to slots
+func cacheKeyName(name string) string {
+	return strings.ToLower(strings.TrimSuffix(name, "."))
+}
+
+// cacheChecker returns the chunker used to validate cached and fetched
+// chunks (fetch workers share it)
+func (r *Receiver) cacheChecker() *chunker.Chunker {
+	r.once.Do(func() {
+		r.checker = r.newChunker("")
+	})
+	return r.checker
+}
+
+// validateCached decodes a chunk and checks it belongs to msgID and is intact
+func (r *Receiver) validateCached(msgID, encoded string) (*chunker.Chunk, error) {
+	chk := r.cacheChecker()
+
+	chunk, err := chk.DecodeChunk(encoded)
+	if err != nil {
+		return nil, err
+	}
+	if id := fmt.Sprintf("%x", chunk.Metadata.MessageID[:8]); id != msgID {
+		return nil, fmt.Errorf("chunk belongs to message %s", id)
+	}
+	if err := chk.ValidateChunk(chunk); err != nil {
+		return nil, err
+	}
+	return chunk, nil
+}
+
+// loadCached fills empty slots with cached chunks, returning how many it filled
+func (r *Receiver) loadCached(manifest *chunker.DNSManifest, chunks []string) int {
+	if r.config.Cache == nil {
+		return 0
+	}
+
+	entries, err := r.config.Cache.Load(manifest.MessageID)
+	if err != nil {
+		r.note(progress.STAGE_RETRIEVE, manifest.MessageID, "   ⚠️  Chunk cache unavailable: %v", err)
+		return 0
+	}
+	if len(entries) == 0 {
+		return 0
+	}
+
+	slots := make(map[string]int, len(chunks))
+	for i := range chunks {
+		slots[cacheKeyName(manifest.ChunkName(i))] = i
+	}
+
+	reused, rejected := 0, 0
+	for _, entry := range entries {
+		i, ok := slots[cacheKeyName(entry.Name)]
+		if !ok || chunks[i] != "" {
+			continue
+		}
+		if _, err := r.validateCached(manifest.MessageID, entry.Data); err != nil {
+			rejected++
+			continue
+		}
+		chunks[i] = entry.Data
+		reused++
+	}
+
+	if rejected > 0 {
+		r.note(progress.STAGE_RETRIEVE, manifest.MessageID, "   ⚠️  Ignored %d cached chunks that failed validation", rejected)
+	}
+	return reused
+}
+
+// cacheChunk stores a fetched chunk that validated as chunk
+func (r *Receiver) cacheChunk(manifest *chunker.DNSManifest, i int, encoded string, chunk *chunker.Chunk) {
+	if r.config.Cache == nil {
+		return
+	}
+
+	entry := clientstate.CachedChunk{Name: manifest.ChunkName(i), Data: encoded}
+	if err := r.config.Cache.Put(manifest.MessageID, chunk.Metadata.Sequence, chunk.Metadata.Checksum, entry); err != nil {
+		r.note(progress.STAGE_RETRIEVE, manifest.MessageID, "\n   ⚠️  Failed to cache chunk %d: %v", i, err)
+	}
+}
+
+// windowFilled reports whether every slot in chunks[from:to] already holds a chunk
+func windowFilled(chunks []string, from, to int) bool {
+	for i := from; i < to; i++ {
+		if chunks[i] == "" {
+			return false
+		}
+	}
+	return true
+}
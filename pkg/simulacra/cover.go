@@ -0,0 +1,85 @@
+package simulacra
+
+import (
+	"context"
+	"github.com/miekg/dns"
+	"math/rand"
+)
+
+// ================================================================================
+// COVER TRAFFIC
+// Where each client sends the cover engine's queries
+// ================================================================================
+
+// LESSON: Cover Goes Where the Chunks Go
+// Cover queries only blend chunk queries in if the same observer sees
+// both. So each client sends them down its own path: the sender to its
+// server, the receiver spread over its resolvers and through their
+// pacers, like its chunk queries. Both take a CoverEngine in their config
+// (nil = the built-in sites and query mix) and use it two ways:
+//
+//   Interleaved - a ratio of cover queries per chunk query (the sender's
+//                 traffic profile or CoverRatio, the receiver's CoverRatio)
+//   Background  - RunCover follows the engine's own Markov timing for as
+//                 long as the caller lets it, uploads and polls or not
+//
+// Answers are ignored: a cover query has done its job once it was seen.
+
+// coverDue returns how many cover queries go before the next chunk query
+// at ratio: its whole part, plus one more by chance for the rest
+func coverDue(ratio float64) int {
+	n := int(ratio)
+	if rand.Float64() < ratio-float64(n) {
+		n++
+	}
+	return n
+}
+
+// sendCover sends a cover query to the upload server
+func (uc *UploadClient) sendCover(ctx context.Context, m *dns.Msg) {
+	uc.config.Queries.Exchange(ctx, m, uc.config.Server, 0, uc.config.QueryTimeout) // Ignore response
+}
+
+// RunCover sends cover queries to the server at the cover engine's own
+// timing until ctx is done
+func (uc *UploadClient) RunCover(ctx context.Context) {
+	uc.config.Cover.Run(ctx, uc.sendCover)
+}
+
+// Cover returns the engine picking the client's cover queries
+func (uc *UploadClient) Cover() *CoverEngine {
+	return uc.config.Cover
+}
+
+// sendCover sends a cover query to one of the resolvers, through its pacer
+func (r *Receiver) sendCover(ctx context.Context, m *dns.Msg) {
+	res := r.pickResolver(nil)
+	if res.pacer != nil {
+		if err := res.pacer.wait(ctx); err != nil {
+			return
+		}
+	}
+	r.config.Queries.Exchange(ctx, m, res.addr, 0, r.config.QueryTimeout) // Ignore response
+}
+
+// coverChunk sends the cover queries due before a chunk query, spaced
+// like chunk queries when no pacer does it
+func (r *Receiver) coverChunk(ctx context.Context) {
+	for n := coverDue(r.config.CoverRatio); n > 0 && ctx.Err() == nil; n-- {
+		r.sendCover(ctx, r.config.Cover.Query())
+		if r.config.QueryRate == 0 {
+			sleep(ctx, FETCH_PAUSE)
+		}
+	}
+}
+
+// RunCover sends cover queries to the resolvers at the cover engine's own
+// timing until ctx is done
+func (r *Receiver) RunCover(ctx context.Context) {
+	r.config.Cover.Run(ctx, r.sendCover)
+}
+
+// Cover returns the engine picking the receiver's cover queries
+func (r *Receiver) Cover() *CoverEngine {
+	return r.config.Cover
+}
@@ -0,0 +1,108 @@
+package simulacra
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"github.com/faanross/simulacra_txt/internal/chunker"
+	"github.com/faanross/simulacra_txt/internal/clientstate"
+	"github.com/faanross/simulacra_txt/internal/progress"
+)
+
+// ================================================================================
+// RESUMABLE RETRIEVALS
+// Fetch journals let a failed or interrupted retrieval pick up where it stopped
+// ================================================================================
+
+// LESSON: Which Slots, Not Which Chunks
+// With Journals set, every chunk that arrives and validates is appended to
+// its message's journal as it lands - from whichever worker fetched it - so
+// the journal is as far along as the retrieval when it dies. The next
+// attempt refills those slots (revalidating each, as with the cache) and
+// queries only the rest.
+//
+// A journal outlives the attempt only while it can still help: once the
+// message is reassembled, or every chunk arrived and reassembly failed
+// anyway, it is removed. Missing chunks and cancellation keep it.
+
+// manifestFingerprint identifies the manifest a journal's slots belong to
+func manifestFingerprint(manifest *chunker.DNSManifest) string {
+	sum := sha256.Sum256([]byte(manifest.Value()))
+	return fmt.Sprintf("%x", sum[:8])
+}
+
+// resumeJournal opens a transfer's journal and fills empty slots from it,
+// returning how many it filled
+func (r *Receiver) resumeJournal(t *pendingTransfer) int {
+	if r.config.Journals == nil {
+		return 0
+	}
+
+	journal, entries, err := r.config.Journals.Resume(clientstate.FetchState{
+		MessageID:   t.msgID,
+		Manifest:    manifestFingerprint(t.manifest),
+		TotalChunks: len(t.chunks),
+		StartedAt:   t.started,
+	})
+	if err != nil {
+		r.note(progress.STAGE_RETRIEVE, t.msgID, "   ⚠️  Fetch journal unavailable: %v", err)
+		return 0
+	}
+	t.journal = journal
+
+	resumed, rejected := 0, 0
+	for _, entry := range entries {
+		if entry.Slot < 0 || entry.Slot >= len(t.chunks) || t.chunks[entry.Slot] != "" {
+			continue
+		}
+		if _, err := r.validateCached(t.msgID, entry.Data); err != nil {
+			rejected++
+			continue
+		}
+		t.chunks[entry.Slot] = entry.Data
+		resumed++
+	}
+
+	if rejected > 0 {
+		r.note(progress.STAGE_RETRIEVE, t.msgID, "   ⚠️  Ignored %d journaled chunks that failed validation", rejected)
+	}
+	return resumed
+}
+
+// keepChunk fills a slot with a fetched chunk, caching and journaling it
+func (r *Receiver) keepChunk(t *pendingTransfer, i int, encoded string) {
+	t.chunks[i] = encoded
+	if r.config.Cache == nil && t.journal == nil {
+		return
+	}
+
+	chunk, err := r.validateCached(t.msgID, encoded)
+	if err != nil {
+		return // Reassembly reports (or repairs) bad chunks
+	}
+	r.cacheChunk(t.manifest, i, encoded, chunk)
+
+	if t.journal == nil {
+		return
+	}
+	if err := t.journal.Append(i, encoded); err != nil {
+		r.note(progress.STAGE_RETRIEVE, t.msgID, "\n   ⚠️  Failed to journal chunk %d: %v", i, err)
+	}
+}
+
+// closeJournal ends a transfer's journal, keeping it on disk only when err
+// left chunks a later attempt could still fetch
+func (r *Receiver) closeJournal(t *pendingTransfer, err error) {
+	if t.journal == nil {
+		return
+	}
+	t.journal.Close()
+	t.journal = nil
+
+	if err != nil && t.filled() > 0 && t.filled() < len(t.chunks) {
+		r.note(progress.STAGE_RETRIEVE, t.msgID, "   💾 Fetch journal kept: %d/%d chunks, a later attempt resumes from there", t.filled(), len(t.chunks))
+		return
+	}
+	if err := r.config.Journals.Remove(t.msgID); err != nil {
+		r.note(progress.STAGE_RETRIEVE, t.msgID, "   ⚠️  %v", err)
+	}
+}
@@ -0,0 +1,172 @@
+package simulacra
+
+import (
+	"crypto/ed25519"
+	"fmt"
+	"github.com/faanross/simulacra_txt/internal/chunker"
+	"github.com/faanross/simulacra_txt/internal/progress"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// ================================================================================
+// MESSAGES AND MANIFESTS
+// Turns data into chunks plus the manifest receivers read first, and signs it
+// ================================================================================
+
+// LESSON: The Manifest Travels Apart
+// Chunks say nothing about how many of them there are or what they are
+// called - the manifest does: chunk count, message digest, naming
+// template, sharding, its recipient, TLVs for relays, and optionally the sender's
+// signature over all of it and over the chunk set. It is published last,
+// so a receiver that finds it finds every chunk.
+//
+// The signing key never leaves the sender: the server only ever sees the
+// signed manifest, and receivers get the public key (path.pub) out of
+// band. A server that swaps chunks can't re-sign the manifest to match.
+
+// ChunkOptions say how data becomes a message
+type ChunkOptions struct {
+	Config         ChunkerConfig // Encoding, FEC, integrity, compression, sizing, auth key, ...
+	NameTemplate   string        // Chunk naming template, e.g. "c-{seq}-{id}.data" ("" = chunker.DEFAULT_NAME_TEMPLATE)
+	Meta           bool          // Extended header with the file's name, type and SHA-256
+	Shard          bool          // Ask the server to split chunks across TXT, AAAA and NULL records
+	ManifestTLVs   TLVs          // Published in the manifest, readable by servers and relays
+	ManifestFormat string        // chunker.MANIFEST_FORMAT_TEXT ("") or MANIFEST_FORMAT_STRUCTURED
+	NameKey        []byte        // Keys {word} and {sub:...} names; receivers then need it too
+	Recipient      string        // Client ID the manifest addresses the message to ("" = any receiver)
+	Reporter       Reporter      // Chunker events as notes, unless Config.Logger is set (nil = progress.Silent)
+}
+
+// ChunkFile reads a file and chunks it for upload; see ChunkData
+func ChunkFile(path string, options ChunkOptions) (*ChunkSet, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	return ChunkData(data, filepath.Base(path), options)
+}
+
+// ChunkData chunks data for upload and builds its manifest. filename is
+// sent in the extended header when options.Meta is set.
+func ChunkData(data []byte, filename string, options ChunkOptions) (*ChunkSet, error) {
+	nameTemplate := options.NameTemplate
+	if nameTemplate == "" {
+		nameTemplate = chunker.DEFAULT_NAME_TEMPLATE
+	}
+	if err := chunker.ValidateNameTemplate(nameTemplate); err != nil {
+		return nil, err
+	}
+
+	// A nil Logger would have the chunker print to stdout
+	config := options.Config
+	if config.Logger == nil {
+		reporter := options.Reporter
+		if reporter == nil {
+			reporter = progress.Silent
+		}
+		config.Logger = progress.ChunkerLogger(reporter, progress.STAGE_CHUNK, filename)
+	}
+	chk := chunker.NewChunker(config)
+
+	var msg *chunker.Message
+	var err error
+	if options.Meta {
+		msg, err = chk.ChunkFile(data, filename, "")
+	} else {
+		msg, err = chk.ChunkMessage(data)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to chunk: %w", err)
+	}
+
+	msgID := fmt.Sprintf("%x", msg.ID[:8])
+	manifest := &chunker.DNSManifest{
+		MessageID:    msgID,
+		TotalChunks:  len(msg.Chunks),
+		Checksum:     chunker.MessageDigest(data),
+		Timestamp:    time.Now(),
+		NameTemplate: nameTemplate,
+		Sharded:      options.Shard,
+		TLVs:         options.ManifestTLVs,
+		Format:       options.ManifestFormat,
+		KeyedNames:   len(options.NameKey) > 0 && chunker.KeyedTemplate(nameTemplate),
+		Recipient:    options.Recipient,
+	}
+	if options.ManifestFormat == chunker.MANIFEST_FORMAT_STRUCTURED {
+		manifest.Encoding = msg.Encoding
+		if compression := msg.Chunks[0].Metadata.Compression; compression != chunker.COMPRESS_NONE {
+			manifest.Compression = compression.String()
+		}
+	}
+
+	return chunker.NewChunkSet(msg, manifest.Value()), nil
+}
+
+// SignChunkSet signs a message's manifest, covering its chunk set
+func SignChunkSet(set *ChunkSet, key ed25519.PrivateKey) error {
+	signed, err := chunker.SignManifest(set.Manifest, set.MessageID(), set.Message.Chunks, key)
+	if err != nil {
+		return err
+	}
+	set.Manifest = signed
+	return nil
+}
+
+// ParseManifest reads a manifest record of msgID under domain
+func ParseManifest(value, msgID, domain string) (*Manifest, error) {
+	return chunker.ParseManifest(value, msgID, domain)
+}
+
+// ManifestName is the record a message's manifest is published under
+func ManifestName(msgID, domain string) string {
+	return fmt.Sprintf("m-%s.data.%s", msgID, domain)
+}
+
+// WriteSigningKey generates a key pair, saving the private key to path and
+// the public key to path.pub. An existing key is never overwritten.
+func WriteSigningKey(path string) (ed25519.PublicKey, error) {
+	public, private, err := chunker.GenerateSigningKey()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate key: %w", err)
+	}
+
+	// O_EXCL: never overwrite a key receivers may already trust
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create key file: %w", err)
+	}
+	if _, err := fmt.Fprintln(file, chunker.EncodeSigningKey(private)); err != nil {
+		file.Close()
+		os.Remove(path)
+		return nil, fmt.Errorf("failed to write key file: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(path)
+		return nil, fmt.Errorf("failed to write key file: %w", err)
+	}
+
+	if err := os.WriteFile(path+".pub", []byte(chunker.EncodeVerifyKey(public)+"\n"), 0644); err != nil {
+		return nil, fmt.Errorf("failed to write public key: %w", err)
+	}
+	return public, nil
+}
+
+// LoadSigningKey reads a private key given inline (hex) or as a file path
+func LoadSigningKey(value string) (ed25519.PrivateKey, error) {
+	text, err := chunker.ReadKeyText(value)
+	if err != nil {
+		return nil, err
+	}
+	return chunker.ParseSigningKey(text)
+}
+
+// LoadVerifyKey reads a public key given inline (hex) or as a file path
+func LoadVerifyKey(value string) (ed25519.PublicKey, error) {
+	text, err := chunker.ReadKeyText(value)
+	if err != nil {
+		return nil, err
+	}
+	return chunker.ParseVerifyKey(text)
+}
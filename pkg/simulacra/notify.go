@@ -0,0 +1,166 @@
+package simulacra
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"strconv"
+	"time"
+)
+
+// ================================================================================
+// NOTIFICATION HOOKS
+// Tell other tooling when a message lands: an HTTP webhook or a local command
+// ================================================================================
+
+// LESSON: Hooks Get Facts, Not Secrets
+// A receiver daemon that only writes files makes every consumer poll a
+// directory. A hook is pushed one Notification per message instead - what
+// arrived, where it was saved, whether it decoded - as JSON: the body of a
+// webhook POST, or the stdin of a command (with the main fields also in
+// SIMULACRA_* environment variables for shell scripts). The plaintext
+// itself is never in the notification, only the path it was written to,
+// so a webhook endpoint or a log of it doesn't become a copy of the
+// message.
+//
+// Commands run without a shell: the notification can't inject anything
+// into a command line, because nothing from it ever becomes one.
+
+// Notification events
+const (
+	NOTIFY_MESSAGE = "message" // A message was retrieved (and decoded, if asked)
+	NOTIFY_FAILED  = "failed"  // Retrieving, saving or decoding a message failed
+)
+
+// Notifier delivery limits
+const (
+	// NOTIFY_TIMEOUT bounds one webhook request or hook command
+	NOTIFY_TIMEOUT = 30 * time.Second
+
+	// NOTIFY_RETRIES is how often a failed webhook delivery is repeated
+	NOTIFY_RETRIES = 2
+)
+
+// Notification describes a message that landed, or failed to
+type Notification struct {
+	Event       string    `json:"event"` // NOTIFY_*
+	MessageID   string    `json:"message_id"`
+	Time        time.Time `json:"time"`
+	Bytes       int       `json:"bytes,omitempty"`
+	ContentType string    `json:"content_type,omitempty"`
+	Filename    string    `json:"filename,omitempty"` // Sender's filename, from the extended header
+	SavedTo     string    `json:"saved_to,omitempty"`
+	Plaintext   string    `json:"plaintext,omitempty"` // Where the decoded message was written
+	Decoded     bool      `json:"decoded"`
+	Error       string    `json:"error,omitempty"`
+}
+
+// Notifier delivers notifications
+type Notifier interface {
+	Notify(ctx context.Context, n Notification) error
+}
+
+// WebhookNotifier POSTs each notification as JSON to a URL. Deliveries
+// that fail in transit or with a 5xx are retried; 4xx answers are not.
+type WebhookNotifier struct {
+	URL    string
+	Client *http.Client // nil = http.DefaultClient
+}
+
+// Notify posts n to the webhook
+func (w *WebhookNotifier) Notify(ctx context.Context, n Notification) error {
+	body, err := json.Marshal(n)
+	if err != nil {
+		return fmt.Errorf("failed to marshal notification: %w", err)
+	}
+	client := w.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	_, err = retry(ctx, NOTIFY_RETRIES, LinearBackoff(time.Second), func() error {
+		ctx, cancel := context.WithTimeout(ctx, NOTIFY_TIMEOUT)
+		defer cancel()
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, bytes.NewReader(body))
+		if err != nil {
+			return permanent(fmt.Errorf("webhook: %w", err))
+		}
+		req.Header.Set("Content-Type", "application/json")
+
+		resp, err := client.Do(req)
+		if err != nil {
+			return fmt.Errorf("webhook: %w", err)
+		}
+		resp.Body.Close()
+
+		switch {
+		case resp.StatusCode >= 500:
+			return fmt.Errorf("webhook returned %s", resp.Status)
+		case resp.StatusCode >= 300:
+			return permanent(fmt.Errorf("webhook returned %s", resp.Status))
+		}
+		return nil
+	})
+	return err
+}
+
+// ExecNotifier runs a command for each notification, with the
+// notification as JSON on stdin and in SIMULACRA_* environment variables
+type ExecNotifier struct {
+	Command []string  // Program and arguments, run without a shell
+	Stdout  io.Writer // Receives the command's output (nil = discarded)
+	Stderr  io.Writer // Receives the command's errors (nil = discarded)
+}
+
+// Notify runs the command for n
+func (e *ExecNotifier) Notify(ctx context.Context, n Notification) error {
+	if len(e.Command) == 0 {
+		return fmt.Errorf("no hook command")
+	}
+	body, err := json.Marshal(n)
+	if err != nil {
+		return fmt.Errorf("failed to marshal notification: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, NOTIFY_TIMEOUT)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, e.Command[0], e.Command[1:]...)
+	cmd.Stdin = bytes.NewReader(body)
+	cmd.Stdout = e.Stdout
+	cmd.Stderr = e.Stderr
+	cmd.Env = append(os.Environ(),
+		"SIMULACRA_EVENT="+n.Event,
+		"SIMULACRA_MESSAGE_ID="+n.MessageID,
+		"SIMULACRA_BYTES="+strconv.Itoa(n.Bytes),
+		"SIMULACRA_CONTENT_TYPE="+n.ContentType,
+		"SIMULACRA_SAVED_TO="+n.SavedTo,
+		"SIMULACRA_PLAINTEXT="+n.Plaintext,
+		"SIMULACRA_ERROR="+n.Error,
+	)
+
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("hook %s: %w", e.Command[0], err)
+	}
+	return nil
+}
+
+// Notifiers delivers to each of several notifiers, returning the first error
+type Notifiers []Notifier
+
+// Notify delivers n to every notifier, even after one fails
+func (ns Notifiers) Notify(ctx context.Context, n Notification) error {
+	var first error
+	for _, notifier := range ns {
+		if err := notifier.Notify(ctx, n); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
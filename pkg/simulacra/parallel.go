@@ -0,0 +1,167 @@
+package simulacra
+
+import (
+	"context"
+	"github.com/faanross/simulacra_txt/internal/chunker"
+	"github.com/faanross/simulacra_txt/internal/progress"
+	"sync"
+	"time"
+)
+
+// ================================================================================
+// PARALLEL CHUNK FETCHING
+// A bounded pool of workers fills a message's chunk slots in any order
+// ================================================================================
+
+// LESSON: Latency, Not Bandwidth
+// A chunk query is a few hundred bytes each way; what makes a serial
+// transfer slow is waiting out one round trip (plus FETCH_PAUSE) per
+// chunk. With N windows in flight the round trips overlap, and a message
+// arrives up to N times sooner - until the server or a resolver starts
+// dropping queries. So two limits apply:
+//
+//   Parallel  - how many windows are in flight at once (the worker pool)
+//   QueryRate - queries per second to any one resolver, whichever worker
+//               sends them (a shared pacer per resolver address)
+//
+// A dispatcher hands windows to the workers and is the only goroutine that
+// counts, reports progress or completes a transfer; workers just write the
+// chunk slots of their window. Slots of different windows never overlap,
+// so the chunks slice fills out of order without a lock.
+
+// Parallel fetching limits
+const (
+	// DEFAULT_QUERY_RATE paces parallel fetches when no QueryRate is set
+	DEFAULT_QUERY_RATE = 100
+
+	// MAX_PARALLEL caps the worker pool
+	MAX_PARALLEL = 64
+)
+
+// pacer spaces the queries sent to one resolver
+type pacer struct {
+	interval time.Duration
+	mu       sync.Mutex
+	next     time.Time // Earliest time the next query may go out
+}
+
+// wait blocks until the resolver may be sent the next query, or ctx is done
+func (p *pacer) wait(ctx context.Context) error {
+	p.mu.Lock()
+	slot := p.next
+	if now := time.Now(); slot.Before(now) {
+		slot = now
+	}
+	p.next = slot.Add(p.interval)
+	p.mu.Unlock()
+
+	return sleep(ctx, time.Until(slot))
+}
+
+// workers returns the size of the fetch worker pool
+func (r *Receiver) workers() int {
+	if r.config.Parallel < 1 {
+		return 1
+	}
+	return r.config.Parallel
+}
+
+// windowJob is one window of one message, for a fetch worker
+type windowJob struct {
+	transfer *pendingTransfer
+	from     int
+}
+
+// windowResult is what a worker fetched for a window
+type windowResult struct {
+	windowJob
+	fetched int
+	failed  int
+	retries int
+	errors  []ChunkError
+}
+
+// startWorkers launches the fetch workers. They take windows from jobs
+// until it is closed and send a result for every one.
+func (r *Receiver) startWorkers(ctx context.Context, jobs <-chan windowJob, results chan<- windowResult) {
+	// Unpaced queries keep the serial spacing between windows
+	paced := r.config.QueryRate > 0
+
+	for w := 0; w < r.workers(); w++ {
+		go func() {
+			for job := range jobs {
+				result := r.fetchWindow(ctx, job.transfer, job.from)
+				results <- result
+
+				// Cached windows sent no queries
+				if !paced && result.fetched+result.failed > 0 {
+					sleep(ctx, FETCH_PAUSE)
+				}
+			}
+		}()
+	}
+}
+
+// fetchTransfer fetches every window of one transfer on the worker pool,
+// reporting aggregate progress. Cancelling ctx stops handing out windows;
+// those in flight are waited for.
+func (r *Receiver) fetchTransfer(ctx context.Context, t *pendingTransfer) {
+	jobs := make(chan windowJob)
+	results := make(chan windowResult, r.workers())
+	r.startWorkers(ctx, jobs, results)
+	defer close(jobs)
+
+	inFlight := 0
+	for (!t.done() && ctx.Err() == nil) || inFlight > 0 {
+		var send chan<- windowJob
+		if !t.done() && ctx.Err() == nil {
+			send = jobs
+		}
+
+		select {
+		case send <- windowJob{transfer: t, from: t.next}:
+			t.next += r.batchStep()
+			inFlight++
+		case result := <-results:
+			inFlight--
+			t.record(result)
+		}
+	}
+}
+
+// record adds a window's result to the transfer and reports progress
+func (t *pendingTransfer) record(result windowResult) {
+	t.fetched += result.fetched
+	t.failed += result.failed
+	t.retries += result.retries
+	t.errors = append(t.errors, result.errors...)
+	t.task.Update(t.fetched)
+}
+
+// noteRate reports how fast the worker pool fetched a transfer's chunks
+func (r *Receiver) noteRate(t *pendingTransfer, elapsed time.Duration) {
+	rate := 0.0
+	if elapsed > 0 {
+		rate = float64(t.fetched) / elapsed.Seconds()
+	}
+	progress.Note(r.config.Reporter, progress.STAGE_RETRIEVE, t.msgID, map[string]interface{}{
+		"workers": r.workers(), "elapsed_ms": elapsed.Milliseconds(), "chunks_per_sec": rate,
+	}, "   ⚡ %d workers: %d chunks in %s (%.1f chunks/s)", r.workers(), t.fetched, elapsed.Round(time.Millisecond), rate)
+}
+
+// newTransfer starts tracking the retrieval of a message whose manifest
+// is known, filling what it can from its fetch journal and the chunk cache
+func (r *Receiver) newTransfer(msgID string, manifest *chunker.DNSManifest, started time.Time) *pendingTransfer {
+	t := &pendingTransfer{
+		msgID:    msgID,
+		manifest: manifest,
+		chunks:   make([]string, manifest.TotalChunks),
+		priority: r.priorityFor(msgID),
+		started:  started,
+	}
+	t.resumed = r.resumeJournal(t)
+	t.cached = r.loadCached(manifest, t.chunks)
+	t.fetched = t.resumed + t.cached
+	t.task = progress.Begin(r.config.Reporter, progress.STAGE_RETRIEVE, msgID, manifest.TotalChunks)
+	return t
+}
@@ -0,0 +1,218 @@
+package simulacra
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/faanross/simulacra_txt/internal/chunker"
+	"github.com/faanross/simulacra_txt/internal/progress"
+	"github.com/miekg/dns"
+	"math/rand"
+	"time"
+)
+
+// ================================================================================
+// QNAME UPLOAD
+// Sends chunks inside TXT query names: the upload rides DNS like the download
+// ================================================================================
+
+// LESSON: Every Query Is a Packet That Can Vanish
+// Over HTTP the server confirms the whole message at once. Here each part is
+// its own UDP query, so each one needs its own acknowledgement: a timeout or
+// SERVFAIL is retried, REFUSED means the server will never accept the part
+// (malformed, or not signed with its QNameKey) and NXDOMAIN that it takes
+// no uploads at all. The options and the manifest go first so the server
+// knows how many chunks to wait for; the query that completes the message
+// is acknowledged with status=complete. Every other acknowledgement says how many chunks the
+// server holds, so when the last one still falls short - the server
+// restarted, or dropped the unfinished upload - every part is sent again.
+
+// QNAME_UPLOAD_PASSES caps how often the whole message is sent when the
+// server reports it incomplete
+const QNAME_UPLOAD_PASSES = 2
+
+var (
+	// errUploadRefused marks a part the server will never accept
+	errUploadRefused = errors.New("server refused upload query")
+
+	// errUploadsOff marks a server that answers upload queries like any
+	// unknown name: it runs without -qname-uploads, or its ACL hides it
+	errUploadsOff = errors.New("server doesn't accept QNAME uploads from here (dns-server -qname-uploads, -acl)")
+)
+
+// headerPart is an upload query sent before the chunks
+type headerPart struct {
+	name  string // For errors: options or manifest
+	query string
+}
+
+// uploadQName sends the options, the manifest and every chunk as upload queries
+func (uc *UploadClient) uploadQName(ctx context.Context, msgID string, chunks []Chunk, manifest string, task *progress.Task, result *UploadResult) error {
+	uc.note(msgID, nil, "\n📤 UPLOADING MESSAGE: %s", msgID)
+	uc.note(msgID, nil, "   Chunks to upload: %d", len(chunks))
+	uc.note(msgID, nil, "   Server: %s (QNAME)", uc.config.Server)
+
+	encoder := chunker.NewQNameEncoder(uc.config.Domain, uc.config.QNameKey)
+
+	// Build every query up front, so an oversized chunk fails before
+	// anything was sent
+	var header []headerPart
+	options := chunker.QNameOptions{Tags: uc.config.Tags, Recipient: uc.config.Recipient, Burn: uc.config.Burn, Priority: uc.config.Priority, Visibility: uc.config.Visibility}
+	if !options.IsZero() {
+		optionsQuery, err := encoder.OptionsQuery(msgID, options)
+		if err != nil {
+			return fmt.Errorf("options: %w", err)
+		}
+		header = append(header, headerPart{"options", optionsQuery})
+	}
+	manifestQuery, err := encoder.ManifestQuery(msgID, manifest)
+	if err != nil {
+		return fmt.Errorf("manifest: %w", err)
+	}
+	header = append(header, headerPart{"manifest", manifestQuery})
+
+	queries := make([]string, len(chunks))
+	for i, chunk := range chunks {
+		if queries[i], err = encoder.ChunkQuery(msgID, i, chunk.Encoded); err != nil {
+			return fmt.Errorf("chunk %d: %w", i, err)
+		}
+	}
+
+	shaper := uc.newShaper(msgID)
+	if uc.traffic.Name != TRAFFIC_NONE {
+		uc.note(msgID, nil, "   Traffic profile: %s (%s)", uc.traffic.Name, uc.traffic.Description)
+	}
+
+	sent := 0
+	var last chunker.QNameAck
+	for pass := 1; pass <= QNAME_UPLOAD_PASSES; pass++ {
+		if pass > 1 {
+			uc.note(msgID, nil, "   ⚠️  Server holds %d of %d chunks, sending the message again", last.Chunks, len(chunks))
+		}
+
+		var n int
+		last, n, err = uc.sendQNamePass(ctx, header, queries, shaper, task)
+		sent += n
+		if err != nil {
+			return err
+		}
+		if last.Complete {
+			break
+		}
+	}
+
+	if !last.Complete {
+		return fmt.Errorf("server acknowledged every part but holds %d of %d chunks (total=%d)", last.Chunks, len(chunks), last.Total)
+	}
+
+	result.Queries, result.Cover = sent, shaper.covers
+	uc.note(msgID, nil, "\n✅ Upload successful!")
+	uc.note(msgID, nil, "   Message ID: %s", msgID)
+	uc.note(msgID, map[string]interface{}{"queries": sent}, "   Queries sent: %d", sent)
+	if shaper.covers > 0 {
+		uc.note(msgID, map[string]interface{}{"cover_queries": shaper.covers}, "   Cover queries: %d", shaper.covers)
+	}
+
+	return nil
+}
+
+// sendQNamePass sends the header parts in order and the chunks paced,
+// ordered and covered as the shaper's profile says, returning the
+// acknowledgement that completed the message or, if none did, the last
+// one, and how many queries were acknowledged
+func (uc *UploadClient) sendQNamePass(ctx context.Context, header []headerPart, queries []string, shaper *trafficShaper, task *progress.Task) (chunker.QNameAck, int, error) {
+	var last chunker.QNameAck
+	acked := 0
+
+	for _, part := range header {
+		ack, err := uc.sendUploadQuery(ctx, part.query)
+		if err != nil {
+			return last, acked, fmt.Errorf("%s: %w", part.name, err)
+		}
+		acked++
+		if ack.Complete {
+			return ack, acked, nil // Already published
+		}
+		last = ack
+	}
+
+	order := make([]int, len(queries))
+	for i := range order {
+		order[i] = i
+	}
+	if shaper.profile.Shuffle {
+		rand.Shuffle(len(order), func(i, j int) { order[i], order[j] = order[j], order[i] })
+	}
+
+	for sent, i := range order {
+		for n := coverDue(shaper.cover); n > 0; n-- {
+			if err := shaper.wait(ctx); err != nil {
+				return last, acked, err
+			}
+			uc.sendCover(ctx, uc.config.Cover.Query())
+			shaper.covers++
+		}
+		if err := shaper.wait(ctx); err != nil {
+			return last, acked, err
+		}
+
+		ack, err := uc.sendUploadQuery(ctx, queries[i])
+		if err != nil {
+			return last, acked, fmt.Errorf("chunk %d: %w", i, err)
+		}
+		acked++
+		task.Update(sent + 1)
+		if ack.Complete {
+			return ack, acked, nil
+		}
+		last = ack
+	}
+	return last, acked, nil
+}
+
+// sendUploadQuery sends one upload query until it is acknowledged
+func (uc *UploadClient) sendUploadQuery(ctx context.Context, name string) (chunker.QNameAck, error) {
+	var ack chunker.QNameAck
+
+	_, err := retry(ctx, uc.config.MaxRetries, LinearBackoff(500*time.Millisecond), func() error {
+		m := new(dns.Msg)
+		m.SetQuestion(dns.Fqdn(name), dns.TypeTXT)
+
+		resp, err := uc.config.Queries.Exchange(ctx, m, uc.config.Server, 0, uc.config.QueryTimeout)
+		if err != nil {
+			if ctx.Err() != nil {
+				return permanent(err)
+			}
+			return err
+		}
+
+		switch resp.Rcode {
+		case dns.RcodeSuccess:
+		case dns.RcodeRefused:
+			return permanent(errUploadRefused)
+		case dns.RcodeNameError:
+			return permanent(errUploadsOff)
+		default:
+			return fmt.Errorf("server answered %s", dns.RcodeToString[resp.Rcode])
+		}
+
+		err = errors.New("no acknowledgement in answer")
+		for _, rr := range resp.Answer {
+			txt, ok := rr.(*dns.TXT)
+			if !ok {
+				continue
+			}
+			if ack, err = chunker.ParseQNameAck(chunker.JoinTXT(txt.Txt)); err == nil {
+				return nil
+			}
+		}
+		return err
+	})
+	switch {
+	case err == nil:
+		return ack, nil
+	case errors.Is(err, errUploadRefused), errors.Is(err, errUploadsOff), ctx.Err() != nil:
+		return chunker.QNameAck{}, err
+	}
+	return chunker.QNameAck{}, fmt.Errorf("no acknowledgement after %d attempts: %w", uc.config.MaxRetries+1, err)
+}
@@ -0,0 +1,177 @@
+package simulacra
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/faanross/simulacra_txt/internal/progress"
+	"github.com/miekg/dns"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ================================================================================
+// QUERY ERROR CLASSES
+// Tells a chunk query worth repeating from one that will fail the same way again
+// ================================================================================
+
+// LESSON: Not Every Failure Is Bad Luck
+// A chunk query can fail in ways that look alike to a retry loop but mean
+// opposite things:
+//
+//   timeout / network - the packet or the path was lost; ask again
+//   SERVFAIL          - the resolver couldn't reach the zone just now; ask again
+//   NODATA            - an answer without the record (a cache-only miss, a
+//                       middlebox stripping TXT); another try may differ
+//   NXDOMAIN          - the zone says the name doesn't exist: the message
+//                       expired, was burned or never had this chunk
+//   REFUSED, FORMERR  - the server won't or can't answer this query, ever
+//
+// Repeating the last two only adds queries to the server's logs. So chunk
+// fetches retry transient classes with an exponential, jittered backoff
+// and give up on permanent ones at once - and a resolver is only charged
+// with a failure when the failure could be its fault.
+
+// Query error classes
+const (
+	ERROR_TIMEOUT  = "timeout"  // No answer in time
+	ERROR_NETWORK  = "network"  // Connecting or sending failed
+	ERROR_SERVFAIL = "servfail" // The resolver answered SERVFAIL
+	ERROR_NODATA   = "nodata"   // NOERROR without the record asked for
+	ERROR_NXDOMAIN = "nxdomain" // The name doesn't exist
+	ERROR_REFUSED  = "refused"  // The server refused the query
+	ERROR_RCODE    = "rcode"    // Any other error code (FORMERR, NOTIMP, ...)
+)
+
+// Chunk retry tuning
+const (
+	// CHUNK_BACKOFF_BASE is the pause before a chunk's first retry, before jitter
+	CHUNK_BACKOFF_BASE = 500 * time.Millisecond
+
+	// CHUNK_BACKOFF_MAX caps the pause between retries of a chunk
+	CHUNK_BACKOFF_MAX = 8 * time.Second
+
+	// MAX_LISTED_CHUNK_ERRORS bounds the missing chunks the summary lists
+	MAX_LISTED_CHUNK_ERRORS = 10
+)
+
+// QueryError is a failed DNS query together with its class
+type QueryError struct {
+	Class string // ERROR_*
+	Err   error
+}
+
+func (e *QueryError) Error() string { return e.Err.Error() }
+func (e *QueryError) Unwrap() error { return e.Err }
+
+// Transient reports whether repeating the query could succeed
+func (e *QueryError) Transient() bool {
+	switch e.Class {
+	case ERROR_NXDOMAIN, ERROR_REFUSED, ERROR_RCODE:
+		return false
+	}
+	return true
+}
+
+// errNoData is the failure of a query answered without the record asked for
+var errNoData = &QueryError{Class: ERROR_NODATA, Err: errors.New("chunk not found")}
+
+// classifyQuery turns the outcome of an exchange into a QueryError: err
+// when the query failed, else the answer's error code (nil for NOERROR)
+func classifyQuery(resp *dns.Msg, err error) error {
+	if err != nil {
+		var qerr *QueryError
+		if errors.As(err, &qerr) {
+			return err
+		}
+		var nerr net.Error
+		if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, os.ErrDeadlineExceeded) ||
+			(errors.As(err, &nerr) && nerr.Timeout()) {
+			return &QueryError{Class: ERROR_TIMEOUT, Err: err}
+		}
+		return &QueryError{Class: ERROR_NETWORK, Err: err}
+	}
+
+	class := ERROR_RCODE
+	switch resp.Rcode {
+	case dns.RcodeSuccess:
+		return nil
+	case dns.RcodeServerFailure:
+		class = ERROR_SERVFAIL
+	case dns.RcodeNameError:
+		class = ERROR_NXDOMAIN
+	case dns.RcodeRefused:
+		class = ERROR_REFUSED
+	}
+	return &QueryError{Class: class, Err: fmt.Errorf("server answered %s", dns.RcodeToString[resp.Rcode])}
+}
+
+// ErrorClass returns the ERROR_* class of a failed query ("" if err isn't one)
+func ErrorClass(err error) string {
+	var qerr *QueryError
+	if errors.As(err, &qerr) {
+		return qerr.Class
+	}
+	return ""
+}
+
+// transient reports whether a failed query is worth repeating. Errors
+// that aren't classified count as transient, as before classification.
+func transient(err error) bool {
+	var qerr *QueryError
+	return !errors.As(err, &qerr) || qerr.Transient()
+}
+
+// ChunkError records the errors one chunk ran into during a retrieval
+type ChunkError struct {
+	Chunk     int    `json:"chunk"`
+	Class     string `json:"class"` // ERROR_* of the last failure
+	Error     string `json:"error"`
+	Attempts  int    `json:"attempts"`
+	Recovered bool   `json:"recovered"` // A later attempt fetched the chunk
+}
+
+// noteChunkErrors summarizes the chunk errors of a transfer: how many
+// chunks ran into each class, then every chunk that stayed missing
+func (r *Receiver) noteChunkErrors(msgID string, chunkErrors []ChunkError) {
+	if len(chunkErrors) == 0 {
+		return
+	}
+	sort.Slice(chunkErrors, func(i, j int) bool { return chunkErrors[i].Chunk < chunkErrors[j].Chunk })
+
+	counts := make(map[string]int)
+	var classes []string
+	recovered := 0
+	for _, ce := range chunkErrors {
+		if counts[ce.Class] == 0 {
+			classes = append(classes, ce.Class)
+		}
+		counts[ce.Class]++
+		if ce.Recovered {
+			recovered++
+		}
+	}
+	sort.Strings(classes)
+	parts := make([]string, len(classes))
+	for i, class := range classes {
+		parts[i] = fmt.Sprintf("%d %s", counts[class], class)
+	}
+	r.note(progress.STAGE_RETRIEVE, msgID, "   📋 Chunk errors: %s (%d/%d chunks recovered by retrying)",
+		strings.Join(parts, ", "), recovered, len(chunkErrors))
+
+	listed := 0
+	for _, ce := range chunkErrors {
+		if ce.Recovered {
+			continue
+		}
+		if listed == MAX_LISTED_CHUNK_ERRORS {
+			r.note(progress.STAGE_RETRIEVE, msgID, "      ... and %d more", len(chunkErrors)-recovered-listed)
+			break
+		}
+		r.note(progress.STAGE_RETRIEVE, msgID, "      chunk %d: %s after %d attempt(s): %s", ce.Chunk, ce.Class, ce.Attempts, ce.Error)
+		listed++
+	}
+}
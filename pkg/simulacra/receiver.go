@@ -0,0 +1,897 @@
+package simulacra
+
+import (
+	"context"
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"github.com/faanross/simulacra_txt/internal/canary"
+	"github.com/faanross/simulacra_txt/internal/chunker"
+	"github.com/faanross/simulacra_txt/internal/clientstate"
+	"github.com/faanross/simulacra_txt/internal/cover"
+	dnsserver "github.com/faanross/simulacra_txt/internal/dns-server"
+	"github.com/faanross/simulacra_txt/internal/fingerprint"
+	"github.com/faanross/simulacra_txt/internal/progress"
+	"github.com/miekg/dns"
+	"math/rand"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ================================================================================
+// DNS RECEIVER CLIENT - Retrieves covert messages
+// ================================================================================
+
+// Message digest policies
+const (
+	DIGEST_REQUIRE = "require" // Refuse mismatches and manifests without a digest
+	DIGEST_VERIFY  = "verify"  // Refuse mismatches, warn when there is no digest
+	DIGEST_WARN    = "warn"    // Only warn
+)
+
+// Receiver defaults
+const (
+	DEFAULT_POLL_INTERVAL = 5 * time.Second
+
+	// FETCH_PAUSE spaces chunk queries so the server isn't hammered
+	FETCH_PAUSE = 50 * time.Millisecond
+)
+
+// ReceiverConfig configures a Receiver. Only Server and Domain are required.
+type ReceiverConfig struct {
+	Server       string            // DNS server or resolver address, host:port (or a DoH URL with a DoH transport)
+	Resolvers    []string          // More resolvers to spread chunk queries over, alongside Server
+	Domain       string            // Zone messages are published under
+	PollInterval time.Duration     // Pause between polls (0 = DEFAULT_POLL_INTERVAL)
+	PollMaxPause time.Duration     // Idle polling backs off up to this pause (0 = twice PollInterval)
+	PollJitter   float64           // Vary each poll pause by up to this fraction either way (0-1)
+	MaxRetries   int               // Retries of a failed chunk query (0 = DEFAULT_MAX_RETRIES, -1 = none)
+	BatchSize    int               // Chunks per range query (0 or 1 = no batching)
+	Parallel     int               // Windows fetched at once (0 or 1 = one after another; at most MAX_PARALLEL)
+	QueryRate    int               // Chunk queries per second to each resolver (0 = DEFAULT_QUERY_RATE in parallel, else FETCH_PAUSE between windows)
+	Priorities   map[string]int    // msgID -> scheduling priority when retrieving several
+	AuthKey      []byte            // Shared secret for chunk authentication tags
+	RequireAuth  bool              // Reject chunks without a valid tag
+	ReceiptKey   []byte            // Sign and send receipts when set
+	VerifyKey    ed25519.PublicKey // Require manifests signed by this key
+	NameKey      []byte            // Secret keying {word} and {sub:...} chunk names
+	DigestMode   string            // DIGEST_REQUIRE, DIGEST_VERIFY ("") or DIGEST_WARN
+	Tags         []string          // Discover only messages carrying these tags
+	Group        string            // Consumer group polled in ("" = none)
+	ArchiveDir   string            // Keep fetched chunks as <id>.chunkset archives here
+	Queries      *Queries          // Shapes DNS queries (nil = plain queries)
+	Reporter     Reporter          // Progress events and notes (nil = progress.Silent)
+	Cache        *ChunkCache       // Validated chunks from earlier attempts (nil = off)
+	Journals     *FetchJournals    // Per-message fetch journals to resume retrievals from (nil = off)
+	Cover        *CoverEngine      // Picks and times cover queries (nil = the built-in sites and mix)
+	CoverRatio   float64           // Cover queries per chunk query (0 = none)
+	Beacon       time.Duration     // Heartbeat interval for the server's presence view (0 = no beacons)
+	BeaconJitter float64           // Vary each beacon pause by up to this fraction either way (0-1)
+	History      bool              // Record every retrieval in the local transfer history
+
+	// Deadlines, on top of whatever the caller's context sets
+	QueryTimeout time.Duration // Wait for each DNS answer (0 = QUERY_TIMEOUT)
+	Timeout      time.Duration // Whole RetrieveMessage, retries included (0 = none)
+}
+
+// Receiver handles message retrieval from DNS
+type Receiver struct {
+	config  ReceiverConfig
+	caps    chunker.Capabilities // Publisher settings from _simulacra.<domain>
+	checker *chunker.Chunker     // Validates chunks entering or leaving the cache
+	once    sync.Once            // Creates checker
+
+	resolvers []*resolver // Server, then Resolvers: where chunk queries go
+
+	beacons atomic.Int64 // Heartbeats the server answered
+}
+
+// NewReceiver creates a receiver, filling in defaults
+func NewReceiver(config ReceiverConfig) (*Receiver, error) {
+	if config.Server == "" || config.Domain == "" {
+		return nil, fmt.Errorf("a receiver needs a server and a domain")
+	}
+	switch config.DigestMode {
+	case "":
+		config.DigestMode = DIGEST_VERIFY
+	case DIGEST_REQUIRE, DIGEST_VERIFY, DIGEST_WARN:
+	default:
+		return nil, fmt.Errorf("unknown digest mode %q (use %s, %s or %s)", config.DigestMode, DIGEST_REQUIRE, DIGEST_VERIFY, DIGEST_WARN)
+	}
+	if config.RequireAuth && len(config.AuthKey) == 0 {
+		return nil, fmt.Errorf("requiring chunk authentication needs an auth key")
+	}
+	if config.Parallel > MAX_PARALLEL {
+		return nil, fmt.Errorf("at most %d parallel fetches", MAX_PARALLEL)
+	}
+	if config.QueryRate < 0 {
+		return nil, fmt.Errorf("query rate can't be negative")
+	}
+	if config.CoverRatio < 0 {
+		return nil, fmt.Errorf("cover ratio can't be negative")
+	}
+	if config.Parallel > 1 && config.QueryRate == 0 {
+		config.QueryRate = DEFAULT_QUERY_RATE
+	}
+
+	if config.PollInterval <= 0 {
+		config.PollInterval = DEFAULT_POLL_INTERVAL
+	}
+	switch {
+	case config.PollMaxPause == 0:
+		config.PollMaxPause = 2 * config.PollInterval
+	case config.PollMaxPause < config.PollInterval:
+		return nil, fmt.Errorf("the longest poll pause can't be shorter than the poll interval")
+	}
+	if config.PollJitter < 0 || config.PollJitter > 1 {
+		return nil, fmt.Errorf("poll jitter must be between 0 and 1")
+	}
+	if config.Beacon < 0 {
+		return nil, fmt.Errorf("beacon interval can't be negative")
+	}
+	if config.Beacon > 0 && config.Beacon < time.Second {
+		return nil, fmt.Errorf("beacon interval must be at least a second")
+	}
+	if config.BeaconJitter < 0 || config.BeaconJitter > 1 {
+		return nil, fmt.Errorf("beacon jitter must be between 0 and 1")
+	}
+	if config.QueryTimeout <= 0 {
+		config.QueryTimeout = QUERY_TIMEOUT
+	}
+	switch {
+	case config.MaxRetries == 0:
+		config.MaxRetries = DEFAULT_MAX_RETRIES
+	case config.MaxRetries < 0:
+		config.MaxRetries = 0
+	}
+	if config.Queries == nil {
+		config.Queries = fingerprint.NewRandomizer(fingerprint.PROFILE_NONE)
+	}
+	if config.Reporter == nil {
+		config.Reporter = progress.Silent
+	}
+	if config.Cover == nil {
+		config.Cover = cover.Default()
+	}
+
+	resolvers, err := newResolvers(config.Server, config.Resolvers, config.QueryRate)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Receiver{config: config, caps: chunker.DefaultCapabilities(), resolvers: resolvers}, nil
+}
+
+// PollMaxPause returns the longest pause idle polling backs off to
+func (r *Receiver) PollMaxPause() time.Duration {
+	return r.config.PollMaxPause
+}
+
+// Capabilities returns the publisher settings in use (see Configure)
+func (r *Receiver) Capabilities() Capabilities {
+	return r.caps
+}
+
+// note reports a human-readable line about a retrieval stage
+func (r *Receiver) note(stage progress.Stage, msgID, format string, args ...interface{}) {
+	progress.Note(r.config.Reporter, stage, msgID, nil, format, args...)
+}
+
+// Configure reads the zone's capabilities record and adopts its settings.
+// Servers without the record keep the built-in defaults.
+func (r *Receiver) Configure(ctx context.Context) error {
+	caps, err := r.fetchCapabilities(ctx)
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		r.note(progress.STAGE_RETRIEVE, "", "⚠️  No capabilities record (%v), using defaults", err)
+		return nil
+	}
+
+	if err := caps.Check(); err != nil {
+		return fmt.Errorf("incompatible server: %w", err)
+	}
+
+	r.caps = caps
+	if r.config.BatchSize > caps.Batch {
+		r.config.BatchSize = caps.Batch
+	}
+	progress.Note(r.config.Reporter, progress.STAGE_RETRIEVE, "", map[string]interface{}{
+		"version": caps.Version, "encoding": caps.Encoding, "integrity": caps.Integrity, "fec": caps.FEC,
+	}, "🏷️  Server protocol v%d (encoding: %s, integrity: %s, fec: %v)", caps.Version, caps.Encoding, caps.Integrity, caps.FEC)
+
+	return nil
+}
+
+// CheckServer probes the server's canary, failing with a diagnosis when
+// the server can't serve a retrieval
+func (r *Receiver) CheckServer(ctx context.Context) (*Canary, error) {
+	report, err := canary.Probe(ctx, r.config.Queries, r.config.Server, r.config.Domain, r.config.QueryTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	r.note(progress.STAGE_RETRIEVE, "", "🐤 Server canary: %s", canary.Describe(report))
+	if report.Status != chunker.CANARY_OK {
+		r.note(progress.STAGE_RETRIEVE, "", "   ⚠️  Server reports it is busy, expect slow or retried queries")
+	}
+	return report, nil
+}
+
+// fetchCapabilities retrieves the protocol version record
+func (r *Receiver) fetchCapabilities(ctx context.Context) (chunker.Capabilities, error) {
+	value, err := r.fetchChunk(ctx, nil, chunker.CapabilitiesName(r.config.Domain))
+	if err != nil {
+		return chunker.Capabilities{}, err
+	}
+	return chunker.ParseCapabilities(value)
+}
+
+// RetrieveMessage fetches a complete message from DNS, with its extended
+// header when the sender added one. It gives up when ctx is done or the
+// config's Timeout passes.
+func (r *Receiver) RetrieveMessage(ctx context.Context, msgID string) ([]byte, *ContentInfo, error) {
+	ret, err := r.Retrieve(ctx, msgID)
+	if err != nil {
+		return nil, nil, err
+	}
+	return ret.Data, ret.Info, nil
+}
+
+// retrieveMessage performs the retrieval, filling in the manifest and
+// transfer statistics of ret
+func (r *Receiver) retrieveMessage(ctx context.Context, ret *Retrieval) ([]byte, *chunker.ContentInfo, error) {
+	msgID, record := ret.MessageID, &ret.Stats
+	r.note(progress.STAGE_RETRIEVE, msgID, "\n📥 RETRIEVING MESSAGE: %s", msgID)
+	r.note(progress.STAGE_RETRIEVE, msgID, "   Server: %s", r.config.Server)
+	if len(r.resolvers) > 1 {
+		r.note(progress.STAGE_RETRIEVE, msgID, "   Resolvers: %s", strings.Join(r.resolverAddrs(), ", "))
+	}
+	r.note(progress.STAGE_RETRIEVE, msgID, "   Domain: %s", r.config.Domain)
+
+	// LESSON: Retrieval Strategy
+	// 1. Fetch manifest first (tells us what to expect)
+	// 2. Query for each chunk
+	// 3. Handle missing/failed chunks
+	// 4. Reassemble in correct order
+	// 5. Decode from steganographic format
+
+	// Step 1: Get manifest
+	r.note(progress.STAGE_RETRIEVE, msgID, "\n1️⃣ Fetching manifest...")
+	manifest, err := r.fetchManifest(ctx, msgID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("manifest fetch failed: %w", err)
+	}
+	ret.Manifest = manifest
+	ret.Signed = manifest.Signed() && r.config.VerifyKey != nil
+	totalChunks := manifest.TotalChunks
+
+	r.note(progress.STAGE_RETRIEVE, msgID, "   ✅ Manifest retrieved")
+	r.note(progress.STAGE_RETRIEVE, msgID, "   Total chunks: %d", totalChunks)
+	if manifest.Format == chunker.MANIFEST_FORMAT_STRUCTURED {
+		compression := manifest.Compression
+		if compression == "" {
+			compression = "none"
+		}
+		r.note(progress.STAGE_RETRIEVE, msgID, "   Format: %s (encoding %s, compression %s)", manifest.Format, manifest.Encoding, compression)
+	}
+	if len(manifest.TLVs) > 0 {
+		r.note(progress.STAGE_RETRIEVE, msgID, "   TLVs: %s", manifest.TLVs)
+	}
+	record.TotalChunks = totalChunks
+
+	// Step 2: Fetch all chunks
+	r.note(progress.STAGE_RETRIEVE, msgID, "\n2️⃣ Fetching chunks...")
+	if r.workers() > 1 {
+		r.note(progress.STAGE_RETRIEVE, msgID, "   Workers: %d, at most %d queries/s per resolver", r.workers(), r.config.QueryRate)
+	}
+	transfer := r.newTransfer(msgID, manifest, record.StartedAt)
+	if transfer.resumed > 0 {
+		r.note(progress.STAGE_RETRIEVE, msgID, "   ⏯️  Resuming: %d/%d chunks from the fetch journal", transfer.resumed, totalChunks)
+	}
+	if transfer.cached > 0 {
+		r.note(progress.STAGE_RETRIEVE, msgID, "   ♻️  %d/%d chunks reused from cache", transfer.cached, totalChunks)
+	}
+
+	fetchStart := time.Now()
+	r.fetchTransfer(ctx, transfer)
+	record.Retries = transfer.retries
+
+	// Chunks lost to cancellation aren't missing, the transfer stopped
+	if err := ctx.Err(); err != nil {
+		transfer.task.Finish(err)
+		r.closeJournal(transfer, err)
+		return nil, nil, err
+	}
+
+	transfer.task.Finish(nil)
+	if r.workers() > 1 {
+		r.noteRate(transfer, time.Since(fetchStart))
+	}
+	chunks, failed := transfer.chunks, transfer.failed
+	record.FailedChunks = failed
+	ret.ChunkErrors = transfer.errors
+	r.noteChunkErrors(msgID, ret.ChunkErrors)
+
+	// Check completeness (parity chunks may still let us recover)
+	if failed > 0 {
+		r.note(progress.STAGE_RETRIEVE, msgID, "   ⚠️  %d/%d chunks missing, attempting FEC recovery", failed, totalChunks)
+	} else {
+		r.note(progress.STAGE_RETRIEVE, msgID, "   ✅ All chunks retrieved")
+	}
+
+	// Step 3: Reassemble
+	r.note(progress.STAGE_REASSEMBLE, msgID, "\n3️⃣ Reassembling message...")
+
+	reassembled, info, err := r.reassembleChunks(chunks, msgID, manifest)
+	r.closeJournal(transfer, err)
+	if err != nil {
+		if failed > 0 {
+			return nil, nil, fmt.Errorf("incomplete retrieval: %d/%d chunks missing: %w", failed, totalChunks, err)
+		}
+		return nil, nil, fmt.Errorf("reassembly failed: %w", err)
+	}
+
+	r.note(progress.STAGE_REASSEMBLE, msgID, "   ✅ Reassembled %d bytes", len(reassembled))
+
+	return reassembled, info, nil
+}
+
+// batchStep returns how many chunks each fetch turn covers
+func (r *Receiver) batchStep() int {
+	if r.config.BatchSize < 1 {
+		return 1
+	}
+	return r.config.BatchSize
+}
+
+// fetchWindow fills t.chunks[from:from+batchStep] using one range query,
+// falling back to single-chunk queries for anything the answer lacked.
+// Range queries need default naming; other layouts are fetched by the
+// names the manifest gives. It returns the chunks fetched, failed and
+// retried, with the errors the window's chunks ran into.
+func (r *Receiver) fetchWindow(ctx context.Context, t *pendingTransfer, from int) windowResult {
+	manifest, chunks := t.manifest, t.chunks
+	to := from + r.batchStep()
+	if to > len(chunks) {
+		to = len(chunks)
+	}
+
+	result := windowResult{windowJob: windowJob{transfer: t, from: from}}
+
+	if to-from > 1 && manifest.DefaultNaming() && !manifest.Sharded && !hasCarrier(manifest) && !windowFilled(chunks, from, to) {
+		r.coverChunk(ctx)
+		got, err := r.fetchRange(ctx, manifest.MessageID, from, to-1)
+		if err != nil && ctx.Err() == nil {
+			r.note(progress.STAGE_RETRIEVE, manifest.MessageID, "\n   ⚠️  Range %d-%d failed (%v), fetching individually", from, to-1, err)
+		}
+		for i, value := range got {
+			if i >= from && i < to && chunks[i] == "" {
+				r.keepChunk(t, i, value)
+				result.fetched++
+			}
+		}
+	}
+
+	for i := from; i < to && ctx.Err() == nil; i++ {
+		if chunks[i] != "" {
+			continue
+		}
+
+		r.coverChunk(ctx)
+		chunkData, chunkErr, err := r.fetchChunkWithRetry(ctx, manifest.ChunkName(i), manifest)
+		result.retries += chunkErr.Attempts - 1
+		if chunkErr.Class != "" && ctx.Err() == nil {
+			chunkErr.Chunk, chunkErr.Recovered = i, err == nil
+			result.errors = append(result.errors, chunkErr)
+		}
+		if err != nil {
+			r.note(progress.STAGE_RETRIEVE, manifest.MessageID, "\n   ❌ Failed chunk %d: %v", i, err)
+			result.failed++
+			continue
+		}
+
+		r.keepChunk(t, i, chunkData)
+		result.fetched++
+	}
+
+	return result
+}
+
+// fetchChunkWithRetry fetches a chunk, retrying transient failures with
+// an exponential backoff on a resolver the earlier attempts didn't fail
+// on; permanent ones (NXDOMAIN, REFUSED, ...) end it at once.
+// Sharded chunks are fetched as their TXT, AAAA and NULL records, chunks
+// of other carriers as the record type the manifest names.
+// It also describes the attempts: how many, and the class and text of
+// the last failure (Class is "" when the first attempt succeeded).
+func (r *Receiver) fetchChunkWithRetry(ctx context.Context, chunkName string, manifest *chunker.DNSManifest) (string, ChunkError, error) {
+	fetch := r.fetchChunk
+	if manifest.Sharded {
+		fetch = r.fetchShards
+	} else if hasCarrier(manifest) {
+		fetch = func(ctx context.Context, rt *route, name string) (string, error) {
+			return r.fetchCarrier(ctx, rt, name, manifest.RecordType)
+		}
+	}
+
+	var chunkData string
+	var chunkErr ChunkError
+	rt := &route{}
+	backoff := ExponentialBackoff(CHUNK_BACKOFF_BASE, CHUNK_BACKOFF_MAX)
+	retries, err := retry(ctx, r.config.MaxRetries, backoff, func() error {
+		var err error
+		if chunkData, err = fetch(ctx, rt, chunkName); err == nil {
+			return nil
+		}
+		chunkErr.Class, chunkErr.Error = ErrorClass(err), err.Error()
+		if !transient(err) {
+			return permanent(err)
+		}
+		rt.fail()
+		return err
+	})
+	chunkErr.Attempts = retries + 1
+	return chunkData, chunkErr, err
+}
+
+// recordTransfer finalizes transfer statistics and appends them to history
+func (r *Receiver) recordTransfer(record *clientstate.TransferRecord, data []byte, err error) {
+	record.Duration = time.Since(record.StartedAt)
+	record.Bytes = len(data)
+	record.Success = err == nil
+	if err != nil {
+		record.Error = err.Error()
+	}
+	if !r.config.History {
+		return
+	}
+
+	if err := clientstate.AppendHistory(*record); err != nil {
+		r.note(progress.STAGE_RETRIEVE, record.MessageID, "⚠️  Failed to record transfer history: %v", err)
+	}
+}
+
+// FetchManifest retrieves, parses and (with a VerifyKey) verifies a
+// message's manifest
+func (r *Receiver) FetchManifest(ctx context.Context, msgID string) (*Manifest, error) {
+	return r.fetchManifest(ctx, msgID)
+}
+
+// fetchManifest retrieves and parses the manifest record
+func (r *Receiver) fetchManifest(ctx context.Context, msgID string) (*chunker.DNSManifest, error) {
+	m := new(dns.Msg)
+	m.SetQuestion(dns.Fqdn(ManifestName(msgID, r.config.Domain)), dns.TypeTXT)
+
+	resp, err := r.config.Queries.Exchange(ctx, m, r.config.Server, 0, r.config.QueryTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	// Extract manifest data: "total:checksum:timestamp[; names=...]" or "v2:<base32 JSON>"
+	for _, ans := range resp.Answer {
+		if txt, ok := ans.(*dns.TXT); ok && len(txt.Txt) > 0 {
+			manifest, err := chunker.ParseManifest(chunker.JoinTXT(txt.Txt), msgID, r.config.Domain)
+			if err != nil {
+				return nil, err
+			}
+			if err := manifest.SetNameKey(r.config.NameKey); err != nil {
+				return nil, fmt.Errorf("%w (-names-key)", err)
+			}
+			return manifest, r.verifyManifest(manifest)
+		}
+	}
+
+	return nil, fmt.Errorf("manifest not found")
+}
+
+// verifyManifest checks the sender's signature before any chunk is fetched
+func (r *Receiver) verifyManifest(manifest *chunker.DNSManifest) error {
+	// LESSON: Verify Before Fetching
+	// A forged manifest could point at any chunk set. Checking it first
+	// costs one signature check and spares the queries for a transfer that
+	// would be rejected anyway.
+	if r.config.VerifyKey == nil {
+		if manifest.Signed() {
+			r.note(progress.STAGE_RETRIEVE, manifest.MessageID, "   ⚠️  Manifest is signed, but no -verify-key was given to check it")
+		}
+		return nil
+	}
+
+	if err := manifest.Verify(r.config.VerifyKey); err != nil {
+		return fmt.Errorf("refusing message %s: %w", manifest.MessageID, err)
+	}
+	r.note(progress.STAGE_RETRIEVE, manifest.MessageID, "   🔏 Manifest signature verified")
+	return nil
+}
+
+// fetchChunk retrieves a single chunk
+func (r *Receiver) fetchChunk(ctx context.Context, rt *route, chunkName string) (string, error) {
+	m := new(dns.Msg)
+	m.SetQuestion(dns.Fqdn(chunkName), dns.TypeTXT)
+
+	// Multi-string chunks don't fit a plain 512-byte UDP response
+	var minUDP uint16
+	if r.caps.ChunkSize > chunker.MAX_DNS_STRING_SIZE {
+		minUDP = chunker.EDNS0_BUFFER_SIZE
+	}
+
+	resp, err := r.exchange(ctx, rt, m, minUDP)
+	if err != nil {
+		return "", err
+	}
+
+	// Extract chunk data, joining multi-string records
+	for _, ans := range resp.Answer {
+		if txt, ok := ans.(*dns.TXT); ok && len(txt.Txt) > 0 {
+			return chunker.JoinTXT(txt.Txt), nil
+		}
+	}
+
+	return "", errNoData
+}
+
+// fetchShards retrieves a sharded chunk's TXT, AAAA and NULL records and
+// recombines them into the chunk value a TXT query would have returned
+func (r *Receiver) fetchShards(ctx context.Context, rt *route, chunkName string) (string, error) {
+	shards := &chunker.RecordShards{}
+
+	for _, qtype := range []uint16{dns.TypeTXT, dns.TypeAAAA, dns.TypeNULL} {
+		m := new(dns.Msg)
+		m.SetQuestion(dns.Fqdn(chunkName), qtype)
+
+		resp, err := r.exchange(ctx, rt, m, 0)
+		if err != nil {
+			return "", fmt.Errorf("%s query: %w", dns.TypeToString[qtype], err)
+		}
+
+		for _, ans := range resp.Answer {
+			switch rr := ans.(type) {
+			case *dns.TXT:
+				shards.TXT = chunker.JoinTXT(rr.Txt)
+			case *dns.AAAA:
+				shards.AAAA = append(shards.AAAA, rr.AAAA)
+			case *dns.NULL:
+				shards.NULL = []byte(rr.Data)
+			}
+		}
+	}
+
+	if shards.TXT == "" {
+		return "", errNoData
+	}
+	return shards.Value(r.caps.Encoding)
+}
+
+// hasCarrier reports whether a message's chunks travel in records other than TXT
+func hasCarrier(manifest *chunker.DNSManifest) bool {
+	return manifest.RecordType != "" && manifest.RecordType != chunker.RECORD_TXT
+}
+
+// fetchCarrier retrieves a chunk published in NULL, CNAME or AAAA records
+func (r *Receiver) fetchCarrier(ctx context.Context, rt *route, chunkName, recordType string) (string, error) {
+	m := new(dns.Msg)
+	m.SetQuestion(dns.Fqdn(chunkName), dns.StringToType[recordType])
+
+	// An AAAA set or a NULL chunk easily outgrows 512 bytes
+	resp, err := r.exchange(ctx, rt, m, chunker.EDNS0_BUFFER_SIZE)
+	if err != nil {
+		return "", err
+	}
+
+	var records []chunker.DNSRecord
+	for _, ans := range resp.Answer {
+		record := chunker.DNSRecord{Name: chunkName, Type: recordType}
+		switch rr := ans.(type) {
+		case *dns.NULL:
+			record.Value = fmt.Sprintf(`\# %d %x`, len(rr.Data), rr.Data)
+		case *dns.CNAME:
+			record.Value = rr.Target
+		case *dns.AAAA:
+			record.Value = rr.AAAA.String()
+		default:
+			continue
+		}
+		if dns.TypeToString[ans.Header().Rrtype] == recordType {
+			records = append(records, record)
+		}
+	}
+
+	if len(records) == 0 {
+		return "", errNoData
+	}
+	return chunker.NewDNSEncoder(r.config.Domain).CarrierValue(records)
+}
+
+// fetchRange retrieves chunks first..last with a single range query.
+// Records that didn't fit in the response are simply absent from the result.
+func (r *Receiver) fetchRange(ctx context.Context, msgID string, first, last int) (map[int]string, error) {
+	rangeName := fmt.Sprintf("%s.data.%s", chunker.RangeLabel(first, last, msgID), r.config.Domain)
+
+	m := new(dns.Msg)
+	m.SetQuestion(dns.Fqdn(rangeName), dns.TypeTXT)
+
+	resp, err := r.exchange(ctx, nil, m, chunker.EDNS0_BUFFER_SIZE)
+	if err != nil {
+		return nil, err
+	}
+
+	chunks := make(map[int]string)
+	for _, ans := range resp.Answer {
+		if txt, ok := ans.(*dns.TXT); ok && len(txt.Txt) > 0 {
+			index, value, err := chunker.ParseRangeValue(chunker.JoinTXT(txt.Txt))
+			if err != nil {
+				continue
+			}
+			chunks[index] = value
+		}
+	}
+
+	if len(chunks) == 0 {
+		return nil, fmt.Errorf("empty range answer")
+	}
+
+	return chunks, nil
+}
+
+// reassembleChunks reconstructs the original data and its extended header
+func (r *Receiver) reassembleChunks(encodedChunks []string, msgID string, manifest *chunker.DNSManifest) ([]byte, *chunker.ContentInfo, error) {
+	// Chunks a server substituted must not be reassembled (or archived)
+	if r.config.VerifyKey != nil {
+		if err := manifest.VerifyChunks(encodedChunks); err != nil {
+			return nil, nil, err
+		}
+		r.note(progress.STAGE_REASSEMBLE, msgID, "   🔏 Chunk set matches the signed manifest")
+	}
+
+	// Convert DNS chunks back to chunker.Chunk format
+	chk := r.newChunker(msgID)
+	if r.config.ArchiveDir != "" {
+		r.archiveChunks(chk, encodedChunks, msgID, manifest)
+	}
+	task := progress.Begin(r.config.Reporter, progress.STAGE_REASSEMBLE, msgID, 0)
+	session := chk.NewReassemblySession()
+
+	for _, encoded := range encodedChunks {
+		if encoded == "" {
+			continue // Skip missing chunks
+		}
+
+		if _, err := session.AddEncoded(encoded); err != nil {
+			err = fmt.Errorf("chunk decode failed: %w", err)
+			task.Finish(err)
+			return nil, nil, err
+		}
+	}
+
+	status := session.Status()
+	if status.Duplicates > 0 {
+		r.note(progress.STAGE_REASSEMBLE, msgID, "   Ignored %d duplicate chunks", status.Duplicates)
+	}
+	if len(status.Missing) > 0 && status.Parity > 0 {
+		r.note(progress.STAGE_REASSEMBLE, msgID, "   Missing %v, attempting parity recovery", status.Missing)
+	}
+
+	// Reassemble
+	data, info, err := session.FinalizeFile()
+	if err == nil {
+		err = r.checkDigest(manifest, data)
+	}
+	task.Finish(err)
+	if err != nil {
+		return nil, nil, err
+	}
+	return data, info, nil
+}
+
+// checkDigest verifies reassembled data against the manifest's message
+// digest, as strictly as the digest policy asks
+func (r *Receiver) checkDigest(manifest *chunker.DNSManifest, data []byte) error {
+	err := manifest.VerifyData(data)
+	switch {
+	case err == nil:
+		r.note(progress.STAGE_REASSEMBLE, manifest.MessageID, "   🧮 Message digest verified")
+		return nil
+	case errors.Is(err, chunker.ErrNoDigest):
+		if r.config.DigestMode == DIGEST_REQUIRE {
+			return fmt.Errorf("%w (-digest %s)", err, DIGEST_REQUIRE)
+		}
+		r.note(progress.STAGE_REASSEMBLE, manifest.MessageID, "   ⚠️  %v, data not verified", err)
+		return nil
+	case r.config.DigestMode == DIGEST_WARN:
+		r.note(progress.STAGE_REASSEMBLE, manifest.MessageID, "   ⚠️  %v", err)
+		return nil
+	}
+	return err
+}
+
+// newChunker creates a chunker for decoding a message with the publisher's
+// settings, reporting through the receiver's reporter
+func (r *Receiver) newChunker(msgID string) *chunker.Chunker {
+	config := r.caps.ChunkerConfig()
+	config.AuthKey = r.config.AuthKey
+	config.RequireAuth = r.config.RequireAuth
+	config.Logger = progress.ChunkerLogger(r.config.Reporter, progress.STAGE_REASSEMBLE, msgID)
+	return chunker.NewChunker(config)
+}
+
+// archiveChunks keeps the fetched chunks as a chunk set archive, so a
+// message can be reassembled again (or republished) without refetching.
+// Failures are reported but don't stop the retrieval.
+func (r *Receiver) archiveChunks(chk *chunker.Chunker, encodedChunks []string, msgID string, manifest *chunker.DNSManifest) {
+	msg, err := chk.CollectChunks(encodedChunks)
+	if err != nil {
+		r.note(progress.STAGE_REASSEMBLE, msgID, "   ⚠️  Not archiving %s: %v", msgID, err)
+		return
+	}
+
+	path := filepath.Join(r.config.ArchiveDir, msgID+chunker.CHUNKSET_EXTENSION)
+	if err := chunker.NewChunkSet(msg, manifest.Value()).Save(path); err != nil {
+		r.note(progress.STAGE_REASSEMBLE, msgID, "   ⚠️  Failed to archive %s: %v", msgID, err)
+		return
+	}
+	r.note(progress.STAGE_REASSEMBLE, msgID, "   🗄️ Archived %d chunks to %s", len(msg.Chunks), path)
+}
+
+// PollHandler receives each message Poll retrieved, or the error that
+// stopped it. Returning nil acknowledges the message and sends a
+// delivered receipt; an error leaves it unacknowledged.
+type PollHandler func(msgID string, data []byte, info *ContentInfo, err error) error
+
+// ReceiptHandler is a PollHandler that also picks the receipt: returning
+// nil acknowledges the message and sends a receipt with the status given
+// (a daemon that decodes sends RECEIPT_DECODED or RECEIPT_FAILED).
+type ReceiptHandler func(msgID string, data []byte, info *ContentInfo, err error) (ReceiptStatus, error)
+
+// Poll checks for new messages for clientID until ctx is done, retrieving
+// each batch interleaved and handing every message to handle
+func (r *Receiver) Poll(ctx context.Context, clientID string, handle PollHandler) error {
+	return r.PollWithReceipts(ctx, clientID, func(msgID string, data []byte, info *ContentInfo, err error) (ReceiptStatus, error) {
+		return RECEIPT_DELIVERED, handle(msgID, data, info, err)
+	})
+}
+
+// PollWithReceipts is Poll with the handler choosing each receipt
+func (r *Receiver) PollWithReceipts(ctx context.Context, clientID string, handle ReceiptHandler) error {
+	// LESSON: Polling Patterns
+	// - Fixed interval: Simple but predictable
+	// - Exponential backoff: Reduces load when idle
+	// - Jittered: Avoids synchronized polling
+	//
+	// All three apply: PollInterval while messages keep coming, doubling
+	// after five empty polls up to PollMaxPause, and every pause varied by
+	// PollJitter so the polls don't tick like a clock.
+
+	consecutiveEmpty := 0
+
+	for {
+		// Query for new messages
+		newMsgIDs, err := r.CheckForNewMessages(ctx, clientID)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			r.note(progress.STAGE_RETRIEVE, "", "⚠️  Poll error: %v", err)
+			if err := sleep(ctx, r.pollPause(r.config.PollInterval)); err != nil {
+				return err
+			}
+			continue
+		}
+
+		wait := r.config.PollInterval
+		if len(newMsgIDs) > 0 {
+			r.note(progress.STAGE_RETRIEVE, "", "\n🔔 New messages: %v", newMsgIDs)
+			consecutiveEmpty = 0
+			wait = 0
+
+			// Retrieve all pending messages with interleaved chunk fetches
+			// so a small message isn't stuck behind a large one
+			r.RetrieveMessages(ctx, newMsgIDs, func(msgID string, data []byte, info *ContentInfo, err error) {
+				status, handleErr := handle(msgID, data, info, err)
+				if handleErr != nil || err != nil {
+					return
+				}
+
+				// Acknowledge receipt
+				r.Acknowledge(ctx, msgID, clientID)
+				r.SendReceipt(ctx, msgID, data, status)
+			})
+		} else {
+			consecutiveEmpty++
+
+			// Exponential backoff when idle
+			if consecutiveEmpty > 5 {
+				wait = r.idlePause(consecutiveEmpty - 5)
+			}
+		}
+
+		if err := sleep(ctx, r.pollPause(wait)); err != nil {
+			return err
+		}
+	}
+}
+
+// idlePause doubles the poll interval per idle round, up to PollMaxPause
+func (r *Receiver) idlePause(rounds int) time.Duration {
+	wait := r.config.PollInterval
+	for i := 0; i < rounds && wait < r.config.PollMaxPause; i++ {
+		wait *= 2
+	}
+	if wait > r.config.PollMaxPause {
+		wait = r.config.PollMaxPause
+	}
+	return wait
+}
+
+// pollPause applies PollJitter to a pause
+func (r *Receiver) pollPause(wait time.Duration) time.Duration {
+	if r.config.PollJitter <= 0 || wait <= 0 {
+		return wait
+	}
+	spread := r.config.PollJitter * (2*rand.Float64() - 1)
+	return wait + time.Duration(spread*float64(wait))
+}
+
+// CheckForNewMessages asks the server which messages clientID hasn't read
+func (r *Receiver) CheckForNewMessages(ctx context.Context, clientID string) ([]string, error) {
+	queryName := dnsserver.ConsumeName(clientID, r.config.Group, r.config.Domain, r.config.Tags)
+
+	m := new(dns.Msg)
+	m.SetQuestion(dns.Fqdn(queryName), dns.TypeTXT)
+
+	resp, err := r.config.Queries.Exchange(ctx, m, r.config.Server, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	// Parse response
+	for _, ans := range resp.Answer {
+		if txt, ok := ans.(*dns.TXT); ok && len(txt.Txt) > 0 {
+			// Response format: "msgID1,msgID2,msgID3"
+			if txt.Txt[0] != "" {
+				return strings.Split(txt.Txt[0], ","), nil
+			}
+		}
+	}
+
+	return []string{}, nil
+}
+
+// Acknowledge marks a message as consumed by clientID. The server doesn't
+// answer with anything worth waiting for, so only a failed send is an error.
+func (r *Receiver) Acknowledge(ctx context.Context, msgID, clientID string) error {
+	ackName := dnsserver.AckName(msgID, clientID, r.config.Group, r.config.Domain)
+
+	m := new(dns.Msg)
+	m.SetQuestion(dns.Fqdn(ackName), dns.TypeTXT)
+
+	_, err := r.config.Queries.Exchange(ctx, m, r.config.Server, 0, 0)
+	return err
+}
+
+// SendReceipt tells the sender how far a message got. It does nothing
+// without a ReceiptKey.
+func (r *Receiver) SendReceipt(ctx context.Context, msgID string, data []byte, status ReceiptStatus) error {
+	if len(r.config.ReceiptKey) == 0 {
+		return nil
+	}
+
+	token := chunker.NewReceipt(msgID, data, status).Token(r.config.ReceiptKey)
+	m := new(dns.Msg)
+	m.SetQuestion(dns.Fqdn(chunker.ReceiptQueryName(token, msgID, r.config.Domain)), dns.TypeTXT)
+
+	resp, err := r.config.Queries.Exchange(ctx, m, r.config.Server, 0, r.config.QueryTimeout)
+	if err == nil && resp.Rcode != dns.RcodeSuccess {
+		err = fmt.Errorf("server answered %s", dns.RcodeToString[resp.Rcode])
+	}
+	if err != nil {
+		r.note(progress.STAGE_RETRIEVE, msgID, "⚠️  Receipt for %s not delivered", msgID)
+		return fmt.Errorf("receipt for %s not delivered: %w", msgID, err)
+	}
+	r.note(progress.STAGE_RETRIEVE, msgID, "🧾 Receipt sent: %s", status)
+	return nil
+}
@@ -0,0 +1,258 @@
+package simulacra
+
+import (
+	"context"
+	"fmt"
+	"github.com/miekg/dns"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ================================================================================
+// RESOLVER FAN-OUT
+// Spreads chunk queries over several resolvers and routes around slow or dead ones
+// ================================================================================
+
+// LESSON: Many Paths to One Zone
+// Every recursive resolver in front of the zone can answer a chunk query,
+// so a receiver given several (-server a,b,c) spreads them out: each
+// resolver sees only part of the transfer, and one that stops answering
+// costs a few retries instead of the whole message.
+//
+// Routing is adaptive. Each resolver keeps a moving average of how long
+// its answers take, and a query goes to one at random, weighted by
+// 1/latency - fast resolvers get most queries, slow ones still get some
+// (and so keep being measured). A resolver that fails RESOLVER_MAX_FAILURES
+// queries in a row sits out RESOLVER_BENCH before it is tried again, and a
+// chunk query that failed is retried on a resolver it hasn't failed on.
+//
+// Manifests, polls, acknowledgements and receipts still go to the first
+// server: consumer state lives there, and those queries are few.
+
+// Resolver health tuning
+const (
+	// RESOLVER_MAX_FAILURES benches a resolver after this many failures in a row
+	RESOLVER_MAX_FAILURES = 3
+
+	// RESOLVER_BENCH is how long a benched resolver gets no queries
+	RESOLVER_BENCH = 30 * time.Second
+
+	// resolverSmoothing weights the newest answer in the latency average
+	resolverSmoothing = 0.3
+)
+
+// ResolverStats describes how one resolver has served chunk queries
+type ResolverStats struct {
+	Address  string        `json:"address"`
+	Queries  int           `json:"queries"`
+	Failures int           `json:"failures"`
+	Latency  time.Duration `json:"latency"` // Moving average of answered queries (0 = none yet)
+	Benched  bool          `json:"benched"` // Sitting out after repeated failures
+}
+
+// resolver is one address chunk queries can go to
+type resolver struct {
+	addr  string
+	pacer *pacer // nil = unpaced
+
+	mu        sync.Mutex
+	latency   time.Duration // Moving average of answered queries
+	streak    int           // Failures in a row
+	benchedTo time.Time     // No queries before this
+	queries   int
+	failures  int
+}
+
+// newResolvers builds the resolver list: server first, then the others,
+// each once. rate paces each resolver (0 = unpaced).
+func newResolvers(server string, others []string, rate int) ([]*resolver, error) {
+	var resolvers []*resolver
+	seen := make(map[string]bool)
+	for _, addr := range append([]string{server}, others...) {
+		addr = strings.TrimSpace(addr)
+		if addr == "" {
+			return nil, fmt.Errorf("empty resolver address")
+		}
+		if seen[addr] {
+			continue
+		}
+		seen[addr] = true
+
+		res := &resolver{addr: addr}
+		if rate > 0 {
+			res.pacer = &pacer{interval: time.Second / time.Duration(rate)}
+		}
+		resolvers = append(resolvers, res)
+	}
+	return resolvers, nil
+}
+
+// observe records the outcome of a query sent to the resolver
+func (res *resolver) observe(elapsed time.Duration, err error) {
+	res.mu.Lock()
+	defer res.mu.Unlock()
+
+	res.queries++
+	if err != nil {
+		res.failures++
+		res.streak++
+		if res.streak >= RESOLVER_MAX_FAILURES {
+			res.benchedTo = time.Now().Add(RESOLVER_BENCH)
+			res.streak = 0
+		}
+		return
+	}
+
+	res.streak = 0
+	if res.latency == 0 {
+		res.latency = elapsed
+	} else {
+		res.latency += time.Duration(resolverSmoothing * float64(elapsed-res.latency))
+	}
+}
+
+// state returns the resolver's latency average and whether it is benched
+func (res *resolver) state(now time.Time) (time.Duration, bool) {
+	res.mu.Lock()
+	defer res.mu.Unlock()
+	return res.latency, now.Before(res.benchedTo)
+}
+
+// stats returns the resolver's statistics
+func (res *resolver) stats() ResolverStats {
+	res.mu.Lock()
+	defer res.mu.Unlock()
+	return ResolverStats{
+		Address:  res.addr,
+		Queries:  res.queries,
+		Failures: res.failures,
+		Latency:  res.latency,
+		Benched:  time.Now().Before(res.benchedTo),
+	}
+}
+
+// route remembers the resolvers one chunk's attempts failed on, so the
+// next attempt goes elsewhere
+type route struct {
+	used   *resolver // Resolver of the latest query
+	failed map[*resolver]bool
+}
+
+// fail marks the resolver of the latest query as failed for this chunk
+func (rt *route) fail() {
+	if rt.used == nil {
+		return
+	}
+	if rt.failed == nil {
+		rt.failed = make(map[*resolver]bool)
+	}
+	rt.failed[rt.used] = true
+}
+
+// pickResolver chooses the resolver for a query: one rt hasn't failed on,
+// not benched, weighted by speed. With no such resolver it relaxes those
+// conditions rather than send nothing.
+func (r *Receiver) pickResolver(rt *route) *resolver {
+	if len(r.resolvers) == 1 {
+		return r.resolvers[0]
+	}
+
+	now := time.Now()
+	candidates := make([]*resolver, 0, len(r.resolvers))
+	latencies := make([]time.Duration, 0, len(r.resolvers))
+	for _, relax := range []int{0, 1, 2} {
+		for _, res := range r.resolvers {
+			latency, benched := res.state(now)
+			if (relax < 2 && rt != nil && rt.failed[res]) || (relax < 1 && benched) {
+				continue
+			}
+			candidates = append(candidates, res)
+			latencies = append(latencies, latency)
+		}
+		if len(candidates) > 0 {
+			break
+		}
+	}
+
+	// Unmeasured resolvers count as fast as the fastest, so they get measured
+	fastest := time.Duration(0)
+	for _, latency := range latencies {
+		if latency > 0 && (fastest == 0 || latency < fastest) {
+			fastest = latency
+		}
+	}
+	if fastest == 0 {
+		fastest = time.Millisecond
+	}
+
+	weights := make([]float64, len(candidates))
+	total := 0.0
+	for i, latency := range latencies {
+		if latency <= 0 {
+			latency = fastest
+		}
+		weights[i] = 1 / latency.Seconds()
+		total += weights[i]
+	}
+
+	pick := rand.Float64() * total
+	for i, weight := range weights {
+		if pick < weight {
+			return candidates[i]
+		}
+		pick -= weight
+	}
+	return candidates[len(candidates)-1]
+}
+
+// exchange sends a chunk query to a resolver picked for rt (nil = any),
+// waiting for that resolver's pacer first, and records how it went.
+// Failures, error codes included, come back as a *QueryError.
+func (r *Receiver) exchange(ctx context.Context, rt *route, m *dns.Msg, minUDP uint16) (*dns.Msg, error) {
+	res := r.pickResolver(rt)
+	if rt != nil {
+		rt.used = res
+	}
+
+	if res.pacer != nil {
+		if err := res.pacer.wait(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	start := time.Now()
+	resp, err := r.config.Queries.Exchange(ctx, m, res.addr, minUDP, r.config.QueryTimeout)
+	err = classifyQuery(resp, err)
+
+	// Our own cancellation says nothing about the resolver, and neither
+	// does an answer that the name doesn't exist or is refused
+	if ctx.Err() == nil {
+		var fault error
+		if err != nil && transient(err) {
+			fault = err
+		}
+		res.observe(time.Since(start), fault)
+	}
+	return resp, err
+}
+
+// resolverAddrs lists the resolver addresses
+func (r *Receiver) resolverAddrs() []string {
+	addrs := make([]string, len(r.resolvers))
+	for i, res := range r.resolvers {
+		addrs[i] = res.addr
+	}
+	return addrs
+}
+
+// ResolverStats returns how each resolver has served chunk queries so far,
+// in the order they were configured
+func (r *Receiver) ResolverStats() []ResolverStats {
+	stats := make([]ResolverStats, len(r.resolvers))
+	for i, res := range r.resolvers {
+		stats[i] = res.stats()
+	}
+	return stats
+}
@@ -0,0 +1,87 @@
+package simulacra
+
+import (
+	"context"
+	"errors"
+	"github.com/faanross/simulacra_txt/internal/chunker"
+	"github.com/faanross/simulacra_txt/internal/clientstate"
+	"time"
+)
+
+// ================================================================================
+// RETRIEVAL RESULTS
+// A retrieved message together with what was checked on the way
+// ================================================================================
+
+// LESSON: Say What Was Verified
+// "The file arrived" and "the file is what the sender sent" are different
+// claims. A Retrieval carries the evidence for the second one next to the
+// data: whether the manifest was signed by the expected sender, whether the
+// reassembled bytes matched the manifest's digest (or there was no digest
+// to match), the transfer's own statistics and the errors its chunks ran
+// into - so a caller can write an integrity report without repeating any
+// of the checks.
+
+// Digest outcomes of a retrieval
+const (
+	DIGEST_STATUS_VERIFIED = "verified" // Data matched the manifest's digest
+	DIGEST_STATUS_ABSENT   = "absent"   // The manifest carried no digest
+	DIGEST_STATUS_MISMATCH = "mismatch" // Data didn't match, accepted under DIGEST_WARN
+)
+
+// Retrieval is the outcome of Retrieve
+type Retrieval struct {
+	MessageID string
+	Data      []byte
+	Info      *ContentInfo // Extended header, nil when the sender added none
+	Manifest  *Manifest    // nil when the manifest couldn't be fetched
+	Signed    bool         // Manifest signature checked against VerifyKey
+	Digest    string       // DIGEST_STATUS_*, "" until data is reassembled
+	Stats     TransferRecord
+
+	// Chunks that ran into query errors, by chunk index, with whether
+	// retrying recovered them
+	ChunkErrors []ChunkError
+}
+
+// Retrieve fetches a complete message like RetrieveMessage, returning it
+// with its manifest, integrity checks and transfer statistics. The
+// Retrieval is returned on failure too, filled in as far as it got.
+func (r *Receiver) Retrieve(ctx context.Context, msgID string) (*Retrieval, error) {
+	if r.config.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.config.Timeout)
+		defer cancel()
+	}
+
+	ret := &Retrieval{
+		MessageID: msgID,
+		Stats: TransferRecord{
+			MessageID: msgID,
+			Direction: clientstate.DirectionDownload,
+			Server:    r.config.Server,
+			StartedAt: time.Now(),
+		},
+	}
+
+	data, info, err := r.retrieveMessage(ctx, ret)
+	ret.Data, ret.Info = data, info
+	if err == nil {
+		ret.Digest = digestStatus(ret.Manifest, data)
+	}
+	r.recordTransfer(&ret.Stats, data, err)
+
+	return ret, err
+}
+
+// digestStatus says how reassembled data compares to the manifest digest
+func digestStatus(manifest *chunker.DNSManifest, data []byte) string {
+	err := manifest.VerifyData(data)
+	switch {
+	case err == nil:
+		return DIGEST_STATUS_VERIFIED
+	case errors.Is(err, chunker.ErrNoDigest):
+		return DIGEST_STATUS_ABSENT
+	}
+	return DIGEST_STATUS_MISMATCH
+}
@@ -0,0 +1,83 @@
+package simulacra
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"time"
+)
+
+// ================================================================================
+// RETRIES
+// One retry loop for every query a client repeats
+// ================================================================================
+
+// LESSON: Knowing When to Stop
+// A lost UDP packet is worth asking again for; a server answering REFUSED
+// will refuse every time, and a cancelled context means nobody is waiting
+// for the answer any more. So a failed attempt is retried after a backoff
+// unless it was marked permanent or ctx is done - and the backoff itself
+// is cut short by cancellation.
+
+// Backoff returns the pause before retry n (n starts at 1)
+type Backoff func(n int) time.Duration
+
+// LinearBackoff pauses step, 2*step, 3*step, ... between retries
+func LinearBackoff(step time.Duration) Backoff {
+	return func(n int) time.Duration {
+		return time.Duration(n) * step
+	}
+}
+
+// ExponentialBackoff doubles the pause from base up to max, then picks
+// a random point in its upper half, so clients that failed together
+// don't all retry together
+func ExponentialBackoff(base, max time.Duration) Backoff {
+	return func(n int) time.Duration {
+		d := base
+		for i := 1; i < n && d < max; i++ {
+			d *= 2
+		}
+		if d > max {
+			d = max
+		}
+		return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+	}
+}
+
+// permanentError marks a failure no retry can fix
+type permanentError struct {
+	err error
+}
+
+func (e permanentError) Error() string { return e.err.Error() }
+func (e permanentError) Unwrap() error { return e.err }
+
+// permanent wraps err so retry gives up at once
+func permanent(err error) error {
+	return permanentError{err: err}
+}
+
+// retry runs attempt until it succeeds, fails permanently or has been
+// retried maxRetries times. It returns the number of retries made and the
+// last error (unwrapped from permanent).
+func retry(ctx context.Context, maxRetries int, backoff Backoff, attempt func() error) (int, error) {
+	for n := 0; ; n++ {
+		if n > 0 {
+			if err := sleep(ctx, backoff(n)); err != nil {
+				return n - 1, err
+			}
+		}
+
+		err := attempt()
+		var stop permanentError
+		switch {
+		case err == nil:
+			return n, nil
+		case errors.As(err, &stop):
+			return n, stop.err
+		case n >= maxRetries:
+			return n, err
+		}
+	}
+}
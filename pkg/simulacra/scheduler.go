@@ -0,0 +1,227 @@
+package simulacra
+
+import (
+	"context"
+	"fmt"
+	"github.com/faanross/simulacra_txt/internal/chunker"
+	"github.com/faanross/simulacra_txt/internal/clientstate"
+	"github.com/faanross/simulacra_txt/internal/progress"
+	"time"
+)
+
+// ================================================================================
+// MULTI-MESSAGE CHUNK SCHEDULER
+// Interleaves chunk fetches across several pending messages
+// ================================================================================
+
+// LESSON: Stride Scheduling
+// Fetching messages strictly one after another means a tiny urgent message
+// waits behind a huge one. Instead every transfer gets a "pass" value and we
+// always fetch the next chunk of the transfer with the lowest pass. After each
+// fetch the pass advances by the transfer's stride (size / priority), so small
+// or high-priority messages get proportionally more turns.
+//
+// With Parallel workers the scheduler decides which window goes out next
+// and the pool decides when: a window is handed over as soon as a worker
+// is free (see parallel.go).
+
+// DEFAULT_PRIORITY is used for messages with no explicit priority
+const DEFAULT_PRIORITY = 1
+
+// pendingTransfer tracks the retrieval state of one message
+type pendingTransfer struct {
+	msgID    string
+	manifest *chunker.DNSManifest
+	chunks   []string
+	next     int          // Next chunk index to fetch
+	inFlight int          // Windows handed to workers and not yet back
+	fetched  int          // Chunks fetched successfully, including reused ones
+	resumed  int          // Chunks reused from the fetch journal
+	cached   int          // Chunks reused from the chunk cache
+	failed   int          // Chunks that failed after retries
+	retries  int          // Total retries across all chunks
+	errors   []ChunkError // Chunks that ran into errors, recovered or not
+	priority int          // Higher means more fetch turns
+	pass     float64      // Stride scheduling position
+	started  time.Time
+	task     *progress.Task
+	journal  *clientstate.FetchJournal // nil = not journaled
+}
+
+// stride returns how far the transfer's pass advances per fetch
+func (t *pendingTransfer) stride() float64 {
+	return float64(len(t.chunks)) / float64(t.priority)
+}
+
+// done reports whether every chunk has been handed out for fetching
+func (t *pendingTransfer) done() bool {
+	return t.next >= len(t.chunks)
+}
+
+// complete reports whether every chunk has been attempted
+func (t *pendingTransfer) complete() bool {
+	return t.done() && t.inFlight == 0
+}
+
+// filled counts the slots holding a chunk
+func (t *pendingTransfer) filled() int {
+	n := 0
+	for _, chunk := range t.chunks {
+		if chunk != "" {
+			n++
+		}
+	}
+	return n
+}
+
+// TransferCallback is invoked as soon as an individual message completes
+type TransferCallback func(msgID string, data []byte, info *ContentInfo, err error)
+
+// RetrieveMessages fetches several messages with interleaved chunk requests.
+// Each message is handed to onComplete as soon as its last chunk arrives,
+// without waiting for the remaining transfers. If ctx is cancelled, the
+// transfers still running are handed over with its error.
+func (r *Receiver) RetrieveMessages(ctx context.Context, msgIDs []string, onComplete TransferCallback) {
+	r.note(progress.STAGE_RETRIEVE, "", "\n📥 RETRIEVING %d MESSAGES (interleaved)", len(msgIDs))
+
+	// Step 1: Fetch all manifests so we know the size of each transfer
+	var active []*pendingTransfer
+	for _, msgID := range msgIDs {
+		started := time.Now()
+		manifest, err := r.fetchManifest(ctx, msgID)
+		if err != nil {
+			onComplete(msgID, nil, nil, fmt.Errorf("manifest fetch failed: %w", err))
+			continue
+		}
+		totalChunks := manifest.TotalChunks
+
+		if totalChunks <= 0 {
+			onComplete(msgID, nil, nil, fmt.Errorf("manifest reports no chunks"))
+			continue
+		}
+
+		t := r.newTransfer(msgID, manifest, started)
+		active = append(active, t)
+
+		if t.fetched > 0 {
+			r.note(progress.STAGE_RETRIEVE, msgID, "   %s: %d chunks (%d resumed, %d from cache)", msgID, totalChunks, t.resumed, t.cached)
+		} else {
+			r.note(progress.STAGE_RETRIEVE, msgID, "   %s: %d chunks", msgID, totalChunks)
+		}
+	}
+
+	// Step 2: Fetch chunks, always serving the transfer with the lowest pass
+	jobs := make(chan windowJob)
+	results := make(chan windowResult, r.workers())
+	r.startWorkers(ctx, jobs, results)
+	defer close(jobs)
+
+	unfinished := append([]*pendingTransfer(nil), active...) // Not yet handed to onComplete
+	inFlight := 0
+	for (len(active) > 0 && ctx.Err() == nil) || inFlight > 0 {
+		var send chan<- windowJob
+		var job windowJob
+		if len(active) > 0 && ctx.Err() == nil {
+			t := nextTransfer(active)
+			send, job = jobs, windowJob{transfer: t, from: t.next}
+		}
+
+		select {
+		case send <- job:
+			t := job.transfer
+			inFlight++
+			t.inFlight++
+
+			// A batched turn counts as several single-chunk turns
+			step := r.batchStep()
+			t.next += step
+			t.pass += t.stride() * float64(step)
+			if t.done() {
+				active = removeTransfer(active, t)
+			}
+		case result := <-results:
+			t := result.transfer
+			inFlight--
+			t.inFlight--
+			t.record(result)
+			if t.complete() && ctx.Err() == nil {
+				unfinished = removeTransfer(unfinished, t)
+				r.finishTransfer(t, onComplete)
+			}
+		}
+	}
+
+	// Transfers still unfinished were cut short by ctx
+	for _, t := range unfinished {
+		t.task.Finish(ctx.Err())
+		r.closeJournal(t, ctx.Err())
+		onComplete(t.msgID, nil, nil, ctx.Err())
+	}
+}
+
+// finishTransfer reassembles a completed transfer and reports the result
+func (r *Receiver) finishTransfer(t *pendingTransfer, onComplete TransferCallback) {
+	t.task.Finish(nil)
+	r.noteChunkErrors(t.msgID, t.errors)
+	data, info, err := r.assembleTransfer(t)
+	r.closeJournal(t, err)
+
+	r.recordTransfer(&clientstate.TransferRecord{
+		MessageID:    t.msgID,
+		Direction:    clientstate.DirectionDownload,
+		Server:       r.config.Server,
+		StartedAt:    t.started,
+		TotalChunks:  len(t.chunks),
+		FailedChunks: t.failed,
+		Retries:      t.retries,
+	}, data, err)
+
+	if err == nil {
+		r.note(progress.STAGE_RETRIEVE, t.msgID, "   ✅ %s complete (%d chunks, %d bytes)", t.msgID, len(t.chunks), len(data))
+	}
+	onComplete(t.msgID, data, info, err)
+}
+
+// assembleTransfer reassembles the chunks of a fully attempted transfer
+func (r *Receiver) assembleTransfer(t *pendingTransfer) ([]byte, *chunker.ContentInfo, error) {
+	// Missing chunks may still be recoverable from parity chunks
+	data, info, err := r.reassembleChunks(t.chunks, t.msgID, t.manifest)
+	if err != nil {
+		if t.failed > 0 {
+			return nil, nil, fmt.Errorf("incomplete retrieval: %d/%d chunks missing: %w",
+				t.failed, len(t.chunks), err)
+		}
+		return nil, nil, fmt.Errorf("reassembly failed: %w", err)
+	}
+
+	return data, info, nil
+}
+
+// priorityFor returns the scheduling priority configured for a message
+func (r *Receiver) priorityFor(msgID string) int {
+	if p, ok := r.config.Priorities[msgID]; ok && p > 0 {
+		return p
+	}
+	return DEFAULT_PRIORITY
+}
+
+// nextTransfer picks the active transfer with the lowest pass value
+func nextTransfer(active []*pendingTransfer) *pendingTransfer {
+	best := active[0]
+	for _, t := range active[1:] {
+		if t.pass < best.pass {
+			best = t
+		}
+	}
+	return best
+}
+
+// removeTransfer drops a finished transfer from the active list
+func removeTransfer(active []*pendingTransfer, done *pendingTransfer) []*pendingTransfer {
+	for i, t := range active {
+		if t == done {
+			return append(active[:i], active[i+1:]...)
+		}
+	}
+	return active
+}
@@ -0,0 +1,126 @@
+package simulacra
+
+import (
+	"context"
+	"fmt"
+	"github.com/faanross/simulacra_txt/internal/chunker"
+	"github.com/faanross/simulacra_txt/internal/clientstate"
+	"github.com/faanross/simulacra_txt/internal/cover"
+	"github.com/faanross/simulacra_txt/internal/fingerprint"
+	"github.com/faanross/simulacra_txt/internal/progress"
+	"time"
+)
+
+// ================================================================================
+// CLIENT SDK
+// Sending and receiving covert messages from any Go program
+// ================================================================================
+
+// LESSON: Libraries Don't Print
+// stego-send and stego-receive used to hold the whole client: a program
+// that wanted to upload or fetch a message had to shell out to them and
+// scrape their output. This package is that client, importable:
+//
+//   UploadClient  - chunks go up by query name, HTTP or DNS UPDATE
+//   Receiver      - manifests, chunks, reassembly, polling, receipts
+//   ChunkFile     - a file becomes chunks and a manifest ready to send
+//
+// Nothing here writes to stdout. Everything the commands used to print is
+// a progress event (a note, with fields where a script would want them)
+// sent to the Reporter in the config - progress.Silent unless one is set -
+// and whatever a caller needs afterwards comes back in a result. Every
+// network call takes a context: cancelling it abandons the query in
+// flight and stops retries, backoffs, rate-limit pauses and polling.
+// QueryTimeout and Timeout in the configs add per-query and per-message
+// deadlines of their own.
+//
+// The types below are the internal packages' own, under names an
+// importer outside this module can use.
+
+// Types shared with the internal packages
+type (
+	Chunk          = chunker.Chunk
+	ChunkSet       = chunker.ChunkSet
+	ChunkerConfig  = chunker.ChunkerConfig
+	ContentInfo    = chunker.ContentInfo
+	Manifest       = chunker.DNSManifest
+	Capabilities   = chunker.Capabilities
+	Canary         = chunker.Canary
+	Receipt        = chunker.Receipt
+	ReceiptStatus  = chunker.ReceiptStatus
+	TTLPolicy      = chunker.TTLPolicy
+	TLVs           = chunker.TLVs
+	Reporter       = progress.Reporter
+	Event          = progress.Event
+	Queries        = fingerprint.Randomizer // Shapes every DNS query a client sends
+	ChunkCache     = clientstate.ChunkCache
+	TransferRecord = clientstate.TransferRecord
+	FetchJournals  = clientstate.FetchJournals
+	CoverEngine    = cover.Engine // Picks and times cover queries
+)
+
+// Receipt statuses
+const (
+	RECEIPT_DELIVERED = chunker.RECEIPT_DELIVERED
+	RECEIPT_DECODED   = chunker.RECEIPT_DECODED
+	RECEIPT_FAILED    = chunker.RECEIPT_FAILED
+)
+
+// QUERY_TIMEOUT bounds a single DNS query
+const QUERY_TIMEOUT = 5 * time.Second
+
+// NewQueries creates a query shaper for a fingerprint profile name ("" =
+// none, plain miekg/dns queries)
+func NewQueries(profile string) (*Queries, error) {
+	parsed, err := fingerprint.ParseProfile(profile)
+	if err != nil {
+		return nil, err
+	}
+	return fingerprint.NewRandomizer(parsed), nil
+}
+
+// NewCoverEngine creates a cover traffic engine from a site list file
+// ("" = the built-in sites), a query type mix like "A=50,AAAA=30,TXT=12,MX=8"
+// ("" = the default mix) and a tempo scaling its pauses (0 = 1)
+func NewCoverEngine(sitesFile, mix string, tempo float64) (*CoverEngine, error) {
+	var config cover.Config
+	var err error
+	if sitesFile != "" {
+		if config.Sites, err = cover.LoadSites(sitesFile); err != nil {
+			return nil, err
+		}
+	}
+	if mix != "" {
+		if config.Mix, err = cover.ParseMix(mix); err != nil {
+			return nil, fmt.Errorf("invalid cover mix: %w", err)
+		}
+	}
+	config.Tempo = tempo
+	return cover.New(config)
+}
+
+// OpenChunkCache opens the on-disk chunk cache in dir ("" = state dir)
+func OpenChunkCache(dir string) (*ChunkCache, error) {
+	return clientstate.OpenChunkCache(dir)
+}
+
+// OpenFetchJournals opens the fetch journal directory ("" = state dir)
+func OpenFetchJournals(dir string) (*FetchJournals, error) {
+	return clientstate.OpenFetchJournals(dir)
+}
+
+// sleep pauses for d, returning early with ctx's error if it is cancelled
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,247 @@
+package simulacra
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ================================================================================
+// TRAFFIC PROFILES
+// Named shapes for the timing, order and cover of upload queries
+// ================================================================================
+
+// LESSON: Rate Is Not a Disguise
+// Jittering a fixed rate still leaves a flat line on a queries-per-minute
+// graph: thousands of lookups of one zone, evenly spread, at 3 a.m. What
+// gives an upload away is its shape, so a traffic profile shapes three
+// things at once:
+//
+//   Timing - how the gaps between queries are drawn: jittered, stretched,
+//            bunched into bursts, or confined to working hours
+//   Order  - chunks go out shuffled, so the sequence numbers in the query
+//            names don't count upwards
+//   Cover  - lookups of popular domains interleaved with the upload, a
+//            configurable number per chunk query
+//
+//   PROFILE       SHAPE
+//   none          The fixed rate, chunks in order, no cover (no -stealth)
+//   steady        The rate with 50-150% jitter, light cover (-stealth)
+//   office-hours  Weekdays 09:00-17:00 local time only, with breaks
+//   low-and-slow  A tenth of the rate, wide jitter, a cover query per chunk
+//   burst         Quick bursts of 4-12 queries, then silence
+//
+// Bursts don't change the long-run rate: the silence after a burst makes
+// up for the speed within it. Cover queries are paced like any other.
+
+// Traffic profiles
+const (
+	TRAFFIC_NONE         = "none"
+	TRAFFIC_STEADY       = "steady"
+	TRAFFIC_OFFICE_HOURS = "office-hours"
+	TRAFFIC_LOW_AND_SLOW = "low-and-slow"
+	TRAFFIC_BURST        = "burst"
+)
+
+// Traffic shaping tuning
+const (
+	// BURST_SPEEDUP is how much faster than the rate queries go within a burst
+	BURST_SPEEDUP = 4
+
+	// BREAK_MIN_GAPS and BREAK_MAX_GAPS bound a break, in mean gaps
+	BREAK_MIN_GAPS = 30
+	BREAK_MAX_GAPS = 120
+)
+
+// TrafficProfile describes how upload queries are spread out
+type TrafficProfile struct {
+	Name        string
+	Description string
+	Shuffle     bool    // Send chunks in random order
+	Slowdown    float64 // Mean gap as a multiple of the rate limit (0 = 1)
+	Jitter      float64 // Gaps vary by up to this fraction either way (0-1)
+	CoverRatio  float64 // Cover queries per chunk query
+	Breaks      float64 // Chance of a break before a query
+	Burst       [2]int  // Queries per burst, at least and at most (zero = no bursts)
+	Hours       [2]int  // Send only from and until these local hours on weekdays (zero = any time)
+}
+
+// trafficProfiles are the named profiles
+var trafficProfiles = map[string]TrafficProfile{
+	TRAFFIC_NONE: {
+		Name:        TRAFFIC_NONE,
+		Description: "fixed rate, chunks in order, no cover traffic",
+	},
+	TRAFFIC_STEADY: {
+		Name:        TRAFFIC_STEADY,
+		Description: "jittered rate, shuffled chunks, light cover traffic",
+		Shuffle:     true,
+		Jitter:      0.5,
+		CoverRatio:  0.1,
+	},
+	TRAFFIC_OFFICE_HOURS: {
+		Name:        TRAFFIC_OFFICE_HOURS,
+		Description: "weekdays 09:00-17:00 local time, with breaks",
+		Shuffle:     true,
+		Jitter:      0.5,
+		CoverRatio:  0.25,
+		Breaks:      0.02,
+		Hours:       [2]int{9, 17},
+	},
+	TRAFFIC_LOW_AND_SLOW: {
+		Name:        TRAFFIC_LOW_AND_SLOW,
+		Description: "a tenth of the rate, wide jitter, a cover query per chunk",
+		Shuffle:     true,
+		Slowdown:    10,
+		Jitter:      0.75,
+		CoverRatio:  1,
+	},
+	TRAFFIC_BURST: {
+		Name:        TRAFFIC_BURST,
+		Description: "bursts of 4-12 quick queries, then silence",
+		Shuffle:     true,
+		Jitter:      0.5,
+		CoverRatio:  0.2,
+		Burst:       [2]int{4, 12},
+	},
+}
+
+// TrafficProfileNames lists the named traffic profiles
+func TrafficProfileNames() []string {
+	names := make([]string, 0, len(trafficProfiles))
+	for name := range trafficProfiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// LookupTrafficProfile returns a named traffic profile
+func LookupTrafficProfile(name string) (TrafficProfile, error) {
+	profile, ok := trafficProfiles[strings.ToLower(strings.TrimSpace(name))]
+	if !ok {
+		return TrafficProfile{}, fmt.Errorf("unknown traffic profile %q (supported: %s)",
+			name, strings.Join(TrafficProfileNames(), ", "))
+	}
+	return profile, nil
+}
+
+// MeanGap returns the average pause between queries at a rate limit,
+// breaks included
+func (p TrafficProfile) MeanGap(rate time.Duration) time.Duration {
+	gap := float64(rate) * p.slowdown()
+	return time.Duration(gap * (1 + p.Breaks*(BREAK_MIN_GAPS+BREAK_MAX_GAPS)/2))
+}
+
+// slowdown returns the mean gap as a multiple of the rate limit
+func (p TrafficProfile) slowdown() float64 {
+	if p.Slowdown <= 0 {
+		return 1
+	}
+	return p.Slowdown
+}
+
+// trafficShaper paces the queries of one upload after a profile
+type trafficShaper struct {
+	profile  TrafficProfile
+	rate     time.Duration // Rate limit: the mean gap before Slowdown
+	cover    float64       // Cover queries per chunk query
+	left     int           // Queries left in the current burst
+	covers   int           // Cover queries sent
+	note     func(format string, args ...interface{})
+	outHours bool // Already noted waiting for working hours
+}
+
+// newShaper starts pacing an upload
+func (uc *UploadClient) newShaper(msgID string) *trafficShaper {
+	cover := uc.traffic.CoverRatio
+	switch {
+	case uc.config.CoverRatio > 0:
+		cover = uc.config.CoverRatio
+	case uc.config.CoverRatio < 0:
+		cover = 0
+	}
+	return &trafficShaper{
+		profile: uc.traffic,
+		rate:    uc.config.RateLimit,
+		cover:   cover,
+		note:    func(format string, args ...interface{}) { uc.note(msgID, nil, format, args...) },
+	}
+}
+
+// wait pauses before the next query: a gap drawn from the profile, then,
+// outside the profile's hours, until they begin
+func (s *trafficShaper) wait(ctx context.Context) error {
+	if err := sleep(ctx, s.next()); err != nil {
+		return err
+	}
+	if s.profile.Hours == [2]int{} {
+		return nil
+	}
+
+	now := time.Now()
+	open := nextOpen(now, s.profile.Hours)
+	if !open.After(now) {
+		s.outHours = false
+		return nil
+	}
+	if !s.outHours {
+		s.note("   🕘 Outside %02d:00-%02d:00 on weekdays, pausing until %s",
+			s.profile.Hours[0], s.profile.Hours[1], open.Format("Mon 15:04"))
+		s.outHours = true
+	}
+	return sleep(ctx, open.Sub(now))
+}
+
+// next draws the gap before the next query
+func (s *trafficShaper) next() time.Duration {
+	p := s.profile
+	base := float64(s.rate) * p.slowdown()
+
+	if p.Burst[0] > 0 {
+		if s.left > 0 {
+			s.left--
+			return jitter(base/BURST_SPEEDUP, p.Jitter)
+		}
+		n := p.Burst[0] + rand.Intn(p.Burst[1]-p.Burst[0]+1)
+		s.left = n - 1
+		// The silence makes up for the burst's speed
+		return jitter(float64(n)*base*(1-1.0/BURST_SPEEDUP), p.Jitter)
+	}
+
+	gap := jitter(base, p.Jitter)
+	if p.Breaks > 0 && rand.Float64() < p.Breaks {
+		gap += time.Duration(base * (BREAK_MIN_GAPS + rand.Float64()*(BREAK_MAX_GAPS-BREAK_MIN_GAPS)))
+	}
+	return gap
+}
+
+// jitter varies d by up to a fraction either way
+func jitter(d, fraction float64) time.Duration {
+	if fraction <= 0 {
+		return time.Duration(d)
+	}
+	return time.Duration(d * (1 - fraction + 2*fraction*rand.Float64()))
+}
+
+// nextOpen returns now during hours on a weekday, else when they next begin
+func nextOpen(now time.Time, hours [2]int) time.Time {
+	for day := 0; day < 8; day++ {
+		d := now.AddDate(0, 0, day)
+		if d.Weekday() == time.Saturday || d.Weekday() == time.Sunday {
+			continue
+		}
+		open := time.Date(d.Year(), d.Month(), d.Day(), hours[0], 0, 0, 0, now.Location())
+		close := time.Date(d.Year(), d.Month(), d.Day(), hours[1], 0, 0, 0, now.Location())
+		if now.Before(open) {
+			return open
+		}
+		if now.Before(close) {
+			return now
+		}
+	}
+	return now
+}
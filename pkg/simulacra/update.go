@@ -0,0 +1,88 @@
+package simulacra
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/faanross/simulacra_txt/internal/chunker"
+	"github.com/faanross/simulacra_txt/internal/publisher"
+)
+
+// ================================================================================
+// DNS UPDATE UPLOAD
+// Publishes the message on any RFC 2136 capable authoritative server
+// ================================================================================
+
+// LESSON: Speaking the Server's Language
+// The HTTP and QNAME methods only work with our own DNS server. DNS UPDATE
+// is what BIND, Knot and friends already understand, so the chunks become
+// ordinary TXT records in a real zone and receivers query them as usual.
+// The server knows nothing about messages: no discovery, receipts, canary
+// or sharding - just records, with the manifest published last.
+
+// messageRecords builds the TXT records of a message, named as its
+// manifest says (keyed names with nameKey)
+func messageRecords(msgID, domain string, chunks []Chunk, manifest string, nameKey []byte, ttl chunker.TTLPolicy) ([]chunker.DNSRecord, error) {
+	names, err := chunker.ParseManifest(manifest, msgID, domain)
+	if err != nil {
+		return nil, fmt.Errorf("invalid manifest: %w", err)
+	}
+	if err := names.SetNameKey(nameKey); err != nil {
+		return nil, fmt.Errorf("%w (-names-key)", err)
+	}
+	if names.Sharded {
+		return nil, errors.New("sharded messages need the simulacra DNS server (drop -shard)")
+	}
+
+	records := make([]chunker.DNSRecord, 0, len(chunks)+1)
+	for i, chunk := range chunks {
+		records = append(records, chunker.DNSRecord{
+			Name:    names.ChunkName(i),
+			Type:    chunker.RECORD_TXT,
+			TTL:     ttl.ChunkTTL(),
+			Value:   chunk.Encoded,
+			Strings: chunker.SplitTXT(chunk.Encoded),
+		})
+	}
+	records = append(records, chunker.DNSRecord{
+		Name:    ManifestName(msgID, domain),
+		Type:    chunker.RECORD_TXT,
+		TTL:     ttl.ManifestTTL(),
+		Value:   manifest,
+		Strings: chunker.SplitTXT(manifest),
+	})
+	return records, nil
+}
+
+// uploadUpdate publishes the message with DNS UPDATE messages to the server
+func (uc *UploadClient) uploadUpdate(ctx context.Context, msgID string, chunks []Chunk, manifest string, result *UploadResult) error {
+	zone := uc.config.UpdateZone
+	if zone == "" {
+		zone = uc.config.Domain
+	}
+
+	uc.note(msgID, nil, "\n📤 UPLOADING MESSAGE: %s", msgID)
+	uc.note(msgID, nil, "   Chunks to upload: %d", len(chunks))
+	uc.note(msgID, nil, "   Server: %s (DNS UPDATE, zone %s)", uc.config.Server, zone)
+
+	records, err := messageRecords(msgID, uc.config.Domain, chunks, manifest, uc.config.NameKey, uc.config.UpdateTTL)
+	if err != nil {
+		return err
+	}
+
+	updater, err := publisher.New(publisher.BACKEND_RFC2136, publisher.Config{
+		Zone:     zone,
+		Endpoint: uc.config.Server,
+		TSIG:     uc.config.TSIGKey,
+	})
+	if err != nil {
+		return err
+	}
+	if err := updater.Publish(ctx, records); err != nil {
+		return err
+	}
+
+	result.Records = len(records)
+	uc.note(msgID, map[string]interface{}{"records": len(records)}, "   ✅ %d records published", len(records))
+	return nil
+}
@@ -0,0 +1,406 @@
+package simulacra
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/faanross/simulacra_txt/internal/canary"
+	"github.com/faanross/simulacra_txt/internal/chunker"
+	"github.com/faanross/simulacra_txt/internal/cover"
+	dnsserver "github.com/faanross/simulacra_txt/internal/dns-server"
+	"github.com/faanross/simulacra_txt/internal/fingerprint"
+	"github.com/faanross/simulacra_txt/internal/progress"
+	"github.com/miekg/dns"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// ================================================================================
+// DNS UPLOAD CLIENT - Sender side of covert channel
+// Uploads chunked messages to the DNS server
+// ================================================================================
+
+// RECEIPT_POLL_INTERVAL spaces the sender's receipt queries
+const RECEIPT_POLL_INTERVAL = 2 * time.Second
+
+// Upload methods
+const (
+	UPLOAD_HTTP   = "http"   // Whole message in one HTTP POST
+	UPLOAD_QNAME  = "qname"  // One TXT query per chunk, data in the query name
+	UPLOAD_UPDATE = "update" // RFC 2136 DNS UPDATE to any authoritative server
+)
+
+// Upload client defaults
+const (
+	DEFAULT_RATE_LIMIT  = 100 * time.Millisecond // 10 queries/sec
+	DEFAULT_MAX_RETRIES = 3
+)
+
+// UploadConfig configures an UploadClient. Only Server and Domain are required.
+type UploadConfig struct {
+	Server     string        // DNS server address, host:port
+	Domain     string        // Zone messages are published under
+	Method     string        // UPLOAD_QNAME (default), UPLOAD_HTTP or UPLOAD_UPDATE
+	RateLimit  time.Duration // Pause between queries (0 = DEFAULT_RATE_LIMIT)
+	MaxRetries int           // Retries of a failed query (0 = DEFAULT_MAX_RETRIES, -1 = none)
+	Stealth    bool          // Shuffled chunk order, timing jitter and cover traffic (TRAFFIC_STEADY)
+	Traffic    string        // TRAFFIC_* profile shaping UPLOAD_QNAME queries ("" = TRAFFIC_STEADY with Stealth, else TRAFFIC_NONE)
+	CoverRatio float64       // Cover queries per chunk query (0 = the profile's, -1 = none)
+	Cover      *CoverEngine  // Picks and times cover queries (nil = the built-in sites and mix)
+	Queries    *Queries      // Shapes DNS queries (nil = plain queries)
+	Reporter   Reporter      // Progress events and notes (nil = progress.Silent)
+
+	// Deadlines, on top of whatever the caller's context sets
+	QueryTimeout time.Duration // Wait for each DNS answer (0 = QUERY_TIMEOUT)
+	Timeout      time.Duration // Whole UploadMessage, retries included (0 = none)
+
+	// Query the message back after uploading it (see Verify)
+	VerifySample   int    // Chunks to verify besides the manifest (0 = no verification, -1 = all)
+	VerifyResolver string // Resolver to verify through, host:port ("" = Server)
+
+	// Message options the simulacra DNS server honours (not UPLOAD_UPDATE)
+	Tags       []string // Labels receivers can filter discovery by
+	Recipient  string   // Client ID that alone discovers the message ("" = any receiver)
+	Burn       bool     // Server deletes the message once it is fetched
+	Priority   string   // Discovery priority level ("" = normal)
+	Visibility string   // Redelivery timeout, e.g. "10m" ("" = server default)
+
+	// UPLOAD_QNAME
+	QNameKey []byte // Upload key signing every query (dns-server -qname-key; nil = unsigned)
+
+	// UPLOAD_HTTP
+	APIURL    string       // Base URL of the HTTP API (default: http://<server host>:8080)
+	APIClient *http.Client // nil = http.DefaultClient
+	APIKeyID  string       // Key signing the upload (dns-server -api-keys)
+	APISecret string       // Its secret, never sent
+
+	// UPLOAD_UPDATE
+	UpdateZone string    // Zone DNS UPDATEs are sent for ("" = Domain)
+	UpdateTTL  TTLPolicy // TTLs of published records (zero = chunker.DefaultTTLPolicy)
+	TSIGKey    string    // TSIG key signing DNS UPDATEs, [algorithm:]name:secret
+
+	NameKey []byte // Secret keying {word} and {sub:...} chunk names
+}
+
+// UploadClient handles covert uploads to DNS server
+type UploadClient struct {
+	config  UploadConfig
+	traffic TrafficProfile
+}
+
+// UploadResult reports how an upload went
+type UploadResult struct {
+	MessageID string
+	Method    string
+	Chunks    int  // Chunks uploaded
+	Queries   int  // Upload queries acknowledged (UPLOAD_QNAME)
+	Cover     int  // Cover queries sent alongside them (UPLOAD_QNAME)
+	Records   int  // Records published (UPLOAD_UPDATE)
+	Duplicate bool // The server already held an identical message; nothing was stored
+
+	Verification *Verification // How querying the message back went (nil = not verified)
+}
+
+// NewUploadClient creates an upload client, filling in defaults
+func NewUploadClient(config UploadConfig) (*UploadClient, error) {
+	if config.Server == "" || config.Domain == "" {
+		return nil, fmt.Errorf("an upload client needs a server and a domain")
+	}
+	switch config.Method {
+	case "":
+		config.Method = UPLOAD_QNAME
+	case UPLOAD_HTTP, UPLOAD_QNAME, UPLOAD_UPDATE:
+	default:
+		return nil, fmt.Errorf("unknown upload method %q (use %s, %s or %s)", config.Method, UPLOAD_HTTP, UPLOAD_QNAME, UPLOAD_UPDATE)
+	}
+	if config.APIURL != "" && !strings.HasPrefix(config.APIURL, "http://") && !strings.HasPrefix(config.APIURL, "https://") {
+		return nil, fmt.Errorf("API URL %q must start with http:// or https://", config.APIURL)
+	}
+
+	if config.VerifySample != 0 && config.Burn {
+		// Verification queries are fetches: they would burn the message
+		return nil, fmt.Errorf("burn-after-reading messages can't be verified")
+	}
+
+	if config.RateLimit <= 0 {
+		config.RateLimit = DEFAULT_RATE_LIMIT
+	}
+	if config.Traffic == "" {
+		config.Traffic = TRAFFIC_NONE
+		if config.Stealth {
+			config.Traffic = TRAFFIC_STEADY
+		}
+	}
+	traffic, err := LookupTrafficProfile(config.Traffic)
+	if err != nil {
+		return nil, err
+	}
+	if config.QueryTimeout <= 0 {
+		config.QueryTimeout = QUERY_TIMEOUT
+	}
+	switch {
+	case config.MaxRetries == 0:
+		config.MaxRetries = DEFAULT_MAX_RETRIES
+	case config.MaxRetries < 0:
+		config.MaxRetries = 0
+	}
+	if config.Queries == nil {
+		config.Queries = fingerprint.NewRandomizer(fingerprint.PROFILE_NONE)
+	}
+	if config.Reporter == nil {
+		config.Reporter = progress.Silent
+	}
+	if config.UpdateTTL == (TTLPolicy{}) {
+		config.UpdateTTL = chunker.DefaultTTLPolicy()
+	}
+	if config.APIClient == nil {
+		config.APIClient = http.DefaultClient
+	}
+	if config.Cover == nil {
+		config.Cover = cover.Default()
+	}
+
+	return &UploadClient{config: config, traffic: traffic}, nil
+}
+
+// Method returns the upload method in use
+func (uc *UploadClient) Method() string {
+	return uc.config.Method
+}
+
+// RateLimit returns the pause between queries
+func (uc *UploadClient) RateLimit() time.Duration {
+	return uc.config.RateLimit
+}
+
+// Traffic returns the traffic profile shaping upload queries
+func (uc *UploadClient) Traffic() TrafficProfile {
+	return uc.traffic
+}
+
+// EstimateUpload returns roughly how long sending chunks takes at the
+// rate limit under the traffic profile, cover queries included - not
+// counting time spent outside the profile's hours
+func (uc *UploadClient) EstimateUpload(chunks int) time.Duration {
+	cover := uc.newShaper("").cover
+	queries := float64(chunks+1) * (1 + cover)
+	return time.Duration(queries * float64(uc.traffic.MeanGap(uc.config.RateLimit)))
+}
+
+// note reports a human-readable line about an upload
+func (uc *UploadClient) note(msgID string, fields map[string]interface{}, format string, args ...interface{}) {
+	progress.Note(uc.config.Reporter, progress.STAGE_UPLOAD, msgID, fields, format, args...)
+}
+
+// UploadMessage uploads a complete message to DNS server, via query
+// names, HTTP or DNS UPDATE depending on the client's method, then with
+// a VerifySample queries it back. It gives up when ctx is done or the
+// config's Timeout passes. When only the verification fails, the result
+// is returned with the error.
+func (uc *UploadClient) UploadMessage(ctx context.Context, msgID string, chunks []Chunk, manifest string) (*UploadResult, error) {
+	if uc.config.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, uc.config.Timeout)
+		defer cancel()
+	}
+
+	task := progress.Begin(uc.config.Reporter, progress.STAGE_UPLOAD, msgID, len(chunks))
+	result := &UploadResult{MessageID: msgID, Method: uc.config.Method, Chunks: len(chunks)}
+
+	var err error
+	switch uc.config.Method {
+	case UPLOAD_HTTP:
+		err = uc.uploadHTTP(ctx, msgID, chunks, manifest, result)
+	case UPLOAD_UPDATE:
+		err = uc.uploadUpdate(ctx, msgID, chunks, manifest, result)
+	default:
+		err = uc.uploadQName(ctx, msgID, chunks, manifest, task, result)
+	}
+	if err == nil {
+		task.Update(len(chunks))
+	}
+	task.Finish(err)
+	if err != nil {
+		return nil, err
+	}
+
+	if uc.config.VerifySample != 0 {
+		if result.Verification, err = uc.Verify(ctx, msgID, chunks, manifest); err != nil {
+			return result, fmt.Errorf("upload not verified: %w", err)
+		}
+	}
+	return result, nil
+}
+
+// UploadChunkSet uploads a chunked message with its manifest
+func (uc *UploadClient) UploadChunkSet(ctx context.Context, set *ChunkSet) (*UploadResult, error) {
+	return uc.UploadMessage(ctx, set.MessageID(), set.Message.Chunks, set.Manifest)
+}
+
+// uploadHTTP posts the whole message to the server's upload endpoint
+func (uc *UploadClient) uploadHTTP(ctx context.Context, msgID string, chunks []Chunk, manifest string, result *UploadResult) error {
+	totalChunks := len(chunks)
+
+	uc.note(msgID, nil, "\n📤 UPLOADING MESSAGE: %s", msgID)
+	uc.note(msgID, nil, "   Chunks to upload: %d", totalChunks)
+	uc.note(msgID, nil, "   Server: %s", uc.config.Server)
+
+	// Chunk names come from the manifest, so receivers can find them
+	// whatever naming template was used
+	names, err := chunker.ParseManifest(manifest, msgID, uc.config.Domain)
+	if err != nil {
+		return fmt.Errorf("invalid manifest: %w", err)
+	}
+	if err := names.SetNameKey(uc.config.NameKey); err != nil {
+		return fmt.Errorf("%w (-names-key)", err)
+	}
+
+	// Prepare chunks map
+	chunkMap := make(map[string]string)
+	for i, chunk := range chunks {
+		chunkMap[names.ChunkName(i)] = chunk.Encoded
+	}
+
+	// Add manifest
+	chunkMap[ManifestName(msgID, uc.config.Domain)] = manifest
+
+	// Create upload request
+	uploadReq := struct {
+		MessageID  string            `json:"message_id"`
+		Chunks     map[string]string `json:"chunks"`
+		Manifest   string            `json:"manifest"`
+		Tags       []string          `json:"tags,omitempty"`
+		Recipient  string            `json:"recipient,omitempty"`
+		Burn       bool              `json:"burn,omitempty"`
+		Priority   string            `json:"priority,omitempty"`
+		Visibility string            `json:"visibility,omitempty"`
+	}{
+		MessageID:  msgID,
+		Chunks:     chunkMap,
+		Manifest:   manifest,
+		Tags:       uc.config.Tags,
+		Recipient:  uc.config.Recipient,
+		Burn:       uc.config.Burn,
+		Priority:   uc.config.Priority,
+		Visibility: uc.config.Visibility,
+	}
+
+	// Convert to JSON
+	jsonData, err := json.Marshal(uploadReq)
+	if err != nil {
+		return fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	base := uc.config.APIURL
+	if base == "" {
+		// Extract host from DNS server address (remove port)
+		serverHost := strings.Split(uc.config.Server, ":")[0]
+		base = fmt.Sprintf("http://%s:8080", serverHost)
+	}
+	// Servers with several zones file the message under ours
+	httpURL := strings.TrimSuffix(base, "/") + "/upload?zone=" + url.QueryEscape(uc.config.Domain)
+
+	uc.note(msgID, nil, "   Uploading to: %s", httpURL)
+
+	// Send HTTP POST request
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, httpURL, bytes.NewReader(jsonData))
+	if err != nil {
+		return fmt.Errorf("HTTP upload failed: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if uc.config.APIKeyID != "" {
+		dnsserver.SignRequest(req, uc.config.APIKeyID, uc.config.APISecret, jsonData)
+	}
+	resp, err := uc.config.APIClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("HTTP upload failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+		var refusal dnsserver.AuthError
+		if json.NewDecoder(resp.Body).Decode(&refusal) == nil && refusal.Message != "" {
+			return fmt.Errorf("server refused the upload: %s (-api-key)", refusal.Message)
+		}
+		return fmt.Errorf("server refused the upload: %s (-api-key)", resp.Status)
+	}
+	if resp.StatusCode == http.StatusConflict {
+		reason, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("server rejected message ID: %s", strings.TrimSpace(string(reason)))
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("server returned status: %s", resp.Status)
+	}
+
+	// Parse response
+	var reply map[string]string
+	if err := json.NewDecoder(resp.Body).Decode(&reply); err != nil {
+		return fmt.Errorf("failed to parse response: %w", err)
+	}
+
+	if reply["status"] == "duplicate" {
+		result.Duplicate = true
+		uc.note(msgID, map[string]interface{}{"duplicate": true}, "\n♻️  Identical message already on server, nothing stored")
+		uc.note(msgID, nil, "   Message ID: %s", reply["message_id"])
+		return nil
+	}
+
+	uc.note(msgID, nil, "\n✅ Upload successful!")
+	uc.note(msgID, nil, "   Message ID: %s", reply["message_id"])
+	uc.note(msgID, map[string]interface{}{"chunks": reply["chunks"]}, "   Chunks uploaded: %s", reply["chunks"])
+
+	return nil
+}
+
+// CheckServer probes the server's canary, failing with a diagnosis when
+// the server can't take an upload
+func (uc *UploadClient) CheckServer(ctx context.Context) (*Canary, error) {
+	report, err := canary.Probe(ctx, uc.config.Queries, uc.config.Server, uc.config.Domain, uc.config.QueryTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	progress.Note(uc.config.Reporter, progress.STAGE_UPLOAD, "", nil, "🐤 Server canary: %s", canary.Describe(report))
+	if report.Status != chunker.CANARY_OK {
+		progress.Note(uc.config.Reporter, progress.STAGE_UPLOAD, "", nil, "   ⚠️  Server reports it is busy, expect slow or retried uploads")
+	}
+	return report, nil
+}
+
+// AwaitReceipt polls for the receiver's signed receipt until one verifies
+// or ctx is done - give it a deadline. Receipts that fail verification are
+// ignored.
+func (uc *UploadClient) AwaitReceipt(ctx context.Context, msgID string, key []byte) (*Receipt, error) {
+	name := dns.Fqdn(chunker.ReceiptName(msgID, uc.config.Domain))
+	warned := false
+
+	for {
+		m := new(dns.Msg)
+		m.SetQuestion(name, dns.TypeTXT)
+
+		if resp, err := uc.config.Queries.Exchange(ctx, m, uc.config.Server, 0, uc.config.QueryTimeout); err == nil {
+			for _, ans := range resp.Answer {
+				txt, ok := ans.(*dns.TXT)
+				if !ok || len(txt.Txt) == 0 {
+					continue
+				}
+				receipt, err := chunker.ParseReceipt(chunker.JoinTXT(txt.Txt), msgID, key)
+				if err == nil {
+					return receipt, nil
+				}
+				if !warned {
+					uc.note(msgID, nil, "   ⚠️  Ignoring receipt: %v", err)
+					warned = true
+				}
+			}
+		}
+
+		if err := sleep(ctx, RECEIPT_POLL_INTERVAL); err != nil {
+			return nil, fmt.Errorf("no valid receipt: %w", err)
+		}
+	}
+}
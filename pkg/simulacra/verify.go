@@ -0,0 +1,172 @@
+package simulacra
+
+import (
+	"context"
+	"fmt"
+	"github.com/faanross/simulacra_txt/internal/chunker"
+	"github.com/faanross/simulacra_txt/internal/progress"
+	"math/rand"
+	"sort"
+	"time"
+)
+
+// ================================================================================
+// UPLOAD VERIFICATION
+// Queries a message back after publishing it, the way a receiver will
+// ================================================================================
+
+// LESSON: Accepted Is Not Servable
+// The server acknowledging an upload says the records are stored. Whether
+// a receiver can get them is a different question: a resolver in between
+// may refuse the zone, strip long TXT answers, or not see a secondary that
+// hasn't caught up with a DNS UPDATE yet. So after publishing, the sender
+// can look itself up - the manifest and a random sample of the chunks (or
+// all of them), through the resolver its receivers will use - and compare
+// each answer with what it published. Answers are compared as raw chunk
+// bytes, so a server that re-encodes chunks still matches.
+//
+// Verification queries count as fetches on the simulacra server, which is
+// why burn-after-reading messages can't be verified: the sender would
+// burn its own message.
+
+// ERROR_MISMATCH classifies a chunk that answered with other data than was published
+const ERROR_MISMATCH = "mismatch"
+
+// Verification is the outcome of querying an upload back
+type Verification struct {
+	Resolver string
+	Manifest error        // nil = the manifest is servable and matches
+	Sampled  int          // Chunks queried
+	Servable []int        // Chunks that answered with what was published
+	Missing  []ChunkError // Chunks that didn't, with why
+	Duration time.Duration
+}
+
+// OK reports whether the manifest and every sampled chunk are servable
+func (v *Verification) OK() bool {
+	return v.Manifest == nil && len(v.Missing) == 0
+}
+
+// Verify queries a message's manifest and a sample of its chunks back
+// through the VerifyResolver (or Server), reporting which are servable.
+// It fails when any isn't.
+func (uc *UploadClient) Verify(ctx context.Context, msgID string, chunks []Chunk, manifest string) (*Verification, error) {
+	resolver := uc.config.VerifyResolver
+	if resolver == "" {
+		resolver = uc.config.Server
+	}
+	v := &Verification{Resolver: resolver}
+	start := time.Now()
+	defer func() { v.Duration = time.Since(start) }()
+
+	names, err := chunker.ParseManifest(manifest, msgID, uc.config.Domain)
+	if err != nil {
+		return v, fmt.Errorf("invalid manifest: %w", err)
+	}
+	if err := names.SetNameKey(uc.config.NameKey); err != nil {
+		return v, fmt.Errorf("%w (-names-key)", err)
+	}
+
+	// A receiver of our own, quiet: the notes here are the sender's
+	receiver, err := NewReceiver(ReceiverConfig{
+		Server:       resolver,
+		Domain:       uc.config.Domain,
+		MaxRetries:   uc.config.MaxRetries,
+		NameKey:      uc.config.NameKey,
+		Queries:      uc.config.Queries,
+		QueryTimeout: uc.config.QueryTimeout,
+		Reporter:     progress.Silent,
+	})
+	if err != nil {
+		return v, err
+	}
+	for _, chunk := range chunks {
+		if len(chunk.Encoded) > receiver.caps.ChunkSize {
+			receiver.caps.ChunkSize = len(chunk.Encoded)
+		}
+	}
+
+	sample := verifySample(len(chunks), uc.config.VerifySample)
+	v.Sampled = len(sample)
+	uc.note(msgID, nil, "\n🔎 Verifying through %s: manifest and %d of %d chunks", resolver, len(sample), len(chunks))
+
+	// The manifest first: without it no receiver gets anywhere
+	_, v.Manifest = retry(ctx, uc.config.MaxRetries, ExponentialBackoff(CHUNK_BACKOFF_BASE, CHUNK_BACKOFF_MAX), func() error {
+		published, err := receiver.fetchManifest(ctx, msgID)
+		if err == nil && (published.TotalChunks != names.TotalChunks || published.Checksum != names.Checksum) {
+			return permanent(fmt.Errorf("manifest answered for %d chunks (checksum %s), published for %d (checksum %s)",
+				published.TotalChunks, published.Checksum, names.TotalChunks, names.Checksum))
+		}
+		return err
+	})
+	if ctx.Err() != nil {
+		return v, ctx.Err()
+	}
+	if v.Manifest != nil {
+		uc.note(msgID, nil, "   ❌ Manifest: %v", v.Manifest)
+	} else {
+		uc.note(msgID, nil, "   ✅ Manifest servable")
+	}
+
+	task := progress.Begin(uc.config.Reporter, progress.STAGE_UPLOAD, msgID, len(sample))
+	for n, i := range sample {
+		if n > 0 {
+			if err := sleep(ctx, uc.config.RateLimit); err != nil {
+				task.Finish(err)
+				return v, err
+			}
+		}
+
+		value, chunkErr, err := receiver.fetchChunkWithRetry(ctx, names.ChunkName(i), names)
+		if ctx.Err() != nil {
+			task.Finish(ctx.Err())
+			return v, ctx.Err()
+		}
+		if err == nil && !sameChunk(value, chunks[i].Encoded) {
+			chunkErr.Class, chunkErr.Error = ERROR_MISMATCH, "answer differs from the published chunk"
+			err = fmt.Errorf("mismatch")
+		}
+		if err != nil {
+			chunkErr.Chunk = i
+			v.Missing = append(v.Missing, chunkErr)
+			uc.note(msgID, nil, "\n   ❌ Chunk %d: %s (%s)", i, chunkErr.Class, chunkErr.Error)
+		} else {
+			v.Servable = append(v.Servable, i)
+		}
+		task.Update(n + 1)
+	}
+	task.Finish(nil)
+
+	fields := map[string]interface{}{"sampled": len(sample), "servable": len(v.Servable), "resolver": resolver}
+	if !v.OK() {
+		uc.note(msgID, fields, "   ⚠️  %d/%d sampled chunks servable", len(v.Servable), len(sample))
+		if v.Manifest != nil {
+			return v, fmt.Errorf("manifest not servable through %s: %w", resolver, v.Manifest)
+		}
+		return v, fmt.Errorf("%d of %d sampled chunks not servable through %s", len(v.Missing), len(sample), resolver)
+	}
+	uc.note(msgID, fields, "   ✅ %d/%d sampled chunks servable", len(v.Servable), len(sample))
+	return v, nil
+}
+
+// verifySample picks the chunks to verify: n at random (in order), or
+// all of them when n is negative or covers them all
+func verifySample(total, n int) []int {
+	if n < 0 || n >= total {
+		n = total
+	}
+	sample := rand.Perm(total)[:n]
+	sort.Ints(sample)
+	return sample
+}
+
+// sameChunk compares an answered chunk value with the published one by
+// their raw bytes, whatever encoding each is in
+func sameChunk(answered, published string) bool {
+	if answered == published {
+		return true
+	}
+	a, errA := chunker.ChunkSetDigest([]string{answered})
+	b, errB := chunker.ChunkSetDigest([]string{published})
+	return errA == nil && errB == nil && a == b
+}